@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The KV prefix acknowledgements are stored under
+const ackKVPath = alertingKVRoot + "/acks/"
+
+// An acknowledgement of a failing alert, which stops its reminders and escalations until
+// it recovers
+type alertAck struct {
+	By      string    `json:"by"`
+	Comment string    `json:"comment,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// The acknowledgements currently stored in the KV store, keyed by KV path
+type ackRegistry struct {
+	sync.Mutex
+	acks map[string]alertAck
+}
+
+var alertAcks = &ackRegistry{
+	acks: make(map[string]alertAck),
+}
+
+// Returns the KV path of the acknowledgement of an alert. Empty parts of the key are
+// replaced with "_" so paths stay unambiguous.
+func ackPath(datacenter string, alert *AlertState) string {
+	parts := []string{datacenter, alert.scopedService(), alert.Tag, alert.scopedNode(), alert.Check}
+	for i, part := range parts {
+		if part == "" {
+			parts[i] = "_"
+		}
+	}
+	return ackKVPath + strings.Join(parts, "/")
+}
+
+// Watches the acknowledgements in the KV store, replacing the known ones whenever they change
+func watchAcks(client *api.Client) {
+	watchKVPrefix(client, ackKVPath, func(pairs api.KVPairs) {
+		acks := make(map[string]alertAck)
+		for _, pair := range pairs {
+			var ack alertAck
+			if err := json.Unmarshal(pair.Value, &ack); err != nil || ack.By == "" {
+				log.Errorf("Invalid acknowledgement at %s, expected {\"by\": \"<name>\"}", pair.Key)
+				continue
+			}
+			acks[pair.Key] = ack
+		}
+		alertAcks.replace(acks)
+	})
+}
+
+func (r *ackRegistry) replace(acks map[string]alertAck) {
+	r.Lock()
+	defer r.Unlock()
+	r.acks = acks
+}
+
+// Returns the acknowledgement of an alert, if it has one
+func (r *ackRegistry) acked(datacenter string, alert *AlertState) (alertAck, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	ack, ok := r.acks[ackPath(datacenter, alert)]
+	return ack, ok
+}
+
+// Stores an acknowledgement of an alert in the KV store
+func acknowledge(client *api.Client, datacenter string, alert *AlertState, ack alertAck) error {
+	if ack.At.IsZero() {
+		ack.At = time.Now().UTC()
+	}
+	value, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.KV().Put(&api.KVPair{Key: ackPath(datacenter, alert), Value: value}, nil)
+	return err
+}
+
+// Removes the acknowledgement of a recovered alert, so its next failure isn't acknowledged.
+// Dry runs leave that to the live instances.
+func clearAck(client *api.Client, datacenter string, alert *AlertState) {
+	if _, ok := alertAcks.acked(datacenter, alert); !ok || client == nil || isDryRun() {
+		return
+	}
+
+	if _, err := client.KV().Delete(ackPath(datacenter, alert), nil); err != nil {
+		log.Errorf("Error clearing acknowledgement of alert '%s': %s", alert.Message, err)
+	}
+}
+
+// Adds who acknowledged an alert to it, if anyone did
+func annotateAck(datacenter string, alert *AlertState) {
+	ack, ok := alertAcks.acked(datacenter, alert)
+	if !ok {
+		return
+	}
+
+	alert.AckedBy = ack.By
+	line := "Acknowledged by " + ack.By
+	if ack.Comment != "" {
+		line += ": " + ack.Comment
+	}
+	if alert.Details != "" {
+		line = alert.Details + "\n" + line
+	}
+	alert.Details = line
+}
+
+// The body of an acknowledgement request
+type ackRequest struct {
+	Datacenter string `json:"datacenter"`
+	Service    string `json:"service"`
+	Namespace  string `json:"namespace"`
+	Partition  string `json:"partition"`
+	Tag        string `json:"tag"`
+	Node       string `json:"node"`
+	Check      string `json:"check"`
+	By         string `json:"by"`
+	Comment    string `json:"comment"`
+}
+
+// Handles PUT requests acknowledging an alert, and DELETE requests removing an acknowledgement
+func ackHandler(config *Config, client *api.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ackRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+			return
+		}
+		if req.Datacenter == "" {
+			req.Datacenter = latestConfig(config).ConsulDatacenter
+		}
+		alert := &AlertState{Service: req.Service, Namespace: req.Namespace, Partition: req.Partition, Tag: req.Tag, Node: req.Node, Check: req.Check}
+
+		var err error
+		switch r.Method {
+		case "PUT", "POST":
+			if req.By == "" {
+				http.Error(w, "by must be set", http.StatusBadRequest)
+				return
+			}
+			err = acknowledge(client, req.Datacenter, alert, alertAck{By: req.By, Comment: req.Comment})
+		case "DELETE":
+			_, err = client.KV().Delete(ackPath(req.Datacenter, alert), nil)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAck_handler(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	handler := ackHandler(&Config{ConsulDatacenter: "dc1"}, client)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("PUT", "/v1/ack", strings.NewReader(`{"service": "redis", "by": "alice", "comment": "failing over"}`)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	path := "service/consul-alerting/acks/dc1/redis/_/_/_"
+	var ack alertAck
+	if err := json.Unmarshal(values[path], &ack); err != nil {
+		t.Fatalf("expected an acknowledgement at %s: %s", path, err)
+	}
+	if ack.By != "alice" || ack.Comment != "failing over" || ack.At.IsZero() {
+		t.Errorf("unexpected acknowledgement: %+v", ack)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("PUT", "/v1/ack", strings.NewReader(`{"service": "redis"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without by, got %d", w.Code)
+	}
+}
+
+func TestAck_stopsReminders(t *testing.T) {
+	alert := &AlertState{Service: "redis", Status: "critical", Message: "redis is critical", Details: "check failing"}
+
+	alertAcks.replace(map[string]alertAck{ackPath("dc1", alert): alertAck{By: "alice", Comment: "on it"}})
+	defer alertAcks.replace(make(map[string]alertAck))
+
+	annotated := *alert
+	annotateAck("dc1", &annotated)
+	if annotated.AckedBy != "alice" || annotated.Details != "check failing\nAcknowledged by alice: on it" {
+		t.Errorf("unexpected annotated alert: %+v", annotated)
+	}
+
+	alertCh := make(chan *AlertState, 10)
+	repeats := &alertRepeats{repeats: make(map[string]*alertRepeat)}
+	repeats.track(namedHandler{name: "test", handler: testHandler{alertCh}}, "dc1", alert, 20*time.Millisecond)
+	defer repeats.track(namedHandler{name: "test", handler: testHandler{alertCh}}, "dc1", &AlertState{Service: "redis", Status: "passing"}, 0)
+
+	time.Sleep(100 * time.Millisecond)
+	if len(alertCh) != 0 {
+		t.Errorf("expected no reminders for an acknowledged alert, got %d", len(alertCh))
+	}
+}
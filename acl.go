@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The key written and deleted on startup to check that the token can keep alert state
+const aclCheckKey = alertingKVRoot + "/acl-check"
+
+// Checks that the Consul token has the permissions needed to watch health and keep alert
+// state. Writing keys under the alerting prefix and creating sessions for the locks are
+// required, so an error is returned without them. Catalog reads without node or service
+// read are filtered instead of denied, so they're only returned as warnings.
+func checkConsulPermissions(client *api.Client, nodeName string) ([]string, error) {
+	if _, err := client.KV().Put(&api.KVPair{Key: aclCheckKey, Value: []byte("ok")}, nil); err != nil {
+		return nil, fmt.Errorf("The Consul token can't write keys under %s/, which is needed to keep alert state (key_prefix \"%s/\" { policy = \"write\" }): %s", alertingKVRoot, alertingKVRoot, err)
+	}
+	if _, err := client.KV().Delete(aclCheckKey, nil); err != nil {
+		return nil, fmt.Errorf("The Consul token can't delete keys under %s/: %s", alertingKVRoot, err)
+	}
+
+	session, _, err := client.Session().Create(&api.SessionEntry{Name: "consul-alerting ACL check", TTL: "10s"}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("The Consul token can't create sessions, which are needed for the watch locks (session_prefix \"\" { policy = \"write\" }): %s", err)
+	}
+	client.Session().Destroy(session, nil)
+
+	return checkCatalogPermissions(client, nodeName, nil)
+}
+
+// Checks that the token the catalog is queried with can read the given node, if any, and
+// services, returning warnings for the ones it can't
+func checkCatalogPermissions(client *api.Client, nodeName string, queryOpts *api.QueryOptions) ([]string, error) {
+	var warnings []string
+	if nodeName != "" {
+		if node, _, err := client.Catalog().Node(nodeName, queryOpts); err != nil {
+			return nil, fmt.Errorf("Error reading node %s from the catalog: %s", nodeName, err)
+		} else if node == nil {
+			warnings = append(warnings, fmt.Sprintf("The Consul token can't read the local node %s, so node health won't be watched (node_prefix \"\" { policy = \"read\" })", nodeName))
+		}
+	}
+
+	if services, _, err := client.Catalog().Services(queryOpts); err != nil {
+		return nil, fmt.Errorf("Error reading services from the catalog: %s", err)
+	} else if len(services) == 0 {
+		warnings = append(warnings, "The Consul token can't read any services, so no services will be watched (service_prefix \"\" { policy = \"read\" })")
+	}
+
+	return warnings, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Starts a fake Consul agent for the permission checks, denying KV writes when readOnly is set
+// and filtering out every service
+func testACLServer(t *testing.T, readOnly bool) (*api.Client, map[string]string, func()) {
+	tokens := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens[r.URL.Path+"?dc="+r.URL.Query().Get("dc")] = r.URL.Query().Get("token")
+		switch {
+		case r.URL.Path == "/v1/kv/"+aclCheckKey:
+			if readOnly {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("Permission denied"))
+				return
+			}
+			w.Write([]byte(`true`))
+		case r.URL.Path == "/v1/session/create":
+			w.Write([]byte(`{"ID": "session1"}`))
+		case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+			w.Write([]byte(`true`))
+		case r.URL.Path == "/v1/catalog/node/node1":
+			w.Write([]byte(`{"Node": {"Node": "node1"}, "Services": {}}`))
+		case r.URL.Path == "/v1/catalog/services":
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	config := api.DefaultConfig()
+	config.Address = strings.TrimPrefix(server.URL, "http://")
+	config.Token = "default"
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, tokens, server.Close
+}
+
+func TestACL_checkPermissions(t *testing.T) {
+	client, _, stop := testACLServer(t, false)
+	defer stop()
+
+	warnings, err := checkConsulPermissions(client, "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "can't read any services") {
+		t.Errorf("expected a warning for the filtered services, got %v", warnings)
+	}
+}
+
+func TestACL_checkPermissionsDenied(t *testing.T) {
+	client, _, stop := testACLServer(t, true)
+	defer stop()
+
+	_, err := checkConsulPermissions(client, "node1")
+	if err == nil || !strings.Contains(err.Error(), `key_prefix "service/consul-alerting/" { policy = "write" }`) {
+		t.Errorf("expected an error naming the missing KV policy, got %v", err)
+	}
+}
+
+func TestACL_datacenterTokens(t *testing.T) {
+	config, err := ParseConfig(`
+datacenters = ["dc1", "dc2"]
+datacenter_tokens {
+  dc2 = "dc2-token"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DatacenterTokens["dc2"] != "dc2-token" {
+		t.Errorf("expected the token of dc2, got %v", config.DatacenterTokens)
+	}
+
+	// The datacenter's token is used for its catalog queries, and the default one otherwise
+	client, tokens, stop := testACLServer(t, false)
+	defer stop()
+	for _, datacenter := range []string{"dc1", "dc2"} {
+		if _, err := checkCatalogPermissions(client, "", config.forDatacenter(datacenter, "dc1").queryOptions()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tokens["/v1/catalog/services?dc=dc2"] != "dc2-token" || tokens["/v1/catalog/services?dc="] != "default" {
+		t.Errorf("expected dc2 to be queried with its own token, got %v", tokens)
+	}
+
+	if _, err := ParseConfig(`datacenter_tokens { dc2 = "dc2-token" }`); err == nil || !strings.Contains(err.Error(), "only be used with datacenters") {
+		t.Errorf("expected an error for datacenter_tokens without datacenters, got %v", err)
+	}
+	if _, err := ParseConfig(`
+datacenters = ["dc1"]
+datacenter_tokens { dc2 = "dc2-token" }`); err == nil || !strings.Contains(err.Error(), "isn't in datacenters") {
+		t.Errorf("expected an error for a token of an unwatched datacenter, got %v", err)
+	}
+}
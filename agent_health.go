@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The name of the pseudo-service used for alerting on the serf membership of the agents
+// in the datacenter. A service block with this name can be used to configure its handlers
+// and thresholds.
+const agentHealthService = "consul-agents"
+
+// The serf statuses of members that left the cluster, as returned by /v1/agent/members
+const (
+	serfLeaving = 2
+	serfLeft    = 3
+)
+
+// Fetches the members of the LAN pool, the agents in the datacenter of consul_address
+func getLANMembers(client *api.Client) ([]*api.AgentMember, error) {
+	members, err := client.Agent().Members(false)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching LAN members: %s", err)
+	}
+	return members, nil
+}
+
+// Converts the LAN pool members into health checks, so they can be watched the same way
+// as a regular service. Each agent gets a "serf" check on its node, which is critical when
+// it has failed and has the agent_left_status once it has left. Agents matching
+// agent_health_ignore are left out.
+func agentHealthChecks(config *Config, members []*api.AgentMember) []*api.HealthCheck {
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+
+	checks := make([]*api.HealthCheck, 0, len(members))
+	for _, member := range members {
+		if matchesAny(config.agentHealthIgnore, member.Name) {
+			continue
+		}
+
+		role := "client"
+		if member.Tags["role"] == "consul" {
+			role = "server"
+		}
+
+		status := api.HealthPassing
+		switch member.Status {
+		case serfAlive:
+		case serfLeaving, serfLeft:
+			status = config.AgentLeftStatus
+		default:
+			status = api.HealthCritical
+		}
+
+		output := fmt.Sprintf("Agent %s (%s, %s) is %s in the LAN pool", member.Name, member.Addr, role, serfStatuses[member.Status])
+		checks = append(checks, &api.HealthCheck{
+			Node:        member.Name,
+			CheckID:     "serf",
+			Name:        "serf",
+			Status:      status,
+			Output:      output,
+			ServiceID:   agentHealthService,
+			ServiceName: agentHealthService,
+		})
+	}
+	return checks
+}
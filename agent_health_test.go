@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestAgentHealth_checks(t *testing.T) {
+	config, err := ParseConfig(`agent_health_ignore = ["asg-.*"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members := []*api.AgentMember{
+		{Name: "server1", Addr: "10.0.0.1", Status: 1, Tags: map[string]string{"role": "consul"}},
+		{Name: "client1", Addr: "10.0.0.2", Status: 1, Tags: map[string]string{"role": "node"}},
+		{Name: "client2", Addr: "10.0.0.3", Status: 4, Tags: map[string]string{"role": "node"}},
+		{Name: "client3", Addr: "10.0.0.4", Status: 3, Tags: map[string]string{"role": "node"}},
+		{Name: "asg-1", Addr: "10.0.0.5", Status: 4, Tags: map[string]string{"role": "node"}},
+	}
+
+	statuses := make(map[string]string)
+	for _, check := range agentHealthChecks(config, members) {
+		if check.ServiceName != agentHealthService || check.CheckID != "serf" {
+			t.Errorf("expected a serf check of %s, got %+v", agentHealthService, check)
+		}
+		statuses[check.Node] = check.Status
+		if check.Node == "client2" && check.Output != "Agent client2 (10.0.0.3, client) is failed in the LAN pool" {
+			t.Errorf("unexpected output: %s", check.Output)
+		}
+	}
+
+	expected := map[string]string{
+		"server1": api.HealthPassing,
+		"client1": api.HealthPassing,
+		"client2": api.HealthCritical,
+		"client3": api.HealthWarning,
+	}
+	if len(statuses) != len(expected) {
+		t.Errorf("expected checks for %v, got %v", expected, statuses)
+	}
+	for node, status := range expected {
+		if statuses[node] != status {
+			t.Errorf("expected %s to be %s, got %s", node, status, statuses[node])
+		}
+	}
+
+	if _, err := ParseConfig(`agent_left_status = "down"`); err == nil || !strings.Contains(err.Error(), "agent_left_status") {
+		t.Errorf("expected an error for an invalid agent_left_status, got %v", err)
+	}
+}
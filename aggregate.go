@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The path (relative to a watch's KV prefix) used for storing the alert states of
+// groups smaller than the whole watch, when aggregating by node or by check
+const alertGroupsPath = "alerts/"
+
+// Returns the group a check belongs to for the given aggregation level. Checks are
+// identified by their node/checkID hash. An empty group means the whole watch.
+func aggregationGroup(mode string, aggregation string, checkHash string) string {
+	switch aggregation {
+	case AggregateNone:
+		return checkHash
+	case AggregateNode:
+		// A node watch only ever covers a single node, so it is already grouped by node
+		if mode == ServiceWatch {
+			return strings.SplitN(checkHash, "/", 2)[0]
+		}
+	}
+
+	return ""
+}
+
+// Returns the group of a check like aggregationGroup, except that the checks with their
+// own check block are always in a group of their own
+func checkGroup(mode string, aggregation string, checkHash string, separate map[string]*CheckConfig) string {
+	if _, ok := separate[checkHash]; ok {
+		return checkHash
+	}
+	return aggregationGroup(mode, aggregation, checkHash)
+}
+
+// Splits a map of node/checkID:statuses into a map of group:(node/checkID:statuses)
+func groupCheckStatuses(mode string, aggregation string, statuses map[string]string, separate map[string]*CheckConfig) map[string]map[string]string {
+	groups := make(map[string]map[string]string)
+
+	for checkHash, status := range statuses {
+		group := checkGroup(mode, aggregation, checkHash, separate)
+		if _, ok := groups[group]; !ok {
+			groups[group] = make(map[string]string)
+		}
+		groups[group][checkHash] = status
+	}
+
+	return groups
+}
+
+// Returns the health checks that belong to the given group
+func filterGroupChecks(mode string, aggregation string, group string, checks []*api.HealthCheck, separate map[string]*CheckConfig) []*api.HealthCheck {
+	filtered := make([]*api.HealthCheck, 0)
+
+	for _, check := range checks {
+		if checkGroup(mode, aggregation, check.Node+"/"+check.CheckID, separate) == group {
+			filtered = append(filtered, check)
+		}
+	}
+
+	return filtered
+}
+
+// Returns the KV path to store the alert state for a group in, keeping the
+// original path for alerts that cover the whole watch
+func groupAlertPath(keyPath string, group string) string {
+	if group == "" {
+		return keyPath + "alert"
+	}
+	return keyPath + alertGroupsPath + group
+}
+
+// The KV prefix the datacenter-wide incidents are stored under, one key per datacenter
+const incidentsKVPath = alertingKVRoot + "/incidents/"
+
+// How many times an incident update is retried when another instance updated it first
+const incidentUpdateAttempts = 10
+
+// A datacenter-wide incident, coalescing the alerts of every watch using the
+// datacenter aggregation level. It's kept in the state store next to the alert states,
+// so it survives restarts and is shared by the instances watching its alerts.
+type incidentGroup struct {
+	// The open alerts in the incident, keyed by service/tag/node
+	Members map[string]*AlertState `json:"members"`
+
+	// The last status sent to the handlers for this incident, and the failing statuses
+	// sent since it last passed, like those of an alert
+	Status  string   `json:"status"`
+	Alerted []string `json:"alerted,omitempty"`
+}
+
+// Serializes the updates of the datacenter-wide incidents made by this instance
+type incidentGroups struct {
+	sync.Mutex
+}
+
+var datacenterIncidents = &incidentGroups{}
+
+// Adds an alert to its datacenter's incident and notifies the default handlers if the
+// overall status of the incident changed. The incident only recovers once every
+// alert in it has returned to passing. The incident is updated with a check-and-set, so
+// when several instances watch its alerts, only the one whose update changed its status
+// opens or resolves it.
+func (i *incidentGroups) update(client *api.Client, config *Config, alert *AlertState) {
+	i.apply(client, config, alert, true)
+}
+
+// Adds a failing alert restored after a restart to its datacenter's incident, in case the
+// incident was lost or stored before the alert was. The alert was already sent as a part
+// of the incident, so the handlers aren't notified.
+func (i *incidentGroups) restore(client *api.Client, config *Config, alert *AlertState) {
+	i.apply(client, config, alert, false)
+}
+
+// Updates the incident with an alert, notifying the handlers of a status change if notify
+// is set
+func (i *incidentGroups) apply(client *api.Client, config *Config, alert *AlertState, notify bool) {
+	i.Lock()
+	defer i.Unlock()
+
+	datacenter := config.ConsulDatacenter
+	key := alert.scopedService() + "/" + alert.Tag + "/" + alert.scopedNode()
+	store := stateKV(client)
+
+	for attempt := 0; attempt < incidentUpdateAttempts; attempt++ {
+		stored, ok, err := store.get(incidentsKVPath + datacenter)
+		if err != nil {
+			log.Errorf("Error loading datacenter incident for %s: %s", datacenter, err)
+			return
+		}
+
+		group := &incidentGroup{Members: make(map[string]*AlertState), Status: api.HealthPassing}
+		if ok && len(stored) > 0 {
+			if err := json.Unmarshal(stored, group); err != nil {
+				log.Errorf("Error parsing datacenter incident for %s: %s", datacenter, err)
+				return
+			}
+			if group.Members == nil {
+				group.Members = make(map[string]*AlertState)
+			}
+		}
+		if !ok {
+			stored = nil
+		}
+
+		lastStatus := group.Status
+		if alert.Status == api.HealthPassing {
+			delete(group.Members, key)
+		} else {
+			member := *alert
+			group.Members[key] = &member
+		}
+
+		statuses := make(map[string]string)
+		for key, member := range group.Members {
+			statuses[key] = member.Status
+		}
+		group.Status = computeHealth(statuses)
+
+		alerted := append([]string{lastStatus}, group.Alerted...)
+		if group.Status == api.HealthPassing {
+			group.Alerted = nil
+		} else if !contains(group.Alerted, group.Status) {
+			group.Alerted = append(group.Alerted, group.Status)
+		}
+
+		serialized, err := json.Marshal(group)
+		if err != nil {
+			log.Errorf("Error forming datacenter incident for %s: %s", datacenter, err)
+			return
+		}
+		set, err := store.cas(incidentsKVPath+datacenter, stored, serialized)
+		if err != nil {
+			log.Errorf("Error storing datacenter incident for %s: %s", datacenter, err)
+			return
+		}
+		if !set {
+			log.Debugf("Datacenter incident for %s was updated by another instance, retrying", datacenter)
+			continue
+		}
+
+		if notify {
+			notifyIncident(config, datacenter, group, alerted)
+		}
+		return
+	}
+
+	log.Errorf("Gave up updating datacenter incident for %s after %d attempts", datacenter, incidentUpdateAttempts)
+}
+
+// Notifies the default handlers of an incident if its status changed from the last one,
+// the first of the statuses alerted before the update
+func notifyIncident(config *Config, datacenter string, group *incidentGroup, alerted []string) {
+	status := group.Status
+	if status == alerted[0] {
+		log.Debugf("Datacenter incident for %s unchanged (%s), %d open alerts", datacenter, status, len(group.Members))
+		return
+	}
+
+	incident := &AlertState{
+		Status:   status,
+		Severity: config.alertSeverity("", status),
+		Message:  fmt.Sprintf("[%s] %d alerts are open, datacenter is now %s", datacenter, len(group.Members), status),
+		Details:  incidentDetails(group.Members),
+		Labels:   config.alertLabels(""),
+	}
+	if status == api.HealthPassing {
+		incident.Message = fmt.Sprintf("[%s] all alerts have recovered, datacenter is now %s", datacenter, status)
+	}
+
+	dispatchAlerts(config, config.severityHandlerNames(datacenter, "", "", nil, nil, status, alerted...), datacenter, incident)
+}
+
+// Returns the messages of each open alert in an incident, sorted for stable output
+func incidentDetails(members map[string]*AlertState) string {
+	lines := make([]string, 0, len(members))
+	for _, member := range members {
+		lines = append(lines, "=> "+member.Message)
+	}
+	sort.Strings(lines)
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return "Open alerts:\n" + strings.Join(lines, "\n")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestAggregate_groupCheckStatuses(t *testing.T) {
+	statuses := map[string]string{
+		"node1/check1": api.HealthCritical,
+		"node1/check2": api.HealthPassing,
+		"node2/check1": api.HealthWarning,
+	}
+
+	cases := []struct {
+		mode        string
+		aggregation string
+		expected    map[string]map[string]string
+	}{
+		{ServiceWatch, AggregateService, map[string]map[string]string{
+			"": statuses,
+		}},
+		{ServiceWatch, AggregateNode, map[string]map[string]string{
+			"node1": {"node1/check1": api.HealthCritical, "node1/check2": api.HealthPassing},
+			"node2": {"node2/check1": api.HealthWarning},
+		}},
+		{ServiceWatch, AggregateNone, map[string]map[string]string{
+			"node1/check1": {"node1/check1": api.HealthCritical},
+			"node1/check2": {"node1/check2": api.HealthPassing},
+			"node2/check1": {"node2/check1": api.HealthWarning},
+		}},
+		{NodeWatch, AggregateNode, map[string]map[string]string{
+			"": statuses,
+		}},
+	}
+
+	for _, c := range cases {
+		groups := groupCheckStatuses(c.mode, c.aggregation, statuses, nil)
+		if !reflect.DeepEqual(groups, c.expected) {
+			t.Errorf("%s/%s: expected %v, got %v", c.mode, c.aggregation, c.expected, groups)
+		}
+	}
+}
+
+func TestAggregate_groupAlertPath(t *testing.T) {
+	if path := groupAlertPath("service/redis/", ""); path != "service/redis/alert" {
+		t.Errorf("expected service/redis/alert, got %s", path)
+	}
+
+	if path := groupAlertPath("service/redis/", "node1"); path != "service/redis/alerts/node1" {
+		t.Errorf("expected service/redis/alerts/node1, got %s", path)
+	}
+}
+
+// Make sure a datacenter incident only recovers once all of its alerts have recovered
+func TestAggregate_datacenterIncident(t *testing.T) {
+	client, _, stop := testFakeKV(t)
+	defer stop()
+
+	config, alertCh := testAlertConfig()
+	config.ConsulDatacenter = "aggregate-test"
+
+	expectStatus := func(status string) {
+		select {
+		case alert := <-alertCh:
+			if alert.Status != status {
+				t.Fatalf("expected incident status %s, got %s", status, alert.Status)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("didn't get incident alert for status %s", status)
+		}
+	}
+
+	expectNothing := func() {
+		select {
+		case alert := <-alertCh:
+			t.Fatalf("got unexpected incident alert: %v", alert)
+		default:
+		}
+	}
+
+	datacenterIncidents.update(client, config, &AlertState{Service: "redis", Status: api.HealthWarning})
+	expectStatus(api.HealthWarning)
+
+	datacenterIncidents.update(client, config, &AlertState{Service: "nginx", Status: api.HealthCritical})
+	expectStatus(api.HealthCritical)
+
+	datacenterIncidents.update(client, config, &AlertState{Service: "redis", Status: api.HealthPassing})
+	expectNothing()
+
+	datacenterIncidents.update(client, config, &AlertState{Service: "nginx", Status: api.HealthPassing})
+	expectStatus(api.HealthPassing)
+}
+
+// Make sure an incident opened before a restart, or by another instance, is resolved by
+// the instance that sees its last alert recover
+func TestAggregate_datacenterIncidentShared(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	config, alertCh := testAlertConfig()
+	config.ConsulDatacenter = "aggregate-shared"
+
+	first, second := &incidentGroups{}, &incidentGroups{}
+
+	first.update(client, config, &AlertState{Service: "redis", Status: api.HealthCritical})
+	select {
+	case alert := <-alertCh:
+		if alert.Status != api.HealthCritical {
+			t.Fatalf("expected incident status critical, got %s", alert.Status)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("didn't get the incident alert")
+	}
+	if _, ok := values[incidentsKVPath+"aggregate-shared"]; !ok {
+		t.Fatal("expected the incident to be stored")
+	}
+
+	// Another instance's alert joins the open incident without opening a new one
+	second.update(client, config, &AlertState{Service: "nginx", Status: api.HealthCritical})
+	second.update(client, config, &AlertState{Service: "redis", Status: api.HealthPassing})
+	select {
+	case alert := <-alertCh:
+		t.Fatalf("got unexpected incident alert: %v", alert)
+	default:
+	}
+
+	second.update(client, config, &AlertState{Service: "nginx", Status: api.HealthPassing})
+	select {
+	case alert := <-alertCh:
+		if alert.Status != api.HealthPassing {
+			t.Fatalf("expected incident status passing, got %s", alert.Status)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("didn't get the incident recovery")
+	}
+}
@@ -13,17 +13,121 @@ import (
 type AlertState struct {
 	Status      string `json:"status"`
 	Node        string `json:"node"`
+	NodeAddress string `json:"node_address,omitempty"`
 	Service     string `json:"service"`
+	Namespace   string `json:"namespace,omitempty"`
+	Partition   string `json:"partition,omitempty"`
 	Tag         string `json:"tag"`
+	Check       string `json:"check"`
 	UpdateIndex int64  `json:"update_index"`
 	LastAlerted string `json:"last_alerted"`
-	Message     string `json:"message"`
-	Details     string `json:"details"`
+
+	// The failing statuses sent to the handlers since the alert last passed, so its
+	// recovery reaches every handler that received one of them, even after a downgrade
+	Alerted []string `json:"alerted,omitempty"`
+
+	Message   string `json:"message"`
+	Details   string `json:"details"`
+	Severity  string `json:"severity,omitempty"`
+	AckedBy   string `json:"acked_by,omitempty"`
+	ConsulURL string `json:"consul_url,omitempty"`
+
+	// When the alert started failing and when it was last sent, as unix timestamps, used to
+	// resume its reminders and escalations after a restart
+	FailingSince int64 `json:"failing_since,omitempty"`
+	LastNotified int64 `json:"last_notified,omitempty"`
+
+	// With the instances aggregation, the number of instances of the service and how many
+	// of them are failing
+	Instances        int `json:"instances,omitempty"`
+	FailingInstances int `json:"failing_instances,omitempty"`
+
+	// Set on an alert about a change in the number of failing instances of a service that
+	// stays failing, which is sent without a change of status
+	instancesChanged bool
+
+	// The span of the alert's trace that the later steps of sending it are recorded
+	// under, when tracing is enabled
+	span *span
+
+	// The recipients an email handler leaves out of the alert, since an email handler
+	// it was sent to before already has them
+	skipRecipients []string
+
+	// The names of the output rules matched by the alert's failing checks
+	Signatures []string `json:"signatures,omitempty"`
+
+	// The Consul metadata of the node and service, and the tags of the service, looked up
+	// when alerting
+	NodeMeta    map[string]string `json:"node_meta,omitempty"`
+	ServiceMeta map[string]string `json:"service_meta,omitempty"`
+	ServiceTags []string          `json:"service_tags,omitempty"`
+
+	// The failing checks of the alert with their raw output, also summarized in Details
+	Checks []AlertCheck `json:"checks,omitempty"`
+
+	// The static labels configured for the alert's datacenter and service
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// A failing check of an alert
+type AlertCheck struct {
+	Node      string `json:"node"`
+	CheckID   string `json:"check_id"`
+	Name      string `json:"name"`
+	ServiceID string `json:"service_id,omitempty"`
+	Status    string `json:"status"`
+	Output    string `json:"output"`
+}
+
+// Adds a status sent to an alert's handlers to the failing statuses it was sent, which
+// are cleared once it recovers
+func recordAlerted(alert *AlertState, status string) {
+	if status == api.HealthPassing {
+		alert.Alerted = nil
+	} else if !contains(alert.Alerted, status) {
+		alert.Alerted = append(alert.Alerted, status)
+	}
+}
+
+// Returns the statuses an alert's handlers were sent: the last alerted status, along
+// with the failing statuses sent since the alert last passed
+func alertedStatuses(alert *AlertState, lastAlerted string) []string {
+	statuses := []string{lastAlerted}
+	for _, status := range alert.Alerted {
+		if !contains(statuses, status) {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// Returns the failing checks of an alert. Like nodeDetails, the alerts of node watches
+// leave out the checks of services.
+func failingChecks(mode string, checks []*api.HealthCheck) []AlertCheck {
+	var failing []AlertCheck
+	for _, check := range checks {
+		if check.Status != api.HealthCritical && check.Status != api.HealthWarning {
+			continue
+		}
+		if mode == NodeWatch && check.ServiceID != "" {
+			continue
+		}
+		failing = append(failing, AlertCheck{
+			Node:      check.Node,
+			CheckID:   check.CheckID,
+			Name:      check.Name,
+			ServiceID: check.ServiceID,
+			Status:    check.Status,
+			Output:    check.Output,
+		})
+	}
+	return failing
 }
 
 // Parses a CheckState from a given Consul K/V path
 func getAlertState(kvPath string, client *api.Client) (*AlertState, error) {
-	kvPair, _, err := client.KV().Get(kvPath, nil)
+	value, _, err := stateKV(client).get(kvPath)
 	check := &AlertState{}
 
 	if err != nil {
@@ -31,15 +135,11 @@ func getAlertState(kvPath string, client *api.Client) (*AlertState, error) {
 		return nil, err
 	}
 
-	if kvPair == nil {
+	if string(value) == "" {
 		return nil, nil
 	}
 
-	if string(kvPair.Value) == "" {
-		return nil, nil
-	}
-
-	err = json.Unmarshal(kvPair.Value, check)
+	err = json.Unmarshal(value, check)
 
 	if err != nil {
 		log.Error("Error parsing alert state: ", err)
@@ -56,10 +156,7 @@ func setAlertState(kvPath string, alert *AlertState, client *api.Client) error {
 		return fmt.Errorf("Error forming state for alert in Consul: %s", err)
 	}
 
-	_, err = client.KV().Put(&api.KVPair{
-		Key:   kvPath,
-		Value: serialized,
-	}, nil)
+	err = stateKV(client).put(kvPath, serialized)
 
 	if err != nil {
 		return fmt.Errorf("Error storing state for alert in Consul: %s", err)
@@ -69,8 +166,21 @@ func setAlertState(kvPath string, alert *AlertState, client *api.Client) error {
 }
 
 // Waits for changeThreshold duration, then alerts if LastUpdated has not
-// changed in the meantime (which would indicate another alert resetting the timer)
-func tryAlert(kvPath string, update AlertState, watchOpts *WatchOptions) {
+// changed in the meantime (which would indicate another alert resetting the timer).
+// The alerts of a check with its own check block use the check's settings.
+func tryAlert(kvPath string, check *CheckConfig, update AlertState, watchOpts *WatchOptions) {
+	node := watchOpts.node
+	if update.Node != "" {
+		node = update.Node
+	}
+	span := startSpan(nil, "alert",
+		"consul.datacenter", watchOpts.config.ConsulDatacenter,
+		"consul.service", watchOpts.service,
+		"consul.node", node,
+		"consul.check", update.Check,
+		"alert.status", update.Status)
+	defer span.finish(nil)
+
 	// Lock the mutex while reading or writing the alert state to avoid race conditions
 	watchOpts.alertLock.Lock()
 	alert, err := getAlertState(kvPath, watchOpts.client)
@@ -86,14 +196,26 @@ func tryAlert(kvPath string, update AlertState, watchOpts *WatchOptions) {
 		alert = &AlertState{
 			Node:        watchOpts.node,
 			Service:     watchOpts.service,
+			Namespace:   watchOpts.config.namespace,
+			Partition:   watchOpts.config.partition,
 			Tag:         watchOpts.tag,
+			Check:       update.Check,
 			LastAlerted: api.HealthPassing,
 		}
+
+		// Alerts grouped by node within a service watch carry their own node name
+		if update.Node != "" {
+			alert.Node = update.Node
+		}
 	}
 
 	alert.Status = update.Status
 	alert.Message = update.Message
 	alert.Details = update.Details
+	alert.Signatures = update.Signatures
+	alert.Checks = update.Checks
+	alert.Instances = update.Instances
+	alert.FailingInstances = update.FailingInstances
 
 	// Increment the update index and store it, so we can check later to see if it changed
 	alert.UpdateIndex++
@@ -108,9 +230,13 @@ func tryAlert(kvPath string, update AlertState, watchOpts *WatchOptions) {
 	}
 	watchOpts.alertLock.Unlock()
 
-	changeThreshold := watchOpts.config.serviceChangeThreshold(watchOpts.service)
+	// The service's tags can override its settings, like the delay before alerting
+	tags := serviceTags(watchOpts)
+
 	log.Debugf("Starting timer for alert: '%s'", update.Message)
-	time.Sleep(time.Duration(changeThreshold) * time.Second)
+	delay := startSpan(span, "alert.delay")
+	time.Sleep(latestConfig(watchOpts.config).withCatalogSettings(watchOpts.service, tags).withCheckSettings(watchOpts.service, check).serviceAlertDelay(watchOpts.service, update.Status))
+	delay.finish(nil)
 
 	watchOpts.alertLock.Lock()
 	defer watchOpts.alertLock.Unlock()
@@ -128,11 +254,51 @@ func tryAlert(kvPath string, update AlertState, watchOpts *WatchOptions) {
 	}
 
 	// If no new alerts were triggered during the sleep, send the alert to each handler to be processed
-	if alert.UpdateIndex == updateIndex && update.Status != alert.LastAlerted {
-		for _, handler := range watchOpts.config.serviceHandlers(watchOpts.service) {
-			handler.Alert(watchOpts.config.ConsulDatacenter, alert)
+	config := latestConfig(watchOpts.config).withCatalogSettings(watchOpts.service, tags).withCheckSettings(watchOpts.service, check)
+	if alert.UpdateIndex != updateIndex {
+		span.setAttributes("alert.outcome", "superseded")
+	} else if update.Status == alert.LastAlerted && !update.instancesChanged {
+		span.setAttributes("alert.outcome", "unchanged")
+	}
+	if alert.UpdateIndex == updateIndex && (update.Status != alert.LastAlerted || update.instancesChanged) {
+		alert.span = span
+		now := time.Now()
+		if alert.Status == api.HealthPassing {
+			alert.FailingSince = 0
+		} else if alert.LastAlerted == api.HealthPassing || alert.FailingSince == 0 {
+			alert.FailingSince = now.Unix()
+		}
+		alert.LastNotified = now.Unix()
+
+		if config.serviceAggregation(watchOpts.service) == AggregateDatacenter {
+			datacenterIncidents.update(watchOpts.client, config, alert)
+		} else {
+			alert.Severity = config.alertSeverity(watchOpts.service, alert.Status)
+			alert.NodeMeta, alert.ServiceMeta, alert.ServiceTags, alert.NodeAddress = alertMetadata(watchOpts, alert.Node)
+			if isExternalNode(alert.NodeMeta) {
+				alert.Details = strings.TrimSpace(externalNodeDetails(alert) + "\n" + alert.Details)
+			}
+			config.applyMessageTemplates(watchOpts.service, alert)
+			if alert.Status == alert.LastAlerted {
+				// A change in the failing instances isn't a status change to flap on
+				notifyHandlers(config, watchOpts.service, tags, alert, alert.LastAlerted)
+			} else {
+				flapRates.record(config, watchOpts.service, tags, alert)
+				if !suppressDuplicate(watchOpts.client, config.serviceDedupeCooldown(watchOpts.service), config.ConsulDatacenter, alert) {
+					flapDetection.notify(config, watchOpts.service, tags, alert)
+				}
+			}
+			if alert.Status == api.HealthPassing {
+				clearAck(watchOpts.client, config.ConsulDatacenter, alert)
+			}
 		}
+		activeAlerts.update(config.ConsulDatacenter, alert)
+		if alert.Status != alert.LastAlerted {
+			countAlert(alert)
+		}
+		history.record(config.ConsulDatacenter, alert, now)
 		alert.LastAlerted = update.Status
+		recordAlerted(alert, update.Status)
 
 		err = setAlertState(kvPath, alert, watchOpts.client)
 		if err != nil {
@@ -141,6 +307,70 @@ func tryAlert(kvPath string, update AlertState, watchOpts *WatchOptions) {
 	}
 }
 
+// Looks up the tags registered on the instances of the watched service, used for
+// applying per-service overrides set by tags. The "alerting." meta keys of the instances
+// are included as tags.
+func serviceTags(watchOpts *WatchOptions) []string {
+	if watchOpts.service == "" || watchOpts.client == nil {
+		return make([]string, 0)
+	}
+
+	var instances []catalogServiceMeta
+	if _, err := watchOpts.client.Raw().Query("/v1/catalog/service/"+watchOpts.service, &instances, watchOpts.config.queryOptions()); err != nil {
+		log.Errorf("Error fetching tags for service %s: %s", watchOpts.service, err)
+		return make([]string, 0)
+	}
+
+	return mergeCatalogTags(instances)
+}
+
+// Looks up the metadata of the alert's node and of the watched service, used by alert
+// templates, the tags of the service and the address of the node. The service metadata
+// and tags are taken from the instance on the alert's node if there is one, otherwise
+// from the first instance.
+func alertMetadata(watchOpts *WatchOptions, node string) (map[string]string, map[string]string, []string, string) {
+	if watchOpts.client == nil {
+		return nil, nil, nil, ""
+	}
+
+	var nodeMeta, serviceMeta map[string]string
+	var serviceTags []string
+	var address string
+	if node != "" {
+		var catalogNode struct {
+			Node catalogNodeMeta
+		}
+		if _, err := watchOpts.client.Raw().Query("/v1/catalog/node/"+node, &catalogNode, watchOpts.config.queryOptions()); err != nil {
+			log.Errorf("Error fetching metadata for node %s: %s", node, err)
+		} else {
+			nodeMeta = catalogNode.Node.Meta
+			address = catalogNode.Node.Address
+		}
+	}
+
+	if watchOpts.service != "" {
+		var instances []struct {
+			Node        string
+			ServiceMeta map[string]string
+			ServiceTags []string
+		}
+		if _, err := watchOpts.client.Raw().Query("/v1/catalog/service/"+watchOpts.service, &instances, watchOpts.config.queryOptions()); err != nil {
+			log.Errorf("Error fetching metadata for service %s: %s", watchOpts.service, err)
+		}
+		for i, instance := range instances {
+			if i == 0 || instance.Node == node {
+				serviceMeta = instance.ServiceMeta
+				serviceTags = instance.ServiceTags
+			}
+			if instance.Node == node {
+				break
+			}
+		}
+	}
+
+	return nodeMeta, serviceMeta, serviceTags, address
+}
+
 // Returns each failing check and its output, used for formatting alert details
 func nodeDetails(checks []*api.HealthCheck) string {
 	details := ""
@@ -59,7 +59,7 @@ func TestAlert_tryAlert(t *testing.T) {
 
 	config, alertCh := testAlertConfig()
 
-	go tryAlert(testAlertKVPath, AlertState{
+	go tryAlert(testAlertKVPath, nil, AlertState{
 		Status: api.HealthCritical,
 	}, &WatchOptions{
 		client:    client,
@@ -90,7 +90,7 @@ func TestAlert_defaultHandler(t *testing.T) {
 		},
 	}
 
-	go tryAlert(testAlertKVPath, AlertState{
+	go tryAlert(testAlertKVPath, nil, AlertState{
 		Status: api.HealthCritical,
 	}, &WatchOptions{
 		client:    client,
@@ -132,7 +132,7 @@ func TestAlert_serviceHandler(t *testing.T) {
 		},
 	}
 
-	go tryAlert(testAlertKVPath, AlertState{
+	go tryAlert(testAlertKVPath, nil, AlertState{
 		Status: api.HealthCritical,
 	}, &WatchOptions{
 		service:   testServiceName,
@@ -153,3 +153,24 @@ func TestAlert_serviceHandler(t *testing.T) {
 	case <-time.After(1 * time.Second):
 	}
 }
+
+func TestAlert_failingChecks(t *testing.T) {
+	checks := []*api.HealthCheck{
+		{Node: "node1", CheckID: "serfHealth", Name: "Serf Health Status", Status: api.HealthPassing},
+		{Node: "node1", CheckID: "disk", Name: "Disk usage", Status: api.HealthWarning, Output: "91% used"},
+		{Node: "node1", CheckID: "service:redis", Name: "redis", ServiceID: "redis", Status: api.HealthCritical, Output: "connection refused"},
+	}
+
+	expected := []AlertCheck{
+		{Node: "node1", CheckID: "disk", Name: "Disk usage", Status: api.HealthWarning, Output: "91% used"},
+		{Node: "node1", CheckID: "service:redis", Name: "redis", ServiceID: "redis", Status: api.HealthCritical, Output: "connection refused"},
+	}
+	if failing := failingChecks(ServiceWatch, checks); !reflect.DeepEqual(failing, expected) {
+		t.Errorf("expected %+v, got %+v", expected, failing)
+	}
+
+	// Node alerts leave out the checks of services
+	if failing := failingChecks(NodeWatch, checks); !reflect.DeepEqual(failing, expected[:1]) {
+		t.Errorf("expected only the node check, got %+v", failing)
+	}
+}
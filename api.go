@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The state of a running watch, as last reported by its loop
+type watchStatus struct {
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Datacenter string     `json:"datacenter,omitempty"`
+	Leader     bool       `json:"leader"`
+	LastQuery  *time.Time `json:"last_query,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// The watches running on this instance, keyed by name
+type watchRegistry struct {
+	sync.Mutex
+	watches map[string]*watchStatus
+}
+
+var runningWatches = &watchRegistry{
+	watches: make(map[string]*watchStatus),
+}
+
+func (r *watchRegistry) start(name string, mode string, datacenter string) {
+	r.Lock()
+	defer r.Unlock()
+	r.watches[name] = &watchStatus{Name: name, Type: mode, Datacenter: datacenter}
+}
+
+func (r *watchRegistry) stop(name string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.watches, name)
+}
+
+// Records that the watch is waiting for its lock
+func (r *watchRegistry) waiting(name string) {
+	r.Lock()
+	defer r.Unlock()
+	if status, ok := r.watches[name]; ok {
+		status.Leader = false
+	}
+}
+
+// Records the result of a query made by the watch, which only queries while it holds its lock
+func (r *watchRegistry) queried(name string, err error, now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	status, ok := r.watches[name]
+	if !ok {
+		return
+	}
+	status.Leader = true
+	status.LastQuery = &now
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
+
+// Returns the watches sorted by name
+func (r *watchRegistry) list() []watchStatus {
+	r.Lock()
+	defer r.Unlock()
+
+	watches := make([]watchStatus, 0, len(r.watches))
+	for _, status := range r.watches {
+		watches = append(watches, *status)
+	}
+	sort.Slice(watches, func(i, j int) bool {
+		return watches[i].Name < watches[j].Name
+	})
+	return watches
+}
+
+// Requires requests to carry the API token, if one is set: as a bearer token, or as the
+// password of basic auth, which browsers prompt for when viewing the dashboard
+func requireAPIToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	if token == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !validAPIToken(token, r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="consul-alerting"`)
+			http.Error(w, "invalid or missing API token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func validAPIToken(token string, r *http.Request) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := r.BasicAuth(); ok {
+		given = password
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// Lists the alerts currently open on this instance
+func alertsHandler(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, activeAlerts.payloads())
+	}
+}
+
+// Lists the watches running on this instance and whether they hold their lock
+func watchesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, runningWatches.list())
+}
+
+// The body of a request creating a silence, which lasts for the duration or until the
+// expiry time given. It silences a service, or the alerts on a node or of the services
+// matching a pattern.
+type silenceRequest struct {
+	Service  string    `json:"service"`
+	Node     string    `json:"node"`
+	Match    string    `json:"match"`
+	Duration string    `json:"duration"`
+	Expires  time.Time `json:"expires"`
+	Reason   string    `json:"reason"`
+}
+
+// A silence and the name of its key, which is the service of a service silence
+type silenceListing struct {
+	ID      string    `json:"id"`
+	Service string    `json:"service,omitempty"`
+	Node    string    `json:"node,omitempty"`
+	Match   string    `json:"match,omitempty"`
+	Expires time.Time `json:"expires"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+func newSilenceListing(name string, s silence) silenceListing {
+	listing := silenceListing{ID: name, Node: s.Node, Match: s.Match, Expires: s.Expires, Reason: s.Reason}
+	if s.Node == "" && s.Match == "" {
+		listing.Service = name
+	}
+	return listing
+}
+
+// Describes what the silence applies to
+func (l silenceListing) target() string {
+	return silence{Node: l.Node, Match: l.Match}.target(l.ID)
+}
+
+// Returns the active silences sorted by ID
+func silenceListings(now time.Time) []silenceListing {
+	listing := make([]silenceListing, 0)
+	for name, s := range alertSilences.active(now) {
+		listing = append(listing, newSilenceListing(name, s))
+	}
+	sort.Slice(listing, func(i, j int) bool {
+		return listing[i].ID < listing[j].ID
+	})
+	return listing
+}
+
+// Lists the active silences on GET, creates or replaces a silence on PUT/POST and deletes
+// the silence with the ID, or of the service, node or pattern, given in the query on DELETE
+func silencesHandler(config *Config, client *api.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := latestConfig(config)
+		if config.SilenceKVPrefix == "" {
+			http.Error(w, "silences are disabled", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			writeJSON(w, silenceListings(time.Now()))
+
+		case "PUT", "POST":
+			var req silenceRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+				return
+			}
+			if req.Service != "" && (req.Node != "" || req.Match != "") {
+				http.Error(w, "service can't be set with node or match", http.StatusBadRequest)
+				return
+			}
+			if req.Service == "" && req.Node == "" && req.Match == "" {
+				http.Error(w, "service, node or match must be set", http.StatusBadRequest)
+				return
+			}
+			expires := req.Expires
+			if req.Duration != "" {
+				d, err := time.ParseDuration(req.Duration)
+				if err != nil || d <= 0 {
+					http.Error(w, fmt.Sprintf("invalid duration: %s", req.Duration), http.StatusBadRequest)
+					return
+				}
+				expires = time.Now().Add(d)
+			}
+			if !expires.After(time.Now()) {
+				http.Error(w, "duration or a future expires must be set", http.StatusBadRequest)
+				return
+			}
+
+			s := silence{Expires: expires.UTC(), Reason: req.Reason, Node: req.Node, Match: req.Match}
+			if err := s.compile(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			name := silenceName(req.Service, req.Node, req.Match)
+			if err := writeSilence(client, config.SilenceKVPrefix, name, s); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, newSilenceListing(name, s))
+
+		case "DELETE":
+			query := r.URL.Query()
+			name := query.Get("id")
+			if name == "" {
+				name = silenceName(query.Get("service"), query.Get("node"), query.Get("match"))
+			}
+			if name == "" {
+				http.Error(w, "id, service, node or match must be set", http.StatusBadRequest)
+				return
+			}
+			if _, err := client.KV().Delete(config.SilenceKVPrefix+name, nil); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI_requireToken(t *testing.T) {
+	handler := requireAPIToken("secret", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	cases := map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusNoContent,
+		// Basic auth with the token as the password, as sent by a browser
+		"Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")): http.StatusNoContent,
+		"Basic " + base64.StdEncoding.EncodeToString([]byte("admin:wrong")):  http.StatusUnauthorized,
+	}
+	for header, expected := range cases {
+		r := httptest.NewRequest("GET", "/v1/alerts", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != expected {
+			t.Errorf("expected status %d with %q, got %d", expected, header, w.Code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("expected a basic auth challenge with %q", header)
+		}
+	}
+}
+
+func TestAPI_silences(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	config := &Config{SilenceKVPrefix: "service/consul-alerting/silences/"}
+	handler := silencesHandler(config, client)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("PUT", "/v1/silences", strings.NewReader(`{"service": "redis", "duration": "2h", "reason": "upgrade"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var s silence
+	if err := json.Unmarshal(values["service/consul-alerting/silences/redis"], &s); err != nil {
+		t.Fatalf("expected a silence in the KV: %s", err)
+	}
+	if s.Reason != "upgrade" || s.Expires.Before(time.Now().Add(119*time.Minute)) {
+		t.Errorf("unexpected silence: %+v", s)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("PUT", "/v1/silences", strings.NewReader(`{"service": "redis"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without a duration, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("DELETE", "/v1/silences?service=redis", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := values["service/consul-alerting/silences/redis"]; ok {
+		t.Error("expected the silence to be deleted")
+	}
+
+	w = httptest.NewRecorder()
+	silencesHandler(&Config{}, client)(w, httptest.NewRequest("GET", "/v1/silences", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 with silences disabled, got %d", w.Code)
+	}
+}
+
+func TestAPI_watches(t *testing.T) {
+	runningWatches.start("service redis", "service", "dc1")
+	defer runningWatches.stop("service redis")
+
+	now := time.Now()
+	runningWatches.queried("service redis", errors.New("connection refused"), now)
+
+	w := httptest.NewRecorder()
+	watchesHandler(w, httptest.NewRequest("GET", "/v1/watches", nil))
+
+	var watches []watchStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &watches); err != nil {
+		t.Fatal(err)
+	}
+	if len(watches) != 1 {
+		t.Fatalf("expected 1 watch, got %d", len(watches))
+	}
+	watch := watches[0]
+	if watch.Type != "service" || !watch.Leader || watch.LastQuery == nil || watch.LastError != "connection refused" {
+		t.Errorf("unexpected watch status: %+v", watch)
+	}
+
+	runningWatches.waiting("service redis")
+	if runningWatches.list()[0].Leader {
+		t.Error("expected the watch not to be the leader while waiting for its lock")
+	}
+}
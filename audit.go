@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A notification attempt, as written to the audit log. Retries is how many times the
+// target was retried, and Outcome is delivered, failed or skipped (while the handler's
+// circuit breaker is open).
+type notificationRecord struct {
+	Time        time.Time `json:"time"`
+	Handler     string    `json:"handler"`
+	HandlerType string    `json:"handler_type"`
+	Target      string    `json:"target,omitempty"`
+	Datacenter  string    `json:"datacenter"`
+	Service     string    `json:"service,omitempty"`
+	Tag         string    `json:"tag,omitempty"`
+	Node        string    `json:"node,omitempty"`
+	Check       string    `json:"check,omitempty"`
+	Status      string    `json:"status"`
+	Message     string    `json:"message"`
+	Outcome     string    `json:"outcome"`
+	Retries     int       `json:"retries"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// A target a handler sent an alert to through retryAlert, like an email server or a
+// webhook URL
+type auditedTarget struct {
+	target  string
+	retries int
+	err     error
+}
+
+type auditedSend struct {
+	targets []auditedTarget
+}
+
+// The audit log of notification attempts, a file of JSON lines. While a handler is sending
+// an alert, the targets it tries are collected by the copy of the alert it was given.
+type notificationAudit struct {
+	sync.Mutex
+	file  *rotatingFile
+	sends map[*AlertState]*auditedSend
+}
+
+var auditLog = &notificationAudit{
+	sends: make(map[*AlertState]*auditedSend),
+}
+
+// Opens the audit log, if a file is configured
+func setupAuditLog(config *Config) error {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+
+	if auditLog.file != nil {
+		auditLog.file.Close()
+		auditLog.file = nil
+	}
+	if config.AuditLogFile == "" {
+		return nil
+	}
+
+	file, err := openRotatingFile(config.AuditLogFile, int64(config.AuditLogMaxSize)*1024*1024, config.AuditLogMaxBackups)
+	if err != nil {
+		return fmt.Errorf("Error opening audit log: %s", err)
+	}
+	auditLog.file = file
+	return nil
+}
+
+func (a *notificationAudit) enabled() bool {
+	a.Lock()
+	defer a.Unlock()
+	return a.file != nil
+}
+
+// Starts auditing a send, returning the copy of the alert to give the handler
+func (a *notificationAudit) start(alert *AlertState) (*AlertState, *auditedSend) {
+	if !a.enabled() {
+		return alert, nil
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	copied := *alert
+	send := &auditedSend{}
+	a.sends[&copied] = send
+	return &copied, send
+}
+
+// Records a target tried by the handler while sending the alert
+func (a *notificationAudit) attempt(alert *AlertState, target string, retries int, err error) {
+	a.Lock()
+	defer a.Unlock()
+	if send, ok := a.sends[alert]; ok {
+		send.targets = append(send.targets, auditedTarget{target, retries, err})
+	}
+}
+
+// Writes the records of a send, one for each target tried, or a single one if the
+// handler didn't report its targets
+func (a *notificationAudit) finish(h namedHandler, datacenter string, alert *AlertState, send *auditedSend, err error) {
+	if send == nil {
+		return
+	}
+
+	a.Lock()
+	delete(a.sends, alert)
+	targets := send.targets
+	a.Unlock()
+
+	if len(targets) == 0 {
+		targets = []auditedTarget{{err: err}}
+	}
+	for _, target := range targets {
+		a.write(h, datacenter, alert, target)
+	}
+}
+
+// Records a send skipped while the handler's circuit breaker is open
+func (a *notificationAudit) skipped(h namedHandler, datacenter string, alert *AlertState) {
+	if a.enabled() {
+		a.write(h, datacenter, alert, auditedTarget{err: errCircuitOpen})
+	}
+}
+
+func (a *notificationAudit) write(h namedHandler, datacenter string, alert *AlertState, target auditedTarget) {
+	record := notificationRecord{
+		Time:        time.Now().UTC(),
+		Handler:     h.name,
+		HandlerType: handlerType(h.handler),
+		Target:      target.target,
+		Datacenter:  datacenter,
+		Service:     alert.Service,
+		Tag:         alert.Tag,
+		Node:        alert.Node,
+		Check:       alert.Check,
+		Status:      alert.Status,
+		Message:     alert.Message,
+		Outcome:     "delivered",
+		Retries:     target.retries,
+	}
+	switch {
+	case target.err == errCircuitOpen:
+		record.Outcome = "skipped"
+		record.Error = target.err.Error()
+	case target.err != nil:
+		record.Outcome = "failed"
+		record.Error = target.err.Error()
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Error encoding audit log record: %s", err)
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	if a.file == nil {
+		return
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Errorf("Error writing audit log: %s", err)
+	}
+}
+
+// Returns the type of a handler, like Slack for a SlackHandler
+func handlerType(handler AlertHandler) string {
+	if handler == nil {
+		return ""
+	}
+	return strings.TrimSuffix(reflect.TypeOf(handler).Name(), "Handler")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A handler sending each alert to two targets, the second of which always fails
+type twoTargetHandler struct{}
+
+func (twoTargetHandler) Alert(datacenter string, alert *AlertState) error {
+	retryAlert(alert, 0, "target one", func() error { return nil })
+	return retryAlert(alert, 2, "target two", func() error { return errors.New("unreachable") })
+}
+
+func TestAudit_records(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := setupAuditLog(&Config{AuditLogFile: path}); err != nil {
+		t.Fatal(err)
+	}
+	defer setupAuditLog(&Config{})
+	setRetryPolicy(retryPolicy{initial: time.Millisecond, max: time.Millisecond})
+	defer setRetryPolicy(retryPolicy{initial: 5 * time.Second, max: 60 * time.Second})
+
+	h := namedHandler{name: "twotargets.test", handler: twoTargetHandler{}}
+	h.alert("dc1", &AlertState{Service: "redis", Status: "critical", Message: "redis is critical"})
+
+	h = namedHandler{name: "stdout.test", handler: testHandler{alerts: make(chan *AlertState, 1)}}
+	h.alert("dc1", &AlertState{Node: "node1", Status: "warning"})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []notificationRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record notificationRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+
+	if r := records[0]; r.Target != "target one" || r.Outcome != "delivered" || r.Retries != 0 || r.Service != "redis" || r.HandlerType != "twoTarget" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if r := records[1]; r.Target != "target two" || r.Outcome != "failed" || r.Retries != 2 || r.Error != "unreachable" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if r := records[2]; r.Handler != "stdout.test" || r.Target != "" || r.Outcome != "delivered" || r.Node != "node1" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if len(auditLog.sends) != 0 {
+		t.Errorf("expected finished sends to be forgotten, %d left", len(auditLog.sends))
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The metadata endpoints used for fetching instance and container credentials
+const (
+	awsInstanceMetadataURL     = "http://169.254.169.254"
+	awsContainerCredentialsURL = "http://169.254.170.2"
+)
+
+// How long the IMDSv2 session tokens used for fetching instance credentials last, in
+// seconds
+const awsInstanceTokenTTL = "21600"
+
+// Credentials are refreshed this long before they expire
+const awsCredentialsExpiryWindow = 5 * time.Minute
+
+// A set of AWS credentials, with an expiration if they're temporary
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+func (creds *awsCredentials) expired() bool {
+	return !creds.Expiration.IsZero() && time.Now().Add(awsCredentialsExpiryWindow).After(creds.Expiration)
+}
+
+// Resolves AWS credentials using the same order as the AWS SDKs: static keys from the
+// config, then the environment, the shared credentials file, and finally the ECS
+// container or EC2 instance role. Resolved credentials are cached until they expire.
+type awsCredentialChain struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Profile         string
+
+	lock   sync.Mutex
+	cached *awsCredentials
+}
+
+func (chain *awsCredentialChain) get() (*awsCredentials, error) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	if chain.cached != nil && !chain.cached.expired() {
+		return chain.cached, nil
+	}
+
+	if chain.AccessKeyID != "" {
+		chain.cached = &awsCredentials{AccessKeyID: chain.AccessKeyID, SecretAccessKey: chain.SecretAccessKey}
+		return chain.cached, nil
+	}
+
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		chain.cached = &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		return chain.cached, nil
+	}
+
+	profile := chain.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	if creds, err := awsSharedCredentials(profile); err != nil {
+		return nil, err
+	} else if creds != nil {
+		chain.cached = creds
+		return creds, nil
+	}
+
+	var creds *awsCredentials
+	var err error
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		creds, err = awsFetchCredentials(awsContainerCredentialsURL+uri, "")
+	} else {
+		creds, err = awsInstanceCredentials(awsInstanceMetadataURL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials found in the config, environment or credentials file, and fetching role credentials failed: %s", err)
+	}
+
+	chain.cached = creds
+	return creds, nil
+}
+
+// Reads the credentials for a profile from the shared credentials file, returning nil
+// if the file or profile doesn't exist
+func awsSharedCredentials(profile string) (*awsCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".aws", "credentials")
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading AWS credentials file: %s", err)
+	}
+	defer file.Close()
+
+	var creds *awsCredentials
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if section != profile || len(parts) != 2 {
+			continue
+		}
+
+		if creds == nil {
+			creds = &awsCredentials{}
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading AWS credentials file: %s", err)
+	}
+
+	if creds != nil && creds.AccessKeyID == "" {
+		return nil, nil
+	}
+	return creds, nil
+}
+
+// Fetches the credentials of the role attached to this EC2 instance, through an IMDSv2
+// session, or with IMDSv1 if the instance metadata service doesn't hand out tokens
+func awsInstanceCredentials(metadataURL string) (*awsCredentials, error) {
+	token := awsInstanceToken(metadataURL)
+	rolesURL := metadataURL + "/latest/meta-data/iam/security-credentials/"
+	resp, err := awsMetadataGet(rolesURL, token)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response code %d listing instance roles", resp.StatusCode)
+	}
+
+	role := strings.TrimSpace(strings.SplitN(string(body), "\n", 2)[0])
+	if role == "" {
+		return nil, fmt.Errorf("no role attached to this instance")
+	}
+
+	return awsFetchCredentials(rolesURL+role, token)
+}
+
+// Starts an IMDSv2 session, returning its token, or "" to fall back to IMDSv1 if that
+// fails
+func awsInstanceToken(metadataURL string) string {
+	req, err := http.NewRequest("PUT", metadataURL+"/latest/api/token", nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", awsInstanceTokenTTL)
+
+	resp, err := handlerHTTPClient.Do(req)
+	if err != nil {
+		log.Debugf("Error getting an IMDSv2 token, falling back to IMDSv1: %s", err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		log.Debugf("Unexpected response code %d getting an IMDSv2 token, falling back to IMDSv1", resp.StatusCode)
+		return ""
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// Sends a GET to a metadata endpoint, with the IMDSv2 session token if there is one
+func awsMetadataGet(url string, token string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	return handlerHTTPClient.Do(req)
+}
+
+// Fetches temporary credentials from an instance or container metadata endpoint, with
+// the IMDSv2 session token of an instance if there is one
+func awsFetchCredentials(url string, token string) (*awsCredentials, error) {
+	resp, err := awsMetadataGet(url, token)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response code %d fetching credentials", resp.StatusCode)
+	}
+
+	var body struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("error decoding credentials: %s", err)
+	}
+
+	return &awsCredentials{
+		AccessKeyID:     body.AccessKeyID,
+		SecretAccessKey: body.SecretAccessKey,
+		SessionToken:    body.Token,
+		Expiration:      body.Expiration,
+	}, nil
+}
+
+// Signs a request using AWS Signature Version 4. The request's Host and (if there's a
+// body) Content-Type must already be set.
+func awsSignRequest(req *http.Request, body []byte, creds *awsCredentials, region string, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.Host}
+	if headers["host"] == "" {
+		headers["host"] = req.URL.Host
+	}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := awsHMAC([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = awsHMAC(key, part)
+	}
+	signature := hex.EncodeToString(awsHMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func awsHMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAWS_signRequest(t *testing.T) {
+	// The get-vanilla case from the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := &awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+
+	awsSignRequest(req, nil, creds, "us-east-1", "service", now)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, auth)
+	}
+}
+
+func TestAWS_sharedCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "credentials")
+	contents := `
+[default]
+aws_access_key_id = default_key
+aws_secret_access_key = default_secret
+
+[alerting]
+aws_access_key_id = alerting_key
+aws_secret_access_key = alerting_secret
+aws_session_token = token
+`
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+	defer os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+
+	creds, err := awsSharedCredentials("alerting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := awsCredentials{AccessKeyID: "alerting_key", SecretAccessKey: "alerting_secret", SessionToken: "token"}
+	if creds == nil || *creds != expected {
+		t.Errorf("expected %+v, got %+v", expected, creds)
+	}
+
+	creds, err = awsSharedCredentials("missing")
+	if err != nil || creds != nil {
+		t.Errorf("expected no credentials for a missing profile, got %+v, %v", creds, err)
+	}
+}
+
+// A fake instance metadata service, handing out IMDSv2 tokens unless v1Only is set
+func testInstanceMetadata(t *testing.T, v1Only bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			if v1Only || r.Method != "PUT" || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte("session"))
+			return
+		}
+
+		expected := "session"
+		if v1Only {
+			expected = ""
+		}
+		if token := r.Header.Get("X-aws-ec2-metadata-token"); token != expected {
+			t.Errorf("expected token %q requesting %s, got %q", expected, r.URL.Path, token)
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("alerting-role\n"))
+		case "/latest/meta-data/iam/security-credentials/alerting-role":
+			w.Write([]byte(`{"AccessKeyId": "instance_key", "SecretAccessKey": "instance_secret", "Token": "token", "Expiration": "2030-01-01T00:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestAWS_instanceCredentials(t *testing.T) {
+	for _, v1Only := range []bool{false, true} {
+		server := testInstanceMetadata(t, v1Only)
+		creds, err := awsInstanceCredentials(server.URL)
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.AccessKeyID != "instance_key" || creds.SecretAccessKey != "instance_secret" || creds.SessionToken != "token" || creds.Expiration.IsZero() {
+			t.Errorf("unexpected credentials with v1Only %v: %+v", v1Only, creds)
+		}
+	}
+}
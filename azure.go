@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// How long the shared access signatures of requests are valid for
+const azureSASExpiry = time.Hour
+
+// The parts of an Azure Service Bus or Event Hubs connection string, e.g.
+// Endpoint=sb://ns.servicebus.windows.net/;SharedAccessKeyName=send;SharedAccessKey=...;EntityPath=alerts
+type azureConnection struct {
+	host       string
+	keyName    string
+	key        string
+	entityPath string
+}
+
+func parseAzureConnectionString(connectionString string) (*azureConnection, error) {
+	conn := &azureConnection{}
+	for _, part := range strings.Split(connectionString, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid connection string part %q", part)
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "endpoint":
+			endpoint, err := url.Parse(kv[1])
+			if err != nil || endpoint.Host == "" {
+				return nil, fmt.Errorf("invalid Endpoint %q", kv[1])
+			}
+			conn.host = endpoint.Host
+		case "sharedaccesskeyname":
+			conn.keyName = kv[1]
+		case "sharedaccesskey":
+			conn.key = kv[1]
+		case "entitypath":
+			conn.entityPath = kv[1]
+		}
+	}
+
+	if conn.host == "" || conn.keyName == "" || conn.key == "" {
+		return nil, fmt.Errorf("connection string must have an Endpoint, SharedAccessKeyName and SharedAccessKey")
+	}
+	return conn, nil
+}
+
+// Returns the URL messages are sent to for an entity (a queue, topic or event hub)
+func (conn *azureConnection) messagesURL(entity string) string {
+	return fmt.Sprintf("https://%s/%s/messages", conn.host, entity)
+}
+
+// Builds a shared access signature token for sending to an entity
+func (conn *azureConnection) sasToken(entity string, now time.Time) string {
+	resource := url.QueryEscape(strings.ToLower(fmt.Sprintf("https://%s/%s", conn.host, entity)))
+	expiry := fmt.Sprintf("%d", now.Add(azureSASExpiry).Unix())
+
+	mac := hmac.New(sha256.New, []byte(conn.key))
+	mac.Write([]byte(resource + "\n" + expiry))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s&skn=%s",
+		resource, url.QueryEscape(signature), expiry, url.QueryEscape(conn.keyName))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The alerts waiting to be sent to a handler as a single digest
+type alertBatch struct {
+	handler    namedHandler
+	datacenter string
+
+	// The latest alert for each service/tag/node, in the order they first arrived
+	keys   []string
+	alerts map[string]*AlertState
+}
+
+// Tracks the open batches of handlers with a batch_window, keyed by handler/datacenter
+type alertBatches struct {
+	sync.Mutex
+	batches map[string]*alertBatch
+}
+
+var handlerBatches = &alertBatches{
+	batches: make(map[string]*alertBatch),
+}
+
+// Sends an alert to the named handler, adding it to the handler's current batch instead
+// if it has a batch_window. Failing alerts are re-sent every repeat_interval until they recover,
+// and recoveries aren't sent to handlers with skip_recoveries.
+func dispatchAlert(config *Config, name string, datacenter string, alert *AlertState) {
+	handler := config.namedHandler(name)
+	if interval := handler.options.RepeatInterval; interval > 0 {
+		handlerRepeats.track(handler, datacenter, alert, time.Duration(interval)*time.Second)
+	}
+	if handler.options.SkipRecoveries && isRecovery(alert) {
+		return
+	}
+
+	window := handler.options.BatchWindow
+	if window <= 0 {
+		sendAsync(handler, datacenter, alert)
+		return
+	}
+
+	handlerBatches.add(handler, name+"/"+datacenter, datacenter, alert, time.Duration(window)*time.Second)
+}
+
+// Returns whether an alert is the recovery of a failing alert, rather than a notice that
+// doesn't change status
+func isRecovery(alert *AlertState) bool {
+	return alert.Status == api.HealthPassing && alert.LastAlerted != "" && alert.LastAlerted != api.HealthPassing
+}
+
+// Adds an alert to a batch, starting a new one that's flushed after the window if
+// there isn't one open. A later alert for the same service/tag/node replaces the earlier one.
+func (b *alertBatches) add(handler namedHandler, key string, datacenter string, alert *AlertState, window time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+
+	batch, ok := b.batches[key]
+	if !ok {
+		batch = &alertBatch{handler: handler, datacenter: datacenter, alerts: make(map[string]*AlertState)}
+		b.batches[key] = batch
+		time.AfterFunc(window, func() {
+			b.flush(key, datacenter)
+		})
+	}
+
+	alertKey := alert.scopedService() + "/" + alert.Tag + "/" + alert.scopedNode() + "/" + alert.Check
+	if _, ok := batch.alerts[alertKey]; !ok {
+		batch.keys = append(batch.keys, alertKey)
+	}
+	member := *alert
+	batch.alerts[alertKey] = &member
+}
+
+// Sends a batch to its handler, as a digest if it holds more than one alert
+func (b *alertBatches) flush(key string, datacenter string) {
+	b.Lock()
+	batch, ok := b.batches[key]
+	delete(b.batches, key)
+	b.Unlock()
+
+	if !ok {
+		return
+	}
+
+	alerts := make([]*AlertState, 0, len(batch.keys))
+	for _, alertKey := range batch.keys {
+		alerts = append(alerts, batch.alerts[alertKey])
+	}
+
+	if len(alerts) == 1 {
+		batch.handler.send(datacenter, alerts[0])
+		return
+	}
+	batch.handler.send(datacenter, digestAlert(datacenter, alerts))
+}
+
+// Sends every open batch now instead of at the end of its window, as the process is
+// shutting down. The batches are sent concurrently and counted as pending alerts.
+func (b *alertBatches) flushAll() {
+	b.Lock()
+	batches := make(map[string]string, len(b.batches))
+	for key, batch := range b.batches {
+		batches[key] = batch.datacenter
+	}
+	b.Unlock()
+
+	for key, datacenter := range batches {
+		pendingAlerts.add()
+		go func(key string, datacenter string) {
+			defer pendingAlerts.done()
+			b.flush(key, datacenter)
+		}(key, datacenter)
+	}
+}
+
+// Summarizes a batch of alerts as a single alert with their worst status. The service
+// and node are only set if every alert shares them.
+func digestAlert(datacenter string, alerts []*AlertState) *AlertState {
+	statuses := make(map[string]string)
+	counts := make(map[string]int)
+	services := make([]string, 0)
+	nodes := make([]string, 0)
+	lines := make([]string, 0, len(alerts))
+
+	for i, alert := range alerts {
+		statuses[fmt.Sprint(i)] = alert.Status
+		counts[alert.Status]++
+		if alert.Service != "" && !contains(services, alert.Service) {
+			services = append(services, alert.Service)
+		}
+		if alert.Node != "" && !contains(nodes, alert.Node) {
+			nodes = append(nodes, alert.Node)
+		}
+		lines = append(lines, "=> "+alert.Message)
+	}
+	sort.Strings(services)
+	sort.Strings(nodes)
+
+	summary := make([]string, 0)
+	for _, status := range []string{api.HealthCritical, api.HealthWarning, api.HealthPassing} {
+		if counts[status] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+
+	digest := &AlertState{
+		Status:  computeHealth(statuses),
+		Message: fmt.Sprintf("[%s] %d alerts: %s", datacenter, len(alerts), strings.Join(summary, ", ")),
+	}
+	if len(services) == 1 {
+		digest.Service = services[0]
+	}
+	if len(nodes) == 1 {
+		digest.Node = nodes[0]
+	}
+
+	details := make([]string, 0)
+	if len(services) > 0 {
+		details = append(details, "Affected services: "+strings.Join(services, ", "))
+	}
+	if len(nodes) > 0 {
+		details = append(details, "Affected nodes: "+strings.Join(nodes, ", "))
+	}
+	details = append(details, "Alerts:")
+	details = append(details, lines...)
+	digest.Details = strings.Join(details, "\n")
+
+	return digest
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatch_digest(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	batches := &alertBatches{batches: make(map[string]*alertBatch)}
+	handler := namedHandler{name: "test", handler: testHandler{alertCh}}
+
+	batches.add(handler, "test/dc1", "dc1", &AlertState{Service: "web", Node: "node1", Status: "critical", Message: "web on node1 is critical"}, time.Hour)
+	batches.add(handler, "test/dc1", "dc1", &AlertState{Service: "web", Node: "node2", Status: "warning", Message: "web on node2 is warning"}, time.Hour)
+	batches.add(handler, "test/dc1", "dc1", &AlertState{Service: "api", Node: "node1", Status: "critical", Message: "api on node1 is critical"}, time.Hour)
+
+	// A later alert replaces the earlier one for the same service and node
+	batches.add(handler, "test/dc1", "dc1", &AlertState{Service: "web", Node: "node1", Status: "passing", Message: "web on node1 is passing"}, time.Hour)
+
+	batches.flush("test/dc1", "dc1")
+
+	digest := <-alertCh
+	if digest.Status != "critical" {
+		t.Errorf("expected the worst status, got %s", digest.Status)
+	}
+	if digest.Message != "[dc1] 3 alerts: 1 critical, 1 warning, 1 passing" {
+		t.Errorf("unexpected message: %s", digest.Message)
+	}
+	if digest.Service != "" {
+		t.Errorf("expected no service for a digest covering several, got %s", digest.Service)
+	}
+
+	expected := "Affected services: api, web\nAffected nodes: node1, node2\nAlerts:\n" +
+		"=> web on node1 is passing\n=> web on node2 is warning\n=> api on node1 is critical"
+	if digest.Details != expected {
+		t.Errorf("expected details:\n%s\ngot:\n%s", expected, digest.Details)
+	}
+
+	// Flushing an already flushed batch does nothing
+	batches.flush("test/dc1", "dc1")
+	if len(alertCh) != 0 {
+		t.Errorf("expected no more alerts, got %d", len(alertCh))
+	}
+}
+
+func TestBatch_single(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	config := &Config{
+		Handlers:       map[string]AlertHandler{"test": testHandler{alertCh}},
+		HandlerOptions: map[string]HandlerOptions{"test": HandlerOptions{BatchWindow: 1}},
+	}
+
+	dispatchAlert(config, "test", "dc1", &AlertState{Service: "web", Status: "critical", Message: "web is critical"})
+	if len(alertCh) != 0 {
+		t.Fatal("expected the alert to be held until the window ends")
+	}
+
+	select {
+	case alert := <-alertCh:
+		if alert.Message != "web is critical" || strings.Contains(alert.Details, "Alerts:") {
+			t.Errorf("expected a lone alert to be sent unchanged, got %+v", alert)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the batch to be flushed")
+	}
+}
+
+func TestBatch_skipRecoveries(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	config := &Config{
+		Handlers:       map[string]AlertHandler{"test": testHandler{alertCh}},
+		HandlerOptions: map[string]HandlerOptions{"test": HandlerOptions{SkipRecoveries: true}},
+	}
+
+	dispatchAlert(config, "test", "dc1", &AlertState{Service: "web", Status: "passing", LastAlerted: "critical", Message: "web is passing"})
+	dispatchAlert(config, "test", "dc1", &AlertState{Service: "web", Status: "critical", LastAlerted: "passing", Message: "web is critical"})
+	select {
+	case alert := <-alertCh:
+		if alert.Message != "web is critical" {
+			t.Errorf("expected only the failure to be sent, got %q", alert.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the failure")
+	}
+
+	// Notices that don't change status aren't recoveries
+	dispatchAlert(config, "test", "dc1", &AlertState{Service: "consul-kv", Status: "passing", LastAlerted: "passing", Message: "key changed"})
+	select {
+	case alert := <-alertCh:
+		if alert.Message != "key changed" {
+			t.Errorf("expected the notice to be sent, got %q", alert.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the notice")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The default number of messages a bus handler buffers while disconnected
+const defaultBusBufferSize = 1000
+
+// The bounds of the backoff between reconnection attempts to a message bus
+const busMinBackoff = 1 * time.Second
+const busMaxBackoff = 2 * time.Minute
+
+var busBufferedMessages = metrics.register("consul_alerting_bus_buffered_messages",
+	"Messages waiting to be published by a message bus handler.", "gauge")
+var busDroppedMessages = metrics.register("consul_alerting_bus_dropped_messages_total",
+	"Messages dropped by a message bus handler because its buffer was full.", "counter")
+
+// Options shared by handlers publishing to a message bus
+type BusOptions struct {
+	BufferSize int  `mapstructure:"buffer_size"`
+	DropOldest bool `mapstructure:"drop_oldest"`
+}
+
+// A long-lived connection to a message bus. The key identifies the alert a message is
+// about, for buses that can use it to keep related messages in order.
+type busConn interface {
+	Publish(topic string, key string, payload []byte) error
+	Close() error
+}
+
+// Opens a new connection to a message bus
+type busDialer func() (busConn, error)
+
+// A message waiting to be published
+type busMessage struct {
+	seq     uint64
+	topic   string
+	key     string
+	payload []byte
+}
+
+// Publishes messages to a message bus over a long-lived connection. Messages are buffered
+// (up to a limit) while the connection is down, and flushed once it has been re-established
+// with exponential backoff between attempts. Publishing never blocks the caller.
+type busPublisher struct {
+	// The handler name, used for logging and metrics
+	name string
+
+	dial    busDialer
+	options BusOptions
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	lock    sync.Mutex
+	queue   []busMessage
+	nextSeq uint64
+	wakeCh  chan struct{}
+	start   sync.Once
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// Creates a publisher for the named handler; it connects on the first published message
+func newBusPublisher(name string, dial busDialer, options BusOptions) *busPublisher {
+	if options.BufferSize <= 0 {
+		options.BufferSize = defaultBusBufferSize
+	}
+
+	return &busPublisher{
+		name:       name,
+		dial:       dial,
+		options:    options,
+		minBackoff: busMinBackoff,
+		maxBackoff: busMaxBackoff,
+		wakeCh:     make(chan struct{}, 1),
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Queues a message to be published, dropping a message if the buffer is full. Returns an
+// error if it was the new message that got dropped.
+func (p *busPublisher) publish(topic string, key string, payload []byte) error {
+	p.start.Do(func() { go p.run() })
+
+	p.lock.Lock()
+	if len(p.queue) >= p.options.BufferSize {
+		busDroppedMessages.add(1, "handler", p.name)
+		if !p.options.DropOldest {
+			p.lock.Unlock()
+			log.Errorf("Buffer for %s is full, dropping alert", p.name)
+			return fmt.Errorf("buffer for %s is full", p.name)
+		}
+		log.Errorf("Buffer for %s is full, dropping oldest alert", p.name)
+		p.queue = p.queue[1:]
+	}
+	p.nextSeq++
+	p.queue = append(p.queue, busMessage{p.nextSeq, topic, key, payload})
+	busBufferedMessages.set(float64(len(p.queue)), "handler", p.name)
+	p.lock.Unlock()
+
+	select {
+	case p.wakeCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Returns the number of messages waiting to be published
+func (p *busPublisher) buffered() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.queue)
+}
+
+// Stops publishing and closes the connection, once the handler has been replaced by a
+// reload. Messages still buffered are dropped.
+func (p *busPublisher) stop() {
+	p.stopOnce.Do(func() { close(p.stopCh) })
+}
+
+// Connects to the bus and publishes queued messages, reconnecting after any failure
+func (p *busPublisher) run() {
+	var conn busConn
+	backoff := p.minBackoff
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+		if dropped := p.buffered(); dropped > 0 {
+			log.Warnf("Dropping %d buffered alerts for %s, its handler was replaced", dropped, p.name)
+		}
+	}()
+
+	// Sleeps for the current backoff with some jitter, then doubles it. Returns false if
+	// the publisher was stopped in the meantime.
+	wait := func() bool {
+		jitter := time.Duration(rand.Int63n(int64(backoff)/2 + 1))
+		select {
+		case <-time.After(backoff/2 + jitter):
+		case <-p.stopCh:
+			return false
+		}
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		default:
+		}
+
+		p.lock.Lock()
+		empty := len(p.queue) == 0
+		p.lock.Unlock()
+		if empty {
+			select {
+			case <-p.wakeCh:
+			case <-p.stopCh:
+			}
+			continue
+		}
+
+		if conn == nil {
+			var err error
+			conn, err = p.dial()
+			if err != nil {
+				log.Errorf("Error connecting %s: %s, retrying in %s", p.name, err, backoff)
+				conn = nil
+				if !wait() {
+					return
+				}
+				continue
+			}
+			log.Infof("Connected %s", p.name)
+			backoff = p.minBackoff
+		}
+
+		p.lock.Lock()
+		message := p.queue[0]
+		p.lock.Unlock()
+
+		if err := conn.Publish(message.topic, message.key, message.payload); err != nil {
+			log.Errorf("Error publishing alert to %s: %s, reconnecting", p.name, err)
+			conn.Close()
+			conn = nil
+			if !wait() {
+				return
+			}
+			continue
+		}
+
+		// Remove the message now that it's been published, unless it was already
+		// dropped to make room for newer ones
+		p.lock.Lock()
+		if len(p.queue) > 0 && p.queue[0].seq == message.seq {
+			p.queue = p.queue[1:]
+		}
+		busBufferedMessages.set(float64(len(p.queue)), "handler", p.name)
+		p.lock.Unlock()
+	}
+}
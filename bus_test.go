@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake bus connection that records published messages, failing while down is set
+type testBusConn struct {
+	sync.Mutex
+	down      bool
+	published []string
+}
+
+func (c *testBusConn) Publish(topic string, key string, payload []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.down {
+		return errors.New("connection lost")
+	}
+	c.published = append(c.published, string(payload))
+	return nil
+}
+
+func (c *testBusConn) Close() error {
+	return nil
+}
+
+func (c *testBusConn) setDown(down bool) {
+	c.Lock()
+	c.down = down
+	c.Unlock()
+}
+
+func (c *testBusConn) messages() []string {
+	c.Lock()
+	defer c.Unlock()
+	return append([]string{}, c.published...)
+}
+
+// Start a publisher with short backoffs for testing
+func testBusPublisher(conn *testBusConn, options BusOptions) *busPublisher {
+	publisher := newBusPublisher("test", func() (busConn, error) {
+		conn.Lock()
+		defer conn.Unlock()
+		if conn.down {
+			return nil, errors.New("connection refused")
+		}
+		return conn, nil
+	}, options)
+	publisher.minBackoff = 10 * time.Millisecond
+	publisher.maxBackoff = 20 * time.Millisecond
+	return publisher
+}
+
+func waitForMessages(t *testing.T, conn *testBusConn, count int) []string {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if messages := conn.messages(); len(messages) >= count {
+			return messages
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d messages, got %v", count, conn.messages())
+	return nil
+}
+
+// Messages published during an outage should be buffered and flushed in order on reconnect
+func TestBus_bufferDuringOutage(t *testing.T) {
+	conn := &testBusConn{}
+	publisher := testBusPublisher(conn, BusOptions{})
+
+	publisher.publish("alerts", "", []byte("1"))
+	waitForMessages(t, conn, 1)
+
+	conn.setDown(true)
+	publisher.publish("alerts", "", []byte("2"))
+	publisher.publish("alerts", "", []byte("3"))
+	time.Sleep(50 * time.Millisecond)
+
+	conn.setDown(false)
+	messages := waitForMessages(t, conn, 3)
+	if messages[1] != "2" || messages[2] != "3" {
+		t.Errorf("expected buffered messages to be flushed in order, got %v", messages)
+	}
+}
+
+// Make sure overflowing the buffer drops the oldest or newest message depending on the config
+func TestBus_overflow(t *testing.T) {
+	for _, dropOldest := range []bool{true, false} {
+		conn := &testBusConn{down: true}
+		publisher := testBusPublisher(conn, BusOptions{BufferSize: 2, DropOldest: dropOldest})
+
+		publisher.publish("alerts", "", []byte("1"))
+		publisher.publish("alerts", "", []byte("2"))
+		publisher.publish("alerts", "", []byte("3"))
+
+		if buffered := publisher.buffered(); buffered != 2 {
+			t.Fatalf("expected 2 buffered messages, got %d", buffered)
+		}
+
+		conn.setDown(false)
+		messages := waitForMessages(t, conn, 2)
+
+		expected := []string{"1", "2"}
+		if dropOldest {
+			expected = []string{"2", "3"}
+		}
+		if messages[0] != expected[0] || messages[1] != expected[1] {
+			t.Errorf("drop_oldest=%v: expected %v, got %v", dropOldest, expected, messages)
+		}
+	}
+}
+
+// A stopped publisher should give up on its buffered messages rather than reconnect
+func TestBus_stop(t *testing.T) {
+	conn := &testBusConn{down: true}
+	publisher := testBusPublisher(conn, BusOptions{})
+
+	publisher.publish("alerts", "", []byte("1"))
+	publisher.stop()
+	publisher.stop()
+	conn.setDown(false)
+
+	time.Sleep(100 * time.Millisecond)
+	if messages := conn.messages(); len(messages) != 0 {
+		t.Errorf("expected nothing to be published once stopped, got %v", messages)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+)
+
+// The service tag that keeps a service from getting a watch, e.g. "alerting.enabled=false"
+const enabledTag = severityTagPrefix + "enabled=false"
+
+// The service settings that can be overridden by "alerting.<setting>=<value>" service tags
+// or "alerting.<setting>" service meta keys registered in the catalog
+var catalogServiceSettings = []string{
+	"change_threshold",
+	"alert_after",
+	"failures_before_alert",
+	"passes_before_recovery",
+	"dedupe_cooldown",
+	"flap_threshold",
+	"flap_window",
+	"escalation",
+	"aggregation",
+}
+
+// An instance of a service in the catalog along with its metadata, which the vendored API
+// client's CatalogService doesn't include
+type catalogServiceMeta struct {
+	Node        string
+	ServiceTags []string
+	ServiceMeta map[string]string
+}
+
+// Merges the tags of the service's instances with their "alerting." meta keys, which are
+// turned into tags of the form "alerting.<key>=<value>". Meta keys take precedence over
+// tags setting the same key, so each key ends up with a single tag.
+func mergeCatalogTags(instances []catalogServiceMeta) []string {
+	tags := make([]string, 0)
+	settings := make(map[string]string)
+	for _, instance := range instances {
+		for _, tag := range instance.ServiceTags {
+			if !strings.HasPrefix(tag, severityTagPrefix) {
+				if !contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+				continue
+			}
+			parts := strings.SplitN(strings.TrimPrefix(tag, severityTagPrefix), "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if _, ok := settings[parts[0]]; !ok {
+				settings[parts[0]] = parts[1]
+			}
+		}
+	}
+	for _, instance := range instances {
+		for key, value := range instance.ServiceMeta {
+			if strings.HasPrefix(key, severityTagPrefix) {
+				settings[strings.TrimPrefix(key, severityTagPrefix)] = value
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tags = append(tags, severityTagPrefix+key+"="+settings[key])
+	}
+	return tags
+}
+
+// Returns a copy of the config with the service's settings overridden by its
+// "alerting.<setting>=<value>" tags. Settings with invalid values are logged and ignored.
+func (config *Config) withCatalogSettings(service string, tags []string) *Config {
+	overrides := make(map[string]interface{})
+	for _, tag := range tags {
+		parts := strings.SplitN(strings.TrimPrefix(tag, severityTagPrefix), "=", 2)
+		if !strings.HasPrefix(tag, severityTagPrefix) || len(parts) != 2 || !contains(catalogServiceSettings, parts[0]) {
+			continue
+		}
+		overrides[parts[0]] = parts[1]
+	}
+	if len(overrides) == 0 {
+		return config
+	}
+
+	serviceConfig := config.serviceConfig(service)
+	if serviceConfig == nil {
+		serviceConfig = &ServiceConfig{
+			Name:                 service,
+			ChangeThreshold:      config.ChangeThreshold,
+			AlertAfter:           config.AlertAfter,
+			Escalation:           config.Escalation,
+			Aggregation:          config.Aggregation,
+			DedupeCooldown:       config.DedupeCooldown,
+			FlapThreshold:        config.FlapThreshold,
+			FlapWindow:           config.FlapWindow,
+			FailuresBeforeAlert:  config.FailuresBeforeAlert,
+			PassesBeforeRecovery: config.PassesBeforeRecovery,
+		}
+	}
+
+	for key, value := range overrides {
+		overridden := *serviceConfig
+		if err := mapstructure.WeakDecode(map[string]interface{}{key: value}, &overridden); err != nil {
+			log.Warnf("Ignoring invalid %s%s for service %s: %s", severityTagPrefix, key, service, err)
+			continue
+		}
+		if err := config.validateCatalogSetting(key, &overridden); err != nil {
+			log.Warnf("Ignoring invalid %s%s for service %s: %s", severityTagPrefix, key, service, err)
+			continue
+		}
+		serviceConfig = &overridden
+	}
+
+	copied := *config
+	copied.Services = make(map[string]ServiceConfig, len(config.Services)+1)
+	for name, s := range config.Services {
+		copied.Services[name] = s
+	}
+	copied.Services[service] = *serviceConfig
+	return &copied
+}
+
+// Makes sure a setting overridden from the catalog has a value the config file would accept
+func (config *Config) validateCatalogSetting(key string, service *ServiceConfig) error {
+	switch key {
+	case "alert_after":
+		return validateAlertAfter(service.AlertAfter)
+	case "aggregation":
+		if !contains([]string{AggregateNone, AggregateNode, AggregateService, AggregateDatacenter, AggregateInstances}, service.Aggregation) {
+			return fmt.Errorf("unknown aggregation %s", service.Aggregation)
+		}
+	case "escalation":
+		if _, ok := config.Escalations[service.Escalation]; !ok && service.Escalation != "" {
+			return fmt.Errorf("unknown escalation %s", service.Escalation)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestCatalogSettings_mergeTags(t *testing.T) {
+	tags := mergeCatalogTags([]catalogServiceMeta{
+		{Node: "node1", ServiceTags: []string{"primary", "alerting.change_threshold=30", "alerting.handlers=slack"}},
+		{Node: "node2", ServiceTags: []string{"replica", "primary"}, ServiceMeta: map[string]string{
+			"alerting.change_threshold": "10",
+			"version":                   "4.0",
+		}},
+	})
+
+	expected := []string{"primary", "replica", "alerting.change_threshold=10", "alerting.handlers=slack"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %v, got %v", expected, tags)
+	}
+}
+
+func TestCatalogSettings_overrides(t *testing.T) {
+	config, err := ParseConfig(`
+change_threshold = 60
+escalation_policy "default" {
+  step {
+    after = 600
+    handlers = ["stdout.log"]
+  }
+}
+handler "stdout" "log" {}
+service "redis" {
+  change_threshold = 20
+  failures_before_alert = 2
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No settings in the tags leaves the config as it is
+	if copied := config.withCatalogSettings("redis", []string{"primary", "alerting.handlers=slack"}); copied != config {
+		t.Errorf("expected the config to be unchanged")
+	}
+
+	copied := config.withCatalogSettings("redis", []string{
+		"alerting.change_threshold=5",
+		"alerting.alert_after=2m",
+		"alerting.escalation=missing",
+		"alerting.flap_threshold=often",
+	})
+	if threshold := copied.serviceChangeThreshold("redis"); threshold != 5 {
+		t.Errorf("expected a change threshold of 5, got %d", threshold)
+	}
+	if delay := copied.serviceAlertDelay("redis", api.HealthCritical); delay != 2*time.Minute {
+		t.Errorf("expected an alert delay of 2m, got %s", delay)
+	}
+	if required := copied.serviceObservationsRequired("redis", api.HealthCritical); required != 2 {
+		t.Errorf("expected the service's failures_before_alert to be kept, got %d", required)
+	}
+	if copied.serviceEscalation("redis") != nil {
+		t.Errorf("expected the unknown escalation to be ignored")
+	}
+	if threshold, _ := copied.serviceFlapDetection("redis"); threshold != 0 {
+		t.Errorf("expected the invalid flap_threshold to be ignored, got %d", threshold)
+	}
+	if threshold := config.serviceChangeThreshold("redis"); threshold != 20 {
+		t.Errorf("expected the original config to be unchanged, got %d", threshold)
+	}
+
+	// Services without a config block start from the global settings
+	copied = config.withCatalogSettings("web", []string{"alerting.escalation=default"})
+	if policy := copied.serviceEscalation("web"); policy == nil || policy.Name != "default" {
+		t.Errorf("expected the default escalation, got %+v", policy)
+	}
+	if threshold := copied.serviceChangeThreshold("web"); threshold != 60 {
+		t.Errorf("expected the global change threshold, got %d", threshold)
+	}
+
+	if config.watchesService("web", []string{"alerting.enabled=false"}) {
+		t.Errorf("expected the service to opt out of alerting")
+	}
+}
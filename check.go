@@ -20,14 +20,19 @@ type CheckState struct {
 // Returns a map of nodename/checkname strings to CheckStates from the given KV prefix
 func getCheckStates(kvPath string, client *api.Client) (map[string]*CheckState, error) {
 	checkStates := make(map[string]*CheckState)
-	keys, _, err := client.KV().Keys(kvPath, "", nil)
+	values, err := stateKV(client).list(kvPath)
 
 	if err != nil {
 		log.Error("Error loading previous check states: ", err)
 		return checkStates, err
 	}
 
-	for _, path := range keys {
+	for path := range values {
+		// Skip the alert states stored for grouped alerts under this prefix
+		if strings.HasPrefix(path, kvPath+alertGroupsPath) {
+			continue
+		}
+
 		checkState, err := getCheckState(path, client)
 
 		if err != nil {
@@ -49,7 +54,7 @@ func getCheckStates(kvPath string, client *api.Client) (map[string]*CheckState,
 
 // Parses a CheckState from a given Consul K/V path
 func getCheckState(kvPath string, client *api.Client) (*CheckState, error) {
-	kvPair, _, err := client.KV().Get(kvPath, nil)
+	value, ok, err := stateKV(client).get(kvPath)
 	check := &CheckState{}
 
 	if err != nil {
@@ -57,15 +62,15 @@ func getCheckState(kvPath string, client *api.Client) (*CheckState, error) {
 		return nil, err
 	}
 
-	if kvPair == nil {
+	if !ok {
 		return check, nil
 	}
 
-	if string(kvPair.Value) == "" {
+	if string(value) == "" {
 		return nil, nil
 	}
 
-	err = json.Unmarshal(kvPair.Value, check)
+	err = json.Unmarshal(value, check)
 
 	if err != nil {
 		log.Error("Error parsing check state: ", err)
@@ -80,11 +85,12 @@ type CheckUpdate struct {
 	*api.HealthCheck
 }
 
-// Updates the last known state of a check in Consul. Returns true if succeeded.
-func updateCheckState(update CheckUpdate, client *api.Client) bool {
+// Updates the last known state of a check in Consul under the given KV root. Returns true
+// if succeeded.
+func updateCheckState(kvRoot string, update CheckUpdate, client *api.Client) bool {
 	check := update.HealthCheck
 
-	kvPath := alertingKVRoot
+	kvPath := kvRoot
 
 	if check.ServiceID != "" {
 		tagPath := ""
@@ -104,10 +110,7 @@ func updateCheckState(update CheckUpdate, client *api.Client) bool {
 		return false
 	}
 
-	_, err = client.KV().Put(&api.KVPair{
-		Key:   kvPath,
-		Value: status,
-	}, nil)
+	err = stateKV(client).put(kvPath, status)
 
 	if err != nil {
 		log.Errorf("Error storing state for alert in Consul: %s", err)
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/mapstructure"
+)
+
+// The settings of a check with its own check block in a service, which is alerted on
+// separately from the rest of the service. Settings that aren't given are taken from the
+// service.
+type CheckConfig struct {
+	// The check ID or name the block is for
+	Name                 string
+	Handlers             []string `mapstructure:"handlers"`
+	ChangeThreshold      int      `mapstructure:"change_threshold"`
+	AlertAfter           string   `mapstructure:"alert_after"`
+	FailuresBeforeAlert  int      `mapstructure:"failures_before_alert"`
+	PassesBeforeRecovery int      `mapstructure:"passes_before_recovery"`
+}
+
+// Parse the raw check blocks of a service, defaulting their settings to the service's
+func parseServiceChecks(raw interface{}, service *ServiceConfig) error {
+	service.Checks = make(map[string]CheckConfig)
+
+	blocks, ok := raw.([]map[string]interface{})
+	if !ok {
+		return fmt.Errorf("check blocks in service %s must be in the form 'check \"id\" {}'", service.Name)
+	}
+	for _, block := range blocks {
+		for name, body := range block {
+			bodies, ok := body.([]map[string]interface{})
+			if !ok {
+				return fmt.Errorf("check blocks in service %s must be in the form 'check \"id\" {}'", service.Name)
+			}
+
+			check := CheckConfig{
+				Name:                 name,
+				ChangeThreshold:      service.ChangeThreshold,
+				AlertAfter:           service.AlertAfter,
+				FailuresBeforeAlert:  service.FailuresBeforeAlert,
+				PassesBeforeRecovery: service.PassesBeforeRecovery,
+			}
+			for _, m := range bodies {
+				if err := mapstructure.WeakDecode(m, &check); err != nil {
+					return fmt.Errorf("Error loading check %s in service %s: %s", name, service.Name, err)
+				}
+			}
+			if err := validateAlertAfter(check.AlertAfter); err != nil {
+				return fmt.Errorf("Error loading check %s in service %s: %s", name, service.Name, err)
+			}
+			service.Checks[name] = check
+		}
+	}
+
+	return nil
+}
+
+// Returns the check block of a service a check is configured by, matching its check ID
+// before its name, or nil if it has none
+func (c *Config) checkConfig(service string, check *api.HealthCheck) *CheckConfig {
+	serviceConfig := c.serviceConfig(service)
+	if serviceConfig == nil {
+		return nil
+	}
+	if checkConfig, ok := serviceConfig.Checks[check.CheckID]; ok {
+		return &checkConfig
+	}
+	if checkConfig, ok := serviceConfig.Checks[check.Name]; ok {
+		return &checkConfig
+	}
+	return nil
+}
+
+// Returns the check blocks of the checks alerted on separately, keyed by node/checkID hash
+func (c *Config) separateChecks(service string, checks []*api.HealthCheck) map[string]*CheckConfig {
+	separate := make(map[string]*CheckConfig)
+	for _, check := range checks {
+		if checkConfig := c.checkConfig(service, check); checkConfig != nil {
+			separate[check.Node+"/"+check.CheckID] = checkConfig
+		}
+	}
+	return separate
+}
+
+// Returns whether a check matches the global or the service's ignored_checks by its
+// check ID or name. The check exported with state_export_check is always ignored, so
+// the open alerts don't raise an alert of their own.
+func (c *Config) ignoresCheck(service string, check *api.HealthCheck) bool {
+	if check.CheckID == stateExportCheckID {
+		return true
+	}
+	patterns := c.ignoredChecks
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		patterns = append(append([]*regexp.Regexp{}, patterns...), serviceConfig.ignoredChecks...)
+	}
+	return matchesAny(patterns, check.CheckID) || matchesAny(patterns, check.Name)
+}
+
+// Leaves the ignored checks out of a watch's checks, returning the checks kept and the
+// node/checkID hashes of the ones left out
+func (c *Config) filterIgnoredChecks(service string, checks []*api.HealthCheck) ([]*api.HealthCheck, []string) {
+	kept := make([]*api.HealthCheck, 0, len(checks))
+	var ignored []string
+	for _, check := range checks {
+		if c.ignoresCheck(service, check) {
+			ignored = append(ignored, check.Node+"/"+check.CheckID)
+			continue
+		}
+		kept = append(kept, check)
+	}
+	return kept, ignored
+}
+
+// Returns a copy of the config with the service's settings replaced by those of one of
+// its check blocks. The check's handlers take precedence over the service's tag_handlers.
+func (c *Config) withCheckSettings(service string, check *CheckConfig) *Config {
+	if check == nil {
+		return c
+	}
+
+	serviceConfig := ServiceConfig{Name: service}
+	if s := c.serviceConfig(service); s != nil {
+		serviceConfig = *s
+	}
+	serviceConfig.ChangeThreshold = check.ChangeThreshold
+	serviceConfig.AlertAfter = check.AlertAfter
+	serviceConfig.FailuresBeforeAlert = check.FailuresBeforeAlert
+	serviceConfig.PassesBeforeRecovery = check.PassesBeforeRecovery
+	if len(check.Handlers) > 0 {
+		serviceConfig.Handlers = check.Handlers
+		serviceConfig.TagHandlers = nil
+	}
+
+	copied := *c
+	copied.Services = make(map[string]ServiceConfig, len(c.Services))
+	for name, s := range c.Services {
+		copied.Services[name] = s
+	}
+	copied.Services[service] = serviceConfig
+	return &copied
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestCheckConfig_parse(t *testing.T) {
+	config, err := ParseConfig(`
+ignored_checks = ["serfHealth"]
+
+service "web" {
+  change_threshold = 30
+  handlers = ["stdout.ops"]
+  ignored_checks = ["debug-.*"]
+
+  check "disk-usage" {
+    change_threshold = 900
+    handlers = ["stdout.infra"]
+  }
+}
+
+handler "stdout" "ops" {}
+handler "stdout" "infra" {}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := CheckConfig{
+		Name:                 "disk-usage",
+		Handlers:             []string{"stdout.infra"},
+		ChangeThreshold:      900,
+		FailuresBeforeAlert:  1,
+		PassesBeforeRecovery: 1,
+	}
+	if check := config.Services["web"].Checks["disk-usage"]; !reflect.DeepEqual(check, expected) {
+		t.Errorf("expected %+v, got %+v", expected, check)
+	}
+
+	checks := []*api.HealthCheck{
+		{Node: "node1", CheckID: "service:web", Name: "HTTP"},
+		{Node: "node1", CheckID: "check-2", Name: "disk-usage"},
+		{Node: "node1", CheckID: "debug-pprof", Name: "pprof"},
+		{Node: "node1", CheckID: "serfHealth", Name: "Serf Health Status"},
+	}
+	kept, ignored := config.filterIgnoredChecks("web", checks)
+	if len(kept) != 2 || !reflect.DeepEqual(ignored, []string{"node1/debug-pprof", "node1/serfHealth"}) {
+		t.Errorf("expected the debug and serf checks to be ignored, got %v", ignored)
+	}
+	if _, ignored := config.filterIgnoredChecks("", checks); len(ignored) != 1 {
+		t.Errorf("expected only the global ignored_checks on node watches, got %v", ignored)
+	}
+
+	// The disk check matches by name and is grouped on its own
+	separate := config.separateChecks("web", kept)
+	groups := groupCheckStatuses(ServiceWatch, AggregateService, map[string]string{
+		"node1/service:web": api.HealthPassing,
+		"node1/check-2":     api.HealthWarning,
+	}, separate)
+	if len(groups) != 2 || groups["node1/check-2"]["node1/check-2"] != api.HealthWarning {
+		t.Errorf("expected the disk check in its own group, got %v", groups)
+	}
+
+	checkConfig := config.withCheckSettings("web", separate["node1/check-2"])
+	if threshold := checkConfig.serviceChangeThreshold("web"); threshold != 900 {
+		t.Errorf("expected the check's change threshold, got %d", threshold)
+	}
+	if names := checkConfig.serviceHandlerNames("web", nil); !reflect.DeepEqual(names, []string{"stdout.infra"}) {
+		t.Errorf("expected the check's handlers, got %v", names)
+	}
+	if threshold := config.serviceChangeThreshold("web"); threshold != 30 {
+		t.Errorf("expected the service's change threshold to be unchanged, got %d", threshold)
+	}
+
+	if _, err := ParseConfig(`service "web" {
+  check "disk" {
+    handlers = ["missing"]
+  }
+}`); err == nil || !strings.Contains(err.Error(), "Unknown handler missing in service web") {
+		t.Errorf("expected an error for an unknown check handler, got %v", err)
+	}
+}
@@ -8,7 +8,7 @@ import (
 )
 
 func testSetCheckState(update CheckUpdate, client *api.Client, t *testing.T) {
-	success := updateCheckState(update, client)
+	success := updateCheckState(alertingKVRoot, update, client)
 
 	if !success {
 		t.Fatal("Failed to write check state to Consul")
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var circuitBreakerOpen = metrics.register("consul_alerting_circuit_breaker_open",
+	"Whether the circuit breaker of a handler is open, skipping its sends.", "gauge")
+
+// How long a circuit breaker stays open if the handler doesn't set circuit_breaker_cooldown
+const defaultCircuitBreakerCooldown = 60 * time.Second
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// The state of a handler's circuit breaker. It opens once the handler has failed threshold
+// sends in a row, and after the cooldown lets a single send through to probe the handler:
+// success closes it again, failure keeps it open for another cooldown.
+type circuitBreaker struct {
+	failures int
+	open     bool
+	probing  bool
+	openedAt time.Time
+}
+
+// Tracks the circuit breakers of the handlers with a circuit_breaker_threshold, keyed by
+// handler name
+type circuitBreakers struct {
+	sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+var handlerCircuitBreakers = &circuitBreakers{
+	breakers: make(map[string]*circuitBreaker),
+}
+
+func (h namedHandler) circuitBreakerCooldown() time.Duration {
+	if h.options.CircuitBreakerCooldown > 0 {
+		return time.Duration(h.options.CircuitBreakerCooldown) * time.Second
+	}
+	return defaultCircuitBreakerCooldown
+}
+
+// Returns whether the handler can be sent an alert now. While the breaker is open sends
+// are skipped, apart from one probe once the cooldown has passed.
+func (c *circuitBreakers) allow(handler namedHandler, now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	breaker, ok := c.breakers[handler.name]
+	if !ok || !breaker.open {
+		return true
+	}
+	if breaker.probing || now.Sub(breaker.openedAt) < handler.circuitBreakerCooldown() {
+		return false
+	}
+	breaker.probing = true
+	log.Infof("Circuit breaker of handler %s is half-open, trying a send", handler.name)
+	return true
+}
+
+// Records the result of a send to the handler, opening or closing its breaker
+func (c *circuitBreakers) record(handler namedHandler, err error, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	breaker, ok := c.breakers[handler.name]
+	if !ok {
+		breaker = &circuitBreaker{}
+		c.breakers[handler.name] = breaker
+	}
+
+	if err == nil {
+		if breaker.open {
+			log.Infof("Circuit breaker of handler %s closed, the handler is delivering alerts again", handler.name)
+			circuitBreakerOpen.set(0, "handler", handler.name)
+		}
+		*breaker = circuitBreaker{}
+		return
+	}
+
+	breaker.failures++
+	if breaker.open {
+		if breaker.probing {
+			breaker.probing = false
+			breaker.openedAt = now
+			log.Warnf("Circuit breaker of handler %s stays open for %s: %s", handler.name, handler.circuitBreakerCooldown(), err)
+		}
+		return
+	}
+	if breaker.failures >= handler.options.CircuitBreakerThreshold {
+		breaker.open = true
+		breaker.openedAt = now
+		circuitBreakerOpen.set(1, "handler", handler.name)
+		log.Warnf("Circuit breaker of handler %s opened after %d failed sends, skipping its sends for %s",
+			handler.name, breaker.failures, handler.circuitBreakerCooldown())
+	}
+}
+
+// Returns whether the handler's circuit breaker is open
+func (c *circuitBreakers) isOpen(name string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	breaker, ok := c.breakers[name]
+	return ok && breaker.open
+}
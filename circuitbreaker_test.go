@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_opensAndCloses(t *testing.T) {
+	breakers := &circuitBreakers{breakers: make(map[string]*circuitBreaker)}
+	handler := namedHandler{name: "slack.ops", options: HandlerOptions{CircuitBreakerThreshold: 3, CircuitBreakerCooldown: 60}}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !breakers.allow(handler, now) {
+			t.Fatalf("expected send %d to be allowed", i)
+		}
+		breakers.record(handler, errCircuitOpen, now)
+	}
+	if breakers.allow(handler, now.Add(30*time.Second)) {
+		t.Fatal("expected the breaker to open after 3 failures")
+	}
+
+	// After the cooldown a single probe goes through
+	probe := now.Add(time.Minute)
+	if !breakers.allow(handler, probe) || breakers.allow(handler, probe) {
+		t.Fatal("expected exactly one probe after the cooldown")
+	}
+	breakers.record(handler, errCircuitOpen, probe)
+	if breakers.allow(handler, probe.Add(30*time.Second)) {
+		t.Fatal("expected a failed probe to keep the breaker open")
+	}
+
+	probe = probe.Add(time.Minute)
+	if !breakers.allow(handler, probe) {
+		t.Fatal("expected a probe after the second cooldown")
+	}
+	breakers.record(handler, nil, probe)
+	if !breakers.allow(handler, probe) || !breakers.allow(handler, probe) {
+		t.Error("expected a successful probe to close the breaker")
+	}
+}
+
+func TestCircuitBreaker_skipsHandler(t *testing.T) {
+	down := int32(1)
+	alertCh := make(chan *AlertState, 10)
+	handler := namedHandler{
+		name:    "flaky",
+		handler: flakyHandler{&down, alertCh},
+		options: HandlerOptions{CircuitBreakerThreshold: 1},
+	}
+	defer handlerCircuitBreakers.record(handler, nil, time.Now())
+
+	alert := &AlertState{Service: "redis", Status: "critical"}
+	if err := handler.call("dc1", alert); err == nil || err == errCircuitOpen {
+		t.Fatalf("expected the handler's error, got %v", err)
+	}
+
+	atomic.StoreInt32(&down, 0)
+	if err := handler.call("dc1", alert); err != errCircuitOpen {
+		t.Errorf("expected the open breaker to skip the handler, got %v", err)
+	}
+	if len(alertCh) != 0 {
+		t.Errorf("expected no alerts while the breaker is open, got %d", len(alertCh))
+	}
+}
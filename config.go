@@ -3,7 +3,14 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/hcl"
 	"github.com/hashicorp/hcl/hcl/ast"
 	"github.com/mitchellh/mapstructure"
@@ -13,40 +20,437 @@ import (
 const LocalMode = "local"
 const GlobalMode = "global"
 
+// Aggregation levels, controlling how check transitions are grouped into alerts
+const AggregateNone = "none"
+const AggregateNode = "node"
+const AggregateService = "service"
+const AggregateDatacenter = "datacenter"
+const AggregateInstances = "instances"
+
+// The default handler classes; handlers that page someone and those that only notify
+const PagingClass = "paging"
+const NotifyClass = "notify"
+
+// The handler classes alerts of each status are sent to, unless overridden
+var defaultSeverityClasses = map[string][]string{
+	api.HealthWarning:  []string{NotifyClass},
+	api.HealthCritical: []string{NotifyClass, PagingClass},
+}
+
+// The prefix for service tags overriding severity classes, e.g. "alerting.warning=notify,paging"
+const severityTagPrefix = "alerting."
+
+// The service tag overriding the handlers of a service, e.g. "alerting.handlers=slack.batch"
+const handlersTagPrefix = "alerting.handlers="
+
 type Config struct {
 	ConsulAddress    string   `mapstructure:"consul_address"`
 	ConsulToken      string   `mapstructure:"consul_token"`
 	ConsulDatacenter string   `mapstructure:"datacenter"`
+	Datacenters      []string `mapstructure:"datacenters"`
 	DevMode          bool     `mapstructure:"dev_mode"`
 	NodeWatch        string   `mapstructure:"node_watch"`
 	ServiceWatch     string   `mapstructure:"service_watch"`
 	ChangeThreshold  int      `mapstructure:"change_threshold"`
+	DedupeCooldown   int      `mapstructure:"dedupe_cooldown"`
+	FlapThreshold    int      `mapstructure:"flap_threshold"`
+	FlapWindow       int      `mapstructure:"flap_window"`
+	Aggregation      string   `mapstructure:"aggregation"`
 	DefaultHandlers  []string `mapstructure:"default_handlers"`
+	NodeHandlers     []string `mapstructure:"node_handlers"`
 	LogLevel         string   `mapstructure:"log_level"`
+	LogFormat        string   `mapstructure:"log_format"`
+	HTTPAddress      string   `mapstructure:"http_address"`
+	APIToken         string   `mapstructure:"api_token"`
+	GRPCAddress      string   `mapstructure:"grpc_address"`
+	ConfigAuditKV    bool     `mapstructure:"config_audit_kv"`
+	ConfigKVPrefix   string   `mapstructure:"config_kv_prefix"`
+	SilenceKVPrefix  string   `mapstructure:"silence_kv_prefix"`
+	ServerHealth     bool     `mapstructure:"server_health"`
+	WANHealth        bool     `mapstructure:"wan_health"`
+	AgentHealth      bool     `mapstructure:"agent_health"`
+	MessageTemplate  string   `mapstructure:"message_template"`
+	DetailsTemplate  string   `mapstructure:"details_template"`
+
+	// The number of status changes within an hour after which an alert is reported as
+	// flapping, and the handlers the report is sent to
+	FlapRateThreshold int      `mapstructure:"flap_rate_threshold"`
+	FlapRateHandlers  []string `mapstructure:"flap_rate_handlers"`
+
+	// The number of distinct services and nodes going critical within StormWindow seconds
+	// above which their alerts are collapsed into a single alert storm alert, and the
+	// handlers that alert is sent to
+	StormThreshold int      `mapstructure:"storm_threshold"`
+	StormWindow    int      `mapstructure:"storm_window"`
+	StormHandlers  []string `mapstructure:"storm_handlers"`
+
+	// The number of consecutive observations of a new health status needed before alerting
+	FailuresBeforeAlert  int `mapstructure:"failures_before_alert"`
+	PassesBeforeRecovery int `mapstructure:"passes_before_recovery"`
+
+	// How long a check must keep failing before alerting, e.g. "2m". Replaces
+	// change_threshold for failures when set
+	AlertAfter string `mapstructure:"alert_after"`
+
+	// How handlers retry failed sends: waiting RetryInitialInterval seconds, doubling
+	// up to RetryMaxInterval, and giving up after RetryMaxElapsed seconds if it's set
+	RetryInitialInterval int `mapstructure:"retry_initial_interval"`
+	RetryMaxInterval     int `mapstructure:"retry_max_interval"`
+	RetryMaxElapsed      int `mapstructure:"retry_max_elapsed"`
+
+	// The number of workers sending alerts to handlers, and the alerts each can queue
+	DispatchWorkers   int `mapstructure:"dispatch_workers"`
+	DispatchQueueSize int `mapstructure:"dispatch_queue_size"`
+
+	// The directory of the persistent queue alerts are kept in until they're delivered,
+	// and how often (in seconds) undelivered alerts are retried
+	QueueDir           string `mapstructure:"queue_dir"`
+	QueueRetryInterval int    `mapstructure:"queue_retry_interval"`
+
+	// How long (in seconds) shutting down waits for the alerts being sent to be delivered
+	ShutdownTimeout int `mapstructure:"shutdown_timeout"`
+
+	// Whether the alert state is kept in the Consul KV store or in files in StateDir
+	StateStore string `mapstructure:"state_store"`
+	StateDir   string `mapstructure:"state_dir"`
+
+	// If set, the address to serve the pprof debug endpoints on, and the basic auth
+	// credentials they require
+	DebugAddress  string `mapstructure:"debug_address"`
+	DebugUsername string `mapstructure:"debug_username"`
+	DebugPassword string `mapstructure:"debug_password"`
+
+	// The number of alert transitions kept in the history, and the file they're kept in
+	// across restarts if set
+	HistorySize int    `mapstructure:"history_size"`
+	HistoryFile string `mapstructure:"history_file"`
+
+	// The file every notification attempt is recorded in, and the size (in megabytes) it's
+	// rotated at and how many rotated files are kept
+	AuditLogFile       string `mapstructure:"audit_log_file"`
+	AuditLogMaxSize    int    `mapstructure:"audit_log_max_size"`
+	AuditLogMaxBackups int    `mapstructure:"audit_log_max_backups"`
+
+	// The file logs are written to instead of stdout, the size (in megabytes) it's
+	// rotated at and how many rotated files are kept
+	LogFile           string `mapstructure:"log_file"`
+	LogFileMaxSize    int    `mapstructure:"log_file_max_size"`
+	LogFileMaxBackups int    `mapstructure:"log_file_max_backups"`
+
+	// Where alerts that a handler failed to deliver are kept: a file of JSON lines, a KV
+	// prefix and/or another handler
+	DeadLetterFile     string `mapstructure:"dead_letter_file"`
+	DeadLetterKVPrefix string `mapstructure:"dead_letter_kv_prefix"`
+	DeadLetterHandler  string `mapstructure:"dead_letter_handler"`
+
+	// Where the open alerts are written back into Consul for other tools to read: a KV
+	// prefix with a key per alert, and/or a check on the local agent summarizing them
+	StateExportKVPrefix string `mapstructure:"state_export_kv_prefix"`
+	StateExportCheck    bool   `mapstructure:"state_export_check"`
+
+	// If set, the StatsD server the internal metrics are also sent to, the prefix of their
+	// names, and whether it's DogStatsD (getting the metric labels as tags)
+	StatsdAddress   string `mapstructure:"statsd_address"`
+	StatsdPrefix    string `mapstructure:"statsd_prefix"`
+	StatsdDogStatsD bool   `mapstructure:"statsd_dogstatsd"`
+
+	// If set, the OTLP/HTTP endpoint of the OpenTelemetry collector that the spans of the
+	// alert pipeline are exported to, the headers sent with them, the service name they're
+	// reported under and the share of alerts that are traced
+	TracingEndpoint    string            `mapstructure:"tracing_endpoint"`
+	TracingHeaders     map[string]string `mapstructure:"tracing_headers"`
+	TracingServiceName string            `mapstructure:"tracing_service_name"`
+	TracingSampleRate  float64           `mapstructure:"tracing_sample_rate"`
+
+	// The Vault server that ${vault:<path>#<key>} references are read from, the token and
+	// namespace used, and how often (in seconds) the secrets are read again for rotations
+	VaultAddress         string `mapstructure:"vault_address"`
+	VaultToken           string `mapstructure:"vault_token"`
+	VaultNamespace       string `mapstructure:"vault_namespace"`
+	VaultRefreshInterval int    `mapstructure:"vault_refresh_interval"`
+
+	// The Consul tokens used for querying some of the datacenters in place of consul_token
+	DatacenterTokens map[string]string `mapstructure:"datacenter_tokens"`
+
+	// The CA certificate verifying the Consul agent over HTTPS, the client certificate and
+	// key presented to it, and the name its certificate is verified against if it isn't the
+	// host in consul_address
+	ConsulCAFile        string `mapstructure:"consul_ca_file"`
+	ConsulCertFile      string `mapstructure:"consul_cert_file"`
+	ConsulKeyFile       string `mapstructure:"consul_key_file"`
+	ConsulTLSServerName string `mapstructure:"consul_tls_server_name"`
+	ConsulTLSSkipVerify bool   `mapstructure:"consul_tls_skip_verify"`
+
+	// The address of the Consul UI, e.g. "https://consul.example.com", used for linking
+	// alerts to the page of their service or node
+	ConsulUIURL string `mapstructure:"consul_ui_url"`
+
+	// The proxy handlers send their HTTP requests through, unless they set their own
+	HTTPProxy string `mapstructure:"http_proxy"`
+
+	// Secret values and patterns masked in logs and check output, and whether common
+	// credential patterns are masked as well
+	RedactValues      []string `mapstructure:"redact_values"`
+	RedactPatterns    []string `mapstructure:"redact_patterns"`
+	RedactCredentials bool     `mapstructure:"redact_credentials"`
+
+	// Whether alerts posted by external systems to /v1/receive are sent to the handlers,
+	// and if set, the secret they must be signed with and how far (in seconds) the time
+	// they were signed at can be from now
+	ReceiveAlerts             bool   `mapstructure:"receive_alerts"`
+	ReceiveSigningSecret      string `mapstructure:"receive_signing_secret"`
+	ReceiveSignatureTolerance int    `mapstructure:"receive_signature_tolerance"`
+
+	// Whether alerts are only logged instead of being sent to the handlers
+	DryRun bool `mapstructure:"dry_run"`
+
+	// How long (in seconds) blocking queries to Consul wait for changes, the backoff before
+	// retrying after an error from Consul, and the most requests per second sent to Consul
+	ConsulWaitTime             int `mapstructure:"consul_wait_time"`
+	ConsulRetryInitialInterval int `mapstructure:"consul_retry_initial_interval"`
+	ConsulRetryMaxInterval     int `mapstructure:"consul_retry_max_interval"`
+	ConsulRateLimit            int `mapstructure:"consul_rate_limit"`
 
-	Services map[string]ServiceConfig
-	Handlers map[string]AlertHandler
+	// How long (in seconds) the requests to Consul must keep failing before alerting that
+	// it's unreachable, 0 meaning never
+	ConsulUnreachableThreshold int `mapstructure:"consul_unreachable_threshold"`
+
+	// Whether catalog and health queries can be served by any server, and how long (in
+	// seconds) a server may have gone without contact with the leader for its answer to
+	// be used, 0 meaning any
+	AllowStale bool `mapstructure:"allow_stale"`
+	MaxStale   int  `mapstructure:"max_stale"`
+
+	// How often (in seconds) each watch reads its checks in full and reconciles its alert
+	// state, 0 meaning never
+	ResyncInterval int `mapstructure:"resync_interval"`
+
+	// The Consul Enterprise namespaces whose services are watched, or "*" for all of them
+	Namespaces []string `mapstructure:"namespaces"`
+
+	// The Consul Enterprise admin partitions whose services and nodes are watched, or "*"
+	// for all of them
+	Partitions []string `mapstructure:"partitions"`
+
+	// Whether to also watch the services and nodes registered by consul-esm on external
+	// nodes, which no agent runs on, when service_watch and node_watch are local
+	ExternalServices bool `mapstructure:"external_services"`
+
+	// The Consul metadata nodes must have to be watched and alerted on, e.g. { env = "prod" }
+	NodeMeta map[string]string `mapstructure:"node_meta"`
+
+	// Whether to alert when nodes register in or leave the catalog, and the patterns of
+	// the nodes left out, such as those of autoscaled pools
+	NodeRegistrations       bool     `mapstructure:"node_registrations"`
+	NodeRegistrationsIgnore []string `mapstructure:"node_registrations_ignore"`
+
+	// Whether to alert when services gain or lose instances or leave the catalog, and the
+	// patterns of the services left out
+	ServiceRegistrations       bool     `mapstructure:"service_registrations"`
+	ServiceRegistrationsIgnore []string `mapstructure:"service_registrations_ignore"`
+
+	// The handlers that alerts on the Consul servers go to if no route matches, how long (in
+	// seconds) the leader_change check stays warning after the leader changes, and the
+	// number of raft peers expected, which defaults to the most seen
+	ServerHealthHandlers   []string `mapstructure:"server_health_handlers"`
+	ServerLeaderStableTime int      `mapstructure:"server_leader_stable_time"`
+	ServerRaftPeers        int      `mapstructure:"server_raft_peers"`
+
+	// The status of the agent_health check of agents that have left the cluster, and the
+	// patterns of the agents left out
+	AgentLeftStatus   string   `mapstructure:"agent_left_status"`
+	AgentHealthIgnore []string `mapstructure:"agent_health_ignore"`
+
+	// Patterns of the check IDs or names left out of every watch
+	IgnoredChecks []string `mapstructure:"ignored_checks"`
+
+	// Whether the checks of nodes and service instances in Consul's maintenance mode are
+	// suppressed, and whether to notify when they enter and leave it
+	ConsulMaintenance       string `mapstructure:"consul_maintenance"`
+	ConsulMaintenanceNotify bool   `mapstructure:"consul_maintenance_notify"`
+
+	// Whether instances elect a single active instance that alerts while the others stand
+	// by, and the TTL of the session holding its lock
+	HighAvailability bool   `mapstructure:"high_availability"`
+	HASessionTTL     string `mapstructure:"ha_session_ttl"`
+
+	// Whether instances split the service and node watches between them
+	Sharding bool `mapstructure:"sharding"`
+
+	// The names or IDs of the prepared queries to watch, and the status of their query check
+	// when the results come from another datacenter
+	PreparedQueries             []string `mapstructure:"prepared_queries"`
+	PreparedQueryFailoverStatus string   `mapstructure:"prepared_query_failover_status"`
+
+	// The escalation policy followed by alerts that stay failing
+	Escalation string `mapstructure:"escalation"`
+
+	SeverityClasses map[string][]string `mapstructure:"severity_classes"`
+
+	// Static labels attached to every alert, and to the alerts of each datacenter
+	Labels           map[string]string            `mapstructure:"labels"`
+	DatacenterLabels map[string]map[string]string `mapstructure:"datacenter_labels"`
+
+	Services       map[string]ServiceConfig
+	Routes         []RouteConfig
+	ServiceFilters []ServiceFilterConfig
+	OutputRules    []OutputRuleConfig
+	KVWatches      []KVWatchConfig
+	LockWatches    []LockWatchConfig
+	Heartbeats     []HeartbeatConfig
+	InhibitRules   []InhibitRuleConfig
+	SLOs           []SLOConfig
+	Maintenance    []MaintenanceConfig
+	Escalations    map[string]EscalationConfig
+	Plugins        map[string]PluginConfig
+	Handlers       map[string]AlertHandler
+	HandlerOptions map[string]HandlerOptions
+
+	messageTemplates alertMessageTemplates
+
+	// The compiled node_registrations_ignore, service_registrations_ignore,
+	// agent_health_ignore and ignored_checks patterns
+	nodeRegistrationsIgnore    []*regexp.Regexp
+	serviceRegistrationsIgnore []*regexp.Regexp
+	agentHealthIgnore          []*regexp.Regexp
+	ignoredChecks              []*regexp.Regexp
+	redactPatterns             []*regexp.Regexp
+
+	// The Vault references the config was loaded with, and the secrets they were read as
+	vaultSecrets map[string]string
+
+	// The agent's datacenter, set on the copies of the config watching each datacenter
+	localDatacenter string
+
+	// The namespace, set on the copies of the config watching each namespace
+	namespace string
+
+	// The partition and the agent's partition, set on the copies of the config watching
+	// each partition
+	partition      string
+	localPartition string
 }
 
 type ServiceConfig struct {
 	Name            string
 	ChangeThreshold int      `mapstructure:"change_threshold"`
+	DedupeCooldown  int      `mapstructure:"dedupe_cooldown"`
+	FlapThreshold   int      `mapstructure:"flap_threshold"`
+	FlapWindow      int      `mapstructure:"flap_window"`
+	Aggregation     string   `mapstructure:"aggregation"`
 	DistinctTags    bool     `mapstructure:"distinct_tags"`
 	IgnoredTags     []string `mapstructure:"ignored_tags"`
 	Handlers        []string `mapstructure:"handlers"`
+
+	// The number of status changes within an hour after which an alert is reported as
+	// flapping, and the handlers the report is sent to
+	FlapRateThreshold int      `mapstructure:"flap_rate_threshold"`
+	FlapRateHandlers  []string `mapstructure:"flap_rate_handlers"`
+
+	// The number of consecutive observations of a new health status needed before alerting
+	FailuresBeforeAlert  int `mapstructure:"failures_before_alert"`
+	PassesBeforeRecovery int `mapstructure:"passes_before_recovery"`
+
+	// How long a check must keep failing before alerting, e.g. "2m". Replaces
+	// change_threshold for failures when set
+	AlertAfter string `mapstructure:"alert_after"`
+
+	// The escalation policy followed by alerts that stay failing
+	Escalation string `mapstructure:"escalation"`
+
+	// Handlers used instead of Handlers for alerts on instances registered with a tag
+	TagHandlers map[string][]string `mapstructure:"tag_handlers"`
+
+	// The share (in percent) and number of passing instances below which the service is
+	// warning or critical, in place of the worst status of its checks
+	InstanceThresholds `mapstructure:",squash"`
+
+	SeverityClasses map[string][]string `mapstructure:"severity_classes"`
+
+	// Overrides the severity of alerts with a given status
+	Severities map[string]string `mapstructure:"severities"`
+
+	// Static labels attached to the alerts on this service, overriding the global labels
+	Labels map[string]string `mapstructure:"labels"`
+
+	MessageTemplate string `mapstructure:"message_template"`
+	DetailsTemplate string `mapstructure:"details_template"`
+
+	// Patterns of the check IDs or names left out of this service's watches, and the check
+	// blocks of checks alerted on separately with their own settings
+	IgnoredChecks []string `mapstructure:"ignored_checks"`
+	Checks        map[string]CheckConfig
+
+	messageTemplates alertMessageTemplates
+	ignoredChecks    []*regexp.Regexp
+}
+
+// Options shared by every handler type, given alongside the handler-specific ones
+type HandlerOptions struct {
+	// The class of the handler, used to decide which statuses it receives alerts for
+	Class string `mapstructure:"class"`
+
+	// The lowest severity of alert the handler receives, or every alert if not set
+	MinSeverity string `mapstructure:"min_severity"`
+
+	// If set, alerts arriving within this many seconds of each other are sent as one digest
+	BatchWindow int `mapstructure:"batch_window"`
+
+	// If set, failing alerts are sent again every this many seconds until they recover
+	RepeatInterval int `mapstructure:"repeat_interval"`
+
+	// If set, the longest time in seconds to wait for the handler to send an alert
+	SendTimeout int `mapstructure:"send_timeout"`
+
+	// If set, the most alerts sent to the handler per minute, and whether the ones over
+	// the limit are dropped (with a summary) or queued
+	RateLimit         int    `mapstructure:"rate_limit"`
+	RateLimitOverflow string `mapstructure:"rate_limit_overflow"`
+
+	// If set, the number of failed sends in a row after which the handler's sends are
+	// skipped for circuit_breaker_cooldown seconds
+	CircuitBreakerThreshold int `mapstructure:"circuit_breaker_threshold"`
+	CircuitBreakerCooldown  int `mapstructure:"circuit_breaker_cooldown"`
+
+	// If set, the handler only receives failures and no recoveries
+	SkipRecoveries bool `mapstructure:"skip_recoveries"`
 }
 
 // Parses a given file path for config and returns a Config object and an array
 // of AlertHandlers
 func ParseConfigFile(path string) (*Config, error) {
+	raw, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(raw)
+}
+
+// Reads a config file as HCL or JSON
+func readConfigFile(path string) (string, error) {
 	// Read the file contents
 	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("Error loading config file: %s", err)
+		return "", fmt.Errorf("Error loading config file: %s", err)
 	}
-	raw := string(bytes)
 
-	return ParseConfig(raw)
+	// JSON is parsed by the HCL parser itself; YAML is converted to JSON first
+	if isYAMLPath(path) {
+		converted, err := yamlToJSON(bytes)
+		if err != nil {
+			return "", fmt.Errorf("Error parsing YAML config file: %s", err)
+		}
+		return string(converted), nil
+	}
+	return string(bytes), nil
+}
+
+func isYAMLPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
 }
 
 func DefaultConfig() *Config {
@@ -61,11 +465,32 @@ func DefaultConfig() *Config {
 // Parses the given config string and returns a Config object and an array
 // of AlertHandlers
 func ParseConfig(raw string) (*Config, error) {
+	return parseConfig(raw, nil)
+}
+
+// A piece of config merged into the main one, like the value of a KV key
+type configFragment struct {
+	name string
+	raw  string
+}
+
+// Parses the given config string, merged with the fragments in order
+func parseConfig(raw string, fragments []configFragment) (*Config, error) {
 	// Parse the file (could be HCL or JSON)
 	root, err := hcl.Parse(raw)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing: %s", err)
 	}
+	// References are only expanded in the config file, before the fragments are merged
+	vaultSecrets, err := interpolateConfig(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, fragment := range fragments {
+		if err := mergeConfigFragment(root, fragment); err != nil {
+			return nil, err
+		}
+	}
 
 	// Top-level item should be a list
 	list, ok := root.Node.(*ast.ObjectList)
@@ -83,6 +508,17 @@ func ParseConfig(raw string) (*Config, error) {
 	}
 	delete(m, "service")
 	delete(m, "handler")
+	delete(m, "route")
+	delete(m, "service_filter")
+	delete(m, "output_rule")
+	delete(m, "kv_watch")
+	delete(m, "lock_watch")
+	delete(m, "heartbeat")
+	delete(m, "inhibit_rule")
+	delete(m, "slo")
+	delete(m, "maintenance")
+	delete(m, "escalation_policy")
+	delete(m, "plugin")
 
 	// Set defaults for unset keys
 	defaultConfig := map[string]interface{}{
@@ -90,7 +526,61 @@ func ParseConfig(raw string) (*Config, error) {
 		"node_watch":       "local",
 		"service_watch":    "local",
 		"change_threshold": 60,
+		"aggregation":      AggregateService,
+		"flap_window":      600,
+		"storm_window":     60,
 		"log_level":        "info",
+		"log_format":       "text",
+
+		"silence_kv_prefix": alertingKVRoot + "/silences/",
+
+		"failures_before_alert":  1,
+		"passes_before_recovery": 1,
+
+		"retry_initial_interval": 5,
+		"retry_max_interval":     60,
+
+		"consul_wait_time":              10,
+		"consul_retry_initial_interval": 10,
+		"consul_retry_max_interval":     60,
+		"allow_stale":                   true,
+
+		"dispatch_workers":    8,
+		"dispatch_queue_size": 100,
+
+		"queue_retry_interval": 30,
+
+		"shutdown_timeout": 30,
+
+		"redact_credentials": true,
+
+		"receive_signature_tolerance": 300,
+
+		"resync_interval": 300,
+
+		"state_store": StateStoreConsul,
+
+		"statsd_prefix": "consul_alerting.",
+
+		"tracing_service_name": "consul-alerting",
+		"tracing_sample_rate":  1,
+
+		"history_size": 1000,
+
+		"audit_log_max_size":    100,
+		"audit_log_max_backups": 5,
+
+		"log_file_max_size":    100,
+		"log_file_max_backups": 5,
+
+		"vault_refresh_interval": 300,
+
+		"server_leader_stable_time": 300,
+		"agent_left_status":         api.HealthWarning,
+
+		"prepared_query_failover_status": api.HealthWarning,
+		"consul_maintenance":             ConsulMaintenanceSuppress,
+		"ha_session_ttl":                 "10s",
 	}
 	for k, v := range defaultConfig {
 		if _, ok := m[k]; !ok {
@@ -103,6 +593,22 @@ func ParseConfig(raw string) (*Config, error) {
 		return nil, err
 	}
 
+	// Fill in the severity classes that weren't overridden
+	if config.SeverityClasses == nil {
+		config.SeverityClasses = make(map[string][]string)
+	}
+	for status, classes := range defaultSeverityClasses {
+		if _, ok := config.SeverityClasses[status]; !ok {
+			config.SeverityClasses[status] = classes
+		}
+	}
+
+	config.vaultSecrets = vaultSecrets
+
+	if config.messageTemplates, err = compileMessageTemplates(config.MessageTemplate, config.DetailsTemplate); err != nil {
+		return nil, err
+	}
+
 	// Use parser function for service blocks
 	config.Services = make(map[string]ServiceConfig)
 	if obj := list.Filter("service"); len(obj.Items) > 0 {
@@ -112,8 +618,24 @@ func ParseConfig(raw string) (*Config, error) {
 		}
 	}
 
+	// Plugins are parsed before the handlers using them
+	if obj := list.Filter("plugin"); len(obj.Items) > 0 {
+		err = parsePlugins(obj, &config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The proxy handlers inherit is checked before them, for a clearer error
+	if config.HTTPProxy != "" {
+		if _, err := parseProxyURL(config.HTTPProxy); err != nil {
+			return nil, fmt.Errorf("Invalid http_proxy: %s", err)
+		}
+	}
+
 	// Use parser function for handler blocks
 	config.Handlers = make(map[string]AlertHandler)
+	config.HandlerOptions = make(map[string]HandlerOptions)
 	if obj := list.Filter("handler"); len(obj.Items) > 0 {
 		err = parseHandlers(obj, &config)
 		if err != nil {
@@ -121,163 +643,1285 @@ func ParseConfig(raw string) (*Config, error) {
 		}
 	}
 
-	// Validate config
-	validWatchModes := []string{LocalMode, GlobalMode}
-
-	if !contains(validWatchModes, config.NodeWatch) {
-		return nil, fmt.Errorf("Invalid value for node_watch: %s", config.NodeWatch)
+	if obj := list.Filter("service_filter"); len(obj.Items) > 0 {
+		err = parseServiceFilters(obj, &config)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if !contains(validWatchModes, config.ServiceWatch) {
-		return nil, fmt.Errorf("Invalid value for service_watch: %s", config.ServiceWatch)
+	if obj := list.Filter("output_rule"); len(obj.Items) > 0 {
+		err = parseOutputRules(obj, &config)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &config, nil
-}
-
-// Parse the raw service objects into the config
-func parseServices(list *ast.ObjectList, config *Config) error {
-	config.Services = make(map[string]ServiceConfig)
+	if obj := list.Filter("inhibit_rule"); len(obj.Items) > 0 {
+		err = parseInhibitRules(obj, &config)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	for _, s := range list.Items {
-		name := s.Keys[0].Token.Value().(string)
+	if obj := list.Filter("slo"); len(obj.Items) > 0 {
+		err = parseSLOs(obj, &config)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		var m map[string]interface{}
-		var service ServiceConfig
-		if err := hcl.DecodeObject(&m, s.Val); err != nil {
-			return err
+	if obj := list.Filter("kv_watch"); len(obj.Items) > 0 {
+		err = parseKVWatches(obj, &config)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		if _, ok := m["change_threshold"]; !ok {
-			m["change_threshold"] = config.ChangeThreshold
+	if obj := list.Filter("lock_watch"); len(obj.Items) > 0 {
+		err = parseLockWatches(obj, &config)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		if err := mapstructure.WeakDecode(m, &service); err != nil {
-			return err
+	if obj := list.Filter("heartbeat"); len(obj.Items) > 0 {
+		err = parseHeartbeats(obj, &config)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		service.Name = name
-		config.Services[name] = service
+	// Routes refer to handlers, so they're parsed last
+	if obj := list.Filter("route"); len(obj.Items) > 0 {
+		err = parseRoutes(obj, &config)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return nil
-}
+	if obj := list.Filter("maintenance"); len(obj.Items) > 0 {
+		err = parseMaintenance(obj, &config)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-// Parse the raw handler objects into the config
-func parseHandlers(list *ast.ObjectList, config *Config) error {
-	config.Handlers = make(map[string]AlertHandler)
+	if obj := list.Filter("escalation_policy"); len(obj.Items) > 0 {
+		err = parseEscalations(obj, &config)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	defaultConfig := map[string]map[string]interface{}{
-		"stdout": map[string]interface{}{
-			"log_level": "warn",
-		},
-		"email": map[string]interface{}{
-			"max_retries": 5,
-		},
-		"pagerduty": map[string]interface{}{
-			"max_retries": 5,
-		},
-		"slack": map[string]interface{}{
-			"max_retries": 5,
-		},
+	if _, ok := config.Escalations[config.Escalation]; config.Escalation != "" && !ok {
+		return nil, fmt.Errorf("Unknown escalation policy %s", config.Escalation)
 	}
 
-	for _, s := range list.Items {
-		if len(s.Keys) < 2 {
-			return fmt.Errorf("didn't specify type/name for handler at line %d", s.Pos().Line)
+	for _, name := range config.NodeHandlers {
+		if _, ok := config.Handlers[name]; !ok {
+			return nil, fmt.Errorf("Unknown handler %s in node_handlers", name)
 		}
-		handlerType := s.Keys[0].Token.Value().(string)
-		name := s.Keys[1].Token.Value().(string)
-		id := handlerType + "." + name
+	}
 
-		var m map[string]interface{}
-		if err := hcl.DecodeObject(&m, s.Val); err != nil {
-			return err
+	for _, name := range config.FlapRateHandlers {
+		if _, ok := config.Handlers[name]; !ok {
+			return nil, fmt.Errorf("Unknown handler %s in flap_rate_handlers", name)
 		}
+	}
 
-		// Set defaults
-		if _, ok := defaultConfig[handlerType]; ok {
-			for key, val := range defaultConfig[handlerType] {
-				if _, ok := m[key]; !ok {
-					m[key] = val
-				}
-			}
+	for _, name := range config.ServerHealthHandlers {
+		if _, ok := config.Handlers[name]; !ok {
+			return nil, fmt.Errorf("Unknown handler %s in server_health_handlers", name)
 		}
+	}
 
-		// Decode based on the handler type.
-		// TODO: look into a more compact way to do this when we have more handlers
-		switch handlerType {
-		case "stdout":
-			var handler StdoutHandler
-			if err := mapstructure.WeakDecode(m, &handler); err != nil {
-				return err
-			}
-			handler.logger = log.StandardLogger()
-			config.Handlers[id] = handler
-		case "email":
-			var handler EmailHandler
-			if err := mapstructure.WeakDecode(m, &handler); err != nil {
-				return err
-			}
-			config.Handlers[id] = handler
-		case "pagerduty":
-			var handler PagerdutyHandler
-			if err := mapstructure.WeakDecode(m, &handler); err != nil {
-				return err
-			}
-			config.Handlers[id] = handler
-		case "slack":
-			var handler SlackHandler
-			if err := mapstructure.WeakDecode(m, &handler); err != nil {
-				return err
-			}
-			config.Handlers[id] = handler
-		default:
-			return fmt.Errorf("Unknown handler type: %s", handlerType)
+	for _, name := range config.StormHandlers {
+		if _, ok := config.Handlers[name]; !ok {
+			return nil, fmt.Errorf("Unknown handler %s in storm_handlers", name)
 		}
-
-		log.Infof("Loaded handler: %s", id)
 	}
 
-	return nil
-}
+	// Validate config
+	validWatchModes := []string{LocalMode, GlobalMode}
 
-func (config *Config) serviceConfig(service string) *ServiceConfig {
-	if s, ok := config.Services[service]; ok {
-		return &s
-	} else {
-		return nil
+	if config.FailuresBeforeAlert < 1 || config.PassesBeforeRecovery < 1 {
+		return nil, fmt.Errorf("failures_before_alert and passes_before_recovery must be at least 1")
 	}
-}
 
-// Loads the configured alert handlers for a given service, filtering if applicable
-func (c *Config) serviceHandlers(service string) []AlertHandler {
-	handlers := make([]AlertHandler, 0)
-	filters := make([]string, 0)
-	serviceConfig := c.serviceConfig(service)
-	if serviceConfig != nil {
-		filters = serviceConfig.Handlers
+	if err := validateAlertAfter(config.AlertAfter); err != nil {
+		return nil, err
 	}
-	if len(filters) == 0 {
-		filters = c.DefaultHandlers
+
+	if config.RetryInitialInterval <= 0 || config.RetryMaxInterval < config.RetryInitialInterval {
+		return nil, fmt.Errorf("retry_initial_interval must be positive and at most retry_max_interval")
 	}
-	for name, handler := range c.Handlers {
-		if len(filters) == 0 || contains(filters, name) {
-			handlers = append(handlers, handler)
-		}
+	if config.RetryMaxElapsed < 0 {
+		return nil, fmt.Errorf("retry_max_elapsed can't be negative")
 	}
-	return handlers
-}
 
-// Compute the changeThreshold for alerts on a service, defaulting to the global threshold
-// if no config for the service is specified
-func (c *Config) serviceChangeThreshold(service string) int {
-	changeThreshold := c.ChangeThreshold
+	if config.DispatchWorkers < 0 || config.DispatchQueueSize < 0 {
+		return nil, fmt.Errorf("dispatch_workers and dispatch_queue_size can't be negative")
+	}
 
-	// Override the global changeThreshold config if we have a service-specific one
+	if config.QueueRetryInterval <= 0 {
+		return nil, fmt.Errorf("queue_retry_interval must be positive")
+	}
+
+	if config.ConsulWaitTime < 1 || config.ConsulWaitTime > 600 {
+		return nil, fmt.Errorf("consul_wait_time must be between 1 and 600")
+	}
+	if config.ConsulRetryInitialInterval <= 0 || config.ConsulRetryMaxInterval < config.ConsulRetryInitialInterval {
+		return nil, fmt.Errorf("consul_retry_initial_interval must be positive and no more than consul_retry_max_interval")
+	}
+	if err := validateConsulUIURL(config.ConsulUIURL); err != nil {
+		return nil, err
+	}
+	if config.ConsulRateLimit < 0 {
+		return nil, fmt.Errorf("consul_rate_limit can't be negative")
+	}
+	if config.MaxStale < 0 {
+		return nil, fmt.Errorf("max_stale can't be negative")
+	}
+
+	if config.ResyncInterval < 0 {
+		return nil, fmt.Errorf("resync_interval can't be negative")
+	}
+
+	if config.FlapRateThreshold < 0 {
+		return nil, fmt.Errorf("flap_rate_threshold can't be negative")
+	}
+
+	if config.ConsulUnreachableThreshold < 0 {
+		return nil, fmt.Errorf("consul_unreachable_threshold can't be negative")
+	}
+
+	if config.ReceiveSignatureTolerance < 1 {
+		return nil, fmt.Errorf("receive_signature_tolerance must be at least 1")
+	}
+
+	if config.TracingEndpoint != "" {
+		if err := validateTracingEndpoint(config.TracingEndpoint); err != nil {
+			return nil, err
+		}
+	}
+	if config.TracingSampleRate < 0 || config.TracingSampleRate > 1 {
+		return nil, fmt.Errorf("tracing_sample_rate must be between 0 and 1")
+	}
+
+	if config.StormThreshold < 0 {
+		return nil, fmt.Errorf("storm_threshold can't be negative")
+	}
+	if config.StormThreshold > 0 && config.StormWindow < 1 {
+		return nil, fmt.Errorf("storm_window must be at least 1 with storm_threshold set")
+	}
+
+	if config.ShutdownTimeout < 0 {
+		return nil, fmt.Errorf("shutdown_timeout can't be negative")
+	}
+
+	if !contains([]string{StateStoreConsul, StateStoreLocal}, config.StateStore) {
+		return nil, fmt.Errorf("Invalid value for state_store: %s", config.StateStore)
+	}
+	if config.StateStore == StateStoreLocal {
+		if config.StateDir == "" {
+			return nil, fmt.Errorf("state_dir must be set with the local state_store")
+		}
+
+		// The outbound queue is kept locally along with the state unless it has its own directory
+		if config.QueueDir == "" {
+			config.QueueDir = filepath.Join(config.StateDir, "queue")
+		}
+	}
+
+	if config.HistorySize <= 0 {
+		return nil, fmt.Errorf("history_size must be positive")
+	}
+
+	if config.AuditLogMaxSize < 0 || config.AuditLogMaxBackups < 0 {
+		return nil, fmt.Errorf("audit_log_max_size and audit_log_max_backups can't be negative")
+	}
+
+	if config.LogFormat != "text" && config.LogFormat != "json" {
+		return nil, fmt.Errorf("log_format must be text or json")
+	}
+	if config.LogFileMaxSize < 0 || config.LogFileMaxBackups < 0 {
+		return nil, fmt.Errorf("log_file_max_size and log_file_max_backups can't be negative")
+	}
+
+	for datacenter := range config.DatacenterTokens {
+		if len(config.Datacenters) == 0 {
+			return nil, fmt.Errorf("datacenter_tokens can only be used with datacenters")
+		}
+		if !contains(config.Datacenters, AllDatacenters) && !contains(config.Datacenters, datacenter) {
+			return nil, fmt.Errorf("datacenter_tokens has a token for %s, which isn't in datacenters", datacenter)
+		}
+	}
+
+	if contains(config.Datacenters, AllDatacenters) && len(config.Datacenters) > 1 {
+		return nil, fmt.Errorf("datacenters can't list other datacenters along with %q", AllDatacenters)
+	}
+
+	if contains(config.Namespaces, AllNamespaces) && len(config.Namespaces) > 1 {
+		return nil, fmt.Errorf("namespaces can't list other namespaces along with %q", AllNamespaces)
+	}
+
+	if contains(config.Partitions, AllPartitions) && len(config.Partitions) > 1 {
+		return nil, fmt.Errorf("partitions can't list other partitions along with %q", AllPartitions)
+	}
+
+	if config.nodeRegistrationsIgnore, err = compileIgnorePatterns("node_registrations_ignore", config.NodeRegistrationsIgnore); err != nil {
+		return nil, err
+	}
+	if config.serviceRegistrationsIgnore, err = compileIgnorePatterns("service_registrations_ignore", config.ServiceRegistrationsIgnore); err != nil {
+		return nil, err
+	}
+	if config.agentHealthIgnore, err = compileIgnorePatterns("agent_health_ignore", config.AgentHealthIgnore); err != nil {
+		return nil, err
+	}
+	if config.ignoredChecks, err = compileIgnorePatterns("ignored_checks", config.IgnoredChecks); err != nil {
+		return nil, err
+	}
+	if config.redactPatterns, err = compileRedactPatterns(config.RedactPatterns); err != nil {
+		return nil, err
+	}
+
+	if !contains([]string{api.HealthPassing, api.HealthWarning, api.HealthCritical}, config.AgentLeftStatus) {
+		return nil, fmt.Errorf("Invalid value for agent_left_status: %s", config.AgentLeftStatus)
+	}
+	if !contains([]string{api.HealthPassing, api.HealthWarning, api.HealthCritical}, config.PreparedQueryFailoverStatus) {
+		return nil, fmt.Errorf("Invalid value for prepared_query_failover_status: %s", config.PreparedQueryFailoverStatus)
+	}
+	if !contains([]string{ConsulMaintenanceSuppress, ConsulMaintenanceAlert}, config.ConsulMaintenance) {
+		return nil, fmt.Errorf("Invalid value for consul_maintenance: %s", config.ConsulMaintenance)
+	}
+	if ttl, err := time.ParseDuration(config.HASessionTTL); err != nil || ttl < 10*time.Second || ttl > 24*time.Hour {
+		return nil, fmt.Errorf("Invalid value for ha_session_ttl: %s, must be a duration between 10s and 24h", config.HASessionTTL)
+	}
+
+	if config.Sharding && config.HighAvailability {
+		return nil, fmt.Errorf("sharding can't be used along with high_availability")
+	}
+	if config.Sharding && config.ServiceWatch != GlobalMode {
+		return nil, fmt.Errorf("sharding requires service_watch to be global")
+	}
+
+	if config.VaultRefreshInterval <= 0 {
+		return nil, fmt.Errorf("vault_refresh_interval must be positive")
+	}
+
+	if config.DebugUsername != "" && config.DebugPassword == "" {
+		return nil, fmt.Errorf("debug_username requires debug_password to be set")
+	}
+
+	if _, ok := config.Handlers[config.DeadLetterHandler]; config.DeadLetterHandler != "" && !ok {
+		return nil, fmt.Errorf("Unknown dead_letter_handler %s", config.DeadLetterHandler)
+	}
+
+	if !contains(validWatchModes, config.NodeWatch) {
+		return nil, fmt.Errorf("Invalid value for node_watch: %s", config.NodeWatch)
+	}
+
+	if !contains(validWatchModes, config.ServiceWatch) {
+		return nil, fmt.Errorf("Invalid value for service_watch: %s", config.ServiceWatch)
+	}
+
+	validAggregations := []string{AggregateNone, AggregateNode, AggregateService, AggregateDatacenter, AggregateInstances}
+
+	if !contains(validAggregations, config.Aggregation) {
+		return nil, fmt.Errorf("Invalid value for aggregation: %s", config.Aggregation)
+	}
+
+	for name, service := range config.Services {
+		if !contains(validAggregations, service.Aggregation) {
+			return nil, fmt.Errorf("Invalid value for aggregation in service %s: %s", name, service.Aggregation)
+		}
+	}
+
+	if err := validateSeverityClasses(config.SeverityClasses); err != nil {
+		return nil, err
+	}
+
+	for name, service := range config.Services {
+		if err := validateSeverityClasses(service.SeverityClasses); err != nil {
+			return nil, fmt.Errorf("%s in service %s", err, name)
+		}
+		if err := validateSeverities(service.Severities); err != nil {
+			return nil, fmt.Errorf("%s in service %s", err, name)
+		}
+		if service.FailuresBeforeAlert < 1 || service.PassesBeforeRecovery < 1 {
+			return nil, fmt.Errorf("failures_before_alert and passes_before_recovery must be at least 1 in service %s", name)
+		}
+		if err := validateAlertAfter(service.AlertAfter); err != nil {
+			return nil, fmt.Errorf("%s in service %s", err, name)
+		}
+		if err := service.InstanceThresholds.validate(service.Aggregation); err != nil {
+			return nil, fmt.Errorf("%s in service %s", err, name)
+		}
+		if _, ok := config.Escalations[service.Escalation]; service.Escalation != "" && !ok {
+			return nil, fmt.Errorf("Unknown escalation policy %s in service %s", service.Escalation, name)
+		}
+
+		handlers := append(append([]string{}, service.Handlers...), service.FlapRateHandlers...)
+		for _, tagHandlers := range service.TagHandlers {
+			handlers = append(handlers, tagHandlers...)
+		}
+		for _, check := range service.Checks {
+			handlers = append(handlers, check.Handlers...)
+		}
+		for _, handler := range handlers {
+			if _, ok := config.Handlers[handler]; !ok {
+				return nil, fmt.Errorf("Unknown handler %s in service %s", handler, name)
+			}
+		}
+	}
+
+	return &config, nil
+}
+
+// Parse the raw service objects into the config
+func parseServices(list *ast.ObjectList, config *Config) error {
+	config.Services = make(map[string]ServiceConfig)
+
+	// The blocks of a service given more than once, like in a config fragment from KV,
+	// are merged, with the settings of later blocks replacing those of earlier ones
+	var names []string
+	blocks := make(map[string]map[string]interface{})
+	for _, s := range list.Items {
+		name := s.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		if err := hcl.DecodeObject(&m, s.Val); err != nil {
+			return err
+		}
+		if block, ok := blocks[name]; ok {
+			for key, value := range m {
+				block[key] = value
+			}
+			continue
+		}
+		names = append(names, name)
+		blocks[name] = m
+	}
+
+	for _, name := range names {
+		m := blocks[name]
+		var service ServiceConfig
+
+		if _, ok := m["change_threshold"]; !ok {
+			m["change_threshold"] = config.ChangeThreshold
+		}
+
+		if _, ok := m["alert_after"]; !ok {
+			m["alert_after"] = config.AlertAfter
+		}
+
+		if _, ok := m["escalation"]; !ok {
+			m["escalation"] = config.Escalation
+		}
+
+		if _, ok := m["aggregation"]; !ok {
+			m["aggregation"] = config.Aggregation
+		}
+
+		if _, ok := m["dedupe_cooldown"]; !ok {
+			m["dedupe_cooldown"] = config.DedupeCooldown
+		}
+
+		if _, ok := m["flap_threshold"]; !ok {
+			m["flap_threshold"] = config.FlapThreshold
+		}
+
+		if _, ok := m["flap_window"]; !ok {
+			m["flap_window"] = config.FlapWindow
+		}
+
+		if _, ok := m["flap_rate_threshold"]; !ok {
+			m["flap_rate_threshold"] = config.FlapRateThreshold
+		}
+
+		if _, ok := m["flap_rate_handlers"]; !ok && len(config.FlapRateHandlers) > 0 {
+			m["flap_rate_handlers"] = config.FlapRateHandlers
+		}
+
+		if _, ok := m["failures_before_alert"]; !ok {
+			m["failures_before_alert"] = config.FailuresBeforeAlert
+		}
+
+		if _, ok := m["passes_before_recovery"]; !ok {
+			m["passes_before_recovery"] = config.PassesBeforeRecovery
+		}
+
+		checks, hasChecks := m["check"]
+		delete(m, "check")
+
+		if err := mapstructure.WeakDecode(m, &service); err != nil {
+			return err
+		}
+
+		var err error
+		if service.messageTemplates, err = compileMessageTemplates(service.MessageTemplate, service.DetailsTemplate); err != nil {
+			return fmt.Errorf("%s in service %s", err, name)
+		}
+		if service.ignoredChecks, err = compileIgnorePatterns("ignored_checks", service.IgnoredChecks); err != nil {
+			return fmt.Errorf("%s in service %s", err, name)
+		}
+
+		service.Name = name
+		if hasChecks {
+			if err := parseServiceChecks(checks, &service); err != nil {
+				return err
+			}
+		}
+		config.Services[name] = service
+	}
+
+	return nil
+}
+
+// Parse the raw handler objects into the config
+func parseHandlers(list *ast.ObjectList, config *Config) error {
+	config.Handlers = make(map[string]AlertHandler)
+	config.HandlerOptions = make(map[string]HandlerOptions)
+
+	defaultConfig := map[string]map[string]interface{}{
+		"stdout": map[string]interface{}{
+			"log_level": "warn",
+		},
+		"email": map[string]interface{}{
+			"max_retries": 5,
+			"send_mode":   EmailSendIndividual,
+			"transport":   EmailTransportMX,
+			"smtp_tls":    EmailTLSStartTLS,
+		},
+		"pagerduty": map[string]interface{}{
+			"max_retries": 5,
+			"class":       PagingClass,
+			"events_url":  pagerdutyEventsURL,
+		},
+		"slack": map[string]interface{}{
+			"max_retries": 5,
+			"api_url":     slackAPIURL,
+		},
+		"webhook": map[string]interface{}{
+			"max_retries":      5,
+			"method":           "POST",
+			"signature_header": defaultSignatureHeader,
+		},
+		"teams": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"victorops": map[string]interface{}{
+			"max_retries": 5,
+			"class":       PagingClass,
+		},
+		"telegram": map[string]interface{}{
+			"max_retries": 5,
+			"api_url":     telegramAPIURL,
+		},
+		"discord": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"sns": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"sqs": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"pubsub": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"servicebus": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"eventhubs": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"kafka": map[string]interface{}{
+			"client_id": "consul-alerting",
+		},
+		"nats": map[string]interface{}{
+			"name": "consul-alerting",
+		},
+		"irc": map[string]interface{}{
+			"nick": "consul-alerting",
+		},
+		"xmpp": map[string]interface{}{
+			"room_nick": "consul-alerting",
+		},
+		"syslog": map[string]interface{}{
+			"facility":    "daemon",
+			"app_name":    "consul-alerting",
+			"max_retries": 5,
+		},
+		"twilio": map[string]interface{}{
+			"api_url":     twilioAPIURL,
+			"max_retries": 5,
+			"class":       PagingClass,
+		},
+		"mattermost": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"googlechat": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"pushover": map[string]interface{}{
+			"api_url":          pushoverAPIURL,
+			"max_retries":      5,
+			"emergency_retry":  60,
+			"emergency_expire": 3600,
+		},
+		"ntfy": map[string]interface{}{
+			"server_url":  ntfyServerURL,
+			"max_retries": 5,
+		},
+		"gotify": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"zabbix": map[string]interface{}{
+			"host":        "{{.Node}}",
+			"key":         "consul.health[{{.Service}}]",
+			"value":       "code",
+			"max_retries": 5,
+		},
+		"nagios": map[string]interface{}{
+			"host":         "{{.Node}}",
+			"service":      "{{.Service}}",
+			"check_source": "consul-alerting",
+			"max_retries":  5,
+		},
+		"sensu": map[string]interface{}{
+			"namespace":      "default",
+			"entity":         "{{.Node}}",
+			"default_entity": "consul",
+			"check":          "{{.Service}}",
+			"default_check":  "consul-node",
+			"max_retries":    5,
+		},
+		"file": map[string]interface{}{
+			"max_size":    100,
+			"max_backups": 5,
+		},
+		"alertmanager": map[string]interface{}{
+			"resend_interval": 60,
+			"max_retries":     5,
+		},
+		"datadog": map[string]interface{}{
+			"site":        "datadoghq.com",
+			"max_retries": 5,
+		},
+		"jira": map[string]interface{}{
+			"issue_type":  "Task",
+			"max_retries": 5,
+		},
+		"servicenow": map[string]interface{}{
+			"resolved_state": "6",
+			"close_code":     "Solved (Permanently)",
+			"max_retries":    5,
+		},
+		"splunk": map[string]interface{}{
+			"source":      "consul-alerting",
+			"sourcetype":  "consul:alert",
+			"max_retries": 5,
+		},
+		"mqtt": map[string]interface{}{
+			"topic":     "consul/alerts/{{.Datacenter}}/{{.Service}}",
+			"client_id": "consul-alerting",
+			"qos":       1,
+		},
+		"exec": map[string]interface{}{
+			"timeout":        30,
+			"max_concurrent": 4,
+		},
+		"matrix": map[string]interface{}{
+			"max_retries": 5,
+		},
+		"rocketchat": map[string]interface{}{
+			"max_retries": 5,
+		},
+	}
+
+	for _, s := range list.Items {
+		if err := parseHandler(s, config, defaultConfig); err != nil {
+			return fmt.Errorf("%s (line %d)", err, s.Pos().Line)
+		}
+	}
+
+	return nil
+}
+
+// Decodes and validates a handler block, adding the handler to the config
+func parseHandler(s *ast.ObjectItem, config *Config, defaultConfig map[string]map[string]interface{}) error {
+	if len(s.Keys) < 2 {
+		return fmt.Errorf("didn't specify type/name for handler")
+	}
+	handlerType := s.Keys[0].Token.Value().(string)
+	name := s.Keys[1].Token.Value().(string)
+	id := handlerType + "." + name
+
+	var m map[string]interface{}
+	if err := hcl.DecodeObject(&m, s.Val); err != nil {
+		return err
+	}
+
+	// Set defaults
+	if _, ok := defaultConfig[handlerType]; ok {
+		for key, val := range defaultConfig[handlerType] {
+			if _, ok := m[key]; !ok {
+				m[key] = val
+			}
+		}
+	}
+
+	if _, ok := m["class"]; !ok {
+		m["class"] = NotifyClass
+	}
+	if _, ok := m["proxy"]; !ok && config.HTTPProxy != "" {
+		m["proxy"] = config.HTTPProxy
+	}
+
+	var options HandlerOptions
+	if err := mapstructure.WeakDecode(m, &options); err != nil {
+		return err
+	}
+	if options.MinSeverity != "" && severityRank(options.MinSeverity) < 0 {
+		return fmt.Errorf("Error loading handler %s: Invalid value for min_severity: %s", id, options.MinSeverity)
+	}
+	if options.BatchWindow < 0 {
+		return fmt.Errorf("Error loading handler %s: batch_window can't be negative", id)
+	}
+	if options.RepeatInterval < 0 {
+		return fmt.Errorf("Error loading handler %s: repeat_interval can't be negative", id)
+	}
+	if options.SendTimeout < 0 {
+		return fmt.Errorf("Error loading handler %s: send_timeout can't be negative", id)
+	}
+	if options.RateLimit < 0 {
+		return fmt.Errorf("Error loading handler %s: rate_limit can't be negative", id)
+	}
+	if !contains([]string{"", RateLimitDrop, RateLimitQueue}, options.RateLimitOverflow) {
+		return fmt.Errorf("Error loading handler %s: Invalid value for rate_limit_overflow: %s", id, options.RateLimitOverflow)
+	}
+	if options.CircuitBreakerThreshold < 0 || options.CircuitBreakerCooldown < 0 {
+		return fmt.Errorf("Error loading handler %s: circuit_breaker_threshold and circuit_breaker_cooldown can't be negative", id)
+	}
+	config.HandlerOptions[id] = options
+
+	// Decode based on the handler type.
+	switch handlerType {
+	case "stdout":
+		var handler StdoutHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		handler.logger = log.StandardLogger()
+		config.Handlers[id] = handler
+	case "email":
+		var handler EmailHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "pagerduty":
+		var handler PagerdutyHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "slack":
+		var handler SlackHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "webhook":
+		var handler WebhookHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "teams":
+		var handler TeamsHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "victorops":
+		var handler VictorOpsHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "telegram":
+		var handler TelegramHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "discord":
+		var handler DiscordHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "sns":
+		var handler SNSHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "sqs":
+		var handler SQSHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "pubsub":
+		var handler PubSubHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "servicebus":
+		var handler ServiceBusHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "eventhubs":
+		var handler EventHubsHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "kafka":
+		var handler KafkaHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "nats":
+		var handler NATSHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "irc":
+		var handler IRCHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "xmpp":
+		var handler XMPPHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "syslog":
+		var handler SyslogHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "twilio":
+		var handler TwilioHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "mattermost":
+		var handler MattermostHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "googlechat":
+		var handler GoogleChatHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "pushover":
+		var handler PushoverHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "ntfy":
+		var handler NtfyHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "gotify":
+		var handler GotifyHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "zabbix":
+		var handler ZabbixHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "nagios":
+		var handler NagiosHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "sensu":
+		var handler SensuHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "file":
+		var handler FileHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "alertmanager":
+		var handler AlertmanagerHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "datadog":
+		var handler DatadogHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "jira":
+		var handler JiraHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "servicenow":
+		var handler ServiceNowHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "splunk":
+		var handler SplunkHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "mqtt":
+		var handler MQTTHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "exec":
+		var handler ExecHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "matrix":
+		var handler MatrixHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "rocketchat":
+		var handler RocketChatHandler
+		if err := decodeHandler(id, m, &handler); err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	case "plugin":
+		handler, err := newPluginHandler(id, m, config)
+		if err != nil {
+			return err
+		}
+		config.Handlers[id] = handler
+	default:
+		return fmt.Errorf("Unknown handler type: %s", handlerType)
+	}
+
+	log.Infof("Loaded handler: %s", id)
+	return nil
+}
+
+// Builds the Consul client config from consul_address, which can start with the scheme to
+// use (http or https), consul_token and the TLS settings. Without consul_token, the token
+// is taken from CONSUL_HTTP_TOKEN. An address without a scheme uses https when any of the
+// TLS settings are set.
+func (c *Config) consulClientConfig() (*api.Config, error) {
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = c.ConsulAddress
+	addressSplit := strings.Split(c.ConsulAddress, "://")
+	useTLS := c.ConsulCAFile != "" || c.ConsulCertFile != "" || c.ConsulKeyFile != "" ||
+		c.ConsulTLSServerName != "" || c.ConsulTLSSkipVerify
+	if len(addressSplit) > 1 {
+		clientConfig.Address = addressSplit[1]
+		clientConfig.Scheme = addressSplit[0]
+	} else if useTLS {
+		clientConfig.Scheme = "https"
+	}
+	if c.ConsulToken != "" {
+		clientConfig.Token = c.ConsulToken
+	}
+
+	if clientConfig.Scheme != "http" && clientConfig.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid scheme in consul_address: %s", clientConfig.Scheme)
+	}
+	if clientConfig.Address == "" {
+		return nil, fmt.Errorf("consul_address must be set")
+	}
+	if useTLS && clientConfig.Scheme != "https" {
+		return nil, fmt.Errorf("The Consul TLS settings need consul_address to use https")
+	}
+
+	if (c.ConsulCertFile == "") != (c.ConsulKeyFile == "") {
+		return nil, fmt.Errorf("consul_cert_file and consul_key_file must be set together")
+	}
+
+	if useTLS {
+		tlsConfig, err := api.SetupTLSConfig(&api.TLSConfig{
+			Address:            c.ConsulTLSServerName,
+			CAFile:             c.ConsulCAFile,
+			CertFile:           c.ConsulCertFile,
+			KeyFile:            c.ConsulKeyFile,
+			InsecureSkipVerify: c.ConsulTLSSkipVerify,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error setting up TLS for the Consul agent: %s", err)
+		}
+		clientConfig.HttpClient.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	}
+
+	if len(c.NodeMeta) > 0 {
+		clientConfig.HttpClient = &http.Client{Transport: &nodeMetaTransport{nodeMeta: c.NodeMeta, base: clientConfig.HttpClient.Transport}}
+	}
+
+	transport := &consulTransport{base: clientConfig.HttpClient.Transport, maxStale: time.Duration(c.MaxStale) * time.Second}
+	if c.ConsulRateLimit > 0 {
+		transport.limiter = newRequestLimiter(c.ConsulRateLimit)
+	}
+	clientConfig.HttpClient = &http.Client{Transport: transport}
+	return clientConfig, nil
+}
+
+// Implemented by handlers that need to check or prepare their settings once decoded
+type handlerValidator interface {
+	validate() error
+}
+
+// Decodes a handler's settings into the given handler pointer, validating them if supported
+func decodeHandler(id string, m map[string]interface{}, handler interface{}) error {
+	if err := mapstructure.WeakDecode(m, handler); err != nil {
+		return err
+	}
+
+	if client, ok := handler.(httpClientHandler); ok {
+		if err := client.setupHTTPClient(); err != nil {
+			return fmt.Errorf("Error loading handler %s: %s", id, err)
+		}
+	}
+
+	if validator, ok := handler.(handlerValidator); ok {
+		if err := validator.validate(); err != nil {
+			return fmt.Errorf("Error loading handler %s: %s", id, err)
+		}
+	}
+
+	return nil
+}
+
+func (config *Config) serviceConfig(service string) *ServiceConfig {
+	if s, ok := config.Services[service]; ok {
+		return &s
+	} else {
+		return nil
+	}
+}
+
+// Loads the configured alert handlers for a given service, filtering if applicable
+func (c *Config) serviceHandlers(service string, tags []string) []AlertHandler {
+	handlers := make([]AlertHandler, 0)
+	for _, name := range c.serviceHandlerNames(service, tags) {
+		handlers = append(handlers, c.Handlers[name])
+	}
+	return handlers
+}
+
+// Returns the sorted names of the configured alert handlers for a given service,
+// filtering if applicable
+func (c *Config) serviceHandlerNames(service string, tags []string) []string {
+	names := make([]string, 0)
+	filters := c.serviceHandlerFilters(service, tags)
+	for name := range c.Handlers {
+		if len(filters) == 0 || contains(filters, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the handler names a service's alerts are limited to, or an empty list for every
+// handler. An "alerting.handlers" service tag takes precedence over the service's tag_handlers,
+// which take precedence over its handlers and then the global default_handlers.
+func (c *Config) serviceHandlerFilters(service string, tags []string) []string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, handlersTagPrefix) {
+			return strings.Split(strings.TrimPrefix(tag, handlersTagPrefix), ",")
+		}
+	}
+
+	serviceConfig := c.serviceConfig(service)
+	if serviceConfig == nil {
+		return c.DefaultHandlers
+	}
+
+	// Every registered tag with its own handlers contributes them
+	filters := make([]string, 0)
+	for _, tag := range tags {
+		for _, name := range serviceConfig.TagHandlers[tag] {
+			if !contains(filters, name) {
+				filters = append(filters, name)
+			}
+		}
+	}
+	if len(filters) > 0 {
+		return filters
+	}
+
+	if len(serviceConfig.Handlers) > 0 {
+		return serviceConfig.Handlers
+	}
+	return c.DefaultHandlers
+}
+
+// Returns the names of the handlers that should receive an alert with the given status.
+// The handlers are chosen by the matching routes, falling back to node_handlers for the
+// alerts of node checks, server_health_handlers for the alerts on the Consul servers or
+// the service's handlers, if no route matches. Handlers in a class that received one of
+// the previously alerted statuses keep receiving updates until the alert recovers, and
+// recoveries only go to the classes that received the failure, or to every handler if
+// that isn't known.
+// Handlers with a min_severity only receive alerts at or above it.
+func (c *Config) severityHandlerNames(datacenter string, service string, node string, nodeMeta map[string]string, tags []string, status string, alerted ...string) []string {
+	candidates, routed := c.routeHandlerNames(datacenter, service, node, nodeMeta, tags, status, alerted, time.Now())
+	if routed {
+		sort.Strings(candidates)
+	} else if service == "" && node != "" && len(c.NodeHandlers) > 0 {
+		candidates = append([]string{}, c.NodeHandlers...)
+		sort.Strings(candidates)
+	} else if service == serverHealthService && len(c.ServerHealthHandlers) > 0 {
+		candidates = append([]string{}, c.ServerHealthHandlers...)
+		sort.Strings(candidates)
+	} else {
+		candidates = c.serviceHandlerNames(service, tags)
+	}
+
+	unknownFailure := status == api.HealthPassing
+	for _, previous := range alerted {
+		if previous != "" && previous != api.HealthPassing {
+			unknownFailure = false
+		}
+	}
+
+	names := make([]string, 0)
+	for _, name := range candidates {
+		class := c.handlerClass(name)
+		if !c.meetsMinSeverity(name, service, status, alerted) {
+			continue
+		}
+		send := unknownFailure || contains(c.severityClasses(service, tags, status), class)
+		for _, previous := range alerted {
+			send = send || contains(c.severityClasses(service, tags, previous), class)
+		}
+		if send {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Returns the class of the given handler, defaulting to NotifyClass
+func (c *Config) handlerClass(name string) string {
+	if options, ok := c.HandlerOptions[name]; ok && options.Class != "" {
+		return options.Class
+	}
+	return NotifyClass
+}
+
+// Returns the handler classes that alerts of a given status should be sent to. Service tags
+// take precedence over the service's config block, which takes precedence over the global setting.
+func (c *Config) severityClasses(service string, tags []string, status string) []string {
+	if classes, ok := tagSeverityClasses(tags)[status]; ok {
+		return classes
+	}
+
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		if classes, ok := serviceConfig.SeverityClasses[status]; ok {
+			return classes
+		}
+	}
+
+	if classes, ok := c.SeverityClasses[status]; ok {
+		return classes
+	}
+
+	return defaultSeverityClasses[status]
+}
+
+// Parses severity class overrides from service tags in the form "alerting.<status>=<class>,<class>"
+func tagSeverityClasses(tags []string) map[string][]string {
+	overrides := make(map[string][]string)
+
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, severityTagPrefix) {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(tag, severityTagPrefix), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		if _, ok := defaultSeverityClasses[parts[0]]; ok {
+			overrides[parts[0]] = strings.Split(parts[1], ",")
+		}
+	}
+
+	return overrides
+}
+
+// Makes sure a severity class mapping only contains alerting statuses
+func validateSeverityClasses(classes map[string][]string) error {
+	for status := range classes {
+		if _, ok := defaultSeverityClasses[status]; !ok {
+			return fmt.Errorf("Invalid status in severity_classes: %s", status)
+		}
+	}
+	return nil
+}
+
+// Compute the changeThreshold for alerts on a service, defaulting to the global threshold
+// if no config for the service is specified
+func (c *Config) serviceChangeThreshold(service string) int {
+	changeThreshold := c.ChangeThreshold
+
+	// Override the global changeThreshold config if we have a service-specific one
 	if c.serviceConfig(service) != nil {
 		changeThreshold = c.serviceConfig(service).ChangeThreshold
 	}
 
 	return changeThreshold
 }
+
+// Returns how long a new status of a service must last before alerting. Failures wait for
+// alert_after if one is set, anything else waits for the change threshold.
+func (c *Config) serviceAlertDelay(service string, status string) time.Duration {
+	alertAfter := c.AlertAfter
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		alertAfter = serviceConfig.AlertAfter
+	}
+
+	if alertAfter != "" && status != api.HealthPassing {
+		// The duration is checked when the config is parsed
+		delay, _ := time.ParseDuration(alertAfter)
+		return delay
+	}
+	return time.Duration(c.serviceChangeThreshold(service)) * time.Second
+}
+
+func validateAlertAfter(alertAfter string) error {
+	if alertAfter == "" {
+		return nil
+	}
+
+	delay, err := time.ParseDuration(alertAfter)
+	if err != nil {
+		return fmt.Errorf("invalid alert_after: %s", err)
+	}
+	if delay < 0 {
+		return fmt.Errorf("alert_after must not be negative")
+	}
+	return nil
+}
+
+// Returns the escalation policy for alerts on a service, defaulting to the global policy
+// if no config for the service is specified, or nil if there isn't one
+func (c *Config) serviceEscalation(service string) *EscalationConfig {
+	name := c.Escalation
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		name = serviceConfig.Escalation
+	}
+
+	policy, ok := c.Escalations[name]
+	if !ok {
+		return nil
+	}
+	return &policy
+}
+
+// Compute the cooldown for duplicate alerts on a service, defaulting to the global cooldown
+// if no config for the service is specified
+func (c *Config) serviceDedupeCooldown(service string) int {
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		return serviceConfig.DedupeCooldown
+	}
+	return c.DedupeCooldown
+}
+
+// Returns the number of consecutive observations of a new health status needed before
+// alerting on a service: failures_before_alert for failures, or passes_before_recovery
+// for recoveries. Defaults to the global settings if no config for the service is specified.
+func (c *Config) serviceObservationsRequired(service string, status string) int {
+	failures, passes := c.FailuresBeforeAlert, c.PassesBeforeRecovery
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		failures, passes = serviceConfig.FailuresBeforeAlert, serviceConfig.PassesBeforeRecovery
+	}
+
+	if status == api.HealthPassing {
+		return passes
+	}
+	return failures
+}
+
+// Returns the flap detection threshold and window (in seconds) for alerts on a service,
+// defaulting to the global settings if no config for the service is specified
+func (c *Config) serviceFlapDetection(service string) (int, int) {
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		return serviceConfig.FlapThreshold, serviceConfig.FlapWindow
+	}
+	return c.FlapThreshold, c.FlapWindow
+}
+
+// Returns the flap rate threshold and handlers for alerts on a service, defaulting to the
+// global settings if no config for the service is specified
+func (c *Config) serviceFlapRate(service string) (int, []string) {
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		return serviceConfig.FlapRateThreshold, serviceConfig.FlapRateHandlers
+	}
+	return c.FlapRateThreshold, c.FlapRateHandlers
+}
+
+// Compute the aggregation level for alerts on a service, defaulting to the global setting
+// if no config for the service is specified
+func (c *Config) serviceAggregation(service string) string {
+	aggregation := c.Aggregation
+
+	if c.serviceConfig(service) != nil && c.serviceConfig(service).Aggregation != "" {
+		aggregation = c.serviceConfig(service).Aggregation
+	}
+
+	if aggregation == "" {
+		aggregation = AggregateService
+	}
+
+	return aggregation
+}
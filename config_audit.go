@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The KV prefix used for storing config audit entries
+const configAuditKVPath = alertingKVRoot + "/audit/config/"
+
+// The KV key holding the key handler settings are hashed with, kept outside of the audit
+// entries so that it can be restricted with its own ACL
+const configHashKeyKVPath = alertingKVRoot + "/audit/hash-key"
+
+// The key handler settings are hashed with. Without a key, the hash of a handler whose
+// settings are known apart from a short secret could be used to guess the secret. It's
+// random for each process, unless the audit trail is stored in the KV store, where a key
+// is shared so that the snapshots stay comparable across restarts.
+var configHashKey = newConfigHashKey()
+
+// A summary of the parts of a config that affect watches and alerting, used for
+// computing what changed between two configs. Handler settings are hashed so that
+// secrets like api tokens are never written to the audit trail.
+type configSnapshot struct {
+	Settings map[string]string `json:"settings"`
+	Services map[string]string `json:"services"`
+	Handlers map[string]string `json:"handlers"`
+}
+
+// An entry in the config audit trail
+type configAuditEntry struct {
+	Time     string         `json:"time"`
+	Reason   string         `json:"reason"`
+	Changes  []string       `json:"changes"`
+	Snapshot configSnapshot `json:"snapshot"`
+}
+
+// Describes the effect on watches of changing a global setting
+var settingEffects = map[string]string{
+	"node_watch":       "the set of watched nodes will change",
+	"service_watch":    "the set of watched services will change",
+	"change_threshold": "pending alerts will use the new threshold",
+	"aggregation":      "alerts will be grouped differently",
+	"default_handlers": "services without handlers set will alert different handlers",
+	"severity_classes": "alerts will be routed to different handler classes",
+	"routes":           "alerts will be routed to different handlers",
+	"service_filters":  "the set of watched services will change",
+	"output_rules":     "alerts will be classified by different check output",
+	"maintenance":      "alerts will be suppressed during different windows",
+	"escalation":       "failing alerts will be escalated differently",
+	"dedupe_cooldown":  "duplicate alerts will be suppressed for a different time",
+	"flap_threshold":   "alerts will be considered flapping after a different number of changes",
+	"flap_window":      "alerts will be considered flapping over a different window",
+
+	"flap_rate_threshold": "alerts will be reported as flapping after a different number of changes",
+	"flap_rate_handlers":  "flapping alerts will be reported to different handlers",
+
+	"failures_before_alert":  "pending alerts will need a different number of failed observations",
+	"passes_before_recovery": "pending recoveries will need a different number of passing observations",
+	"alert_after":            "pending alerts will wait for a different delay",
+	"plugins":                "plugin handlers will run different commands",
+
+	"server_health_handlers": "alerts on the Consul servers will go to different handlers",
+
+	"prepared_query_failover_status": "prepared queries failing over will have a different status",
+	"ignored_checks":                 "different checks will be left out of the watches",
+	"consul_maintenance":             "checks in maintenance mode will be handled differently",
+	"consul_maintenance_notify":      "notifications of maintenance mode will be turned on or off",
+
+	"node_registrations_ignore":    "different nodes will be ignored when registering or leaving the catalog",
+	"service_registrations_ignore": "different services will be ignored when their instances change",
+}
+
+// Builds a snapshot of the given config for the audit trail
+func snapshotConfig(config *Config) configSnapshot {
+	snapshot := configSnapshot{
+		Settings: map[string]string{
+			"node_watch":       config.NodeWatch,
+			"service_watch":    config.ServiceWatch,
+			"change_threshold": fmt.Sprintf("%d", config.ChangeThreshold),
+			"dedupe_cooldown":  fmt.Sprintf("%d", config.DedupeCooldown),
+			"flap_threshold":   fmt.Sprintf("%d", config.FlapThreshold),
+			"flap_window":      fmt.Sprintf("%d", config.FlapWindow),
+			"aggregation":      config.Aggregation,
+			"default_handlers": fmt.Sprintf("%v", config.DefaultHandlers),
+			"node_handlers":    fmt.Sprintf("%v", config.NodeHandlers),
+			"severity_classes": fmt.Sprintf("%v", config.SeverityClasses),
+
+			"flap_rate_threshold": fmt.Sprintf("%d", config.FlapRateThreshold),
+			"flap_rate_handlers":  fmt.Sprintf("%v", config.FlapRateHandlers),
+
+			"failures_before_alert":  fmt.Sprintf("%d", config.FailuresBeforeAlert),
+			"passes_before_recovery": fmt.Sprintf("%d", config.PassesBeforeRecovery),
+			"alert_after":            config.AlertAfter,
+
+			"server_health_handlers": fmt.Sprintf("%v", config.ServerHealthHandlers),
+
+			"prepared_query_failover_status": config.PreparedQueryFailoverStatus,
+			"ignored_checks":                 fmt.Sprintf("%v", config.IgnoredChecks),
+			"consul_maintenance":             config.ConsulMaintenance,
+			"consul_maintenance_notify":      fmt.Sprintf("%t", config.ConsulMaintenanceNotify),
+
+			"node_registrations_ignore":    fmt.Sprintf("%v", config.NodeRegistrationsIgnore),
+			"service_registrations_ignore": fmt.Sprintf("%v", config.ServiceRegistrationsIgnore),
+		},
+		Services: make(map[string]string),
+		Handlers: make(map[string]string),
+	}
+
+	routes, _ := json.Marshal(config.Routes)
+	snapshot.Settings["routes"] = string(routes)
+
+	serviceFilters, _ := json.Marshal(config.ServiceFilters)
+	snapshot.Settings["service_filters"] = string(serviceFilters)
+
+	outputRules, _ := json.Marshal(config.OutputRules)
+	snapshot.Settings["output_rules"] = string(outputRules)
+
+	maintenance, _ := json.Marshal(config.Maintenance)
+	snapshot.Settings["maintenance"] = string(maintenance)
+
+	escalations, _ := json.Marshal(config.Escalations)
+	snapshot.Settings["escalation"] = config.Escalation + " " + string(escalations)
+
+	plugins, _ := json.Marshal(config.Plugins)
+	snapshot.Settings["plugins"] = string(plugins)
+
+	// Only the exported settings are compared, since unexported fields hold runtime state
+	// like compiled patterns, which would differ between two loads of the same config
+	for name, service := range config.Services {
+		settings, _ := json.Marshal(service)
+		snapshot.Services[name] = string(settings)
+	}
+
+	for name, handler := range config.Handlers {
+		settings, _ := json.Marshal(handler)
+		options, _ := json.Marshal(config.HandlerOptions[name])
+		mac := hmac.New(sha256.New, configHashKey)
+		mac.Write(append(settings, options...))
+		snapshot.Handlers[name] = fmt.Sprintf("%s:%x", reflect.TypeOf(handler).Name(), mac.Sum(nil)[:6])
+	}
+
+	return snapshot
+}
+
+// Returns a sorted, human-readable summary of the differences between two snapshots
+func diffConfigSnapshots(old, new configSnapshot) []string {
+	changes := make([]string, 0)
+
+	for _, key := range mergedKeys(old.Settings, new.Settings) {
+		if old.Settings[key] != new.Settings[key] {
+			change := fmt.Sprintf("setting %s changed from '%s' to '%s'", key, old.Settings[key], new.Settings[key])
+			if effect, ok := settingEffects[key]; ok {
+				change = change + " (" + effect + ")"
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	changes = append(changes, diffSnapshotMap("service", old.Services, new.Services, true)...)
+	changes = append(changes, diffSnapshotMap("handler", old.Handlers, new.Handlers, false)...)
+
+	return changes
+}
+
+// Diffs the entries of a snapshot map, including the old/new values if showValues is set
+func diffSnapshotMap(kind string, old, new map[string]string, showValues bool) []string {
+	changes := make([]string, 0)
+
+	for _, key := range mergedKeys(old, new) {
+		oldVal, inOld := old[key]
+		newVal, inNew := new[key]
+
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("%s %s added", kind, key))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("%s %s removed", kind, key))
+		case oldVal != newVal && showValues:
+			changes = append(changes, fmt.Sprintf("%s %s changed from %s to %s", kind, key, oldVal, newVal))
+		case oldVal != newVal:
+			changes = append(changes, fmt.Sprintf("%s %s changed", kind, key))
+		}
+	}
+
+	return changes
+}
+
+// Returns the sorted union of the keys of two maps
+func mergedKeys(a, b map[string]string) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Records the changes between the previous config and the given one, logging them and
+// writing an audit entry to the Consul KV store if config_audit_kv is enabled. If previous
+// is nil, the last snapshot stored in the KV store (if any) is used for the comparison.
+func recordConfigChange(client *api.Client, previous *configSnapshot, config *Config, reason string) {
+	if previous == nil && config.ConfigAuditKV {
+		if err := loadConfigHashKey(client); err != nil {
+			log.Error("Error loading the config hash key: ", err)
+		}
+	}
+
+	snapshot := snapshotConfig(config)
+
+	if previous == nil && config.ConfigAuditKV {
+		last, err := lastConfigSnapshot(client)
+		if err != nil {
+			log.Error("Error loading previous config snapshot: ", err)
+		}
+		previous = last
+	}
+
+	if previous == nil {
+		log.Infof("Config audit (%s): no previous config to compare against", reason)
+		previous = &configSnapshot{}
+	}
+
+	changes := diffConfigSnapshots(*previous, snapshot)
+	if len(changes) == 0 {
+		log.Infof("Config audit (%s): no changes", reason)
+		return
+	}
+
+	for _, change := range changes {
+		log.Infof("Config audit (%s): %s", reason, change)
+	}
+
+	if !config.ConfigAuditKV {
+		return
+	}
+
+	now := time.Now()
+	entry, err := json.Marshal(configAuditEntry{
+		Time:     now.UTC().Format(time.RFC3339),
+		Reason:   reason,
+		Changes:  changes,
+		Snapshot: snapshot,
+	})
+	if err != nil {
+		log.Error("Error forming config audit entry: ", err)
+		return
+	}
+
+	for _, key := range []string{fmt.Sprintf("%d", now.UnixNano()), "latest"} {
+		_, err = client.KV().Put(&api.KVPair{
+			Key:   configAuditKVPath + key,
+			Value: entry,
+		}, nil)
+		if err != nil {
+			log.Error("Error storing config audit entry in Consul: ", err)
+			return
+		}
+	}
+}
+
+// Loads the snapshot from the most recent audit entry in the KV store
+func lastConfigSnapshot(client *api.Client) (*configSnapshot, error) {
+	kvPair, _, err := client.KV().Get(configAuditKVPath+"latest", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if kvPair == nil || strings.TrimSpace(string(kvPair.Value)) == "" {
+		return nil, nil
+	}
+
+	var entry configAuditEntry
+	if err := json.Unmarshal(kvPair.Value, &entry); err != nil {
+		return nil, err
+	}
+
+	return &entry.Snapshot, nil
+}
+
+func newConfigHashKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// Loads the key handler settings are hashed with from the KV store, storing this process's
+// key if there isn't one yet. If another instance stored its key first, that one is used.
+func loadConfigHashKey(client *api.Client) error {
+	kv := client.KV()
+
+	kvPair, _, err := kv.Get(configHashKeyKVPath, nil)
+	if err != nil {
+		return err
+	}
+
+	if kvPair == nil || len(kvPair.Value) == 0 {
+		stored, _, err := kv.CAS(&api.KVPair{Key: configHashKeyKVPath, Value: configHashKey}, nil)
+		if err != nil || stored {
+			return err
+		}
+
+		if kvPair, _, err = kv.Get(configHashKeyKVPath, nil); err != nil {
+			return err
+		}
+		if kvPair == nil || len(kvPair.Value) == 0 {
+			return fmt.Errorf("the config hash key was removed while being stored")
+		}
+	}
+
+	configHashKey = kvPair.Value
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigAudit_diffSnapshots(t *testing.T) {
+	old, err := ParseConfig(`
+	service_watch = "local"
+
+	service "redis" {
+		change_threshold = 30
+	}
+
+	service "nginx" {}
+
+	handler "stdout" "log" {}
+
+	handler "pagerduty" "page_ops" {
+		service_key = "asdf1234"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	new, err := ParseConfig(`
+	service_watch = "global"
+
+	service "redis" {
+		change_threshold = 15
+	}
+
+	service "webapp" {}
+
+	handler "stdout" "log" {}
+
+	handler "pagerduty" "page_ops" {
+		service_key = "qwer5678"
+	}
+
+	handler "email" "admin" {
+		recipients = ["admin@example.com"]
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := diffConfigSnapshots(snapshotConfig(old), snapshotConfig(new))
+
+	expected := []string{
+		"setting service_watch changed from 'local' to 'global' (the set of watched services will change)",
+		"service nginx removed",
+		"service redis changed from " + snapshotConfig(old).Services["redis"] + " to " + snapshotConfig(new).Services["redis"],
+		"service webapp added",
+		"handler email.admin added",
+		"handler pagerduty.page_ops changed",
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes:\n%#v\ngot:\n%#v", expected, changes)
+	}
+
+	if changes := diffConfigSnapshots(snapshotConfig(new), snapshotConfig(new)); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+func TestConfigAudit_snapshotHashes(t *testing.T) {
+	config, err := ParseConfig(`
+	service "redis" {
+		ignored_checks = ["^serfHealth$"]
+		message_template = "{{.Service}} is {{.Status}}"
+	}
+
+	handler "pagerduty" "page_ops" {
+		service_key = "asdf1234"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reparsing the same config compiles its patterns and templates again, which mustn't look like a change
+	reparsed, err := ParseConfig(`
+	service "redis" {
+		ignored_checks = ["^serfHealth$"]
+		message_template = "{{.Service}} is {{.Status}}"
+	}
+
+	handler "pagerduty" "page_ops" {
+		service_key = "asdf1234"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := diffConfigSnapshots(snapshotConfig(config), snapshotConfig(reparsed)); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+
+	// The handler hashes depend on the key, so they can't be recomputed without it
+	before := snapshotConfig(config).Handlers["pagerduty.page_ops"]
+	defer func(key []byte) { configHashKey = key }(configHashKey)
+	configHashKey = newConfigHashKey()
+	if after := snapshotConfig(config).Handlers["pagerduty.page_ops"]; after == before {
+		t.Errorf("expected the hash to change with the key, got %s", after)
+	}
+}
+
+func TestConfigAudit_loadHashKey(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	defer func(key []byte) { configHashKey = key }(configHashKey)
+
+	if err := loadConfigHashKey(client); err != nil {
+		t.Fatal(err)
+	}
+	stored := values[configHashKeyKVPath]
+	if len(stored) != 32 || string(stored) != string(configHashKey) {
+		t.Fatalf("expected the key to be stored, got %x", stored)
+	}
+
+	// Another instance starting up uses the stored key
+	configHashKey = newConfigHashKey()
+	if err := loadConfigHashKey(client); err != nil {
+		t.Fatal(err)
+	}
+	if string(configHashKey) != string(stored) {
+		t.Errorf("expected the stored key to be used, got %x", configHashKey)
+	}
+}
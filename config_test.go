@@ -1,12 +1,18 @@
 package main
 
 import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul/api"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -38,6 +44,7 @@ func TestConfig_parseValues(t *testing.T) {
 
 	service "redis" {
 		change_threshold = 15
+		aggregation = "node"
 		distinct_tags = true
 		ignored_tags = ["seed", "node"]
 	}
@@ -63,6 +70,15 @@ func TestConfig_parseValues(t *testing.T) {
 		api_token = "mytoken"
 		channel_name = "alerts"
 	}
+
+	handler "webhook" "events" {
+		urls = ["http://localhost:8080/alerts"]
+		method = "put"
+		headers {
+			X-Source = "consul-alerting"
+		}
+		bearer_token = "secret"
+	}
 	`
 
 	config, err := ParseConfig(configString)
@@ -71,25 +87,76 @@ func TestConfig_parseValues(t *testing.T) {
 	}
 
 	expected := &Config{
-		ConsulAddress:    "localhost:8500",
-		ConsulToken:      "test_token",
-		ConsulDatacenter: "testdc",
-		NodeWatch:        "local",
-		ServiceWatch:     "global",
-		ChangeThreshold:  30,
-		DefaultHandlers:  []string{"stdout.warn", "email.admin"},
-		LogLevel:         "warn",
+		ConsulAddress:        "localhost:8500",
+		ConsulToken:          "test_token",
+		ConsulDatacenter:     "testdc",
+		ConsulWaitTime:       10,
+		NodeWatch:            "local",
+		ServiceWatch:         "global",
+		ChangeThreshold:      30,
+		FlapWindow:           600,
+		StormWindow:          60,
+		FailuresBeforeAlert:  1,
+		PassesBeforeRecovery: 1,
+		RetryInitialInterval: 5,
+		RetryMaxInterval:     60,
+
+		ConsulRetryInitialInterval: 10,
+		ConsulRetryMaxInterval:     60,
+		AllowStale:                 true,
+
+		DispatchWorkers:      8,
+		DispatchQueueSize:    100,
+		QueueRetryInterval:   30,
+		ShutdownTimeout:      30,
+		ResyncInterval:       300,
+		RedactCredentials:    true,
+		StateStore:           "consul",
+		StatsdPrefix:         "consul_alerting.",
+		TracingServiceName:   "consul-alerting",
+		TracingSampleRate:    1,
+		HistorySize:          1000,
+		AuditLogMaxSize:      100,
+		AuditLogMaxBackups:   5,
+		LogFileMaxSize:       100,
+		LogFileMaxBackups:    5,
+		Aggregation:          "service",
+		DefaultHandlers:      []string{"stdout.warn", "email.admin"},
+		LogLevel:             "warn",
+		LogFormat:            "text",
+		VaultRefreshInterval: 300,
+		SilenceKVPrefix:      "service/consul-alerting/silences/",
+
+		ServerLeaderStableTime: 300,
+		AgentLeftStatus:        "warning",
+
+		PreparedQueryFailoverStatus: "warning",
+		ConsulMaintenance:           "suppress",
+		HASessionTTL:                "10s",
+		ReceiveSignatureTolerance:   300,
+		SeverityClasses: map[string][]string{
+			"warning":  []string{"notify"},
+			"critical": []string{"notify", "paging"},
+		},
 		Services: map[string]ServiceConfig{
 			"redis": ServiceConfig{
-				Name:            "redis",
-				ChangeThreshold: 15,
-				DistinctTags:    true,
-				IgnoredTags:     []string{"seed", "node"},
+				Name:                 "redis",
+				ChangeThreshold:      15,
+				FlapWindow:           600,
+				FailuresBeforeAlert:  1,
+				PassesBeforeRecovery: 1,
+				Aggregation:          "node",
+				DistinctTags:         true,
+				IgnoredTags:          []string{"seed", "node"},
 			},
 			"webapp": ServiceConfig{
-				Name:            "webapp",
-				ChangeThreshold: 30,
-				Handlers:        []string{"email.admin"},
+				Name:                 "webapp",
+				ChangeThreshold:      30,
+				FlapWindow:           600,
+				FailuresBeforeAlert:  1,
+				PassesBeforeRecovery: 1,
+				Aggregation:          "service",
+				Handlers:             []string{"email.admin"},
 			},
 		},
 		Handlers: map[string]AlertHandler{
@@ -100,17 +167,38 @@ func TestConfig_parseValues(t *testing.T) {
 			"email.admin": EmailHandler{
 				Recipients: []string{"admin@example.com"},
 				MaxRetries: 5,
+				SendMode:   "individual",
+				Transport:  "mx",
+				SMTPTLS:    "starttls",
 			},
 			"pagerduty.page_ops": PagerdutyHandler{
 				ServiceKey: "asdf1234",
 				MaxRetries: 10,
+				EventsURL:  pagerdutyEventsURL,
 			},
 			"slack.dev_channel": SlackHandler{
 				Token:       "mytoken",
 				ChannelName: "alerts",
 				MaxRetries:  5,
+				APIURL:      slackAPIURL,
+			},
+			"webhook.events": WebhookHandler{
+				URLs:        []string{"http://localhost:8080/alerts"},
+				Method:      "PUT",
+				Headers:     map[string]string{"X-Source": "consul-alerting"},
+				BearerToken: "secret",
+				MaxRetries:  5,
+
+				SignatureHeader: defaultSignatureHeader,
 			},
 		},
+		HandlerOptions: map[string]HandlerOptions{
+			"stdout.warn":        HandlerOptions{Class: "notify"},
+			"email.admin":        HandlerOptions{Class: "notify"},
+			"pagerduty.page_ops": HandlerOptions{Class: "paging"},
+			"slack.dev_channel":  HandlerOptions{Class: "notify"},
+			"webhook.events":     HandlerOptions{Class: "notify"},
+		},
 	}
 
 	if !reflect.DeepEqual(config, expected) {
@@ -122,6 +210,42 @@ func TestConfig_parseValues(t *testing.T) {
 	}
 }
 
+func TestConfig_sharding(t *testing.T) {
+	if _, err := ParseConfig(`
+	sharding = true
+	service_watch = "global"
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseConfig(`sharding = true`); err == nil {
+		t.Error("expected an error for sharding with local service watches")
+	}
+	if _, err := ParseConfig(`
+	sharding = true
+	service_watch = "global"
+	high_availability = true
+	`); err == nil {
+		t.Error("expected an error for sharding with high_availability")
+	}
+}
+
+func TestConfig_invalidAggregation(t *testing.T) {
+	_, err := ParseConfig(`aggregation = "cluster"`)
+	if err == nil {
+		t.Fatal("expected error, but nothing was returned")
+	}
+
+	_, err = ParseConfig(`
+	service "redis" {
+		aggregation = "cluster"
+	}
+	`)
+	if err == nil {
+		t.Fatal("expected error, but nothing was returned")
+	}
+}
+
 func TestConfig_defaultHandlers(t *testing.T) {
 	config := &Config{
 		DefaultHandlers: []string{"stdout.warn"},
@@ -132,7 +256,7 @@ func TestConfig_defaultHandlers(t *testing.T) {
 		},
 	}
 
-	handlers := config.serviceHandlers("")
+	handlers := config.serviceHandlers("", nil)
 
 	if len(handlers) != len(config.Handlers) {
 		t.Fatalf("expected %d handlers, got %d", len(config.Handlers), len(handlers))
@@ -143,6 +267,43 @@ func TestConfig_defaultHandlers(t *testing.T) {
 	}
 }
 
+func TestConfig_nodeHandlers(t *testing.T) {
+	config, err := ParseConfig(`
+default_handlers = ["stdout.default"]
+node_handlers = ["stdout.nodes"]
+
+handler "stdout" "default" {}
+handler "stdout" "nodes" {}
+
+route "db-nodes" {
+  node = "db-.*"
+  handlers = ["stdout.default"]
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service  string
+		node     string
+		expected []string
+	}{
+		{"", "web-1", []string{"stdout.nodes"}},
+		{"", "db-1", []string{"stdout.default"}},
+		{"redis", "web-1", []string{"stdout.default"}},
+	}
+	for _, c := range cases {
+		names := config.severityHandlerNames("dc1", c.service, c.node, nil, nil, "critical", "passing")
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%s on %s: expected handlers %v, got %v", c.service, c.node, c.expected, names)
+		}
+	}
+
+	if _, err := ParseConfig(`node_handlers = ["stdout.missing"]`); err == nil || !strings.Contains(err.Error(), "Unknown handler stdout.missing in node_handlers") {
+		t.Errorf("expected an error for an unknown node handler, got %v", err)
+	}
+}
+
 func TestConfig_serviceHandlers(t *testing.T) {
 	config := &Config{
 		Services: map[string]ServiceConfig{
@@ -158,7 +319,7 @@ func TestConfig_serviceHandlers(t *testing.T) {
 		},
 	}
 
-	handlers := config.serviceHandlers("webapp")
+	handlers := config.serviceHandlers("webapp", nil)
 
 	if len(handlers) != len(config.Handlers) {
 		t.Fatalf("expected %d handlers, got %d", len(config.Handlers), len(handlers))
@@ -168,3 +329,293 @@ func TestConfig_serviceHandlers(t *testing.T) {
 		t.Fatalf("expected \n%#v\n\n, got \n\n%#v\n\n", config.Handlers["stdout.warn"], config)
 	}
 }
+
+func TestConfig_serviceTagHandlers(t *testing.T) {
+	config, err := ParseConfig(`
+	default_handlers = ["stdout.log"]
+
+	service "jobs" {
+		handlers = ["slack.jobs"]
+		tag_handlers {
+			payments = ["pagerduty.payments"]
+			reports = ["slack.reports"]
+		}
+	}
+
+	handler "stdout" "log" {}
+	handler "pagerduty" "payments" {
+		service_key = "asdf1234"
+	}
+	handler "slack" "jobs" {
+		api_token = "token"
+		channel_name = "jobs"
+	}
+	handler "slack" "reports" {
+		api_token = "token"
+		channel_name = "reports"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service  string
+		tags     []string
+		expected []string
+	}{
+		{"redis", nil, []string{"stdout.log"}},
+		{"jobs", nil, []string{"slack.jobs"}},
+		{"jobs", []string{"reports"}, []string{"slack.reports"}},
+		{"jobs", []string{"payments", "reports"}, []string{"pagerduty.payments", "slack.reports"}},
+
+		// The handlers tag overrides the config
+		{"jobs", []string{"reports", "alerting.handlers=stdout.log"}, []string{"stdout.log"}},
+		{"redis", []string{"alerting.handlers=slack.jobs,slack.reports"}, []string{"slack.jobs", "slack.reports"}},
+	}
+
+	for _, c := range cases {
+		names := config.serviceHandlerNames(c.service, c.tags)
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%s %v: expected handlers %v, got %v", c.service, c.tags, c.expected, names)
+		}
+	}
+}
+
+func TestConfig_observationsRequired(t *testing.T) {
+	config, err := ParseConfig(`
+	failures_before_alert = 3
+
+	service "redis" {
+		passes_before_recovery = 2
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service  string
+		status   string
+		expected int
+	}{
+		{"web", api.HealthCritical, 3},
+		{"web", api.HealthPassing, 1},
+		{"redis", api.HealthWarning, 3},
+		{"redis", api.HealthPassing, 2},
+	}
+	for _, c := range cases {
+		if actual := config.serviceObservationsRequired(c.service, c.status); actual != c.expected {
+			t.Errorf("expected %d observations for %s %s, got %d", c.expected, c.service, c.status, actual)
+		}
+	}
+
+	for _, raw := range []string{
+		`failures_before_alert = 0`,
+		`service "redis" {
+			passes_before_recovery = 0
+		}`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Fatalf("expected error for %q, but nothing was returned", raw)
+		}
+	}
+}
+
+func TestConfig_alertAfter(t *testing.T) {
+	config, err := ParseConfig(`
+	change_threshold = 30
+	alert_after = "2m"
+
+	service "redis" {
+		alert_after = "90s"
+	}
+
+	service "web" {
+		alert_after = ""
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service  string
+		status   string
+		expected time.Duration
+	}{
+		{"consul", api.HealthCritical, 2 * time.Minute},
+		{"consul", api.HealthPassing, 30 * time.Second},
+		{"redis", api.HealthWarning, 90 * time.Second},
+		{"redis", api.HealthPassing, 30 * time.Second},
+		{"web", api.HealthCritical, 30 * time.Second},
+	}
+	for _, c := range cases {
+		if actual := config.serviceAlertDelay(c.service, c.status); actual != c.expected {
+			t.Errorf("expected a delay of %s for %s %s, got %s", c.expected, c.service, c.status, actual)
+		}
+	}
+
+	for _, raw := range []string{
+		`alert_after = "soon"`,
+		`alert_after = "-1m"`,
+		`service "redis" {
+			alert_after = "2"
+		}`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Fatalf("expected error for %q, but nothing was returned", raw)
+		}
+	}
+}
+
+func TestConfig_unknownServiceHandler(t *testing.T) {
+	_, err := ParseConfig(`
+	service "jobs" {
+		tag_handlers {
+			reports = ["slack.missing"]
+		}
+	}
+
+	handler "stdout" "log" {}
+	`)
+	if err == nil {
+		t.Fatal("expected error, but nothing was returned")
+	}
+}
+
+func TestConfig_severityHandlers(t *testing.T) {
+	config, err := ParseConfig(`
+	service "webapp" {
+		severity_classes {
+			warning = ["notify", "paging"]
+		}
+	}
+
+	handler "stdout" "log" {}
+
+	handler "pagerduty" "page_ops" {
+		service_key = "asdf1234"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service    string
+		tags       []string
+		status     string
+		lastStatus string
+		expected   []string
+	}{
+		// Warnings don't page by default, criticals do
+		{"redis", nil, "warning", "passing", []string{"stdout.log"}},
+		{"redis", nil, "critical", "passing", []string{"pagerduty.page_ops", "stdout.log"}},
+
+		// Recoveries only go to the classes that received the failure, or to every
+		// handler if it isn't known
+		{"redis", nil, "passing", "warning", []string{"stdout.log"}},
+		{"redis", nil, "passing", "critical", []string{"pagerduty.page_ops", "stdout.log"}},
+		{"redis", nil, "passing", "", []string{"pagerduty.page_ops", "stdout.log"}},
+
+		// Handlers that were paged keep getting updates until recovery
+		{"redis", nil, "warning", "critical", []string{"pagerduty.page_ops", "stdout.log"}},
+
+		// The service block opts in to paging on warnings
+		{"webapp", nil, "warning", "passing", []string{"pagerduty.page_ops", "stdout.log"}},
+
+		// Tags take precedence over the service block
+		{"webapp", []string{"alerting.warning=notify"}, "warning", "passing", []string{"stdout.log"}},
+		{"redis", []string{"alerting.warning=paging"}, "warning", "passing", []string{"pagerduty.page_ops"}},
+	}
+
+	for _, c := range cases {
+		names := config.severityHandlerNames("dc1", c.service, "", nil, c.tags, c.status, c.lastStatus)
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%s %v %s->%s: expected handlers %v, got %v", c.service, c.tags, c.lastStatus, c.status, c.expected, names)
+		}
+	}
+
+	// An incident paged by a critical alert is still resolved after a downgrade to warning
+	alert := &AlertState{}
+	for _, status := range []string{"critical", "warning"} {
+		recordAlerted(alert, status)
+	}
+	names := config.severityHandlerNames("dc1", "redis", "", nil, nil, "passing", alertedStatuses(alert, "warning")...)
+	if !reflect.DeepEqual(names, []string{"pagerduty.page_ops", "stdout.log"}) {
+		t.Errorf("critical->warning->passing: expected the paged handler to get the recovery, got %v", names)
+	}
+	if recordAlerted(alert, "passing"); alert.Alerted != nil {
+		t.Errorf("expected the alerted statuses to be cleared on recovery, got %v", alert.Alerted)
+	}
+}
+
+func TestConfig_invalidSeverityClasses(t *testing.T) {
+	_, err := ParseConfig(`
+	severity_classes {
+		passing = ["paging"]
+	}
+	`)
+	if err == nil {
+		t.Fatal("expected error, but nothing was returned")
+	}
+}
+
+func TestConfig_consulTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Config": {"NodeName": "node1"}}`))
+	}))
+	defer server.Close()
+
+	caFile := path.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test certificate is valid for example.com, and the address has no scheme
+	config := &Config{
+		ConsulAddress:       strings.TrimPrefix(server.URL, "https://"),
+		ConsulCAFile:        caFile,
+		ConsulTLSServerName: "example.com",
+	}
+	clientConfig, err := config.consulClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientConfig.Scheme != "https" {
+		t.Errorf("expected the TLS settings to switch to https, got %s", clientConfig.Scheme)
+	}
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := client.Agent().NodeName(); err != nil || name != "node1" {
+		t.Errorf("expected to connect over TLS, got %q, %v", name, err)
+	}
+
+	config.ConsulTLSServerName = "consul.example.org"
+	if clientConfig, err = config.consulClientConfig(); err != nil {
+		t.Fatal(err)
+	}
+	client, _ = api.NewClient(clientConfig)
+	if _, err := client.Agent().NodeName(); err == nil {
+		t.Error("expected a certificate not valid for the server name to be rejected")
+	}
+
+	// The scheme and client certificate are checked
+	cases := []struct {
+		config   *Config
+		expected string
+	}{
+		{&Config{ConsulAddress: "http://localhost:8501", ConsulCAFile: caFile}, "need consul_address to use https"},
+		{&Config{ConsulAddress: "localhost:8501", ConsulCertFile: caFile}, "consul_cert_file and consul_key_file must be set together"},
+		{&Config{ConsulAddress: "localhost:8501", ConsulCAFile: caFile + ".missing"}, "failed to read CA file"},
+	}
+	for _, c := range cases {
+		if _, err := c.config.consulClientConfig(); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("expected an error containing %q, got %v", c.expected, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The checks Consul registers while a node or a service instance is in maintenance mode.
+// The service check's ID is followed by the service ID.
+const (
+	nodeMaintenanceCheck     = "_node_maintenance"
+	serviceMaintenancePrefix = "_service_maintenance:"
+)
+
+// What happens to the checks of nodes and service instances in Consul's maintenance mode
+const (
+	ConsulMaintenanceSuppress = "suppress"
+	ConsulMaintenanceAlert    = "alert"
+)
+
+// Tracks the nodes and service instances a watch sees in maintenance mode, keyed by node
+// and node/serviceID, along with the reason given for the maintenance
+type maintenanceTracker struct {
+	nodes     map[string]string
+	instances map[string]string
+}
+
+// Returns the reason given for a maintenance check, which Consul keeps in its notes
+func maintenanceReason(check *api.HealthCheck) string {
+	if check.Notes != "" {
+		return check.Notes
+	}
+	return check.Output
+}
+
+// Returns the nodes and the service instances whose maintenance checks are in the checks
+func findMaintenance(checks []*api.HealthCheck) (map[string]string, map[string]string) {
+	nodes := make(map[string]string)
+	instances := make(map[string]string)
+	for _, check := range checks {
+		if check.Status == api.HealthPassing {
+			continue
+		}
+		if check.CheckID == nodeMaintenanceCheck {
+			nodes[check.Node] = maintenanceReason(check)
+		} else if strings.HasPrefix(check.CheckID, serviceMaintenancePrefix) {
+			instances[check.Node+"/"+strings.TrimPrefix(check.CheckID, serviceMaintenancePrefix)] = maintenanceReason(check)
+		}
+	}
+	return nodes, instances
+}
+
+// Looks up the nodes in maintenance mode, for service watches whose checks don't include
+// the node checks
+func getNodeMaintenance(config *Config, client *api.Client) (map[string]string, error) {
+	checks, _, err := client.Health().State(api.HealthCritical, config.queryOptions())
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching nodes in maintenance mode: %s", err)
+	}
+	nodes, _ := findMaintenance(checks)
+	return nodes, nil
+}
+
+// Updates the nodes and service instances in maintenance mode from a watch's checks,
+// notifying when they enter or leave it if consul_maintenance_notify is set, and returns
+// the checks to alert on. With the suppress mode, the checks of nodes and instances in
+// maintenance are left out, so their alerts keep the status they had before it started.
+// Node watches notify on nodes, service watches on the service's instances.
+func (m *maintenanceTracker) update(config *Config, opts *WatchOptions, checks []*api.HealthCheck) ([]*api.HealthCheck, error) {
+	if config.ConsulMaintenance == ConsulMaintenanceAlert {
+		return checks, nil
+	}
+
+	nodes, instances := findMaintenance(checks)
+	if opts.service != "" && opts.client != nil {
+		failing := false
+		for _, check := range checks {
+			if check.Status != api.HealthPassing {
+				failing = true
+			}
+		}
+		if failing {
+			var err error
+			if nodes, err = getNodeMaintenance(config, opts.client); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if m.nodes != nil && config.ConsulMaintenanceNotify {
+		if opts.service == "" {
+			notifyMaintenanceChanges(config, "", m.nodes, nodes)
+		} else {
+			notifyMaintenanceChanges(config, opts.service, m.instances, instances)
+		}
+	}
+	m.nodes, m.instances = nodes, instances
+
+	kept := make([]*api.HealthCheck, 0, len(checks))
+	for _, check := range checks {
+		if _, ok := nodes[check.Node]; ok {
+			continue
+		}
+		if _, ok := instances[check.Node+"/"+check.ServiceID]; ok && check.ServiceID != "" {
+			continue
+		}
+		if strings.HasPrefix(check.CheckID, serviceMaintenancePrefix) {
+			if _, ok := instances[check.Node+"/"+strings.TrimPrefix(check.CheckID, serviceMaintenancePrefix)]; ok {
+				continue
+			}
+		}
+		kept = append(kept, check)
+	}
+	return kept, nil
+}
+
+// Notifies about the nodes or service instances that entered or left maintenance mode
+// between two observations
+func notifyMaintenanceChanges(config *Config, service string, previous map[string]string, current map[string]string) {
+	for key, reason := range current {
+		if _, ok := previous[key]; !ok {
+			notifyMaintenance(config, service, key, reason, true)
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			notifyMaintenance(config, service, key, "", false)
+		}
+	}
+}
+
+// Sends a passing notice about a node, or a service instance keyed by node/serviceID,
+// entering or leaving maintenance mode
+func notifyMaintenance(config *Config, service string, key string, reason string, started bool) {
+	node := key
+	target := "node " + node
+	if service != "" {
+		parts := strings.SplitN(key, "/", 2)
+		node = parts[0]
+		target = fmt.Sprintf("service %s on node %s", service, node)
+	}
+
+	alert := &AlertState{
+		Status:      api.HealthPassing,
+		Node:        node,
+		Service:     service,
+		Check:       "maintenance",
+		LastAlerted: api.HealthPassing,
+	}
+	if started {
+		alert.Message = fmt.Sprintf("[%s] %s entered maintenance mode", config.ConsulDatacenter, target)
+		alert.Details = reason
+	} else {
+		alert.Message = fmt.Sprintf("[%s] %s left maintenance mode", config.ConsulDatacenter, target)
+	}
+	log.Info(alert.Message)
+
+	alert.Severity = config.alertSeverity(service, alert.Status)
+	config.applyMessageTemplates(service, alert)
+	notifyHandlers(config, service, nil, alert, api.HealthPassing)
+	countAlert(alert)
+	history.record(config.ConsulDatacenter, alert, time.Now())
+}
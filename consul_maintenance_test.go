@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestConsulMaintenance_update(t *testing.T) {
+	config, alertCh := testAlertConfig()
+	config.ConsulDatacenter = "dc1"
+	config.ConsulMaintenance = ConsulMaintenanceSuppress
+	config.ConsulMaintenanceNotify = true
+	opts := &WatchOptions{node: "node1", config: config}
+
+	serfCheck := &api.HealthCheck{Node: "node1", CheckID: "serfHealth", Status: api.HealthPassing}
+	tracker := &maintenanceTracker{}
+	if checks, err := tracker.update(config, opts, []*api.HealthCheck{serfCheck}); err != nil || len(checks) != 1 {
+		t.Fatalf("expected the check to be kept, got %v (%v)", checks, err)
+	}
+
+	checks, err := tracker.update(config, opts, []*api.HealthCheck{
+		{Node: "node1", CheckID: "serfHealth", Status: api.HealthCritical},
+		{Node: "node1", CheckID: nodeMaintenanceCheck, Status: api.HealthCritical, Notes: "kernel upgrade"},
+	})
+	if err != nil || len(checks) != 0 {
+		t.Errorf("expected the checks of the node in maintenance to be left out, got %v (%v)", checks, err)
+	}
+	alert := <-alertCh
+	if alert.Message != "[dc1] node node1 entered maintenance mode" || alert.Details != "kernel upgrade" || alert.Status != api.HealthPassing {
+		t.Errorf("unexpected notification: %+v", alert)
+	}
+
+	if checks, _ := tracker.update(config, opts, []*api.HealthCheck{serfCheck}); len(checks) != 1 {
+		t.Errorf("expected the check to be kept after the maintenance, got %v", checks)
+	}
+	if alert := <-alertCh; alert.Message != "[dc1] node node1 left maintenance mode" {
+		t.Errorf("unexpected notification: %s", alert.Message)
+	}
+
+	// The instances of a service in maintenance are left out of its watch
+	opts = &WatchOptions{service: "redis", config: config}
+	tracker = &maintenanceTracker{}
+	checks, _ = tracker.update(config, opts, []*api.HealthCheck{
+		{Node: "node1", CheckID: "service:redis1", ServiceID: "redis1", Status: api.HealthCritical},
+		{Node: "node1", CheckID: serviceMaintenancePrefix + "redis1", ServiceID: "redis1", Status: api.HealthCritical},
+		{Node: "node2", CheckID: "service:redis2", ServiceID: "redis2", Status: api.HealthCritical},
+	})
+	if len(checks) != 1 || checks[0].Node != "node2" {
+		t.Errorf("expected only the check of node2 to be kept, got %v", checks)
+	}
+
+	config.ConsulMaintenance = ConsulMaintenanceAlert
+	if checks, _ := (&maintenanceTracker{}).update(config, opts, []*api.HealthCheck{
+		{Node: "node1", CheckID: serviceMaintenancePrefix + "redis1", ServiceID: "redis1", Status: api.HealthCritical},
+	}); len(checks) != 1 {
+		t.Errorf("expected the maintenance checks to be alerted on, got %v", checks)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var staleReads = metrics.register("consul_alerting_consul_stale_reads_total",
+	"Stale reads from Consul that were made again from the leader, for being older than max_stale.", "counter")
+
+// Maximum time to wait for a blocking (watch) query to Consul, set by consul_wait_time
+var watchWaitTime = 10 * time.Second
+
+// How long the watches wait before retrying after an error from Consul. The backoff is
+// shared by every watch, so when Consul fails they all back off together instead of
+// retrying in lockstep, and it starts over once a request to Consul succeeds. Also keeps
+// when the requests started failing, for alerting on Consul being unreachable.
+var consulBackoff = struct {
+	sync.Mutex
+	policy       retryPolicy
+	failures     int
+	failingSince time.Time
+}{
+	policy: retryPolicy{initial: 10 * time.Second, max: 60 * time.Second},
+}
+
+// Applies the blocking query and retry settings. Must be called before the watches start.
+func setConsulQuerySettings(config *Config) {
+	watchWaitTime = time.Duration(config.ConsulWaitTime) * time.Second
+
+	consulBackoff.Lock()
+	defer consulBackoff.Unlock()
+	consulBackoff.policy = retryPolicy{
+		initial: time.Duration(config.ConsulRetryInitialInterval) * time.Second,
+		max:     time.Duration(config.ConsulRetryMaxInterval) * time.Second,
+	}
+}
+
+// Returns how long to wait before retrying a failed query to Consul
+func consulErrorWait() time.Duration {
+	consulBackoff.Lock()
+	defer consulBackoff.Unlock()
+	failures := consulBackoff.failures
+	if failures < 1 {
+		failures = 1
+	}
+	return consulBackoff.policy.wait(failures)
+}
+
+// Records the result of a request to Consul for the backoff
+func recordConsulRequest(failed bool) {
+	consulBackoff.Lock()
+	defer consulBackoff.Unlock()
+	if failed {
+		if consulBackoff.failures == 0 {
+			consulBackoff.failingSince = time.Now()
+		}
+		consulBackoff.failures++
+	} else {
+		consulBackoff.failures = 0
+		consulBackoff.failingSince = time.Time{}
+	}
+}
+
+// Returns when the requests to Consul started failing, or the zero time if the last one
+// succeeded
+func consulFailingSince() time.Time {
+	consulBackoff.Lock()
+	defer consulBackoff.Unlock()
+	return consulBackoff.failingSince
+}
+
+// Spaces out requests to at most rate per second, allowing bursts of up to rate requests
+// after a quiet period
+type requestLimiter struct {
+	sync.Mutex
+	interval time.Duration
+	burst    time.Duration
+	next     time.Time
+}
+
+func newRequestLimiter(rate int) *requestLimiter {
+	interval := time.Second / time.Duration(rate)
+	return &requestLimiter{interval: interval, burst: time.Duration(rate-1) * interval}
+}
+
+// Returns how long a request made now has to wait for its turn, reserving it
+func (l *requestLimiter) reserve(now time.Time) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+	if earliest := now.Add(-l.burst); l.next.Before(earliest) {
+		l.next = earliest
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Throttles the requests to Consul to consul_rate_limit and records their results for
+// the backoff. Only failed requests and server errors count as failures, since some
+// endpoints (like the autopilot health) answer with other error codes on purpose.
+// Stale reads answered by a server that has been out of contact with the leader for
+// longer than max_stale are made again as consistent reads.
+type consulTransport struct {
+	limiter  *requestLimiter
+	maxStale time.Duration
+	base     http.RoundTripper
+}
+
+func (t *consulTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.limiter != nil {
+		if wait := t.limiter.reserve(time.Now()); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			}
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && t.tooStale(req, resp) {
+		resp.Body.Close()
+		log.Debugf("Stale read of %s was %sms behind the leader, reading from the leader", req.URL.Path, resp.Header.Get("X-Consul-LastContact"))
+		staleReads.add(1)
+
+		consistent := req.Clone(req.Context())
+		query := consistent.URL.Query()
+		query.Del("stale")
+		consistent.URL.RawQuery = query.Encode()
+		resp, err = t.base.RoundTrip(consistent)
+	}
+	recordConsulRequest(err != nil || resp.StatusCode >= 500)
+	return resp, err
+}
+
+// Returns whether a stale read was answered by a server that was out of contact with
+// the leader for longer than max_stale
+func (t *consulTransport) tooStale(req *http.Request, resp *http.Response) bool {
+	if t.maxStale <= 0 || req.Method != "GET" {
+		return false
+	}
+	if _, ok := req.URL.Query()["stale"]; !ok {
+		return false
+	}
+	lastContact, err := strconv.ParseUint(resp.Header.Get("X-Consul-LastContact"), 10, 64)
+	return err == nil && time.Duration(lastContact)*time.Millisecond > t.maxStale
+}
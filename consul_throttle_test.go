@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConsulThrottle_requestLimiter(t *testing.T) {
+	limiter := newRequestLimiter(10)
+	now := time.Now()
+
+	// A burst of up to the rate goes through after a quiet period
+	for i := 0; i < 10; i++ {
+		if wait := limiter.reserve(now); wait != 0 {
+			t.Fatalf("expected request %d of the burst not to wait, got %s", i, wait)
+		}
+	}
+	if wait := limiter.reserve(now); wait != 100*time.Millisecond {
+		t.Errorf("expected the next request to wait for its turn, got %s", wait)
+	}
+	if wait := limiter.reserve(now); wait != 200*time.Millisecond {
+		t.Errorf("expected the requests to be spaced out, got %s", wait)
+	}
+}
+
+func TestConsulThrottle_backoff(t *testing.T) {
+	defer setConsulQuerySettings(&Config{ConsulWaitTime: 10, ConsulRetryInitialInterval: 10, ConsulRetryMaxInterval: 60})
+	setConsulQuerySettings(&Config{ConsulWaitTime: 5, ConsulRetryInitialInterval: 2, ConsulRetryMaxInterval: 8})
+	if watchWaitTime != 5*time.Second {
+		t.Errorf("expected the wait time to be set, got %s", watchWaitTime)
+	}
+
+	failures := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &consulTransport{base: http.DefaultTransport}}
+
+	failures = 3
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if wait := consulErrorWait(); wait < 4*time.Second || wait > 8*time.Second {
+		t.Errorf("expected the backoff to grow with the failures, got %s", wait)
+	}
+
+	// Responses other than server errors aren't failures, and reset the backoff
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if wait := consulErrorWait(); wait < time.Second || wait > 2*time.Second {
+		t.Errorf("expected the backoff to start over, got %s", wait)
+	}
+}
+
+func TestConsulThrottle_config(t *testing.T) {
+	config, err := ParseConfig(`consul_rate_limit = 50`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := config.consulClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport, ok := clientConfig.HttpClient.Transport.(*consulTransport); !ok || transport.limiter == nil {
+		t.Errorf("expected the requests to Consul to be throttled")
+	}
+
+	if _, err := ParseConfig(`consul_wait_time = 0`); err == nil {
+		t.Error("expected an error for a zero wait time")
+	}
+	if _, err := ParseConfig(`consul_retry_max_interval = 5`); err == nil {
+		t.Error("expected an error for a max interval below the initial one")
+	}
+}
+
+func TestConsulThrottle_maxStale(t *testing.T) {
+	var consistent int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["stale"]; ok {
+			w.Header().Set("X-Consul-LastContact", "15000")
+		} else {
+			consistent++
+			w.Header().Set("X-Consul-LastContact", "0")
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &consulTransport{base: http.DefaultTransport, maxStale: 10 * time.Second}}
+
+	resp, err := client.Get(server.URL + "/v1/health/service/redis?stale=&index=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if consistent != 1 || resp.Header.Get("X-Consul-LastContact") != "0" {
+		t.Errorf("expected a stale read past max_stale to be read again from the leader")
+	}
+
+	// Consistent reads and stale reads within max_stale are used as they are
+	client.Transport.(*consulTransport).maxStale = 20 * time.Second
+	resp, err = client.Get(server.URL + "/v1/health/service/redis?stale=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if consistent != 1 {
+		t.Errorf("expected the stale read to be used, got %d consistent reads", consistent)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The pseudo-services that don't have a page of their own in the Consul UI
+var pseudoServices = []string{
+	agentHealthService, consulAgentService, kvWatchService, lockWatchService, nodeRegistrationService,
+	preparedQueryService, serverHealthService, stormService, testAlertService, wanHealthService,
+}
+
+// Makes sure consul_ui_url is an absolute http(s) URL
+func validateConsulUIURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("consul_ui_url must be an http or https URL, got %q", raw)
+	}
+	return nil
+}
+
+// Returns the link to the page of an alert's service in the Consul UI, or to its node's
+// page for node alerts and the alerts of pseudo-services on a node. Alerts without either,
+// or without consul_ui_url set, have no link.
+func (c *Config) consulUILink(datacenter string, alert *AlertState) string {
+	if c.ConsulUIURL == "" || datacenter == "" {
+		return ""
+	}
+
+	var page string
+	switch {
+	case alert.Service != "" && !contains(pseudoServices, alert.Service):
+		page = "services/" + url.PathEscape(alert.Service)
+	case alert.Node != "":
+		page = "nodes/" + url.PathEscape(alert.Node)
+	default:
+		return ""
+	}
+
+	link := strings.TrimSuffix(c.ConsulUIURL, "/") + "/ui/" + url.PathEscape(datacenter) + "/" + page
+	query := url.Values{}
+	if alert.Namespace != "" && alert.Namespace != defaultNamespace {
+		query.Set("ns", alert.Namespace)
+	}
+	if alert.Partition != "" && alert.Partition != defaultPartition {
+		query.Set("partition", alert.Partition)
+	}
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link
+}
+
+// Sets the Consul UI link of an alert about to be sent
+func annotateConsulLink(config *Config, alert *AlertState) {
+	if link := config.consulUILink(config.ConsulDatacenter, alert); link != "" {
+		alert.ConsulURL = link
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConsulUI_links(t *testing.T) {
+	config, err := ParseConfig(`consul_ui_url = "https://consul.example.com/"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		alert    *AlertState
+		expected string
+	}{
+		{&AlertState{Service: "redis", Node: "node1"}, "https://consul.example.com/ui/dc1/services/redis"},
+		{&AlertState{Node: "node1"}, "https://consul.example.com/ui/dc1/nodes/node1"},
+		{&AlertState{Service: "redis", Namespace: "team-a", Partition: "eu"}, "https://consul.example.com/ui/dc1/services/redis?ns=team-a&partition=eu"},
+		{&AlertState{Service: "redis", Namespace: "default"}, "https://consul.example.com/ui/dc1/services/redis"},
+		// Pseudo-services link to their node, if they have one
+		{&AlertState{Service: serverHealthService, Node: "server1"}, "https://consul.example.com/ui/dc1/nodes/server1"},
+		{&AlertState{Service: kvWatchService}, ""},
+	}
+	for _, c := range cases {
+		if link := config.consulUILink("dc1", c.alert); link != c.expected {
+			t.Errorf("expected %q for %+v, got %q", c.expected, c.alert, link)
+		}
+	}
+
+	if link := (&Config{}).consulUILink("dc1", &AlertState{Service: "redis"}); link != "" {
+		t.Errorf("expected no link without consul_ui_url, got %q", link)
+	}
+	if _, err := ParseConfig(`consul_ui_url = "consul.example.com"`); err == nil || !strings.Contains(err.Error(), "consul_ui_url") {
+		t.Errorf("expected an error for a URL without a scheme, got %v", err)
+	}
+}
+
+func TestConsulUI_handlers(t *testing.T) {
+	alert := &AlertState{Service: "redis", Status: "critical", Message: "redis is critical", ConsulURL: "https://consul.example.com/ui/dc1/services/redis"}
+
+	attachment := SlackHandler{}.attachment("dc1", alert, "")
+	context := attachment.Blocks[len(attachment.Blocks)-1].Elements[0].(*slackText)
+	if !strings.Contains(context.Text, "<https://consul.example.com/ui/dc1/services/redis|View in Consul>") {
+		t.Errorf("expected a Slack link, got %q", context.Text)
+	}
+
+	event := PagerdutyHandler{RoutingKey: "key"}.event("dc1", alert, alert.Message)
+	if len(event.Links) != 1 || event.Links[0].Href != alert.ConsulURL {
+		t.Errorf("expected a PagerDuty link, got %+v", event.Links)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The name of the pseudo-service the alerts on the Consul agent being unreachable are
+// sent for. A service block with this name can be used to configure their handlers.
+const consulAgentService = "consul-agent"
+
+// How often the requests to Consul are checked for having failed too long
+const consulUnreachableInterval = 5 * time.Second
+
+// Whether the Consul agent has been alerted as unreachable, and since when
+type consulUnreachableState struct {
+	sync.Mutex
+	alerted bool
+	since   time.Time
+}
+
+var consulUnreachable = &consulUnreachableState{}
+
+// Alerts once the requests to Consul have been failing for longer than
+// consul_unreachable_threshold, and recovers once a request succeeds again
+func watchConsulReachability(nodeName string, config *Config) {
+	ticker := time.NewTicker(consulUnreachableInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		consulUnreachable.check(latestConfig(config), nodeName, consulFailingSince(), time.Now())
+	}
+}
+
+func (s *consulUnreachableState) check(config *Config, nodeName string, failingSince time.Time, now time.Time) {
+	s.Lock()
+	threshold := time.Duration(config.ConsulUnreachableThreshold) * time.Second
+	var alert *AlertState
+	switch {
+	case !s.alerted && threshold > 0 && !failingSince.IsZero() && now.Sub(failingSince) >= threshold:
+		s.alerted = true
+		s.since = failingSince
+		alert = &AlertState{
+			Status:      api.HealthCritical,
+			LastAlerted: api.HealthPassing,
+			Message: fmt.Sprintf("[%s] Consul agent at %s has been unreachable for %s",
+				config.ConsulDatacenter, config.ConsulAddress, now.Sub(failingSince).Round(time.Second)),
+			Details: fmt.Sprintf("Requests to Consul have been failing since %s, so the watches can't see any changes in health",
+				failingSince.UTC().Format(time.RFC3339)),
+		}
+	case s.alerted && failingSince.IsZero():
+		s.alerted = false
+		alert = &AlertState{
+			Status:      api.HealthPassing,
+			LastAlerted: api.HealthCritical,
+			Message: fmt.Sprintf("[%s] Consul agent at %s is reachable again after %s",
+				config.ConsulDatacenter, config.ConsulAddress, now.Sub(s.since).Round(time.Second)),
+		}
+	}
+	s.Unlock()
+
+	if alert != nil {
+		notifyConsulUnreachable(config, nodeName, alert)
+	}
+}
+
+// Sends the alert on the Consul agent being unreachable to the handlers of the
+// consul-agent service. It goes straight to the handlers, since each instance depends on
+// its own agent and silences, maintenance windows and the active instance lock can't be
+// kept up to date without it.
+func notifyConsulUnreachable(config *Config, nodeName string, alert *AlertState) {
+	datacenter := config.ConsulDatacenter
+	alert.Service = consulAgentService
+	alert.Node = nodeName
+	alert.Check = "consul unreachable"
+	alert.Severity = config.alertSeverity(consulAgentService, alert.Status)
+	config.applyMessageTemplates(consulAgentService, alert)
+	annotateConsulLink(config, alert)
+	alert.Labels = config.alertLabels(consulAgentService)
+
+	if alert.Status == api.HealthPassing {
+		log.Info(alert.Message)
+	} else {
+		log.Error(alert.Message)
+	}
+	for _, name := range config.severityHandlerNames(datacenter, consulAgentService, nodeName, nil, nil, alert.Status, alert.LastAlerted) {
+		go config.namedHandler(name).deliver(datacenter, alert)
+	}
+	activeAlerts.update(datacenter, alert)
+	countAlert(alert)
+	history.record(datacenter, alert, time.Now())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsulUnreachable_alerts(t *testing.T) {
+	config, alertCh := testAlertConfig()
+	config.ConsulDatacenter = "dc1"
+	config.ConsulAddress = "localhost:8500"
+	config.ConsulUnreachableThreshold = 60
+	state := &consulUnreachableState{}
+
+	now := time.Now()
+	failingSince := now.Add(-30 * time.Second)
+	state.check(config, "node1", failingSince, now)
+	if len(alertCh) != 0 {
+		t.Fatal("expected no alert before the threshold")
+	}
+
+	now = now.Add(45 * time.Second)
+	state.check(config, "node1", failingSince, now)
+	select {
+	case alert := <-alertCh:
+		if alert.Service != consulAgentService || alert.Node != "node1" || alert.Status != "critical" ||
+			alert.Message != "[dc1] Consul agent at localhost:8500 has been unreachable for 1m15s" {
+			t.Errorf("unexpected alert: %+v", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the alert")
+	}
+
+	// Still failing doesn't alert again, and the first success recovers it
+	state.check(config, "node1", failingSince, now.Add(time.Minute))
+	state.check(config, "node1", time.Time{}, now.Add(2*time.Minute))
+	select {
+	case alert := <-alertCh:
+		if alert.Status != "passing" || !strings.HasSuffix(alert.Message, "is reachable again after 3m15s") {
+			t.Errorf("unexpected recovery: %+v", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the recovery")
+	}
+	if len(alertCh) != 0 {
+		t.Errorf("expected a single alert and recovery, got %d more", len(alertCh))
+	}
+}
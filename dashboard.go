@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How many recent alert transitions the dashboard shows
+const dashboardHistoryLength = 25
+
+// The dashboard page, refreshing itself every 30 seconds
+const dashboardTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>consul-alerting ({{.Datacenter}})</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { text-align: left; padding: 4px 12px; border-bottom: 1px solid #ddd; }
+.status { font-weight: bold; }
+.none { color: #888; }
+</style>
+</head>
+<body>
+<h1>consul-alerting</h1>
+<p>Datacenter {{.Datacenter}}, as of {{.Now.Format "2006-01-02 15:04:05 MST"}}</p>
+
+<h2>Failing ({{len .Alerts}})</h2>
+{{if .Alerts}}<table>
+<tr><th>Status</th><th>Service</th><th>Node</th><th>Check</th><th>Message</th><th>Acknowledged</th></tr>
+{{range .Alerts}}<tr><td class="status" style="color: {{statusColor .Status}};">{{.Status}}</td><td>{{.Service}}{{if .Tag}} ({{.Tag}}){{end}}</td><td>{{.Node}}</td><td>{{.Check}}</td><td>{{.Message}}</td><td>{{.AckedBy}}</td></tr>
+{{end}}</table>
+{{else}}<p class="none">Nothing is failing.</p>
+{{end}}
+<h2>Silences</h2>
+{{if .Silences}}<table>
+<tr><th>Service</th><th>Expires</th><th>Reason</th></tr>
+{{range .Silences}}<tr><td>{{.Target}}</td><td>{{.Expires.Format "2006-01-02 15:04:05 MST"}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{else}}<p class="none">No active silences.</p>
+{{end}}
+<h2>Handlers</h2>
+<table>
+<tr><th>Handler</th><th>Type</th><th>Delivered</th><th>Failed</th><th>Queued</th><th>Circuit breaker</th></tr>
+{{range .Handlers}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.Delivered}}</td><td>{{.Failed}}</td><td>{{.Queued}}</td><td>{{if .CircuitOpen}}<span class="status" style="color: {{statusColor "critical"}};">open</span>{{else}}closed{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Recent Alerts</h2>
+{{if .History}}<table>
+<tr><th>Time</th><th>Status</th><th>Service</th><th>Node</th><th>Message</th></tr>
+{{range .History}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td class="status" style="color: {{statusColor .Status}};">{{.Status}}</td><td>{{.Service}}{{if .Tag}} ({{.Tag}}){{end}}</td><td>{{.Node}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>
+{{else}}<p class="none">No alerts yet.</p>
+{{end}}
+</body>
+</html>
+`
+
+var dashboardPage = template.Must(template.New("dashboard").Funcs(emailTemplateFuncs).Parse(dashboardTemplate))
+
+// A silence and the name of its key, with what it applies to for display
+type dashboardSilence struct {
+	Name   string
+	Target string
+	silence
+}
+
+// The health of a handler, from its send counts, queue and circuit breaker
+type dashboardHandlerHealth struct {
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Delivered   float64 `json:"delivered"`
+	Failed      float64 `json:"failed"`
+	Queued      int     `json:"queued"`
+	CircuitOpen bool    `json:"circuit_open"`
+}
+
+type dashboardData struct {
+	Datacenter string
+	Now        time.Time
+	Alerts     []AlertState
+	Silences   []dashboardSilence
+	Handlers   []dashboardHandlerHealth
+	History    []alertTransition
+}
+
+// Gathers the daemon's state for the dashboard
+func dashboardState(config *Config, now time.Time) dashboardData {
+	config = latestConfig(config)
+	data := dashboardData{
+		Datacenter: config.ConsulDatacenter,
+		Now:        now,
+		Alerts:     activeAlerts.list(),
+		History:    history.query(historyFilter{}, dashboardHistoryLength),
+	}
+
+	for name, s := range alertSilences.active(now) {
+		data.Silences = append(data.Silences, dashboardSilence{name, s.target(name), s})
+	}
+	sort.Slice(data.Silences, func(i, j int) bool {
+		return data.Silences[i].Name < data.Silences[j].Name
+	})
+
+	queue := currentQueue()
+	for name, handler := range config.Handlers {
+		h := dashboardHandlerHealth{
+			Name:        name,
+			Type:        handlerType(handler),
+			Delivered:   handlerSends.value("handler", name, "result", "success"),
+			Failed:      handlerSends.value("handler", name, "result", "failure"),
+			CircuitOpen: handlerCircuitBreakers.isOpen(name),
+		}
+		if queue != nil {
+			h.Queued = queue.count(name)
+		}
+		data.Handlers = append(data.Handlers, h)
+	}
+	sort.Slice(data.Handlers, func(i, j int) bool {
+		return data.Handlers[i].Name < data.Handlers[j].Name
+	})
+
+	return data
+}
+
+// Serves the dashboard
+func dashboardHandler(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var page bytes.Buffer
+		if err := dashboardPage.Execute(&page, dashboardState(config, time.Now())); err != nil {
+			log.Errorf("Error rendering dashboard: %s", err)
+			http.Error(w, fmt.Sprintf("error rendering dashboard: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page.WriteTo(w)
+	}
+}
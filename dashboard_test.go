@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard_page(t *testing.T) {
+	activeAlerts.update("dc1", &AlertState{Service: "dashboard-test", Status: "critical", Message: "dashboard-test is critical"})
+	defer activeAlerts.update("dc1", &AlertState{Service: "dashboard-test", Status: "passing"})
+
+	alertSilences.replace(map[string]silence{"nginx": {Expires: time.Now().Add(time.Hour), Reason: "deploying"}})
+	defer alertSilences.replace(make(map[string]silence))
+
+	config := &Config{
+		ConsulDatacenter: "dc1",
+		Handlers:         map[string]AlertHandler{"slack.ops": SlackHandler{}},
+	}
+
+	w := httptest.NewRecorder()
+	dashboardHandler(config)(w, httptest.NewRequest("GET", "/ui", nil))
+	body := w.Body.String()
+	for _, expected := range []string{
+		"Datacenter dc1",
+		"dashboard-test is critical",
+		"<td>nginx</td>",
+		"deploying",
+		"<td>slack.ops</td><td>Slack</td>",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected the dashboard to contain %q, got:\n%s", expected, body)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Watches every datacenter in the WAN federation when given as the datacenters
+const AllDatacenters = "*"
+
+// How often the catalog is checked for datacenters joining or leaving the federation
+const datacenterDiscoveryInterval = time.Minute
+
+// The discovery goroutines running for a datacenter or namespace, sharing a stop channel
+type datacenterWatch struct {
+	stopCh     chan struct{}
+	goroutines int
+}
+
+// Sends the stop channel two values for each goroutine, to stop it and wait for it to
+// finish shutting down
+func (w *datacenterWatch) stop() {
+	for i := 0; i < w.goroutines; i++ {
+		w.stopCh <- struct{}{}
+		w.stopCh <- struct{}{}
+	}
+}
+
+// Returns a copy of the config for the watches of the given datacenter, whose alerts are
+// tagged with it. Services in datacenters other than the agent's are always watched
+// globally, since the local node isn't in them.
+func (config *Config) forDatacenter(datacenter string, local string) *Config {
+	copied := *config
+	copied.ConsulDatacenter = datacenter
+	copied.localDatacenter = local
+	if datacenter != local {
+		copied.ServiceWatch = GlobalMode
+	}
+	return &copied
+}
+
+// Returns the options for querying the catalog and health of the config's datacenter.
+// Requests for other datacenters than the agent's are forwarded to them by the agent.
+// A token set for the datacenter in datacenter_tokens is used for them. With allow_stale,
+// the queries can be served by any server instead of only the leader.
+func (config *Config) queryOptions() *api.QueryOptions {
+	if config.localDatacenter == "" {
+		return &api.QueryOptions{AllowStale: config.AllowStale}
+	}
+	queryOpts := &api.QueryOptions{AllowStale: config.AllowStale, Token: config.DatacenterTokens[config.ConsulDatacenter]}
+	if config.ConsulDatacenter != config.localDatacenter {
+		queryOpts.Datacenter = config.ConsulDatacenter
+	}
+	return queryOpts
+}
+
+// Returns the KV path the watches of the config's datacenter keep their state under.
+// The state is always kept in the agent's datacenter, since the sessions holding the
+// watch locks are tied to the local node, so other datacenters get a path of their own.
+// Partitions and namespaces other than the default ones get a path of their own as well.
+func (config *Config) stateKVRoot() string {
+	root := alertingKVRoot
+	if config.localDatacenter != "" && config.ConsulDatacenter != config.localDatacenter {
+		root = root + "/datacenter/" + config.ConsulDatacenter
+	}
+	if config.partition != "" && config.partition != defaultPartition {
+		root = root + "/partition/" + config.partition
+	}
+	if config.namespace != "" && config.namespace != defaultNamespace {
+		root = root + "/namespace/" + config.namespace
+	}
+	return root
+}
+
+// Returns the datacenters to watch, looking them up in the catalog if they're "*"
+func (config *Config) watchedDatacenters(client *api.Client) ([]string, error) {
+	if !contains(config.Datacenters, AllDatacenters) {
+		return config.Datacenters, nil
+	}
+	return client.Catalog().Datacenters()
+}
+
+// Looks up the datacenter of the Consul agent, retrying until it succeeds
+func agentDatacenter(client *api.Client) string {
+	for {
+		agentInfo, err := client.Agent().Self()
+		if err == nil {
+			return agentInfo["Config"]["Datacenter"].(string)
+		}
+		log.Error("Error fetching datacenter from Consul: ", err)
+		wait := consulErrorWait()
+		log.Errorf("Retrying in %s...", wait.Truncate(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
+
+// Runs the service discovery, and the node discovery if node_watch is global, of each of
+// the datacenters. When watching "*", datacenters joining or leaving the federation are
+// picked up every minute.
+func watchDatacenters(nodeName string, config *Config, shutdownCh chan struct{}, client *api.Client, clientConfig *api.Config) {
+	local := agentDatacenter(client)
+	watches := make(map[string]*datacenterWatch)
+
+	for {
+		wait := datacenterDiscoveryInterval
+		datacenters, err := config.watchedDatacenters(client)
+		if err != nil {
+			wait = consulErrorWait()
+			log.Errorf("Error trying to list datacenters: %s, retrying in %s...", err, wait.Truncate(time.Millisecond))
+			consulQueryErrors.add(1, "watch", "datacenters")
+		} else {
+			current := make(map[string]bool)
+			for _, datacenter := range datacenters {
+				current[datacenter] = true
+				if _, ok := watches[datacenter]; ok {
+					continue
+				}
+
+				watch, err := startDatacenterWatch(nodeName, datacenter, local, config, client, clientConfig)
+				if err != nil {
+					log.Errorf("Error watching datacenter %s: %s", datacenter, err)
+					continue
+				}
+				watches[datacenter] = watch
+			}
+
+			for datacenter, watch := range watches {
+				if !current[datacenter] {
+					log.Infof("Datacenter %s left, removing its watches", datacenter)
+					delete(watches, datacenter)
+					go watch.stop()
+				}
+			}
+		}
+
+		select {
+		case <-shutdownCh:
+			log.Infof("Shutting down datacenter watches (count: %d)...", len(watches))
+
+			var wg sync.WaitGroup
+			for _, watch := range watches {
+				wg.Add(1)
+				go func(watch *datacenterWatch) {
+					defer wg.Done()
+					watch.stop()
+				}(watch)
+			}
+			wg.Wait()
+			log.Info("Finished shutting down datacenter watches")
+			<-shutdownCh
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Starts the discovery of services and nodes in a datacenter
+func startDatacenterWatch(nodeName string, datacenter string, local string, config *Config, client *api.Client, clientConfig *api.Config) (*datacenterWatch, error) {
+	log.Infof("Watching datacenter %s", datacenter)
+	config = config.forDatacenter(datacenter, local)
+
+	// The local node is only checked by the startup check, in the agent's datacenter
+	warnings, err := checkCatalogPermissions(client, "", config.queryOptions())
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		log.Warnf("%s in datacenter %s", warning, datacenter)
+	}
+
+	watch := &datacenterWatch{stopCh: make(chan struct{})}
+	watch.goroutines = startDiscovery(nodeName, config, watch.stopCh, client, clientConfig)
+	return watch, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestDatacenters_forDatacenter(t *testing.T) {
+	config := &Config{ConsulDatacenter: "dc1", ServiceWatch: LocalMode, ChangeThreshold: 60}
+
+	local := config.forDatacenter("dc1", "dc1")
+	remote := config.forDatacenter("dc2", "dc1")
+	if local.ServiceWatch != LocalMode || local.localDatacenter != "dc1" {
+		t.Errorf("expected the agent's datacenter to keep the service watch mode, got %+v", local)
+	}
+	if remote.ConsulDatacenter != "dc2" || remote.ServiceWatch != GlobalMode {
+		t.Errorf("expected other datacenters to watch services globally, got %+v", remote)
+	}
+	if config.ConsulDatacenter != "dc1" {
+		t.Error("expected the original config to be unchanged")
+	}
+
+	// Other datacenters are queried through the agent and keep their state in its KV
+	if local.queryOptions().Datacenter != "" || local.stateKVRoot() != alertingKVRoot {
+		t.Errorf("expected the agent's datacenter to use the default query options and KV root")
+	}
+	if remote.queryOptions().Datacenter != "dc2" || remote.stateKVRoot() != alertingKVRoot+"/datacenter/dc2" {
+		t.Errorf("expected dc2 to be queried with its dc parameter, got %q and KV root %q", remote.queryOptions().Datacenter, remote.stateKVRoot())
+	}
+
+	// After a reload, the watches of each datacenter get a copy of the new config
+	reloaded := &Config{ConsulDatacenter: "dc1", ServiceWatch: LocalMode, ChangeThreshold: 30}
+	setReloadedConfig(reloaded)
+	defer setReloadedConfig(nil)
+
+	latest := latestConfig(remote)
+	if latest.ConsulDatacenter != "dc2" || latest.ChangeThreshold != 30 || latest.ServiceWatch != GlobalMode {
+		t.Errorf("expected a dc2 copy of the reloaded config, got %+v", latest)
+	}
+	if latestConfig(remote) != latest {
+		t.Error("expected the copy to be reused")
+	}
+	if latestConfig(config) != reloaded {
+		t.Error("expected the reloaded config itself outside of datacenter watches")
+	}
+}
+
+func TestDatacenters_parse(t *testing.T) {
+	config, err := ParseConfig(`datacenters = ["dc1", "dc2"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Datacenters, []string{"dc1", "dc2"}) {
+		t.Errorf("expected the datacenters, got %v", config.Datacenters)
+	}
+
+	if _, err := ParseConfig(`datacenters = ["*", "dc2"]`); err == nil || !strings.Contains(err.Error(), `along with "*"`) {
+		t.Errorf("expected an error for * with other datacenters, got %v", err)
+	}
+}
+
+func TestDatacenters_watch(t *testing.T) {
+	var lock sync.Mutex
+	queried := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/catalog/datacenters":
+			w.Write([]byte(`["dc1", "dc2", "dc3"]`))
+		case "/v1/catalog/services":
+			lock.Lock()
+			queried[r.URL.Query().Get("dc")] = true
+			lock.Unlock()
+			w.Header().Set("X-Consul-Index", "1")
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = strings.TrimPrefix(server.URL, "http://")
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{Datacenters: []string{AllDatacenters}, ServiceWatch: GlobalMode, NodeWatch: LocalMode}
+	datacenters, err := config.watchedDatacenters(client)
+	if err != nil || !reflect.DeepEqual(datacenters, []string{"dc1", "dc2", "dc3"}) {
+		t.Fatalf("expected the datacenters from the catalog, got %v, %v", datacenters, err)
+	}
+
+	watch, err := startDatacenterWatch("node1", "dc2", "dc1", config, client, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		done := queried["dc2"]
+		lock.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	watch.stop()
+
+	if !queried["dc2"] {
+		t.Errorf("expected the services of dc2 to be queried with its dc parameter, got %v", queried)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+var deadLetterAlerts = metrics.register("consul_alerting_dead_letters_total",
+	"Alerts a handler failed to deliver after all its retries.", "counter")
+
+// An alert a handler failed to deliver, as written to the dead-letter sinks
+type deadLetter struct {
+	Handler    string      `json:"handler"`
+	Datacenter string      `json:"datacenter"`
+	Alert      *AlertState `json:"alert"`
+	Error      string      `json:"error"`
+	FailedAt   time.Time   `json:"failed_at"`
+}
+
+// Where undeliverable alerts go: appended as a JSON line to a file, written under a KV
+// prefix, and/or sent to a secondary handler
+type deadLetterSinks struct {
+	sync.Mutex
+	file     string
+	kvPrefix string
+	handler  string
+	config   *Config
+	client   *api.Client
+}
+
+var deadLetters = &deadLetterSinks{}
+
+// Sets up the configured dead-letter sinks
+func setDeadLetters(config *Config, client *api.Client) {
+	deadLetters.Lock()
+	defer deadLetters.Unlock()
+
+	deadLetters.file = config.DeadLetterFile
+	deadLetters.kvPrefix = config.DeadLetterKVPrefix
+	deadLetters.handler = config.DeadLetterHandler
+	deadLetters.config = config
+	deadLetters.client = client
+}
+
+// Records an alert the handler gave up on. The secondary handler's own failures aren't
+// sent back to it.
+func (d *deadLetterSinks) add(handler string, datacenter string, alert *AlertState, sendErr error) {
+	deadLetterAlerts.add(1, "handler", handler)
+	log.Errorf("Failed to deliver alert '%s' to handler %s: %s", alert.Message, handler, sendErr)
+
+	d.Lock()
+	file, kvPrefix, secondary, config, client := d.file, d.kvPrefix, d.handler, d.config, d.client
+	d.Unlock()
+
+	letter := deadLetter{
+		Handler:    handler,
+		Datacenter: datacenter,
+		Alert:      alert,
+		Error:      sendErr.Error(),
+		FailedAt:   time.Now().UTC(),
+	}
+
+	if file != "" {
+		if err := d.appendFile(file, letter); err != nil {
+			log.Errorf("Error writing dead letter to %s: %s", file, err)
+		}
+	}
+	if kvPrefix != "" && client != nil {
+		if err := writeDeadLetter(client, kvPrefix, letter); err != nil {
+			log.Errorf("Error writing dead letter to the KV store: %s", err)
+		}
+	}
+	if secondary != "" && secondary != handler && config != nil {
+		forwarded := *alert
+		forwarded.Details = fmt.Sprintf("Handler %s failed to deliver this alert: %s\n%s", handler, sendErr, alert.Details)
+		config.namedHandler(secondary).call(datacenter, &forwarded)
+	}
+}
+
+// Appends a dead letter to the file as a line of JSON
+func (d *deadLetterSinks) appendFile(path string, letter deadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Writes a dead letter to <prefix><handler>/<unix nanoseconds>
+func writeDeadLetter(client *api.Client, prefix string, letter deadLetter) error {
+	value, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	key := prefix + letter.Handler + "/" + strconv.FormatInt(letter.FailedAt.UnixNano(), 10)
+	_, err = client.KV().Put(&api.KVPair{Key: key, Value: value}, nil)
+	return err
+}
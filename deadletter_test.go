@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeadLetter_sinks(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "consul-alerting-dead-letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	down := int32(1)
+	alertCh := make(chan *AlertState, 10)
+	config := &Config{
+		DeadLetterFile:     filepath.Join(dir, "dead-letters.json"),
+		DeadLetterKVPrefix: "service/consul-alerting/dead-letters/",
+		DeadLetterHandler:  "backup",
+		Handlers: map[string]AlertHandler{
+			"slack.ops": flakyHandler{&down, nil},
+			"backup":    testHandler{alertCh},
+		},
+	}
+	setDeadLetters(config, client)
+	defer setDeadLetters(&Config{}, nil)
+
+	alert := &AlertState{Service: "redis", Status: "critical", Message: "redis is critical"}
+	if err := config.namedHandler("slack.ops").deliver("dc1", alert); err == nil {
+		t.Fatal("expected the delivery to fail")
+	}
+
+	contents, err := ioutil.ReadFile(config.DeadLetterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var letter deadLetter
+	if err := json.Unmarshal(contents, &letter); err != nil {
+		t.Fatal(err)
+	}
+	if letter.Handler != "slack.ops" || letter.Error != "endpoint unreachable" || letter.Alert.Message != "redis is critical" {
+		t.Errorf("unexpected dead letter: %+v", letter)
+	}
+
+	found := false
+	for key := range values {
+		if strings.HasPrefix(key, "service/consul-alerting/dead-letters/slack.ops/") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected a dead letter in the KV store, got %v", values)
+	}
+
+	if len(alertCh) != 1 {
+		t.Fatalf("expected the alert to be sent to the dead-letter handler")
+	}
+	if forwarded := <-alertCh; !strings.HasPrefix(forwarded.Details, "Handler slack.ops failed to deliver this alert") {
+		t.Errorf("unexpected forwarded alert: %+v", forwarded)
+	}
+}
+
+func TestDeadLetter_unknownHandler(t *testing.T) {
+	_, err := ParseConfig(`dead_letter_handler = "slack.missing"`)
+	if err == nil || !strings.Contains(err.Error(), "Unknown dead_letter_handler") {
+		t.Errorf("expected an unknown handler error, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/http/pprof"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Starts the pprof debug server, if an address is configured. It's separate from the
+// HTTP server so profiling can be kept to a private interface.
+func startDebugServer(config *Config) {
+	if config.DebugAddress == "" {
+		return
+	}
+
+	log.Infof("Serving pprof debug endpoints on %s", config.DebugAddress)
+	handler := requireBasicAuth(config.DebugUsername, config.DebugPassword, debugMux())
+	go func() {
+		if err := http.ListenAndServe(config.DebugAddress, handler); err != nil {
+			log.Fatal("Error running debug server: ", err)
+		}
+	}()
+}
+
+func debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Requires requests to carry the username and password, if a password is set
+func requireBasicAuth(username string, password string, next http.Handler) http.Handler {
+	if password == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="consul-alerting debug"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
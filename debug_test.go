@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebug_basicAuth(t *testing.T) {
+	handler := requireBasicAuth("admin", "secret", debugMux())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without credentials, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/debug/pprof/", nil)
+	req.SetBasicAuth("admin", "wrong")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 with the wrong password, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/debug/pprof/", nil)
+	req.SetBasicAuth("admin", "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("expected the pprof index, got %d", w.Code)
+	}
+}
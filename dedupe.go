@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The KV prefix used for recording when each notification was last sent
+const dedupeKVPath = alertingKVRoot + "/dedupe/"
+
+// Returns the KV path recording when a notification for the alert's status was last sent.
+// Empty parts of the key are replaced with "_" so paths stay unambiguous.
+func dedupePath(datacenter string, alert *AlertState) string {
+	parts := []string{datacenter, alert.scopedService(), alert.Tag, alert.scopedNode(), alert.Check, alert.Status}
+	for i, part := range parts {
+		if part == "" {
+			parts[i] = "_"
+		}
+	}
+	return dedupeKVPath + strings.Join(parts, "/")
+}
+
+// Returns whether an identical notification was sent within the cooldown, in which case
+// this one should be suppressed. Otherwise the notification is recorded as sent now.
+// The record is kept in the KV store so it survives restarts.
+func suppressDuplicate(client *api.Client, cooldown int, datacenter string, alert *AlertState) bool {
+	if cooldown <= 0 || client == nil {
+		return false
+	}
+
+	path := dedupePath(datacenter, alert)
+	now := time.Now()
+
+	value, ok, err := stateKV(client).get(path)
+	if err != nil {
+		log.Errorf("Error fetching dedupe state, not suppressing alert: %s", err)
+		return false
+	}
+
+	if ok {
+		sent, err := strconv.ParseInt(string(value), 10, 64)
+		if err == nil && now.Sub(time.Unix(sent, 0)) < time.Duration(cooldown)*time.Second {
+			log.Infof("Suppressing duplicate alert '%s', last sent %s ago", alert.Message, now.Sub(time.Unix(sent, 0)).Truncate(time.Second))
+			return true
+		}
+	}
+
+	err = stateKV(client).put(path, []byte(strconv.FormatInt(now.Unix(), 10)))
+	if err != nil {
+		log.Errorf("Error storing dedupe state: %s", err)
+	}
+
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// A fake of the Consul KV api, storing values in memory
+func testFakeKV(t *testing.T) (*api.Client, map[string][]byte, func()) {
+	var lock sync.Mutex
+	values := make(map[string][]byte)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		switch r.Method {
+		case "PUT":
+			body, _ := ioutil.ReadAll(r.Body)
+			values[key] = body
+			w.Write([]byte("true"))
+		case "GET":
+			if _, ok := r.URL.Query()["recurse"]; ok {
+				var pairs []*api.KVPair
+				for k, value := range values {
+					if strings.HasPrefix(k, key) {
+						pairs = append(pairs, &api.KVPair{Key: k, Value: value})
+					}
+				}
+				if len(pairs) == 0 {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				json.NewEncoder(w).Encode(pairs)
+				return
+			}
+			value, ok := values[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode([]*api.KVPair{{Key: key, Value: value}})
+		case "DELETE":
+			delete(values, key)
+			w.Write([]byte("true"))
+		}
+	}))
+
+	config := api.DefaultConfig()
+	config.Address = strings.TrimPrefix(server.URL, "http://")
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, values, server.Close
+}
+
+func TestDedupe_suppressDuplicate(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	alert := &AlertState{Service: "redis", Node: "node1", Status: "critical", Message: "redis is critical"}
+
+	if suppressDuplicate(client, 300, "dc1", alert) {
+		t.Fatal("expected the first alert to be sent")
+	}
+	if _, ok := values[dedupeKVPath+"dc1/redis/_/node1/_/critical"]; !ok {
+		t.Errorf("expected the sent alert to be recorded, got %v", values)
+	}
+
+	if !suppressDuplicate(client, 300, "dc1", alert) {
+		t.Error("expected the identical alert to be suppressed")
+	}
+
+	recovery := &AlertState{Service: "redis", Node: "node1", Status: "passing"}
+	if suppressDuplicate(client, 300, "dc1", recovery) {
+		t.Error("expected an alert with a different status to be sent")
+	}
+
+	if suppressDuplicate(client, 300, "dc2", alert) {
+		t.Error("expected an alert in another datacenter to be sent")
+	}
+
+	// Alerts sent outside the cooldown aren't suppressed
+	values[dedupeKVPath+"dc1/redis/_/node1/_/critical"] = []byte("1")
+	if suppressDuplicate(client, 300, "dc1", alert) {
+		t.Error("expected an alert outside the cooldown to be sent")
+	}
+
+	if suppressDuplicate(client, 0, "dc1", alert) {
+		t.Error("expected nothing to be suppressed without a cooldown")
+	}
+}
+
+func TestDedupe_serviceCooldown(t *testing.T) {
+	config, err := ParseConfig(`
+	dedupe_cooldown = 600
+
+	service "redis" {}
+
+	service "webapp" {
+		dedupe_cooldown = 0
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for service, expected := range map[string]int{"redis": 600, "webapp": 0, "other": 600} {
+		if cooldown := config.serviceDedupeCooldown(service); cooldown != expected {
+			t.Errorf("%s: expected cooldown %d, got %d", service, expected, cooldown)
+		}
+	}
+}
@@ -16,10 +16,8 @@ func discoverServices(nodeName string, config *Config, shutdownCh chan struct{},
 		log.Infof("Discovering services on local node (%s)", nodeName)
 	}
 
-	queryOpts := &api.QueryOptions{
-		AllowStale: true,
-		WaitTime:   watchWaitTime,
-	}
+	queryOpts := config.queryOptions()
+	queryOpts.WaitTime = watchWaitTime
 
 	// Used to store services we've already started watches for
 	services := make(map[string]bool)
@@ -52,6 +50,9 @@ func discoverServices(nodeName string, config *Config, shutdownCh chan struct{},
 		default:
 		}
 
+		// Pick up a reloaded config, so services start or stop being watched per tag
+		config = latestConfig(config)
+
 		var queryMeta *api.QueryMeta
 		currentServices := make(map[string][]string)
 		var err error
@@ -60,23 +61,15 @@ func discoverServices(nodeName string, config *Config, shutdownCh chan struct{},
 		if config.ServiceWatch == GlobalMode {
 			currentServices, queryMeta, err = client.Catalog().Services(queryOpts)
 		} else {
-			var node *api.CatalogNode
-			node, queryMeta, err = client.Catalog().Node(nodeName, queryOpts)
-			if err == nil {
-				// Build the map of service:[tags]
-				for _, config := range node.Services {
-					if _, ok := currentServices[config.Service]; ok {
-						currentServices[config.Service] = config.Tags
-					} else {
-						currentServices[config.Service] = append(currentServices[config.Service], config.Tags...)
-					}
-				}
-			}
+			currentServices, queryMeta, err = localServices(nodeName, config, client, queryOpts)
 		}
 
 		if err != nil {
-			log.Errorf("Error trying to watch services: %s, retrying in 10s...", err)
-			time.Sleep(errorWaitTime)
+			wait := consulErrorWait()
+			log.Errorf("Error trying to watch services: %s, retrying in %s...", err, wait.Truncate(time.Millisecond))
+			consulQueryErrors.add(1, "watch", "services")
+			daemonReadiness.update("consul", err)
+			time.Sleep(wait)
 			continue
 		}
 
@@ -91,6 +84,9 @@ func discoverServices(nodeName string, config *Config, shutdownCh chan struct{},
 		// Compare the new list of services with our stored one to see if we need to
 		// spawn any new watches
 		for service, tags := range currentServices {
+			if !config.watchesService(service, tags) || !shards.owns(service) {
+				continue
+			}
 			serviceConfig := config.serviceConfig(service)
 
 			// If DistinctTags is specified, spawn a separate watch for each tag on the service
@@ -140,15 +136,17 @@ func discoverServices(nodeName string, config *Config, shutdownCh chan struct{},
 				}()
 			}
 		}
+
+		daemonReadiness.update("consul", nil)
+		daemonReadiness.update("services", nil)
 	}
 }
 
-// Queries the catalog for nodes and starts watches for them
+// Queries the catalog for nodes and starts watches for them. Unless node_watch is global,
+// only the external nodes registered by consul-esm are watched.
 func discoverNodes(config *Config, shutdownCh chan struct{}, client *api.Client) {
-	queryOpts := &api.QueryOptions{
-		AllowStale: true,
-		WaitTime:   watchWaitTime,
-	}
+	queryOpts := config.queryOptions()
+	queryOpts.WaitTime = watchWaitTime
 
 	// Used to store nodes we've already started watches for
 	nodes := make(map[string]bool, 0)
@@ -181,11 +179,14 @@ func discoverNodes(config *Config, shutdownCh chan struct{}, client *api.Client)
 			return
 		default:
 		}
-		currentNodes, queryMeta, err := client.Catalog().Nodes(queryOpts)
+		currentNodes, queryMeta, err := catalogNodes(client, queryOpts)
 
 		if err != nil {
-			log.Errorf("Error trying to watch node list: %s, retrying in 10s...", err)
-			time.Sleep(errorWaitTime)
+			wait := consulErrorWait()
+			log.Errorf("Error trying to watch node list: %s, retrying in %s...", err, wait.Truncate(time.Millisecond))
+			consulQueryErrors.add(1, "watch", "nodes")
+			daemonReadiness.update("consul", err)
+			time.Sleep(wait)
 			continue
 		}
 
@@ -200,7 +201,13 @@ func discoverNodes(config *Config, shutdownCh chan struct{}, client *api.Client)
 		// Compare the new list of nodes with our stored one to see if we need to
 		// spawn any new watches
 		for _, node := range currentNodes {
+			if config.NodeWatch != GlobalMode && !isExternalNode(node.Meta) {
+				continue
+			}
 			nodeName := node.Node
+			if !shards.owns(nodeName) {
+				continue
+			}
 			if _, ok := nodes[nodeName]; !ok {
 				log.Infof("Discovered new node: %s", nodeName)
 				opts := &WatchOptions{
@@ -229,5 +236,8 @@ func discoverNodes(config *Config, shutdownCh chan struct{}, client *api.Client)
 				}()
 			}
 		}
+
+		daemonReadiness.update("consul", nil)
+		daemonReadiness.update("nodes", nil)
 	}
 }
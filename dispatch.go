@@ -0,0 +1,101 @@
+package main
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var dispatchQueueLength = metrics.register("consul_alerting_dispatch_queue_length",
+	"Alerts waiting for a dispatch worker to send them to a handler.", "gauge")
+
+// An alert waiting to be sent to a handler
+type dispatchJob struct {
+	handler    namedHandler
+	datacenter string
+	alert      AlertState
+
+	// The time the alert spends waiting for the worker
+	span *span
+}
+
+// A pool of workers sending alerts to handlers, so slow handlers don't hold up the watches
+// that raised the alerts. Each worker has its own queue, and the alerts of an incident for
+// a handler always go to the same worker so they arrive in order.
+type alertDispatcher struct {
+	queues []chan dispatchJob
+}
+
+var dispatcher = struct {
+	sync.Mutex
+	pool *alertDispatcher
+}{}
+
+// Starts the dispatch workers. Without them alerts are sent from the watch that raised them.
+func startDispatcher(workers int, queueSize int) {
+	if workers <= 0 {
+		return
+	}
+	pool := newAlertDispatcher(workers, queueSize)
+
+	dispatcher.Lock()
+	defer dispatcher.Unlock()
+	dispatcher.pool = pool
+}
+
+func newAlertDispatcher(workers int, queueSize int) *alertDispatcher {
+	pool := &alertDispatcher{queues: make([]chan dispatchJob, workers)}
+	for i := range pool.queues {
+		pool.queues[i] = make(chan dispatchJob, queueSize)
+		go pool.work(i)
+	}
+	return pool
+}
+
+func (d *alertDispatcher) work(i int) {
+	for job := range d.queues[i] {
+		dispatchQueueLength.set(float64(len(d.queues[i])), "worker", strconv.Itoa(i))
+		job.span.finish(nil)
+		job.handler.send(job.datacenter, &job.alert)
+		pendingAlerts.done()
+	}
+}
+
+// Queues an alert for the worker its handler and incident belong to, waiting for room in
+// the queue if it's full so no alert is lost. The alert counts as pending until the worker
+// has sent it.
+func (d *alertDispatcher) enqueue(handler namedHandler, datacenter string, alert *AlertState) {
+	hash := fnv.New32a()
+	hash.Write([]byte(handler.name + "/" + alertIncidentKey(datacenter, alert) + "-" + alert.Check))
+	i := int(hash.Sum32() % uint32(len(d.queues)))
+	queue := d.queues[i]
+
+	job := dispatchJob{handler: handler, datacenter: datacenter, alert: *alert}
+	job.span = startSpan(alert.span, "alert.dispatch_queue", "handler", handler.name, "dispatch.worker", strconv.Itoa(i))
+	pendingAlerts.add()
+	select {
+	case queue <- job:
+	default:
+		log.Warnf("Dispatch queue of worker %d is full, waiting to queue alert '%s' for handler %s", i, alert.Message, handler.name)
+		queue <- job
+	}
+	dispatchQueueLength.set(float64(len(queue)), "worker", strconv.Itoa(i))
+}
+
+// Sends an alert to a handler through the dispatch workers, or right away if they
+// aren't running
+func sendAsync(handler namedHandler, datacenter string, alert *AlertState) {
+	dispatcher.Lock()
+	pool := dispatcher.pool
+	dispatcher.Unlock()
+
+	if pool == nil {
+		pendingAlerts.add()
+		defer pendingAlerts.done()
+		handler.send(datacenter, alert)
+		return
+	}
+	pool.enqueue(handler, datacenter, alert)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDispatch_order(t *testing.T) {
+	alertCh := make(chan *AlertState, 20)
+	pool := newAlertDispatcher(4, 10)
+	handler := namedHandler{name: "test", handler: testHandler{alertCh}}
+
+	alert := &AlertState{Service: "redis", Node: "node1"}
+	for i := 0; i < 10; i++ {
+		alert.Message = fmt.Sprintf("update %d", i)
+		pool.enqueue(handler, "dc1", alert)
+	}
+
+	for i := 0; i < 10; i++ {
+		select {
+		case received := <-alertCh:
+			if expected := fmt.Sprintf("update %d", i); received.Message != expected {
+				t.Fatalf("expected %s, got %s", expected, received.Message)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for update %d", i)
+		}
+	}
+}
+
+func TestDispatch_slowHandler(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	pool := newAlertDispatcher(1, 10)
+	slow := namedHandler{name: "slow", handler: blockingHandler{release}}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			pool.enqueue(slow, "dc1", &AlertState{Service: "redis"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected queueing alerts not to wait for a slow handler")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// The headers covered by DKIM signatures, when the email has them
+var dkimSignedHeaders = []string{"From", "To", "Subject", "Date", "Message-Id", "Mime-Version", "Content-Type", "Content-Transfer-Encoding"}
+
+// Signs outgoing emails with DKIM (RFC 6376), using relaxed canonicalization for both the
+// headers and body
+type dkimSigner struct {
+	domain   string
+	selector string
+	key      crypto.Signer
+}
+
+// An email in its final form, ready to be sent
+type signedEmail []byte
+
+func (email signedEmail) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(email)
+	return int64(n), err
+}
+
+// Loads the RSA or Ed25519 private key in PEM format used for signing
+func newDKIMSigner(domain string, selector string, keyFile string) (*dkimSigner, error) {
+	contents, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dkim_key_file: %s", err)
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key found in dkim_key_file %s", keyFile)
+	}
+
+	var key crypto.Signer
+	if rsaKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		key = rsaKey
+	} else {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing dkim_key_file: %s", err)
+		}
+		switch parsed := parsed.(type) {
+		case *rsa.PrivateKey:
+			key = parsed
+		case ed25519.PrivateKey:
+			key = parsed
+		default:
+			return nil, fmt.Errorf("dkim_key_file must hold an RSA or Ed25519 key")
+		}
+	}
+
+	return &dkimSigner{domain: domain, selector: selector, key: key}, nil
+}
+
+// Renders an email and prepends its DKIM-Signature header
+func (s *dkimSigner) sign(m io.WriterTo) (signedEmail, error) {
+	var raw bytes.Buffer
+	if _, err := m.WriteTo(&raw); err != nil {
+		return nil, err
+	}
+	header, body := splitEmail(raw.Bytes())
+
+	bodyHash := sha256.Sum256([]byte(dkimRelaxedBody(body)))
+	fields := parseEmailHeaders(header)
+	signed := make([]string, 0, len(dkimSignedHeaders))
+	var data strings.Builder
+	for _, name := range dkimSignedHeaders {
+		if value, ok := fields[strings.ToLower(name)]; ok {
+			signed = append(signed, name)
+			data.WriteString(dkimRelaxedHeader(name, value))
+		}
+	}
+
+	algorithm := "rsa-sha256"
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		algorithm = "ed25519-sha256"
+	}
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		algorithm, s.domain, s.selector, time.Now().Unix(), strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+	data.WriteString(strings.TrimSuffix(dkimRelaxedHeader("DKIM-Signature", value), "\r\n"))
+
+	hash := sha256.Sum256([]byte(data.String()))
+	var signature []byte
+	var err error
+	if algorithm == "ed25519-sha256" {
+		// Ed25519 signs the hash as its message (RFC 8463)
+		signature, err = s.key.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	} else {
+		signature, err = s.key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	email := "DKIM-Signature: " + value + base64.StdEncoding.EncodeToString(signature) + "\r\n"
+	return append([]byte(email), raw.Bytes()...), nil
+}
+
+// Splits a rendered email into its header block (including the final line break) and body
+func splitEmail(raw []byte) (string, string) {
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		return string(raw[:i+2]), string(raw[i+4:])
+	}
+	return string(raw), ""
+}
+
+// Returns the unfolded value of each header of an email by lower-cased name, keeping the
+// last one for repeated headers as DKIM signs the last instance first
+func parseEmailHeaders(header string) map[string]string {
+	fields := make(map[string]string)
+	var name string
+	for _, line := range strings.Split(strings.TrimSuffix(header, "\r\n"), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && name != "" {
+			fields[name] += "\r\n" + line
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(parts[0]))
+		fields[name] = parts[1]
+	}
+	return fields
+}
+
+// Canonicalizes a header with the relaxed algorithm: a lower-case name and the value
+// unfolded, with whitespace collapsed to single spaces and trimmed
+func dkimRelaxedHeader(name string, value string) string {
+	value = strings.Replace(value, "\r\n", "", -1)
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.Join(strings.Fields(value), " ") + "\r\n"
+}
+
+// Canonicalizes a body with the relaxed algorithm: whitespace within lines collapsed to
+// single spaces, trailing whitespace and trailing empty lines removed
+func dkimRelaxedBody(body string) string {
+	lines := strings.Split(body, "\r\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		lines[i] = collapseWhitespace(line)
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// Replaces each run of spaces and tabs with a single space, keeping leading whitespace
+func collapseWhitespace(line string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range line {
+		if r == ' ' || r == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDKIM_canonicalization(t *testing.T) {
+	// The examples of RFC 6376 section 3.4.5
+	header := "A: X\r\nB : Y\t\r\n\tZ  \r\n"
+	fields := parseEmailHeaders(header)
+	if canonical := dkimRelaxedHeader("A", fields["a"]) + dkimRelaxedHeader("B", fields["b"]); canonical != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("unexpected canonical headers: %q", canonical)
+	}
+	if canonical := dkimRelaxedBody(" C \r\nD \t E\r\n\r\n\r\n"); canonical != " C\r\nD E\r\n" {
+		t.Errorf("unexpected canonical body: %q", canonical)
+	}
+	if canonical := dkimRelaxedBody("\r\n\r\n"); canonical != "" {
+		t.Errorf("expected an empty body to stay empty, got %q", canonical)
+	}
+}
+
+func TestDKIM_sign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	dir := t.TempDir()
+	keys := map[string][]byte{
+		"rsa.pem":     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		"ed25519.pem": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER}),
+	}
+	for name, contents := range keys {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, verify := range map[string]func(hash []byte, signature []byte) bool{
+		"rsa.pem": func(hash []byte, signature []byte) bool {
+			return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, hash, signature) == nil
+		},
+		"ed25519.pem": func(hash []byte, signature []byte) bool {
+			return ed25519.Verify(edKey.Public().(ed25519.PublicKey), hash, signature)
+		},
+	} {
+		handler := EmailHandler{
+			SendMode:     EmailSendIndividual,
+			Transport:    EmailTransportMX,
+			FromAddress:  "alerts@example.com",
+			HTML:         true,
+			DKIMSelector: "alerts",
+			DKIMKeyFile:  filepath.Join(dir, name),
+		}
+		if err := handler.validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		m := handler.newMessage("dc1", &AlertState{Status: "critical", Service: "redis", Message: "redis is critical", Details: "connection refused  \n"})
+		m.SetAddressHeader("To", "ops@example.com", "")
+		signed, err := handler.dkim.sign(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify the signature the way a receiving server would
+		header, body := splitEmail(signed)
+		fields := parseEmailHeaders(header)
+		signature := fields["dkim-signature"]
+		tags := make(map[string]string)
+		for _, tag := range strings.Split(signature, ";") {
+			parts := strings.SplitN(strings.TrimSpace(tag), "=", 2)
+			tags[parts[0]] = parts[1]
+		}
+		if tags["d"] != "example.com" || tags["s"] != "alerts" || tags["c"] != "relaxed/relaxed" {
+			t.Errorf("unexpected signature tags: %v", tags)
+		}
+
+		bodyHash := sha256.Sum256([]byte(dkimRelaxedBody(body)))
+		if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+			t.Errorf("body hash doesn't match the body with %s", name)
+		}
+
+		var data strings.Builder
+		for _, name := range strings.Split(tags["h"], ":") {
+			data.WriteString(dkimRelaxedHeader(name, fields[strings.ToLower(name)]))
+		}
+		unsigned := regexp.MustCompile(`b=[^;]*$`).ReplaceAllString(signature, "b=")
+		data.WriteString(strings.TrimSuffix(dkimRelaxedHeader("DKIM-Signature", unsigned), "\r\n"))
+		hash := sha256.Sum256([]byte(data.String()))
+		decoded, _ := base64.StdEncoding.DecodeString(tags["b"])
+		if !verify(hash[:], decoded) {
+			t.Errorf("signature doesn't verify with %s", name)
+		}
+		if !strings.Contains(tags["h"], "From") || !strings.Contains(tags["h"], "Subject") {
+			t.Errorf("expected the From and Subject headers to be signed, got %s", tags["h"])
+		}
+	}
+
+	invalid := []EmailHandler{
+		{SendMode: EmailSendIndividual, Transport: EmailTransportMX, DKIMSelector: "alerts"},
+		{SendMode: EmailSendIndividual, Transport: EmailTransportMX, DKIMSelector: "alerts", DKIMKeyFile: filepath.Join(dir, "missing.pem")},
+	}
+	for _, handler := range invalid {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
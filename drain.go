@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Counts the alerts queued for or being sent to handlers, so shutting down can wait for
+// them to be delivered
+type alertDrain struct {
+	sync.Mutex
+	pending int
+
+	// Closed once there are no alerts pending
+	idle chan struct{}
+}
+
+var pendingAlerts = newAlertDrain()
+
+func newAlertDrain() *alertDrain {
+	idle := make(chan struct{})
+	close(idle)
+	return &alertDrain{idle: idle}
+}
+
+func (d *alertDrain) add() {
+	d.Lock()
+	defer d.Unlock()
+	if d.pending == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.pending++
+}
+
+func (d *alertDrain) done() {
+	d.Lock()
+	defer d.Unlock()
+	d.pending--
+	if d.pending == 0 {
+		close(d.idle)
+	}
+}
+
+func (d *alertDrain) count() int {
+	d.Lock()
+	defer d.Unlock()
+	return d.pending
+}
+
+// Waits until no alerts are pending, returning false if the timeout passes first
+func (d *alertDrain) wait(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		d.Lock()
+		idle := d.idle
+		d.Unlock()
+
+		select {
+		case <-idle:
+			// More alerts may have been queued since the channel was closed
+			if d.count() == 0 {
+				return true
+			}
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// Sends the open batches and email digests and waits for the alerts being sent to be
+// delivered, retries included, until the timeout. The retries still running after it are cancelled; with
+// queue_dir set, their alerts are left in the queue and redelivered after a restart.
+func drainAlerts(timeout time.Duration) {
+	handlerBatches.flushAll()
+	emailDigests.flushAll()
+
+	if count := pendingAlerts.count(); count > 0 {
+		log.Infof("Waiting up to %s for %d alerts to be sent...", timeout, count)
+	}
+	if !pendingAlerts.wait(timeout) {
+		log.Warnf("Gave up waiting for %d alerts to be sent", pendingAlerts.count())
+	}
+	cancelRetries()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDrain_wait(t *testing.T) {
+	drain := newAlertDrain()
+	if !drain.wait(time.Millisecond) {
+		t.Fatal("expected nothing to wait for")
+	}
+
+	drain.add()
+	drain.add()
+	if drain.wait(10 * time.Millisecond) {
+		t.Fatal("expected the wait to time out with pending alerts")
+	}
+
+	go func() {
+		drain.done()
+		time.Sleep(10 * time.Millisecond)
+		drain.done()
+	}()
+	if !drain.wait(time.Second) {
+		t.Fatalf("expected the pending alerts to be drained, %d left", drain.count())
+	}
+}
+
+func TestDrain_flushesBatches(t *testing.T) {
+	alertCh := make(chan *AlertState, 1)
+	handler := namedHandler{name: "test", handler: testHandler{alertCh}}
+
+	defer func(batches *alertBatches) { handlerBatches = batches }(handlerBatches)
+	handlerBatches = &alertBatches{batches: make(map[string]*alertBatch)}
+	handlerBatches.add(handler, "test/dc1", "dc1", &AlertState{Service: "redis", Status: "critical"}, time.Hour)
+
+	// Draining cancels the retries left, so set up a new policy for the other tests after it
+	defer setRetryPolicy(retryPolicy{initial: 5 * time.Second, max: 60 * time.Second})
+	drainAlerts(time.Second)
+	select {
+	case alert := <-alertCh:
+		if alert.Service != "redis" {
+			t.Errorf("unexpected alert: %+v", alert)
+		}
+	default:
+		t.Fatal("expected the open batch to be sent before shutting down")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Whether the daemon runs in dry-run mode, where the watches run and the alerts are
+// decided as usual but are only logged instead of being sent to the handlers
+var dryRun = struct {
+	sync.Mutex
+	enabled bool
+}{}
+
+func setDryRun(enabled bool) {
+	dryRun.Lock()
+	defer dryRun.Unlock()
+	dryRun.enabled = enabled
+}
+
+func isDryRun() bool {
+	dryRun.Lock()
+	defer dryRun.Unlock()
+	return dryRun.enabled
+}
+
+// Sets up dry-run mode. Unless the state is already kept locally, the alert state is
+// copied from the Consul KV store into memory so the dry run starts from what the live
+// instances last alerted on, without its own decisions being written back for them to see.
+func startDryRun(client *api.Client) error {
+	setDryRun(true)
+	log.Warn("Running in dry-run mode, alerts are logged instead of being sent to the handlers")
+
+	localState.Lock()
+	defer localState.Unlock()
+	if localState.store != nil {
+		return nil
+	}
+	values, err := consulStore{client}.list(alertingKVRoot + "/")
+	if err != nil {
+		return err
+	}
+	localState.store = &localStore{values: values}
+	log.Infof("Keeping the dry run's alert state in memory (%d keys)", len(values))
+	return nil
+}
+
+// Logs the alert a handler would have been sent in dry-run mode, with all its details
+func logDryRun(h namedHandler, datacenter string, alert *AlertState) {
+	fields := stdoutFields(datacenter, alert)
+	fields["handler"] = h.name
+	fields["last_alerted"] = alert.LastAlerted
+	if alert.Severity != "" {
+		fields["severity"] = alert.Severity
+	}
+	if len(alert.Signatures) > 0 {
+		fields["signatures"] = strings.Join(alert.Signatures, ",")
+	}
+	log.WithFields(fields).Infof("Dry run: not sending alert '%s' to handler %s", alert.Message, h.name)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+	values[alertingKVRoot+"/redis/node1"] = []byte("stored")
+
+	if err := startDryRun(client); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		setDryRun(false)
+		localState.Lock()
+		localState.store = nil
+		localState.Unlock()
+	}()
+
+	alertCh := make(chan *AlertState, 1)
+	namedHandler{name: "test", handler: testHandler{alertCh}}.send("dc1", &AlertState{Service: "redis", Status: "critical"})
+	if len(alertCh) != 0 {
+		t.Error("expected the alert not to be sent in a dry run")
+	}
+
+	// The state is read from Consul but only written to memory
+	store := stateKV(client)
+	if value, ok, _ := store.get(alertingKVRoot + "/redis/node1"); !ok || string(value) != "stored" {
+		t.Errorf("expected the stored state to be copied, got %q", value)
+	}
+	if err := store.put(alertingKVRoot+"/redis/node2", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := values[alertingKVRoot+"/redis/node2"]; ok {
+		t.Error("expected the dry run not to write to Consul")
+	}
+}
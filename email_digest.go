@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/gomail.v2"
+)
+
+// An alert waiting to be sent in an email digest, with the datacenter it's from
+type emailDigestEntry struct {
+	datacenter string
+	alert      *AlertState
+}
+
+// The alerts collected by an email handler with a digest_window, sent as one email
+// once the window after the first of them has passed
+type emailDigest struct {
+	sync.Mutex
+	window time.Duration
+
+	// The handler the digest is sent with, the latest alert for each
+	// datacenter/service/tag/node/check in the order they first arrived, and whether
+	// the window is open
+	handler EmailHandler
+	keys    []string
+	entries map[string]emailDigestEntry
+	open    bool
+}
+
+// Tracks the digests of the email handlers, so they can be sent right away when shutting
+// down
+type emailDigestRegistry struct {
+	sync.Mutex
+	digests []*emailDigest
+	closed  bool
+}
+
+var emailDigests = &emailDigestRegistry{}
+
+// Creates the digest of an email handler with the given window in seconds
+func newEmailDigest(window int) *emailDigest {
+	digest := &emailDigest{
+		window:  time.Duration(window) * time.Second,
+		entries: make(map[string]emailDigestEntry),
+	}
+
+	emailDigests.Lock()
+	emailDigests.digests = append(emailDigests.digests, digest)
+	emailDigests.Unlock()
+	return digest
+}
+
+// Adds an alert to the digest, opening a window if there isn't one. The alerts arriving
+// while shutting down are sent right away instead.
+func (d *emailDigest) add(handler EmailHandler, datacenter string, alert *AlertState) {
+	emailDigests.Lock()
+	closed := emailDigests.closed
+	emailDigests.Unlock()
+
+	member := *alert
+	key := datacenter + "/" + alert.scopedService() + "/" + alert.Tag + "/" + alert.scopedNode() + "/" + alert.Check
+
+	d.Lock()
+	d.handler = handler
+	if _, ok := d.entries[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+	d.entries[key] = emailDigestEntry{datacenter, &member}
+	if !d.open && !closed {
+		d.open = true
+		time.AfterFunc(d.window, d.flush)
+	}
+	d.Unlock()
+
+	if closed {
+		d.flush()
+	}
+}
+
+// Sends the collected alerts, as a digest if there's more than one
+func (d *emailDigest) flush() {
+	d.Lock()
+	handler := d.handler
+	entries := make([]emailDigestEntry, 0, len(d.keys))
+	for _, key := range d.keys {
+		entries = append(entries, d.entries[key])
+	}
+	d.keys = nil
+	d.entries = make(map[string]emailDigestEntry)
+	d.open = false
+	d.Unlock()
+
+	// Each recipient gets a digest of the alerts routed to them, so the recipients are
+	// grouped by the alerts they get
+	recipientEntries := make(map[string][]emailDigestEntry)
+	recipientKeys := make(map[string]string)
+	for i, entry := range entries {
+		for _, recipient := range handler.alertRecipients(entry.alert) {
+			recipientEntries[recipient] = append(recipientEntries[recipient], entry)
+			recipientKeys[recipient] += fmt.Sprintf("%d/", i)
+		}
+	}
+	groups := make(map[string][]string)
+	groupKeys := make([]string, 0)
+	for recipient, key := range recipientKeys {
+		if _, ok := groups[key]; !ok {
+			groupKeys = append(groupKeys, key)
+		}
+		groups[key] = append(groups[key], recipient)
+	}
+	sort.Strings(groupKeys)
+
+	for _, key := range groupKeys {
+		recipients := groups[key]
+		sort.Strings(recipients)
+		members := recipientEntries[recipients[0]]
+
+		var err error
+		if len(members) == 1 {
+			entry := members[0]
+			err = handler.deliver(recipients, entry.alert, func() *gomail.Message {
+				return handler.newMessage(entry.datacenter, entry.alert)
+			})
+		} else {
+			err = handler.sendDigest(recipients, members)
+		}
+		if err != nil {
+			log.Errorf("Error sending email digest of %d alerts: %s", len(members), err)
+		}
+	}
+}
+
+// Sends every open digest now, as the process is shutting down. The digests are sent
+// concurrently and counted as pending alerts, and later alerts are sent right away.
+func (r *emailDigestRegistry) flushAll() {
+	r.Lock()
+	r.closed = true
+	digests := append([]*emailDigest{}, r.digests...)
+	r.Unlock()
+
+	for _, digest := range digests {
+		pendingAlerts.add()
+		go func(digest *emailDigest) {
+			defer pendingAlerts.done()
+			digest.flush()
+		}(digest)
+	}
+}
+
+// Sends a digest of the given alerts to the recipients as a single plain text email
+func (handler EmailHandler) sendDigest(recipients []string, entries []emailDigestEntry) error {
+	digest := emailDigestAlert(entries)
+	return handler.deliver(recipients, digest, func() *gomail.Message {
+		m := gomail.NewMessage()
+		m.SetAddressHeader("From", handler.from(), "Consul Alerting")
+		m.SetHeader("Subject", digest.Message)
+		m.SetBody("text/plain", digest.Details)
+		return m
+	})
+}
+
+// Summarizes the alerts of a digest as a single alert with their worst status, a
+// subject counting them by status, and the alerts grouped by datacenter and service
+func emailDigestAlert(entries []emailDigestEntry) *AlertState {
+	statuses := make(map[string]string)
+	counts := make(map[string]int)
+	datacenters := make([]string, 0)
+	groups := make(map[string][]*AlertState)
+	groupNames := make([]string, 0)
+
+	for i, entry := range entries {
+		statuses[fmt.Sprint(i)] = entry.alert.Status
+		counts[entry.alert.Status]++
+		if !contains(datacenters, entry.datacenter) {
+			datacenters = append(datacenters, entry.datacenter)
+		}
+
+		service := entry.alert.scopedService()
+		if service == "" {
+			service = "nodes"
+		}
+		group := entry.datacenter + " / " + service
+		if _, ok := groups[group]; !ok {
+			groupNames = append(groupNames, group)
+		}
+		groups[group] = append(groups[group], entry.alert)
+	}
+	sort.Strings(datacenters)
+	sort.Strings(groupNames)
+
+	summary := make([]string, 0)
+	for _, status := range []string{api.HealthCritical, api.HealthWarning, api.HealthPassing} {
+		if counts[status] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+
+	sections := make([]string, 0, len(groupNames))
+	for _, group := range groupNames {
+		lines := []string{group}
+		for _, alert := range groups[group] {
+			lines = append(lines, fmt.Sprintf("=> %s: %s", alert.Status, alert.Message))
+			for _, line := range strings.Split(strings.TrimSpace(alert.Details), "\n") {
+				if line != "" {
+					lines = append(lines, "   "+line)
+				}
+			}
+			if alert.ConsulURL != "" {
+				lines = append(lines, "   View in Consul: "+alert.ConsulURL)
+			}
+		}
+		sections = append(sections, strings.Join(lines, "\n"))
+	}
+
+	return &AlertState{
+		Status:  computeHealth(statuses),
+		Message: fmt.Sprintf("[%s] %d alerts: %s", strings.Join(datacenters, ", "), len(entries), strings.Join(summary, ", ")),
+		Details: strings.Join(sections, "\n\n"),
+	}
+}
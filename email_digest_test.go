@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEmailDigest_alert(t *testing.T) {
+	digest := emailDigestAlert([]emailDigestEntry{
+		{"dc2", &AlertState{Service: "web", Status: "passing", Message: "web is passing"}},
+		{"dc1", &AlertState{Service: "redis", Node: "node1", Status: "critical", Message: "redis is critical", Details: "connection refused"}},
+		{"dc1", &AlertState{Node: "node2", Status: "warning", Message: "node2 is warning"}},
+		{"dc1", &AlertState{Service: "redis", Node: "node2", Status: "critical", Message: "redis is critical"}},
+	})
+
+	if digest.Status != "critical" {
+		t.Errorf("expected the worst status, got %s", digest.Status)
+	}
+	if digest.Message != "[dc1, dc2] 4 alerts: 2 critical, 1 warning, 1 passing" {
+		t.Errorf("unexpected subject: %s", digest.Message)
+	}
+	expected := `dc1 / nodes
+=> warning: node2 is warning
+
+dc1 / redis
+=> critical: redis is critical
+   connection refused
+=> critical: redis is critical
+
+dc2 / web
+=> passing: web is passing`
+	if digest.Details != expected {
+		t.Errorf("unexpected body:\n%s", digest.Details)
+	}
+}
+
+func TestEmailDigest_flush(t *testing.T) {
+	defer func(digests *emailDigestRegistry) { emailDigests = digests }(emailDigests)
+	emailDigests = &emailDigestRegistry{}
+
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	handler := EmailHandler{
+		Recipients:   []string{"admin@example.com", "ops@example.org"},
+		SendMode:     EmailSendTo,
+		Transport:    EmailTransportSMTP,
+		SMTPHost:     host,
+		SMTPPort:     portNum,
+		SMTPTLS:      EmailTLSStartTLS,
+		DigestWindow: 3600,
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Service: "redis", Node: "node1", Status: "critical", Message: "redis is critical"})
+	handler.Alert("dc1", &AlertState{Service: "web", Node: "node1", Status: "warning", Message: "web is warning"})
+	// A later alert for the same instance replaces the earlier one
+	handler.Alert("dc1", &AlertState{Service: "redis", Node: "node1", Status: "passing", Message: "redis is passing"})
+	if server.data != "" {
+		t.Fatal("expected nothing to be sent before the window ends")
+	}
+
+	handler.digest.flush()
+	if len(server.recipients) != 2 {
+		t.Errorf("expected a single email to both recipients, got %v", server.recipients)
+	}
+	if !strings.Contains(server.data, "Subject: [dc1] 2 alerts: 1 warning, 1 passing") {
+		t.Errorf("expected a digest of both alerts, got: %s", server.data)
+	}
+
+	if err := (&EmailHandler{SendMode: EmailSendIndividual, Transport: EmailTransportMX, DigestWindow: -1}).validate(); err == nil {
+		t.Error("expected an error for a negative digest_window")
+	}
+}
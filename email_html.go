@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+)
+
+// The template used for HTML emails when the handler doesn't set html_template
+const defaultEmailHTMLTemplate = `<html>
+<body style="font-family: sans-serif;">
+<h2 style="color: {{statusColor .Status}};">{{.Message}}</h2>
+<table cellpadding="6" style="border-collapse: collapse;">
+<tr><th align="left">Status</th><td style="color: {{statusColor .Status}};">{{.Status}}</td></tr>
+{{if .Service}}<tr><th align="left">Service</th><td>{{.Service}}{{if .Tag}} ({{.Tag}}){{end}}</td></tr>
+{{end}}{{if .Node}}<tr><th align="left">Node</th><td>{{.Node}}</td></tr>
+{{end}}<tr><th align="left">Datacenter</th><td>{{.Datacenter}}</td></tr>
+{{if .Check}}<tr><th align="left">Check</th><td>{{.Check}}</td></tr>
+{{end}}{{if .ConsulURL}}<tr><th align="left">Consul</th><td><a href="{{.ConsulURL}}">View in Consul</a></td></tr>
+{{end}}</table>
+{{if .Details}}<h3>Check output</h3>
+<pre style="background: #f4f4f4; padding: 8px;">{{.Details}}</pre>
+{{end}}</body>
+</html>
+`
+
+var emailTemplateFuncs = template.FuncMap{
+	"statusColor": func(status string) string {
+		return fmt.Sprintf("#%06X", alertStatusColor(status))
+	},
+}
+
+// Parses the HTML email template from the given file, or the default template if
+// the path is empty
+func loadEmailHTMLTemplate(path string) (*template.Template, error) {
+	text := defaultEmailHTMLTemplate
+	if path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading html_template: %s", err)
+		}
+		text = string(contents)
+	}
+
+	tmpl, err := template.New("email").Funcs(emailTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing html_template: %s", err)
+	}
+	return tmpl, nil
+}
+
+// Renders the HTML body of an alert email
+func renderEmailHTML(tmpl *template.Template, datacenter string, alert *AlertState) (string, error) {
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, alertPayload{datacenter, alert}); err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
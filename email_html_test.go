@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmailHTML_defaultTemplate(t *testing.T) {
+	tmpl, err := loadEmailHTMLTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := renderEmailHTML(tmpl, "dc1", &AlertState{
+		Status:  "critical",
+		Service: "redis",
+		Node:    "node1",
+		Message: "redis is critical",
+		Details: "<connection refused>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"redis is critical", "<td>redis</td>", "<td>node1</td>", "<td>dc1</td>", "#A30200", "&lt;connection refused&gt;"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in rendered email:\n%s", expected, body)
+		}
+	}
+}
+
+func TestEmailHTML_customTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "alert.html")
+	if err := ioutil.WriteFile(path, []byte("<p>{{.Datacenter}}/{{.Service}}: {{.Status}}</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := EmailHandler{SendMode: EmailSendIndividual, Transport: EmailTransportMX, HTMLTemplate: path}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := handler.newMessage("dc1", &AlertState{Status: "passing", Service: "redis"})
+	var raw strings.Builder
+	m.WriteTo(&raw)
+	if !strings.Contains(raw.String(), "text/html") || !strings.Contains(raw.String(), "<p>dc1/redis: passing</p>") {
+		t.Errorf("expected an html alternative in the email:\n%s", raw.String())
+	}
+
+	handler.HTMLTemplate = filepath.Join(dir, "missing.html")
+	if err := handler.validate(); err == nil {
+		t.Error("expected an error for a missing template file")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A rule sending the alerts it matches to other recipients than recipients. Every set
+// condition must match; the first matching rule picks the recipients.
+type EmailRecipientRoute struct {
+	Service    string   `mapstructure:"service"`
+	Tags       []string `mapstructure:"tags"`
+	Recipients []string `mapstructure:"recipients"`
+
+	serviceRegexp *regexp.Regexp
+}
+
+func (route *EmailRecipientRoute) validate() error {
+	if len(route.Recipients) == 0 {
+		return fmt.Errorf("recipients must be set")
+	}
+	for i, recipient := range route.Recipients {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("Invalid address in recipients[%d] %q: %s", i, recipient, err)
+		}
+		route.Recipients[i] = address.Address
+	}
+
+	var err error
+	if route.serviceRegexp, err = compileRouteRegexp(route.Service); err != nil {
+		return fmt.Errorf("invalid service pattern: %s", err)
+	}
+	return nil
+}
+
+func (route *EmailRecipientRoute) matches(alert *AlertState) bool {
+	if route.serviceRegexp != nil && !route.serviceRegexp.MatchString(alert.Service) {
+		return false
+	}
+	if len(route.Tags) > 0 && !contains(route.Tags, alert.Tag) {
+		return false
+	}
+	return true
+}
+
+// Returns the deduplicated recipients of the first recipient route matching an alert, or
+// of recipients if none match, leaving out those an earlier email handler sent it to
+func (handler EmailHandler) alertRecipients(alert *AlertState) []string {
+	recipients := handler.Recipients
+	for i := range handler.RecipientRoutes {
+		if handler.RecipientRoutes[i].matches(alert) {
+			recipients = handler.RecipientRoutes[i].Recipients
+			break
+		}
+	}
+
+	deduped := dedupeRecipients(recipients)
+	if len(alert.skipRecipients) == 0 {
+		return deduped
+	}
+	kept := make([]string, 0, len(deduped))
+	for _, recipient := range deduped {
+		if !contains(alert.skipRecipients, strings.ToLower(recipient)) {
+			kept = append(kept, recipient)
+		}
+	}
+	return kept
+}
+
+// Sends an alert to each of the named handlers like dispatchAlert. A recipient of several
+// of the email handlers only gets the alert from the first of them, so overlapping
+// handlers don't send the same alert twice.
+func dispatchAlerts(config *Config, names []string, datacenter string, alert *AlertState) {
+	claimed := make([]string, 0)
+	for _, name := range names {
+		handler := config.namedHandler(name)
+		email, ok := handler.handler.(EmailHandler)
+		if !ok || (handler.options.SkipRecoveries && isRecovery(alert)) {
+			dispatchAlert(config, name, datacenter, alert)
+			continue
+		}
+
+		var skipped []string
+		for _, recipient := range email.alertRecipients(alert) {
+			if key := strings.ToLower(recipient); contains(claimed, key) {
+				skipped = append(skipped, key)
+			} else {
+				claimed = append(claimed, key)
+			}
+		}
+		if len(skipped) == 0 {
+			dispatchAlert(config, name, datacenter, alert)
+			continue
+		}
+
+		deduped := *alert
+		deduped.skipRecipients = skipped
+		if len(email.alertRecipients(&deduped)) == 0 {
+			log.Debugf("Not sending alert '%s' to %s, its recipients already got it", alert.Message, name)
+			continue
+		}
+		dispatchAlert(config, name, datacenter, &deduped)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEmailRecipients_alertRecipients(t *testing.T) {
+	handler := EmailHandler{
+		Recipients: []string{"Ops <ops@example.com>"},
+		SendMode:   EmailSendIndividual,
+		Transport:  EmailTransportMX,
+		RecipientRoutes: []EmailRecipientRoute{
+			{Service: "db-.*", Recipients: []string{"dba@example.com", "DBA <DBA@example.com> "}},
+			{Tags: []string{"payments"}, Recipients: []string{"payments@example.com", "ops@example.com"}},
+		},
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		alert    AlertState
+		expected []string
+	}{
+		{AlertState{Service: "db-orders"}, []string{"dba@example.com"}},
+		{AlertState{Service: "api", Tag: "payments"}, []string{"payments@example.com", "ops@example.com"}},
+		{AlertState{Service: "redis"}, []string{"ops@example.com"}},
+		{AlertState{Node: "node1"}, []string{"ops@example.com"}},
+	}
+	for _, c := range cases {
+		if actual := handler.alertRecipients(&c.alert); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("expected recipients %v for %+v, got %v", c.expected, c.alert, actual)
+		}
+	}
+
+	invalid := []EmailRecipientRoute{
+		{Service: "db-.*"},
+		{Service: "db-.*", Recipients: []string{"not an address"}},
+		{Service: "(", Recipients: []string{"dba@example.com"}},
+	}
+	for _, route := range invalid {
+		handler := EmailHandler{SendMode: EmailSendIndividual, Transport: EmailTransportMX, RecipientRoutes: []EmailRecipientRoute{route}}
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", route)
+		}
+	}
+}
+
+func TestEmailRecipients_acrossHandlers(t *testing.T) {
+	defer func(batches *alertBatches) { handlerBatches = batches }(handlerBatches)
+	handlerBatches = &alertBatches{batches: make(map[string]*alertBatch)}
+
+	ops := EmailHandler{Recipients: []string{"ops@example.com", "Oncall <oncall@example.com>"}, SendMode: EmailSendIndividual, Transport: EmailTransportMX}
+	dba := EmailHandler{Recipients: []string{"ONCALL@example.com", "dba@example.com"}, SendMode: EmailSendIndividual, Transport: EmailTransportMX}
+	oncall := EmailHandler{Recipients: []string{"oncall@example.com"}, SendMode: EmailSendIndividual, Transport: EmailTransportMX}
+	for _, handler := range []*EmailHandler{&ops, &dba, &oncall} {
+		if err := handler.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The batches hold the alerts each handler was given, without sending them
+	config := &Config{
+		Handlers: map[string]AlertHandler{"email.ops": ops, "email.dba": dba, "email.oncall": oncall},
+		HandlerOptions: map[string]HandlerOptions{
+			"email.ops":    {BatchWindow: 3600},
+			"email.dba":    {BatchWindow: 3600},
+			"email.oncall": {BatchWindow: 3600},
+		},
+	}
+	dispatchAlerts(config, []string{"email.ops", "email.dba", "email.oncall"}, "dc1", &AlertState{Service: "redis", Status: "critical"})
+
+	expected := map[string][]string{
+		"email.ops": {"ops@example.com", "oncall@example.com"},
+		"email.dba": {"dba@example.com"},
+	}
+	for name, recipients := range expected {
+		batch, ok := handlerBatches.batches[name+"/dc1"]
+		if !ok {
+			t.Fatalf("expected the alert to be sent to %s", name)
+		}
+		for _, alert := range batch.alerts {
+			if actual := config.Handlers[name].(EmailHandler).alertRecipients(alert); !reflect.DeepEqual(actual, recipients) {
+				t.Errorf("expected recipients %v for %s, got %v", recipients, name, actual)
+			}
+		}
+	}
+	if _, ok := handlerBatches.batches["email.oncall/dc1"]; ok {
+		t.Error("expected the alert not to be sent to email.oncall, whose recipients already got it")
+	}
+}
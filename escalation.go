@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+)
+
+// An escalation policy, sending alerts that are still failing after each step's delay
+// to that step's handlers
+type EscalationConfig struct {
+	Name  string           `json:"name"`
+	Steps []EscalationStep `mapstructure:"step" json:"steps"`
+}
+
+type EscalationStep struct {
+	// The time (in seconds) after the alert was first sent
+	After    int      `mapstructure:"after" json:"after"`
+	Handlers []string `mapstructure:"handlers" json:"handlers"`
+}
+
+// Parse the raw escalation objects into the config
+func parseEscalations(list *ast.ObjectList, config *Config) error {
+	config.Escalations = make(map[string]EscalationConfig)
+
+	for _, e := range list.Items {
+		if len(e.Keys) != 1 {
+			return fmt.Errorf("escalation policy must be in the form 'escalation_policy \"name\" {}'")
+		}
+		name := e.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var policy EscalationConfig
+		if err := hcl.DecodeObject(&m, e.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &policy); err != nil {
+			return err
+		}
+		policy.Name = name
+
+		if err := policy.validate(config); err != nil {
+			return fmt.Errorf("Error loading escalation policy %s: %s", name, err)
+		}
+		config.Escalations[name] = policy
+	}
+
+	return nil
+}
+
+func (policy *EscalationConfig) validate(config *Config) error {
+	if len(policy.Steps) == 0 {
+		return fmt.Errorf("no steps set")
+	}
+
+	for _, step := range policy.Steps {
+		if step.After <= 0 {
+			return fmt.Errorf("after must be positive")
+		}
+		if len(step.Handlers) == 0 {
+			return fmt.Errorf("no handlers set for the step after %d seconds", step.After)
+		}
+		for _, name := range step.Handlers {
+			if _, ok := config.Handlers[name]; !ok {
+				return fmt.Errorf("unknown handler %s", name)
+			}
+		}
+	}
+
+	sort.SliceStable(policy.Steps, func(i, j int) bool {
+		return policy.Steps[i].After < policy.Steps[j].After
+	})
+	return nil
+}
+
+// A failing alert being escalated, along with the handlers that have been sent it
+type alertEscalation struct {
+	config   *Config
+	alert    *AlertState
+	notified []string
+	timers   []*time.Timer
+}
+
+// Tracks the alerts being escalated, keyed by datacenter/service/tag/node/check
+type escalationTracker struct {
+	sync.Mutex
+	active map[string]*alertEscalation
+}
+
+var escalations = &escalationTracker{
+	active: make(map[string]*alertEscalation),
+}
+
+// Starts escalating a failing alert that was sent to the given handlers, following the
+// service's escalation policy. Once the alert recovers, the escalation stops and the
+// recovery is also sent to the handlers it was escalated to.
+func (e *escalationTracker) update(config *Config, service string, alert *AlertState, sentTo []string) {
+	key := alertIncidentKey(config.ConsulDatacenter, alert) + "-" + alert.Check
+
+	e.Lock()
+	escalation, ok := e.active[key]
+	if alert.Status == api.HealthPassing {
+		e.stop(key)
+		e.Unlock()
+		if !ok {
+			return
+		}
+
+		recovery := *alert
+		var names []string
+		for _, name := range escalation.notified {
+			if !contains(sentTo, name) {
+				names = append(names, name)
+			}
+		}
+		dispatchAlerts(escalation.config, names, config.ConsulDatacenter, &recovery)
+		return
+	}
+	defer e.Unlock()
+
+	latest := *alert
+	if ok {
+		escalation.alert = &latest
+		for _, name := range sentTo {
+			if !contains(escalation.notified, name) {
+				escalation.notified = append(escalation.notified, name)
+			}
+		}
+		return
+	}
+
+	policy := config.serviceEscalation(service)
+	if policy == nil {
+		return
+	}
+
+	escalation = &alertEscalation{config: config, alert: &latest, notified: append([]string{}, sentTo...)}
+	for _, step := range policy.Steps {
+		step := step
+		escalation.timers = append(escalation.timers, time.AfterFunc(time.Duration(step.After)*time.Second, func() {
+			e.escalate(key, escalation, step)
+		}))
+	}
+	e.active[key] = escalation
+}
+
+// Resumes the escalation of an alert that has been failing for a while before a restart.
+// The steps that were due by now were taken before the restart, unless the alert was
+// acknowledged, so only the later steps are scheduled.
+func (e *escalationTracker) resume(config *Config, service string, alert *AlertState, sentTo []string, failing time.Duration) {
+	policy := config.serviceEscalation(service)
+	if policy == nil {
+		return
+	}
+	key := alertIncidentKey(config.ConsulDatacenter, alert) + "-" + alert.Check
+
+	e.Lock()
+	defer e.Unlock()
+	if _, ok := e.active[key]; ok {
+		return
+	}
+
+	_, acked := alertAcks.acked(config.ConsulDatacenter, alert)
+	latest := *alert
+	escalation := &alertEscalation{config: config, alert: &latest, notified: append([]string{}, sentTo...)}
+	for _, step := range policy.Steps {
+		step := step
+		after := time.Duration(step.After) * time.Second
+		if after <= failing {
+			for _, name := range step.Handlers {
+				if !acked && !contains(escalation.notified, name) {
+					escalation.notified = append(escalation.notified, name)
+				}
+			}
+			continue
+		}
+		escalation.timers = append(escalation.timers, time.AfterFunc(after-failing, func() {
+			e.escalate(key, escalation, step)
+		}))
+	}
+	e.active[key] = escalation
+}
+
+// Sends a still failing, unacknowledged alert to the handlers of an escalation step it
+// hasn't been sent to
+func (e *escalationTracker) escalate(key string, escalation *alertEscalation, step EscalationStep) {
+	e.Lock()
+	if e.active[key] != escalation {
+		e.Unlock()
+		return
+	}
+	if ack, ok := alertAcks.acked(escalation.config.ConsulDatacenter, escalation.alert); ok {
+		log.Infof("Not escalating alert '%s', acknowledged by %s", escalation.alert.Message, ack.By)
+		e.Unlock()
+		return
+	}
+
+	names := make([]string, 0, len(step.Handlers))
+	for _, name := range step.Handlers {
+		if !contains(escalation.notified, name) {
+			names = append(names, name)
+			escalation.notified = append(escalation.notified, name)
+		}
+	}
+	escalated := *escalation.alert
+	escalated.Message = fmt.Sprintf("Escalated after %s: %s", time.Duration(step.After)*time.Second, escalated.Message)
+	e.Unlock()
+
+	for _, name := range names {
+		log.Infof("Escalating alert '%s' to %s", escalated.Message, name)
+	}
+	dispatchAlerts(escalation.config, names, escalation.config.ConsulDatacenter, &escalated)
+}
+
+// Stops the escalation of an alert. Must be called with the lock held.
+func (e *escalationTracker) stop(key string) {
+	if escalation, ok := e.active[key]; ok {
+		for _, timer := range escalation.timers {
+			timer.Stop()
+		}
+		delete(e.active, key)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEscalation_parse(t *testing.T) {
+	config, err := ParseConfig(`
+	escalation = "default"
+
+	service "payments" {
+		escalation = "payments"
+	}
+
+	escalation_policy "default" {
+		step {
+			after = 600
+			handlers = ["stdout.log"]
+		}
+	}
+
+	escalation_policy "payments" {
+		step {
+			after = 1800
+			handlers = ["stdout.manager"]
+		}
+		step {
+			after = 600
+			handlers = ["stdout.log"]
+		}
+	}
+
+	handler "stdout" "log" {}
+	handler "stdout" "manager" {}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &EscalationConfig{
+		Name: "payments",
+		Steps: []EscalationStep{
+			{After: 600, Handlers: []string{"stdout.log"}},
+			{After: 1800, Handlers: []string{"stdout.manager"}},
+		},
+	}
+	if policy := config.serviceEscalation("payments"); !reflect.DeepEqual(policy, expected) {
+		t.Errorf("expected policy %+v, got %+v", expected, policy)
+	}
+	if policy := config.serviceEscalation("web"); policy == nil || policy.Name != "default" {
+		t.Errorf("expected the default policy, got %+v", policy)
+	}
+
+	for _, raw := range []string{
+		`escalation = "missing"`,
+		`escalation_policy "empty" {}`,
+		`escalation_policy "unknown" {
+			step {
+				after = 600
+				handlers = ["stdout.missing"]
+			}
+		}`,
+		`escalation_policy "immediate" {
+			step {
+				after = 0
+				handlers = ["stdout.log"]
+			}
+		}`,
+	} {
+		if _, err := ParseConfig(raw + "\nhandler \"stdout\" \"log\" {}"); err == nil {
+			t.Errorf("expected error for %q, but nothing was returned", raw)
+		}
+	}
+}
+
+func TestEscalation_resume(t *testing.T) {
+	config := &Config{
+		ConsulDatacenter: "dc1",
+		Escalation:       "ops",
+		Escalations: map[string]EscalationConfig{
+			"ops": EscalationConfig{Name: "ops", Steps: []EscalationStep{
+				{After: 600, Handlers: []string{"pager"}},
+				{After: 3600, Handlers: []string{"phone"}},
+			}},
+		},
+	}
+	tracker := &escalationTracker{active: make(map[string]*alertEscalation)}
+
+	alert := &AlertState{Service: "web", Status: "critical", Message: "web is critical"}
+	tracker.resume(config, "web", alert, []string{"slack"}, 30*time.Minute)
+
+	escalation := tracker.active[alertIncidentKey("dc1", alert)+"-"]
+	if escalation == nil {
+		t.Fatal("expected the escalation to resume")
+	}
+	defer tracker.stop(alertIncidentKey("dc1", alert) + "-")
+
+	// The step due before the restart was already taken, and only the later one is left
+	if !reflect.DeepEqual(escalation.notified, []string{"slack", "pager"}) {
+		t.Errorf("unexpected handlers notified: %v", escalation.notified)
+	}
+	if len(escalation.timers) != 1 {
+		t.Errorf("expected 1 step to be scheduled, got %d", len(escalation.timers))
+	}
+}
+
+func TestEscalation_untilRecovered(t *testing.T) {
+	slackCh := make(chan *AlertState, 10)
+	pagerCh := make(chan *AlertState, 10)
+	config := &Config{
+		ConsulDatacenter: "dc1",
+		Escalation:       "ops",
+		Escalations: map[string]EscalationConfig{
+			"ops": EscalationConfig{Name: "ops", Steps: []EscalationStep{
+				{After: 3600, Handlers: []string{"slack", "pager"}},
+			}},
+		},
+		Handlers: map[string]AlertHandler{
+			"slack": testHandler{slackCh},
+			"pager": testHandler{pagerCh},
+		},
+	}
+	tracker := &escalationTracker{active: make(map[string]*alertEscalation)}
+
+	alert := &AlertState{Service: "web", Status: "critical", Message: "web is critical"}
+	tracker.update(config, "web", alert, []string{"slack"})
+
+	key := alertIncidentKey("dc1", alert) + "-"
+	escalation := tracker.active[key]
+	if escalation == nil {
+		t.Fatal("expected the alert to be escalated")
+	}
+
+	// Firing the step only sends the alert to the handlers that haven't received it
+	tracker.escalate(key, escalation, config.Escalations["ops"].Steps[0])
+	if len(slackCh) != 0 {
+		t.Errorf("expected no escalation to a handler already alerted, got %d", len(slackCh))
+	}
+	escalated := <-pagerCh
+	if escalated.Message != "Escalated after 1h0m0s: web is critical" {
+		t.Errorf("unexpected message: %s", escalated.Message)
+	}
+
+	// The recovery is sent to the handlers the alert was escalated to
+	tracker.update(config, "web", &AlertState{Service: "web", Status: "passing", Message: "web is passing"}, []string{"slack"})
+	if len(tracker.active) != 0 {
+		t.Fatal("expected the escalation to stop")
+	}
+	if recovery := <-pagerCh; recovery.Status != "passing" {
+		t.Errorf("expected a recovery, got %s", recovery.Status)
+	}
+	if len(slackCh) != 0 {
+		t.Errorf("expected no extra recovery for a handler already alerted, got %d", len(slackCh))
+	}
+
+	// A stopped escalation's step does nothing
+	tracker.escalate(key, escalation, config.Escalations["ops"].Steps[0])
+	if len(pagerCh) != 0 {
+		t.Errorf("expected no more alerts, got %d", len(pagerCh))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The node meta key consul-esm registers external nodes with, set to "true"
+const externalNodeMeta = "external-node"
+
+// A node in the catalog along with its metadata, which the vendored API client's Node
+// doesn't include
+type catalogNodeMeta struct {
+	Node    string
+	Address string
+	Meta    map[string]string
+}
+
+// Returns whether a node's metadata marks it as an external node registered by consul-esm,
+// which has no agent running on it
+func isExternalNode(meta map[string]string) bool {
+	return meta[externalNodeMeta] == "true"
+}
+
+// Lists the nodes in the catalog along with their metadata
+func catalogNodes(client *api.Client, queryOpts *api.QueryOptions) ([]catalogNodeMeta, *api.QueryMeta, error) {
+	var nodes []catalogNodeMeta
+	queryMeta, err := client.Raw().Query("/v1/catalog/nodes", &nodes, queryOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodes, queryMeta, nil
+}
+
+// Returns the services registered on the local node and, with external_services set, on
+// the external nodes registered by consul-esm, as a map of service:[tags]. With external
+// services, the query blocks on the catalog's services, since the services of other nodes
+// don't change the index of the local node.
+func localServices(nodeName string, config *Config, client *api.Client, queryOpts *api.QueryOptions) (map[string][]string, *api.QueryMeta, error) {
+	services := make(map[string][]string)
+	addServices := func(node *api.CatalogNode) {
+		if node == nil {
+			return
+		}
+		for _, service := range node.Services {
+			tags := services[service.Service]
+			for _, tag := range service.Tags {
+				if !contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+			services[service.Service] = tags
+		}
+	}
+
+	if !config.ExternalServices {
+		node, queryMeta, err := client.Catalog().Node(nodeName, queryOpts)
+		if err != nil {
+			return nil, nil, err
+		}
+		addServices(node)
+		return services, queryMeta, nil
+	}
+
+	_, queryMeta, err := client.Catalog().Services(queryOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes, _, err := catalogNodes(client, config.queryOptions())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, node := range nodes {
+		if node.Node != nodeName && !isExternalNode(node.Meta) {
+			continue
+		}
+		catalogNode, _, err := client.Catalog().Node(node.Node, config.queryOptions())
+		if err != nil {
+			return nil, nil, err
+		}
+		addServices(catalogNode)
+	}
+	return services, queryMeta, nil
+}
+
+// Returns the line added to the details of alerts on external nodes, naming the node and
+// its address since there's no agent on it to look up
+func externalNodeDetails(alert *AlertState) string {
+	return fmt.Sprintf("External node %s (%s), monitored by consul-esm", alert.Node, alert.NodeAddress)
+}
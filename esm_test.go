@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestESM_localServices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-Index", "5")
+		switch r.URL.Path {
+		case "/v1/catalog/services":
+			w.Write([]byte(`{"redis": [], "web": [], "external-db": ["primary"]}`))
+		case "/v1/catalog/nodes":
+			w.Write([]byte(`[
+				{"Node": "node1", "Address": "10.0.0.1"},
+				{"Node": "node2", "Address": "10.0.0.2"},
+				{"Node": "db.example.com", "Address": "db.example.com", "Meta": {"external-node": "true"}}
+			]`))
+		case "/v1/catalog/node/node1":
+			w.Write([]byte(`{"Node": {"Node": "node1"}, "Services": {"redis": {"Service": "redis", "Tags": ["cache"]}}}`))
+		case "/v1/catalog/node/db.example.com":
+			w.Write([]byte(`{"Node": {"Node": "db.example.com"}, "Services": {"external-db": {"Service": "external-db", "Tags": ["primary"]}}}`))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = strings.TrimPrefix(server.URL, "http://")
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services, queryMeta, err := localServices("node1", &Config{}, client, &api.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(services, map[string][]string{"redis": {"cache"}}) {
+		t.Errorf("expected only the local node's services, got %v", services)
+	}
+
+	// The services of external nodes are watched along with the local node's
+	services, queryMeta, err = localServices("node1", &Config{ExternalServices: true}, client, &api.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for service := range services {
+		names = append(names, service)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"external-db", "redis"}) {
+		t.Errorf("expected the local and external services, got %v", services)
+	}
+	if queryMeta.LastIndex != 5 {
+		t.Errorf("expected the index of the catalog's services, got %d", queryMeta.LastIndex)
+	}
+}
+
+func TestESM_externalNodeDetails(t *testing.T) {
+	if !isExternalNode(map[string]string{"external-node": "true", "external-probe": "true"}) || isExternalNode(nil) {
+		t.Error("expected only nodes with external-node=true to be external")
+	}
+
+	alert := &AlertState{Node: "db.example.com", NodeAddress: "10.1.0.5"}
+	if details := externalNodeDetails(alert); details != "External node db.example.com (10.1.0.5), monitored by consul-esm" {
+		t.Errorf("unexpected details: %s", details)
+	}
+}
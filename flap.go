@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The flapping state of an alert
+type flapState struct {
+	// The times the alert changed status within the flap window
+	changes []time.Time
+
+	// While flapping, the latest alert held back from the handlers, the last status the
+	// handlers were sent, and the timer ending the flapping once the alert is stable
+	flapping bool
+	latest   *AlertState
+	alerted  string
+	stable   *time.Timer
+}
+
+// Tracks how often alerts change status, keyed by datacenter/service/tag/node/check
+type flapTracker struct {
+	sync.Mutex
+	states map[string]*flapState
+}
+
+var flapDetection = &flapTracker{
+	states: make(map[string]*flapState),
+}
+
+// Sends an alert to the handlers chosen for it, or to the handlers of the maintenance
+// window it falls in. Alerts on silenced services or suppressed by an inhibit rule aren't
+// sent at all, alerts held back by an alert storm are sent once it's over if they're still
+// failing, and alerts outside maintenance windows are escalated by the service's
+// escalation policy.
+func notifyHandlers(config *Config, service string, tags []string, alert *AlertState, lastAlerted string) {
+	// Alerts that don't come from a watch, like SLOs and received alerts, start their
+	// trace here
+	route := startSpan(alert.span, "alert.route", "consul.service", service, "alert.status", alert.Status)
+	defer route.finish(nil)
+	if alert.span == nil {
+		alert.span = route
+	}
+
+	annotateAck(config.ConsulDatacenter, alert)
+	annotateConsulLink(config, alert)
+	alert.Labels = config.alertLabels(service)
+	names := config.severityHandlerNames(config.ConsulDatacenter, service, alert.Node, alert.NodeMeta, tags, alert.Status, alertedStatuses(alert, lastAlerted)...)
+	if window := config.activeMaintenance(service, tags, time.Now()); window != nil {
+		log.Infof("Alert '%s' is in maintenance window %s, action: %s", alert.Message, window.Name, window.Action)
+		names = config.maintenanceHandlerNames(window)
+		route.setAttributes("alert.maintenance_window", window.Name)
+	} else if s, ok := alertSilences.silenced(service, alert.Node, time.Now()); ok {
+		log.Infof("Alert '%s' is silenced until %s: %s", alert.Message, s.Expires.Format(time.RFC3339), s.Reason)
+		route.setAttributes("alert.outcome", "silenced")
+		return
+	} else if rule, released := inhibitions.check(config, service, tags, alert); rule != "" {
+		log.Infof("Alert '%s' is inhibited by rule %s", alert.Message, rule)
+		route.setAttributes("alert.outcome", "inhibited", "alert.inhibit_rule", rule)
+		return
+	} else if alertStorms.hold(config, service, tags, alert) {
+		route.setAttributes("alert.outcome", "held by alert storm")
+		return
+	} else {
+		// The handlers of an alert that was held back never got its failing status
+		if released {
+			names = config.severityHandlerNames(config.ConsulDatacenter, service, alert.Node, alert.NodeMeta, tags, alert.Status, api.HealthPassing)
+		}
+		escalations.update(config, service, alert, names)
+	}
+
+	route.setAttributes("alert.handlers", strings.Join(names, ","))
+	dispatchAlerts(config, names, config.ConsulDatacenter, alert)
+}
+
+// Sends an alert to its handlers unless it's flapping. An alert that changes status more
+// than the service's flap_threshold times within its flap_window is sent once as flapping,
+// and its further changes are held back until it has been stable for a whole window.
+func (f *flapTracker) notify(config *Config, service string, tags []string, alert *AlertState) {
+	threshold, window := config.serviceFlapDetection(service)
+	if threshold <= 0 {
+		notifyHandlers(config, service, tags, alert, alert.LastAlerted)
+		return
+	}
+	windowDuration := time.Duration(window) * time.Second
+
+	f.Lock()
+	key := alertIncidentKey(config.ConsulDatacenter, alert) + "-" + alert.Check
+	state, ok := f.states[key]
+	if !ok {
+		state = &flapState{}
+		f.states[key] = state
+	}
+
+	now := time.Now()
+	changes := make([]time.Time, 0, len(state.changes)+1)
+	for _, change := range state.changes {
+		if now.Sub(change) < windowDuration {
+			changes = append(changes, change)
+		}
+	}
+	state.changes = append(changes, now)
+
+	held := *alert
+	if state.flapping {
+		log.Debugf("Holding back alert '%s' while flapping", alert.Message)
+		state.latest = &held
+		state.stable.Reset(windowDuration)
+		f.Unlock()
+		return
+	}
+
+	if len(state.changes) <= threshold {
+		if len(state.changes) == 1 && alert.Status == api.HealthPassing {
+			delete(f.states, key)
+		}
+		f.Unlock()
+		notifyHandlers(config, service, tags, alert, alert.LastAlerted)
+		return
+	}
+
+	// The alert has started flapping, so send it once and hold back the rest
+	flapping := held
+	flapping.Message = fmt.Sprintf("%s (flapping: %d status changes in %s, holding notifications until stable)",
+		alert.Message, len(state.changes), windowDuration)
+	if flapping.Status == api.HealthPassing {
+		flapping.Status = api.HealthWarning
+	}
+
+	state.flapping = true
+	state.latest = &held
+	state.alerted = flapping.Status
+	state.stable = time.AfterFunc(windowDuration, func() {
+		f.stabilize(config, service, tags, key)
+	})
+	f.Unlock()
+
+	log.Infof("Alert '%s' is flapping, holding notifications until it's stable", alert.Message)
+	notifyHandlers(config, service, tags, &flapping, alert.LastAlerted)
+}
+
+// Ends the flapping of an alert that has been stable for a whole window, sending its
+// latest state to the handlers
+func (f *flapTracker) stabilize(config *Config, service string, tags []string, key string) {
+	f.Lock()
+	state, ok := f.states[key]
+	if !ok || !state.flapping {
+		f.Unlock()
+		return
+	}
+	delete(f.states, key)
+	f.Unlock()
+
+	latest := *state.latest
+	latest.Message = latest.Message + " (no longer flapping)"
+	notifyHandlers(config, service, tags, &latest, state.alerted)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The window the status changes of an alert are counted over for flap_rate_threshold
+const flapRateWindow = time.Hour
+
+// A status change of an alert
+type flapRateChange struct {
+	time   time.Time
+	status string
+}
+
+// The status changes of an alert within the last hour, and whether it has been reported
+// as flapping since it went over the threshold
+type flapRateState struct {
+	changes  []flapRateChange
+	reported bool
+}
+
+// Tracks how often alerts change status for reporting chronic flappers, keyed by
+// datacenter/service/tag/node/check. Unlike flap detection, this doesn't hold back any
+// alerts.
+type flapRateTracker struct {
+	sync.Mutex
+	states map[string]*flapRateState
+}
+
+var flapRates = &flapRateTracker{
+	states: make(map[string]*flapRateState),
+}
+
+// Records a status change of an alert, sending a notice to the service's
+// flap_rate_handlers once it has changed status more than flap_rate_threshold times
+// within an hour. The notice isn't sent again until the rate has dropped back to the
+// threshold.
+func (f *flapRateTracker) record(config *Config, service string, tags []string, alert *AlertState) {
+	threshold, handlers := config.serviceFlapRate(service)
+	if threshold <= 0 {
+		return
+	}
+	now := time.Now()
+
+	f.Lock()
+	key := alertIncidentKey(config.ConsulDatacenter, alert) + "-" + alert.Check
+	state, ok := f.states[key]
+	if !ok {
+		state = &flapRateState{}
+		f.states[key] = state
+	}
+
+	changes := make([]flapRateChange, 0, len(state.changes)+1)
+	for _, change := range state.changes {
+		if now.Sub(change.time) < flapRateWindow {
+			changes = append(changes, change)
+		}
+	}
+	state.changes = append(changes, flapRateChange{now, alert.Status})
+
+	if len(state.changes) <= threshold {
+		state.reported = false
+		if len(state.changes) == 1 && alert.Status == api.HealthPassing {
+			delete(f.states, key)
+		}
+		f.Unlock()
+		return
+	}
+	if state.reported {
+		f.Unlock()
+		return
+	}
+	state.reported = true
+	changes = append([]flapRateChange{}, state.changes...)
+	f.Unlock()
+
+	notifyFlapRate(config, service, tags, alert, handlers, changes)
+}
+
+// Sends the notice of an alert changing status too often to the given handlers, or to
+// the handlers a warning on it would be sent to when none are given
+func notifyFlapRate(config *Config, service string, tags []string, alert *AlertState, handlers []string, changes []flapRateChange) {
+	lines := make([]string, 0, len(changes))
+	for _, change := range changes {
+		lines = append(lines, fmt.Sprintf("=> %s %s", change.time.UTC().Format(time.RFC3339), change.status))
+	}
+
+	notice := *alert
+	notice.Status = api.HealthWarning
+	notice.LastAlerted = api.HealthWarning
+	notice.Message = fmt.Sprintf("%s (changed status %d times in the last hour)", alert.Message, len(changes))
+	notice.Details = strings.Join(lines, "\n")
+	notice.Severity = config.alertSeverity(service, notice.Status)
+	annotateConsulLink(config, &notice)
+	notice.Labels = config.alertLabels(service)
+
+	now := time.Now()
+	if window := config.activeMaintenance(service, tags, now); window != nil {
+		log.Infof("Not reporting flapping alert '%s' in maintenance window %s", alert.Message, window.Name)
+		return
+	}
+	if _, ok := alertSilences.silenced(service, alert.Node, now); ok {
+		log.Infof("Not reporting flapping alert '%s' while silenced", alert.Message)
+		return
+	}
+
+	names := handlers
+	if len(names) == 0 {
+		names = config.severityHandlerNames(config.ConsulDatacenter, service, alert.Node, alert.NodeMeta, tags, api.HealthWarning, api.HealthPassing)
+	}
+	log.Infof("Alert '%s' changed status %d times in the last hour, reporting it as flapping", alert.Message, len(changes))
+	dispatchAlerts(config, names, config.ConsulDatacenter, &notice)
+	history.record(config.ConsulDatacenter, &notice, now)
+}
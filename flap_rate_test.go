@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlapRate_reportsChronicFlappers(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	lowCh := make(chan *AlertState, 10)
+	config, err := ParseConfig(`
+handler "stdout" "low" {}
+
+service "redis" {
+  flap_rate_threshold = 3
+  flap_rate_handlers = ["stdout.low"]
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConsulDatacenter = "dc1"
+	config.Handlers = map[string]AlertHandler{"test": testHandler{alertCh}, "stdout.low": testHandler{lowCh}}
+	tracker := &flapRateTracker{states: make(map[string]*flapRateState)}
+
+	statuses := []string{"critical", "passing", "critical", "passing", "critical", "passing"}
+	for _, status := range statuses {
+		tracker.record(config, "redis", nil, &AlertState{Service: "redis", Check: "redis", Status: status, Message: "redis is " + status})
+	}
+
+	// The report is sent once, on the fourth change
+	report := <-lowCh
+	if !strings.Contains(report.Message, "redis is passing (changed status 4 times in the last hour)") || report.Status != "warning" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if lines := strings.Split(report.Details, "\n"); len(lines) != 4 || !strings.HasSuffix(lines[0], " critical") {
+		t.Errorf("expected the report to list the changes, got %q", report.Details)
+	}
+	if len(lowCh) != 0 || len(alertCh) != 0 {
+		t.Errorf("expected a single report to the flap rate handlers, got %d and %d", len(lowCh), len(alertCh))
+	}
+
+	// Services without a threshold aren't tracked
+	tracker.record(config, "web", nil, &AlertState{Service: "web", Status: "critical"})
+	if len(tracker.states) != 1 {
+		t.Errorf("expected only redis to be tracked, got %d states", len(tracker.states))
+	}
+
+	if _, err := ParseConfig(`flap_rate_handlers = ["missing"]`); err == nil {
+		t.Error("expected an error for an unknown flap rate handler")
+	}
+}
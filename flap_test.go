@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlap_holdsNotifications(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	config := &Config{
+		ConsulDatacenter: "dc1",
+		FlapThreshold:    2,
+		FlapWindow:       1,
+		Handlers:         map[string]AlertHandler{"test": testHandler{alertCh}},
+		HandlerOptions:   map[string]HandlerOptions{},
+	}
+	tracker := &flapTracker{states: make(map[string]*flapState)}
+
+	statuses := []string{"critical", "passing", "critical", "passing", "critical"}
+	lastAlerted := "passing"
+	for _, status := range statuses {
+		tracker.notify(config, "redis", nil, &AlertState{Service: "redis", Status: status, LastAlerted: lastAlerted, Message: "redis is " + status})
+		lastAlerted = status
+	}
+
+	// The first two changes are sent, then the alert is sent once as flapping
+	expected := []string{"redis is critical", "redis is passing"}
+	for _, message := range expected {
+		if alert := <-alertCh; alert.Message != message {
+			t.Errorf("expected alert %q, got %q", message, alert.Message)
+		}
+	}
+	flapping := <-alertCh
+	if !strings.Contains(flapping.Message, "flapping: 3 status changes") || flapping.Status != "critical" {
+		t.Errorf("unexpected flapping alert: %+v", flapping)
+	}
+	if len(alertCh) != 0 {
+		t.Fatalf("expected the rest of the changes to be held back, got %d alerts", len(alertCh))
+	}
+
+	// Once stable for a window, the latest state is sent
+	select {
+	case alert := <-alertCh:
+		if alert.Message != "redis is critical (no longer flapping)" {
+			t.Errorf("unexpected stabilized alert: %q", alert.Message)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the alert to stabilize")
+	}
+}
+
+func TestFlap_disabled(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	config := &Config{
+		Handlers:       map[string]AlertHandler{"test": testHandler{alertCh}},
+		HandlerOptions: map[string]HandlerOptions{},
+	}
+	tracker := &flapTracker{states: make(map[string]*flapState)}
+
+	for _, status := range []string{"critical", "passing", "critical", "passing"} {
+		tracker.notify(config, "redis", nil, &AlertState{Service: "redis", Status: status})
+	}
+	if len(alertCh) != 4 {
+		t.Errorf("expected every alert to be sent, got %d", len(alertCh))
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metadata endpoint used for fetching the token of the instance's service account
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// The token endpoint used when a credentials file doesn't set one
+const gcpTokenURL = "https://oauth2.googleapis.com/token"
+
+// Tokens are refreshed this long before they expire
+const gcpTokenExpiryWindow = 5 * time.Minute
+
+// The fields used from a service account key or application default credentials file
+type gcpCredentialsFile struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Finds and reads the credentials file to use: the given file, then
+// GOOGLE_APPLICATION_CREDENTIALS, then the gcloud application default credentials.
+// Returns nil if there's none, for using the instance's service account instead.
+func loadGCPCredentials(path string) (*gcpCredentialsFile, error) {
+	explicit := path != ""
+	if path == "" {
+		path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		explicit = path != ""
+	}
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".config", "gcloud", "application_default_credentials.json")
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading credentials file: %s", err)
+	}
+
+	var creds gcpCredentialsFile
+	if err := json.Unmarshal(contents, &creds); err != nil {
+		return nil, fmt.Errorf("error decoding credentials file %s: %s", path, err)
+	}
+	switch creds.Type {
+	case "service_account":
+		if creds.ClientEmail == "" || creds.PrivateKey == "" {
+			return nil, fmt.Errorf("credentials file %s is missing client_email or private_key", path)
+		}
+	case "authorized_user":
+		if creds.RefreshToken == "" {
+			return nil, fmt.Errorf("credentials file %s is missing refresh_token", path)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported credentials type %q in %s, must be service_account or authorized_user", creds.Type, path)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = gcpTokenURL
+	}
+	return &creds, nil
+}
+
+// Fetches OAuth access tokens for Google Cloud APIs with a service account key, user
+// credentials, or the service account of the GCE/GKE instance when there's no credentials
+// file. Tokens are cached until they expire.
+type gcpTokenSource struct {
+	Credentials *gcpCredentialsFile
+	Scope       string
+	HTTPOptions HTTPOptions
+
+	lock   sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func (source *gcpTokenSource) get() (string, error) {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+
+	if source.token != "" && time.Now().Add(gcpTokenExpiryWindow).Before(source.expiry) {
+		return source.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	creds := source.Credentials
+	switch {
+	case creds == nil:
+		req, err = http.NewRequest("GET", gcpMetadataTokenURL, nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	case creds.Type == "service_account":
+		var assertion string
+		if assertion, err = source.assertion(); err != nil {
+			return "", err
+		}
+		req, err = gcpTokenRequest(creds.TokenURI, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	default:
+		req, err = gcpTokenRequest(creds.TokenURI, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	body, err := source.HTTPOptions.doRequest(req)
+	if err != nil {
+		if creds == nil {
+			return "", fmt.Errorf("no Google Cloud credentials file found, and fetching the instance's token failed: %s", err)
+		}
+		return "", fmt.Errorf("error fetching access token: %s", err)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("error decoding access token: %s", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("no access token in response: %s", body)
+	}
+
+	source.token = token.AccessToken
+	source.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return source.token, nil
+}
+
+func gcpTokenRequest(tokenURL string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// Builds the JWT signed with the service account's key that is exchanged for a token
+func (source *gcpTokenSource) assertion() (string, error) {
+	creds := source.Credentials
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("no PEM key found in the private_key of the credentials file")
+	}
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return "", fmt.Errorf("the private_key of the credentials file must be an RSA key")
+		}
+		key = rsaKey
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return "", fmt.Errorf("error parsing the private_key of the credentials file: %s", err)
+	}
+
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": source.Scope,
+		"aud":   creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
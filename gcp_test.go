@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes a service account key file using the given token endpoint
+func writeTestGCPCredentials(t *testing.T, key *rsa.PrivateKey, tokenURL string) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "alerting-prod",
+		"client_email": "alerts@alerting-prod.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    tokenURL,
+	})
+
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Serves access tokens for JWT assertions signed with the given key
+func newTestGCPTokenServer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		if r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+			t.Errorf("unexpected token request: %v", r.PostForm)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+			t.Errorf("invalid assertion signature: %s", err)
+		}
+		var claims map[string]interface{}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(payload, &claims)
+		if claims["iss"] != "alerts@alerting-prod.iam.gserviceaccount.com" || claims["scope"] != pubsubScope {
+			t.Errorf("unexpected claims: %v", claims)
+		}
+
+		w.Write([]byte(`{"access_token": "token1", "expires_in": 3600}`))
+	}))
+}
+
+func TestGCP_tokenSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestGCPTokenServer(t, key)
+	defer server.Close()
+
+	creds, err := loadGCPCredentials(writeTestGCPCredentials(t, key, server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.ProjectID != "alerting-prod" {
+		t.Errorf("expected the project from the credentials, got %s", creds.ProjectID)
+	}
+
+	source := &gcpTokenSource{Credentials: creds, Scope: pubsubScope}
+	token, err := source.get()
+	if err != nil || token != "token1" {
+		t.Fatalf("expected token1, got %s (%v)", token, err)
+	}
+
+	// The token is cached until it's about to expire
+	server.Close()
+	if token, err := source.get(); err != nil || token != "token1" {
+		t.Errorf("expected the cached token, got %s (%v)", token, err)
+	}
+}
+
+func TestGCP_loadCredentials(t *testing.T) {
+	if _, err := loadGCPCredentials(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected an error for a missing credentials file")
+	}
+
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	ioutil.WriteFile(path, []byte(`{"type": "external_account"}`), 0600)
+	if _, err := loadGCPCredentials(path); err == nil {
+		t.Error("expected an error for an unsupported credentials type")
+	}
+}
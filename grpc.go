@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// A minimal gRPC server for the management API in alerting.proto, implementing just enough
+// of the protocol for unary and server streaming calls: length-prefixed messages over
+// HTTP/2 without TLS (h2c), with the status in the trailers. Compressed messages aren't
+// supported.
+
+const grpcServicePath = "/consul_alerting.v1.Alerting/"
+
+// The largest request message accepted
+const grpcMaxMessageSize = 4 * 1024 * 1024
+
+// The gRPC status codes returned by the server
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnauthenticated    = 16
+)
+
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+func grpcErrorf(code int, format string, args ...interface{}) error {
+	return &grpcError{code, fmt.Sprintf(format, args...)}
+}
+
+// Starts the gRPC server, if an address is configured
+func startGRPCServer(config *Config, client *api.Client) {
+	if config.GRPCAddress == "" {
+		return
+	}
+
+	server := &http.Server{
+		Addr:      config.GRPCAddress,
+		Handler:   grpcHandler(config, client),
+		Protocols: new(http.Protocols),
+	}
+	server.Protocols.SetUnencryptedHTTP2(true)
+
+	log.Infof("Serving the gRPC API on %s", config.GRPCAddress)
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatal("Error running gRPC server: ", err)
+		}
+	}()
+}
+
+func grpcHandler(config *Config, client *api.Client) http.HandlerFunc {
+	methods := map[string]func([]protoField) (*protoEncoder, error){
+		"ListAlerts": func([]protoField) (*protoEncoder, error) {
+			return grpcListAlerts(latestConfig(config)), nil
+		},
+		"ListSilences": func([]protoField) (*protoEncoder, error) {
+			return grpcListSilences(latestConfig(config))
+		},
+		"SetSilence": func(request []protoField) (*protoEncoder, error) {
+			return grpcSetSilence(latestConfig(config), client, request)
+		},
+		"DeleteSilence": func(request []protoField) (*protoEncoder, error) {
+			return grpcDeleteSilence(latestConfig(config), client, request)
+		},
+		"ListHandlers": func([]protoField) (*protoEncoder, error) {
+			return grpcListHandlers(latestConfig(config)), nil
+		},
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "expected a gRPC request", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+
+		if config.APIToken != "" && !validAPIToken(config.APIToken, r) {
+			writeGRPCStatus(w, grpcErrorf(grpcUnauthenticated, "invalid or missing API token"))
+			return
+		}
+
+		request, err := readGRPCMessage(r.Body)
+		if err != nil {
+			writeGRPCStatus(w, err)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, grpcServicePath)
+		if name == "WatchAlerts" {
+			grpcWatchAlerts(w, r, request)
+			return
+		}
+
+		method, ok := methods[name]
+		if !ok || !strings.HasPrefix(r.URL.Path, grpcServicePath) {
+			writeGRPCStatus(w, grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path))
+			return
+		}
+		response, err := method(request)
+		if err == nil {
+			err = writeGRPCMessage(w, response)
+		}
+		writeGRPCStatus(w, err)
+	}
+}
+
+// Reads a unary request message, treating an empty body as an empty message
+func readGRPCMessage(body io.Reader) ([]protoField, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(body, header[:]); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "error reading request: %s", err)
+	}
+	if header[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages aren't supported")
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > grpcMaxMessageSize {
+		return nil, grpcErrorf(grpcInvalidArgument, "request of %d bytes is too large", length)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "error reading request: %s", err)
+	}
+
+	fields, err := decodeProto(message)
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%s", err)
+	}
+	return fields, nil
+}
+
+func writeGRPCMessage(w http.ResponseWriter, message *protoEncoder) error {
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[1:], uint32(len(message.buf)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(message.buf)
+	return err
+}
+
+// Sets the status of the call in the trailers
+func writeGRPCStatus(w http.ResponseWriter, err error) {
+	code := grpcOK
+	if err != nil {
+		code = grpcInternal
+		if e, ok := err.(*grpcError); ok {
+			code = e.code
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(err.Error()))
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+}
+
+func encodeAlert(datacenter string, alert *AlertState) *protoEncoder {
+	var m protoEncoder
+	m.string(1, datacenter)
+	m.string(2, alert.Service)
+	m.string(3, alert.Tag)
+	m.string(4, alert.Node)
+	m.string(5, alert.Check)
+	m.string(6, alert.Status)
+	m.string(7, alert.Message)
+	m.string(8, alert.Details)
+	m.string(9, alert.Severity)
+	m.string(10, alert.AckedBy)
+	return &m
+}
+
+func encodeSilence(service string, s silence) *protoEncoder {
+	var m protoEncoder
+	m.string(1, service)
+	m.timestamp(2, s.Expires)
+	m.string(3, s.Reason)
+	return &m
+}
+
+func grpcListAlerts(config *Config) *protoEncoder {
+	var response protoEncoder
+	for _, alert := range activeAlerts.payloads() {
+		response.message(1, encodeAlert(alert.Datacenter, alert.AlertState))
+	}
+	return &response
+}
+
+func grpcListSilences(config *Config) (*protoEncoder, error) {
+	if config.SilenceKVPrefix == "" {
+		return nil, grpcErrorf(grpcFailedPrecondition, "silences are disabled")
+	}
+
+	var response protoEncoder
+	for _, s := range dashboardState(config, time.Now()).Silences {
+		response.message(1, encodeSilence(s.Name, s.silence))
+	}
+	return &response, nil
+}
+
+func grpcSetSilence(config *Config, client *api.Client, request []protoField) (*protoEncoder, error) {
+	if config.SilenceKVPrefix == "" {
+		return nil, grpcErrorf(grpcFailedPrecondition, "silences are disabled")
+	}
+
+	service := protoString(request, 1)
+	if service == "" {
+		return nil, grpcErrorf(grpcInvalidArgument, "service must be set")
+	}
+	duration, err := protoDuration(request, 2)
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%s", err)
+	}
+	if duration <= 0 {
+		return nil, grpcErrorf(grpcInvalidArgument, "duration must be positive")
+	}
+
+	s := silence{Expires: time.Now().Add(duration).UTC(), Reason: protoString(request, 3)}
+	if err := writeSilence(client, config.SilenceKVPrefix, service, s); err != nil {
+		return nil, err
+	}
+	return encodeSilence(service, s), nil
+}
+
+func grpcDeleteSilence(config *Config, client *api.Client, request []protoField) (*protoEncoder, error) {
+	if config.SilenceKVPrefix == "" {
+		return nil, grpcErrorf(grpcFailedPrecondition, "silences are disabled")
+	}
+
+	service := protoString(request, 1)
+	if service == "" {
+		return nil, grpcErrorf(grpcInvalidArgument, "service must be set")
+	}
+	if _, err := client.KV().Delete(config.SilenceKVPrefix+service, nil); err != nil {
+		return nil, err
+	}
+	return &protoEncoder{}, nil
+}
+
+func grpcListHandlers(config *Config) *protoEncoder {
+	var response protoEncoder
+	for _, h := range dashboardState(config, time.Now()).Handlers {
+		var m protoEncoder
+		m.string(1, h.Name)
+		m.string(2, h.Type)
+		m.uint64(3, uint64(h.Delivered))
+		m.uint64(4, uint64(h.Failed))
+		m.uint64(5, uint64(h.Queued))
+		m.bool(6, h.CircuitOpen)
+		response.message(1, &m)
+	}
+	return &response
+}
+
+// Streams alert events until the client goes away
+func grpcWatchAlerts(w http.ResponseWriter, r *http.Request, request []protoField) {
+	service, node := protoString(request, 1), protoString(request, 2)
+	events := alertEvents.subscribe()
+	defer alertEvents.unsubscribe(events)
+
+	flusher, _ := w.(http.Flusher)
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case transition := <-events:
+			if (service != "" && transition.Service != service) || (node != "" && transition.Node != node) {
+				continue
+			}
+
+			var event protoEncoder
+			event.timestamp(1, transition.Time)
+			event.message(2, encodeAlert(transition.Datacenter, &AlertState{
+				Service: transition.Service,
+				Tag:     transition.Tag,
+				Node:    transition.Node,
+				Check:   transition.Check,
+				Status:  transition.Status,
+				Message: transition.Message,
+			}))
+			event.string(3, transition.PreviousStatus)
+
+			if err := writeGRPCMessage(w, &event); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// The streams following the alert transitions, each buffering a few events. A stream
+// that falls behind misses events rather than holding up alerting.
+type alertEventStreams struct {
+	sync.Mutex
+	subscribers map[chan alertTransition]bool
+}
+
+var alertEvents = &alertEventStreams{
+	subscribers: make(map[chan alertTransition]bool),
+}
+
+func (s *alertEventStreams) subscribe() chan alertTransition {
+	s.Lock()
+	defer s.Unlock()
+	events := make(chan alertTransition, 64)
+	s.subscribers[events] = true
+	return events
+}
+
+func (s *alertEventStreams) unsubscribe(events chan alertTransition) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.subscribers, events)
+}
+
+func (s *alertEventStreams) publish(transition alertTransition) {
+	s.Lock()
+	defer s.Unlock()
+	for events := range s.subscribers {
+		select {
+		case events <- transition:
+		default:
+			log.Warnf("Dropping alert event for a slow gRPC stream")
+		}
+	}
+}
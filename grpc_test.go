@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testGRPCServer(t *testing.T, config *Config) (*httptest.Server, *http.Client) {
+	server := httptest.NewUnstartedServer(grpcHandler(config, nil))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return server, &http.Client{Transport: transport}
+}
+
+func grpcCall(t *testing.T, client *http.Client, url string, method string, request *protoEncoder, token string) (*http.Response, []byte) {
+	var body bytes.Buffer
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[1:], uint32(len(request.buf)))
+	body.Write(header[:])
+	body.Write(request.buf)
+
+	req, _ := http.NewRequest("POST", url+grpcServicePath+method, &body)
+	req.Header.Set("Content-Type", "application/grpc")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+func TestGRPC_listAlerts(t *testing.T) {
+	activeAlerts.update("dc1", &AlertState{Service: "grpc-test", Status: "critical", Message: "down"})
+	defer activeAlerts.update("dc1", &AlertState{Service: "grpc-test", Status: "passing"})
+
+	server, client := testGRPCServer(t, &Config{ConsulDatacenter: "dc1", APIToken: "secret"})
+	defer server.Close()
+
+	resp, _ := grpcCall(t, client, server.URL, "ListAlerts", &protoEncoder{}, "")
+	if status := resp.Trailer.Get("Grpc-Status"); status != "16" {
+		t.Errorf("expected status 16 without a token, got %q", status)
+	}
+
+	resp, data := grpcCall(t, client, server.URL, "ListAlerts", &protoEncoder{}, "secret")
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Fatalf("expected status 0, got %q: %s", status, resp.Trailer.Get("Grpc-Message"))
+	}
+	if len(data) < 5 {
+		t.Fatalf("expected a response message, got %d bytes", len(data))
+	}
+
+	fields, err := decodeProto(data[5:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, field := range fields {
+		alert, err := decodeProto(field.bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if protoString(alert, 2) == "grpc-test" {
+			found = true
+			if protoString(alert, 1) != "dc1" || protoString(alert, 6) != "critical" || protoString(alert, 7) != "down" {
+				t.Errorf("unexpected alert: %+v", alert)
+			}
+		}
+	}
+	if !found {
+		t.Error("expected the open alert to be listed")
+	}
+
+	resp, _ = grpcCall(t, client, server.URL, "Unknown", &protoEncoder{}, "secret")
+	if status := resp.Trailer.Get("Grpc-Status"); status != "12" {
+		t.Errorf("expected status 12 for an unknown method, got %q", status)
+	}
+}
+
+func TestGRPC_watchAlerts(t *testing.T) {
+	server, client := testGRPCServer(t, &Config{})
+	defer server.Close()
+
+	var request protoEncoder
+	request.string(1, "redis")
+	var body bytes.Buffer
+	body.Write([]byte{0, 0, 0, 0, byte(len(request.buf))})
+	body.Write(request.buf)
+
+	req, _ := http.NewRequest("POST", server.URL+grpcServicePath+"WatchAlerts", &body)
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The stream has subscribed once its headers are sent
+	alertEvents.publish(alertTransition{Time: time.Now(), Service: "mysql", Status: "critical"})
+	alertEvents.publish(alertTransition{Time: time.Now(), Service: "redis", Status: "critical", PreviousStatus: "passing"})
+
+	var header [5]byte
+	if _, err := io.ReadFull(resp.Body, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	message := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(resp.Body, message); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := decodeProto(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if protoString(event, 3) != "passing" {
+		t.Errorf("expected the redis event, got %+v", event)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The KV path of the lock electing the active instance with high_availability enabled
+const activeLockPath = alertingKVRoot + "/active"
+
+// Whether this instance is the active one, when instances run as active and standby.
+// Without high_availability every instance is active.
+type instanceState struct {
+	sync.Mutex
+
+	enabled bool
+	active  bool
+
+	// Whether the instance was active when shutdown started, so it keeps sending the
+	// alerts it queued after giving up the active lock
+	draining bool
+
+	// Closed once the instance becomes active, or standby
+	activeCh  chan struct{}
+	standbyCh chan struct{}
+}
+
+var activeInstance = newInstanceState()
+
+func newInstanceState() *instanceState {
+	standbyCh := make(chan struct{})
+	close(standbyCh)
+	return &instanceState{
+		activeCh:  make(chan struct{}),
+		standbyCh: standbyCh,
+	}
+}
+
+// Starts running the instance as a standby until it acquires the active lock
+func (s *instanceState) enable() {
+	s.Lock()
+	defer s.Unlock()
+	s.enabled = true
+}
+
+// Marks the instance as active or standby
+func (s *instanceState) set(active bool) {
+	s.Lock()
+	defer s.Unlock()
+	if active == s.active {
+		return
+	}
+	s.active = active
+	if active {
+		close(s.activeCh)
+		s.standbyCh = make(chan struct{})
+	} else {
+		close(s.standbyCh)
+		s.activeCh = make(chan struct{})
+	}
+}
+
+// Returns whether the instance is active and should send alerts
+func (s *instanceState) isActive() bool {
+	s.Lock()
+	defer s.Unlock()
+	return !s.enabled || s.active || s.draining
+}
+
+// Keeps an active instance sending alerts while it shuts down, once the watches release
+// the active lock, so the alerts already queued are delivered by the drain. A standby
+// instance stays standby.
+func (s *instanceState) drain() {
+	s.Lock()
+	defer s.Unlock()
+	s.draining = s.active
+}
+
+// Returns "active" or "standby" with high_availability, or an empty string without it
+func (s *instanceState) role() string {
+	s.Lock()
+	defer s.Unlock()
+	switch {
+	case !s.enabled:
+		return ""
+	case s.active:
+		return "active"
+	}
+	return "standby"
+}
+
+// Blocks until the instance is active, returning false if stopped first
+func (s *instanceState) wait(stopCh chan struct{}) bool {
+	s.Lock()
+	if !s.enabled || s.active {
+		s.Unlock()
+		return true
+	}
+	activeCh := s.activeCh
+	s.Unlock()
+
+	select {
+	case <-activeCh:
+		return true
+	case <-stopCh:
+		return false
+	}
+}
+
+// Returns a channel closed once the instance becomes standby, or nil (which never
+// receives) without high_availability
+func (s *instanceState) standby() <-chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+	if !s.enabled {
+		return nil
+	}
+	return s.standbyCh
+}
+
+// Contends for the active lock, making the instance active while it holds it. The
+// other locks are only contended for by the active instance, so a standby instance
+// doesn't run any watches until it takes over.
+func watchActiveLock(nodeName string, config *Config, client *api.Client, stopCh chan struct{}) {
+	apiLock, err := client.LockOpts(&api.LockOptions{
+		Key:         activeLockPath,
+		Value:       []byte(nodeName),
+		SessionName: "consul-alerting active instance",
+		SessionTTL:  config.HASessionTTL,
+	})
+	if err != nil {
+		log.Fatalf("Error initializing the active instance lock: %s", err)
+	}
+
+	lock := LockHelper{
+		target:       "the active instance",
+		client:       client,
+		lock:         apiLock,
+		stopCh:       make(chan struct{}, 1),
+		lockCh:       make(chan struct{}, 1),
+		instanceLock: true,
+		callback: func() {
+			log.Info("Became the active instance, starting to alert")
+			activeInstance.set(true)
+		},
+		released: func() {
+			log.Warn("Lost the active instance lock, standing by")
+			activeInstance.set(false)
+		},
+	}
+	go lock.start()
+
+	<-stopCh
+	lock.stop()
+	activeInstance.set(false)
+	<-stopCh
+}
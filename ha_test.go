@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHA_instanceState(t *testing.T) {
+	state := newInstanceState()
+	if !state.isActive() || state.role() != "" || state.standby() != nil {
+		t.Fatalf("expected every instance to be active without high availability")
+	}
+
+	state.enable()
+	if state.isActive() || state.role() != "standby" {
+		t.Errorf("expected the instance to start as standby")
+	}
+	stopCh := make(chan struct{}, 1)
+	stopCh <- struct{}{}
+	if state.wait(stopCh) {
+		t.Errorf("expected waiting to be stopped")
+	}
+
+	waited := make(chan bool)
+	go func() { waited <- state.wait(make(chan struct{})) }()
+	state.set(true)
+	select {
+	case ok := <-waited:
+		if !ok || !state.isActive() || state.role() != "active" {
+			t.Errorf("expected the instance to be active")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the instance to become active")
+	}
+
+	standbyCh := state.standby()
+	state.set(false)
+	select {
+	case <-standbyCh:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the standby channel to be closed")
+	}
+}
+
+func TestHA_drain(t *testing.T) {
+	state := newInstanceState()
+	state.enable()
+	state.drain()
+	state.set(false)
+	if state.isActive() {
+		t.Errorf("expected a standby instance to stay standby while shutting down")
+	}
+
+	// An active instance keeps sending after giving up the active lock
+	state.set(true)
+	state.drain()
+	state.set(false)
+	if !state.isActive() {
+		t.Errorf("expected the instance to keep sending the queued alerts while shutting down")
+	}
+}
+
+func TestHA_standbyDoesntSend(t *testing.T) {
+	alertCh := make(chan *AlertState, 1)
+	handler := namedHandler{name: "test", handler: testHandler{alertCh}}
+
+	defer func(state *instanceState) { activeInstance = state }(activeInstance)
+	activeInstance = newInstanceState()
+	activeInstance.enable()
+
+	handler.send("dc1", &AlertState{Service: "redis", Status: "critical"})
+	select {
+	case alert := <-alertCh:
+		t.Fatalf("expected no alert from a standby instance, got %+v", alert)
+	default:
+	}
+
+	activeInstance.set(true)
+	handler.send("dc1", &AlertState{Service: "redis", Status: "critical"})
+	if alert := <-alertCh; alert.Service != "redis" {
+		t.Errorf("unexpected alert: %+v", alert)
+	}
+}
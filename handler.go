@@ -1,13 +1,15 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
+	htmltemplate "html/template"
+	"io"
 	"net"
-	"strconv"
+	"net/mail"
+	"os"
 	"strings"
-
-	"time"
+	"text/template"
 
 	"github.com/darkcrux/gopherduty"
 	"github.com/hashicorp/consul/api"
@@ -17,9 +19,56 @@ import (
 )
 
 // AlertHandlers are responsible for alerting to some external endpoint
-// when given an alert (email, pagerduty, etc)
+// when given an alert (email, pagerduty, etc), returning an error if it
+// couldn't be delivered
 type AlertHandler interface {
-	Alert(datacenter string, alert *AlertState)
+	Alert(datacenter string, alert *AlertState) error
+}
+
+// Implemented by handlers doing work of their own in the background, to stop it once a
+// reload replaces or removes the handler
+type stoppableHandler interface {
+	stop()
+}
+
+// The JSON representation of an alert sent by handlers that forward the whole alert
+type alertPayload struct {
+	Datacenter string `json:"datacenter"`
+	*AlertState
+}
+
+// The colors used to highlight alerts by status in handlers that support it
+var alertStatusColors = map[string]int{
+	api.HealthPassing:  0x2EB886,
+	api.HealthWarning:  0xDAA038,
+	api.HealthCritical: 0xA30200,
+}
+
+// Returns the color for an alert's status, treating unknown statuses as critical
+func alertStatusColor(status string) int {
+	if color, ok := alertStatusColors[status]; ok {
+		return color
+	}
+	return alertStatusColors[api.HealthCritical]
+}
+
+// Returns the Nagios plugin exit code of an alert's status, used by monitoring systems
+// that follow its conventions: 0 for OK, 1 for WARNING and 2 for CRITICAL
+func alertStatusCode(status string) int {
+	switch status {
+	case api.HealthPassing:
+		return 0
+	case api.HealthWarning:
+		return 1
+	}
+	return 2
+}
+
+// Returns a key for deduplicating incidents in external services. It needs to be unique
+// to the datacenter, partition, namespace and service/node we're alerting on, so that recoveries
+// resolve the right incident.
+func alertIncidentKey(datacenter string, alert *AlertState) string {
+	return datacenter + "-" + alert.scopedService() + "-" + alert.Tag + "-" + alert.scopedNode()
 }
 
 type StdoutHandler struct {
@@ -27,135 +76,754 @@ type StdoutHandler struct {
 	logger   *log.Logger
 }
 
-func (handler StdoutHandler) Alert(datacenter string, alert *AlertState) {
+func (handler StdoutHandler) Alert(datacenter string, alert *AlertState) error {
+	logger := log.NewEntry(handler.logger)
 	text := []string{alert.Message}
-	if alert.Details != "" {
+	if _, ok := handler.logger.Formatter.(*log.JSONFormatter); ok {
+		// With JSON logs, keep each alert to a single entry with its fields
+		logger = logger.WithFields(stdoutFields(datacenter, alert))
+	} else if alert.Details != "" {
 		text = append(text, strings.Split(alert.Details, "\n")...)
 	}
 	for _, line := range text {
 		switch strings.ToLower(handler.LogLevel) {
 		case "panic":
-			handler.logger.Panic(line)
+			logger.Panic(line)
 		case "fatal":
-			handler.logger.Fatal(line)
+			logger.Fatal(line)
 		case "error":
-			handler.logger.Error(line)
+			logger.Error(line)
 		case "warn", "warning":
-			handler.logger.Warn(line)
+			logger.Warn(line)
 		case "info":
-			handler.logger.Info(line)
+			logger.Info(line)
 		case "debug":
-			handler.logger.Debug(line)
+			logger.Debug(line)
 		}
 	}
+	return nil
+}
+
+func stdoutFields(datacenter string, alert *AlertState) log.Fields {
+	fields := log.Fields{"datacenter": datacenter, "status": alert.Status}
+	for name, value := range map[string]string{
+		"service":   alert.Service,
+		"namespace": alert.Namespace,
+		"partition": alert.Partition,
+		"tag":       alert.Tag,
+		"node":      alert.Node,
+		"check":     alert.Check,
+		"details":   alert.Details,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	return fields
 }
 
 type EmailHandler struct {
-	Recipients []string `mapstructure:"recipients"`
-	MaxRetries int      `mapstructure:"max_retries"`
+	Recipients   []string `mapstructure:"recipients"`
+	MaxRetries   int      `mapstructure:"max_retries"`
+	SendMode     string   `mapstructure:"send_mode"`
+	Transport    string   `mapstructure:"transport"`
+	FromAddress  string   `mapstructure:"from_address"`
+	HTML         bool     `mapstructure:"html"`
+	HTMLTemplate string   `mapstructure:"html_template"`
+
+	// Templates replacing the alert's message and details as the subject and body
+	SubjectTemplate string `mapstructure:"subject_template"`
+	BodyTemplate    string `mapstructure:"body_template"`
+
+	// If set, the alerts arriving within this many seconds of the first one are sent as
+	// a single digest email
+	DigestWindow int `mapstructure:"digest_window"`
+
+	// Rules sending alerts to other recipients by service or tag
+	RecipientRoutes []EmailRecipientRoute `mapstructure:"recipient_routes"`
+
+	// DKIM signing settings, for emails sent without an authenticated relay
+	DKIMDomain   string `mapstructure:"dkim_domain"`
+	DKIMSelector string `mapstructure:"dkim_selector"`
+	DKIMKeyFile  string `mapstructure:"dkim_key_file"`
+
+	// SMTP relay settings, used by the smtp and ses_smtp transports
+	SMTPHost          string `mapstructure:"smtp_host"`
+	SMTPPort          int    `mapstructure:"smtp_port"`
+	SMTPUsername      string `mapstructure:"smtp_username"`
+	SMTPPassword      string `mapstructure:"smtp_password"`
+	SMTPTLS           string `mapstructure:"smtp_tls"`
+	SMTPTLSSkipVerify bool   `mapstructure:"smtp_tls_skip_verify"`
+
+	// Amazon SES settings, used by the ses and ses_smtp transports
+	Region          string `mapstructure:"region"`
+	SESEndpoint     string `mapstructure:"ses_endpoint"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	Profile         string `mapstructure:"profile"`
+	HTTPOptions     `mapstructure:",squash"`
+
+	credentials     *awsCredentialChain
+	htmlTemplate    *htmltemplate.Template
+	subjectTemplate *template.Template
+	bodyTemplate    *template.Template
+	digest          *emailDigest
+	dkim            *dkimSigner
+}
+
+// The ways an EmailHandler can address an alert to its recipients
+const EmailSendIndividual = "individual"
+const EmailSendTo = "to"
+const EmailSendBcc = "bcc"
+
+// The ways an EmailHandler can deliver its emails: directly to each recipient domain's
+// mail server, through an SMTP relay, through the SES api, or through the SES SMTP interface
+const EmailTransportMX = "mx"
+const EmailTransportSMTP = "smtp"
+const EmailTransportSES = "ses"
+const EmailTransportSESSMTP = "ses_smtp"
+
+// How an EmailHandler secures its connection to an SMTP relay: upgrading with STARTTLS
+// when the server offers it, or connecting over TLS from the start
+const EmailTLSStartTLS = "starttls"
+const EmailTLSImplicit = "implicit"
+
+const emailFromAddress = "consul-alerting@noreply.com"
+
+func (handler EmailHandler) Alert(datacenter string, alert *AlertState) error {
+	if handler.digest != nil {
+		handler.digest.add(handler, datacenter, alert)
+		return nil
+	}
+
+	return handler.deliver(handler.alertRecipients(alert), alert, func() *gomail.Message {
+		return handler.newMessage(datacenter, alert)
+	})
 }
 
-func (handler EmailHandler) Alert(datacenter string, alert *AlertState) {
-	for _, recipient := range handler.Recipients {
-		// Get the mail server to use for this recipient
-		records, err := net.LookupMX(strings.Split(recipient, "@")[1])
+// Sends the emails composed for an alert to the recipients with the configured transport
+func (handler EmailHandler) deliver(recipients []string, alert *AlertState, compose func() *gomail.Message) error {
+	switch handler.Transport {
+	case EmailTransportSES:
+		return handler.sendAll(alert, recipients, "SES", compose, handler.sendSES)
+	case EmailTransportSMTP, EmailTransportSESSMTP:
+		return handler.sendAll(alert, recipients, handler.SMTPHost, compose, dialAndSend(handler.relayDialer()))
+	}
+
+	// Group the recipients by domain, since each domain has its own mail server
+	domains := make(map[string][]string)
+	domainNames := make([]string, 0)
+	for _, recipient := range recipients {
+		domain := recipient[strings.LastIndex(recipient, "@")+1:]
+		if _, ok := domains[domain]; !ok {
+			domainNames = append(domainNames, domain)
+		}
+		domains[domain] = append(domains[domain], recipient)
+	}
+
+	var failed error
+	for _, domain := range domainNames {
+		// Get the mail server to use for this domain
+		records, err := net.LookupMX(domain)
 		if err != nil {
 			log.Error("Error looking up email server: ", err)
+			failed = err
 			continue
 		}
 
-		m := gomail.NewMessage()
-		m.SetAddressHeader("From", "consul-alerting@noreply.com", "Consul Alerting")
-		m.SetAddressHeader("To", recipient, "")
+		d := gomail.NewDialer(records[0].Host, 25, "", "")
+		err = handler.sendAll(alert, domains[domain], records[0].Host, compose, dialAndSend(d))
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+func (handler *EmailHandler) validate() error {
+	// Only the address of a recipient like "Ops <ops@example.com>" is kept, which is what
+	// the emails are sent to and the mail servers are looked up by
+	for i, recipient := range handler.Recipients {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("Invalid address in recipients[%d] %q: %s", i, recipient, err)
+		}
+		handler.Recipients[i] = address.Address
+	}
+	if handler.FromAddress != "" {
+		if _, err := mail.ParseAddress(handler.FromAddress); err != nil {
+			return fmt.Errorf("Invalid from_address %q: %s", handler.FromAddress, err)
+		}
+	}
+	if !contains([]string{EmailSendIndividual, EmailSendTo, EmailSendBcc}, handler.SendMode) {
+		return fmt.Errorf("Invalid value for send_mode: %s", handler.SendMode)
+	}
+	if !contains([]string{EmailTransportMX, EmailTransportSMTP, EmailTransportSES, EmailTransportSESSMTP}, handler.Transport) {
+		return fmt.Errorf("Invalid value for transport: %s", handler.Transport)
+	}
+	for i := range handler.RecipientRoutes {
+		if err := handler.RecipientRoutes[i].validate(); err != nil {
+			return fmt.Errorf("recipient_routes[%d]: %s", i, err)
+		}
+	}
+	if handler.DigestWindow < 0 {
+		return fmt.Errorf("digest_window can't be negative")
+	}
+	if handler.DigestWindow > 0 {
+		handler.digest = newEmailDigest(handler.DigestWindow)
+	}
+	var err error
+	if handler.SubjectTemplate != "" {
+		if handler.subjectTemplate, err = compileAlertTemplate("subject_template", handler.SubjectTemplate); err != nil {
+			return err
+		}
+	}
+	if handler.BodyTemplate != "" {
+		if handler.bodyTemplate, err = compileAlertTemplate("body_template", handler.BodyTemplate); err != nil {
+			return err
+		}
+	}
+	if handler.HTML || handler.HTMLTemplate != "" {
+		tmpl, err := loadEmailHTMLTemplate(handler.HTMLTemplate)
+		if err != nil {
+			return err
+		}
+		handler.htmlTemplate = tmpl
+	}
+	if (handler.DKIMSelector == "") != (handler.DKIMKeyFile == "") {
+		return fmt.Errorf("dkim_selector and dkim_key_file must be set together")
+	}
+	if handler.DKIMKeyFile != "" {
+		domain := handler.DKIMDomain
+		if address, err := mail.ParseAddress(handler.from()); err == nil && domain == "" {
+			domain = address.Address[strings.LastIndex(address.Address, "@")+1:]
+		}
+		if handler.dkim, err = newDKIMSigner(domain, handler.DKIMSelector, handler.DKIMKeyFile); err != nil {
+			return err
+		}
+	}
+	if handler.Transport == EmailTransportMX {
+		return nil
+	}
+	if handler.Transport == EmailTransportSMTP {
+		if handler.SMTPHost == "" {
+			return fmt.Errorf("smtp_host must be set when using the smtp transport")
+		}
+		return handler.validateRelay()
+	}
+
+	if handler.Region == "" {
+		handler.Region = os.Getenv("AWS_REGION")
+	}
+	if handler.Region == "" {
+		handler.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if handler.Region == "" {
+		return fmt.Errorf("region must be set when using the %s transport", handler.Transport)
+	}
+
+	if handler.Transport == EmailTransportSESSMTP {
+		if handler.SMTPUsername == "" || handler.SMTPPassword == "" {
+			return fmt.Errorf("smtp_username and smtp_password must be set when using the ses_smtp transport")
+		}
+		handler.SMTPHost = fmt.Sprintf("email-smtp.%s.amazonaws.com", handler.Region)
+		return handler.validateRelay()
+	}
+
+	if handler.SESEndpoint == "" {
+		handler.SESEndpoint = fmt.Sprintf("https://email.%s.amazonaws.com/", handler.Region)
+	}
+	if (handler.AccessKeyID == "") != (handler.SecretAccessKey == "") {
+		return fmt.Errorf("access_key_id and secret_access_key must be set together")
+	}
+	handler.credentials = &awsCredentialChain{
+		AccessKeyID:     handler.AccessKeyID,
+		SecretAccessKey: handler.SecretAccessKey,
+		Profile:         handler.Profile,
+	}
+	return nil
+}
+
+// Checks the TLS and authentication settings for an SMTP relay and fills in the default port
+func (handler *EmailHandler) validateRelay() error {
+	if !contains([]string{EmailTLSStartTLS, EmailTLSImplicit}, handler.SMTPTLS) {
+		return fmt.Errorf("Invalid value for smtp_tls: %s", handler.SMTPTLS)
+	}
+	if handler.SMTPPassword != "" && handler.SMTPUsername == "" {
+		return fmt.Errorf("smtp_password requires smtp_username to be set")
+	}
+
+	if handler.SMTPPort == 0 {
+		handler.SMTPPort = 587
+		if handler.SMTPTLS == EmailTLSImplicit {
+			handler.SMTPPort = 465
+		}
+	}
+	return nil
+}
+
+// Creates the dialer for the configured SMTP relay
+func (handler EmailHandler) relayDialer() *gomail.Dialer {
+	d := gomail.NewDialer(handler.SMTPHost, handler.SMTPPort, handler.SMTPUsername, handler.SMTPPassword)
+	d.SSL = handler.SMTPTLS == EmailTLSImplicit
+	if handler.SMTPTLSSkipVerify {
+		d.TLSConfig = &tls.Config{ServerName: handler.SMTPHost, InsecureSkipVerify: true}
+	}
+	return d
+}
 
-		m.SetHeader("Subject", alert.Message)
-		m.SetBody("text/plain", alert.Details)
+// Returns a sender delivering each email over a new connection to the given server
+func dialAndSend(d *gomail.Dialer) gomail.SendFunc {
+	return func(from string, to []string, msg io.WriterTo) error {
+		s, err := d.Dial()
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		return s.Send(from, to, msg)
+	}
+}
 
-		d := gomail.NewPlainDialer(records[0].Host, 25, "", "")
+// Addresses the composed email to the recipients according to the send mode and sends
+// each resulting email, signed if DKIM is set up, retrying on failure. Returns the last
+// error if any email wasn't sent.
+func (handler EmailHandler) sendAll(alert *AlertState, recipients []string, server string, compose func() *gomail.Message, send gomail.SendFunc) error {
+	var messages []*gomail.Message
+	var destinations [][]string
+	switch handler.SendMode {
+	case EmailSendTo:
+		m := compose()
+		m.SetHeader("To", recipients...)
+		messages = append(messages, m)
+		destinations = append(destinations, recipients)
+	case EmailSendBcc:
+		m := compose()
+		m.SetHeader("To", handler.from())
+		m.SetHeader("Bcc", recipients...)
+		messages = append(messages, m)
+		destinations = append(destinations, append([]string{handler.from()}, recipients...))
+	default:
+		for _, recipient := range recipients {
+			m := compose()
+			m.SetAddressHeader("To", recipient, "")
+			messages = append(messages, m)
+			destinations = append(destinations, []string{recipient})
+		}
+	}
 
-		tries := 0
-		for tries <= handler.MaxRetries {
-			if err := d.DialAndSend(m); err != nil {
-				log.Error("Error sending alert email: ", err)
-				log.Error("Retrying email in 5s...")
-				time.Sleep(5 * time.Second)
-				tries++
-			} else {
-				break
+	var failed error
+	for i, m := range messages {
+		var msg io.WriterTo = m
+		if handler.dkim != nil {
+			signed, err := handler.dkim.sign(m)
+			if err != nil {
+				log.Errorf("Error signing email with DKIM: %s", err)
+				failed = err
+				continue
 			}
+			msg = signed
+		}
+
+		to := destinations[i]
+		err := retryAlert(alert, handler.MaxRetries, "email server "+server, func() error {
+			return send(handler.from(), to, msg)
+		})
+		if err != nil {
+			failed = err
 		}
 	}
+	return failed
+}
+
+// The address alert emails are sent from
+func (handler EmailHandler) from() string {
+	if handler.FromAddress != "" {
+		return handler.FromAddress
+	}
+	return emailFromAddress
+}
+
+// Creates the email for an alert, without any recipients set. If HTML emails are
+// enabled the rendered template is added as an alternative to the plain text body.
+func (handler EmailHandler) newMessage(datacenter string, alert *AlertState) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetAddressHeader("From", handler.from(), "Consul Alerting")
+
+	// Subjects are kept to a single line, whatever the template renders
+	subject := renderAlertTemplateOr(handler.subjectTemplate, datacenter, alert, alert.Message)
+	m.SetHeader("Subject", strings.Join(strings.Fields(subject), " "))
+	body := alert.Details
+	if alert.ConsulURL != "" {
+		body = strings.TrimSpace(body + "\n\nView in Consul: " + alert.ConsulURL)
+	}
+	m.SetBody("text/plain", renderAlertTemplateOr(handler.bodyTemplate, datacenter, alert, body))
+
+	if handler.htmlTemplate != nil {
+		body, err := renderEmailHTML(handler.htmlTemplate, datacenter, alert)
+		if err != nil {
+			log.Errorf("Error rendering html email, sending plain text only: %s", err)
+		} else {
+			m.AddAlternative("text/html", body)
+		}
+	}
+
+	return m
+}
+
+// Removes duplicate addresses from a list of recipients, comparing their lowercased
+// addresses so that "Ops <ops@example.com>" and "OPS@example.com" are the same recipient,
+// and drops any malformed addresses
+func dedupeRecipients(recipients []string) []string {
+	seen := make(map[string]bool)
+	deduped := make([]string, 0, len(recipients))
+
+	for _, recipient := range recipients {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			log.Errorf("Skipping invalid email recipient %s: %s", recipient, err)
+			continue
+		}
+		if key := strings.ToLower(address.Address); !seen[key] {
+			seen[key] = true
+			deduped = append(deduped, address.Address)
+		}
+	}
+
+	return deduped
 }
 
 type PagerdutyHandler struct {
 	ServiceKey string `mapstructure:"service_key"`
 	MaxRetries int    `mapstructure:"max_retries"`
+
+	// A template replacing the alert's message as the incident description
+	DescriptionTemplate string `mapstructure:"description_template"`
+
+	// Sends events to the Events API v2 at EventsURL with RoutingKey instead of using the
+	// deprecated v1 API with ServiceKey. Component and Group are templates, and the
+	// component defaults to the service.
+	RoutingKey string          `mapstructure:"routing_key"`
+	EventsURL  string          `mapstructure:"events_url"`
+	Component  string          `mapstructure:"component"`
+	Group      string          `mapstructure:"group"`
+	EventClass string          `mapstructure:"event_class"`
+	Links      []PagerdutyLink `mapstructure:"links"`
+
+	// Keys sending the alerts of some services to other integrations
+	Keys []PagerdutyKey `mapstructure:"keys"`
+
+	// The PagerDuty severity of v2 events for each status, overriding the alert's severity
+	Severities map[string]string `mapstructure:"severities"`
+
+	// The secret PagerDuty signs webhooks to /v1/pagerduty/webhook with, which sync
+	// acknowledgements of incidents back to their alerts
+	WebhookSecret string `mapstructure:"webhook_secret"`
+
+	// A template for the incident key (the dedup key of v2 events), replacing the default
+	// datacenter-service-tag-node key
+	IncidentKey string `mapstructure:"incident_key"`
+
+	descriptionTemplate *template.Template
+	incidentKeyTemplate *template.Template
+	componentTemplate   *template.Template
+	groupTemplate       *template.Template
+	linkTemplates       [][2]*template.Template
 }
 
-func (handler PagerdutyHandler) Alert(datacenter string, alert *AlertState) {
-	client := gopherduty.NewClient(handler.ServiceKey)
-	client.MaxRetry = handler.MaxRetries
+func (handler *PagerdutyHandler) validate() error {
+	if handler.ServiceKey == "" && handler.RoutingKey == "" {
+		return fmt.Errorf("service_key or routing_key must be set")
+	}
+	for i := range handler.Keys {
+		if err := handler.Keys[i].validate(); err != nil {
+			return fmt.Errorf("keys[%d]: %s", i, err)
+		}
+	}
+	if err := validatePagerdutySeverities(handler.Severities); err != nil {
+		return err
+	}
+	if err := handler.compileEventTemplates(); err != nil {
+		return err
+	}
+
+	if handler.DescriptionTemplate == "" {
+		return nil
+	}
+
+	var err error
+	handler.descriptionTemplate, err = compileAlertTemplate("description_template", handler.DescriptionTemplate)
+	return err
+}
+
+func (handler PagerdutyHandler) Alert(datacenter string, alert *AlertState) error {
+	description := renderAlertTemplateOr(handler.descriptionTemplate, datacenter, alert, alert.Message)
+	incidentKey := handler.incidentKey(datacenter, alert)
+	if handler.WebhookSecret != "" {
+		pagerdutyIncidents.track(incidentKey, datacenter, alert)
+	}
+	if handler.RoutingKey != "" {
+		return handler.sendEvent(datacenter, alert, description)
+	}
 
-	// This key needs to be unique to the datacenter and service/node we're alerting on
-	incidentKey := datacenter + "-" + alert.Service + "-" + alert.Tag + "-" + alert.Node
+	client := gopherduty.NewClient(handler.alertKey(alert))
+	client.MaxRetry = handler.MaxRetries
 
 	var resp *gopherduty.PagerDutyResponse
 	if alert.Status != api.HealthPassing {
-		resp = client.Trigger(incidentKey, alert.Message, "", "", alert.Details)
+		resp = client.Trigger(incidentKey, description, "", alert.ConsulURL, alert.Details)
 	} else {
-		resp = client.Resolve(incidentKey, alert.Message, alert.Details)
+		resp = client.Resolve(incidentKey, description, alert.Details)
 	}
 
 	for _, err := range resp.Errors {
 		log.Errorf("Error sending alert to PagerDuty: %v (details: %v, message: %v)", err, alert.Details, alert.Message)
 	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("%v", resp.Errors[len(resp.Errors)-1])
+	}
+	return nil
 }
 
 type SlackHandler struct {
-	Token       string `mapstructure:"api_token"`
-	ChannelName string `mapstructure:"channel_name"`
-	MaxRetries  int    `mapstructure:"max_retries"`
+	Token       string       `mapstructure:"api_token"`
+	ChannelName string       `mapstructure:"channel_name"`
+	MaxRetries  int          `mapstructure:"max_retries"`
+	Fields      []SlackField `mapstructure:"fields"`
+	HTTPOptions `mapstructure:",squash"`
+
+	// Details longer than SnippetThreshold are uploaded as a snippet using BotToken
+	// (which needs the files:write scope), or truncated if it isn't set
+	BotToken         string `mapstructure:"bot_token"`
+	SnippetThreshold int    `mapstructure:"snippet_threshold"`
+
+	// A template replacing the default message text of the attachment
+	TextTemplate string `mapstructure:"text_template"`
+
+	// Adds Acknowledge and Silence buttons to failing alerts. Slack sends the clicks to
+	// /v1/slack/actions, signed with SigningSecret.
+	Interactive     bool   `mapstructure:"interactive"`
+	SigningSecret   string `mapstructure:"signing_secret"`
+	SilenceDuration int    `mapstructure:"silence_duration"`
+
+	// Replies to the message of a failing alert in its thread for later updates and the
+	// recovery. Needs posting through the Web API at APIURL with BotToken.
+	ThreadUpdates bool   `mapstructure:"thread_updates"`
+	APIURL        string `mapstructure:"api_url"`
+	threads       *slackThreadRegistry
+
+	// Rules sending alerts to other channels by service, tag or severity
+	Channels []SlackChannelRoute `mapstructure:"channels"`
+
+	// The parsed title/value templates for each entry in Fields
+	fieldTemplates [][2]*template.Template
+	textTemplate   *template.Template
 }
 
-const slackMessageFormat = `
-*%s*
-%s
-`
+// A field to add to the Slack attachment, with its title and value given as
+// templates over the AlertState (e.g. "{{.Service}}")
+type SlackField struct {
+	Title string `mapstructure:"title"`
+	Value string `mapstructure:"value"`
+	Short bool   `mapstructure:"short"`
+}
 
-func (handler SlackHandler) Alert(datacenter string, alert *AlertState) {
-	message := fmt.Sprintf(slackMessageFormat, alert.Message, alert.Details)
-	tries := 0
+func (handler *SlackHandler) validate() error {
+	if handler.Interactive {
+		if handler.SigningSecret == "" {
+			return fmt.Errorf("signing_secret must be set for interactive messages")
+		}
+		if handler.SilenceDuration <= 0 {
+			handler.SilenceDuration = 3600
+		}
+	}
 
-	for tries <= handler.MaxRetries {
-		attachment := slack.Attachment{
-			Color:         "good",
-			Fallback:      "",
-			AuthorName:    "https://github.com/kyhavlov/consul-alerting",
-			AuthorSubname: "github.com",
-			AuthorLink:    "https://github.com/kyhavlov",
-			AuthorIcon:    "https://avatars2.githubusercontent.com/u/4177697?s=400&v=4",
-			Text:          message,
-			Footer:        "consul-alerting",
-			FooterIcon:    "https://platform.slack-edge.com/img/default_application_icon.png",
-			Ts:            json.Number(strconv.FormatInt(time.Now().Unix(), 10)),
+	if handler.Token == "" && handler.BotToken == "" {
+		return fmt.Errorf("api_token or bot_token must be set")
+	}
+	if handler.webAPI() && handler.ChannelName == "" {
+		return fmt.Errorf("channel_name must be set when posting with bot_token")
+	}
+	if handler.ThreadUpdates && handler.BotToken == "" {
+		return fmt.Errorf("bot_token must be set for thread updates")
+	}
+	for i := range handler.Channels {
+		if err := handler.Channels[i].validate(); err != nil {
+			return fmt.Errorf("channels[%d]: %s", i, err)
 		}
+	}
+	if handler.ThreadUpdates || len(handler.Channels) > 0 {
+		handler.threads = &slackThreadRegistry{threads: make(map[string]slackThread)}
+	}
 
-		msg := slack.WebhookMessage{
-			Attachments: []slack.Attachment{attachment},
+	if handler.TextTemplate != "" {
+		tmpl, err := compileAlertTemplate("text_template", handler.TextTemplate)
+		if err != nil {
+			return err
 		}
-		err := slack.PostWebhook(handler.Token, &msg)
+		handler.textTemplate = tmpl
+	}
+	return handler.compileFields()
+}
+
+// Returns whether alerts are posted with chat.postMessage using the bot token rather than
+// to the incoming webhook, which is the case without a webhook or for thread updates
+func (handler SlackHandler) webAPI() bool {
+	return handler.BotToken != "" && (handler.Token == "" || handler.ThreadUpdates)
+}
+
+// Parses the field templates, returning an error if any of them are invalid
+func (handler *SlackHandler) compileFields() error {
+	templates, err := compileSlackFields(handler.Fields)
+	handler.fieldTemplates = templates
+	return err
+}
+
+// Renders the configured fields for an alert into Slack attachment fields
+func (handler SlackHandler) renderFields(datacenter string, alert *AlertState) []slack.AttachmentField {
+	return renderSlackFields(handler.Fields, handler.fieldTemplates, datacenter, alert)
+}
+
+// Parses the title/value templates of each field, for handlers using Slack-style attachments
+func compileSlackFields(fields []SlackField) ([][2]*template.Template, error) {
+	var templates [][2]*template.Template
+
+	for i, field := range fields {
+		title, err := compileAlertTemplate(fmt.Sprintf("fields[%d].title", i), field.Title)
 		if err != nil {
-			fmt.Println(err)
+			return nil, err
 		}
 
+		value, err := compileAlertTemplate(fmt.Sprintf("fields[%d].value", i), field.Value)
 		if err != nil {
-			log.Errorf("Error sending alert to Slack (channel: %s): %s", handler.ChannelName, err)
-			log.Errorf("Retrying alert to slack in 5s...")
-			time.Sleep(5 * time.Second)
-		} else {
-			break
+			return nil, err
+		}
+
+		templates = append(templates, [2]*template.Template{title, value})
+	}
+
+	return templates, nil
+}
+
+// Renders compiled field templates for an alert into attachment fields
+func renderSlackFields(fields []SlackField, templates [][2]*template.Template, datacenter string, alert *AlertState) []slack.AttachmentField {
+	rendered := make([]slack.AttachmentField, 0, len(templates))
+
+	for i, tmpls := range templates {
+		title, err := renderAlertTemplate(tmpls[0], datacenter, alert)
+		if err != nil {
+			log.Error("Error rendering attachment field: ", err)
+			continue
 		}
 
-		tries++
+		value, err := renderAlertTemplate(tmpls[1], datacenter, alert)
+		if err != nil {
+			log.Error("Error rendering attachment field: ", err)
+			continue
+		}
+
+		rendered = append(rendered, slack.AttachmentField{
+			Title: title,
+			Value: value,
+			Short: fields[i].Short,
+		})
 	}
+
+	return rendered
+}
+
+const slackMessageFormat = `
+*%s*
+%s
+`
+
+func (handler SlackHandler) Alert(datacenter string, alert *AlertState) error {
+	key := alertIncidentKey(datacenter, alert) + "-" + alert.Check
+	thread, known := handler.threads.get(key)
+	if !known {
+		thread.Channel = handler.routeChannel(alert)
+	}
+
+	details := alert.Details
+	if handler.SnippetThreshold > 0 && len(details) > handler.SnippetThreshold {
+		details = handler.uploadSnippet(thread.Channel, alert)
+	}
+	attachment := handler.attachment(datacenter, alert, details)
+
+	send := func() error {
+		msg := slackBlockMessage{Channel: thread.Channel, Attachments: []slackBlockAttachment{attachment}}
+		_, err := handler.sendJSON("POST", handler.Token, nil, msg)
+		return err
+	}
+	if handler.webAPI() {
+		send = func() error {
+			channel := thread.Channel
+			if channel == "" {
+				channel = handler.ChannelName
+			}
+			ts, err := handler.postMessage(slackPostMessage{
+				Channel:     channel,
+				Text:        alert.Message,
+				ThreadTS:    thread.TS,
+				Attachments: []slackBlockAttachment{attachment},
+			})
+			if !known {
+				thread.TS = ts
+			}
+			return err
+		}
+	}
+
+	if err := retryAlert(alert, handler.MaxRetries, fmt.Sprintf("Slack (channel: %s)", handler.ChannelName), send); err != nil {
+		return err
+	}
+
+	// Recoveries close the thread, and the first message of a failing alert opens it
+	if alert.Status == api.HealthPassing {
+		handler.threads.remove(key)
+	} else if !known {
+		handler.threads.set(key, thread)
+	}
+	return nil
+}
+
+// Uploads the alert details as a snippet to the channel (channel_name if empty) and returns
+// a truncated version of them linking to it, falling back to only truncating if the upload
+// isn't possible
+func (handler SlackHandler) uploadSnippet(channel string, alert *AlertState) string {
+	truncated := truncateDetails(alert.Details, handler.SnippetThreshold)
+	if channel == "" {
+		channel = handler.ChannelName
+	}
+
+	if handler.BotToken == "" || channel == "" {
+		return truncated + "\n(output truncated)"
+	}
+
+	file, err := slack.New(handler.BotToken).UploadFile(slack.FileUploadParameters{
+		Content:  alert.Details,
+		Filetype: "text",
+		Filename: "details.txt",
+		Title:    alert.Message,
+		Channels: []string{channel},
+	})
+	if err != nil {
+		log.Errorf("Error uploading alert details to Slack (channel: %s): %s", channel, err)
+		return truncated + "\n(output truncated)"
+	}
+
+	return fmt.Sprintf("%s\n(output truncated, full output: %s)", truncated, file.Permalink)
+}
+
+// Cuts details down to at most limit bytes, preferring to end on a line break
+func truncateDetails(details string, limit int) string {
+	if len(details) <= limit {
+		return details
+	}
+
+	truncated := details[:limit]
+	if i := strings.LastIndex(truncated, "\n"); i > 0 {
+		truncated = truncated[:i]
+	}
+
+	return truncated
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// AlertmanagerHandler forwards alerts to one or more Prometheus Alertmanagers. Firing
+// alerts are re-sent every resend_interval, since Alertmanager resolves alerts on its
+// own once they stop being sent.
+type AlertmanagerHandler struct {
+	URLs           []string          `mapstructure:"urls"`
+	Labels         map[string]string `mapstructure:"labels"`
+	GeneratorURL   string            `mapstructure:"generator_url"`
+	ResendInterval int               `mapstructure:"resend_interval"`
+	MaxRetries     int               `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	firing *alertmanagerAlerts
+}
+
+// An alert in the format of Alertmanager's /api/v2/alerts endpoint
+type alertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       *time.Time        `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// The alerts currently firing, keyed by datacenter/service/tag/node
+type alertmanagerAlerts struct {
+	sync.Mutex
+	alerts map[string]alertmanagerAlert
+	start  sync.Once
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func (handler *AlertmanagerHandler) validate() error {
+	if len(handler.URLs) == 0 {
+		return fmt.Errorf("at least one url must be set")
+	}
+	if handler.ResendInterval <= 0 {
+		return fmt.Errorf("resend_interval must be positive")
+	}
+
+	for i, url := range handler.URLs {
+		handler.URLs[i] = strings.TrimSuffix(url, "/")
+	}
+	handler.firing = &alertmanagerAlerts{
+		alerts: make(map[string]alertmanagerAlert),
+		stopCh: make(chan struct{}),
+	}
+	return nil
+}
+
+// Stops re-sending the firing alerts, once the handler has been replaced by a reload
+func (handler AlertmanagerHandler) stop() {
+	handler.firing.stopOnce.Do(func() { close(handler.firing.stopCh) })
+}
+
+func (handler AlertmanagerHandler) Alert(datacenter string, alert *AlertState) error {
+	handler.firing.start.Do(func() { go handler.resend() })
+
+	now := time.Now().UTC()
+	current := handler.newAlert(datacenter, alert, now)
+	key := alertIncidentKey(datacenter, alert)
+	send := make([]alertmanagerAlert, 0, 2)
+
+	handler.firing.Lock()
+	previous, wasFiring := handler.firing.alerts[key]
+	if alert.Status == api.HealthPassing {
+		delete(handler.firing.alerts, key)
+		resolved := current
+		if wasFiring {
+			resolved = previous
+		}
+		resolved.EndsAt = &now
+		send = append(send, resolved)
+	} else {
+		if wasFiring && previous.Labels["severity"] == current.Labels["severity"] {
+			current.StartsAt = previous.StartsAt
+		} else if wasFiring {
+			// The status is one of the labels, so a warning turning critical (or back) is
+			// a different alert to Alertmanager and the previous one needs resolving
+			previous.EndsAt = &now
+			send = append(send, previous)
+		}
+		handler.firing.alerts[key] = current
+		send = append(send, current)
+	}
+	handler.firing.Unlock()
+
+	return handler.post(alert, send)
+}
+
+// Builds the Alertmanager alert for an alert state
+func (handler AlertmanagerHandler) newAlert(datacenter string, alert *AlertState, now time.Time) alertmanagerAlert {
+	labels := map[string]string{
+		"alertname":  "ConsulHealthCheck",
+		"datacenter": datacenter,
+	}
+	for name, value := range alert.Labels {
+		labels[name] = value
+	}
+	for name, value := range handler.Labels {
+		labels[name] = value
+	}
+	for _, label := range [][2]string{
+		{"service", alert.Service},
+		{"tag", alert.Tag},
+		{"node", alert.Node},
+		{"check", alert.Check},
+	} {
+		if label[1] != "" {
+			labels[label[0]] = label[1]
+		}
+	}
+
+	// Recoveries are sent with the labels of the alert they resolve, in case it isn't
+	// tracked any more (e.g. after a restart)
+	labels["severity"] = alert.Status
+	if alert.Status == api.HealthPassing {
+		labels["severity"] = alert.LastAlerted
+	}
+
+	return alertmanagerAlert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary":     alert.Message,
+			"description": alert.Details,
+		},
+		StartsAt:     now,
+		GeneratorURL: handler.GeneratorURL,
+	}
+}
+
+// Sends alerts to every Alertmanager, on behalf of the alert being sent if there is one
+func (handler AlertmanagerHandler) post(alert *AlertState, alerts []alertmanagerAlert) error {
+	var failed error
+	for _, url := range handler.URLs {
+		err := retryAlert(alert, handler.MaxRetries, "Alertmanager "+url, func() error {
+			_, err := handler.sendJSON("POST", url+"/api/v2/alerts", nil, alerts)
+			return err
+		})
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// Periodically re-sends the firing alerts so Alertmanager doesn't resolve them
+func (handler AlertmanagerHandler) resend() {
+	for {
+		select {
+		case <-time.After(time.Duration(handler.ResendInterval) * time.Second):
+		case <-handler.firing.stopCh:
+			return
+		}
+
+		handler.firing.Lock()
+		alerts := make([]alertmanagerAlert, 0, len(handler.firing.alerts))
+		for _, alert := range handler.firing.alerts {
+			alerts = append(alerts, alert)
+		}
+		handler.firing.Unlock()
+
+		if len(alerts) > 0 {
+			log.Debugf("Re-sending %d firing alerts to Alertmanager", len(alerts))
+			handler.post(nil, alerts)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_alertmanager(t *testing.T) {
+	var requests [][]alertmanagerAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("unexpected request path: %s", r.URL.Path)
+		}
+
+		var alerts []alertmanagerAlert
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &alerts); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+		requests = append(requests, alerts)
+	}))
+	defer server.Close()
+
+	handler := AlertmanagerHandler{
+		URLs:           []string{server.URL + "/"},
+		Labels:         map[string]string{"team": "ops"},
+		ResendInterval: 3600,
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: api.HealthWarning, LastAlerted: api.HealthPassing, Service: "redis", Message: "redis is warning"}
+	handler.Alert("dc1", alert)
+
+	if len(requests) != 1 || len(requests[0]) != 1 {
+		t.Fatalf("expected one alert, got %v", requests)
+	}
+	firing := requests[0][0]
+	expectedLabels := map[string]string{
+		"alertname":  "ConsulHealthCheck",
+		"datacenter": "dc1",
+		"service":    "redis",
+		"severity":   "warning",
+		"team":       "ops",
+	}
+	for name, value := range expectedLabels {
+		if firing.Labels[name] != value {
+			t.Errorf("expected label %s=%s, got %v", name, value, firing.Labels)
+		}
+	}
+	if firing.EndsAt != nil {
+		t.Errorf("expected no endsAt on a firing alert, got %s", firing.EndsAt)
+	}
+
+	// Going critical resolves the warning alert and starts a critical one
+	alert.Status, alert.LastAlerted = api.HealthCritical, api.HealthWarning
+	handler.Alert("dc1", alert)
+	if len(requests[1]) != 2 || requests[1][0].EndsAt == nil || requests[1][1].Labels["severity"] != "critical" {
+		t.Errorf("expected the warning to resolve and a critical alert to fire, got %+v", requests[1])
+	}
+
+	alert.Status, alert.LastAlerted = api.HealthPassing, api.HealthCritical
+	handler.Alert("dc1", alert)
+	resolved := requests[2]
+	if len(resolved) != 1 || resolved[0].EndsAt == nil || resolved[0].Labels["severity"] != "critical" {
+		t.Errorf("expected the critical alert to resolve, got %+v", resolved)
+	}
+	if len(handler.firing.alerts) != 0 {
+		t.Errorf("expected no firing alerts, got %v", handler.firing.alerts)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ServiceBusHandler sends alerts as JSON to an Azure Service Bus queue or topic
+type ServiceBusHandler struct {
+	ConnectionString string `mapstructure:"connection_string"`
+	Entity           string `mapstructure:"entity"`
+	Sessions         bool   `mapstructure:"sessions"`
+	MaxRetries       int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	conn *azureConnection
+}
+
+// EventHubsHandler sends alerts as JSON to an Azure event hub
+type EventHubsHandler struct {
+	ConnectionString string `mapstructure:"connection_string"`
+	EventHub         string `mapstructure:"event_hub"`
+	MaxRetries       int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	conn *azureConnection
+}
+
+// Parses the connection string and returns the entity to send to, which defaults to the
+// EntityPath of the connection string
+func parseAzureHandler(connectionString string, entity string, option string) (*azureConnection, string, error) {
+	conn, err := parseAzureConnectionString(connectionString)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid connection_string: %s", err)
+	}
+	if entity == "" {
+		entity = conn.entityPath
+	}
+	if entity == "" {
+		return nil, "", fmt.Errorf("%s must be set if the connection string has no EntityPath", option)
+	}
+	return conn, entity, nil
+}
+
+func (handler *ServiceBusHandler) validate() error {
+	var err error
+	handler.conn, handler.Entity, err = parseAzureHandler(handler.ConnectionString, handler.Entity, "entity")
+	return err
+}
+
+func (handler *EventHubsHandler) validate() error {
+	var err error
+	handler.conn, handler.EventHub, err = parseAzureHandler(handler.ConnectionString, handler.EventHub, "event_hub")
+	return err
+}
+
+func (handler ServiceBusHandler) Alert(datacenter string, alert *AlertState) error {
+	key := alertIncidentKey(datacenter, alert)
+	properties := map[string]interface{}{
+		// The message ID is fixed across retries, so duplicate detection can drop a send
+		// that succeeded but timed out
+		"MessageId": key + "-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		"Label":     alert.Status,
+	}
+	if handler.Sessions {
+		properties["SessionId"] = key
+	}
+
+	// Custom properties allow filtering topic subscriptions without decoding the alert
+	headers := map[string]string{
+		"datacenter": strconv.Quote(datacenter),
+		"service":    strconv.Quote(alert.Service),
+		"status":     strconv.Quote(alert.Status),
+	}
+	return azureSend(handler.HTTPOptions, handler.conn, handler.Entity, "Service Bus entity", datacenter, alert, handler.MaxRetries, properties, headers)
+}
+
+func (handler EventHubsHandler) Alert(datacenter string, alert *AlertState) error {
+	// The partition key keeps the transitions of an alert in order on one partition
+	properties := map[string]interface{}{"PartitionKey": alertIncidentKey(datacenter, alert)}
+	return azureSend(handler.HTTPOptions, handler.conn, handler.EventHub, "event hub", datacenter, alert, handler.MaxRetries, properties, nil)
+}
+
+// Sends an alert to a Service Bus or Event Hubs entity using the REST api
+func azureSend(options HTTPOptions, conn *azureConnection, entity string, kind string, datacenter string, alert *AlertState,
+	maxRetries int, properties map[string]interface{}, headers map[string]string) error {
+	message, err := json.Marshal(alertPayload{datacenter, alert})
+	if err != nil {
+		log.Errorf("Error encoding alert for Azure: %s", err)
+		return err
+	}
+	brokerProperties, err := json.Marshal(properties)
+	if err != nil {
+		return err
+	}
+
+	return retryAlert(alert, maxRetries, kind+" "+entity, func() error {
+		req, err := http.NewRequest("POST", conn.messagesURL(entity), bytes.NewReader(message))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", conn.sasToken(entity, time.Now()))
+		req.Header.Set("BrokerProperties", string(brokerProperties))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		_, err = options.doRequest(req)
+		return err
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A request received by the fake Azure server
+type testAzureRequest struct {
+	path       string
+	auth       string
+	properties map[string]interface{}
+	status     string
+	payload    map[string]interface{}
+}
+
+func newTestAzureServer(t *testing.T, requests chan testAzureRequest) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := testAzureRequest{path: r.URL.Path, auth: r.Header.Get("Authorization"), status: r.Header.Get("status")}
+		json.Unmarshal([]byte(r.Header.Get("BrokerProperties")), &request.properties)
+		json.NewDecoder(r.Body).Decode(&request.payload)
+		requests <- request
+		w.WriteHeader(http.StatusCreated)
+	}))
+}
+
+func TestHandler_serviceBus(t *testing.T) {
+	requests := make(chan testAzureRequest, 1)
+	server := newTestAzureServer(t, requests)
+	defer server.Close()
+
+	handler := ServiceBusHandler{
+		ConnectionString: "Endpoint=sb://" + server.Listener.Addr().String() + "/;SharedAccessKeyName=send;SharedAccessKey=secret;EntityPath=alerts",
+		Sessions:         true,
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+	handler.client = server.Client()
+
+	alert := &AlertState{Status: "critical", Service: "redis"}
+	if err := handler.Alert("dc1", alert); err != nil {
+		t.Fatal(err)
+	}
+
+	request := <-requests
+	if request.path != "/alerts/messages" || !strings.HasPrefix(request.auth, "SharedAccessSignature ") {
+		t.Errorf("unexpected request to %s with %s", request.path, request.auth)
+	}
+	if request.properties["SessionId"] != alertIncidentKey("dc1", alert) || request.properties["Label"] != "critical" {
+		t.Errorf("unexpected broker properties: %v", request.properties)
+	}
+	if request.status != `"critical"` || request.payload["service"] != "redis" {
+		t.Errorf("unexpected message: %+v", request)
+	}
+}
+
+func TestHandler_eventHubs(t *testing.T) {
+	requests := make(chan testAzureRequest, 1)
+	server := newTestAzureServer(t, requests)
+	defer server.Close()
+
+	handler := EventHubsHandler{
+		ConnectionString: "Endpoint=sb://" + server.Listener.Addr().String() + "/;SharedAccessKeyName=send;SharedAccessKey=secret",
+		EventHub:         "consul-alerts",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+	handler.client = server.Client()
+
+	alert := &AlertState{Status: "warning", Service: "redis"}
+	if err := handler.Alert("dc1", alert); err != nil {
+		t.Fatal(err)
+	}
+
+	request := <-requests
+	if request.path != "/consul-alerts/messages" || request.properties["PartitionKey"] != alertIncidentKey("dc1", alert) {
+		t.Errorf("unexpected request: %+v", request)
+	}
+
+	// Without EntityPath the event hub must be set
+	handler.EventHub = ""
+	if err := handler.validate(); err == nil {
+		t.Error("expected an error without an event hub")
+	}
+}
+
+func TestAzure_sasToken(t *testing.T) {
+	conn, err := parseAzureConnectionString("Endpoint=sb://ns.servicebus.windows.net/;SharedAccessKeyName=send;SharedAccessKey=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1600000000, 0)
+	resource := url.QueryEscape("https://ns.servicebus.windows.net/alerts")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(resource + "\n1600003600"))
+	expected := "SharedAccessSignature sr=" + resource + "&sig=" + url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil))) + "&se=1600003600&skn=send"
+	if token := conn.sasToken("Alerts", now); token != expected {
+		t.Errorf("expected %s, got %s", expected, token)
+	}
+
+	for _, invalid := range []string{"", "Endpoint=sb://ns.servicebus.windows.net/", "garbage"} {
+		if _, err := parseAzureConnectionString(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
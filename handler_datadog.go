@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// DatadogHandler creates a Datadog event for each alert
+type DatadogHandler struct {
+	APIKey     string   `mapstructure:"api_key"`
+	Site       string   `mapstructure:"site"`
+	Tags       []string `mapstructure:"tags"`
+	MaxRetries int      `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	// The events endpoint, built from the site
+	eventsURL string
+}
+
+// The body of a request to the v1 events api
+type datadogEvent struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	AlertType      string   `json:"alert_type"`
+	AggregationKey string   `json:"aggregation_key"`
+	SourceTypeName string   `json:"source_type_name"`
+	Tags           []string `json:"tags"`
+}
+
+// Datadog limits event text to this many characters
+const datadogTextLimit = 4000
+
+func (handler *DatadogHandler) validate() error {
+	if handler.APIKey == "" {
+		return fmt.Errorf("api_key must be set")
+	}
+
+	site := strings.TrimSuffix(handler.Site, "/")
+	if !strings.Contains(site, "://") {
+		site = "https://api." + site
+	}
+	handler.eventsURL = site + "/api/v1/events"
+	return nil
+}
+
+func (handler DatadogHandler) Alert(datacenter string, alert *AlertState) error {
+	event := datadogEvent{
+		Title:          alert.Message,
+		AlertType:      datadogAlertType(alert.Status),
+		AggregationKey: alertIncidentKey(datacenter, alert),
+		SourceTypeName: "consul",
+		Tags:           append([]string{"datacenter:" + datacenter}, handler.Tags...),
+	}
+	if alert.Details != "" {
+		// Datadog renders event text as markdown when it's wrapped in %%%
+		event.Text = "%%%\n```\n" + truncateDetails(alert.Details, datadogTextLimit-20) + "\n```\n%%%"
+	}
+	for _, tag := range [][2]string{
+		{"service", alert.Service},
+		{"tag", alert.Tag},
+		{"node", alert.Node},
+		{"check", alert.Check},
+	} {
+		if tag[1] != "" {
+			event.Tags = append(event.Tags, tag[0]+":"+tag[1])
+		}
+	}
+	for _, name := range sortedLabelNames(alert.Labels) {
+		event.Tags = append(event.Tags, name+":"+alert.Labels[name])
+	}
+
+	headers := map[string]string{"DD-API-KEY": handler.APIKey}
+	return retryAlert(alert, handler.MaxRetries, "Datadog", func() error {
+		_, err := handler.sendJSON("POST", handler.eventsURL, headers, event)
+		return err
+	})
+}
+
+// Returns the Datadog alert type for a check status
+func datadogAlertType(status string) string {
+	switch status {
+	case api.HealthPassing:
+		return "success"
+	case api.HealthWarning:
+		return "warning"
+	}
+	return "error"
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_datadog(t *testing.T) {
+	var event datadogEvent
+	var apiKey, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+		apiKey = r.Header.Get("DD-API-KEY")
+		path = r.URL.Path
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	handler := DatadogHandler{APIKey: "key", Site: server.URL, Tags: []string{"env:prod"}}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: api.HealthCritical, Service: "redis", Node: "node1", Message: "redis is critical"})
+
+	if apiKey != "key" || path != "/api/v1/events" {
+		t.Errorf("unexpected api key %s or path %s", apiKey, path)
+	}
+	if event.AlertType != "error" || event.Title != "redis is critical" || event.AggregationKey != "dc1-redis--node1" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	expectedTags := []string{"datacenter:dc1", "env:prod", "service:redis", "node:node1"}
+	if !reflect.DeepEqual(event.Tags, expectedTags) {
+		t.Errorf("expected tags %v, got %v", expectedTags, event.Tags)
+	}
+}
+
+func TestHandler_datadogSite(t *testing.T) {
+	handler := DatadogHandler{APIKey: "key", Site: "datadoghq.eu"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if handler.eventsURL != "https://api.datadoghq.eu/api/v1/events" {
+		t.Errorf("unexpected events url: %s", handler.eventsURL)
+	}
+
+	if datadogAlertType(api.HealthPassing) != "success" || datadogAlertType(api.HealthWarning) != "warning" {
+		t.Error("unexpected alert types")
+	}
+}
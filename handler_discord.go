@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Discord limits embed descriptions to this many characters
+const discordDescriptionLimit = 4096
+
+// DiscordHandler sends alerts as embeds to a Discord webhook
+type DiscordHandler struct {
+	WebhookURL string `mapstructure:"webhook_url"`
+	Username   string `mapstructure:"username"`
+	MaxRetries int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// The body of a Discord webhook execution
+type discordMessage struct {
+	Username string         `json:"username,omitempty"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color"`
+	Fields      []discordEmbedField `json:"fields,omitempty"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+func (handler *DiscordHandler) validate() error {
+	if handler.WebhookURL == "" {
+		return fmt.Errorf("webhook_url must be set")
+	}
+	return nil
+}
+
+func (handler DiscordHandler) Alert(datacenter string, alert *AlertState) error {
+	message := discordMessage{
+		Username: handler.Username,
+		Embeds:   []discordEmbed{discordAlertEmbed(datacenter, alert)},
+	}
+
+	return retryAlert(alert, handler.MaxRetries, "Discord", func() error {
+		_, err := handler.sendJSON("POST", handler.WebhookURL, nil, message)
+		return err
+	})
+}
+
+// Builds the embed for an alert, with the check details in the body
+func discordAlertEmbed(datacenter string, alert *AlertState) discordEmbed {
+	embed := discordEmbed{
+		Title: alert.Message,
+		Color: alertStatusColor(alert.Status),
+	}
+
+	if alert.Details != "" {
+		// Leave room for the code block markers
+		details := truncateDetails(strings.Replace(alert.Details, "```", "'''", -1), discordDescriptionLimit-8)
+		embed.Description = "```\n" + details + "\n```"
+	}
+
+	for _, field := range []discordEmbedField{
+		{"Datacenter", datacenter, true},
+		{"Status", alert.Status, true},
+		{"Service", alert.Service, true},
+		{"Tag", alert.Tag, true},
+		{"Node", alert.Node, true},
+		{"Check", alert.Check, true},
+	} {
+		if field.Value != "" {
+			embed.Fields = append(embed.Fields, field)
+		}
+	}
+
+	return embed
+}
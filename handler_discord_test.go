@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_discord(t *testing.T) {
+	var message discordMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	handler := DiscordHandler{WebhookURL: server.URL, Username: "alerts"}
+	handler.Alert("dc1", &AlertState{
+		Status:  api.HealthCritical,
+		Service: "redis",
+		Message: "redis is critical",
+		Details: "connection refused",
+	})
+
+	if message.Username != "alerts" || len(message.Embeds) != 1 {
+		t.Fatalf("unexpected message: %+v", message)
+	}
+
+	embed := message.Embeds[0]
+	if embed.Color != 0xA30200 {
+		t.Errorf("expected critical color, got %x", embed.Color)
+	}
+	if embed.Description != "```\nconnection refused\n```" {
+		t.Errorf("unexpected description: %s", embed.Description)
+	}
+	if len(embed.Fields) != 3 {
+		t.Errorf("expected 3 fields, got %+v", embed.Fields)
+	}
+}
+
+func TestHandler_discordLongDetails(t *testing.T) {
+	embed := discordAlertEmbed("dc1", &AlertState{Details: strings.Repeat("line\n", 2000)})
+	if len(embed.Description) > discordDescriptionLimit {
+		t.Errorf("expected description to fit the limit, got %d characters", len(embed.Description))
+	}
+}
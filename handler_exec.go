@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExecHandler runs a local command for each alert, passing the alert in environment
+// variables and as JSON on stdin
+type ExecHandler struct {
+	Command       []string `mapstructure:"command"`
+	Timeout       int      `mapstructure:"timeout"`
+	MaxConcurrent int      `mapstructure:"max_concurrent"`
+	MaxRetries    int      `mapstructure:"max_retries"`
+
+	// Limits the number of commands running at once
+	slots chan struct{}
+}
+
+func (handler *ExecHandler) validate() error {
+	if len(handler.Command) == 0 {
+		return fmt.Errorf("command must be set")
+	}
+	if handler.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if handler.MaxConcurrent <= 0 {
+		return fmt.Errorf("max_concurrent must be positive")
+	}
+
+	handler.slots = make(chan struct{}, handler.MaxConcurrent)
+	return nil
+}
+
+func (handler ExecHandler) Alert(datacenter string, alert *AlertState) error {
+	payload, err := json.Marshal(alertPayload{datacenter, alert})
+	if err != nil {
+		log.Errorf("Error encoding alert for command: %s", err)
+		return err
+	}
+
+	return handler.runAlert(alert, "command "+handler.Command[0], execEnvironment(datacenter, alert), payload)
+}
+
+// Runs the command for an alert once a slot is free, retrying it if it fails
+func (handler ExecHandler) runAlert(alert *AlertState, description string, env []string, stdin []byte) error {
+	handler.slots <- struct{}{}
+	defer func() { <-handler.slots }()
+
+	return retryAlert(alert, handler.MaxRetries, description, func() error {
+		return handler.run(env, stdin)
+	})
+}
+
+// Runs the command once, killing it if it runs past the timeout
+func (handler ExecHandler) run(env []string, stdin []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(handler.Timeout)*time.Second)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, handler.Command[0], handler.Command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if out := strings.TrimSpace(output.String()); out != "" {
+		log.Debugf("Output from %s: %s", handler.Command[0], out)
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %ds", handler.Timeout)
+	}
+	return err
+}
+
+// Returns the environment variables describing an alert
+func execEnvironment(datacenter string, alert *AlertState) []string {
+	return []string{
+		"CONSUL_ALERT_DATACENTER=" + datacenter,
+		"CONSUL_ALERT_STATUS=" + alert.Status,
+		"CONSUL_ALERT_LAST_ALERTED=" + alert.LastAlerted,
+		"CONSUL_ALERT_SERVICE=" + alert.Service,
+		"CONSUL_ALERT_NAMESPACE=" + alert.Namespace,
+		"CONSUL_ALERT_PARTITION=" + alert.Partition,
+		"CONSUL_ALERT_TAG=" + alert.Tag,
+		"CONSUL_ALERT_NODE=" + alert.Node,
+		"CONSUL_ALERT_CHECK=" + alert.Check,
+		"CONSUL_ALERT_MESSAGE=" + alert.Message,
+		"CONSUL_ALERT_DETAILS=" + alert.Details,
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandler_exec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "output")
+	handler := ExecHandler{
+		Command:       []string{"sh", "-c", `cat > "$0.json"; echo "$CONSUL_ALERT_SERVICE $CONSUL_ALERT_STATUS" > "$0"`, output},
+		Timeout:       10,
+		MaxConcurrent: 1,
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Service: "redis"})
+
+	env, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(env)) != "redis critical" {
+		t.Errorf("expected the alert in the environment, got %s", env)
+	}
+
+	stdin, err := ioutil.ReadFile(output + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(stdin, &payload); err != nil || payload["datacenter"] != "dc1" {
+		t.Errorf("expected the alert as JSON on stdin, got %s (%v)", stdin, err)
+	}
+}
+
+func TestHandler_execTimeout(t *testing.T) {
+	handler := ExecHandler{Command: []string{"sleep", "5"}, Timeout: 1, MaxConcurrent: 1}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := handler.run(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+}
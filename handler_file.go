@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FileHandler appends each alert transition as a line of JSON to a file, as an event
+// stream for log shippers like Filebeat or Vector to pick up
+type FileHandler struct {
+	Path       string `mapstructure:"path"`
+	MaxSize    int    `mapstructure:"max_size"`
+	MaxBackups int    `mapstructure:"max_backups"`
+	Sync       bool   `mapstructure:"sync"`
+}
+
+// A line of the event stream: the alert in the format of the webhook handler, with the
+// time it was written
+type fileEvent struct {
+	Time time.Time `json:"time"`
+	alertPayload
+}
+
+// The files written by file handlers, by path, so the handlers writing to one file (like
+// the old and new handler across a reload) share its rotation. Files are opened on the
+// first alert, so validating a config doesn't create them.
+var eventFiles = struct {
+	sync.Mutex
+	files map[string]*rotatingFile
+}{files: make(map[string]*rotatingFile)}
+
+func (handler *FileHandler) validate() error {
+	if handler.Path == "" {
+		return fmt.Errorf("path must be set")
+	}
+	if handler.MaxSize < 0 {
+		return fmt.Errorf("max_size can't be negative")
+	}
+	if handler.MaxBackups < 0 {
+		return fmt.Errorf("max_backups can't be negative")
+	}
+	return nil
+}
+
+func (handler FileHandler) Alert(datacenter string, alert *AlertState) error {
+	line, err := json.Marshal(fileEvent{time.Now().UTC(), alertPayload{datacenter, alert}})
+	if err != nil {
+		log.Errorf("Error encoding alert for file: %s", err)
+		return err
+	}
+
+	return retryAlert(alert, 0, "file "+handler.Path, func() error {
+		file, err := handler.file()
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		if handler.Sync {
+			return file.Sync()
+		}
+		return nil
+	})
+}
+
+// Returns the handler's file, opening it if no handler has yet
+func (handler FileHandler) file() (*rotatingFile, error) {
+	eventFiles.Lock()
+	defer eventFiles.Unlock()
+
+	maxSize := int64(handler.MaxSize) * 1024 * 1024
+	if file, ok := eventFiles.files[handler.Path]; ok {
+		file.Lock()
+		file.maxSize, file.backups = maxSize, handler.MaxBackups
+		file.Unlock()
+		return file, nil
+	}
+
+	file, err := openRotatingFile(handler.Path, maxSize, handler.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	eventFiles.files[handler.Path] = file
+	return file, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandler_file(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+	defer func() {
+		eventFiles.Lock()
+		eventFiles.files[path].Close()
+		delete(eventFiles.files, path)
+		eventFiles.Unlock()
+	}()
+
+	config, err := ParseConfig(`
+handler "file" "events" {
+  path = "` + path + `"
+  sync = true
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := config.Handlers["file.events"].(FileHandler)
+	if handler.MaxSize != 100 || handler.MaxBackups != 5 {
+		t.Errorf("unexpected defaults: %+v", handler)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the file not to be created before the first alert, got %v", err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Service: "redis", Node: "web1", Message: "redis is critical"})
+	handler.Alert("dc1", &AlertState{Status: "passing", Service: "redis", Node: "web1", Message: "redis is passing", LastAlerted: "critical"})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("expected a line of JSON, got %q: %s", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	first := events[0]
+	if first["datacenter"] != "dc1" || first["service"] != "redis" || first["status"] != "critical" || first["time"] == nil {
+		t.Errorf("unexpected event: %v", first)
+	}
+	if events[1]["status"] != "passing" || events[1]["last_alerted"] != "critical" {
+		t.Errorf("unexpected recovery event: %v", events[1])
+	}
+
+	// Handlers writing to the same path share the file
+	other := FileHandler{Path: path, MaxSize: 1}
+	shared, err := other.file()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if original, _ := handler.file(); shared != original {
+		t.Error("expected the handlers to share the file")
+	}
+}
+
+func TestHandler_fileInvalid(t *testing.T) {
+	cases := []FileHandler{
+		{},
+		{Path: "events.jsonl", MaxSize: -1},
+		{Path: "events.jsonl", MaxBackups: -1},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
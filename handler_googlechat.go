@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// GoogleChatHandler posts alerts as cards to a Google Chat space webhook. Each alert is
+// threaded by its datacenter/service/node, so recoveries land in the same thread.
+type GoogleChatHandler struct {
+	WebhookURL string `mapstructure:"webhook_url"`
+	MaxRetries int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// The body of a Google Chat message with a single card
+type googleChatMessage struct {
+	Text    string           `json:"text"`
+	CardsV2 []googleChatCard `json:"cardsV2"`
+}
+
+type googleChatCard struct {
+	CardID string `json:"cardId"`
+	Card   struct {
+		Header   googleChatCardHeader `json:"header"`
+		Sections []googleChatSection  `json:"sections"`
+	} `json:"card"`
+}
+
+type googleChatCardHeader struct {
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle"`
+}
+
+type googleChatSection struct {
+	Widgets []googleChatWidget `json:"widgets"`
+}
+
+type googleChatWidget struct {
+	DecoratedText *googleChatDecoratedText `json:"decoratedText,omitempty"`
+	TextParagraph *googleChatTextParagraph `json:"textParagraph,omitempty"`
+}
+
+type googleChatDecoratedText struct {
+	TopLabel string `json:"topLabel"`
+	Text     string `json:"text"`
+}
+
+type googleChatTextParagraph struct {
+	Text string `json:"text"`
+}
+
+func (handler *GoogleChatHandler) validate() error {
+	if handler.WebhookURL == "" {
+		return fmt.Errorf("webhook_url must be set")
+	}
+	if _, err := url.Parse(handler.WebhookURL); err != nil {
+		return fmt.Errorf("invalid webhook_url: %s", err)
+	}
+	return nil
+}
+
+func (handler GoogleChatHandler) Alert(datacenter string, alert *AlertState) error {
+	endpoint, _ := url.Parse(handler.WebhookURL)
+	query := endpoint.Query()
+	query.Set("threadKey", alertIncidentKey(datacenter, alert))
+	query.Set("messageReplyOption", "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD")
+	endpoint.RawQuery = query.Encode()
+
+	message := googleChatAlertMessage(datacenter, alert)
+	return retryAlert(alert, handler.MaxRetries, "Google Chat", func() error {
+		_, err := handler.sendJSON("POST", endpoint.String(), nil, message)
+		return err
+	})
+}
+
+// Builds the card for an alert, with a field for each of the alert's attributes and
+// the check output below them
+func googleChatAlertMessage(datacenter string, alert *AlertState) googleChatMessage {
+	var card googleChatCard
+	card.CardID = "alert"
+	card.Card.Header = googleChatCardHeader{
+		Title:    alert.Message,
+		Subtitle: fmt.Sprintf(`<font color="#%06X">%s</font>`, alertStatusColor(alert.Status), alert.Status),
+	}
+
+	var fields googleChatSection
+	for _, field := range [][2]string{
+		{"Datacenter", datacenter},
+		{"Service", alert.Service},
+		{"Tag", alert.Tag},
+		{"Node", alert.Node},
+		{"Check", alert.Check},
+	} {
+		if field[1] != "" {
+			fields.Widgets = append(fields.Widgets, googleChatWidget{
+				DecoratedText: &googleChatDecoratedText{TopLabel: field[0], Text: html.EscapeString(field[1])},
+			})
+		}
+	}
+	card.Card.Sections = append(card.Card.Sections, fields)
+
+	if alert.Details != "" {
+		output := strings.Replace(html.EscapeString(alert.Details), "\n", "<br>", -1)
+		card.Card.Sections = append(card.Card.Sections, googleChatSection{
+			Widgets: []googleChatWidget{{TextParagraph: &googleChatTextParagraph{Text: output}}},
+		})
+	}
+
+	return googleChatMessage{
+		Text:    alert.Message,
+		CardsV2: []googleChatCard{card},
+	}
+}
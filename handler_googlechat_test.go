@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_googleChat(t *testing.T) {
+	var message googleChatMessage
+	var threadKeys []string
+	var key string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+		threadKeys = append(threadKeys, r.URL.Query().Get("threadKey"))
+		key = r.URL.Query().Get("key")
+	}))
+	defer server.Close()
+
+	handler := GoogleChatHandler{WebhookURL: server.URL + "/v1/spaces/AAA/messages?key=abc"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: api.HealthCritical, Service: "redis", Node: "node1", Message: "redis is critical", Details: "a < b\nline 2"}
+	handler.Alert("dc1", alert)
+	alert.Status = api.HealthPassing
+	handler.Alert("dc1", alert)
+
+	if len(threadKeys) != 2 || threadKeys[0] != "dc1-redis--node1" || threadKeys[0] != threadKeys[1] {
+		t.Errorf("expected matching thread keys, got %v", threadKeys)
+	}
+	if key != "abc" {
+		t.Errorf("expected the webhook's query to be kept, got key %s", key)
+	}
+
+	sections := message.CardsV2[0].Card.Sections
+	if len(sections) != 2 || len(sections[0].Widgets) != 3 {
+		t.Fatalf("unexpected sections: %+v", sections)
+	}
+	if text := sections[1].Widgets[0].TextParagraph.Text; text != "a &lt; b<br>line 2" {
+		t.Errorf("unexpected output text: %s", text)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The default Gotify priority for each status. Gotify apps show priorities of 8 and
+// above as high-priority notifications, and 4 to 7 with a sound.
+var gotifyPriorities = map[string]int{
+	api.HealthCritical: 8,
+	api.HealthWarning:  5,
+	api.HealthPassing:  2,
+}
+
+// GotifyHandler sends alerts as push notifications through a Gotify server
+type GotifyHandler struct {
+	ServerURL  string         `mapstructure:"server_url"`
+	AppToken   string         `mapstructure:"app_token"`
+	Priorities map[string]int `mapstructure:"priorities"`
+	MaxRetries int            `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// The body of a create message request
+type gotifyMessage struct {
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	Priority int                    `json:"priority"`
+	Extras   map[string]interface{} `json:"extras,omitempty"`
+}
+
+func (handler *GotifyHandler) validate() error {
+	if handler.ServerURL == "" {
+		return fmt.Errorf("server_url must be set")
+	}
+	if parsed, err := url.Parse(handler.ServerURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("server_url must be an http or https URL")
+	}
+	if handler.AppToken == "" {
+		return fmt.Errorf("app_token must be set")
+	}
+	for status, priority := range handler.Priorities {
+		if _, ok := gotifyPriorities[status]; !ok {
+			return fmt.Errorf("invalid status %q in priorities", status)
+		}
+		if priority < 0 || priority > 10 {
+			return fmt.Errorf("priority for %s must be between 0 and 10, got %d", status, priority)
+		}
+	}
+
+	handler.ServerURL = strings.TrimSuffix(handler.ServerURL, "/")
+	return nil
+}
+
+func (handler GotifyHandler) Alert(datacenter string, alert *AlertState) error {
+	priority, ok := handler.Priorities[alert.Status]
+	if !ok {
+		priority, ok = gotifyPriorities[alert.Status]
+	}
+	if !ok {
+		priority = gotifyPriorities[api.HealthCritical]
+	}
+
+	message := gotifyMessage{
+		Title:    alert.Message,
+		Message:  pushoverMessage(alert),
+		Priority: priority,
+	}
+	if alert.ConsulURL != "" {
+		message.Extras = map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]string{"url": alert.ConsulURL},
+			},
+		}
+	}
+
+	headers := map[string]string{"X-Gotify-Key": handler.AppToken}
+	return retryAlert(alert, handler.MaxRetries, "Gotify", func() error {
+		_, err := handler.sendJSON("POST", handler.ServerURL+"/message", headers, message)
+		return err
+	})
+}
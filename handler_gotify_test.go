@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_gotify(t *testing.T) {
+	var key string
+	var messages []gotifyMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/message" {
+			t.Errorf("unexpected request path: %s", r.URL.Path)
+		}
+		key = r.Header.Get("X-Gotify-Key")
+		var message gotifyMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		messages = append(messages, message)
+	}))
+	defer server.Close()
+
+	handler := GotifyHandler{
+		ServerURL:  server.URL + "/",
+		AppToken:   "app",
+		Priorities: map[string]int{api.HealthWarning: 6},
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: api.HealthCritical, Message: "[dc1] redis is critical", Details: "connection refused", ConsulURL: "https://consul.example.com/ui/dc1/services/redis"}
+	if err := handler.Alert("dc1", alert); err != nil {
+		t.Fatal(err)
+	}
+	if key != "app" {
+		t.Errorf("expected the app token to be sent, got %q", key)
+	}
+	if messages[0].Title != "[dc1] redis is critical" || messages[0].Message != "connection refused" || messages[0].Priority != 8 {
+		t.Errorf("unexpected message: %+v", messages[0])
+	}
+	if messages[0].Extras["client::notification"] == nil {
+		t.Errorf("expected a click URL, got %v", messages[0].Extras)
+	}
+
+	alert.Status = api.HealthWarning
+	handler.Alert("dc1", alert)
+	alert.Status = api.HealthPassing
+	handler.Alert("dc1", alert)
+	if messages[1].Priority != 6 || messages[2].Priority != 2 {
+		t.Errorf("unexpected priorities: %d, %d", messages[1].Priority, messages[2].Priority)
+	}
+}
+
+func TestHandler_gotifyInvalid(t *testing.T) {
+	cases := []GotifyHandler{
+		{AppToken: "app"},
+		{ServerURL: "gotify.example.com", AppToken: "app"},
+		{ServerURL: "https://gotify.example.com"},
+		{ServerURL: "https://gotify.example.com", AppToken: "app", Priorities: map[string]int{"critical": 11}},
+		{ServerURL: "https://gotify.example.com", AppToken: "app", Priorities: map[string]int{"down": 5}},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// The longest message announced, leaving room in the 512 byte IRC line for the command,
+// channel and the prefix the server adds when relaying it
+const ircMaxMessage = 400
+
+// IRCHandler announces each alert transition in IRC channels
+type IRCHandler struct {
+	Server       string   `mapstructure:"server"`
+	Nick         string   `mapstructure:"nick"`
+	Username     string   `mapstructure:"username"`
+	Password     string   `mapstructure:"password"`
+	SASLUsername string   `mapstructure:"sasl_username"`
+	SASLPassword string   `mapstructure:"sasl_password"`
+	Channels     []string `mapstructure:"channels"`
+	Notice       bool     `mapstructure:"notice"`
+
+	TLSOptions `mapstructure:",squash"`
+	BusOptions `mapstructure:",squash"`
+
+	publisher *busPublisher
+}
+
+func (handler *IRCHandler) validate() error {
+	if handler.Server == "" {
+		return fmt.Errorf("server must be set")
+	}
+	if _, _, err := ircServerAddress(handler.Server); err != nil {
+		return fmt.Errorf("invalid server %s: %s", handler.Server, err)
+	}
+	if handler.Nick == "" || strings.ContainsAny(handler.Nick, " ,:") {
+		return fmt.Errorf("nick must be set, without spaces, commas or colons")
+	}
+	if len(handler.Channels) == 0 {
+		return fmt.Errorf("at least one channel must be set in channels")
+	}
+	for i, channel := range handler.Channels {
+		fields := strings.Fields(channel)
+		if len(fields) == 0 || len(fields) > 2 || !strings.ContainsAny(fields[0][:1], "#&+!") {
+			return fmt.Errorf("channels[%d]: %q must be a channel name, optionally followed by its key", i, channel)
+		}
+	}
+	if (handler.SASLUsername == "") != (handler.SASLPassword == "") {
+		return fmt.Errorf("sasl_username and sasl_password must be set together")
+	}
+
+	tlsConfig, err := handler.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	username := handler.Username
+	if username == "" {
+		username = handler.Nick
+	}
+	config := &ircConfig{
+		server:       handler.Server,
+		nick:         handler.Nick,
+		username:     username,
+		password:     handler.Password,
+		saslUsername: handler.SASLUsername,
+		saslPassword: handler.SASLPassword,
+		channels:     handler.Channels,
+		notice:       handler.Notice,
+		tlsConfig:    tlsConfig,
+	}
+	handler.publisher = newBusPublisher("irc server "+handler.Server, func() (busConn, error) {
+		return dialIRC(config)
+	}, handler.BusOptions)
+
+	return nil
+}
+
+func (handler IRCHandler) stop() {
+	handler.publisher.stop()
+}
+
+func (handler IRCHandler) Alert(datacenter string, alert *AlertState) error {
+	return handler.publisher.publish("", alertIncidentKey(datacenter, alert), []byte(ircMessage(alert)))
+}
+
+// Formats an alert as a single line, e.g. "[dc1] CRIT: redis is critical"
+func ircMessage(alert *AlertState) string {
+	message := fmt.Sprintf("%s: %s", shortStatus(alert.Status), strings.Join(strings.Fields(alert.Message), " "))
+	if alert.ConsulURL != "" {
+		message += " - " + alert.ConsulURL
+	}
+
+	if len(message) > ircMaxMessage {
+		message = message[:ircMaxMessage]
+		for !utf8.ValidString(message) {
+			message = message[:len(message)-1]
+		}
+	}
+	return message
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHandler_irc(t *testing.T) {
+	server := newTestIRCServer(t, "")
+	defer server.listener.Close()
+
+	handler := IRCHandler{Server: server.listener.Addr().String(), Nick: "alerts", Channels: []string{"#ops"}, Notice: true}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Service: "redis", Message: "[dc1] redis is\ncritical", ConsulURL: "http://consul/ui"})
+
+	for server.next(t) != "JOIN #ops" {
+	}
+	if line := server.next(t); line != "NOTICE #ops :CRIT: [dc1] redis is critical - http://consul/ui" {
+		t.Errorf("unexpected message: %q", line)
+	}
+}
+
+func TestHandler_ircMessage(t *testing.T) {
+	message := ircMessage(&AlertState{Status: "passing", Message: "[dc1] " + strings.Repeat("é", 300)})
+	if len(message) > ircMaxMessage || !strings.HasPrefix(message, "OK: [dc1] é") {
+		t.Errorf("expected the message to be truncated to %d bytes, got %d: %q", ircMaxMessage, len(message), message)
+	}
+
+	invalid := []IRCHandler{
+		{Nick: "alerts", Channels: []string{"#ops"}},
+		{Server: "irc.local:6667", Nick: "alerts"},
+		{Server: "irc.local:6667", Nick: "alerts", Channels: []string{"ops"}},
+		{Server: "irc.local:6667", Nick: "alerts", Channels: []string{"#ops"}, SASLUsername: "bot"},
+	}
+	for _, handler := range invalid {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error for %+v", handler)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The prefix of the label used to find the open issue for an alert
+const jiraLabelPrefix = "consul-alerting-"
+
+// JiraHandler opens a Jira issue when a service goes critical, and comments on (and
+// optionally transitions) the issue once it recovers. Warnings are ignored.
+type JiraHandler struct {
+	URL               string            `mapstructure:"url"`
+	Username          string            `mapstructure:"username"`
+	APIToken          string            `mapstructure:"api_token"`
+	ProjectKey        string            `mapstructure:"project_key"`
+	IssueType         string            `mapstructure:"issue_type"`
+	Fields            map[string]string `mapstructure:"fields"`
+	ResolveTransition string            `mapstructure:"resolve_transition"`
+	MaxRetries        int               `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	fieldTemplates map[string]*template.Template
+}
+
+// An issue in a Jira search result or create response
+type jiraIssue struct {
+	Key string `json:"key"`
+}
+
+func (handler *JiraHandler) validate() error {
+	if handler.URL == "" || handler.ProjectKey == "" {
+		return fmt.Errorf("url and project_key must be set")
+	}
+	if handler.APIToken == "" {
+		return fmt.Errorf("api_token must be set")
+	}
+
+	handler.URL = strings.TrimSuffix(handler.URL, "/")
+	handler.fieldTemplates = make(map[string]*template.Template)
+	for field, text := range handler.Fields {
+		tmpl, err := compileAlertTemplate("fields."+field, text)
+		if err != nil {
+			return err
+		}
+		handler.fieldTemplates[field] = tmpl
+	}
+
+	return nil
+}
+
+func (handler JiraHandler) Alert(datacenter string, alert *AlertState) error {
+	if alert.Status == api.HealthWarning {
+		log.Debugf("Not sending warning alert to Jira: %s", alert.Message)
+		return nil
+	}
+
+	label := jiraIssueLabel(datacenter, alert)
+	return retryAlert(alert, handler.MaxRetries, "Jira", func() error {
+		issues, err := handler.openIssues(label)
+		if err != nil {
+			return err
+		}
+
+		if alert.Status == api.HealthPassing {
+			for _, issue := range issues {
+				if err := handler.resolveIssue(issue.Key, alert); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if len(issues) > 0 {
+			log.Infof("Jira issue %s is already open for alert: %s", issues[0].Key, alert.Message)
+			return nil
+		}
+		return handler.createIssue(datacenter, alert, label)
+	})
+}
+
+// Returns the label identifying the issues for an alert; labels can't contain spaces
+func jiraIssueLabel(datacenter string, alert *AlertState) string {
+	return jiraLabelPrefix + strings.Join(strings.Fields(alertIncidentKey(datacenter, alert)), "_")
+}
+
+func (handler JiraHandler) headers() map[string]string {
+	if handler.Username == "" {
+		return map[string]string{"Authorization": "Bearer " + handler.APIToken}
+	}
+
+	credentials := handler.Username + ":" + handler.APIToken
+	return map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))}
+}
+
+// Finds the unresolved issues with the given label
+func (handler JiraHandler) openIssues(label string) ([]jiraIssue, error) {
+	jql := fmt.Sprintf(`project = "%s" AND labels = "%s" AND statusCategory != Done`, handler.ProjectKey, label)
+	query := url.Values{"jql": {jql}, "fields": {"key"}}
+
+	var result struct {
+		Issues []jiraIssue `json:"issues"`
+	}
+	err := handler.getJSON(handler.URL+"/rest/api/2/search?"+query.Encode(), handler.headers(), &result)
+	return result.Issues, err
+}
+
+func (handler JiraHandler) createIssue(datacenter string, alert *AlertState, label string) error {
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": handler.ProjectKey},
+		"issuetype":   map[string]string{"name": handler.IssueType},
+		"summary":     truncateDetails(alert.Message, 255),
+		"description": jiraDescription(alert),
+		"labels":      []string{label},
+	}
+
+	names := make([]string, 0, len(handler.fieldTemplates))
+	for name := range handler.fieldTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, err := renderAlertTemplate(handler.fieldTemplates[name], datacenter, alert)
+		if err != nil {
+			log.Error("Error rendering Jira field: ", err)
+			continue
+		}
+		fields[name] = jiraFieldValue(value)
+	}
+
+	body, err := handler.sendJSON("POST", handler.URL+"/rest/api/2/issue", handler.headers(), map[string]interface{}{"fields": fields})
+	if err != nil {
+		return err
+	}
+
+	var issue jiraIssue
+	json.Unmarshal(body, &issue)
+	log.Infof("Opened Jira issue %s for alert: %s", issue.Key, alert.Message)
+	return nil
+}
+
+// Comments on an issue with the recovery, then applies the resolve transition if set
+func (handler JiraHandler) resolveIssue(key string, alert *AlertState) error {
+	comment := map[string]string{"body": jiraDescription(alert)}
+	if _, err := handler.sendJSON("POST", handler.URL+"/rest/api/2/issue/"+key+"/comment", handler.headers(), comment); err != nil {
+		return err
+	}
+
+	if handler.ResolveTransition == "" {
+		return nil
+	}
+
+	var result struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}
+	transitionsURL := handler.URL + "/rest/api/2/issue/" + key + "/transitions"
+	if err := handler.getJSON(transitionsURL, handler.headers(), &result); err != nil {
+		return err
+	}
+
+	for _, transition := range result.Transitions {
+		if strings.EqualFold(transition.Name, handler.ResolveTransition) {
+			body := map[string]interface{}{"transition": map[string]string{"id": transition.ID}}
+			_, err := handler.sendJSON("POST", transitionsURL, handler.headers(), body)
+			return err
+		}
+	}
+
+	log.Errorf("Transition %s isn't available for Jira issue %s", handler.ResolveTransition, key)
+	return nil
+}
+
+// Formats an alert for an issue description or comment, in Jira wiki markup
+func jiraDescription(alert *AlertState) string {
+	if alert.Details == "" {
+		return alert.Message
+	}
+	return alert.Message + "\n{noformat}\n" + alert.Details + "\n{noformat}"
+}
+
+// Returns a rendered field value, decoding it if it's a JSON object or array so that
+// fields like priority (which need {"name": "..."}) can be set
+func jiraFieldValue(value string) interface{} {
+	trimmed := strings.TrimSpace(value)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(trimmed), &decoded); err == nil {
+			return decoded
+		}
+	}
+	return value
+}
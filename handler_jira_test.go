@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// A fake Jira server holding at most one issue
+type testJiraServer struct {
+	*httptest.Server
+	created     map[string]interface{}
+	comments    []string
+	transitions []string
+	open        bool
+}
+
+func newTestJiraServer(t *testing.T) *testJiraServer {
+	s := &testJiraServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "bot" || pass != "token" {
+			t.Errorf("unexpected credentials %s/%s", user, pass)
+		}
+
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+
+		switch {
+		case r.URL.Path == "/rest/api/2/search":
+			if !strings.Contains(r.URL.Query().Get("jql"), `labels = "consul-alerting-dc1-redis--"`) {
+				t.Errorf("unexpected jql: %s", r.URL.Query().Get("jql"))
+			}
+			if s.open {
+				w.Write([]byte(`{"issues": [{"key": "OPS-1"}]}`))
+			} else {
+				w.Write([]byte(`{"issues": []}`))
+			}
+		case r.URL.Path == "/rest/api/2/issue":
+			s.created = body["fields"].(map[string]interface{})
+			s.open = true
+			w.Write([]byte(`{"key": "OPS-1"}`))
+		case r.URL.Path == "/rest/api/2/issue/OPS-1/comment":
+			s.comments = append(s.comments, body["body"].(string))
+		case r.URL.Path == "/rest/api/2/issue/OPS-1/transitions" && r.Method == "GET":
+			w.Write([]byte(`{"transitions": [{"id": "11", "name": "In Progress"}, {"id": "31", "name": "Done"}]}`))
+		case r.URL.Path == "/rest/api/2/issue/OPS-1/transitions":
+			s.transitions = append(s.transitions, body["transition"].(map[string]interface{})["id"].(string))
+			s.open = false
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		}
+	}))
+	return s
+}
+
+func TestHandler_jira(t *testing.T) {
+	server := newTestJiraServer(t)
+	defer server.Close()
+
+	handler := JiraHandler{
+		URL:               server.URL,
+		Username:          "bot",
+		APIToken:          "token",
+		ProjectKey:        "OPS",
+		IssueType:         "Incident",
+		Fields:            map[string]string{"priority": `{"name": "High"}`, "customfield_1": "{{.Service}}"},
+		ResolveTransition: "done",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: api.HealthCritical, Service: "redis", Message: "[dc1] redis is critical", Details: "output"}
+	handler.Alert("dc1", alert)
+
+	if server.created == nil {
+		t.Fatal("expected an issue to be created")
+	}
+	if server.created["summary"] != "[dc1] redis is critical" || server.created["customfield_1"] != "redis" {
+		t.Errorf("unexpected issue fields: %v", server.created)
+	}
+	if priority, ok := server.created["priority"].(map[string]interface{}); !ok || priority["name"] != "High" {
+		t.Errorf("expected priority to be decoded as an object, got %v", server.created["priority"])
+	}
+
+	// A second critical alert shouldn't open a duplicate
+	server.created = nil
+	handler.Alert("dc1", alert)
+	if server.created != nil {
+		t.Error("expected no duplicate issue")
+	}
+
+	alert.Status = api.HealthPassing
+	alert.Message = "redis is passing"
+	handler.Alert("dc1", alert)
+	if len(server.comments) != 1 || !strings.HasPrefix(server.comments[0], "redis is passing") {
+		t.Errorf("expected a recovery comment, got %v", server.comments)
+	}
+	if len(server.transitions) != 1 || server.transitions[0] != "31" {
+		t.Errorf("expected the Done transition, got %v", server.transitions)
+	}
+}
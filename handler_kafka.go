@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// KafkaHandler publishes each alert transition as a JSON message to a Kafka topic
+type KafkaHandler struct {
+	Brokers       []string `mapstructure:"brokers"`
+	Topic         string   `mapstructure:"topic"`
+	ClientID      string   `mapstructure:"client_id"`
+	SASLMechanism string   `mapstructure:"sasl_mechanism"`
+	SASLUsername  string   `mapstructure:"sasl_username"`
+	SASLPassword  string   `mapstructure:"sasl_password"`
+	TLSOptions    `mapstructure:",squash"`
+	BusOptions    `mapstructure:",squash"`
+
+	publisher *busPublisher
+}
+
+func (handler *KafkaHandler) validate() error {
+	if len(handler.Brokers) == 0 {
+		return fmt.Errorf("at least one broker must be set in brokers")
+	}
+	if handler.Topic == "" {
+		return fmt.Errorf("topic must be set")
+	}
+	if !contains([]string{"", "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512"}, handler.SASLMechanism) {
+		return fmt.Errorf("invalid sasl_mechanism: %s", handler.SASLMechanism)
+	}
+
+	tlsConfig, err := handler.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	config := &kafkaConfig{
+		brokers:       handler.Brokers,
+		clientID:      handler.ClientID,
+		tlsConfig:     tlsConfig,
+		saslMechanism: handler.SASLMechanism,
+		saslUsername:  handler.SASLUsername,
+		saslPassword:  handler.SASLPassword,
+	}
+	handler.publisher = newBusPublisher("kafka topic "+handler.Topic, func() (busConn, error) {
+		return dialKafka(config)
+	}, handler.BusOptions)
+
+	return nil
+}
+
+func (handler KafkaHandler) stop() {
+	handler.publisher.stop()
+}
+
+func (handler KafkaHandler) Alert(datacenter string, alert *AlertState) error {
+	payload, err := json.Marshal(alertPayload{datacenter, alert})
+	if err != nil {
+		log.Errorf("Error encoding alert for Kafka: %s", err)
+		return err
+	}
+
+	return handler.publisher.publish(handler.Topic, alertIncidentKey(datacenter, alert), payload)
+}
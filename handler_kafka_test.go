@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHandler_kafka(t *testing.T) {
+	broker := newTestKafkaBroker(t)
+	defer broker.listener.Close()
+
+	handler := KafkaHandler{Brokers: []string{broker.listener.Addr().String()}, Topic: "alerts"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Service: "redis"})
+
+	select {
+	case batch := <-broker.produced:
+		// The value is the last field before the (empty) record headers
+		var payload map[string]interface{}
+		start := len(batch) - 1
+		for ; start > 0 && batch[start] != '{'; start-- {
+		}
+		if err := json.Unmarshal(batch[start:len(batch)-1], &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload["datacenter"] != "dc1" || payload["service"] != "redis" {
+			t.Errorf("unexpected payload: %v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the alert to be produced")
+	}
+}
+
+func TestHandler_kafkaInvalid(t *testing.T) {
+	cases := []KafkaHandler{
+		{Topic: "alerts"},
+		{Brokers: []string{"localhost:9092"}},
+		{Brokers: []string{"localhost:9092"}, Topic: "alerts", SASLMechanism: "GSSAPI"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
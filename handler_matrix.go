@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Makes each message's transaction ID unique within this process
+var matrixTxnCounter uint64
+
+// MatrixHandler posts alerts as formatted messages to a Matrix room
+type MatrixHandler struct {
+	HomeserverURL string `mapstructure:"homeserver_url"`
+	AccessToken   string `mapstructure:"access_token"`
+	RoomID        string `mapstructure:"room_id"`
+	MaxRetries    int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// An m.room.message event with an HTML body
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+func (handler *MatrixHandler) validate() error {
+	if handler.HomeserverURL == "" || handler.AccessToken == "" || handler.RoomID == "" {
+		return fmt.Errorf("homeserver_url, access_token and room_id must be set")
+	}
+
+	handler.HomeserverURL = strings.TrimSuffix(handler.HomeserverURL, "/")
+	return nil
+}
+
+func (handler MatrixHandler) Alert(datacenter string, alert *AlertState) error {
+	message := matrixAlertMessage(alert)
+	headers := map[string]string{"Authorization": "Bearer " + handler.AccessToken}
+
+	// Retries reuse the transaction ID, so the homeserver deduplicates them if an
+	// earlier attempt got through
+	txnID := fmt.Sprintf("consul-alerting-%d-%d", time.Now().UnixNano(), atomic.AddUint64(&matrixTxnCounter, 1))
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		handler.HomeserverURL, url.PathEscape(handler.RoomID), txnID)
+
+	return retryAlert(alert, handler.MaxRetries, "Matrix room "+handler.RoomID, func() error {
+		_, err := handler.sendJSON("PUT", endpoint, headers, message)
+		return err
+	})
+}
+
+// Formats an alert as plain text and HTML, with the message colored by status
+func matrixAlertMessage(alert *AlertState) matrixMessage {
+	text := alert.Message
+	formatted := fmt.Sprintf(`<p><font color="#%06X"><strong>%s</strong></font></p>`,
+		alertStatusColor(alert.Status), html.EscapeString(text))
+
+	if alert.Details != "" {
+		text += "\n" + alert.Details
+		formatted += "<pre><code>" + html.EscapeString(alert.Details) + "</code></pre>"
+	}
+
+	return matrixMessage{
+		MsgType:       "m.text",
+		Body:          text,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formatted,
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_matrix(t *testing.T) {
+	var message matrixMessage
+	var path, auth, method string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+		path, auth, method = r.URL.EscapedPath(), r.Header.Get("Authorization"), r.Method
+		w.Write([]byte(`{"event_id": "$abc"}`))
+	}))
+	defer server.Close()
+
+	handler := MatrixHandler{HomeserverURL: server.URL, AccessToken: "token", RoomID: "!room:example.org"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: api.HealthCritical, Message: "[dc1] redis is critical", Details: "<error>"})
+
+	if method != "PUT" || auth != "Bearer token" {
+		t.Errorf("unexpected method %s or auth %s", method, auth)
+	}
+	if !strings.HasPrefix(path, "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/consul-alerting-") {
+		t.Errorf("unexpected path: %s", path)
+	}
+	if message.Body != "[dc1] redis is critical\n<error>" {
+		t.Errorf("unexpected body: %s", message.Body)
+	}
+	if !strings.Contains(message.FormattedBody, "<pre><code>&lt;error&gt;</code></pre>") {
+		t.Errorf("expected escaped details in the formatted body, got %s", message.FormattedBody)
+	}
+}
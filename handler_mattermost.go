@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"text/template"
+
+	"github.com/nlopes/slack"
+)
+
+// Mattermost limits attachment text to this many characters
+const mattermostTextLimit = 16000
+
+// MattermostHandler posts alerts to a Mattermost incoming webhook, formatted the same
+// way as the Slack handler
+type MattermostHandler struct {
+	WebhookURL string       `mapstructure:"webhook_url"`
+	Channel    string       `mapstructure:"channel"`
+	Username   string       `mapstructure:"username"`
+	IconURL    string       `mapstructure:"icon_url"`
+	MaxRetries int          `mapstructure:"max_retries"`
+	Fields     []SlackField `mapstructure:"fields"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	// The parsed title/value templates for each entry in Fields
+	fieldTemplates [][2]*template.Template
+}
+
+// The body of a Mattermost incoming webhook request
+type mattermostMessage struct {
+	Channel     string                 `json:"channel,omitempty"`
+	Username    string                 `json:"username,omitempty"`
+	IconURL     string                 `json:"icon_url,omitempty"`
+	Attachments []mattermostAttachment `json:"attachments"`
+}
+
+type mattermostAttachment struct {
+	Fallback string                  `json:"fallback"`
+	Color    string                  `json:"color"`
+	Text     string                  `json:"text"`
+	Fields   []slack.AttachmentField `json:"fields,omitempty"`
+	Footer   string                  `json:"footer"`
+}
+
+func (handler *MattermostHandler) validate() error {
+	if handler.WebhookURL == "" {
+		return fmt.Errorf("webhook_url must be set")
+	}
+
+	templates, err := compileSlackFields(handler.Fields)
+	handler.fieldTemplates = templates
+	return err
+}
+
+func (handler MattermostHandler) Alert(datacenter string, alert *AlertState) error {
+	details := alert.Details
+	if len(details) > mattermostTextLimit {
+		details = truncateDetails(details, mattermostTextLimit) + "\n(output truncated)"
+	}
+
+	message := mattermostMessage{
+		Channel:  handler.Channel,
+		Username: handler.Username,
+		IconURL:  handler.IconURL,
+		Attachments: []mattermostAttachment{{
+			Fallback: alert.Message,
+			Color:    fmt.Sprintf("#%06X", alertStatusColor(alert.Status)),
+			Text:     fmt.Sprintf(slackMessageFormat, alert.Message, details),
+			Fields:   renderSlackFields(handler.Fields, handler.fieldTemplates, datacenter, alert),
+			Footer:   "consul-alerting",
+		}},
+	}
+
+	return retryAlert(alert, handler.MaxRetries, "Mattermost", func() error {
+		_, err := handler.sendJSON("POST", handler.WebhookURL, nil, message)
+		return err
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_mattermost(t *testing.T) {
+	var message mattermostMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	handler := MattermostHandler{
+		WebhookURL: server.URL,
+		Channel:    "ops",
+		Fields:     []SlackField{{Title: "Service", Value: "{{.Service}} in {{.Datacenter}}", Short: true}},
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: api.HealthWarning, Service: "redis", Message: "redis is warning"})
+
+	if message.Channel != "ops" || len(message.Attachments) != 1 {
+		t.Fatalf("unexpected message: %+v", message)
+	}
+
+	attachment := message.Attachments[0]
+	if attachment.Color != "#DAA038" {
+		t.Errorf("expected warning color, got %s", attachment.Color)
+	}
+	if len(attachment.Fields) != 1 || attachment.Fields[0].Value != "redis in dc1" || !attachment.Fields[0].Short {
+		t.Errorf("unexpected fields: %+v", attachment.Fields)
+	}
+}
+
+func TestHandler_mattermostInvalidFields(t *testing.T) {
+	handler := MattermostHandler{WebhookURL: "http://localhost", Fields: []SlackField{{Title: "{{.Nope}}"}}}
+	if err := handler.validate(); err == nil {
+		t.Error("expected an error for an invalid field template")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MQTTHandler publishes each alert transition as a JSON message to an MQTT broker
+type MQTTHandler struct {
+	Brokers  []string `mapstructure:"brokers"`
+	Topic    string   `mapstructure:"topic"`
+	ClientID string   `mapstructure:"client_id"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	QoS      int      `mapstructure:"qos"`
+	Retain   bool     `mapstructure:"retain"`
+
+	TLSOptions `mapstructure:",squash"`
+	BusOptions `mapstructure:",squash"`
+
+	topicTemplate *template.Template
+	publisher     *busPublisher
+}
+
+func (handler *MQTTHandler) validate() error {
+	if len(handler.Brokers) == 0 {
+		return fmt.Errorf("at least one broker must be set in brokers")
+	}
+	for _, broker := range handler.Brokers {
+		if _, _, err := mqttBrokerAddress(broker); err != nil {
+			return fmt.Errorf("invalid broker %s: %s", broker, err)
+		}
+	}
+	if handler.QoS != 0 && handler.QoS != 1 {
+		return fmt.Errorf("qos must be 0 or 1")
+	}
+
+	tmpl, err := compileAlertTemplate("topic", handler.Topic)
+	if err != nil {
+		return err
+	}
+	handler.topicTemplate = tmpl
+
+	tlsConfig, err := handler.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	config := &mqttConfig{
+		brokers:   handler.Brokers,
+		clientID:  handler.ClientID,
+		username:  handler.Username,
+		password:  handler.Password,
+		qos:       handler.QoS,
+		retain:    handler.Retain,
+		tlsConfig: tlsConfig,
+	}
+	handler.publisher = newBusPublisher("mqtt broker "+handler.Brokers[0], func() (busConn, error) {
+		return dialMQTT(config)
+	}, handler.BusOptions)
+
+	return nil
+}
+
+func (handler MQTTHandler) stop() {
+	handler.publisher.stop()
+}
+
+func (handler MQTTHandler) Alert(datacenter string, alert *AlertState) error {
+	topic, err := renderAlertTemplate(handler.topicTemplate, datacenter, alert)
+	if err != nil {
+		log.Errorf("Error rendering MQTT topic: %s", err)
+		return err
+	}
+
+	payload, err := json.Marshal(alertPayload{datacenter, alert})
+	if err != nil {
+		log.Errorf("Error encoding alert for MQTT: %s", err)
+		return err
+	}
+
+	return handler.publisher.publish(topic, alertIncidentKey(datacenter, alert), payload)
+}
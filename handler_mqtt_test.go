@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandler_mqtt(t *testing.T) {
+	broker := newTestMQTTBroker(t)
+	defer broker.listener.Close()
+
+	handler := MQTTHandler{
+		Brokers:  []string{broker.listener.Addr().String()},
+		Topic:    "consul/alerts/{{.Datacenter}}/{{.Service}}",
+		ClientID: "test",
+		QoS:      1,
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Service: "redis"})
+
+	select {
+	case message := <-broker.published:
+		if message.topic != "consul/alerts/dc1/redis" {
+			t.Errorf("unexpected topic: %s", message.topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the alert to be published")
+	}
+}
+
+func TestHandler_mqttInvalid(t *testing.T) {
+	cases := []MQTTHandler{
+		{Topic: "alerts"},
+		{Brokers: []string{"localhost:1883"}, Topic: "alerts", QoS: 2},
+		{Brokers: []string{"localhost:1883"}, Topic: "{{.Nope}}"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The names of Nagios service and host states by exit code, prefixed to plugin output
+var (
+	nagiosServiceStates = []string{"OK", "WARNING", "CRITICAL"}
+	nagiosHostStates    = []string{"UP", "DOWN"}
+)
+
+// NagiosHandler submits alerts as passive check results to Nagios or Icinga, either
+// through the external command file or the Icinga 2 API
+type NagiosHandler struct {
+	CommandFile   string `mapstructure:"command_file"`
+	APIURL        string `mapstructure:"api_url"`
+	Username      string `mapstructure:"username"`
+	Password      string `mapstructure:"password"`
+	TLSCAFile     string `mapstructure:"tls_ca_file"`
+	TLSSkipVerify bool   `mapstructure:"tls_skip_verify"`
+	Host          string `mapstructure:"host"`
+	DefaultHost   string `mapstructure:"default_host"`
+	Service       string `mapstructure:"service"`
+	CheckSource   string `mapstructure:"check_source"`
+	MaxRetries    int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	hostTemplate    *template.Template
+	serviceTemplate *template.Template
+}
+
+// The body of an Icinga 2 process-check-result action
+type icingaCheckResult struct {
+	Type         string            `json:"type"`
+	Filter       string            `json:"filter"`
+	FilterVars   map[string]string `json:"filter_vars"`
+	ExitStatus   int               `json:"exit_status"`
+	PluginOutput string            `json:"plugin_output"`
+	CheckSource  string            `json:"check_source,omitempty"`
+}
+
+func (handler *NagiosHandler) validate() error {
+	if (handler.CommandFile == "") == (handler.APIURL == "") {
+		return fmt.Errorf("exactly one of command_file and api_url must be set")
+	}
+	if handler.APIURL != "" {
+		if parsed, err := url.Parse(handler.APIURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("api_url must be an http or https URL")
+		}
+		if (handler.Username == "") != (handler.Password == "") {
+			return fmt.Errorf("username and password must be set together")
+		}
+		handler.APIURL = strings.TrimSuffix(handler.APIURL, "/")
+	}
+
+	// Icinga 2 serves its API with a certificate signed by its own CA
+	if handler.TLSCAFile != "" || handler.TLSSkipVerify {
+		tlsConfig, err := TLSOptions{TLS: true, TLSCAFile: handler.TLSCAFile, TLSSkipVerify: handler.TLSSkipVerify}.tlsConfig()
+		if err != nil {
+			return err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if handler.client != nil {
+			transport = handler.client.Transport.(*http.Transport)
+		}
+		transport.TLSClientConfig = tlsConfig
+		handler.client = &http.Client{Timeout: handlerHTTPTimeout, Transport: transport}
+	}
+
+	var err error
+	if handler.hostTemplate, err = compileAlertTemplate("host", handler.Host); err != nil {
+		return err
+	}
+	handler.serviceTemplate, err = compileAlertTemplate("service", handler.Service)
+	return err
+}
+
+func (handler NagiosHandler) Alert(datacenter string, alert *AlertState) error {
+	host := renderAlertTemplateOr(handler.hostTemplate, datacenter, alert, "")
+	if host == "" {
+		host = handler.DefaultHost
+	}
+	if host == "" {
+		return fmt.Errorf("no Nagios host for alert '%s', set default_host for alerts without a node", alert.Message)
+	}
+	// Alerts that don't render a service, such as node checks, are submitted as host
+	// check results, which only know about UP and DOWN
+	service := renderAlertTemplateOr(handler.serviceTemplate, datacenter, alert, "")
+	code := alertStatusCode(alert.Status)
+	state := nagiosServiceStates[code]
+	if service == "" {
+		if alert.Status != api.HealthPassing {
+			code = 1
+		}
+		state = nagiosHostStates[code]
+	}
+
+	output := fmt.Sprintf("%s - %s", state, alert.Message)
+	if alert.Details != "" {
+		output += "\n" + alert.Details
+	}
+
+	if handler.APIURL != "" {
+		return retryAlert(alert, handler.MaxRetries, "Icinga API", func() error {
+			return handler.submitAPI(host, service, code, output)
+		})
+	}
+	return retryAlert(alert, handler.MaxRetries, "Nagios command file", func() error {
+		return handler.submitCommand(host, service, code, output)
+	})
+}
+
+// Writes a check result to the external command file. Lines after the first are
+// escaped, as Nagios reads the rest of the output as long plugin output.
+func (handler NagiosHandler) submitCommand(host string, service string, code int, output string) error {
+	output = strings.Replace(output, "\n", `\n`, -1)
+	var command string
+	if service == "" {
+		command = fmt.Sprintf("[%d] PROCESS_HOST_CHECK_RESULT;%s;%d;%s\n", time.Now().Unix(), host, code, output)
+	} else {
+		command = fmt.Sprintf("[%d] PROCESS_SERVICE_CHECK_RESULT;%s;%s;%d;%s\n", time.Now().Unix(), host, service, code, output)
+	}
+
+	// The command file is a named pipe, so open it without blocking to fail fast when
+	// Nagios isn't reading from it, and without creating it when it's missing
+	file, err := os.OpenFile(handler.CommandFile, os.O_WRONLY|os.O_APPEND|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(command)
+	return err
+}
+
+func (handler NagiosHandler) submitAPI(host string, service string, code int, output string) error {
+	result := icingaCheckResult{
+		Type:         "Host",
+		Filter:       "host.name == host",
+		FilterVars:   map[string]string{"host": host},
+		ExitStatus:   code,
+		PluginOutput: output,
+		CheckSource:  handler.CheckSource,
+	}
+	if service != "" {
+		result.Type = "Service"
+		result.Filter = "host.name == host && service.name == service"
+		result.FilterVars["service"] = service
+	}
+
+	headers := map[string]string{"Accept": "application/json"}
+	if handler.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(handler.Username + ":" + handler.Password))
+		headers["Authorization"] = "Basic " + credentials
+	}
+	_, err := handler.sendJSON("POST", handler.APIURL+"/v1/actions/process-check-result", headers, result)
+	return err
+}
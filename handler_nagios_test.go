@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_nagiosCommandFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nagios.cmd")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseConfig(`
+handler "nagios" "noc" {
+  command_file = "` + path + `"
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := config.Handlers["nagios.noc"]
+
+	alerts := []*AlertState{
+		{Status: api.HealthWarning, Node: "web1", Service: "redis", Message: "redis is warning", Details: "slow\nresponses"},
+		{Status: api.HealthCritical, Node: "web1", Message: "web1 is critical"},
+	}
+	for _, alert := range alerts {
+		if err := handler.Alert("dc1", alert); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 commands, got %q", contents)
+	}
+	if !strings.HasSuffix(lines[0], `] PROCESS_SERVICE_CHECK_RESULT;web1;redis;1;WARNING - redis is warning\nslow\nresponses`) {
+		t.Errorf("unexpected service command: %s", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "] PROCESS_HOST_CHECK_RESULT;web1;1;DOWN - web1 is critical") {
+		t.Errorf("unexpected host command: %s", lines[1])
+	}
+}
+
+func TestHandler_nagiosIcinga(t *testing.T) {
+	var user, password string
+	var results []icingaCheckResult
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/actions/process-check-result" {
+			t.Errorf("unexpected request path: %s", r.URL.Path)
+		}
+		user, password, _ = r.BasicAuth()
+		var result icingaCheckResult
+		json.NewDecoder(r.Body).Decode(&result)
+		results = append(results, result)
+	}))
+	defer server.Close()
+
+	handler := NagiosHandler{
+		APIURL:      server.URL + "/",
+		Username:    "consul",
+		Password:    "secret",
+		Host:        "{{.Node}}",
+		DefaultHost: "consul",
+		Service:     "{{.Service}}",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: api.HealthCritical, Node: "web1", Service: "redis", Message: "redis is critical"}
+	if err := handler.Alert("dc1", alert); err != nil {
+		t.Fatal(err)
+	}
+	if user != "consul" || password != "secret" {
+		t.Errorf("expected basic auth, got %q:%q", user, password)
+	}
+	result := results[0]
+	if result.Type != "Service" || result.ExitStatus != 2 || result.PluginOutput != "CRITICAL - redis is critical" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.FilterVars["host"] != "web1" || result.FilterVars["service"] != "redis" {
+		t.Errorf("unexpected filter vars: %v", result.FilterVars)
+	}
+
+	// Node checks with no node go to the default host
+	alert = &AlertState{Status: api.HealthPassing, Message: "consul is passing"}
+	handler.Alert("dc1", alert)
+	if results[1].Type != "Host" || results[1].ExitStatus != 0 || results[1].FilterVars["host"] != "consul" {
+		t.Errorf("unexpected host result: %+v", results[1])
+	}
+}
+
+func TestHandler_nagiosInvalid(t *testing.T) {
+	cases := []NagiosHandler{
+		{},
+		{CommandFile: "/var/lib/nagios3/rw/nagios.cmd", APIURL: "https://icinga.example.com:5665"},
+		{APIURL: "icinga.example.com:5665"},
+		{APIURL: "https://icinga.example.com:5665", Username: "consul"},
+		{CommandFile: "/var/lib/nagios3/rw/nagios.cmd", Service: "{{.Missing}}"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
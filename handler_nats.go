@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NATSHandler publishes each alert transition as a JSON message to a NATS subject
+type NATSHandler struct {
+	Servers   []string `mapstructure:"servers"`
+	Subject   string   `mapstructure:"subject"`
+	Name      string   `mapstructure:"name"`
+	Username  string   `mapstructure:"username"`
+	Password  string   `mapstructure:"password"`
+	Token     string   `mapstructure:"token"`
+	JetStream bool     `mapstructure:"jetstream"`
+
+	TLSOptions `mapstructure:",squash"`
+	BusOptions `mapstructure:",squash"`
+
+	publisher *busPublisher
+}
+
+func (handler *NATSHandler) validate() error {
+	if len(handler.Servers) == 0 {
+		return fmt.Errorf("at least one server must be set in servers")
+	}
+	if handler.Subject == "" {
+		return fmt.Errorf("subject must be set")
+	}
+	for _, server := range handler.Servers {
+		if _, _, err := natsServerAddress(server); err != nil {
+			return fmt.Errorf("invalid server %s: %s", server, err)
+		}
+	}
+
+	tlsConfig, err := handler.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	config := &natsConfig{
+		servers:   handler.Servers,
+		name:      handler.Name,
+		username:  handler.Username,
+		password:  handler.Password,
+		token:     handler.Token,
+		jetstream: handler.JetStream,
+		tlsConfig: tlsConfig,
+	}
+	handler.publisher = newBusPublisher("nats subject "+handler.Subject, func() (busConn, error) {
+		return dialNATS(config)
+	}, handler.BusOptions)
+
+	return nil
+}
+
+func (handler NATSHandler) stop() {
+	handler.publisher.stop()
+}
+
+func (handler NATSHandler) Alert(datacenter string, alert *AlertState) error {
+	payload, err := json.Marshal(alertPayload{datacenter, alert})
+	if err != nil {
+		log.Errorf("Error encoding alert for NATS: %s", err)
+		return err
+	}
+
+	return handler.publisher.publish(handler.Subject, alertIncidentKey(datacenter, alert), payload)
+}
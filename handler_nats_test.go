@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHandler_nats(t *testing.T) {
+	server := newTestNATSServer(t, "")
+	defer server.listener.Close()
+
+	handler := NATSHandler{Servers: []string{server.listener.Addr().String()}, Subject: "consul.alerts"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Service: "redis"})
+
+	select {
+	case message := <-server.published:
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(message.payload), &payload); err != nil {
+			t.Fatal(err)
+		}
+		if message.subject != "consul.alerts" || payload["service"] != "redis" {
+			t.Errorf("unexpected message: %+v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the alert to be published")
+	}
+}
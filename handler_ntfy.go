@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The default ntfy server
+const ntfyServerURL = "https://ntfy.sh"
+
+// ntfy limits messages to this many bytes, sending anything longer as an attachment
+const ntfyMessageLimit = 4096
+
+// The default ntfy priority for each status, from 1 (min) to 5 (urgent)
+var ntfyPriorities = map[string]int{
+	api.HealthCritical: 5,
+	api.HealthWarning:  4,
+	api.HealthPassing:  3,
+}
+
+// The tag (shown as an emoji) added to the notifications of each status
+var ntfyStatusTags = map[string]string{
+	api.HealthCritical: "rotating_light",
+	api.HealthWarning:  "warning",
+	api.HealthPassing:  "white_check_mark",
+}
+
+// NtfyHandler publishes alerts to a topic on ntfy.sh or a self-hosted ntfy server
+type NtfyHandler struct {
+	ServerURL  string         `mapstructure:"server_url"`
+	Topic      string         `mapstructure:"topic"`
+	Token      string         `mapstructure:"token"`
+	Username   string         `mapstructure:"username"`
+	Password   string         `mapstructure:"password"`
+	Priorities map[string]int `mapstructure:"priorities"`
+	Tags       []string       `mapstructure:"tags"`
+	MaxRetries int            `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// The body of a JSON publish request
+type ntfyMessage struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title"`
+	Message  string   `json:"message"`
+	Priority int      `json:"priority"`
+	Tags     []string `json:"tags"`
+	Click    string   `json:"click,omitempty"`
+}
+
+func (handler *NtfyHandler) validate() error {
+	if handler.Topic == "" {
+		return fmt.Errorf("topic must be set")
+	}
+	if handler.Token != "" && handler.Username != "" {
+		return fmt.Errorf("only one of token and username can be set")
+	}
+	if (handler.Username == "") != (handler.Password == "") {
+		return fmt.Errorf("username and password must be set together")
+	}
+	for status, priority := range handler.Priorities {
+		if _, ok := ntfyPriorities[status]; !ok {
+			return fmt.Errorf("invalid status %q in priorities", status)
+		}
+		if priority < 1 || priority > 5 {
+			return fmt.Errorf("priority for %s must be between 1 and 5, got %d", status, priority)
+		}
+	}
+
+	handler.ServerURL = strings.TrimSuffix(handler.ServerURL, "/")
+	return nil
+}
+
+func (handler NtfyHandler) Alert(datacenter string, alert *AlertState) error {
+	priority, ok := handler.Priorities[alert.Status]
+	if !ok {
+		priority = ntfyPriorities[alert.Status]
+	}
+	if priority == 0 {
+		priority = ntfyPriorities[api.HealthCritical]
+	}
+
+	// Tags that aren't emoji shortcodes are listed below the notification, so they
+	// can be used to tell the service and datacenter apart at a glance
+	tags := []string{ntfyStatusTags[alert.Status], "dc:" + datacenter}
+	if tags[0] == "" {
+		tags[0] = ntfyStatusTags[api.HealthCritical]
+	}
+	if alert.Service != "" {
+		tags = append(tags, "service:"+alert.Service)
+	}
+	tags = append(tags, handler.Tags...)
+
+	message := ntfyMessage{
+		Topic:    handler.Topic,
+		Title:    alert.Message,
+		Message:  truncateDetails(pushoverMessage(alert), ntfyMessageLimit),
+		Priority: priority,
+		Tags:     tags,
+		Click:    alert.ConsulURL,
+	}
+
+	headers := map[string]string{}
+	if handler.Token != "" {
+		headers["Authorization"] = "Bearer " + handler.Token
+	} else if handler.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(handler.Username + ":" + handler.Password))
+		headers["Authorization"] = "Basic " + credentials
+	}
+	return retryAlert(alert, handler.MaxRetries, "ntfy topic "+handler.Topic, func() error {
+		_, err := handler.sendJSON("POST", handler.ServerURL, headers, message)
+		return err
+	})
+}
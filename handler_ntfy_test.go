@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_ntfy(t *testing.T) {
+	var auth string
+	var messages []ntfyMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		var message ntfyMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		messages = append(messages, message)
+	}))
+	defer server.Close()
+
+	config, err := ParseConfig(`
+handler "ntfy" "phone" {
+  server_url = "` + server.URL + `/"
+  topic = "consul-alerts"
+  token = "tk_secret"
+  tags = ["homelab"]
+  priorities {
+    warning = 2
+  }
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := config.Handlers["ntfy.phone"]
+
+	alert := &AlertState{Status: api.HealthCritical, Service: "redis", Message: "redis is critical", Details: "connection refused"}
+	if err := handler.Alert("dc1", alert); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer tk_secret" {
+		t.Errorf("expected the token to be sent, got %q", auth)
+	}
+	message := messages[0]
+	if message.Topic != "consul-alerts" || message.Title != "redis is critical" || message.Message != "connection refused" || message.Priority != 5 {
+		t.Errorf("unexpected message: %+v", message)
+	}
+	expectedTags := []string{"rotating_light", "dc:dc1", "service:redis", "homelab"}
+	if len(message.Tags) != len(expectedTags) {
+		t.Fatalf("expected tags %v, got %v", expectedTags, message.Tags)
+	}
+	for i, tag := range expectedTags {
+		if message.Tags[i] != tag {
+			t.Errorf("expected tags %v, got %v", expectedTags, message.Tags)
+		}
+	}
+
+	alert.Status = api.HealthWarning
+	handler.Alert("dc1", alert)
+	if messages[1].Priority != 2 || messages[1].Tags[0] != "warning" {
+		t.Errorf("expected the configured warning priority, got %+v", messages[1])
+	}
+}
+
+func TestHandler_ntfyInvalid(t *testing.T) {
+	cases := []NtfyHandler{
+		{},
+		{Topic: "alerts", Token: "tk", Username: "user", Password: "pass"},
+		{Topic: "alerts", Username: "user"},
+		{Topic: "alerts", Priorities: map[string]int{"critical": 6}},
+		{Topic: "alerts", Priorities: map[string]int{"down": 5}},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
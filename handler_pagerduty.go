@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const pagerdutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// A link to add to PagerDuty incidents, with its href and text given as templates
+type PagerdutyLink struct {
+	Href string `mapstructure:"href"`
+	Text string `mapstructure:"text"`
+}
+
+// An integration key to send the alerts of some services to instead of the handler's own,
+// so each team's escalation policy receives its own alerts. Every set condition must match;
+// the first matching entry picks the key.
+type PagerdutyKey struct {
+	Service string   `mapstructure:"service"`
+	Tags    []string `mapstructure:"tags"`
+	Key     string   `mapstructure:"key"`
+
+	serviceRegexp *regexp.Regexp
+}
+
+func (key *PagerdutyKey) validate() error {
+	if key.Key == "" {
+		return fmt.Errorf("key must be set")
+	}
+
+	var err error
+	if key.serviceRegexp, err = compileRouteRegexp(key.Service); err != nil {
+		return fmt.Errorf("invalid service pattern: %s", err)
+	}
+	return nil
+}
+
+func (key *PagerdutyKey) matches(alert *AlertState) bool {
+	if key.serviceRegexp != nil && !key.serviceRegexp.MatchString(alert.Service) {
+		return false
+	}
+	if len(key.Tags) > 0 && !contains(key.Tags, alert.Tag) {
+		return false
+	}
+	return true
+}
+
+// Returns the integration key to send an alert with: the first matching entry of keys, or
+// the handler's routing_key (or service_key if using the v1 API)
+func (handler PagerdutyHandler) alertKey(alert *AlertState) string {
+	for i := range handler.Keys {
+		if handler.Keys[i].matches(alert) {
+			return handler.Keys[i].Key
+		}
+	}
+	if handler.RoutingKey != "" {
+		return handler.RoutingKey
+	}
+	return handler.ServiceKey
+}
+
+// Returns the key of the incident an alert opens or resolves: the handler's incident_key
+// template if it's set and renders a key, otherwise the alert's default incident key
+func (handler PagerdutyHandler) incidentKey(datacenter string, alert *AlertState) string {
+	defaultKey := alertIncidentKey(datacenter, alert)
+	if key := renderAlertTemplateOr(handler.incidentKeyTemplate, datacenter, alert, defaultKey); key != "" {
+		return key
+	}
+	return defaultKey
+}
+
+// The body accepted by the PagerDuty Events API v2
+type pagerdutyEvent struct {
+	RoutingKey  string               `json:"routing_key"`
+	EventAction string               `json:"event_action"`
+	DedupKey    string               `json:"dedup_key"`
+	Payload     *pagerdutyPayload    `json:"payload,omitempty"`
+	Links       []pagerdutyEventLink `json:"links,omitempty"`
+}
+
+type pagerdutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     string                 `json:"timestamp"`
+	Component     string                 `json:"component,omitempty"`
+	Group         string                 `json:"group,omitempty"`
+	Class         string                 `json:"class,omitempty"`
+	CustomDetails map[string]interface{} `json:"custom_details"`
+}
+
+type pagerdutyEventLink struct {
+	Href string `json:"href"`
+	Text string `json:"text,omitempty"`
+}
+
+// Parses the templates used by Events API v2 events
+func (handler *PagerdutyHandler) compileEventTemplates() error {
+	var err error
+	if handler.Component != "" {
+		if handler.componentTemplate, err = compileAlertTemplate("component", handler.Component); err != nil {
+			return err
+		}
+	}
+	if handler.IncidentKey != "" {
+		if handler.incidentKeyTemplate, err = compileAlertTemplate("incident_key", handler.IncidentKey); err != nil {
+			return err
+		}
+	}
+	if handler.Group != "" {
+		if handler.groupTemplate, err = compileAlertTemplate("group", handler.Group); err != nil {
+			return err
+		}
+	}
+
+	handler.linkTemplates = nil
+	for i, link := range handler.Links {
+		href, err := compileAlertTemplate(fmt.Sprintf("links[%d].href", i), link.Href)
+		if err != nil {
+			return err
+		}
+		text, err := compileAlertTemplate(fmt.Sprintf("links[%d].text", i), link.Text)
+		if err != nil {
+			return err
+		}
+		handler.linkTemplates = append(handler.linkTemplates, [2]*template.Template{href, text})
+	}
+	return nil
+}
+
+// The severities accepted by the Events API v2
+var pagerdutySeverities = []string{"critical", "error", "warning", "info"}
+
+// Makes sure a severity mapping only maps alerting statuses to PagerDuty severities
+func validatePagerdutySeverities(severities map[string]string) error {
+	for status, severity := range severities {
+		if status != api.HealthWarning && status != api.HealthCritical {
+			return fmt.Errorf("invalid status %s in severities", status)
+		}
+		if !contains(pagerdutySeverities, severity) {
+			return fmt.Errorf("invalid PagerDuty severity %s for %s", severity, status)
+		}
+	}
+	return nil
+}
+
+// Returns the PagerDuty severity of an alert: the handler's mapping of its status if it
+// has one, otherwise its severity level or, for alerts without one, its status
+func (handler PagerdutyHandler) severity(alert *AlertState) string {
+	if severity, ok := handler.Severities[alert.Status]; ok {
+		return severity
+	}
+
+	switch alert.Severity {
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+		return alert.Severity
+	}
+	if alert.Status == api.HealthWarning {
+		return SeverityWarning
+	}
+	return SeverityCritical
+}
+
+// Builds the Events API v2 event for an alert. Recoveries resolve the incident and only
+// need its dedup key.
+func (handler PagerdutyHandler) event(datacenter string, alert *AlertState, description string) pagerdutyEvent {
+	event := pagerdutyEvent{
+		RoutingKey:  handler.alertKey(alert),
+		EventAction: "trigger",
+		DedupKey:    handler.incidentKey(datacenter, alert),
+	}
+	if alert.Status == api.HealthPassing {
+		event.EventAction = "resolve"
+		return event
+	}
+
+	source := alert.Node
+	if source == "" {
+		source = datacenter
+	}
+
+	details := map[string]interface{}{
+		"status":     alert.Status,
+		"datacenter": datacenter,
+		"details":    alert.Details,
+	}
+	for key, value := range map[string]string{"service": alert.Service, "tag": alert.Tag, "node": alert.Node, "check": alert.Check} {
+		if value != "" {
+			details[key] = value
+		}
+	}
+	if len(alert.NodeMeta) > 0 {
+		details["node_meta"] = alert.NodeMeta
+	}
+	if len(alert.ServiceMeta) > 0 {
+		details["service_meta"] = alert.ServiceMeta
+	}
+	if len(alert.ServiceTags) > 0 {
+		details["service_tags"] = alert.ServiceTags
+	}
+	if alert.NodeAddress != "" {
+		details["node_address"] = alert.NodeAddress
+	}
+	if len(alert.Checks) > 0 {
+		details["checks"] = alert.Checks
+	}
+	if len(alert.Labels) > 0 {
+		details["labels"] = alert.Labels
+	}
+
+	event.Payload = &pagerdutyPayload{
+		Summary:       truncateDetails(description, 1024),
+		Source:        source,
+		Severity:      handler.severity(alert),
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		Component:     renderAlertTemplateOr(handler.componentTemplate, datacenter, alert, alert.Service),
+		Group:         renderAlertTemplateOr(handler.groupTemplate, datacenter, alert, ""),
+		Class:         handler.EventClass,
+		CustomDetails: details,
+	}
+
+	if alert.ConsulURL != "" {
+		event.Links = append(event.Links, pagerdutyEventLink{Href: alert.ConsulURL, Text: "View in Consul"})
+	}
+	for _, tmpls := range handler.linkTemplates {
+		href := renderAlertTemplateOr(tmpls[0], datacenter, alert, "")
+		if href == "" {
+			continue
+		}
+		event.Links = append(event.Links, pagerdutyEventLink{Href: href, Text: renderAlertTemplateOr(tmpls[1], datacenter, alert, "")})
+	}
+
+	return event
+}
+
+// Sends an alert to the Events API v2
+func (handler PagerdutyHandler) sendEvent(datacenter string, alert *AlertState, description string) error {
+	event := handler.event(datacenter, alert, description)
+	return retryAlert(alert, handler.MaxRetries, "PagerDuty", func() error {
+		_, err := sendJSON("POST", handler.EventsURL, nil, event)
+		return err
+	})
+}
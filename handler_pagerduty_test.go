@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_pagerdutyEvents(t *testing.T) {
+	var received []pagerdutyEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event pagerdutyEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+		received = append(received, event)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	handler := PagerdutyHandler{
+		RoutingKey: "R0UT1NG",
+		EventsURL:  server.URL,
+		Group:      "{{.Datacenter}}-{{.Tag}}",
+		EventClass: "consul",
+		Links:      []PagerdutyLink{{Href: "https://consul.example.com/ui/dc1/services/{{.Service}}", Text: "Consul UI"}},
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: api.HealthWarning, Service: "redis", Tag: "primary", Node: "node1", Message: "redis is warning", Details: "high latency",
+		Checks: []AlertCheck{{Node: "node1", CheckID: "service:redis", Name: "redis", Status: api.HealthWarning, Output: "high latency"}}}
+	handler.Alert("dc1", alert)
+	alert.Status = api.HealthPassing
+	handler.Alert("dc1", alert)
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(received))
+	}
+	trigger, resolve := received[0], received[1]
+	if trigger.EventAction != "trigger" || resolve.EventAction != "resolve" {
+		t.Errorf("expected trigger then resolve, got %s, %s", trigger.EventAction, resolve.EventAction)
+	}
+	if trigger.RoutingKey != "R0UT1NG" || trigger.DedupKey != "dc1-redis-primary-node1" || resolve.DedupKey != trigger.DedupKey {
+		t.Errorf("unexpected keys: %+v, %+v", trigger, resolve)
+	}
+
+	payload := trigger.Payload
+	if payload == nil {
+		t.Fatal("expected a payload on the trigger event")
+	}
+	if payload.Summary != "redis is warning" || payload.Source != "node1" || payload.Severity != "warning" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+	if payload.Component != "redis" || payload.Group != "dc1-primary" || payload.Class != "consul" {
+		t.Errorf("unexpected component/group/class: %+v", payload)
+	}
+	if payload.CustomDetails["details"] != "high latency" || payload.CustomDetails["node"] != "node1" {
+		t.Errorf("unexpected custom details: %v", payload.CustomDetails)
+	}
+	if checks, ok := payload.CustomDetails["checks"].([]interface{}); !ok || len(checks) != 1 || checks[0].(map[string]interface{})["check_id"] != "service:redis" {
+		t.Errorf("expected the failing checks in the custom details, got %v", payload.CustomDetails["checks"])
+	}
+	if len(trigger.Links) != 1 || trigger.Links[0].Href != "https://consul.example.com/ui/dc1/services/redis" {
+		t.Errorf("unexpected links: %+v", trigger.Links)
+	}
+	if resolve.Payload != nil {
+		t.Errorf("expected no payload on the resolve event, got %+v", resolve.Payload)
+	}
+}
+
+func TestHandler_pagerdutyKeys(t *testing.T) {
+	handler := PagerdutyHandler{
+		RoutingKey: "platform",
+		Keys: []PagerdutyKey{
+			{Service: "payments(-.*)?", Key: "payments"},
+			{Tags: []string{"batch"}, Key: "data"},
+		},
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		alert    AlertState
+		expected string
+	}{
+		{AlertState{Service: "payments-api"}, "payments"},
+		{AlertState{Service: "payments", Tag: "batch"}, "payments"},
+		{AlertState{Service: "reports", Tag: "batch"}, "data"},
+		{AlertState{Service: "redis"}, "platform"},
+		{AlertState{Node: "node1"}, "platform"},
+	}
+	for _, c := range cases {
+		if actual := handler.alertKey(&c.alert); actual != c.expected {
+			t.Errorf("expected key %s for %+v, got %s", c.expected, c.alert, actual)
+		}
+	}
+
+	if event := handler.event("dc1", &AlertState{Service: "payments-api", Status: api.HealthCritical}, ""); event.RoutingKey != "payments" {
+		t.Errorf("expected the payments routing key, got %s", event.RoutingKey)
+	}
+
+	v1 := PagerdutyHandler{ServiceKey: "platform", Keys: []PagerdutyKey{{Service: "payments"}}}
+	if err := v1.validate(); err == nil {
+		t.Error("expected an error for an entry without a key")
+	}
+}
+
+func TestHandler_pagerdutySeverity(t *testing.T) {
+	handler := PagerdutyHandler{RoutingKey: "key", Severities: map[string]string{"critical": "error"}}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		alert    AlertState
+		expected string
+	}{
+		{AlertState{Status: api.HealthCritical, Severity: SeverityCritical}, "error"},
+		{AlertState{Status: api.HealthWarning, Severity: SeverityWarning}, "warning"},
+		{AlertState{Status: api.HealthWarning, Severity: SeverityCritical}, "critical"},
+		{AlertState{Status: api.HealthWarning}, "warning"},
+	}
+	for _, c := range cases {
+		if actual := handler.severity(&c.alert); actual != c.expected {
+			t.Errorf("expected severity %s for %+v, got %s", c.expected, c.alert, actual)
+		}
+	}
+
+	for _, severities := range []map[string]string{{"passing": "info"}, {"warning": "high"}} {
+		invalid := PagerdutyHandler{RoutingKey: "key", Severities: severities}
+		if err := invalid.validate(); err == nil {
+			t.Errorf("expected an error for severities %v", severities)
+		}
+	}
+}
+
+func TestHandler_pagerdutyValidate(t *testing.T) {
+	if err := (&PagerdutyHandler{}).validate(); err == nil {
+		t.Error("expected an error without service_key or routing_key")
+	}
+	if err := (&PagerdutyHandler{RoutingKey: "key", Component: "{{.Bogus}}"}).validate(); err == nil {
+		t.Error("expected an error for an invalid component template")
+	}
+	if err := (&PagerdutyHandler{RoutingKey: "key", IncidentKey: "{{.Bogus}}"}).validate(); err == nil {
+		t.Error("expected an error for an invalid incident key template")
+	}
+}
+
+func TestHandler_pagerdutyIncidentKey(t *testing.T) {
+	handler := PagerdutyHandler{RoutingKey: "key", IncidentKey: "{{.Datacenter}}-{{.Service}}"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every node's alert on the service shares its incident
+	node1 := &AlertState{Status: api.HealthCritical, Service: "redis", Tag: "primary", Node: "node1"}
+	node2 := &AlertState{Status: api.HealthCritical, Service: "redis", Node: "node2"}
+	if key := handler.event("dc1", node1, "").DedupKey; key != "dc1-redis" {
+		t.Errorf("expected the templated key, got %q", key)
+	}
+	if handler.incidentKey("dc1", node2) != handler.incidentKey("dc1", node1) {
+		t.Error("expected the alerts of both nodes to share an incident")
+	}
+
+	// Without the template, or when it renders nothing, the default key is kept
+	if key := (PagerdutyHandler{}).incidentKey("dc1", node1); key != "dc1-redis-primary-node1" {
+		t.Errorf("expected the default key, got %q", key)
+	}
+	empty := PagerdutyHandler{RoutingKey: "key", IncidentKey: "{{.Tag}}"}
+	if err := empty.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if key := empty.incidentKey("dc1", node2); key != "dc1-redis--node2" {
+		t.Errorf("expected the default key for an empty render, got %q", key)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The OAuth scope needed for publishing to Pub/Sub
+const pubsubScope = "https://www.googleapis.com/auth/pubsub"
+
+// PubSubHandler publishes alerts as JSON to a Google Cloud Pub/Sub topic
+type PubSubHandler struct {
+	Project         string `mapstructure:"project"`
+	Topic           string `mapstructure:"topic"`
+	CredentialsFile string `mapstructure:"credentials_file"`
+	Endpoint        string `mapstructure:"endpoint"`
+	Ordering        bool   `mapstructure:"ordering"`
+	MaxRetries      int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	tokens *gcpTokenSource
+}
+
+func (handler *PubSubHandler) validate() error {
+	creds, err := loadGCPCredentials(handler.CredentialsFile)
+	if err != nil {
+		return err
+	}
+
+	// The topic can be given in full as projects/<project>/topics/<topic>
+	if parts := strings.Split(handler.Topic, "/"); len(parts) == 4 && parts[0] == "projects" && parts[2] == "topics" {
+		if handler.Project != "" && handler.Project != parts[1] {
+			return fmt.Errorf("topic %s is in a different project than %s", handler.Topic, handler.Project)
+		}
+		handler.Project, handler.Topic = parts[1], parts[3]
+	}
+	if handler.Topic == "" || strings.Contains(handler.Topic, "/") {
+		return fmt.Errorf("invalid topic: %q", handler.Topic)
+	}
+	if handler.Project == "" && creds != nil {
+		handler.Project = creds.ProjectID
+	}
+	if handler.Project == "" {
+		return fmt.Errorf("project must be set")
+	}
+	if handler.Endpoint == "" {
+		handler.Endpoint = "https://pubsub.googleapis.com"
+	}
+	handler.Endpoint = strings.TrimSuffix(handler.Endpoint, "/")
+
+	handler.tokens = &gcpTokenSource{Credentials: creds, Scope: pubsubScope, HTTPOptions: handler.HTTPOptions}
+	return nil
+}
+
+// A Pub/Sub message, with its data base64 encoded
+type pubsubMessage struct {
+	Data        []byte            `json:"data"`
+	Attributes  map[string]string `json:"attributes"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+func (handler PubSubHandler) Alert(datacenter string, alert *AlertState) error {
+	data, err := json.Marshal(alertPayload{datacenter, alert})
+	if err != nil {
+		log.Errorf("Error encoding alert for Pub/Sub: %s", err)
+		return err
+	}
+
+	// The attributes allow filtering subscriptions without decoding the alert
+	message := pubsubMessage{
+		Data: data,
+		Attributes: map[string]string{
+			"datacenter": datacenter,
+			"service":    alert.Service,
+			"node":       alert.Node,
+			"status":     alert.Status,
+		},
+	}
+	if handler.Ordering {
+		message.OrderingKey = alertIncidentKey(datacenter, alert)
+	}
+
+	url := fmt.Sprintf("%s/v1/projects/%s/topics/%s:publish", handler.Endpoint, handler.Project, handler.Topic)
+	return retryAlert(alert, handler.MaxRetries, "Pub/Sub topic "+handler.Topic, func() error {
+		token, err := handler.tokens.get()
+		if err != nil {
+			return err
+		}
+
+		payload := map[string]interface{}{"messages": []pubsubMessage{message}}
+		_, err = handler.sendJSON("POST", url, map[string]string{"Authorization": "Bearer " + token}, payload)
+		return err
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler_pubsub(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenServer := newTestGCPTokenServer(t, key)
+	defer tokenServer.Close()
+
+	var path, auth string
+	var body struct {
+		Messages []pubsubMessage `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"messageIds": ["1"]}`))
+	}))
+	defer server.Close()
+
+	handler := PubSubHandler{
+		Topic:           "projects/alerting-prod/topics/alerts",
+		CredentialsFile: writeTestGCPCredentials(t, key, tokenServer.URL),
+		Endpoint:        server.URL,
+		Ordering:        true,
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: "critical", Service: "redis", Message: "redis is critical"}
+	if err := handler.Alert("dc1", alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v1/projects/alerting-prod/topics/alerts:publish" || auth != "Bearer token1" {
+		t.Errorf("unexpected publish request to %s with %s", path, auth)
+	}
+	if len(body.Messages) != 1 {
+		t.Fatalf("expected one message, got %+v", body)
+	}
+	message := body.Messages[0]
+	if message.Attributes["status"] != "critical" || message.OrderingKey != alertIncidentKey("dc1", alert) {
+		t.Errorf("unexpected message: %+v", message)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(message.Data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["datacenter"] != "dc1" || payload["service"] != "redis" {
+		t.Errorf("unexpected message data: %v", payload)
+	}
+}
+
+func TestHandler_pubsubInvalid(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	credentials := writeTestGCPCredentials(t, key, "http://localhost")
+
+	cases := []PubSubHandler{
+		{CredentialsFile: credentials},
+		{CredentialsFile: credentials, Topic: "projects/alerting-prod/topics/alerts", Project: "other"},
+		{CredentialsFile: credentials, Topic: "alerts/more"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The default base URL of the Pushover api
+const pushoverAPIURL = "https://api.pushover.net"
+
+// Pushover limits titles and messages to these lengths
+const pushoverTitleLimit = 250
+const pushoverMessageLimit = 1024
+
+// PushoverHandler sends alerts as push notifications through Pushover
+type PushoverHandler struct {
+	AppToken   string   `mapstructure:"app_token"`
+	UserKeys   []string `mapstructure:"user_keys"`
+	Priority   int      `mapstructure:"priority"`
+	Sound      string   `mapstructure:"sound"`
+	APIURL     string   `mapstructure:"api_url"`
+	MaxRetries int      `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	// How often (in seconds) to re-notify for emergency priority alerts until they're
+	// acknowledged, and when to give up
+	EmergencyRetry  int `mapstructure:"emergency_retry"`
+	EmergencyExpire int `mapstructure:"emergency_expire"`
+}
+
+func (handler *PushoverHandler) validate() error {
+	if handler.AppToken == "" {
+		return fmt.Errorf("app_token must be set")
+	}
+	if len(handler.UserKeys) == 0 {
+		return fmt.Errorf("at least one key must be set in user_keys")
+	}
+	if handler.Priority < -2 || handler.Priority > 2 {
+		return fmt.Errorf("priority must be between -2 and 2, got %d", handler.Priority)
+	}
+	if handler.Priority == 2 && handler.EmergencyRetry < 30 {
+		return fmt.Errorf("emergency_retry must be at least 30 seconds")
+	}
+
+	handler.APIURL = strings.TrimSuffix(handler.APIURL, "/")
+	return nil
+}
+
+func (handler PushoverHandler) Alert(datacenter string, alert *AlertState) error {
+	form := url.Values{}
+	form.Set("token", handler.AppToken)
+	form.Set("title", truncateDetails(alert.Message, pushoverTitleLimit))
+	form.Set("message", truncateDetails(pushoverMessage(alert), pushoverMessageLimit))
+	if handler.Sound != "" {
+		form.Set("sound", handler.Sound)
+	}
+
+	// Recoveries don't need to wake anyone up, so never send them above normal priority
+	priority := handler.Priority
+	if alert.Status == api.HealthPassing && priority > 0 {
+		priority = 0
+	}
+	form.Set("priority", strconv.Itoa(priority))
+	if priority == 2 {
+		form.Set("retry", strconv.Itoa(handler.EmergencyRetry))
+		form.Set("expire", strconv.Itoa(handler.EmergencyExpire))
+	}
+
+	var failed error
+	for _, user := range handler.UserKeys {
+		form.Set("user", user)
+		body := form.Encode()
+
+		err := retryAlert(alert, handler.MaxRetries, "Pushover", func() error {
+			req, err := http.NewRequest("POST", handler.APIURL+"/1/messages.json", strings.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			_, err = handler.doRequest(req)
+			return err
+		})
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// Returns the body of the notification; Pushover requires it to be non-empty
+func pushoverMessage(alert *AlertState) string {
+	if alert.Details != "" {
+		return alert.Details
+	}
+	return alert.Message
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_pushover(t *testing.T) {
+	var forms []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/messages.json" {
+			t.Errorf("unexpected request path: %s", r.URL.Path)
+		}
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+	}))
+	defer server.Close()
+
+	handler := PushoverHandler{
+		AppToken:        "app",
+		UserKeys:        []string{"user1", "user2"},
+		Priority:        2,
+		EmergencyRetry:  60,
+		EmergencyExpire: 3600,
+		APIURL:          server.URL,
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: api.HealthCritical, Message: "[dc1] redis is critical", Details: "connection refused"})
+	if len(forms) != 2 || forms[0].Get("user") != "user1" || forms[1].Get("user") != "user2" {
+		t.Fatalf("expected a notification per user, got %v", forms)
+	}
+	if forms[0].Get("priority") != "2" || forms[0].Get("retry") != "60" || forms[0].Get("expire") != "3600" {
+		t.Errorf("expected emergency priority settings, got %v", forms[0])
+	}
+	if forms[0].Get("title") != "[dc1] redis is critical" || forms[0].Get("message") != "connection refused" {
+		t.Errorf("unexpected title/message: %v", forms[0])
+	}
+
+	forms = nil
+	handler.Alert("dc1", &AlertState{Status: api.HealthPassing, Message: "[dc1] redis is passing"})
+	if forms[0].Get("priority") != "0" || forms[0].Get("retry") != "" {
+		t.Errorf("expected recoveries to be sent at normal priority, got %v", forms[0])
+	}
+}
+
+func TestHandler_pushoverInvalid(t *testing.T) {
+	cases := []PushoverHandler{
+		{UserKeys: []string{"user"}},
+		{AppToken: "app"},
+		{AppToken: "app", UserKeys: []string{"user"}, Priority: 3},
+		{AppToken: "app", UserKeys: []string{"user"}, Priority: 2, EmergencyRetry: 10},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
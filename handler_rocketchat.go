@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"text/template"
+
+	"github.com/nlopes/slack"
+)
+
+// RocketChatHandler posts alerts to a Rocket.Chat incoming webhook, formatted the same
+// way as the Slack handler
+type RocketChatHandler struct {
+	WebhookURL string       `mapstructure:"webhook_url"`
+	Channel    string       `mapstructure:"channel"`
+	Alias      string       `mapstructure:"alias"`
+	Avatar     string       `mapstructure:"avatar"`
+	MaxRetries int          `mapstructure:"max_retries"`
+	Fields     []SlackField `mapstructure:"fields"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	// The parsed title/value templates for each entry in Fields
+	fieldTemplates [][2]*template.Template
+}
+
+// The body of a Rocket.Chat incoming webhook request
+type rocketChatMessage struct {
+	Channel     string                 `json:"channel,omitempty"`
+	Alias       string                 `json:"alias,omitempty"`
+	Avatar      string                 `json:"avatar,omitempty"`
+	Text        string                 `json:"text"`
+	Attachments []rocketChatAttachment `json:"attachments"`
+}
+
+type rocketChatAttachment struct {
+	Color  string                  `json:"color"`
+	Text   string                  `json:"text"`
+	Fields []slack.AttachmentField `json:"fields,omitempty"`
+}
+
+func (handler *RocketChatHandler) validate() error {
+	if handler.WebhookURL == "" {
+		return fmt.Errorf("webhook_url must be set")
+	}
+
+	templates, err := compileSlackFields(handler.Fields)
+	handler.fieldTemplates = templates
+	return err
+}
+
+func (handler RocketChatHandler) Alert(datacenter string, alert *AlertState) error {
+	message := rocketChatMessage{
+		Channel: handler.Channel,
+		Alias:   handler.Alias,
+		Avatar:  handler.Avatar,
+		Text:    "*" + alert.Message + "*",
+		Attachments: []rocketChatAttachment{{
+			Color:  fmt.Sprintf("#%06X", alertStatusColor(alert.Status)),
+			Text:   alert.Details,
+			Fields: renderSlackFields(handler.Fields, handler.fieldTemplates, datacenter, alert),
+		}},
+	}
+
+	return retryAlert(alert, handler.MaxRetries, "Rocket.Chat", func() error {
+		_, err := handler.sendJSON("POST", handler.WebhookURL, nil, message)
+		return err
+	})
+}
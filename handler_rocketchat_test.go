@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_rocketchat(t *testing.T) {
+	var message rocketChatMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	handler := RocketChatHandler{
+		WebhookURL: server.URL,
+		Channel:    "#ops",
+		Fields:     []SlackField{{Title: "Node", Value: "{{.Node}}"}},
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: api.HealthPassing, Node: "node1", Message: "node1 is passing", Details: "ok"})
+
+	if message.Channel != "#ops" || message.Text != "*node1 is passing*" || len(message.Attachments) != 1 {
+		t.Fatalf("unexpected message: %+v", message)
+	}
+	attachment := message.Attachments[0]
+	if attachment.Color != "#2EB886" || attachment.Text != "ok" {
+		t.Errorf("unexpected attachment: %+v", attachment)
+	}
+	if len(attachment.Fields) != 1 || attachment.Fields[0].Value != "node1" {
+		t.Errorf("unexpected fields: %+v", attachment.Fields)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Characters Sensu doesn't allow in the names of entities and checks
+var sensuInvalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_.\-]+`)
+
+// SensuHandler sends alerts as events to the Sensu Go backend, so they go through its
+// filters and handlers like the results of Sensu's own checks
+type SensuHandler struct {
+	BackendURL    string            `mapstructure:"backend_url"`
+	APIKey        string            `mapstructure:"api_key"`
+	Namespace     string            `mapstructure:"namespace"`
+	Entity        string            `mapstructure:"entity"`
+	DefaultEntity string            `mapstructure:"default_entity"`
+	Check         string            `mapstructure:"check"`
+	DefaultCheck  string            `mapstructure:"default_check"`
+	Handlers      []string          `mapstructure:"handlers"`
+	Labels        map[string]string `mapstructure:"labels"`
+	MaxRetries    int               `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	entityTemplate *template.Template
+	checkTemplate  *template.Template
+}
+
+// An event in the Sensu Go core/v2 API
+type sensuEvent struct {
+	Entity sensuEntity `json:"entity"`
+	Check  sensuCheck  `json:"check"`
+}
+
+type sensuMetadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type sensuEntity struct {
+	EntityClass string        `json:"entity_class"`
+	Metadata    sensuMetadata `json:"metadata"`
+}
+
+type sensuCheck struct {
+	Metadata sensuMetadata `json:"metadata"`
+	Status   int           `json:"status"`
+	Output   string        `json:"output"`
+	Handlers []string      `json:"handlers,omitempty"`
+	Issued   int64         `json:"issued"`
+	Executed int64         `json:"executed"`
+}
+
+func (handler *SensuHandler) validate() error {
+	if handler.BackendURL == "" {
+		return fmt.Errorf("backend_url must be set")
+	}
+	if parsed, err := url.Parse(handler.BackendURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("backend_url must be an http or https URL")
+	}
+	if handler.APIKey == "" {
+		return fmt.Errorf("api_key must be set")
+	}
+	if handler.Namespace == "" {
+		return fmt.Errorf("namespace must be set")
+	}
+	handler.BackendURL = strings.TrimSuffix(handler.BackendURL, "/")
+
+	var err error
+	if handler.entityTemplate, err = compileAlertTemplate("entity", handler.Entity); err != nil {
+		return err
+	}
+	handler.checkTemplate, err = compileAlertTemplate("check", handler.Check)
+	return err
+}
+
+func (handler SensuHandler) Alert(datacenter string, alert *AlertState) error {
+	entity := sensuName(renderAlertTemplateOr(handler.entityTemplate, datacenter, alert, ""), handler.DefaultEntity)
+	check := sensuName(renderAlertTemplateOr(handler.checkTemplate, datacenter, alert, ""), handler.DefaultCheck)
+	if entity == "" || check == "" {
+		return fmt.Errorf("no Sensu entity or check for alert '%s', set default_entity and default_check", alert.Message)
+	}
+
+	labels := map[string]string{"datacenter": datacenter}
+	if alert.Service != "" {
+		labels["service"] = alert.Service
+	}
+	for name, value := range handler.Labels {
+		labels[name] = value
+	}
+	annotations := map[string]string{}
+	if alert.ConsulURL != "" {
+		annotations["consul_url"] = alert.ConsulURL
+	}
+
+	output := alert.Message
+	if alert.Details != "" {
+		output += "\n" + alert.Details
+	}
+
+	now := time.Now().Unix()
+	event := sensuEvent{
+		// Consul nodes aren't necessarily running the Sensu agent, so events are sent
+		// for proxy entities, which Sensu creates when they don't exist yet
+		Entity: sensuEntity{
+			EntityClass: "proxy",
+			Metadata:    sensuMetadata{Name: entity, Namespace: handler.Namespace},
+		},
+		Check: sensuCheck{
+			Metadata: sensuMetadata{
+				Name:        check,
+				Namespace:   handler.Namespace,
+				Labels:      labels,
+				Annotations: annotations,
+			},
+			Status:   alertStatusCode(alert.Status),
+			Output:   output,
+			Handlers: handler.Handlers,
+			Issued:   now,
+			Executed: now,
+		},
+	}
+
+	endpoint := fmt.Sprintf("%s/api/core/v2/namespaces/%s/events/%s/%s", handler.BackendURL,
+		url.PathEscape(handler.Namespace), url.PathEscape(entity), url.PathEscape(check))
+	headers := map[string]string{"Authorization": "Key " + handler.APIKey}
+	return retryAlert(alert, handler.MaxRetries, "Sensu backend", func() error {
+		_, err := handler.sendJSON("PUT", endpoint, headers, event)
+		return err
+	})
+}
+
+// Replaces the characters Sensu doesn't allow in names, e.g. the spaces in check
+// names, returning fallback for an empty name
+func sensuName(name string, fallback string) string {
+	name = sensuInvalidNameChars.ReplaceAllString(strings.TrimSpace(name), "_")
+	if name == "" {
+		return fallback
+	}
+	return name
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_sensu(t *testing.T) {
+	var auth string
+	var paths []string
+	var events []sensuEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			t.Errorf("expected a PUT request, got %s", r.Method)
+		}
+		auth = r.Header.Get("Authorization")
+		paths = append(paths, r.URL.Path)
+		var event sensuEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	config, err := ParseConfig(`
+handler "sensu" "backend" {
+  backend_url = "` + server.URL + `"
+  api_key = "secret"
+  handlers = ["slack"]
+  labels {
+    team = "platform"
+  }
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := config.Handlers["sensu.backend"]
+
+	alert := &AlertState{Status: api.HealthWarning, Node: "web1", Service: "redis", Message: "redis is warning", Details: "slow responses"}
+	if err := handler.Alert("dc1", alert); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Key secret" {
+		t.Errorf("expected the API key to be sent, got %q", auth)
+	}
+	if paths[0] != "/api/core/v2/namespaces/default/events/web1/redis" {
+		t.Errorf("unexpected request path: %s", paths[0])
+	}
+	event := events[0]
+	if event.Entity.EntityClass != "proxy" || event.Entity.Metadata.Name != "web1" {
+		t.Errorf("unexpected entity: %+v", event.Entity)
+	}
+	check := event.Check
+	if check.Metadata.Name != "redis" || check.Status != 1 || check.Output != "redis is warning\nslow responses" {
+		t.Errorf("unexpected check: %+v", check)
+	}
+	if check.Metadata.Labels["datacenter"] != "dc1" || check.Metadata.Labels["team"] != "platform" {
+		t.Errorf("unexpected labels: %v", check.Metadata.Labels)
+	}
+	if len(check.Handlers) != 1 || check.Handlers[0] != "slack" {
+		t.Errorf("unexpected handlers: %v", check.Handlers)
+	}
+
+	// Node checks fall back to the default check
+	alert = &AlertState{Status: api.HealthCritical, Node: "web1", Message: "web1 is critical"}
+	handler.Alert("dc1", alert)
+	if paths[1] != "/api/core/v2/namespaces/default/events/web1/consul-node" || events[1].Check.Status != 2 {
+		t.Errorf("unexpected node event at %s: %+v", paths[1], events[1].Check)
+	}
+}
+
+func TestHandler_sensuName(t *testing.T) {
+	cases := map[string]string{
+		"redis":              "redis",
+		"Service 'redis' ok": "Service_redis_ok",
+		"  ":                 "fallback",
+	}
+
+	for name, expected := range cases {
+		if actual := sensuName(name, "fallback"); actual != expected {
+			t.Errorf("expected %q for %q, got %q", expected, name, actual)
+		}
+	}
+}
+
+func TestHandler_sensuInvalid(t *testing.T) {
+	cases := []SensuHandler{
+		{APIKey: "secret", Namespace: "default"},
+		{BackendURL: "sensu.example.com:8080", APIKey: "secret", Namespace: "default"},
+		{BackendURL: "http://sensu.example.com:8080", Namespace: "default"},
+		{BackendURL: "http://sensu.example.com:8080", APIKey: "secret"},
+		{BackendURL: "http://sensu.example.com:8080", APIKey: "secret", Namespace: "default", Check: "{{.Missing}}"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
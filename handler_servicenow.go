@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The default incident urgency for each failing status (1 is high, 3 is low)
+var defaultServiceNowUrgencies = map[string]string{
+	api.HealthWarning:  "2",
+	api.HealthCritical: "1",
+}
+
+// ServiceNowHandler creates an incident through the Table API when an alert fires,
+// updates its urgency as the status changes and resolves it on recovery. Incidents
+// are matched to alerts by their correlation ID.
+type ServiceNowHandler struct {
+	InstanceURL     string            `mapstructure:"instance_url"`
+	Username        string            `mapstructure:"username"`
+	Password        string            `mapstructure:"password"`
+	AssignmentGroup string            `mapstructure:"assignment_group"`
+	CallerID        string            `mapstructure:"caller_id"`
+	Urgencies       map[string]string `mapstructure:"urgencies"`
+	ResolvedState   string            `mapstructure:"resolved_state"`
+	CloseCode       string            `mapstructure:"close_code"`
+	MaxRetries      int               `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// An incident returned by the Table API
+type serviceNowIncident struct {
+	SysID   string `json:"sys_id"`
+	Number  string `json:"number"`
+	Urgency string `json:"urgency"`
+}
+
+func (handler *ServiceNowHandler) validate() error {
+	if handler.InstanceURL == "" {
+		return fmt.Errorf("instance_url must be set")
+	}
+	if handler.Username == "" || handler.Password == "" {
+		return fmt.Errorf("username and password must be set")
+	}
+
+	urgencies := make(map[string]string)
+	for status, urgency := range defaultServiceNowUrgencies {
+		urgencies[status] = urgency
+	}
+	for status, urgency := range handler.Urgencies {
+		if _, ok := defaultServiceNowUrgencies[status]; !ok {
+			return fmt.Errorf("invalid status in urgencies: %s", status)
+		}
+		urgencies[status] = urgency
+	}
+	handler.Urgencies = urgencies
+
+	handler.InstanceURL = strings.TrimSuffix(handler.InstanceURL, "/")
+	return nil
+}
+
+func (handler ServiceNowHandler) Alert(datacenter string, alert *AlertState) error {
+	correlationID := alertIncidentKey(datacenter, alert)
+
+	return retryAlert(alert, handler.MaxRetries, "ServiceNow", func() error {
+		incident, err := handler.activeIncident(correlationID)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case alert.Status == api.HealthPassing && incident == nil:
+			log.Debugf("No active ServiceNow incident to resolve for alert: %s", alert.Message)
+			return nil
+		case alert.Status == api.HealthPassing:
+			return handler.updateIncident(incident, map[string]string{
+				"state":       handler.ResolvedState,
+				"close_code":  handler.CloseCode,
+				"close_notes": serviceNowDescription(datacenter, alert),
+			})
+		case incident == nil:
+			return handler.createIncident(datacenter, alert, correlationID)
+		case incident.Urgency != handler.Urgencies[alert.Status]:
+			return handler.updateIncident(incident, map[string]string{
+				"urgency":  handler.Urgencies[alert.Status],
+				"comments": serviceNowDescription(datacenter, alert),
+			})
+		}
+
+		return nil
+	})
+}
+
+func (handler ServiceNowHandler) headers() map[string]string {
+	credentials := handler.Username + ":" + handler.Password
+	return map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))}
+}
+
+// Finds the active incident for a correlation ID, returning nil if there isn't one
+func (handler ServiceNowHandler) activeIncident(correlationID string) (*serviceNowIncident, error) {
+	query := url.Values{
+		"sysparm_query":  {"active=true^correlation_id=" + correlationID},
+		"sysparm_fields": {"sys_id,number,urgency"},
+		"sysparm_limit":  {"1"},
+	}
+
+	var result struct {
+		Result []serviceNowIncident `json:"result"`
+	}
+	if err := handler.getJSON(handler.InstanceURL+"/api/now/table/incident?"+query.Encode(), handler.headers(), &result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Result) == 0 {
+		return nil, nil
+	}
+	return &result.Result[0], nil
+}
+
+func (handler ServiceNowHandler) createIncident(datacenter string, alert *AlertState, correlationID string) error {
+	incident := map[string]string{
+		"short_description": truncateDetails(alert.Message, 160),
+		"description":       serviceNowDescription(datacenter, alert),
+		"urgency":           handler.Urgencies[alert.Status],
+		"correlation_id":    correlationID,
+	}
+	if handler.AssignmentGroup != "" {
+		incident["assignment_group"] = handler.AssignmentGroup
+	}
+	if handler.CallerID != "" {
+		incident["caller_id"] = handler.CallerID
+	}
+
+	_, err := handler.sendJSON("POST", handler.InstanceURL+"/api/now/table/incident", handler.headers(), incident)
+	if err == nil {
+		log.Infof("Created ServiceNow incident for alert: %s", alert.Message)
+	}
+	return err
+}
+
+func (handler ServiceNowHandler) updateIncident(incident *serviceNowIncident, fields map[string]string) error {
+	url := handler.InstanceURL + "/api/now/table/incident/" + incident.SysID
+	_, err := handler.sendJSON("PATCH", url, handler.headers(), fields)
+	if err == nil {
+		log.Infof("Updated ServiceNow incident %s", incident.Number)
+	}
+	return err
+}
+
+func serviceNowDescription(datacenter string, alert *AlertState) string {
+	description := fmt.Sprintf("%s\n\nDatacenter: %s\nStatus: %s", alert.Message, datacenter, alert.Status)
+	if alert.Details != "" {
+		description += "\n\n" + alert.Details
+	}
+	return description
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_servicenow(t *testing.T) {
+	var incident map[string]string
+	var updates []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/now/table/incident":
+			if r.URL.Query().Get("sysparm_query") != "active=true^correlation_id=dc1-redis--" {
+				t.Errorf("unexpected query: %s", r.URL.Query().Get("sysparm_query"))
+			}
+			if incident == nil || incident["state"] == "6" {
+				w.Write([]byte(`{"result": []}`))
+				return
+			}
+			fmt.Fprintf(w, `{"result": [{"sys_id": "abc", "number": "INC001", "urgency": "%s"}]}`, incident["urgency"])
+		case r.Method == "POST" && r.URL.Path == "/api/now/table/incident":
+			incident = body
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "PATCH" && r.URL.Path == "/api/now/table/incident/abc":
+			updates = append(updates, body)
+			for key, value := range body {
+				incident[key] = value
+			}
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		}
+	}))
+	defer server.Close()
+
+	handler := ServiceNowHandler{
+		InstanceURL:     server.URL,
+		Username:        "user",
+		Password:        "pass",
+		AssignmentGroup: "ops",
+		ResolvedState:   "6",
+		CloseCode:       "Solved (Permanently)",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: api.HealthWarning, Service: "redis", Message: "[dc1] redis is warning"}
+	handler.Alert("dc1", alert)
+	if incident == nil || incident["urgency"] != "2" || incident["assignment_group"] != "ops" {
+		t.Fatalf("unexpected incident: %v", incident)
+	}
+	if incident["short_description"] != "[dc1] redis is warning" {
+		t.Errorf("unexpected short description: %v", incident["short_description"])
+	}
+
+	alert.Status = api.HealthCritical
+	handler.Alert("dc1", alert)
+	if len(updates) != 1 || updates[0]["urgency"] != "1" {
+		t.Errorf("expected the urgency to be raised, got %v", updates)
+	}
+
+	alert.Status = api.HealthPassing
+	handler.Alert("dc1", alert)
+	if len(updates) != 2 || updates[1]["state"] != "6" || updates[1]["close_code"] != "Solved (Permanently)" {
+		t.Errorf("expected the incident to be resolved, got %v", updates)
+	}
+
+	// Recovering again shouldn't touch the resolved incident
+	handler.Alert("dc1", alert)
+	if len(updates) != 2 {
+		t.Errorf("expected no more updates, got %v", updates)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SNS limits subjects to this many characters
+const snsSubjectLimit = 100
+
+// SNSHandler publishes alerts as JSON to an AWS SNS topic
+type SNSHandler struct {
+	TopicARN        string `mapstructure:"topic_arn"`
+	Region          string `mapstructure:"region"`
+	Endpoint        string `mapstructure:"endpoint"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	Profile         string `mapstructure:"profile"`
+	MaxRetries      int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	credentials *awsCredentialChain
+}
+
+func (handler *SNSHandler) validate() error {
+	arn := strings.Split(handler.TopicARN, ":")
+	if len(arn) != 6 || arn[0] != "arn" || arn[2] != "sns" {
+		return fmt.Errorf("invalid topic_arn: %s", handler.TopicARN)
+	}
+
+	if handler.Region == "" {
+		handler.Region = arn[3]
+	}
+	if handler.Endpoint == "" {
+		handler.Endpoint = fmt.Sprintf("https://sns.%s.amazonaws.com/", handler.Region)
+	}
+	if (handler.AccessKeyID == "") != (handler.SecretAccessKey == "") {
+		return fmt.Errorf("access_key_id and secret_access_key must be set together")
+	}
+
+	handler.credentials = &awsCredentialChain{
+		AccessKeyID:     handler.AccessKeyID,
+		SecretAccessKey: handler.SecretAccessKey,
+		Profile:         handler.Profile,
+	}
+	return nil
+}
+
+func (handler SNSHandler) Alert(datacenter string, alert *AlertState) error {
+	message, err := json.Marshal(alertPayload{datacenter, alert})
+	if err != nil {
+		log.Errorf("Error encoding alert for SNS: %s", err)
+		return err
+	}
+
+	return retryAlert(alert, handler.MaxRetries, "SNS topic "+handler.TopicARN, func() error {
+		return handler.publish(truncateDetails(alert.Message, snsSubjectLimit), string(message))
+	})
+}
+
+// Publishes a message to the topic using the SNS query api
+func (handler SNSHandler) publish(subject string, message string) error {
+	creds, err := handler.credentials.get()
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("Action", "Publish")
+	form.Set("Version", "2010-03-31")
+	form.Set("TopicArn", handler.TopicARN)
+	form.Set("Subject", subject)
+	form.Set("Message", message)
+	body := []byte(form.Encode())
+
+	req, err := http.NewRequest("POST", handler.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	awsSignRequest(req, body, creds, handler.Region, "sns", time.Now())
+
+	_, err = handler.doRequest(req)
+	return err
+}
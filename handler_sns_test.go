@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_sns(t *testing.T) {
+	var form map[string][]string
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	handler := SNSHandler{
+		TopicARN:        "arn:aws:sns:us-west-2:123456789012:alerts",
+		Endpoint:        server.URL,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if handler.Region != "us-west-2" {
+		t.Errorf("expected region from the topic arn, got %s", handler.Region)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Service: "redis", Message: "redis is critical"})
+
+	if form["Action"][0] != "Publish" || form["TopicArn"][0] != handler.TopicARN {
+		t.Errorf("unexpected publish request: %v", form)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(form["Message"][0]), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["datacenter"] != "dc1" || payload["service"] != "redis" {
+		t.Errorf("unexpected message: %v", payload)
+	}
+
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") || !strings.Contains(auth, "/us-west-2/sns/") {
+		t.Errorf("unexpected authorization header: %s", auth)
+	}
+}
+
+func TestHandler_snsInvalid(t *testing.T) {
+	cases := []SNSHandler{
+		{TopicARN: "alerts"},
+		{TopicARN: "arn:aws:sqs:us-west-2:123456789012:alerts"},
+		{TopicARN: "arn:aws:sns:us-west-2:123456789012:alerts", AccessKeyID: "key"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// SplunkHandler sends alert transitions to a Splunk HTTP Event Collector
+type SplunkHandler struct {
+	URL        string `mapstructure:"url"`
+	Token      string `mapstructure:"token"`
+	Index      string `mapstructure:"index"`
+	Source     string `mapstructure:"source"`
+	SourceType string `mapstructure:"sourcetype"`
+	MaxRetries int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// An event in the HEC JSON format
+type splunkEvent struct {
+	Time       float64      `json:"time"`
+	Host       string       `json:"host,omitempty"`
+	Source     string       `json:"source,omitempty"`
+	SourceType string       `json:"sourcetype,omitempty"`
+	Index      string       `json:"index,omitempty"`
+	Event      alertPayload `json:"event"`
+}
+
+func (handler *SplunkHandler) validate() error {
+	if handler.URL == "" || handler.Token == "" {
+		return fmt.Errorf("url and token must be set")
+	}
+
+	handler.URL = strings.TrimSuffix(handler.URL, "/")
+	if !strings.HasSuffix(handler.URL, "/services/collector/event") {
+		handler.URL += "/services/collector/event"
+	}
+	return nil
+}
+
+func (handler SplunkHandler) Alert(datacenter string, alert *AlertState) error {
+	hostname, _ := os.Hostname()
+	event := splunkEvent{
+		Time:       float64(time.Now().UnixNano()) / float64(time.Second),
+		Host:       hostname,
+		Source:     handler.Source,
+		SourceType: handler.SourceType,
+		Index:      handler.Index,
+		Event:      alertPayload{datacenter, alert},
+	}
+
+	headers := map[string]string{"Authorization": "Splunk " + handler.Token}
+	return retryAlert(alert, handler.MaxRetries, "Splunk", func() error {
+		_, err := handler.sendJSON("POST", handler.URL, headers, event)
+		return err
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler_splunk(t *testing.T) {
+	var event map[string]interface{}
+	var auth, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+		auth = r.Header.Get("Authorization")
+		path = r.URL.Path
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+
+	handler := SplunkHandler{URL: server.URL, Token: "hec-token", Index: "consul", SourceType: "consul:alert"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Service: "redis"})
+
+	if auth != "Splunk hec-token" || path != "/services/collector/event" {
+		t.Errorf("unexpected auth %s or path %s", auth, path)
+	}
+	if event["index"] != "consul" || event["sourcetype"] != "consul:alert" {
+		t.Errorf("unexpected event metadata: %v", event)
+	}
+	inner, ok := event["event"].(map[string]interface{})
+	if !ok || inner["datacenter"] != "dc1" || inner["service"] != "redis" || inner["status"] != "critical" {
+		t.Errorf("unexpected event body: %v", event["event"])
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SQSHandler enqueues alerts as JSON onto an AWS SQS queue. On FIFO queues the incident
+// key is the message group, so the transitions of an alert are consumed in order.
+type SQSHandler struct {
+	QueueURL        string `mapstructure:"queue_url"`
+	Region          string `mapstructure:"region"`
+	Endpoint        string `mapstructure:"endpoint"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	Profile         string `mapstructure:"profile"`
+	MaxRetries      int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+
+	credentials *awsCredentialChain
+}
+
+func (handler *SQSHandler) validate() error {
+	queue, err := url.Parse(handler.QueueURL)
+	if err != nil || queue.Host == "" || len(strings.Split(strings.Trim(queue.Path, "/"), "/")) != 2 {
+		return fmt.Errorf("invalid queue_url: %s", handler.QueueURL)
+	}
+
+	if handler.Region == "" {
+		// Queue URLs are https://sqs.<region>.amazonaws.com/<account>/<queue>, or
+		// https://<region>.queue.amazonaws.com/... for older queues
+		host := strings.Split(queue.Hostname(), ".")
+		switch {
+		case len(host) >= 4 && host[0] == "sqs":
+			handler.Region = host[1]
+		case len(host) >= 4 && host[1] == "queue":
+			handler.Region = host[0]
+		default:
+			return fmt.Errorf("region must be set, it can't be found in queue_url %s", handler.QueueURL)
+		}
+	}
+	if handler.Endpoint == "" {
+		handler.Endpoint = fmt.Sprintf("https://sqs.%s.amazonaws.com/", handler.Region)
+	}
+	if (handler.AccessKeyID == "") != (handler.SecretAccessKey == "") {
+		return fmt.Errorf("access_key_id and secret_access_key must be set together")
+	}
+
+	handler.credentials = &awsCredentialChain{
+		AccessKeyID:     handler.AccessKeyID,
+		SecretAccessKey: handler.SecretAccessKey,
+		Profile:         handler.Profile,
+	}
+	return nil
+}
+
+// Whether the queue is a FIFO queue, which requires a message group
+func (handler SQSHandler) fifo() bool {
+	return strings.HasSuffix(strings.TrimSuffix(handler.QueueURL, "/"), ".fifo")
+}
+
+func (handler SQSHandler) Alert(datacenter string, alert *AlertState) error {
+	message, err := json.Marshal(alertPayload{datacenter, alert})
+	if err != nil {
+		log.Errorf("Error encoding alert for SQS: %s", err)
+		return err
+	}
+
+	// The deduplication ID is fixed across retries, so a send that succeeded but timed
+	// out isn't enqueued twice
+	key := alertIncidentKey(datacenter, alert)
+	deduplicationID := key + "-" + alert.Status + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	return retryAlert(alert, handler.MaxRetries, "SQS queue "+handler.QueueURL, func() error {
+		return handler.send(string(message), key, deduplicationID)
+	})
+}
+
+// Sends a message to the queue using the SQS query api
+func (handler SQSHandler) send(message string, key string, deduplicationID string) error {
+	creds, err := handler.credentials.get()
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("Action", "SendMessage")
+	form.Set("Version", "2012-11-05")
+	form.Set("QueueUrl", handler.QueueURL)
+	form.Set("MessageBody", message)
+	if handler.fifo() {
+		// Both IDs are limited to 128 characters
+		form.Set("MessageGroupId", truncateID(key))
+		form.Set("MessageDeduplicationId", truncateID(deduplicationID))
+	}
+	body := []byte(form.Encode())
+
+	req, err := http.NewRequest("POST", handler.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	awsSignRequest(req, body, creds, handler.Region, "sqs", time.Now())
+
+	_, err = handler.doRequest(req)
+	return err
+}
+
+// Shortens an ID to the 128 characters SQS allows, keeping the end, which differs the most
+func truncateID(id string) string {
+	if len(id) > 128 {
+		return id[len(id)-128:]
+	}
+	return id
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_sqs(t *testing.T) {
+	var form map[string][]string
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	handler := SQSHandler{
+		QueueURL:        "https://sqs.us-west-2.amazonaws.com/123456789012/alerts.fifo",
+		Endpoint:        server.URL,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if handler.Region != "us-west-2" {
+		t.Errorf("expected region from the queue url, got %s", handler.Region)
+	}
+
+	alert := &AlertState{Status: "critical", Service: "redis", Message: "redis is critical"}
+	handler.Alert("dc1", alert)
+
+	if form["Action"][0] != "SendMessage" || form["QueueUrl"][0] != handler.QueueURL {
+		t.Errorf("unexpected send request: %v", form)
+	}
+	if form["MessageGroupId"][0] != alertIncidentKey("dc1", alert) || form["MessageDeduplicationId"][0] == "" {
+		t.Errorf("expected the incident key as message group, got %v", form)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(form["MessageBody"][0]), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["datacenter"] != "dc1" || payload["service"] != "redis" {
+		t.Errorf("unexpected message: %v", payload)
+	}
+
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") || !strings.Contains(auth, "/us-west-2/sqs/") {
+		t.Errorf("unexpected authorization header: %s", auth)
+	}
+
+	// Standard queues take no message group
+	handler.QueueURL = "https://us-east-1.queue.amazonaws.com/123456789012/alerts"
+	handler.Region = ""
+	if err := handler.validate(); err != nil || handler.Region != "us-east-1" {
+		t.Fatalf("expected region us-east-1 from the legacy queue url, got %s (%v)", handler.Region, err)
+	}
+	handler.Alert("dc1", alert)
+	if _, ok := form["MessageGroupId"]; ok {
+		t.Errorf("expected no message group for a standard queue, got %v", form)
+	}
+}
+
+func TestHandler_sqsInvalid(t *testing.T) {
+	cases := []SQSHandler{
+		{QueueURL: "alerts"},
+		{QueueURL: "https://localhost:9324/123456789012/alerts"},
+		{QueueURL: "https://sqs.us-west-2.amazonaws.com/123456789012/alerts", AccessKeyID: "key"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
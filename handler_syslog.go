@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The sockets tried, in order, when logging to the local syslog daemon
+var syslogLocalSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// The private enterprise number used for the structured data in syslog messages.
+// 32473 is reserved for documentation and examples.
+const syslogSDID = "alert@32473"
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// The severity used for each check status unless overridden
+var defaultSyslogStatusSeverities = map[string]string{
+	api.HealthPassing:  "notice",
+	api.HealthWarning:  "warning",
+	api.HealthCritical: "crit",
+}
+
+// SyslogHandler writes alerts to a local or remote syslog server in the RFC 5424 format
+type SyslogHandler struct {
+	Network    string            `mapstructure:"network"`
+	Address    string            `mapstructure:"address"`
+	Facility   string            `mapstructure:"facility"`
+	AppName    string            `mapstructure:"app_name"`
+	Severities map[string]string `mapstructure:"severities"`
+	MaxRetries int               `mapstructure:"max_retries"`
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func (handler *SyslogHandler) validate() error {
+	if _, ok := syslogFacilities[handler.Facility]; !ok {
+		return fmt.Errorf("invalid facility: %s", handler.Facility)
+	}
+
+	severities := make(map[string]string)
+	for status, severity := range defaultSyslogStatusSeverities {
+		severities[status] = severity
+	}
+	for status, severity := range handler.Severities {
+		if _, ok := defaultSyslogStatusSeverities[status]; !ok {
+			return fmt.Errorf("invalid status in severities: %s", status)
+		}
+		if _, ok := syslogSeverities[severity]; !ok {
+			return fmt.Errorf("invalid severity for %s: %s", status, severity)
+		}
+		severities[status] = severity
+	}
+	handler.Severities = severities
+
+	switch handler.Network {
+	case "":
+		if handler.Address != "" {
+			return fmt.Errorf("network must be set when address is set")
+		}
+	case "udp", "tcp", "unix", "unixgram":
+		if handler.Address == "" {
+			return fmt.Errorf("address must be set when network is set")
+		}
+	default:
+		return fmt.Errorf("invalid network: %s", handler.Network)
+	}
+
+	return nil
+}
+
+func (handler SyslogHandler) Alert(datacenter string, alert *AlertState) error {
+	message := handler.format(datacenter, alert, time.Now())
+
+	description := "syslog"
+	if handler.Address != "" {
+		description = "syslog at " + handler.Address
+	}
+	return retryAlert(alert, handler.MaxRetries, description, func() error {
+		return handler.send(message)
+	})
+}
+
+// Formats an alert as an RFC 5424 message, with the alert fields as structured data
+func (handler SyslogHandler) format(datacenter string, alert *AlertState, now time.Time) string {
+	severity, ok := syslogSeverities[handler.Severities[alert.Status]]
+	if !ok {
+		severity = syslogSeverities["crit"]
+	}
+	priority := syslogFacilities[handler.Facility]*8 + severity
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	params := make([]string, 0)
+	for _, param := range [][2]string{
+		{"datacenter", datacenter},
+		{"status", alert.Status},
+		{"service", alert.Service},
+		{"tag", alert.Tag},
+		{"node", alert.Node},
+		{"check", alert.Check},
+	} {
+		if param[1] != "" {
+			params = append(params, fmt.Sprintf(`%s="%s"`, param[0], syslogParamEscaper.Replace(param[1])))
+		}
+	}
+
+	// Syslog messages are a single line, so fold the details onto it
+	text := alert.Message
+	if details := strings.TrimSpace(alert.Details); details != "" {
+		text += ": " + strings.Replace(details, "\n", " ", -1)
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d - [%s %s] %s", priority, now.Format(time.RFC3339Nano), hostname,
+		handler.AppName, os.Getpid(), syslogSDID, strings.Join(params, " "), text)
+}
+
+// Sends a message in a new connection to the syslog server
+func (handler SyslogHandler) send(message string) error {
+	conn, err := handler.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(handlerHTTPTimeout))
+
+	// Stream transports need framing; use octet counting (RFC 6587)
+	if handler.Network == "tcp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+
+	_, err = conn.Write([]byte(message))
+	return err
+}
+
+func (handler SyslogHandler) dial() (net.Conn, error) {
+	if handler.Network != "" {
+		return net.DialTimeout(handler.Network, handler.Address, handlerHTTPTimeout)
+	}
+
+	var lastErr error
+	for _, socket := range syslogLocalSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.Dial(network, socket)
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+	}
+
+	return nil, fmt.Errorf("unable to connect to the local syslog daemon: %s", lastErr)
+}
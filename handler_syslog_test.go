@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_syslogFormat(t *testing.T) {
+	handler := SyslogHandler{Facility: "local0", AppName: "consul-alerting"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	now, _ := time.Parse(time.RFC3339, "2017-01-02T03:04:05Z")
+	message := handler.format("dc1", &AlertState{
+		Status:  api.HealthCritical,
+		Service: "redis",
+		Node:    `node"1`,
+		Message: "redis is critical",
+		Details: "line 1\nline 2",
+	}, now)
+
+	// local0 (16) * 8 + crit (2)
+	pattern := `^<130>1 2017-01-02T03:04:05Z \S+ consul-alerting \d+ - ` +
+		`\[alert@32473 datacenter="dc1" status="critical" service="redis" node="node\\"1"\] ` +
+		`redis is critical: line 1 line 2$`
+	if !regexp.MustCompile(pattern).MatchString(message) {
+		t.Errorf("message didn't match %s:\n%s", pattern, message)
+	}
+}
+
+func TestHandler_syslogSeverities(t *testing.T) {
+	handler := SyslogHandler{Facility: "daemon", Severities: map[string]string{"passing": "info"}}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if handler.Severities["passing"] != "info" || handler.Severities["critical"] != "crit" {
+		t.Errorf("unexpected severities: %v", handler.Severities)
+	}
+
+	invalid := []SyslogHandler{
+		{Facility: "nope"},
+		{Facility: "daemon", Severities: map[string]string{"passing": "loud"}},
+		{Facility: "daemon", Severities: map[string]string{"unknown": "info"}},
+		{Facility: "daemon", Network: "udp"},
+	}
+	for _, handler := range invalid {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
+
+func TestHandler_syslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	handler := SyslogHandler{Network: "udp", Address: conn.LocalAddr().String(), Facility: "daemon", AppName: "test"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+	handler.Alert("dc1", &AlertState{Status: api.HealthPassing, Message: "redis is passing"})
+
+	buf := make([]byte, 1024)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// daemon (3) * 8 + notice (5)
+	if !regexp.MustCompile(`^<29>1 .* redis is passing$`).Match(buf[:n]) {
+		t.Errorf("unexpected message: %s", buf[:n])
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+)
+
+// TeamsHandler posts alerts as cards to a Microsoft Teams incoming webhook
+type TeamsHandler struct {
+	WebhookURL string `mapstructure:"webhook_url"`
+	MaxRetries int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// A legacy actionable message card, as accepted by Teams incoming webhooks
+type teamsMessageCard struct {
+	Type       string             `json:"@type"`
+	Context    string             `json:"@context"`
+	ThemeColor string             `json:"themeColor"`
+	Summary    string             `json:"summary"`
+	Title      string             `json:"title"`
+	Sections   []teamsCardSection `json:"sections"`
+}
+
+type teamsCardSection struct {
+	Facts []teamsCardFact `json:"facts"`
+	Text  string          `json:"text,omitempty"`
+}
+
+type teamsCardFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (handler *TeamsHandler) validate() error {
+	if handler.WebhookURL == "" {
+		return fmt.Errorf("webhook_url must be set")
+	}
+	return nil
+}
+
+func (handler TeamsHandler) Alert(datacenter string, alert *AlertState) error {
+	card := teamsCard(datacenter, alert)
+
+	return retryAlert(alert, handler.MaxRetries, "Teams", func() error {
+		_, err := handler.sendJSON("POST", handler.WebhookURL, nil, card)
+		return err
+	})
+}
+
+// Builds the message card for an alert
+func teamsCard(datacenter string, alert *AlertState) teamsMessageCard {
+	facts := []teamsCardFact{
+		{"Datacenter", datacenter},
+		{"Status", alert.Status},
+	}
+	for _, fact := range []teamsCardFact{
+		{"Service", alert.Service},
+		{"Tag", alert.Tag},
+		{"Node", alert.Node},
+		{"Check", alert.Check},
+	} {
+		if fact.Value != "" {
+			facts = append(facts, fact)
+		}
+	}
+
+	section := teamsCardSection{Facts: facts}
+	if alert.Details != "" {
+		// Teams renders the text as markdown, so keep the check output preformatted
+		section.Text = "```\n" + alert.Details + "\n```"
+	}
+
+	return teamsMessageCard{
+		Type:       "MessageCard",
+		Context:    "http://schema.org/extensions",
+		ThemeColor: fmt.Sprintf("%06X", alertStatusColor(alert.Status)),
+		Summary:    alert.Message,
+		Title:      alert.Message,
+		Sections:   []teamsCardSection{section},
+	}
+}
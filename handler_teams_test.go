@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_teams(t *testing.T) {
+	var card teamsMessageCard
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &card); err != nil {
+			t.Errorf("error decoding card: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	handler := TeamsHandler{WebhookURL: server.URL}
+	handler.Alert("dc1", &AlertState{
+		Status:  api.HealthWarning,
+		Service: "redis",
+		Message: "redis is now warning",
+		Details: "check output",
+	})
+
+	if card.Type != "MessageCard" || card.Title != "redis is now warning" {
+		t.Errorf("unexpected card: %+v", card)
+	}
+	if card.ThemeColor != "DAA038" {
+		t.Errorf("expected warning color, got %s", card.ThemeColor)
+	}
+	if len(card.Sections) != 1 || len(card.Sections[0].Facts) != 3 {
+		t.Fatalf("expected one section with 3 facts, got %+v", card.Sections)
+	}
+}
+
+func TestHandler_teamsColors(t *testing.T) {
+	colors := map[string]string{
+		api.HealthPassing:  "2EB886",
+		api.HealthWarning:  "DAA038",
+		api.HealthCritical: "A30200",
+		"unknown":          "A30200",
+	}
+	for status, color := range colors {
+		card := teamsCard("dc1", &AlertState{Status: status})
+		if card.ThemeColor != color {
+			t.Errorf("expected color %s for %s, got %s", color, status, card.ThemeColor)
+		}
+		if card.Sections[0].Text != "" {
+			t.Errorf("expected no text without details, got %s", card.Sections[0].Text)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The default base URL of the Telegram bot api
+const telegramAPIURL = "https://api.telegram.org"
+
+// TelegramHandler sends alerts to one or more Telegram chats through a bot
+type TelegramHandler struct {
+	BotToken   string   `mapstructure:"bot_token"`
+	ChatIDs    []string `mapstructure:"chat_ids"`
+	APIURL     string   `mapstructure:"api_url"`
+	MaxRetries int      `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// The body of a sendMessage request
+type telegramMessage struct {
+	ChatID    string `json:"chat_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode"`
+}
+
+// Escapes the characters that have a meaning in Telegram's Markdown parse mode
+var telegramMarkdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+func (handler *TelegramHandler) validate() error {
+	if handler.BotToken == "" {
+		return fmt.Errorf("bot_token must be set")
+	}
+	if len(handler.ChatIDs) == 0 {
+		return fmt.Errorf("at least one chat id must be set in chat_ids")
+	}
+
+	handler.APIURL = strings.TrimSuffix(handler.APIURL, "/")
+	return nil
+}
+
+func (handler TelegramHandler) Alert(datacenter string, alert *AlertState) error {
+	url := fmt.Sprintf("%s/bot%s/sendMessage", handler.APIURL, handler.BotToken)
+	text := telegramText(alert)
+
+	var failed error
+	for _, chatID := range handler.ChatIDs {
+		message := telegramMessage{
+			ChatID:    chatID,
+			Text:      text,
+			ParseMode: "Markdown",
+		}
+
+		err := retryAlert(alert, handler.MaxRetries, "Telegram chat "+chatID, func() error {
+			_, err := handler.sendJSON("POST", url, nil, message)
+			return err
+		})
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// Formats an alert as Markdown, with the details in a preformatted block
+func telegramText(alert *AlertState) string {
+	text := "*" + telegramMarkdownEscaper.Replace(alert.Message) + "*"
+	if alert.Details != "" {
+		// Backticks can't be escaped inside a code block, so swap them for quotes
+		text += "\n```\n" + strings.Replace(alert.Details, "`", "'", -1) + "\n```"
+	}
+	return text
+}
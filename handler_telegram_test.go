@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler_telegram(t *testing.T) {
+	received := make(map[string]telegramMessage)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottoken/sendMessage" {
+			t.Errorf("unexpected request path: %s", r.URL.Path)
+		}
+
+		var message telegramMessage
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+		received[message.ChatID] = message
+	}))
+	defer server.Close()
+
+	handler := TelegramHandler{
+		BotToken: "token",
+		ChatIDs:  []string{"-1001", "-1002"},
+		APIURL:   server.URL + "/",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Message: "redis is critical", Details: "output"})
+
+	if len(received) != 2 {
+		t.Fatalf("expected messages to 2 chats, got %d", len(received))
+	}
+	if received["-1001"].ParseMode != "Markdown" {
+		t.Errorf("expected Markdown parse mode, got %s", received["-1001"].ParseMode)
+	}
+}
+
+func TestHandler_telegramText(t *testing.T) {
+	text := telegramText(&AlertState{Message: "my_service is *critical*", Details: "run `check`"})
+	expected := "*my\\_service is \\*critical\\**\n```\nrun 'check'\n```"
+	if text != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, text)
+	}
+
+	text = telegramText(&AlertState{Message: "redis is passing"})
+	if text != "*redis is passing*" {
+		t.Errorf("expected no details block, got %s", text)
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"net"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/nlopes/slack"
@@ -89,9 +94,210 @@ func TestHandler_slack(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	expected := fmt.Sprintf(slackMessageFormat, alert.Message, alert.Details)
+	attachments := history.Messages[0].Attachments
+	if len(attachments) != 1 || attachments[0].Fallback != alert.Message {
+		t.Errorf("expected an attachment for `%s`, got %+v", alert.Message, attachments)
+	}
+}
+
+func TestHandler_slackFields(t *testing.T) {
+	handler := SlackHandler{
+		Fields: []SlackField{
+			{Title: "Service", Value: "{{.Service}} ({{.Datacenter}})", Short: true},
+			{Title: "{{.Status}}", Value: "{{.Node}}"},
+		},
+	}
+	if err := handler.compileFields(); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := handler.renderFields("dc1", &AlertState{
+		Service: "redis",
+		Node:    "node1",
+		Status:  "critical",
+	})
+
+	expected := []slack.AttachmentField{
+		{Title: "Service", Value: "redis (dc1)", Short: true},
+		{Title: "critical", Value: "node1"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %#v, got %#v", expected, fields)
+	}
+}
+
+func TestHandler_slackFieldsInvalid(t *testing.T) {
+	_, err := ParseConfig(`
+	handler "slack" "dev_channel" {
+		api_token = "mytoken"
+		fields = [{ title = "Service", value = "{{.Servce}}" }]
+	}
+	`)
+	if err == nil {
+		t.Fatal("expected error, but nothing was returned")
+	}
+
+	expected := "fields[0].value"
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q to include %q", err.Error(), expected)
+	}
+}
+
+func TestHandler_truncateDetails(t *testing.T) {
+	details := "line one\nline two\nline three"
+
+	if truncated := truncateDetails(details, 100); truncated != details {
+		t.Errorf("expected details to be unchanged, got %q", truncated)
+	}
+
+	if truncated := truncateDetails(details, 14); truncated != "line one" {
+		t.Errorf("expected %q, got %q", "line one", truncated)
+	}
+
+	if truncated := truncateDetails(details, 4); truncated != "line" {
+		t.Errorf("expected %q, got %q", "line", truncated)
+	}
+}
+
+// Without a bot token, long details should be truncated inline
+func TestHandler_slackSnippetFallback(t *testing.T) {
+	handler := SlackHandler{SnippetThreshold: 9}
+
+	details := handler.uploadSnippet("", &AlertState{Details: "line one\nline two"})
+	expected := "line one\n(output truncated)"
+	if details != expected {
+		t.Errorf("expected %q, got %q", expected, details)
+	}
+}
+
+func TestHandler_dedupeRecipients(t *testing.T) {
+	recipients := dedupeRecipients([]string{
+		"admin@example.com",
+		"ops@example.com",
+		" Admin@Example.com",
+		"not-an-address",
+		"Ops <OPS@example.com>",
+	})
+
+	expected := []string{"admin@example.com", "ops@example.com"}
+	if !reflect.DeepEqual(recipients, expected) {
+		t.Errorf("expected %v, got %v", expected, recipients)
+	}
+}
+
+// A fake SMTP relay that accepts PLAIN auth and records what it receives
+type fakeSMTPServer struct {
+	listener   net.Listener
+	auth       string
+	recipients []string
+	data       string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeSMTPServer{listener: listener}
+	go server.serve()
+	return server
+}
+
+func (s *fakeSMTPServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch command {
+		case "EHLO":
+			fmt.Fprint(conn, "250-localhost\r\n250 AUTH PLAIN\r\n")
+		case "AUTH":
+			s.auth = line
+			fmt.Fprint(conn, "235 ok\r\n")
+		case "RCPT":
+			s.recipients = append(s.recipients, line)
+			fmt.Fprint(conn, "250 ok\r\n")
+		case "DATA":
+			fmt.Fprint(conn, "354 go ahead\r\n")
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil || dataLine == ".\r\n" {
+					break
+				}
+				s.data += dataLine
+			}
+			fmt.Fprint(conn, "250 ok\r\n")
+		case "QUIT":
+			fmt.Fprint(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "250 ok\r\n")
+		}
+	}
+}
+
+func TestHandler_emailSMTP(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	handler := EmailHandler{
+		Recipients:   []string{"admin@example.com", "ops@example.org"},
+		SendMode:     EmailSendTo,
+		Transport:    EmailTransportSMTP,
+		FromAddress:  "alerts@example.com",
+		SMTPHost:     host,
+		SMTPPort:     portNum,
+		SMTPUsername: "user",
+		SMTPPassword: "pass",
+		SMTPTLS:      EmailTLSStartTLS,
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Message: "redis is critical", Details: "check failed"})
+
+	if server.auth == "" {
+		t.Error("expected the relay to be authenticated against")
+	}
+	if len(server.recipients) != 2 {
+		t.Errorf("expected both recipients in a single email, got %v", server.recipients)
+	}
+	if !strings.Contains(server.data, "From: \"Consul Alerting\" <alerts@example.com>") {
+		t.Errorf("expected the configured from address, got: %s", server.data)
+	}
+}
+
+func TestHandler_emailSMTPInvalid(t *testing.T) {
+	cases := []EmailHandler{
+		{SendMode: EmailSendIndividual, Transport: EmailTransportSMTP, SMTPTLS: EmailTLSStartTLS},
+		{SendMode: EmailSendIndividual, Transport: EmailTransportSMTP, SMTPHost: "mail", SMTPTLS: "none"},
+		{SendMode: EmailSendIndividual, Transport: EmailTransportSMTP, SMTPHost: "mail", SMTPTLS: EmailTLSStartTLS, SMTPPassword: "pass"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
 
-	if history.Messages[0].Text != expected {
-		t.Errorf("expected `%s`, got `%s`", expected, history.Messages[0].Text)
+	handler := EmailHandler{SendMode: EmailSendIndividual, Transport: EmailTransportSMTP, SMTPHost: "mail", SMTPTLS: EmailTLSImplicit}
+	if err := handler.validate(); err != nil || handler.SMTPPort != 465 {
+		t.Errorf("expected implicit tls to default to port 465, got %d (%v)", handler.SMTPPort, err)
 	}
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The default base URL of the Twilio api
+const twilioAPIURL = "https://api.twilio.com"
+
+// Twilio splits longer messages into multiple segments and rejects ones over this length
+const twilioBodyLimit = 1600
+
+// TwilioHandler sends alerts as SMS messages through Twilio
+type TwilioHandler struct {
+	AccountSID string   `mapstructure:"account_sid"`
+	AuthToken  string   `mapstructure:"auth_token"`
+	FromNumber string   `mapstructure:"from_number"`
+	Recipients []string `mapstructure:"recipients"`
+	APIURL     string   `mapstructure:"api_url"`
+	MaxRetries int      `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+func (handler *TwilioHandler) validate() error {
+	if handler.AccountSID == "" || handler.AuthToken == "" {
+		return fmt.Errorf("account_sid and auth_token must be set")
+	}
+	if handler.FromNumber == "" {
+		return fmt.Errorf("from_number must be set")
+	}
+	if len(handler.Recipients) == 0 {
+		return fmt.Errorf("at least one number must be set in recipients")
+	}
+
+	handler.APIURL = strings.TrimSuffix(handler.APIURL, "/")
+	return nil
+}
+
+func (handler TwilioHandler) Alert(datacenter string, alert *AlertState) error {
+	body := twilioBody(alert)
+
+	var failed error
+	for _, recipient := range handler.Recipients {
+		err := retryAlert(alert, handler.MaxRetries, "Twilio number "+recipient, func() error {
+			return handler.send(recipient, body)
+		})
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// Sends a single SMS message
+func (handler TwilioHandler) send(to string, body string) error {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", handler.FromNumber)
+	form.Set("Body", body)
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", handler.APIURL, handler.AccountSID)
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(handler.AccountSID, handler.AuthToken)
+
+	_, err = handler.doRequest(req)
+	return err
+}
+
+// Formats an alert for SMS, cutting the details short to fit in a message
+func twilioBody(alert *AlertState) string {
+	body := alert.Message
+	if alert.Details == "" || len(body)+1 >= twilioBodyLimit {
+		return truncateDetails(body, twilioBodyLimit)
+	}
+
+	return body + "\n" + truncateDetails(alert.Details, twilioBodyLimit-len(body)-1)
+}
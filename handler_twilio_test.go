@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_twilio(t *testing.T) {
+	var recipients []string
+	var body, path, user string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		recipients = append(recipients, r.PostForm.Get("To"))
+		body = r.PostForm.Get("Body")
+		path = r.URL.Path
+		user, _, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	handler := TwilioHandler{
+		AccountSID: "AC123",
+		AuthToken:  "token",
+		FromNumber: "+15550000000",
+		Recipients: []string{"+15551111111", "+15552222222"},
+		APIURL:     server.URL,
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Message: "[dc1] redis is critical", Details: "connection refused"})
+
+	if len(recipients) != 2 || recipients[1] != "+15552222222" {
+		t.Errorf("unexpected recipients: %v", recipients)
+	}
+	if body != "[dc1] redis is critical\nconnection refused" {
+		t.Errorf("unexpected body: %s", body)
+	}
+	if path != "/2010-04-01/Accounts/AC123/Messages.json" || user != "AC123" {
+		t.Errorf("unexpected request path %s or user %s", path, user)
+	}
+}
+
+func TestHandler_twilioBodyLimit(t *testing.T) {
+	body := twilioBody(&AlertState{Message: "[dc1] redis is critical", Details: strings.Repeat("output\n", 1000)})
+	if len(body) > twilioBodyLimit {
+		t.Errorf("expected body to fit in %d characters, got %d", twilioBodyLimit, len(body))
+	}
+}
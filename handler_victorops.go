@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// VictorOpsHandler sends alerts to the Splunk On-Call (VictorOps) REST integration
+type VictorOpsHandler struct {
+	RestURL    string `mapstructure:"rest_url"`
+	RoutingKey string `mapstructure:"routing_key"`
+	MaxRetries int    `mapstructure:"max_retries"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// The body accepted by the VictorOps REST integration
+type victorOpsAlert struct {
+	MessageType       string `json:"message_type"`
+	EntityID          string `json:"entity_id"`
+	EntityDisplayName string `json:"entity_display_name"`
+	StateMessage      string `json:"state_message"`
+	MonitoringTool    string `json:"monitoring_tool"`
+}
+
+func (handler *VictorOpsHandler) validate() error {
+	if handler.RestURL == "" {
+		return fmt.Errorf("rest_url must be set")
+	}
+	if handler.RoutingKey == "" {
+		return fmt.Errorf("routing_key must be set")
+	}
+
+	handler.RestURL = strings.TrimSuffix(handler.RestURL, "/")
+	return nil
+}
+
+func (handler VictorOpsHandler) Alert(datacenter string, alert *AlertState) error {
+	messageType := "CRITICAL"
+	if alert.Status == api.HealthPassing {
+		messageType = "RECOVERY"
+	}
+
+	body := victorOpsAlert{
+		MessageType:       messageType,
+		EntityID:          alertIncidentKey(datacenter, alert),
+		EntityDisplayName: alert.Message,
+		StateMessage:      alert.Details,
+		MonitoringTool:    "consul-alerting",
+	}
+
+	url := handler.RestURL + "/" + handler.RoutingKey
+	return retryAlert(alert, handler.MaxRetries, "VictorOps", func() error {
+		_, err := handler.sendJSON("POST", url, nil, body)
+		return err
+	})
+}
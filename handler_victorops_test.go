@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHandler_victorops(t *testing.T) {
+	var received []victorOpsAlert
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body victorOpsAlert
+		data, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("error decoding body: %s", err)
+		}
+		received = append(received, body)
+		paths = append(paths, r.URL.Path)
+	}))
+	defer server.Close()
+
+	handler := VictorOpsHandler{RestURL: server.URL + "/integrations/generic/alert/key/", RoutingKey: "ops"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: api.HealthCritical, Service: "redis", Node: "node1", Message: "redis is critical"}
+	handler.Alert("dc1", alert)
+	alert.Status = api.HealthPassing
+	handler.Alert("dc1", alert)
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(received))
+	}
+	if received[0].MessageType != "CRITICAL" || received[1].MessageType != "RECOVERY" {
+		t.Errorf("expected CRITICAL then RECOVERY, got %s, %s", received[0].MessageType, received[1].MessageType)
+	}
+	if received[0].EntityID != "dc1-redis--node1" || received[0].EntityID != received[1].EntityID {
+		t.Errorf("expected matching entity ids, got %s, %s", received[0].EntityID, received[1].EntityID)
+	}
+	if paths[0] != "/integrations/generic/alert/key/ops" {
+		t.Errorf("unexpected request path: %s", paths[0])
+	}
+}
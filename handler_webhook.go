@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WebhookHandler sends the full alert as JSON to one or more URLs
+type WebhookHandler struct {
+	URLs        []string          `mapstructure:"urls"`
+	Method      string            `mapstructure:"method"`
+	Headers     map[string]string `mapstructure:"headers"`
+	Username    string            `mapstructure:"username"`
+	Password    string            `mapstructure:"password"`
+	BearerToken string            `mapstructure:"bearer_token"`
+	MaxRetries  int               `mapstructure:"max_retries"`
+
+	// The shared secret requests are signed with, and the header the signature is sent in
+	SigningSecret   string `mapstructure:"signing_secret"`
+	SignatureHeader string `mapstructure:"signature_header"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+func (handler *WebhookHandler) validate() error {
+	if len(handler.URLs) == 0 {
+		return fmt.Errorf("at least one url must be set")
+	}
+
+	if handler.BearerToken != "" && handler.Username != "" {
+		return fmt.Errorf("only one of username and bearer_token can be set")
+	}
+
+	if handler.SigningSecret != "" && handler.SignatureHeader == "" {
+		return fmt.Errorf("signature_header can't be empty with signing_secret set")
+	}
+
+	handler.Method = strings.ToUpper(handler.Method)
+	return nil
+}
+
+func (handler WebhookHandler) Alert(datacenter string, alert *AlertState) error {
+	payload := alertPayload{datacenter, alert}
+
+	var failed error
+	for _, url := range handler.URLs {
+		err := retryAlert(alert, handler.MaxRetries, "webhook "+url, func() error {
+			return handler.send(url, payload)
+		})
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// Sends the payload to a single URL
+func (handler WebhookHandler) send(url string, payload alertPayload) error {
+	headers := make(map[string]string)
+	for name, value := range handler.Headers {
+		headers[name] = value
+	}
+
+	if handler.BearerToken != "" {
+		headers["Authorization"] = "Bearer " + handler.BearerToken
+	} else if handler.Username != "" {
+		credentials := handler.Username + ":" + handler.Password
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	if handler.SigningSecret == "" {
+		_, err := handler.sendJSON(handler.Method, url, headers, payload)
+		return err
+	}
+
+	// The signature covers the exact body, so it's encoded here rather than by sendJSON
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding payload: %s", err)
+	}
+	req, err := http.NewRequest(handler.Method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	setWebhookSignature(req, handler.SignatureHeader, handler.SigningSecret, body, time.Now())
+
+	_, err = handler.doRequest(req)
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_webhook(t *testing.T) {
+	var received map[string]interface{}
+	var auth, custom, method string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Errorf("error decoding webhook body: %s", err)
+		}
+		auth = r.Header.Get("Authorization")
+		custom = r.Header.Get("X-Custom")
+		method = r.Method
+	}))
+	defer server.Close()
+
+	handler := WebhookHandler{
+		URLs:        []string{server.URL},
+		Method:      "put",
+		Headers:     map[string]string{"X-Custom": "value"},
+		BearerToken: "token",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{
+		Status:  "critical",
+		Service: "redis",
+		Message: "redis is critical",
+	})
+
+	if received["datacenter"] != "dc1" || received["service"] != "redis" || received["status"] != "critical" {
+		t.Errorf("unexpected webhook body: %v", received)
+	}
+	if method != "PUT" {
+		t.Errorf("expected method PUT, got %s", method)
+	}
+	if auth != "Bearer token" {
+		t.Errorf("expected bearer auth header, got '%s'", auth)
+	}
+	if custom != "value" {
+		t.Errorf("expected custom header 'value', got '%s'", custom)
+	}
+}
+
+func TestHandler_webhookBasicAuth(t *testing.T) {
+	var user, pass string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ = r.BasicAuth()
+	}))
+	defer server.Close()
+
+	handler := WebhookHandler{
+		URLs:     []string{server.URL},
+		Method:   "POST",
+		Username: "alerts",
+		Password: "secret",
+	}
+	handler.Alert("dc1", &AlertState{Status: "passing"})
+
+	if user != "alerts" || pass != "secret" {
+		t.Errorf("expected basic auth alerts/secret, got %s/%s", user, pass)
+	}
+}
+
+func TestHandler_webhookSigned(t *testing.T) {
+	var verified error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		verified = verifyWebhookSignature("shared", body, r.Header, time.Now(), time.Minute)
+	}))
+	defer server.Close()
+
+	handler := WebhookHandler{URLs: []string{server.URL}, Method: "POST", SigningSecret: "shared", SignatureHeader: defaultSignatureHeader}
+	if err := handler.Alert("dc1", &AlertState{Status: "critical", Service: "redis"}); err != nil {
+		t.Fatal(err)
+	}
+	if verified != nil {
+		t.Errorf("expected the signature to verify, got %s", verified)
+	}
+}
+
+func TestHandler_webhookErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	handler := WebhookHandler{URLs: []string{server.URL}, Method: "POST"}
+	err := handler.send(server.URL, alertPayload{"dc1", &AlertState{}})
+	if err == nil {
+		t.Fatal("expected an error for a 503 response")
+	}
+}
+
+func TestHandler_webhookValidate(t *testing.T) {
+	cases := []WebhookHandler{
+		{},
+		{URLs: []string{"http://localhost"}, Username: "user", BearerToken: "token"},
+		{URLs: []string{"http://localhost"}, SigningSecret: "shared"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
+
+func TestHandler_webhookProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Host)
+	}))
+	defer proxy.Close()
+
+	config, err := ParseConfig(fmt.Sprintf(`
+http_proxy = "%s"
+
+handler "webhook" "global" {
+  urls = ["http://alerts.example.com/hook"]
+}
+
+handler "webhook" "own" {
+  urls = ["http://other.example.com/hook"]
+  proxy = "%s"
+}`, proxy.URL, proxy.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"webhook.global", "webhook.own"} {
+		if err := config.Handlers[name].Alert("dc1", &AlertState{Status: "critical"}); err != nil {
+			t.Errorf("error sending through the proxy with %s: %s", name, err)
+		}
+	}
+	if !reflect.DeepEqual(proxied, []string{"alerts.example.com", "other.example.com"}) {
+		t.Errorf("expected both alerts to go through the proxy, got %v", proxied)
+	}
+
+	for _, raw := range []string{
+		`http_proxy = "proxy.example.com:3128"`,
+		`handler "webhook" "bad" {
+  urls = ["http://alerts.example.com/hook"]
+  proxy = "ftp://proxy.example.com"
+}`,
+	} {
+		if _, err := ParseConfig(raw); err == nil || !strings.Contains(err.Error(), "proxy") {
+			t.Errorf("expected a proxy error for %s, got %v", raw, err)
+		}
+	}
+}
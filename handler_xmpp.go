@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// XMPPHandler sends each alert transition as an XMPP message to users and MUC rooms
+type XMPPHandler struct {
+	Server         string   `mapstructure:"server"`
+	JID            string   `mapstructure:"jid"`
+	Password       string   `mapstructure:"password"`
+	Recipients     []string `mapstructure:"recipients"`
+	Rooms          []string `mapstructure:"rooms"`
+	RoomNick       string   `mapstructure:"room_nick"`
+	AllowPlaintext bool     `mapstructure:"allow_plaintext"`
+
+	TLSOptions `mapstructure:",squash"`
+	BusOptions `mapstructure:",squash"`
+
+	publisher *busPublisher
+}
+
+func (handler *XMPPHandler) validate() error {
+	jid, err := parseXMPPJID(handler.JID)
+	if err != nil {
+		return fmt.Errorf("invalid jid: %s", err)
+	}
+	if handler.Password == "" {
+		return fmt.Errorf("password must be set")
+	}
+	if handler.Server != "" {
+		if _, _, err := net.SplitHostPort(handler.Server); err != nil {
+			return fmt.Errorf("invalid server %s: %s", handler.Server, err)
+		}
+	}
+	if len(handler.Recipients) == 0 && len(handler.Rooms) == 0 {
+		return fmt.Errorf("at least one of recipients or rooms must be set")
+	}
+	for i, recipient := range handler.Recipients {
+		if _, err := parseXMPPJID(recipient); err != nil {
+			return fmt.Errorf("recipients[%d]: %s", i, err)
+		}
+	}
+	for i, room := range handler.Rooms {
+		fields := strings.Fields(room)
+		if len(fields) == 0 || len(fields) > 2 {
+			return fmt.Errorf("rooms[%d]: %q must be a room JID, optionally followed by its password", i, room)
+		}
+		if _, err := parseXMPPJID(fields[0]); err != nil {
+			return fmt.Errorf("rooms[%d]: %s", i, err)
+		}
+	}
+	if len(handler.Rooms) > 0 && handler.RoomNick == "" {
+		return fmt.Errorf("room_nick must be set")
+	}
+
+	// The TLS options also apply to STARTTLS, so the config is built even when tls is
+	// off; tls itself selects connecting with TLS directly
+	options := handler.TLSOptions
+	options.TLS = true
+	tlsConfig, err := options.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	config := &xmppConfig{
+		server:         handler.Server,
+		jid:            jid,
+		password:       handler.Password,
+		recipients:     handler.Recipients,
+		rooms:          handler.Rooms,
+		roomNick:       handler.RoomNick,
+		directTLS:      handler.TLS,
+		allowPlaintext: handler.AllowPlaintext,
+		tlsConfig:      tlsConfig,
+	}
+	handler.publisher = newBusPublisher("xmpp account "+handler.JID, func() (busConn, error) {
+		return dialXMPP(config)
+	}, handler.BusOptions)
+
+	return nil
+}
+
+func (handler XMPPHandler) stop() {
+	handler.publisher.stop()
+}
+
+func (handler XMPPHandler) Alert(datacenter string, alert *AlertState) error {
+	return handler.publisher.publish("", alertIncidentKey(datacenter, alert), []byte(xmppMessage(alert)))
+}
+
+// Formats an alert as a summary line like the irc handler's, followed by its details
+func xmppMessage(alert *AlertState) string {
+	lines := []string{fmt.Sprintf("%s: %s", shortStatus(alert.Status), alert.Message)}
+	if alert.Details != "" {
+		lines = append(lines, alert.Details)
+	}
+	if alert.ConsulURL != "" {
+		lines = append(lines, "View in Consul: "+alert.ConsulURL)
+	}
+	return strings.Join(lines, "\n")
+}
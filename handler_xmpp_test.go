@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHandler_xmpp(t *testing.T) {
+	server := newTestXMPPServer(t)
+	defer server.listener.Close()
+
+	handler := XMPPHandler{
+		Server:         server.listener.Addr().String(),
+		JID:            "user@example.org",
+		Password:       "secret",
+		Recipients:     []string{"oncall@example.org"},
+		AllowPlaintext: true,
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Message: "[dc1] redis is critical", Details: "=> (check) redis: down", ConsulURL: "http://consul/ui"})
+
+	for {
+		message := server.next(t)
+		if message.XMLName.Local != "message" {
+			continue
+		}
+		if message.Body != "CRIT: [dc1] redis is critical\n=> (check) redis: down\nView in Consul: http://consul/ui" {
+			t.Errorf("unexpected message: %q", message.Body)
+		}
+		break
+	}
+}
+
+func TestHandler_xmppValidate(t *testing.T) {
+	invalid := []XMPPHandler{
+		{JID: "example.org", Password: "secret", Recipients: []string{"oncall@example.org"}},
+		{JID: "user@example.org", Recipients: []string{"oncall@example.org"}},
+		{JID: "user@example.org", Password: "secret"},
+		{JID: "user@example.org", Password: "secret", Rooms: []string{"ops"}},
+		{JID: "user@example.org", Password: "secret", Recipients: []string{"oncall@example.org"}, Server: "example.org"},
+	}
+	for _, handler := range invalid {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error for %+v", handler)
+		}
+	}
+}
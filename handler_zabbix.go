@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// The default port of Zabbix servers and proxies for trapper items
+const zabbixPort = "10051"
+
+// How long to wait for the Zabbix server to answer
+const zabbixTimeout = 30 * time.Second
+
+// The header of a Zabbix protocol packet, followed by the data length
+var zabbixHeader = []byte("ZBXD\x01")
+
+// The counts in the info of a sender data response, e.g.
+// "processed: 1; failed: 0; total: 1; seconds spent: 0.000041"
+var zabbixFailedPattern = regexp.MustCompile(`failed: (\d+)`)
+
+// The values a Zabbix handler can send
+var zabbixValues = []string{"code", "status", "message", "json"}
+
+// ZabbixHandler sends alerts to trapper items on a Zabbix server or proxy, like
+// zabbix_sender
+type ZabbixHandler struct {
+	Server      string `mapstructure:"server"`
+	Host        string `mapstructure:"host"`
+	DefaultHost string `mapstructure:"default_host"`
+	Key         string `mapstructure:"key"`
+	Value       string `mapstructure:"value"`
+	MaxRetries  int    `mapstructure:"max_retries"`
+
+	hostTemplate *template.Template
+	keyTemplate  *template.Template
+}
+
+// A sender data request and its response
+type zabbixRequest struct {
+	Request string            `json:"request"`
+	Data    []zabbixDataValue `json:"data"`
+	Clock   int64             `json:"clock"`
+}
+
+type zabbixDataValue struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+func (handler *ZabbixHandler) validate() error {
+	if handler.Server == "" {
+		return fmt.Errorf("server must be set")
+	}
+	if _, _, err := net.SplitHostPort(handler.Server); err != nil {
+		handler.Server = net.JoinHostPort(handler.Server, zabbixPort)
+	}
+	if !contains(zabbixValues, handler.Value) {
+		return fmt.Errorf("invalid value %q, must be one of %v", handler.Value, zabbixValues)
+	}
+
+	var err error
+	if handler.hostTemplate, err = compileAlertTemplate("host", handler.Host); err != nil {
+		return err
+	}
+	handler.keyTemplate, err = compileAlertTemplate("key", handler.Key)
+	return err
+}
+
+func (handler ZabbixHandler) Alert(datacenter string, alert *AlertState) error {
+	host := renderAlertTemplateOr(handler.hostTemplate, datacenter, alert, "")
+	if host == "" {
+		host = handler.DefaultHost
+	}
+	if host == "" {
+		return fmt.Errorf("no Zabbix host for alert '%s', set default_host for alerts without a node", alert.Message)
+	}
+	key := renderAlertTemplateOr(handler.keyTemplate, datacenter, alert, "")
+
+	value, err := handler.value(datacenter, alert)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	request, err := json.Marshal(zabbixRequest{
+		Request: "sender data",
+		Data:    []zabbixDataValue{{Host: host, Key: key, Value: value, Clock: now}},
+		Clock:   now,
+	})
+	if err != nil {
+		return err
+	}
+
+	return retryAlert(alert, handler.MaxRetries, "Zabbix server "+handler.Server, func() error {
+		return zabbixSend(handler.Server, request)
+	})
+}
+
+// Returns the item value sent for an alert
+func (handler ZabbixHandler) value(datacenter string, alert *AlertState) (string, error) {
+	switch handler.Value {
+	case "status":
+		return alert.Status, nil
+	case "message":
+		if alert.Details == "" {
+			return alert.Message, nil
+		}
+		return alert.Message + "\n" + alert.Details, nil
+	case "json":
+		payload, err := json.Marshal(alertPayload{datacenter, alert})
+		return string(payload), err
+	}
+	return strconv.Itoa(alertStatusCode(alert.Status)), nil
+}
+
+// Sends a request to a Zabbix server, returning an error if it wasn't processed
+func zabbixSend(server string, request []byte) error {
+	conn, err := net.DialTimeout("tcp", server, zabbixTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(zabbixTimeout))
+
+	if _, err := conn.Write(zabbixPacket(request)); err != nil {
+		return err
+	}
+
+	body, err := readZabbixPacket(conn)
+	if err != nil {
+		return fmt.Errorf("error reading response: %s", err)
+	}
+	var response zabbixResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("invalid response: %s", err)
+	}
+	if response.Response != "success" {
+		return fmt.Errorf("request failed: %s", response.Info)
+	}
+
+	// Values for hosts or items that don't exist, or aren't trapper items, are counted as
+	// failed in an otherwise successful response
+	if match := zabbixFailedPattern.FindStringSubmatch(response.Info); match != nil && match[1] != "0" {
+		return fmt.Errorf("value not processed, check the host and trapper item exist: %s", response.Info)
+	}
+	return nil
+}
+
+// Frames data as a Zabbix protocol packet: the header, then the data length as a
+// little-endian 64-bit integer
+func zabbixPacket(data []byte) []byte {
+	var packet bytes.Buffer
+	packet.Write(zabbixHeader)
+	binary.Write(&packet, binary.LittleEndian, uint64(len(data)))
+	packet.Write(data)
+	return packet.Bytes()
+}
+
+func readZabbixPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(zabbixHeader)], zabbixHeader) {
+		return nil, fmt.Errorf("invalid header %q", header[:len(zabbixHeader)])
+	}
+	length := binary.LittleEndian.Uint64(header[len(zabbixHeader):])
+	if length > 1<<20 {
+		return nil, fmt.Errorf("response of %d bytes is too large", length)
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Starts a trapper listener that records sender data requests and answers each one
+// with the given info
+func testZabbixServer(t *testing.T, info string) (string, chan zabbixRequest) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	requests := make(chan zabbixRequest, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			data, err := readZabbixPacket(conn)
+			if err != nil {
+				t.Error(err)
+				conn.Close()
+				continue
+			}
+			var request zabbixRequest
+			json.Unmarshal(data, &request)
+			requests <- request
+
+			response, _ := json.Marshal(zabbixResponse{Response: "success", Info: info})
+			conn.Write(zabbixPacket(response))
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), requests
+}
+
+func TestHandler_zabbix(t *testing.T) {
+	addr, requests := testZabbixServer(t, "processed: 1; failed: 0; total: 1; seconds spent: 0.000041")
+
+	config, err := ParseConfig(`
+handler "zabbix" "trapper" {
+  server = "` + addr + `"
+  default_host = "consul"
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := config.Handlers["zabbix.trapper"]
+
+	alert := &AlertState{Status: api.HealthWarning, Node: "web1", Service: "redis", Message: "redis is warning"}
+	if err := handler.Alert("dc1", alert); err != nil {
+		t.Fatal(err)
+	}
+	request := <-requests
+	if request.Request != "sender data" || len(request.Data) != 1 {
+		t.Fatalf("unexpected request: %+v", request)
+	}
+	value := request.Data[0]
+	if value.Host != "web1" || value.Key != "consul.health[redis]" || value.Value != "1" {
+		t.Errorf("unexpected value: %+v", value)
+	}
+
+	// Alerts without a node go to the default host
+	alert = &AlertState{Status: api.HealthPassing, Service: "redis", Message: "redis is passing"}
+	if err := handler.Alert("dc1", alert); err != nil {
+		t.Fatal(err)
+	}
+	value = (<-requests).Data[0]
+	if value.Host != "consul" || value.Value != "0" {
+		t.Errorf("unexpected value: %+v", value)
+	}
+}
+
+func TestHandler_zabbixFailed(t *testing.T) {
+	addr, _ := testZabbixServer(t, "processed: 0; failed: 1; total: 1; seconds spent: 0.000041")
+
+	handler := ZabbixHandler{Server: addr, Host: "{{.Node}}", Key: "consul.health", Value: "status"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+	alert := &AlertState{Status: api.HealthCritical, Node: "web1", Message: "web1 is critical"}
+	if err := handler.Alert("dc1", alert); err == nil {
+		t.Error("expected an error for a value the server didn't process")
+	}
+}
+
+func TestHandler_zabbixInvalid(t *testing.T) {
+	cases := []ZabbixHandler{
+		{Value: "code"},
+		{Server: "zabbix.example.com", Value: "state"},
+		{Server: "zabbix.example.com", Value: "code", Key: "{{.Missing}}"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+
+	handler := ZabbixHandler{Server: "zabbix.example.com", Value: "code"}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if handler.Server != "zabbix.example.com:10051" {
+		t.Errorf("expected the default port to be added, got %s", handler.Server)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+)
+
+// A deadman switch pinged while the daemon is healthy, such as a Healthchecks.io check,
+// a Dead Man's Snitch or a PagerDuty heartbeat, so the alerter dying doesn't go unnoticed
+type HeartbeatConfig struct {
+	Name     string
+	URL      string            `mapstructure:"url"`
+	FailURL  string            `mapstructure:"fail_url"`
+	Method   string            `mapstructure:"method"`
+	Headers  map[string]string `mapstructure:"headers"`
+	Interval int               `mapstructure:"interval"`
+
+	HTTPOptions `mapstructure:",squash"`
+}
+
+// Parse the raw heartbeat objects into the config
+func parseHeartbeats(list *ast.ObjectList, config *Config) error {
+	config.Heartbeats = make([]HeartbeatConfig, 0, len(list.Items))
+
+	for _, h := range list.Items {
+		if len(h.Keys) != 1 {
+			return fmt.Errorf("heartbeat must be in the form 'heartbeat \"name\" {}'")
+		}
+		name := h.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var heartbeat HeartbeatConfig
+		if err := hcl.DecodeObject(&m, h.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &heartbeat); err != nil {
+			return err
+		}
+		heartbeat.Name = name
+
+		if err := heartbeat.validate(); err != nil {
+			return fmt.Errorf("Error loading heartbeat %s: %s", name, err)
+		}
+		config.Heartbeats = append(config.Heartbeats, heartbeat)
+	}
+
+	return nil
+}
+
+func (heartbeat *HeartbeatConfig) validate() error {
+	if heartbeat.URL == "" {
+		return fmt.Errorf("url must be set")
+	}
+	for option, raw := range map[string]string{"url": heartbeat.URL, "fail_url": heartbeat.FailURL} {
+		if raw == "" {
+			continue
+		}
+		if parsed, err := url.Parse(raw); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%s must be an http or https URL", option)
+		}
+	}
+
+	heartbeat.Method = strings.ToUpper(heartbeat.Method)
+	if heartbeat.Method == "" {
+		heartbeat.Method = "GET"
+	}
+	if !contains([]string{"GET", "HEAD", "POST", "PUT"}, heartbeat.Method) {
+		return fmt.Errorf("invalid method %s, must be GET, HEAD, POST or PUT", heartbeat.Method)
+	}
+
+	if heartbeat.Interval == 0 {
+		heartbeat.Interval = 60
+	}
+	if heartbeat.Interval < 1 {
+		return fmt.Errorf("interval must be at least 1")
+	}
+	return heartbeat.setupHTTPClient()
+}
+
+// Pings the heartbeat every interval. Standby instances and dry runs don't ping, so the
+// deadman switch only hears from the instance that is actually sending alerts.
+func runHeartbeat(heartbeat HeartbeatConfig) {
+	log.Infof("Pinging heartbeat %s every %ds", heartbeat.Name, heartbeat.Interval)
+	ticker := time.NewTicker(time.Duration(heartbeat.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if activeInstance.isActive() && !isDryRun() {
+			ready, states := daemonReadiness.status()
+			if err := heartbeat.ping(ready, states); err != nil {
+				log.Errorf("Error pinging heartbeat %s: %s", heartbeat.Name, err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// Pings the url while every component is ready, or the fail_url once one has failed.
+// Nothing is sent while components are still starting up. The body of POST and PUT
+// requests lists the state of each component.
+func (heartbeat HeartbeatConfig) ping(ready bool, states map[string]string) error {
+	target := heartbeat.URL
+	if !ready {
+		failed := false
+		for _, state := range states {
+			if state != "ok" && state != "pending" {
+				failed = true
+			}
+		}
+		if !failed || heartbeat.FailURL == "" {
+			log.Debugf("Not pinging heartbeat %s, consul-alerting isn't ready", heartbeat.Name)
+			return nil
+		}
+		target = heartbeat.FailURL
+	}
+
+	components := make([]string, 0, len(states))
+	for component := range states {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	lines := make([]string, 0, len(components))
+	for _, component := range components {
+		lines = append(lines, fmt.Sprintf("%s: %s", component, states[component]))
+	}
+
+	var body *strings.Reader
+	if heartbeat.Method == "POST" || heartbeat.Method == "PUT" {
+		body = strings.NewReader(strings.Join(lines, "\n"))
+	} else {
+		body = strings.NewReader("")
+	}
+	req, err := http.NewRequest(heartbeat.Method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	for name, value := range heartbeat.Headers {
+		req.Header.Set(name, value)
+	}
+
+	// The ping URL is often the secret of the check, so it's left out of the error
+	_, err = heartbeat.doRequest(req)
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	return err
+}
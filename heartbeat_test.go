@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeartbeat_ping(t *testing.T) {
+	type ping struct {
+		path string
+		body string
+	}
+	pings := make(chan ping, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		pings <- ping{r.URL.Path, string(body)}
+	}))
+	defer server.Close()
+
+	config, err := ParseConfig(`
+heartbeat "healthchecks" {
+  url = "` + server.URL + `/check"
+  fail_url = "` + server.URL + `/check/fail"
+  method = "post"
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heartbeat := config.Heartbeats[0]
+	if heartbeat.Name != "healthchecks" || heartbeat.Interval != 60 || heartbeat.Method != "POST" {
+		t.Fatalf("unexpected heartbeat: %+v", heartbeat)
+	}
+
+	if err := heartbeat.ping(true, map[string]string{"consul": "ok", "services": "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-pings; p.path != "/check" || p.body != "consul: ok\nservices: ok" {
+		t.Errorf("unexpected ping: %+v", p)
+	}
+
+	if err := heartbeat.ping(false, map[string]string{"consul": "connection refused"}); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-pings; p.path != "/check/fail" {
+		t.Errorf("expected a ping to the fail_url, got %+v", p)
+	}
+
+	// Nothing is sent while starting up
+	if err := heartbeat.ping(false, map[string]string{"consul": "ok", "services": "pending"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(pings) != 0 {
+		t.Error("expected no ping while pending")
+	}
+}
+
+func TestHeartbeat_validate(t *testing.T) {
+	for _, raw := range []string{
+		`heartbeat "a" {}`,
+		`heartbeat "a" { url = "hc-ping.com/abc" }`,
+		`heartbeat "a" { url = "https://hc-ping.com/abc" method = "DELETE" }`,
+		`heartbeat "a" { url = "https://hc-ping.com/abc" interval = -1 }`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Errorf("expected an error for %s", raw)
+		}
+	}
+}
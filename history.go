@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A change in the status of an alert, as sent to the handlers
+type alertTransition struct {
+	Time           time.Time `json:"time"`
+	Datacenter     string    `json:"datacenter"`
+	Service        string    `json:"service,omitempty"`
+	Tag            string    `json:"tag,omitempty"`
+	Node           string    `json:"node,omitempty"`
+	Check          string    `json:"check,omitempty"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Message        string    `json:"message"`
+}
+
+// The most recent alert transitions, oldest first. If a file is set the transitions are
+// also appended to it, and it's rewritten with just the kept ones once it has grown to
+// twice the size of the history.
+type alertHistory struct {
+	sync.Mutex
+	size        int
+	transitions []alertTransition
+	file        string
+	written     int
+}
+
+var history = &alertHistory{size: 1000}
+
+// Sets up the history with the configured size, loading the transitions kept in its file
+func setupHistory(config *Config) error {
+	history.Lock()
+	defer history.Unlock()
+
+	history.size = config.HistorySize
+	history.file = config.HistoryFile
+	history.transitions = nil
+	if history.file == "" {
+		return nil
+	}
+
+	f, err := os.Open(history.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error loading alert history: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var transition alertTransition
+		if err := json.Unmarshal(scanner.Bytes(), &transition); err != nil {
+			log.Warnf("Skipping invalid alert history entry: %s", err)
+			continue
+		}
+		history.append(transition)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error loading alert history: %s", err)
+	}
+	return history.compact()
+}
+
+// Records an alert sent to the handlers. The alert's LastAlerted is still the status it
+// had before.
+func (h *alertHistory) record(datacenter string, alert *AlertState, now time.Time) {
+	transition := alertTransition{
+		Time:           now.UTC(),
+		Datacenter:     datacenter,
+		Service:        alert.Service,
+		Tag:            alert.Tag,
+		Node:           alert.Node,
+		Check:          alert.Check,
+		Status:         alert.Status,
+		PreviousStatus: alert.LastAlerted,
+		Message:        alert.Message,
+	}
+	alertEvents.publish(transition)
+
+	h.Lock()
+	defer h.Unlock()
+	h.append(transition)
+	if h.file == "" {
+		return
+	}
+
+	if err := h.write(transition); err != nil {
+		log.Errorf("Error writing alert history: %s", err)
+	}
+	if h.written >= 2*h.size {
+		if err := h.compact(); err != nil {
+			log.Errorf("Error compacting alert history: %s", err)
+		}
+	}
+}
+
+// Adds a transition, dropping the oldest once the history is full. Called with the lock held.
+func (h *alertHistory) append(transition alertTransition) {
+	h.transitions = append(h.transitions, transition)
+	if len(h.transitions) > h.size {
+		h.transitions = h.transitions[len(h.transitions)-h.size:]
+	}
+}
+
+// Appends a transition to the history file. Called with the lock held.
+func (h *alertHistory) write(transition alertTransition) error {
+	line, err := json.Marshal(transition)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	h.written++
+	return f.Close()
+}
+
+// Rewrites the history file with only the kept transitions. Called with the lock held.
+func (h *alertHistory) compact() error {
+	f, err := os.OpenFile(h.file+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, transition := range h.transitions {
+		line, _ := json.Marshal(transition)
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	h.written = len(h.transitions)
+	return os.Rename(h.file+".tmp", h.file)
+}
+
+// Filters the alert history: by service, node and datacenter when set, and to the
+// transitions between since and until when they aren't zero
+type historyFilter struct {
+	service    string
+	node       string
+	datacenter string
+	since      time.Time
+	until      time.Time
+}
+
+func (f historyFilter) matches(transition alertTransition) bool {
+	switch {
+	case f.service != "" && transition.Service != f.service:
+		return false
+	case f.node != "" && transition.Node != f.node:
+		return false
+	case f.datacenter != "" && transition.Datacenter != f.datacenter:
+		return false
+	case !f.since.IsZero() && transition.Time.Before(f.since):
+		return false
+	case !f.until.IsZero() && transition.Time.After(f.until):
+		return false
+	}
+	return true
+}
+
+// Returns the matching transitions, newest first, up to limit if it's positive
+func (h *alertHistory) query(filter historyFilter, limit int) []alertTransition {
+	h.Lock()
+	defer h.Unlock()
+
+	matches := make([]alertTransition, 0)
+	for i := len(h.transitions) - 1; i >= 0; i-- {
+		if limit > 0 && len(matches) >= limit {
+			break
+		}
+		if filter.matches(h.transitions[i]) {
+			matches = append(matches, h.transitions[i])
+		}
+	}
+	return matches
+}
+
+// Parses a time given as RFC 3339 or as a duration before now, like "2h"
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or a duration", value)
+	}
+	return t, nil
+}
+
+// Serves the alert history, newest first, filtered by the service, node, datacenter,
+// since, until and limit query parameters
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	now := time.Now()
+	filter := historyFilter{
+		service:    query.Get("service"),
+		node:       query.Get("node"),
+		datacenter: query.Get("datacenter"),
+	}
+
+	var err error
+	if filter.since, err = parseHistoryTime(query.Get("since"), now); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.until, err = parseHistoryTime(query.Get("until"), now); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history.query(filter, limit))
+}
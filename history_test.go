@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory_bounded(t *testing.T) {
+	h := &alertHistory{size: 2}
+	now := time.Now()
+	for _, status := range []string{"warning", "critical", "passing"} {
+		h.record("dc1", &AlertState{Service: "redis", Status: status}, now)
+	}
+
+	transitions := h.query(historyFilter{}, 0)
+	if len(transitions) != 2 || transitions[0].Status != "passing" || transitions[1].Status != "critical" {
+		t.Errorf("expected the last 2 transitions newest first, got %+v", transitions)
+	}
+}
+
+func TestHistory_handler(t *testing.T) {
+	setupHistory(&Config{HistorySize: 10})
+	defer setupHistory(&Config{HistorySize: 1000})
+
+	now := time.Now()
+	history.record("dc1", &AlertState{Service: "redis", Status: "critical", LastAlerted: "passing"}, now.Add(-3*time.Hour))
+	history.record("dc1", &AlertState{Service: "redis", Status: "passing", LastAlerted: "critical"}, now.Add(-time.Hour))
+	history.record("dc1", &AlertState{Node: "node1", Status: "warning"}, now)
+
+	w := httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest("GET", "/v1/history?service=redis&since=2h", nil))
+	var transitions []alertTransition
+	if err := json.Unmarshal(w.Body.Bytes(), &transitions); err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions) != 1 || transitions[0].Status != "passing" || transitions[0].PreviousStatus != "critical" {
+		t.Errorf("unexpected transitions: %+v", transitions)
+	}
+
+	w = httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest("GET", "/v1/history?limit=2", nil))
+	transitions = nil
+	json.Unmarshal(w.Body.Bytes(), &transitions)
+	if len(transitions) != 2 || transitions[0].Node != "node1" {
+		t.Errorf("expected the 2 newest transitions, got %+v", transitions)
+	}
+
+	w = httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest("GET", "/v1/history?since=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid time, got %d", w.Code)
+	}
+}
+
+func TestHistory_persisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer setupHistory(&Config{HistorySize: 1000})
+
+	config := &Config{HistorySize: 2, HistoryFile: filepath.Join(dir, "history.json")}
+	if err := setupHistory(config); err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []string{"warning", "critical", "passing", "critical"} {
+		history.record("dc1", &AlertState{Service: "redis", Status: status}, time.Now())
+	}
+
+	// The file is compacted once it holds twice the history
+	contents, _ := ioutil.ReadFile(config.HistoryFile)
+	if lines := strings.Count(string(contents), "\n"); lines != 2 {
+		t.Errorf("expected the file to be compacted to 2 lines, got %d", lines)
+	}
+
+	if err := setupHistory(config); err != nil {
+		t.Fatal(err)
+	}
+	transitions := history.query(historyFilter{}, 0)
+	if len(transitions) != 2 || transitions[0].Status != "critical" || transitions[1].Status != "passing" {
+		t.Errorf("expected the history to be reloaded, got %+v", transitions)
+	}
+}
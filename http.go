@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Starts the HTTP server for the dashboard and the metrics, health, acknowledgement and webhook endpoints, if an address is configured
+func startHTTPServer(config *Config, client *api.Client) {
+	if config.HTTPAddress == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler(config))
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.HandleFunc("/v1/ack", requireAPIToken(config.APIToken, ackHandler(config, client)))
+	mux.HandleFunc("/v1/alerts", requireAPIToken(config.APIToken, alertsHandler(config)))
+	mux.HandleFunc("/v1/silences", requireAPIToken(config.APIToken, silencesHandler(config, client)))
+	mux.HandleFunc("/v1/watches", requireAPIToken(config.APIToken, watchesHandler))
+	mux.HandleFunc("/v1/history", requireAPIToken(config.APIToken, historyHandler))
+	mux.HandleFunc("/v1/status", requireAPIToken(config.APIToken, statusHandler(config)))
+	mux.HandleFunc("/ui", requireAPIToken(config.APIToken, dashboardHandler(config)))
+	mux.HandleFunc("/v1/slack/actions", slackActionsHandler(config, client))
+	mux.HandleFunc("/v1/pagerduty/webhook", pagerdutyWebhookHandler(config, client))
+	if config.ReceiveAlerts {
+		mux.HandleFunc("/v1/receive", requireAPIToken(config.APIToken, receiveHandler(config, client, false)))
+		mux.HandleFunc("/v1/receive/alertmanager", requireAPIToken(config.APIToken, receiveHandler(config, client, true)))
+	}
+
+	log.Infof("Serving HTTP endpoints on %s", config.HTTPAddress)
+	go func() {
+		if err := http.ListenAndServe(config.HTTPAddress, mux); err != nil {
+			log.Fatal("Error running HTTP server: ", err)
+		}
+	}()
+}
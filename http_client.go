@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The timeout for outbound HTTP requests made by handlers
+const handlerHTTPTimeout = 30 * time.Second
+
+// The client used by handlers for outbound HTTP requests, going through the proxy set in
+// the HTTPS_PROXY and HTTP_PROXY environment variables if any
+var handlerHTTPClient = &http.Client{Timeout: handlerHTTPTimeout}
+
+// HTTP options shared by handlers that send alerts over HTTP
+type HTTPOptions struct {
+	Proxy string `mapstructure:"proxy"`
+
+	client *http.Client
+}
+
+// Implemented by handlers sending alerts over HTTP, to set up their client once decoded
+type httpClientHandler interface {
+	setupHTTPClient() error
+}
+
+// Makes sure a proxy is an absolute http(s) or socks5 URL
+func parseProxyURL(raw string) (*url.URL, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil || !contains([]string{"http", "https", "socks5"}, parsed.Scheme) || parsed.Host == "" {
+		return nil, fmt.Errorf("%q isn't an http, https or socks5 URL", raw)
+	}
+	return parsed, nil
+}
+
+// Sets up a client going through the handler's proxy, if it has one
+func (options *HTTPOptions) setupHTTPClient() error {
+	if options.Proxy == "" {
+		options.client = nil
+		return nil
+	}
+
+	proxy, err := parseProxyURL(options.Proxy)
+	if err != nil {
+		return fmt.Errorf("Invalid proxy: %s", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxy)
+	options.client = &http.Client{Timeout: handlerHTTPTimeout, Transport: transport}
+	return nil
+}
+
+func (options HTTPOptions) httpClient() *http.Client {
+	if options.client != nil {
+		return options.client
+	}
+	return handlerHTTPClient
+}
+
+// Sends a request with the given payload encoded as JSON, returning the response body.
+// Responses with a non-2xx status code are returned as errors.
+func sendJSON(method string, url string, headers map[string]string, payload interface{}) ([]byte, error) {
+	return HTTPOptions{}.sendJSON(method, url, headers, payload)
+}
+
+// Sends a request using the default handler HTTP client, returning the response body
+func doRequest(req *http.Request) ([]byte, error) {
+	return HTTPOptions{}.doRequest(req)
+}
+
+// Sends a GET request with the default handler HTTP client and decodes the JSON response
+// into out
+func getJSON(url string, headers map[string]string, out interface{}) error {
+	return HTTPOptions{}.getJSON(url, headers, out)
+}
+
+// Sends a request with the given payload encoded as JSON using the handler's client,
+// returning the response body
+func (options HTTPOptions) sendJSON(method string, url string, headers map[string]string, payload interface{}) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding payload: %s", err)
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	return options.doRequest(req)
+}
+
+// Sends a request using the handler's client, returning the response body.
+// Responses with a non-2xx status code are returned as errors.
+func (options HTTPOptions) doRequest(req *http.Request) ([]byte, error) {
+	resp, err := options.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %s", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return respBody, fmt.Errorf("unexpected response code %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+
+	return respBody, nil
+}
+
+// Sends a GET request using the handler's client and decodes the JSON response into out
+func (options HTTPOptions) getJSON(url string, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	body, err := options.doRequest(req)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("error decoding response: %s", err)
+	}
+	return nil
+}
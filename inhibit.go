@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+)
+
+// A rule suppressing the alerts of services while a check they depend on is failing,
+// such as the alerts of the services on a node whose serfHealth is critical. The rule's
+// source is any watched check matching source_service and source_check with at least
+// source_status; its targets are the alerts of the services matching target_service in
+// the same datacenter. With equal = ["node"], an alert is only suppressed when all of its
+// failing checks are on nodes with a failing source.
+type InhibitRuleConfig struct {
+	Name          string   `json:"name"`
+	SourceService string   `mapstructure:"source_service" json:"source_service,omitempty"`
+	SourceCheck   string   `mapstructure:"source_check" json:"source_check,omitempty"`
+	SourceStatus  string   `mapstructure:"source_status" json:"source_status"`
+	TargetService string   `mapstructure:"target_service" json:"target_service,omitempty"`
+	Equal         []string `mapstructure:"equal" json:"equal,omitempty"`
+
+	sourceService *regexp.Regexp
+	sourceCheck   *regexp.Regexp
+	targetService *regexp.Regexp
+}
+
+// Parse the raw inhibit_rule objects into the config
+func parseInhibitRules(list *ast.ObjectList, config *Config) error {
+	config.InhibitRules = make([]InhibitRuleConfig, 0, len(list.Items))
+
+	for _, r := range list.Items {
+		if len(r.Keys) != 1 {
+			return fmt.Errorf("inhibit_rule must be in the form 'inhibit_rule \"name\" {}'")
+		}
+		name := r.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var rule InhibitRuleConfig
+		if err := hcl.DecodeObject(&m, r.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &rule); err != nil {
+			return err
+		}
+		rule.Name = name
+
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("Error loading inhibit_rule %s: %s", name, err)
+		}
+		config.InhibitRules = append(config.InhibitRules, rule)
+	}
+
+	return nil
+}
+
+func (rule *InhibitRuleConfig) validate() error {
+	if rule.SourceService == "" && rule.SourceCheck == "" {
+		return fmt.Errorf("at least one of source_service or source_check must be set")
+	}
+
+	var err error
+	if rule.sourceService, err = compileRouteRegexp(rule.SourceService); err != nil {
+		return fmt.Errorf("invalid source_service: %s", err)
+	}
+	if rule.sourceCheck, err = compileRouteRegexp(rule.SourceCheck); err != nil {
+		return fmt.Errorf("invalid source_check: %s", err)
+	}
+	if rule.targetService, err = compileRouteRegexp(rule.TargetService); err != nil {
+		return fmt.Errorf("invalid target_service: %s", err)
+	}
+
+	if rule.SourceStatus == "" {
+		rule.SourceStatus = api.HealthCritical
+	}
+	if rule.SourceStatus != api.HealthWarning && rule.SourceStatus != api.HealthCritical {
+		return fmt.Errorf("invalid source_status %s, must be warning or critical", rule.SourceStatus)
+	}
+
+	for _, field := range rule.Equal {
+		if field != "node" {
+			return fmt.Errorf("invalid equal field %s, only node is supported", field)
+		}
+	}
+	return nil
+}
+
+// Returns whether a check is a failing source of the rule
+func (rule *InhibitRuleConfig) isSource(check *api.HealthCheck) bool {
+	if check.Status != api.HealthCritical && (rule.SourceStatus == api.HealthCritical || check.Status != api.HealthWarning) {
+		return false
+	}
+	if rule.sourceService != nil && !rule.sourceService.MatchString(check.ServiceName) {
+		return false
+	}
+	if rule.sourceCheck != nil && !rule.sourceCheck.MatchString(check.CheckID) && !rule.sourceCheck.MatchString(check.Name) {
+		return false
+	}
+	return true
+}
+
+// Returns whether the rule suppresses an alert for a service, given the checks of the
+// watches in the alert's datacenter. An alert with failing checks that are sources of the
+// rule, like the alert of a node whose serfHealth is critical, is never suppressed.
+func (rule *InhibitRuleConfig) inhibits(service string, alert *AlertState, checks []*api.HealthCheck) bool {
+	if rule.targetService != nil && !rule.targetService.MatchString(service) {
+		return false
+	}
+
+	sourceNodes := make(map[string]bool)
+	for _, check := range checks {
+		if rule.isSource(check) {
+			sourceNodes[check.Node] = true
+		}
+	}
+	if len(sourceNodes) == 0 || rule.ownAlert(service, alert, checks) {
+		return false
+	}
+	if !contains(rule.Equal, "node") {
+		return true
+	}
+
+	covered, uncovered := 0, 0
+	for _, check := range checks {
+		if check.ServiceName != service || check.Status == api.HealthPassing || rule.isSource(check) {
+			continue
+		}
+		if alert.Node != "" && check.Node != alert.Node {
+			continue
+		}
+		if sourceNodes[check.Node] {
+			covered++
+		} else {
+			uncovered++
+		}
+	}
+
+	if covered+uncovered == 0 {
+		// Alerts not raised from the watched checks, like registration alerts, are
+		// matched by their node
+		return alert.Node != "" && sourceNodes[alert.Node]
+	}
+	return uncovered == 0
+}
+
+// Returns whether an alert is raised by the rule's own sources, which it never suppresses
+func (rule *InhibitRuleConfig) ownAlert(service string, alert *AlertState, checks []*api.HealthCheck) bool {
+	for _, check := range checks {
+		if check.ServiceName == service && (alert.Node == "" || check.Node == alert.Node) && rule.isSource(check) {
+			return true
+		}
+	}
+	return false
+}
+
+// The checks last seen by each running watch, which inhibit rules look for their
+// sources in
+type checkRegistry struct {
+	sync.Mutex
+	watches map[string]watchedCheckSet
+}
+
+type watchedCheckSet struct {
+	datacenter string
+	checks     []*api.HealthCheck
+}
+
+var watchedChecks = &checkRegistry{watches: make(map[string]watchedCheckSet)}
+
+// Replaces the checks last seen by a watch
+func (r *checkRegistry) set(watch string, datacenter string, checks []*api.HealthCheck) {
+	r.Lock()
+	defer r.Unlock()
+	r.watches[watch] = watchedCheckSet{datacenter, checks}
+}
+
+func (r *checkRegistry) remove(watch string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.watches, watch)
+}
+
+// Returns the checks seen by the watches of a datacenter
+func (r *checkRegistry) datacenter(datacenter string) []*api.HealthCheck {
+	r.Lock()
+	defer r.Unlock()
+	checks := make([]*api.HealthCheck, 0)
+	for _, set := range r.watches {
+		if set.datacenter == datacenter {
+			checks = append(checks, set.checks...)
+		}
+	}
+	return checks
+}
+
+// An alert held back by an inhibit rule, along with what's needed to send it once the
+// rule no longer applies
+type inhibitedAlert struct {
+	rule    string
+	config  *Config
+	service string
+	tags    []string
+	alert   AlertState
+}
+
+// Tracks the alerts held back by inhibit rules, so their recoveries are held back too,
+// keyed by datacenter/service/tag/node/check
+type inhibitTracker struct {
+	sync.Mutex
+	inhibited map[string]*inhibitedAlert
+}
+
+var inhibitions = &inhibitTracker{inhibited: make(map[string]*inhibitedAlert)}
+
+// Returns the name of the first inhibit rule suppressing an alert, or ""
+func inhibitingRule(config *Config, service string, alert *AlertState) string {
+	if len(config.InhibitRules) == 0 {
+		return ""
+	}
+	checks := watchedChecks.datacenter(config.ConsulDatacenter)
+	for i := range config.InhibitRules {
+		if rule := &config.InhibitRules[i]; rule.inhibits(service, alert, checks) {
+			return rule.Name
+		}
+	}
+	return ""
+}
+
+// Returns the name of the inhibit rule suppressing an alert, or "" if it should be sent,
+// along with whether the alert was held back until now, in which case its handlers were
+// never sent it. The recovery of a held back alert is suppressed as well.
+func (t *inhibitTracker) check(config *Config, service string, tags []string, alert *AlertState) (string, bool) {
+	key := alertIncidentKey(config.ConsulDatacenter, alert) + "-" + alert.Check
+
+	t.Lock()
+	defer t.Unlock()
+	entry, held := t.inhibited[key]
+	if alert.Status == api.HealthPassing {
+		delete(t.inhibited, key)
+		if held {
+			return entry.rule, false
+		}
+		return "", false
+	}
+
+	if rule := inhibitingRule(config, service, alert); rule != "" {
+		t.inhibited[key] = &inhibitedAlert{rule, config, service, tags, *alert}
+		return rule, false
+	}
+	delete(t.inhibited, key)
+	return "", held
+}
+
+// Sends the held back alerts whose inhibit rules no longer apply, such as once the node
+// they're on is healthy again while they're still failing
+func (t *inhibitTracker) release() {
+	t.Lock()
+	released := make([]*inhibitedAlert, 0)
+	for key, entry := range t.inhibited {
+		if inhibitingRule(latestConfig(entry.config), entry.service, &entry.alert) == "" {
+			delete(t.inhibited, key)
+			released = append(released, entry)
+		}
+	}
+	t.Unlock()
+
+	for _, entry := range released {
+		log.Infof("Alert '%s' is no longer inhibited by rule %s", entry.alert.Message, entry.rule)
+		notifyHandlers(entry.config, entry.service, entry.tags, &entry.alert, api.HealthPassing)
+	}
+}
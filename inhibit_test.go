@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestInhibit_parse(t *testing.T) {
+	config, err := ParseConfig(`
+inhibit_rule "node-down" {
+  source_check = "serfHealth"
+  equal = ["node"]
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.InhibitRules) != 1 || config.InhibitRules[0].SourceStatus != api.HealthCritical {
+		t.Fatalf("unexpected inhibit rules: %+v", config.InhibitRules)
+	}
+
+	for _, raw := range []string{
+		`inhibit_rule "nosource" { target_service = "web" }`,
+		`inhibit_rule "badpattern" { source_service = "(" }`,
+		`inhibit_rule "badstatus" { source_service = "db" source_status = "passing" }`,
+		`inhibit_rule "badequal" { source_service = "db" equal = ["tag"] }`,
+	} {
+		if _, err := ParseConfig(raw); err == nil || !strings.Contains(err.Error(), "Error loading inhibit_rule") {
+			t.Errorf("expected an error for %s, got %v", raw, err)
+		}
+	}
+}
+
+func TestInhibit_inhibits(t *testing.T) {
+	config, err := ParseConfig(`
+inhibit_rule "node-down" {
+  source_check = "serfHealth"
+  equal = ["node"]
+}
+
+inhibit_rule "db-down" {
+  source_service = "db"
+  target_service = "api"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeDown, dbDown := &config.InhibitRules[0], &config.InhibitRules[1]
+
+	checks := []*api.HealthCheck{
+		{Node: "node1", CheckID: "serfHealth", Status: api.HealthCritical},
+		{Node: "node1", CheckID: "service:web", ServiceName: "web", Status: api.HealthCritical},
+		{Node: "node2", CheckID: "service:web", ServiceName: "web", Status: api.HealthPassing},
+		{Node: "node1", CheckID: "service:redis", ServiceName: "redis", Status: api.HealthCritical},
+		{Node: "node2", CheckID: "service:redis", ServiceName: "redis", Status: api.HealthCritical},
+		{Node: "node3", CheckID: "service:db", ServiceName: "db", Status: api.HealthCritical},
+	}
+
+	cases := []struct {
+		rule      *InhibitRuleConfig
+		service   string
+		alert     *AlertState
+		inhibited bool
+	}{
+		// Every failing check of web is on the dead node
+		{nodeDown, "web", &AlertState{Service: "web", Status: api.HealthCritical}, true},
+		// Redis is also failing on a healthy node
+		{nodeDown, "redis", &AlertState{Service: "redis", Status: api.HealthCritical}, false},
+		{nodeDown, "redis", &AlertState{Service: "redis", Node: "node1", Status: api.HealthCritical}, true},
+		// The alert of the dead node itself is never suppressed
+		{nodeDown, "", &AlertState{Node: "node1", Status: api.HealthCritical}, false},
+		// Alerts that aren't raised from checks are matched by their node
+		{nodeDown, "consul-catalog", &AlertState{Service: "consul-catalog", Node: "node1", Status: api.HealthCritical}, true},
+		{nodeDown, "consul-catalog", &AlertState{Service: "consul-catalog", Node: "node2", Status: api.HealthCritical}, false},
+		{dbDown, "api", &AlertState{Service: "api", Status: api.HealthCritical}, true},
+		{dbDown, "web", &AlertState{Service: "web", Status: api.HealthCritical}, false},
+	}
+	for i, c := range cases {
+		if inhibited := c.rule.inhibits(c.service, c.alert, checks); inhibited != c.inhibited {
+			t.Errorf("case %d: expected inhibited to be %v, got %v", i, c.inhibited, inhibited)
+		}
+	}
+
+	// A warning source only counts for rules with a warning source_status
+	checks[0].Status = api.HealthWarning
+	if nodeDown.inhibits("web", &AlertState{Service: "web", Status: api.HealthCritical}, checks) {
+		t.Error("expected a warning serfHealth not to inhibit")
+	}
+}
+
+func TestInhibit_holdsAlertsUntilReleased(t *testing.T) {
+	config, err := ParseConfig(`
+inhibit_rule "node-down" {
+  source_check = "serfHealth"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alertCh := make(chan *AlertState, 10)
+	config.ConsulDatacenter = "dc1"
+	config.Handlers = map[string]AlertHandler{"test": testHandler{alertCh}}
+
+	defer watchedChecks.remove("node node1")
+	watchedChecks.set("node node1", "dc1", []*api.HealthCheck{
+		{Node: "node1", CheckID: "serfHealth", Status: api.HealthCritical},
+	})
+
+	alert := &AlertState{Service: "web", Status: api.HealthCritical, LastAlerted: api.HealthPassing, Message: "web is critical"}
+	notifyHandlers(config, "web", nil, alert, api.HealthPassing)
+	if len(alertCh) != 0 {
+		t.Fatal("expected the alert to be held back")
+	}
+
+	// The recovery of a held back alert is held back as well
+	recovery := &AlertState{Service: "web", Status: api.HealthPassing, LastAlerted: api.HealthCritical, Message: "web is passing"}
+	notifyHandlers(config, "web", nil, recovery, api.HealthCritical)
+	if len(alertCh) != 0 {
+		t.Fatal("expected the recovery to be held back")
+	}
+
+	// An alert still failing once its source recovers is sent
+	notifyHandlers(config, "web", nil, alert, api.HealthPassing)
+	watchedChecks.set("node node1", "dc1", []*api.HealthCheck{
+		{Node: "node1", CheckID: "serfHealth", Status: api.HealthPassing},
+	})
+	inhibitions.release()
+	select {
+	case sent := <-alertCh:
+		if sent.Message != "web is critical" {
+			t.Errorf("unexpected alert: %q", sent.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the released alert")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The health of the instances of a service, each taking the worst status of its checks
+type instanceCounts struct {
+	total    int
+	warning  int
+	critical int
+}
+
+// Counts the instances of a service by the status of their checks, keyed by node and
+// service ID. Instances without checks aren't in the health checks, so aren't counted.
+func countInstances(checks []*api.HealthCheck) instanceCounts {
+	statuses := make(map[string]map[string]string)
+	for _, check := range checks {
+		instance := check.Node + "/" + check.ServiceID
+		if _, ok := statuses[instance]; !ok {
+			statuses[instance] = make(map[string]string)
+		}
+		statuses[instance][check.CheckID] = check.Status
+	}
+
+	counts := instanceCounts{total: len(statuses)}
+	for _, instance := range statuses {
+		switch computeHealth(instance) {
+		case api.HealthWarning:
+			counts.warning++
+		case api.HealthCritical:
+			counts.critical++
+		}
+	}
+	return counts
+}
+
+func (c instanceCounts) failing() int {
+	return c.warning + c.critical
+}
+
+func (c instanceCounts) passing() int {
+	return c.total - c.failing()
+}
+
+// Describes the counts, e.g. "3 of 12 instances are critical, 1 warning"
+func (c instanceCounts) summary() string {
+	switch {
+	case c.failing() == 0:
+		return fmt.Sprintf("all %d instances are passing", c.total)
+	case c.critical == 0:
+		return fmt.Sprintf("%d of %d instances are warning", c.warning, c.total)
+	case c.warning == 0:
+		return fmt.Sprintf("%d of %d instances are critical", c.critical, c.total)
+	}
+	return fmt.Sprintf("%d of %d instances are critical, %d warning", c.critical, c.total, c.warning)
+}
+
+// Thresholds on the passing instances of a service, each 0 when unset
+type InstanceThresholds struct {
+	WarningBelowPercent  int `mapstructure:"warning_below_percent"`
+	CriticalBelowPercent int `mapstructure:"critical_below_percent"`
+	WarningBelowCount    int `mapstructure:"warning_below_count"`
+	CriticalBelowCount   int `mapstructure:"critical_below_count"`
+}
+
+func (t InstanceThresholds) set() bool {
+	return t != InstanceThresholds{}
+}
+
+func (t InstanceThresholds) validate(aggregation string) error {
+	for _, percent := range []int{t.WarningBelowPercent, t.CriticalBelowPercent} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("instance thresholds in percent must be between 0 and 100")
+		}
+	}
+	if t.WarningBelowCount < 0 || t.CriticalBelowCount < 0 {
+		return fmt.Errorf("instance thresholds can't be negative")
+	}
+	if t.set() && (aggregation == AggregateNone || aggregation == AggregateNode) {
+		return fmt.Errorf("instance thresholds can't be used with aggregation %s", aggregation)
+	}
+	return nil
+}
+
+// Returns the health of a service from the share and number of its passing instances.
+// The percentages only apply to services with instances.
+func (t InstanceThresholds) health(counts instanceCounts) string {
+	passing := counts.passing()
+	below := func(percent int, count int) bool {
+		if count > 0 && passing < count {
+			return true
+		}
+		return percent > 0 && counts.total > 0 && passing*100 < percent*counts.total
+	}
+
+	switch {
+	case below(t.CriticalBelowPercent, t.CriticalBelowCount):
+		return api.HealthCritical
+	case below(t.WarningBelowPercent, t.WarningBelowCount):
+		return api.HealthWarning
+	}
+	return api.HealthPassing
+}
+
+// Returns the instance thresholds of a service
+func (c *Config) serviceInstanceThresholds(service string) InstanceThresholds {
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		return serviceConfig.InstanceThresholds
+	}
+	return InstanceThresholds{}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestInstances_countInstances(t *testing.T) {
+	checks := []*api.HealthCheck{
+		{Node: "node1", ServiceID: "api", CheckID: "http", Status: api.HealthCritical},
+		{Node: "node1", ServiceID: "api", CheckID: "tcp", Status: api.HealthPassing},
+		{Node: "node2", ServiceID: "api", CheckID: "http", Status: api.HealthWarning},
+		{Node: "node3", ServiceID: "api", CheckID: "http", Status: api.HealthPassing},
+		{Node: "node3", ServiceID: "api-2", CheckID: "http", Status: api.HealthCritical},
+	}
+
+	counts := countInstances(checks)
+	if counts != (instanceCounts{total: 4, warning: 1, critical: 2}) {
+		t.Fatalf("unexpected counts: %+v", counts)
+	}
+	if counts.failing() != 3 || counts.passing() != 1 {
+		t.Errorf("expected 3 failing and 1 passing instances, got %d and %d", counts.failing(), counts.passing())
+	}
+
+	cases := map[instanceCounts]string{
+		{total: 4, warning: 1, critical: 2}: "2 of 4 instances are critical, 1 warning",
+		{total: 12, critical: 3}:            "3 of 12 instances are critical",
+		{total: 12, warning: 1}:             "1 of 12 instances are warning",
+		{total: 12}:                         "all 12 instances are passing",
+	}
+	for counts, expected := range cases {
+		if summary := counts.summary(); summary != expected {
+			t.Errorf("expected %q, got %q", expected, summary)
+		}
+	}
+}
+
+func TestInstances_aggregation(t *testing.T) {
+	config, err := ParseConfig(`
+service "api" {
+  aggregation = "instances"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aggregation := config.serviceAggregation("api"); aggregation != AggregateInstances {
+		t.Errorf("expected the instances aggregation, got %s", aggregation)
+	}
+	if group := aggregationGroup(ServiceWatch, AggregateInstances, "node1/http"); group != "" {
+		t.Errorf("expected the instances of a service to share a group, got %q", group)
+	}
+}
+
+func TestInstances_thresholds(t *testing.T) {
+	config, err := ParseConfig(`
+service "api" {
+  warning_below_percent = 80
+  critical_below_percent = 50
+  critical_below_count = 2
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thresholds := config.serviceInstanceThresholds("api")
+	if !thresholds.set() || config.serviceInstanceThresholds("web").set() {
+		t.Fatalf("expected only api to have instance thresholds, got %+v", thresholds)
+	}
+
+	cases := []struct {
+		counts   instanceCounts
+		expected string
+	}{
+		// Losing one instance out of fifty isn't worth alerting on
+		{instanceCounts{total: 50, critical: 1}, api.HealthPassing},
+		{instanceCounts{total: 50, critical: 11}, api.HealthWarning},
+		{instanceCounts{total: 50, warning: 20, critical: 6}, api.HealthCritical},
+		{instanceCounts{total: 3, critical: 2}, api.HealthCritical},
+		{instanceCounts{total: 2}, api.HealthPassing},
+		{instanceCounts{}, api.HealthCritical},
+	}
+	for _, c := range cases {
+		if health := thresholds.health(c.counts); health != c.expected {
+			t.Errorf("expected %+v to be %s, got %s", c.counts, c.expected, health)
+		}
+	}
+
+	for _, raw := range []string{
+		`service "api" { warning_below_percent = 120 }`,
+		`service "api" { critical_below_count = -1 }`,
+		`service "api" { aggregation = "node" critical_below_count = 2 }`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Errorf("expected an error for %s", raw)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+)
+
+// Matches a ${NAME} reference, or a $${ escaping one
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Replaces the references in the string values of a parsed config: ${NAME} with the
+// value of the environment variable NAME, and ${vault:<path>#<key>} with a key of a Vault
+// secret, so secrets like tokens and passwords don't need to be in the file. $${ is left
+// as a literal ${. Returns the Vault references used, with their values.
+func interpolateConfig(root *ast.File) (map[string]string, error) {
+	resolver := &vaultResolver{root: root}
+
+	var err error
+	ast.Walk(root, func(n ast.Node) (ast.Node, bool) {
+		literal, ok := n.(*ast.LiteralType)
+		if !ok || err != nil || (literal.Token.Type != token.STRING && literal.Token.Type != token.HEREDOC) {
+			return n, err == nil
+		}
+
+		value, ok := literal.Token.Value().(string)
+		if !ok {
+			return n, true
+		}
+		var expanded string
+		if expanded, err = expandReferences(value, resolver.secret); err != nil {
+			err = fmt.Errorf("%s (line %d)", err, literal.Token.Pos.Line)
+			return n, false
+		}
+		if expanded != value {
+			literal.Token.Type, literal.Token.Text, literal.Token.JSON = token.STRING, strconv.Quote(expanded), true
+		}
+		return n, true
+	})
+	return resolver.secrets, err
+}
+
+// Expands the environment variable references in a string
+func expandEnv(value string) (string, error) {
+	return expandReferences(value, nil)
+}
+
+// Expands the references in a string, looking up Vault references with vault
+func expandReferences(value string, vault func(reference string) (string, error)) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		if reference == "$${" || err != nil {
+			return "${"
+		}
+		name := reference[2 : len(reference)-1]
+		if strings.HasPrefix(name, vaultReferencePrefix) && vault != nil {
+			var secret string
+			secret, err = vault(strings.TrimPrefix(name, vaultReferencePrefix))
+			return secret
+		}
+		if !envName.MatchString(name) {
+			err = fmt.Errorf("Invalid environment variable reference %s", reference)
+			return reference
+		}
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			err = fmt.Errorf("Environment variable %s isn't set", name)
+		}
+		return env
+	})
+	return expanded, err
+}
+
+// Returns the value of a top-level string setting of a parsed config, with its
+// environment variables expanded
+func topLevelString(root *ast.File, key string) (string, error) {
+	list, ok := root.Node.(*ast.ObjectList)
+	if !ok {
+		return "", nil
+	}
+	for _, item := range list.Filter(key).Items {
+		if literal, ok := item.Val.(*ast.LiteralType); ok && literal.Token.Type == token.STRING {
+			if value, ok := literal.Token.Value().(string); ok {
+				return expandEnv(value)
+			}
+		}
+	}
+	return "", nil
+}
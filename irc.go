@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A minimal IRC client, implementing just enough of the protocol to register (with SASL
+// PLAIN if configured), join channels and announce messages in them.
+
+// The timeout for connecting and registering with the server, and for each write
+const ircTimeout = 30 * time.Second
+
+// The settings for connecting to an IRC server
+type ircConfig struct {
+	server       string
+	nick         string
+	username     string
+	password     string
+	saslUsername string
+	saslPassword string
+	channels     []string
+	notice       bool
+	tlsConfig    *tls.Config
+}
+
+// A registered connection to an IRC server, used as a busConn. A reader goroutine answers
+// the server's PINGs so the connection stays up between alerts.
+type ircConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	config *ircConfig
+
+	writeLock sync.Mutex
+	done      chan struct{}
+	err       error
+}
+
+// Returns the address of an IRC server given as host:port, irc://host[:port] or
+// ircs://host[:port], and whether it requires TLS
+func ircServerAddress(server string) (string, bool, error) {
+	if !strings.Contains(server, "://") {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return "", false, err
+		}
+		return server, false, nil
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", false, err
+	}
+	switch u.Scheme {
+	case "irc":
+		if u.Port() == "" {
+			return net.JoinHostPort(u.Hostname(), "6667"), false, nil
+		}
+	case "ircs":
+		if u.Port() == "" {
+			return net.JoinHostPort(u.Hostname(), "6697"), true, nil
+		}
+	default:
+		return "", false, fmt.Errorf("unsupported scheme %q, must be irc or ircs", u.Scheme)
+	}
+	return u.Host, u.Scheme == "ircs", nil
+}
+
+// Connects and registers with the server, then joins the channels
+func dialIRC(config *ircConfig) (*ircConn, error) {
+	address, useTLS, err := ircServerAddress(config.server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", address, ircTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := config.tlsConfig
+	if tlsConfig == nil && useTLS {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig != nil {
+		if tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(ircTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("tls handshake failed: %s", err)
+		}
+		conn = tlsConn
+	}
+
+	c := &ircConn{conn: conn, reader: bufio.NewReader(conn), config: config, done: make(chan struct{})}
+	if err := c.register(); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	for _, channel := range config.channels {
+		if err := c.send("JOIN " + channel); err != nil {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+
+	go c.read()
+	return c, nil
+}
+
+// Registers the connection, authenticating with SASL PLAIN if set and picking another
+// nick if the configured one is taken
+func (c *ircConn) register() error {
+	c.conn.SetDeadline(time.Now().Add(ircTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	nick := c.config.nick
+	if c.config.saslUsername != "" {
+		if err := c.send("CAP REQ :sasl"); err != nil {
+			return err
+		}
+	}
+	if c.config.password != "" {
+		if err := c.send("PASS " + c.config.password); err != nil {
+			return err
+		}
+	}
+	if err := c.send("NICK " + nick); err != nil {
+		return err
+	}
+	if err := c.send(fmt.Sprintf("USER %s 0 * :consul-alerting", c.config.username)); err != nil {
+		return err
+	}
+
+	for {
+		_, command, params, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+
+		var reply string
+		switch command {
+		case "001":
+			return nil
+		case "PING":
+			reply = "PONG :" + strings.Join(params, " ")
+		case "CAP":
+			if len(params) >= 2 && params[1] == "ACK" {
+				reply = "AUTHENTICATE PLAIN"
+			} else if len(params) >= 2 && params[1] == "NAK" {
+				return fmt.Errorf("server doesn't support SASL")
+			}
+		case "AUTHENTICATE":
+			credentials := c.config.saslUsername + "\x00" + c.config.saslUsername + "\x00" + c.config.saslPassword
+			reply = "AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(credentials))
+		case "903":
+			reply = "CAP END"
+		case "902", "904", "905", "906":
+			return fmt.Errorf("SASL authentication failed: %s", lastParam(params))
+		case "432", "433", "436":
+			// The nick is taken or not allowed; try another
+			nick += "_"
+			reply = "NICK " + nick
+		case "464":
+			return fmt.Errorf("server rejected the password: %s", lastParam(params))
+		case "ERROR":
+			return fmt.Errorf("server error: %s", lastParam(params))
+		}
+
+		if reply != "" {
+			if err := c.send(reply); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Reads the server's messages until the connection fails, answering PINGs and logging
+// errors joining or messaging the channels
+func (c *ircConn) read() {
+	for {
+		_, command, params, err := c.readMessage()
+		if err != nil {
+			c.err = err
+			close(c.done)
+			return
+		}
+
+		switch command {
+		case "PING":
+			c.send("PONG :" + strings.Join(params, " "))
+		case "ERROR":
+			log.Errorf("IRC server %s closed the connection: %s", c.config.server, lastParam(params))
+		case "403", "404", "471", "473", "474", "475", "477":
+			if len(params) >= 2 {
+				log.Errorf("Error joining or messaging IRC channel %s: %s", params[1], lastParam(params))
+			}
+		}
+	}
+}
+
+// Reads the next message from the server, split into its prefix, command and parameters
+func (c *ircConn) readMessage() (string, string, []string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", "", nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	var prefix string
+	if strings.HasPrefix(line, ":") {
+		parts := strings.SplitN(line[1:], " ", 2)
+		prefix = parts[0]
+		line = ""
+		if len(parts) == 2 {
+			line = parts[1]
+		}
+	}
+
+	var trailing string
+	hasTrailing := false
+	if i := strings.Index(line, " :"); i >= 0 {
+		trailing, hasTrailing = line[i+2:], true
+		line = line[:i]
+	} else if strings.HasPrefix(line, ":") {
+		trailing, hasTrailing = line[1:], true
+		line = ""
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return prefix, "", nil, nil
+	}
+	params := fields[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	return prefix, strings.ToUpper(fields[0]), params, nil
+}
+
+// Returns the last parameter of a message, which holds the human-readable text of replies
+func lastParam(params []string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	return params[len(params)-1]
+}
+
+// Writes a line to the server
+func (c *ircConn) send(line string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(ircTimeout))
+	_, err := io.WriteString(c.conn, line+"\r\n")
+	return err
+}
+
+// Announces a message in every channel
+func (c *ircConn) Publish(topic string, key string, payload []byte) error {
+	select {
+	case <-c.done:
+		return fmt.Errorf("connection closed: %s", c.err)
+	default:
+	}
+
+	command := "PRIVMSG"
+	if c.config.notice {
+		command = "NOTICE"
+	}
+	for _, channel := range c.config.channels {
+		// Only the channel name is the target; the rest is its key
+		target := strings.Fields(channel)[0]
+		if err := c.send(fmt.Sprintf("%s %s :%s", command, target, payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ircConn) Close() error {
+	c.send("QUIT :consul-alerting")
+	return c.conn.Close()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A fake IRC server, rejecting the nick "taken" and accepting SASL PLAIN for the
+// credentials in sasl if set
+type testIRCServer struct {
+	listener net.Listener
+	sasl     string
+	lines    chan string
+}
+
+func newTestIRCServer(t *testing.T, sasl string) *testIRCServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testIRCServer{listener: listener, sasl: sasl, lines: make(chan string, 20)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (s *testIRCServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Registration is held until CAP END when the client requests SASL
+	var nick string
+	capabilities := false
+	welcome := func() {
+		// Test keepalives by pinging before welcoming the client
+		io.WriteString(conn, "PING :irc.test\r\n")
+		fmt.Fprintf(conn, ":irc.test 001 %s :Welcome\r\n", nick)
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "CAP":
+			if fields[1] == "END" {
+				welcome()
+				continue
+			}
+			capabilities = true
+			io.WriteString(conn, ":irc.test CAP * ACK :sasl\r\n")
+		case "AUTHENTICATE":
+			if fields[1] == "PLAIN" {
+				io.WriteString(conn, "AUTHENTICATE +\r\n")
+			} else if decoded, _ := base64.StdEncoding.DecodeString(fields[1]); string(decoded) == s.sasl {
+				io.WriteString(conn, ":irc.test 903 * :SASL authentication successful\r\n")
+			} else {
+				io.WriteString(conn, ":irc.test 904 * :SASL authentication failed\r\n")
+			}
+		case "NICK":
+			if fields[1] == "taken" {
+				io.WriteString(conn, ":irc.test 433 * taken :Nickname is already in use\r\n")
+				continue
+			}
+			nick = fields[1]
+			if !capabilities {
+				welcome()
+			}
+		case "JOIN", "PRIVMSG", "NOTICE", "PONG":
+			s.lines <- line
+		}
+	}
+}
+
+// Returns the next line of a kind the fake server records
+func (s *testIRCServer) next(t *testing.T) string {
+	select {
+	case line := <-s.lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the client")
+		return ""
+	}
+}
+
+func TestIRC_publish(t *testing.T) {
+	server := newTestIRCServer(t, "bot\x00bot\x00secret")
+	defer server.listener.Close()
+
+	conn, err := dialIRC(&ircConfig{
+		server:       "irc://" + server.listener.Addr().String(),
+		nick:         "taken",
+		username:     "bot",
+		saslUsername: "bot",
+		saslPassword: "secret",
+		channels:     []string{"#ops", "#dba key"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	expected := []string{"PONG :irc.test", "JOIN #ops", "JOIN #dba key"}
+	for _, line := range expected {
+		if got := server.next(t); got != line {
+			t.Errorf("expected %q, got %q", line, got)
+		}
+	}
+
+	if err := conn.Publish("", "", []byte("[dc1] CRIT: redis is critical")); err != nil {
+		t.Fatal(err)
+	}
+	for _, channel := range []string{"#ops", "#dba"} {
+		if got := server.next(t); got != "PRIVMSG "+channel+" :[dc1] CRIT: redis is critical" {
+			t.Errorf("unexpected message: %q", got)
+		}
+	}
+}
+
+func TestIRC_saslFailure(t *testing.T) {
+	server := newTestIRCServer(t, "bot\x00bot\x00secret")
+	defer server.listener.Close()
+
+	_, err := dialIRC(&ircConfig{
+		server:       server.listener.Addr().String(),
+		nick:         "bot",
+		username:     "bot",
+		saslUsername: "bot",
+		saslPassword: "wrong",
+		channels:     []string{"#ops"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "SASL authentication failed") {
+		t.Errorf("expected a SASL error, got %v", err)
+	}
+}
+
+func TestIRC_serverAddress(t *testing.T) {
+	cases := map[string]string{
+		"irc.libera.chat:6667":     "irc.libera.chat:6667",
+		"irc://irc.libera.chat":    "irc.libera.chat:6667",
+		"ircs://irc.libera.chat":   "irc.libera.chat:6697",
+		"ircs://irc.example:7000/": "irc.example:7000",
+	}
+
+	for server, expected := range cases {
+		address, _, err := ircServerAddress(server)
+		if err != nil || address != expected {
+			t.Errorf("expected %s for %s, got %s (%v)", expected, server, address, err)
+		}
+	}
+
+	for _, server := range []string{"irc.libera.chat", "http://irc.libera.chat"} {
+		if _, _, err := ircServerAddress(server); err == nil {
+			t.Errorf("expected an error for %s", server)
+		}
+	}
+}
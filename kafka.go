@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// A minimal Kafka producer client, implementing just enough of the protocol to publish
+// records: metadata lookups, produce requests with v2 record batches (Kafka 0.11+) and
+// SASL authentication.
+
+// The Kafka api keys used by the producer
+const (
+	kafkaProduceKey          = 0
+	kafkaMetadataKey         = 3
+	kafkaSaslHandshakeKey    = 17
+	kafkaSaslAuthenticateKey = 36
+)
+
+// The timeout for connecting to a broker and for each request
+const kafkaTimeout = 10 * time.Second
+
+var kafkaCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Descriptions of the error codes most likely to be returned to a producer
+var kafkaErrors = map[int16]string{
+	2:  "corrupt message",
+	3:  "unknown topic or partition",
+	5:  "leader not available",
+	6:  "not leader for partition",
+	7:  "request timed out",
+	10: "message too large",
+	29: "topic authorization failed",
+	31: "cluster authorization failed",
+	33: "unsupported sasl mechanism",
+	58: "sasl authentication failed",
+}
+
+func kafkaError(code int16) error {
+	if description, ok := kafkaErrors[code]; ok {
+		return fmt.Errorf("kafka error %d: %s", code, description)
+	}
+	return fmt.Errorf("kafka error %d", code)
+}
+
+// The settings for connecting to a Kafka cluster
+type kafkaConfig struct {
+	brokers   []string
+	clientID  string
+	tlsConfig *tls.Config
+
+	// The SASL mechanism to authenticate with (PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512),
+	// or empty to skip authentication
+	saslMechanism string
+	saslUsername  string
+	saslPassword  string
+}
+
+// Encodes fields in the Kafka wire format
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.WriteByte(byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) int32(v int32) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) int64(v int64) { binary.Write(e, binary.BigEndian, v) }
+
+func (e *kafkaEncoder) string(v string) {
+	e.int16(int16(len(v)))
+	e.WriteString(v)
+}
+
+func (e *kafkaEncoder) nullableString(v string) {
+	if v == "" {
+		e.int16(-1)
+		return
+	}
+	e.string(v)
+}
+
+func (e *kafkaEncoder) bytes(v []byte) {
+	e.int32(int32(len(v)))
+	e.Write(v)
+}
+
+func (e *kafkaEncoder) varint(v int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	e.Write(buf[:binary.PutVarint(buf, v)])
+}
+
+func (e *kafkaEncoder) varintBytes(v []byte) {
+	if v == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(v)))
+	e.Write(v)
+}
+
+// Decodes fields in the Kafka wire format, remembering the first error encountered
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	v := d.buf[:n]
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if v := d.next(1); v != nil {
+		return int8(v[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if v := d.next(2); v != nil {
+		return int16(binary.BigEndian.Uint16(v))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if v := d.next(4); v != nil {
+		return int32(binary.BigEndian.Uint32(v))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if v := d.next(8); v != nil {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *kafkaDecoder) int32Array() []int32 {
+	n := d.int32()
+	values := make([]int32, 0)
+	for i := int32(0); i < n && d.err == nil; i++ {
+		values = append(values, d.int32())
+	}
+	return values
+}
+
+// A connection to a single broker
+type kafkaBroker struct {
+	conn          net.Conn
+	clientID      string
+	correlationID int32
+}
+
+// Connects to a broker, authenticating if SASL is configured
+func dialKafkaBroker(address string, config *kafkaConfig) (*kafkaBroker, error) {
+	dialer := &net.Dialer{Timeout: kafkaTimeout}
+
+	var conn net.Conn
+	var err error
+	if config.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, config.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	broker := &kafkaBroker{conn: conn, clientID: config.clientID}
+	if config.saslMechanism != "" {
+		if err := broker.authenticate(config); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error authenticating to %s: %s", address, err)
+		}
+	}
+
+	return broker, nil
+}
+
+// Sends a request and returns the body of the response
+func (b *kafkaBroker) request(apiKey int16, apiVersion int16, body []byte) ([]byte, error) {
+	b.correlationID++
+
+	var header kafkaEncoder
+	header.int16(apiKey)
+	header.int16(apiVersion)
+	header.int32(b.correlationID)
+	header.nullableString(b.clientID)
+
+	var req kafkaEncoder
+	req.int32(int32(header.Len() + len(body)))
+	req.Write(header.Bytes())
+	req.Write(body)
+
+	b.conn.SetDeadline(time.Now().Add(kafkaTimeout))
+	if _, err := b.conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(b.conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(b.conn, resp); err != nil {
+		return nil, err
+	}
+
+	if id := int32(binary.BigEndian.Uint32(resp)); id != b.correlationID {
+		return nil, fmt.Errorf("expected correlation id %d, got %d", b.correlationID, id)
+	}
+
+	return resp[4:], nil
+}
+
+func (b *kafkaBroker) Close() error {
+	return b.conn.Close()
+}
+
+// Authenticates using SaslHandshake v1 followed by SaslAuthenticate requests
+func (b *kafkaBroker) authenticate(config *kafkaConfig) error {
+	var handshake kafkaEncoder
+	handshake.string(config.saslMechanism)
+	resp, err := b.request(kafkaSaslHandshakeKey, 1, handshake.Bytes())
+	if err != nil {
+		return err
+	}
+	d := &kafkaDecoder{buf: resp}
+	if code := d.int16(); code != 0 {
+		return kafkaError(code)
+	}
+
+	exchange := func(message []byte) ([]byte, error) {
+		var auth kafkaEncoder
+		auth.bytes(message)
+		resp, err := b.request(kafkaSaslAuthenticateKey, 0, auth.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		d := &kafkaDecoder{buf: resp}
+		code := d.int16()
+		errorMessage := d.string()
+		authBytes := d.bytes()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if code != 0 {
+			return nil, fmt.Errorf("%s: %s", kafkaError(code), errorMessage)
+		}
+		return authBytes, nil
+	}
+
+	switch config.saslMechanism {
+	case "PLAIN":
+		_, err = exchange([]byte("\x00" + config.saslUsername + "\x00" + config.saslPassword))
+		return err
+	case "SCRAM-SHA-256", "SCRAM-SHA-512":
+		return scramAuthenticate(config.saslMechanism, config.saslUsername, config.saslPassword, exchange)
+	}
+
+	return fmt.Errorf("unsupported sasl mechanism %s", config.saslMechanism)
+}
+
+// The partition leaders of a topic, as returned by a metadata request
+type kafkaTopicMetadata struct {
+	brokers map[int32]string
+	leaders []int32
+}
+
+// Looks up the brokers and partition leaders for a topic using a v1 metadata request
+func (b *kafkaBroker) metadata(topic string) (*kafkaTopicMetadata, error) {
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(topic)
+
+	resp, err := b.request(kafkaMetadataKey, 1, req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &kafkaTopicMetadata{brokers: make(map[int32]string)}
+	d := &kafkaDecoder{buf: resp}
+
+	brokers := d.int32()
+	for i := int32(0); i < brokers && d.err == nil; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		metadata.brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+
+	topics := d.int32()
+	for i := int32(0); i < topics && d.err == nil; i++ {
+		topicErr := d.int16()
+		name := d.string()
+		d.int8() // is internal
+		partitions := d.int32()
+
+		leaders := make(map[int32]int32)
+		for j := int32(0); j < partitions && d.err == nil; j++ {
+			d.int16() // partition error, leaders are checked below
+			partition := d.int32()
+			leaders[partition] = d.int32()
+			d.int32Array() // replicas
+			d.int32Array() // isr
+		}
+
+		if name != topic {
+			continue
+		}
+		if topicErr != 0 {
+			return nil, kafkaError(topicErr)
+		}
+		for partition := int32(0); partition < int32(len(leaders)); partition++ {
+			leader, ok := leaders[partition]
+			if !ok {
+				return nil, fmt.Errorf("missing metadata for partition %d of %s", partition, topic)
+			}
+			metadata.leaders = append(metadata.leaders, leader)
+		}
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("error decoding metadata: %s", d.err)
+	}
+	if len(metadata.leaders) == 0 {
+		return nil, fmt.Errorf("no partitions found for topic %s", topic)
+	}
+
+	return metadata, nil
+}
+
+// Encodes a single record as a v2 record batch
+func kafkaRecordBatch(key []byte, value []byte, timestamp time.Time) []byte {
+	var record kafkaEncoder
+	record.int8(0)   // attributes
+	record.varint(0) // timestamp delta
+	record.varint(0) // offset delta
+	record.varintBytes(key)
+	record.varintBytes(value)
+	record.varint(0) // headers
+
+	// Everything after the crc is covered by it
+	millis := timestamp.UnixNano() / int64(time.Millisecond)
+	var body kafkaEncoder
+	body.int16(0) // attributes
+	body.int32(0) // last offset delta
+	body.int64(millis)
+	body.int64(millis)
+	body.int64(-1) // producer id
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(1)  // record count
+	body.varint(int64(record.Len()))
+	body.Write(record.Bytes())
+
+	var batch kafkaEncoder
+	batch.int64(0) // base offset
+	batch.int32(int32(4 + 1 + 4 + body.Len()))
+	batch.int32(0) // partition leader epoch
+	batch.int8(2)  // magic
+	batch.int32(int32(crc32.Checksum(body.Bytes(), kafkaCRCTable)))
+	batch.Write(body.Bytes())
+
+	return batch.Bytes()
+}
+
+// Publishes a record to a partition using a v3 produce request, waiting for the leader
+// to acknowledge it
+func (b *kafkaBroker) produce(topic string, partition int32, key []byte, value []byte) error {
+	var req kafkaEncoder
+	req.nullableString("") // transactional id
+	req.int16(1)           // acks
+	req.int32(int32(kafkaTimeout / time.Millisecond))
+	req.int32(1)
+	req.string(topic)
+	req.int32(1)
+	req.int32(partition)
+	req.bytes(kafkaRecordBatch(key, value, time.Now()))
+
+	resp, err := b.request(kafkaProduceKey, 3, req.Bytes())
+	if err != nil {
+		return err
+	}
+
+	d := &kafkaDecoder{buf: resp}
+	topics := d.int32()
+	for i := int32(0); i < topics && d.err == nil; i++ {
+		d.string()
+		partitions := d.int32()
+		for j := int32(0); j < partitions && d.err == nil; j++ {
+			d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if code != 0 && d.err == nil {
+				return kafkaError(code)
+			}
+		}
+	}
+
+	return d.err
+}
+
+// A producer connection to a Kafka cluster, used as a busConn. Records with the same key
+// always go to the same partition so that the transitions of an alert stay in order.
+type kafkaProducer struct {
+	config    *kafkaConfig
+	bootstrap *kafkaBroker
+	metadata  map[string]*kafkaTopicMetadata
+	leaders   map[string]*kafkaBroker
+}
+
+// Connects to the first reachable bootstrap broker
+func dialKafka(config *kafkaConfig) (*kafkaProducer, error) {
+	var lastErr error
+	for _, address := range config.brokers {
+		broker, err := dialKafkaBroker(address, config)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return &kafkaProducer{
+			config:    config,
+			bootstrap: broker,
+			metadata:  make(map[string]*kafkaTopicMetadata),
+			leaders:   make(map[string]*kafkaBroker),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no brokers reachable, last error: %s", lastErr)
+}
+
+func (p *kafkaProducer) Publish(topic string, key string, payload []byte) error {
+	metadata, ok := p.metadata[topic]
+	if !ok {
+		var err error
+		if metadata, err = p.bootstrap.metadata(topic); err != nil {
+			return err
+		}
+		p.metadata[topic] = metadata
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	partition := int32(hash.Sum32() % uint32(len(metadata.leaders)))
+
+	address, ok := metadata.brokers[metadata.leaders[partition]]
+	if !ok {
+		return kafkaError(5)
+	}
+
+	leader, ok := p.leaders[address]
+	if !ok {
+		var err error
+		if leader, err = dialKafkaBroker(address, p.config); err != nil {
+			return err
+		}
+		p.leaders[address] = leader
+	}
+
+	return leader.produce(topic, partition, []byte(key), payload)
+}
+
+func (p *kafkaProducer) Close() error {
+	for _, leader := range p.leaders {
+		leader.Close()
+	}
+	return p.bootstrap.Close()
+}
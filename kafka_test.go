@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// A fake broker that serves a single-partition topic and records produced batches
+type testKafkaBroker struct {
+	listener net.Listener
+	produced chan []byte
+}
+
+func newTestKafkaBroker(t *testing.T) *testKafkaBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := &testKafkaBroker{listener: listener, produced: make(chan []byte, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+
+	return broker
+}
+
+func (b *testKafkaBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	host, portString, _ := net.SplitHostPort(b.listener.Addr().String())
+	port, _ := strconv.Atoi(portString)
+
+	for {
+		var size int32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		req := make([]byte, size)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+
+		d := &kafkaDecoder{buf: req}
+		apiKey := d.int16()
+		d.int16()
+		correlationID := d.int32()
+		d.string()
+
+		var resp kafkaEncoder
+		resp.int32(correlationID)
+		switch apiKey {
+		case kafkaMetadataKey:
+			resp.int32(1)
+			resp.int32(0)
+			resp.string(host)
+			resp.int32(int32(port))
+			resp.nullableString("")
+			resp.int32(0)
+			resp.int32(1)
+			resp.int16(0)
+			resp.string("alerts")
+			resp.int8(0)
+			resp.int32(1)
+			resp.int16(0)
+			resp.int32(0)
+			resp.int32(0)
+			resp.int32(0)
+			resp.int32(0)
+		case kafkaProduceKey:
+			d.string()
+			d.int16()
+			d.int32()
+			d.int32()
+			d.string()
+			d.int32()
+			d.int32()
+			b.produced <- d.bytes()
+
+			resp.int32(1)
+			resp.string("alerts")
+			resp.int32(1)
+			resp.int32(0)
+			resp.int16(0)
+			resp.int64(0)
+			resp.int64(-1)
+			resp.int32(0)
+		}
+
+		var framed kafkaEncoder
+		framed.bytes(resp.Bytes())
+		conn.Write(framed.Bytes())
+	}
+}
+
+func TestKafka_produce(t *testing.T) {
+	broker := newTestKafkaBroker(t)
+	defer broker.listener.Close()
+
+	producer, err := dialKafka(&kafkaConfig{brokers: []string{broker.listener.Addr().String()}, clientID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+
+	if err := producer.Publish("alerts", "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case batch := <-broker.produced:
+		if batch[16] != 2 {
+			t.Errorf("expected a v2 record batch, got magic %d", batch[16])
+		}
+		crc := binary.BigEndian.Uint32(batch[17:21])
+		if crc != crc32.Checksum(batch[21:], kafkaCRCTable) {
+			t.Errorf("record batch has an invalid crc")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the produce request")
+	}
+}
+
+func TestKafka_recordBatch(t *testing.T) {
+	batch := kafkaRecordBatch([]byte("key"), []byte("value"), time.Unix(1500000000, 0))
+
+	d := &kafkaDecoder{buf: batch}
+	d.int64()
+	if length := d.int32(); int(length) != len(batch)-12 {
+		t.Errorf("expected batch length %d, got %d", len(batch)-12, length)
+	}
+	d.int32()
+	d.int8()
+	d.int32()
+	d.int16()
+	d.int32()
+	if timestamp := d.int64(); timestamp != 1500000000000 {
+		t.Errorf("expected timestamp 1500000000000, got %d", timestamp)
+	}
+	d.next(8 + 8 + 2 + 4)
+	if count := d.int32(); count != 1 {
+		t.Errorf("expected 1 record, got %d", count)
+	}
+
+	record := d.buf
+	length, n := binary.Varint(record)
+	if int(length) != len(record)-n {
+		t.Errorf("expected record length %d, got %d", len(record)-n, length)
+	}
+	if d.err != nil {
+		t.Fatal(d.err)
+	}
+}
+
+func TestKafka_scram(t *testing.T) {
+	// The SCRAM-SHA-256 example from RFC 7677
+	client := &scramClient{newHash: sha256.New, username: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+
+	if first := client.firstMessage(); first != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("unexpected client first message: %s", first)
+	}
+
+	final, err := client.finalMessage("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if final != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, final)
+	}
+
+	if err := client.verifyServer("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Error(err)
+	}
+	if err := client.verifyServer("v=invalid"); err == nil {
+		t.Error("expected an error for an invalid server signature")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// The name of the pseudo-service the notices of KV watches are sent for. A service block
+// with this name can be used to configure their handlers.
+const kvWatchService = "consul-kv"
+
+// The KV path the keys seen by each KV watch are stored under, along with its lock
+const kvWatchesKVPath = alertingKVRoot + "/kv-watches/"
+
+// The changes to keys a KV watch can notify about
+const (
+	KVChangeCreated  = "created"
+	KVChangeModified = "modified"
+	KVChangeDeleted  = "deleted"
+)
+
+// A watch on the keys under a KV prefix, notifying when keys matching its key_pattern are
+// created, modified or deleted. With a value_pattern, creations and modifications are
+// only notified about when the new value matches it.
+type KVWatchConfig struct {
+	Name         string   `json:"name"`
+	Prefix       string   `mapstructure:"prefix" json:"prefix"`
+	KeyPattern   string   `mapstructure:"key_pattern" json:"key_pattern,omitempty"`
+	ValuePattern string   `mapstructure:"value_pattern" json:"value_pattern,omitempty"`
+	Changes      []string `mapstructure:"changes" json:"changes"`
+	Handlers     []string `mapstructure:"handlers" json:"handlers,omitempty"`
+
+	keyRegexp   *regexp.Regexp
+	valueRegexp *regexp.Regexp
+}
+
+// Parse the raw kv_watch objects into the config
+func parseKVWatches(list *ast.ObjectList, config *Config) error {
+	config.KVWatches = make([]KVWatchConfig, 0, len(list.Items))
+
+	for _, w := range list.Items {
+		if len(w.Keys) != 1 {
+			return fmt.Errorf("kv_watch must be in the form 'kv_watch \"name\" {}'")
+		}
+		name := w.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var watch KVWatchConfig
+		if err := hcl.DecodeObject(&m, w.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &watch); err != nil {
+			return err
+		}
+		watch.Name = name
+
+		if err := watch.validate(config); err != nil {
+			return fmt.Errorf("Error loading kv_watch %s: %s", name, err)
+		}
+		config.KVWatches = append(config.KVWatches, watch)
+	}
+
+	return nil
+}
+
+func (watch *KVWatchConfig) validate(config *Config) error {
+	if watch.Prefix == "" {
+		return fmt.Errorf("no prefix set")
+	}
+
+	var err error
+	if watch.keyRegexp, err = compileRouteRegexp(watch.KeyPattern); err != nil {
+		return fmt.Errorf("invalid key_pattern: %s", err)
+	}
+	if watch.valueRegexp, err = compileRouteRegexp(watch.ValuePattern); err != nil {
+		return fmt.Errorf("invalid value_pattern: %s", err)
+	}
+
+	if len(watch.Changes) == 0 {
+		watch.Changes = []string{KVChangeCreated, KVChangeModified, KVChangeDeleted}
+	}
+	for _, change := range watch.Changes {
+		if !contains([]string{KVChangeCreated, KVChangeModified, KVChangeDeleted}, change) {
+			return fmt.Errorf("invalid change %s", change)
+		}
+	}
+
+	for _, name := range watch.Handlers {
+		if _, ok := config.Handlers[name]; !ok {
+			return fmt.Errorf("unknown handler %s", name)
+		}
+	}
+	return nil
+}
+
+// The modify index of each key a KV watch has seen, so changes made while no instance
+// was watching are still noticed
+type kvWatchState struct {
+	Keys map[string]uint64 `json:"keys"`
+}
+
+// A key that was created, modified or deleted
+type kvChange struct {
+	change string
+	key    string
+}
+
+// Compares the keys under a KV watch's prefix to the stored ones, returning the changes
+// to notify about and the state to store
+func diffKVPairs(watch *KVWatchConfig, state *kvWatchState, pairs api.KVPairs) ([]kvChange, *kvWatchState) {
+	next := &kvWatchState{Keys: make(map[string]uint64)}
+	changes := make([]kvChange, 0)
+
+	for _, pair := range pairs {
+		if watch.keyRegexp != nil && !watch.keyRegexp.MatchString(pair.Key) {
+			continue
+		}
+		next.Keys[pair.Key] = pair.ModifyIndex
+
+		change := KVChangeCreated
+		if index, ok := state.Keys[pair.Key]; ok {
+			if index == pair.ModifyIndex {
+				continue
+			}
+			change = KVChangeModified
+		}
+		if contains(watch.Changes, change) && (watch.valueRegexp == nil || watch.valueRegexp.Match(pair.Value)) {
+			changes = append(changes, kvChange{change: change, key: pair.Key})
+		}
+	}
+
+	if contains(watch.Changes, KVChangeDeleted) {
+		for key := range state.Keys {
+			if _, ok := next.Keys[key]; !ok {
+				changes = append(changes, kvChange{change: KVChangeDeleted, key: key})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].key < changes[j].key
+	})
+	return changes, next
+}
+
+// Watches the keys under a KV watch's prefix and notifies about their changes. The keys
+// found on the first run are only stored, without notifying.
+func watchKV(watch KVWatchConfig, config *Config, client *api.Client, stopCh chan struct{}) {
+	kvPath := kvWatchesKVPath + watch.Name
+	watchRegistrations("kv watch "+watch.Name, kvPath, config, client, stopCh,
+		func(config *Config, queryOpts *api.QueryOptions) (*api.QueryMeta, error) {
+			pairs, queryMeta, err := client.KV().List(watch.Prefix, queryOpts)
+			if err != nil {
+				return nil, err
+			}
+
+			state := &kvWatchState{}
+			found, err := getRegistrationState(client, kvPath, state)
+			if err != nil {
+				return queryMeta, err
+			}
+
+			changes, next := diffKVPairs(&watch, state, pairs)
+			if found && reflect.DeepEqual(state.Keys, next.Keys) {
+				return queryMeta, nil
+			}
+			if err := setRegistrationState(client, kvPath, next); err != nil {
+				return queryMeta, err
+			}
+			if found && len(changes) > 0 {
+				notifyKVChanges(config, &watch, changes)
+			}
+			return queryMeta, nil
+		})
+}
+
+// Sends a notice listing the changes a KV watch saw in one update
+func notifyKVChanges(config *Config, watch *KVWatchConfig, changes []kvChange) {
+	datacenter := config.ConsulDatacenter
+	if len(watch.Handlers) > 0 {
+		config = config.withCheckSettings(kvWatchService, &CheckConfig{Handlers: watch.Handlers})
+	}
+
+	lines := make([]string, 0, len(changes))
+	for _, change := range changes {
+		lines = append(lines, fmt.Sprintf("=> %s %s", change.change, change.key))
+	}
+
+	alert := &AlertState{
+		Status:      api.HealthPassing,
+		Service:     kvWatchService,
+		Check:       watch.Name,
+		LastAlerted: api.HealthPassing,
+		Message:     fmt.Sprintf("[%s] %d keys changed under %s (kv watch %s)", datacenter, len(changes), watch.Prefix, watch.Name),
+		Details:     strings.Join(lines, "\n"),
+	}
+	if len(changes) == 1 {
+		alert.Message = fmt.Sprintf("[%s] key %s was %s (kv watch %s)", datacenter, changes[0].key, changes[0].change, watch.Name)
+	}
+
+	alert.Severity = config.alertSeverity(kvWatchService, alert.Status)
+	config.applyMessageTemplates(kvWatchService, alert)
+	notifyHandlers(config, kvWatchService, nil, alert, api.HealthPassing)
+	countAlert(alert)
+	history.record(datacenter, alert, time.Now())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestKVWatch_parse(t *testing.T) {
+	config, err := ParseConfig(`
+kv_watch "flags" {
+  prefix = "config/flags/"
+  key_pattern = "config/flags/.*\\.enabled"
+  changes = ["modified", "deleted"]
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.KVWatches) != 1 {
+		t.Fatalf("expected one kv watch, got %d", len(config.KVWatches))
+	}
+	watch := config.KVWatches[0]
+	if watch.Name != "flags" || watch.Prefix != "config/flags/" || !reflect.DeepEqual(watch.Changes, []string{"modified", "deleted"}) {
+		t.Errorf("unexpected kv watch: %+v", watch)
+	}
+
+	config, err = ParseConfig(`kv_watch "all" { prefix = "config/" }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := config.KVWatches[0].Changes; !reflect.DeepEqual(changes, []string{KVChangeCreated, KVChangeModified, KVChangeDeleted}) {
+		t.Errorf("expected every change by default, got %v", changes)
+	}
+
+	for _, raw := range []string{
+		`kv_watch "noprefix" {}`,
+		`kv_watch "badpattern" { prefix = "a/" key_pattern = "(" }`,
+		`kv_watch "badchange" { prefix = "a/" changes = ["renamed"] }`,
+		`kv_watch "badhandler" { prefix = "a/" handlers = ["missing"] }`,
+	} {
+		if _, err := ParseConfig(raw); err == nil || !strings.Contains(err.Error(), "Error loading kv_watch") {
+			t.Errorf("expected an error for %s, got %v", raw, err)
+		}
+	}
+}
+
+func TestKVWatch_diffKVPairs(t *testing.T) {
+	config, err := ParseConfig(`
+kv_watch "flags" {
+  prefix = "config/"
+  key_pattern = "config/flags/.*"
+  value_pattern = "on"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch := &config.KVWatches[0]
+
+	state := &kvWatchState{Keys: map[string]uint64{
+		"config/flags/a": 10,
+		"config/flags/b": 11,
+		"config/flags/c": 12,
+		"config/flags/d": 13,
+	}}
+	pairs := api.KVPairs{
+		{Key: "config/flags/a", ModifyIndex: 10, Value: []byte("on")},
+		{Key: "config/flags/b", ModifyIndex: 20, Value: []byte("on")},
+		{Key: "config/flags/c", ModifyIndex: 21, Value: []byte("off")},
+		{Key: "config/flags/e", ModifyIndex: 22, Value: []byte("on")},
+		{Key: "config/other", ModifyIndex: 23, Value: []byte("on")},
+	}
+
+	changes, next := diffKVPairs(watch, state, pairs)
+	expected := []kvChange{
+		{change: KVChangeModified, key: "config/flags/b"},
+		{change: KVChangeDeleted, key: "config/flags/d"},
+		{change: KVChangeCreated, key: "config/flags/e"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+
+	// Keys whose values don't match are still tracked, so they aren't seen as created later
+	expectedKeys := map[string]uint64{"config/flags/a": 10, "config/flags/b": 20, "config/flags/c": 21, "config/flags/e": 22}
+	if !reflect.DeepEqual(next.Keys, expectedKeys) {
+		t.Errorf("expected keys %v, got %v", expectedKeys, next.Keys)
+	}
+
+	watch.Changes = []string{KVChangeCreated}
+	changes, _ = diffKVPairs(watch, state, pairs)
+	if !reflect.DeepEqual(changes, []kvChange{{change: KVChangeCreated, key: "config/flags/e"}}) {
+		t.Errorf("expected only the creation, got %v", changes)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	log "github.com/sirupsen/logrus"
+)
+
+// Loads the config file, merged with the config fragments under the KV prefix if it's set
+func loadConfig(path string, client *api.Client, prefix string) (*Config, error) {
+	raw, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == "" || client == nil {
+		return ParseConfig(raw)
+	}
+
+	pairs, _, err := client.KV().List(prefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading config from KV prefix %s: %s", prefix, err)
+	}
+	fragments, err := kvConfigFragments(pairs)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(raw, fragments)
+}
+
+// Returns the config fragments stored in the given keys, in key order. Keys ending in
+// .yaml or .yml hold YAML, and the others HCL or JSON.
+func kvConfigFragments(pairs api.KVPairs) ([]configFragment, error) {
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+
+	fragments := make([]configFragment, 0, len(pairs))
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, "/") || len(strings.TrimSpace(string(pair.Value))) == 0 {
+			continue
+		}
+
+		raw := string(pair.Value)
+		if isYAMLPath(pair.Key) {
+			converted, err := yamlToJSON(pair.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing YAML config in %s: %s", pair.Key, err)
+			}
+			raw = string(converted)
+		}
+		fragments = append(fragments, configFragment{name: pair.Key, raw: raw})
+	}
+	return fragments, nil
+}
+
+// Merges a config fragment into a parsed config. Its settings replace the config's, and its
+// blocks are added after the config's; service blocks are merged with the config's blocks
+// for the same service, while other blocks with the same name replace them.
+func mergeConfigFragment(root *ast.File, fragment configFragment) error {
+	parsed, err := hcl.Parse(fragment.raw)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %s", fragment.name, err)
+	}
+	list, ok := root.Node.(*ast.ObjectList)
+	added, addedOk := parsed.Node.(*ast.ObjectList)
+	if !ok || !addedOk {
+		return fmt.Errorf("error parsing %s: root should be an object", fragment.name)
+	}
+	if err := checkConfigFragment(parsed); err != nil {
+		return fmt.Errorf("error in %s: %s", fragment.name, err)
+	}
+
+	replaced := make(map[string]bool)
+	for _, item := range added.Items {
+		if replaces(item) {
+			replaced[itemPath(item)] = true
+		}
+	}
+
+	items := make([]*ast.ObjectItem, 0, len(list.Items)+len(added.Items))
+	for _, item := range list.Items {
+		if !replaced[itemPath(item)] {
+			items = append(items, item)
+		}
+	}
+	list.Items = append(items, added.Items...)
+	return nil
+}
+
+// Makes sure a fragment doesn't set what only the config file can, since anyone with
+// write access to the KV prefix could otherwise run commands on the alerting host or
+// send its secrets to a handler of their own: exec and plugin handlers, plugins and
+// references to their handlers, the Vault settings, and ${} references, which are only
+// expanded in the config file.
+func checkConfigFragment(parsed *ast.File) error {
+	for _, item := range parsed.Node.(*ast.ObjectList).Items {
+		path := strings.Split(itemPath(item), ".")
+		switch {
+		case path[0] == "plugin":
+			return fmt.Errorf("plugin blocks can only be set in the config file")
+		case path[0] == "handler" && len(path) > 1 && (path[1] == "exec" || path[1] == "plugin"):
+			return fmt.Errorf("%s handlers can only be set in the config file", path[1])
+		case strings.HasPrefix(path[0], "vault_"):
+			return fmt.Errorf("%s can only be set in the config file", path[0])
+		}
+	}
+
+	var err error
+	ast.Walk(parsed, func(n ast.Node) (ast.Node, bool) {
+		literal, ok := n.(*ast.LiteralType)
+		if !ok || err != nil {
+			return n, err == nil
+		}
+		value, ok := literal.Token.Value().(string)
+		if !ok {
+			return n, true
+		}
+		if strings.HasPrefix(value, "exec.") || strings.HasPrefix(value, "plugin.") {
+			err = fmt.Errorf("handler %s can only be referred to in the config file (line %d)", value, literal.Token.Pos.Line)
+		}
+		for _, reference := range envReference.FindAllString(value, -1) {
+			if reference != "$${" && err == nil {
+				err = fmt.Errorf("%s: references are only expanded in the config file (line %d)", reference, literal.Token.Pos.Line)
+			}
+		}
+		return n, err == nil
+	})
+	return err
+}
+
+// Returns whether an item of a fragment replaces the config's items with the same keys:
+// settings and named blocks other than services do
+func replaces(item *ast.ObjectItem) bool {
+	if len(item.Keys) == 1 {
+		_, block := item.Val.(*ast.ObjectType)
+		return !block
+	}
+	return item.Keys[0].Token.Value() != "service"
+}
+
+// Returns the keys of an item, like handler.slack.ops
+func itemPath(item *ast.ObjectItem) string {
+	keys := make([]string, len(item.Keys))
+	for i, key := range item.Keys {
+		keys[i] = fmt.Sprint(key.Token.Value())
+	}
+	return strings.Join(keys, ".")
+}
+
+// Watches the config fragments under the KV prefix, requesting a reload when they change
+func watchKVConfig(client *api.Client, prefix string) {
+	log.Infof("Watching for config changes under %s", prefix)
+
+	// The first result is the config that was just loaded
+	var last string
+	started := false
+	watchKVPrefix(client, prefix, func(pairs api.KVPairs) {
+		versions := make([]string, 0, len(pairs))
+		for _, pair := range pairs {
+			versions = append(versions, fmt.Sprintf("%s@%d", pair.Key, pair.ModifyIndex))
+		}
+		sort.Strings(versions)
+		current := strings.Join(versions, ",")
+
+		if started && current != last {
+			log.Infof("Config under %s changed, reloading configuration", prefix)
+			requestReload()
+		}
+		last, started = current, true
+	})
+}
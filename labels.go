@@ -0,0 +1,33 @@
+package main
+
+import "sort"
+
+// Returns the labels attached to the alerts on a service: the global labels, overridden by
+// those of the datacenter and then by those of the service. Returns nil without any.
+func (c *Config) alertLabels(service string) map[string]string {
+	layers := []map[string]string{c.Labels, c.DatacenterLabels[c.ConsulDatacenter]}
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		layers = append(layers, serviceConfig.Labels)
+	}
+
+	var labels map[string]string
+	for _, layer := range layers {
+		for key, value := range layer {
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// Returns the names of an alert's labels in order, for handlers that list them
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
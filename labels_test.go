@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLabels_alertLabels(t *testing.T) {
+	config, err := ParseConfig(`
+labels {
+  environment = "prod"
+  team = "platform"
+}
+
+datacenter_labels {
+  dc2 {
+    environment = "staging"
+  }
+}
+
+service "payments" {
+  labels {
+    team = "payments"
+    runbook = "https://wiki.example.com/payments"
+  }
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.ConsulDatacenter = "dc1"
+	if labels := config.alertLabels("web"); !reflect.DeepEqual(labels, map[string]string{"environment": "prod", "team": "platform"}) {
+		t.Errorf("expected the global labels, got %v", labels)
+	}
+
+	config.ConsulDatacenter = "dc2"
+	expected := map[string]string{"environment": "staging", "team": "payments", "runbook": "https://wiki.example.com/payments"}
+	if labels := config.alertLabels("payments"); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected %v, got %v", expected, labels)
+	}
+
+	if labels := (&Config{}).alertLabels("web"); labels != nil {
+		t.Errorf("expected no labels, got %v", labels)
+	}
+}
+
+func TestLabels_handlers(t *testing.T) {
+	alert := &AlertState{Service: "redis", Status: "critical", Labels: map[string]string{"team": "payments", "env": "prod"}}
+
+	labels := AlertmanagerHandler{Labels: map[string]string{"env": "override"}}.newAlert("dc1", alert, time.Now()).Labels
+	if labels["team"] != "payments" || labels["env"] != "override" || labels["service"] != "redis" {
+		t.Errorf("unexpected Alertmanager labels: %v", labels)
+	}
+
+	event := PagerdutyHandler{RoutingKey: "key"}.event("dc1", alert, "")
+	if !reflect.DeepEqual(event.Payload.CustomDetails["labels"], alert.Labels) {
+		t.Errorf("expected the labels in the PagerDuty custom details, got %v", event.Payload.CustomDetails)
+	}
+}
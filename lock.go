@@ -29,6 +29,13 @@ type LockHelper struct {
 	// A function to be run after acquiring the lock
 	callback func()
 
+	// An optional function to be run after losing the lock
+	released func()
+
+	// Whether this is the lock electing the active instance. Every other lock is only
+	// contended for while this instance is active.
+	instanceLock bool
+
 	// Indicates whether we currently hold the lock
 	acquired bool
 }
@@ -41,6 +48,10 @@ func (l *LockHelper) start() {
 		case <-l.stopCh:
 			shutdown = true
 		default:
+			if !l.instanceLock && !activeInstance.wait(l.stopCh) {
+				shutdown = true
+				continue
+			}
 			log.Debugf("Waiting to acquire lock on %s...", l.target)
 
 			// Lock() returns an interrupt channel on success that can be used to block until we lose the lock
@@ -52,12 +63,19 @@ func (l *LockHelper) start() {
 				l.acquired = true
 				log.Infof("Acquired lock for %s", l.target)
 
-				<-intChan
+				// The locks of the active instance are released when it becomes standby
+				select {
+				case <-intChan:
+				case <-l.standby():
+				}
 
 				l.acquired = false
 				log.Infof("Lost lock for %s", l.target)
 				l.lock.Unlock()
 				l.lock.Destroy()
+				if l.released != nil {
+					l.released()
+				}
 			} else {
 				if err != nil {
 					log.Warnf("Error getting lock for %s: %s", l.target, err)
@@ -68,6 +86,15 @@ func (l *LockHelper) start() {
 	}
 }
 
+// Returns a channel closed when the instance becomes standby, or nil for the lock
+// electing the active instance
+func (l *LockHelper) standby() <-chan struct{} {
+	if l.instanceLock {
+		return nil
+	}
+	return activeInstance.standby()
+}
+
 // Shut down the lock acquisition loop, which will cause the lock to get released if it's currently acquired
 func (l *LockHelper) stop() {
 	l.stopCh <- struct{}{}
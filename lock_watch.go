@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// The name of the pseudo-service the alerts of lock watches are sent for. A service block
+// with this name can be used to configure their handlers.
+const lockWatchService = "consul-locks"
+
+// The KV path the holder seen by each lock watch is stored under, along with its lock
+const lockWatchesKVPath = alertingKVRoot + "/lock-watches/"
+
+// A watch on a lock key that alerts when it loses its holder, or on the sessions with a
+// name that alerts when none is left
+type LockWatchConfig struct {
+	Name     string   `json:"name"`
+	Key      string   `mapstructure:"key" json:"key,omitempty"`
+	Session  string   `mapstructure:"session" json:"session,omitempty"`
+	Status   string   `mapstructure:"status" json:"status"`
+	Handlers []string `mapstructure:"handlers" json:"handlers,omitempty"`
+}
+
+// Parse the raw lock_watch objects into the config
+func parseLockWatches(list *ast.ObjectList, config *Config) error {
+	config.LockWatches = make([]LockWatchConfig, 0, len(list.Items))
+
+	for _, w := range list.Items {
+		if len(w.Keys) != 1 {
+			return fmt.Errorf("lock_watch must be in the form 'lock_watch \"name\" {}'")
+		}
+		name := w.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var watch LockWatchConfig
+		if err := hcl.DecodeObject(&m, w.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &watch); err != nil {
+			return err
+		}
+		watch.Name = name
+
+		if err := watch.validate(config); err != nil {
+			return fmt.Errorf("Error loading lock_watch %s: %s", name, err)
+		}
+		config.LockWatches = append(config.LockWatches, watch)
+	}
+
+	return nil
+}
+
+func (watch *LockWatchConfig) validate(config *Config) error {
+	if (watch.Key == "") == (watch.Session == "") {
+		return fmt.Errorf("exactly one of key or session must be set")
+	}
+
+	if watch.Status == "" {
+		watch.Status = api.HealthCritical
+	}
+	if watch.Status != api.HealthWarning && watch.Status != api.HealthCritical {
+		return fmt.Errorf("invalid status %s, must be warning or critical", watch.Status)
+	}
+
+	for _, name := range watch.Handlers {
+		if _, ok := config.Handlers[name]; !ok {
+			return fmt.Errorf("unknown handler %s", name)
+		}
+	}
+	return nil
+}
+
+// The last seen holder of a lock watch and the status last alerted for it
+type lockWatchState struct {
+	Status  string `json:"status"`
+	Session string `json:"session"`
+	Node    string `json:"node"`
+}
+
+// A change of a lock watch's holder, and the status to alert it with
+type lockWatchChange struct {
+	status      string
+	lastAlerted string
+	previous    lockWatchState
+	current     lockWatchState
+}
+
+// Compares the current holder of a lock watch to the stored one, returning the change to
+// alert on, if any, and the state to store. A watch without a stored state is assumed to
+// have been held, so one that has no holder when it first starts alerts.
+func diffLockHolder(watch *LockWatchConfig, state *lockWatchState, found bool, session string, node string) (*lockWatchChange, *lockWatchState) {
+	next := &lockWatchState{Status: api.HealthPassing, Session: session, Node: node}
+	if session == "" {
+		// Keep the last holder around for the alert's details
+		next.Status, next.Session, next.Node = watch.Status, state.Session, state.Node
+	}
+
+	lastAlerted := state.Status
+	if !found {
+		lastAlerted = api.HealthPassing
+	}
+
+	switch {
+	case next.Status != lastAlerted:
+		return &lockWatchChange{next.Status, lastAlerted, *state, *next}, next
+	case found && session != "" && session != state.Session:
+		return &lockWatchChange{api.HealthPassing, api.HealthPassing, *state, *next}, next
+	}
+	return nil, next
+}
+
+// Watches the holder of a lock watch's key or session and alerts when it's lost or
+// changes
+func watchLock(watch LockWatchConfig, config *Config, client *api.Client, stopCh chan struct{}) {
+	kvPath := lockWatchesKVPath + watch.Name
+	watchRegistrations("lock watch "+watch.Name, kvPath, config, client, stopCh,
+		func(config *Config, queryOpts *api.QueryOptions) (*api.QueryMeta, error) {
+			var session, node string
+			var queryMeta *api.QueryMeta
+			var err error
+			if watch.Key != "" {
+				session, node, queryMeta, err = lockKeyHolder(client, watch.Key, queryOpts)
+			} else {
+				session, node, queryMeta, err = namedSession(client, watch.Session, queryOpts)
+			}
+			if err != nil {
+				return queryMeta, err
+			}
+
+			state := &lockWatchState{}
+			found, err := getRegistrationState(client, kvPath, state)
+			if err != nil {
+				return queryMeta, err
+			}
+
+			change, next := diffLockHolder(&watch, state, found, session, node)
+			if found && change == nil && *next == *state {
+				return queryMeta, nil
+			}
+			if err := setRegistrationState(client, kvPath, next); err != nil {
+				return queryMeta, err
+			}
+			if change != nil {
+				notifyLockChange(config, &watch, change)
+			}
+			return queryMeta, nil
+		})
+}
+
+// Returns the session holding a lock key and the node of the session
+func lockKeyHolder(client *api.Client, key string, queryOpts *api.QueryOptions) (string, string, *api.QueryMeta, error) {
+	pair, queryMeta, err := client.KV().Get(key, queryOpts)
+	if err != nil || pair == nil || pair.Session == "" {
+		return "", "", queryMeta, err
+	}
+
+	session, _, err := client.Session().Info(pair.Session, nil)
+	if err != nil {
+		return "", "", queryMeta, err
+	}
+	if session == nil {
+		// The session was invalidated after the key was read
+		return "", "", queryMeta, nil
+	}
+	return session.ID, session.Node, queryMeta, nil
+}
+
+// Returns the most recently created session with a name and its node
+func namedSession(client *api.Client, name string, queryOpts *api.QueryOptions) (string, string, *api.QueryMeta, error) {
+	sessions, queryMeta, err := client.Session().List(queryOpts)
+	if err != nil {
+		return "", "", queryMeta, err
+	}
+
+	var latest *api.SessionEntry
+	for _, session := range sessions {
+		if session.Name == name && (latest == nil || session.CreateIndex > latest.CreateIndex) {
+			latest = session
+		}
+	}
+	if latest == nil {
+		return "", "", queryMeta, nil
+	}
+	return latest.ID, latest.Node, queryMeta, nil
+}
+
+// Sends the alert for a lock watch losing its holder, getting one again or changing it.
+// A lost holder stays alerting until the lock is held again.
+func notifyLockChange(config *Config, watch *LockWatchConfig, change *lockWatchChange) {
+	datacenter := config.ConsulDatacenter
+	if len(watch.Handlers) > 0 {
+		config = config.withCheckSettings(lockWatchService, &CheckConfig{Handlers: watch.Handlers})
+	}
+
+	alert := &AlertState{
+		Status:      change.status,
+		Node:        change.current.Node,
+		Service:     lockWatchService,
+		Check:       watch.Name,
+		LastAlerted: change.lastAlerted,
+	}
+
+	subject := "lock " + watch.Key
+	if watch.Key == "" {
+		subject = "session " + watch.Session
+	}
+	switch {
+	case change.status != api.HealthPassing && watch.Key != "":
+		alert.Message = fmt.Sprintf("[%s] lock %s lost its holder (lock watch %s)", datacenter, watch.Key, watch.Name)
+	case change.status != api.HealthPassing:
+		alert.Message = fmt.Sprintf("[%s] session %s was invalidated (lock watch %s)", datacenter, watch.Session, watch.Name)
+	case change.lastAlerted != api.HealthPassing:
+		alert.Message = fmt.Sprintf("[%s] %s is held again by node %s (lock watch %s)", datacenter, subject, change.current.Node, watch.Name)
+	default:
+		alert.Message = fmt.Sprintf("[%s] %s moved to node %s (lock watch %s)", datacenter, subject, change.current.Node, watch.Name)
+	}
+
+	if change.status == api.HealthPassing {
+		alert.Details = fmt.Sprintf("Held by session %s on node %s", change.current.Session, change.current.Node)
+		if change.previous.Session != "" {
+			alert.Details += fmt.Sprintf("\nPreviously held by session %s on node %s", change.previous.Session, change.previous.Node)
+		}
+	} else if change.previous.Session != "" {
+		alert.Details = fmt.Sprintf("Last held by session %s on node %s", change.previous.Session, change.previous.Node)
+	}
+
+	alert.Severity = config.alertSeverity(lockWatchService, alert.Status)
+	config.applyMessageTemplates(lockWatchService, alert)
+	notifyHandlers(config, lockWatchService, nil, alert, change.lastAlerted)
+	activeAlerts.update(datacenter, alert)
+	countAlert(alert)
+	history.record(datacenter, alert, time.Now())
+}
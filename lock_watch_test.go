@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestLockWatch_parse(t *testing.T) {
+	config, err := ParseConfig(`
+lock_watch "scheduler" {
+  key = "service/scheduler/leader"
+}
+
+lock_watch "worker" {
+  session = "worker-lock"
+  status = "warning"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.LockWatches) != 2 {
+		t.Fatalf("expected two lock watches, got %d", len(config.LockWatches))
+	}
+	if watch := config.LockWatches[0]; watch.Name != "scheduler" || watch.Key != "service/scheduler/leader" || watch.Status != api.HealthCritical {
+		t.Errorf("unexpected lock watch: %+v", watch)
+	}
+	if watch := config.LockWatches[1]; watch.Session != "worker-lock" || watch.Status != api.HealthWarning {
+		t.Errorf("unexpected lock watch: %+v", watch)
+	}
+
+	for _, raw := range []string{
+		`lock_watch "none" {}`,
+		`lock_watch "both" { key = "a" session = "b" }`,
+		`lock_watch "badstatus" { key = "a" status = "passing" }`,
+		`lock_watch "badhandler" { key = "a" handlers = ["missing"] }`,
+	} {
+		if _, err := ParseConfig(raw); err == nil || !strings.Contains(err.Error(), "Error loading lock_watch") {
+			t.Errorf("expected an error for %s, got %v", raw, err)
+		}
+	}
+}
+
+func TestLockWatch_diffLockHolder(t *testing.T) {
+	watch := &LockWatchConfig{Name: "scheduler", Key: "service/scheduler/leader", Status: api.HealthCritical}
+
+	// Held when first seen, stored without alerting
+	change, state := diffLockHolder(watch, &lockWatchState{}, false, "s1", "node1")
+	if change != nil {
+		t.Errorf("expected no change for a held lock on the first run, got %+v", change)
+	}
+
+	// The holder moving to another session sends a passing notice
+	change, state = diffLockHolder(watch, state, true, "s2", "node2")
+	if change == nil || change.status != api.HealthPassing || change.lastAlerted != api.HealthPassing || change.previous.Session != "s1" {
+		t.Fatalf("expected a passing notice for the new holder, got %+v", change)
+	}
+
+	// Losing the holder alerts, keeping the last holder for the details
+	change, state = diffLockHolder(watch, state, true, "", "")
+	if change == nil || change.status != api.HealthCritical || change.lastAlerted != api.HealthPassing {
+		t.Fatalf("expected a critical alert for the lost holder, got %+v", change)
+	}
+	if state.Session != "s2" || state.Node != "node2" {
+		t.Errorf("expected the last holder to be kept, got %+v", state)
+	}
+
+	if change, _ = diffLockHolder(watch, state, true, "", ""); change != nil {
+		t.Errorf("expected no change while the lock stays free, got %+v", change)
+	}
+
+	change, state = diffLockHolder(watch, state, true, "s3", "node1")
+	if change == nil || change.status != api.HealthPassing || change.lastAlerted != api.HealthCritical {
+		t.Fatalf("expected a recovery once the lock is held again, got %+v", change)
+	}
+	if state.Status != api.HealthPassing || state.Session != "s3" {
+		t.Errorf("unexpected state: %+v", state)
+	}
+
+	// A lock without a holder when the watch first starts alerts
+	change, _ = diffLockHolder(watch, &lockWatchState{}, false, "", "")
+	if change == nil || change.status != api.HealthCritical {
+		t.Errorf("expected a lock without a holder on the first run to alert, got %+v", change)
+	}
+}
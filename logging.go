@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	prefixed "github.com/x-cray/logrus-prefixed-formatter"
+)
+
+// The log file, if one is configured
+var logFile io.Closer
+
+// Sets up the log level, format and output, and the secrets masked in logs and alerts
+func setupLogging(config *Config) error {
+	level, err := log.ParseLevel(config.LogLevel)
+	if err != nil {
+		return fmt.Errorf("Error setting log_level '%s': %s", config.LogLevel, err)
+	}
+
+	var output io.Writer = os.Stderr
+	if config.LogFile != "" {
+		file, err := openRotatingFile(config.LogFile, int64(config.LogFileMaxSize)*1024*1024, config.LogFileMaxBackups)
+		if err != nil {
+			return fmt.Errorf("Error opening log file: %s", err)
+		}
+		if logFile != nil {
+			logFile.Close()
+		}
+		output, logFile = file, file
+	}
+
+	switch config.LogFormat {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		formatter := new(prefixed.TextFormatter)
+		formatter.ForceColors = config.LogFile == ""
+		formatter.DisableColors = config.LogFile != ""
+		log.SetFormatter(formatter)
+	}
+	log.SetOutput(output)
+	log.SetLevel(level)
+
+	secrets.update(config)
+	installRedactHook.Do(func() {
+		log.AddHook(redactHook{secrets})
+	})
+	return nil
+}
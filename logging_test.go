@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestLogging_jsonFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "consul-alerting.log")
+	if err := setupLogging(&Config{LogLevel: "info", LogFormat: "json", LogFile: path}); err != nil {
+		t.Fatal(err)
+	}
+	defer setupLogging(&Config{LogLevel: "debug", LogFormat: "text"})
+
+	log.Debug("not logged")
+	handler := StdoutHandler{LogLevel: "warn", logger: log.StandardLogger()}
+	handler.Alert("dc1", &AlertState{Service: "redis", Status: "critical", Message: "redis is critical", Details: "line 1\nline 2"})
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected a single log entry, got %d: %s", len(lines), data)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "redis is critical" || entry["level"] != "warning" || entry["service"] != "redis" || entry["details"] != "line 1\nline 2" {
+		t.Errorf("unexpected log entry: %v", entry)
+	}
+	if _, ok := entry["node"]; ok {
+		t.Errorf("expected empty fields to be left out: %v", entry)
+	}
+}
+
+func TestLogging_invalidLevel(t *testing.T) {
+	if err := setupLogging(&Config{LogLevel: "loud"}); err == nil {
+		t.Error("expected an error for an invalid log level")
+	}
+}
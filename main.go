@@ -6,7 +6,6 @@ import (
 	"math/rand"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
@@ -16,10 +15,22 @@ import (
 )
 
 const usage = `Usage: consul-alerting [--help] [options]
+       consul-alerting validate [-connect] <config>
+       consul-alerting test-alert [-handler=<type.name>] <config>
+       consul-alerting status [-address=<host:port>] [<config>]
+       consul-alerting silence create|list|delete [options] [<config>]
 
 Options:
 
     -config=<path>    Sets the path to a configuration file on disk.
+    -dry-run          Decides and logs alerts without sending them to the handlers.
+
+Commands:
+
+    validate          Checks a configuration file without starting the daemon.
+    test-alert        Sends a test alert and its recovery to the configured handlers.
+    status            Shows the watches, alerts, silences and handlers of a running daemon.
+    silence           Creates, lists and deletes the silences of a running daemon.
 `
 
 func init() {
@@ -32,10 +43,25 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:], os.Stdout, os.Stderr))
+		case "test-alert":
+			os.Exit(runTestAlert(os.Args[2:], os.Stdout, os.Stderr))
+		case "status":
+			os.Exit(runStatus(os.Args[2:], os.Stdout, os.Stderr))
+		case "silence":
+			os.Exit(runSilence(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	// Parse command line options
 	var config_path string
 	var help bool
+	var dry_run bool
 	flag.StringVar(&config_path, "config", "", "")
+	flag.BoolVar(&dry_run, "dry-run", false, "")
 	flag.BoolVar(&help, "help", false, "")
 	flag.Parse()
 
@@ -58,23 +84,35 @@ func main() {
 		config = DefaultConfig()
 	}
 
-	// Set log level
-	level, err := log.ParseLevel(config.LogLevel)
-	if err != nil {
-		log.Errorf("Error setting loglevel '%s': %s", level, err)
+	// Set up logging
+	if err := setupLogging(config); err != nil {
+		log.Error(err)
 		os.Exit(2)
 	}
-	log.SetLevel(level)
+
+	setRetryPolicy(config.retryPolicy())
+	if config.StatsdAddress != "" {
+		if err := startStatsd(config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	startTracing(config)
+	startDispatcher(config.DispatchWorkers, config.DispatchQueueSize)
+	if config.QueueDir != "" {
+		if err := startQueue(config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := startStateStore(config); err != nil {
+		log.Fatal(err)
+	}
 
 	// Initialize Consul client
-	clientConfig := api.DefaultConfig()
-	clientConfig.Address = config.ConsulAddress
-	addressSplit := strings.Split(config.ConsulAddress, "://")
-	if len(addressSplit) > 1 {
-		clientConfig.Address = addressSplit[1]
-		clientConfig.Scheme = addressSplit[0]
+	setConsulQuerySettings(config)
+	clientConfig, err := config.consulClientConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
-	clientConfig.Token = config.ConsulToken
 
 	log.Infof("Using Consul agent at %s", clientConfig.Address)
 	client, err := api.NewClient(clientConfig)
@@ -92,35 +130,127 @@ func main() {
 		time.Sleep(10 * time.Second)
 	}
 
+	// Make sure the token can keep alert state before starting any watches
+	warnings, err := checkConsulPermissions(client, nodeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, warning := range warnings {
+		log.Warn(warning)
+	}
+
+	// Merge in the config stored in KV. If it can't be loaded, the config file is used
+	// alone until the KV config changes
+	if config.ConfigKVPrefix != "" {
+		loaded, err := loadConfig(config_path, client, config.ConfigKVPrefix)
+		if err != nil {
+			log.Errorf("Error loading config from KV, using the config file alone: %s", err)
+		} else {
+			config = loaded
+			if err := setupLogging(config); err != nil {
+				log.Fatal(err)
+			}
+			setRetryPolicy(config.retryPolicy())
+		}
+	}
+
 	// Get datacenter info if it wasn't specified in the config
 	if config.ConsulDatacenter == "" {
-		agentInfo, err := client.Agent().Self()
+		config.ConsulDatacenter = agentDatacenter(client)
+	}
+	log.Info("Using datacenter: ", config.ConsulDatacenter)
 
-		for err != nil {
-			agentInfo, err = client.Agent().Self()
-			log.Error("Error fetching datacenter from Consul: ", err)
-			log.Error("Retrying in 10s...")
-			time.Sleep(10 * time.Second)
+	if config.DryRun || dry_run {
+		if err := startDryRun(client); err != nil {
+			log.Fatal(err)
 		}
+	}
 
-		config.ConsulDatacenter = agentInfo["Config"]["Datacenter"].(string)
+	recordConfigChange(client, nil, config, "startup")
+	setDeadLetters(config, client)
+	startStateExport(config, client)
+	if err := setupHistory(config); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupAuditLog(config); err != nil {
+		log.Fatal(err)
 	}
-	log.Info("Using datacenter: ", config.ConsulDatacenter)
 
 	if config.DevMode {
 		registerTestServices(client)
 	}
 
+	daemonReadiness.expect("consul", "services")
+	if config.NodeWatch == GlobalMode {
+		daemonReadiness.expect("nodes")
+	}
+	daemonReadiness.update("consul", nil)
+	startHTTPServer(config, client)
+	startGRPCServer(config, client)
+	startDebugServer(config)
+	go watchAcks(client)
+
+	if config.SilenceKVPrefix != "" {
+		go watchSilences(client, config.SilenceKVPrefix)
+	}
+	if config_path != "" {
+		go watchVaultSecrets(config)
+		go renewVaultTokens(config)
+	}
+	if config.ConfigKVPrefix != "" {
+		go watchKVConfig(client, config.ConfigKVPrefix)
+	}
+
 	// Use a shared stop channel between node/service discovery for faster shutdown
 	shutdownCh := make(chan struct{}, 0)
 
-	go discoverServices(nodeName, config, shutdownCh, client)
+	// Each of the goroutines below needs two sends on the shutdown channel to stop
+	shutdownSends := 2
 
-	// If NodeWatch is set to global mode, monitor the catalog for new nodes
-	if config.NodeWatch == GlobalMode {
-		log.Info("Discovering nodes from catalog")
-		go discoverNodes(config, shutdownCh, client)
+	// A dry run watches everything itself, so it neither takes the high availability lock
+	// nor a shard from the live instances
+	highAvailability := config.HighAvailability && !isDryRun()
+	sharding := config.Sharding && !isDryRun()
+
+	// With high availability, the watches below only run once this instance is active
+	if highAvailability {
+		log.Info("Running in high availability mode, waiting to become the active instance")
+		activeInstance.enable()
+	}
+
+	// With sharding, services and nodes are only watched once this instance is among the
+	// shard members
+	if sharding {
+		log.Info("Sharding watches across instances")
+		shards.enable(nodeName)
+	}
+
+	// With datacenters set, services and nodes are discovered in each of them
+	if len(config.Datacenters) > 0 {
+		log.Infof("Watching datacenters %v", config.Datacenters)
+		go watchDatacenters(nodeName, config, shutdownCh, client, clientConfig)
 	} else {
+		if len(config.Partitions) > 0 {
+			log.Infof("Watching partitions %v", config.Partitions)
+		} else if len(config.Namespaces) > 0 {
+			log.Infof("Watching namespaces %v", config.Namespaces)
+		}
+		shutdownSends = 2 * startDiscovery(nodeName, config, shutdownCh, client, clientConfig)
+	}
+
+	// If NodeWatch is set to global mode, the catalog is monitored for new nodes above
+	localNodeWatched := config.NodeWatch != GlobalMode
+	if localNodeWatched {
+		matches, err := localNodeMatches(client, nodeName, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !matches {
+			log.Infof("Not monitoring local node (%s)'s checks, it doesn't have the node_meta %v", nodeName, config.NodeMeta)
+			localNodeWatched = false
+		}
+	}
+	if localNodeWatched {
 		log.Infof("Monitoring local node (%s)'s checks", nodeName)
 		// We're in local mode so we don't need to discover the local node; it won't change
 		opts := &WatchOptions{
@@ -130,6 +260,100 @@ func main() {
 			stopCh: shutdownCh,
 		}
 		go watch(opts)
+		shutdownSends += 2
+	}
+
+	if config.ServerHealth {
+		log.Info("Monitoring the autopilot health of the Consul servers")
+		go watch(&WatchOptions{
+			service:      serverHealthService,
+			serverHealth: true,
+			config:       config,
+			client:       client,
+			stopCh:       shutdownCh,
+		})
+		shutdownSends += 2
+	}
+
+	if config.WANHealth {
+		log.Info("Monitoring the WAN federation of the datacenters")
+		go watch(&WatchOptions{
+			service:   wanHealthService,
+			wanHealth: true,
+			config:    config,
+			client:    client,
+			stopCh:    shutdownCh,
+		})
+		shutdownSends += 2
+	}
+
+	if config.AgentHealth {
+		log.Info("Monitoring the serf membership of the agents")
+		go watch(&WatchOptions{
+			service:     agentHealthService,
+			agentHealth: true,
+			config:      config,
+			client:      client,
+			stopCh:      shutdownCh,
+		})
+		shutdownSends += 2
+	}
+
+	if len(config.PreparedQueries) > 0 {
+		log.Infof("Monitoring the results of prepared queries %v", config.PreparedQueries)
+		go watch(&WatchOptions{
+			service:         preparedQueryService,
+			preparedQueries: true,
+			config:          config,
+			client:          client,
+			stopCh:          shutdownCh,
+		})
+		shutdownSends += 2
+	}
+
+	if config.NodeRegistrations {
+		log.Info("Monitoring nodes registering in and leaving the catalog")
+		go watchNodeRegistrations(config, client, shutdownCh)
+		shutdownSends += 2
+	}
+
+	if config.ServiceRegistrations {
+		log.Info("Monitoring the instances of the services in the catalog")
+		go watchServiceRegistrations(config, client, shutdownCh)
+		shutdownSends += 2
+	}
+
+	for _, slo := range config.SLOs {
+		log.Infof("Monitoring the availability of service %s against SLO %s", slo.Service, slo.Name)
+		go watchSLO(slo, config, client, shutdownCh)
+		shutdownSends += 2
+	}
+
+	for _, kvWatch := range config.KVWatches {
+		log.Infof("Monitoring the keys under %s (kv watch %s)", kvWatch.Prefix, kvWatch.Name)
+		go watchKV(kvWatch, config, client, shutdownCh)
+		shutdownSends += 2
+	}
+
+	for _, lockWatch := range config.LockWatches {
+		log.Infof("Monitoring the holder of lock watch %s", lockWatch.Name)
+		go watchLock(lockWatch, config, client, shutdownCh)
+		shutdownSends += 2
+	}
+
+	for _, heartbeat := range config.Heartbeats {
+		go runHeartbeat(heartbeat)
+	}
+	go watchConsulReachability(nodeName, config)
+
+	if highAvailability {
+		go watchActiveLock(nodeName, config, client, shutdownCh)
+		shutdownSends += 2
+	}
+
+	if sharding {
+		go watchShardMembers(nodeName, config, client, shutdownCh)
+		shutdownSends += 2
 	}
 
 	// Set up signal handling for graceful shutdown
@@ -137,16 +361,41 @@ func main() {
 
 	signal.Notify(c)
 
-	for sig := range c {
+	reload := func() {
+		reloaded, err := reloadConfig(config_path, config, client)
+		if err != nil {
+			log.Errorf("Error reloading configuration, keeping the running one: %s", err)
+			return
+		}
+		config = reloaded
+	}
+
+	for {
+		var sig os.Signal
+		select {
+		case <-reloadRequests:
+			reload()
+			continue
+		case sig = <-c:
+		}
+
 		switch sig {
 		case syscall.SIGINT:
-			shutdown(client, config, shutdownCh)
+			shutdown(client, config, shutdownCh, shutdownSends)
 
 		case syscall.SIGTERM:
-			shutdown(client, config, shutdownCh)
+			shutdown(client, config, shutdownCh, shutdownSends)
 
 		case syscall.SIGQUIT:
-			shutdown(client, config, shutdownCh)
+			shutdown(client, config, shutdownCh, shutdownSends)
+
+		case syscall.SIGHUP:
+			if config_path == "" {
+				log.Warn("Got SIGHUP, but there is no config file to reload")
+				continue
+			}
+			log.Info("Got SIGHUP, reloading configuration")
+			reload()
 
 		default:
 			log.Error("Unknown signal.")
@@ -154,14 +403,29 @@ func main() {
 	}
 }
 
-func shutdown(client *api.Client, config *Config, shutdownCh chan struct{}) {
+func shutdown(client *api.Client, config *Config, shutdownCh chan struct{}, sends int) {
 	log.Info("Got interrupt signal, shutting down")
+
+	// The alerts being sent get until shutdown_timeout to be delivered, after which their
+	// retries are cancelled so they can't hold up stopping the watches
+	timeout := time.Duration(config.ShutdownTimeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	cancelTimer := time.AfterFunc(timeout, cancelRetries)
+
+	// Releasing the active lock would make the queued alerts be dropped as if this
+	// instance were a standby
+	activeInstance.drain()
+
 	log.Info("Releasing locks...")
 	// Send twice to the channel for each watch to stop; first to initiate shutdown and
 	// then to block until the shutdown has finished
-	for i := 0; i < 4; i++ {
+	for i := 0; i < sends; i++ {
 		shutdownCh <- struct{}{}
 	}
+	cancelTimer.Stop()
+	drainAlerts(time.Until(deadline))
+	stopStateExport(10 * time.Second)
+	flushTraces(traceFlushInterval)
 
 	if config.DevMode {
 		client.Agent().CheckDeregister("memory usage")
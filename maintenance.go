@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// What happens to the alerts matched by an active maintenance window
+const (
+	MaintenanceSuppress = "suppress"
+	MaintenanceStdout   = "stdout"
+)
+
+// A recurring window during which the alerts it matches are suppressed or only sent to
+// stdout handlers. The window runs from start to end on each of its days, ending on the
+// next day if end is earlier than start.
+type MaintenanceConfig struct {
+	Name     string   `json:"name"`
+	Service  string   `mapstructure:"service" json:"service,omitempty"`
+	Tags     []string `mapstructure:"tags" json:"tags,omitempty"`
+	Days     []string `mapstructure:"days" json:"days,omitempty"`
+	Start    string   `mapstructure:"start" json:"start"`
+	End      string   `mapstructure:"end" json:"end"`
+	Timezone string   `mapstructure:"timezone" json:"timezone,omitempty"`
+	Action   string   `mapstructure:"action" json:"action"`
+
+	serviceRegexp *regexp.Regexp
+	schedule      *schedule
+}
+
+// Parse the raw maintenance window objects into the config
+func parseMaintenance(list *ast.ObjectList, config *Config) error {
+	config.Maintenance = make([]MaintenanceConfig, 0, len(list.Items))
+
+	for _, w := range list.Items {
+		if len(w.Keys) != 1 {
+			return fmt.Errorf("maintenance must be in the form 'maintenance \"name\" {}'")
+		}
+		name := w.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var window MaintenanceConfig
+		if err := hcl.DecodeObject(&m, w.Val); err != nil {
+			return err
+		}
+		if _, ok := m["action"]; !ok {
+			m["action"] = MaintenanceSuppress
+		}
+		if err := mapstructure.WeakDecode(m, &window); err != nil {
+			return err
+		}
+		window.Name = name
+
+		if err := window.validate(); err != nil {
+			return fmt.Errorf("Error loading maintenance window %s: %s", name, err)
+		}
+		config.Maintenance = append(config.Maintenance, window)
+	}
+
+	return nil
+}
+
+func (window *MaintenanceConfig) validate() error {
+	if !contains([]string{MaintenanceSuppress, MaintenanceStdout}, window.Action) {
+		return fmt.Errorf("invalid action %s", window.Action)
+	}
+
+	var err error
+	if window.serviceRegexp, err = compileRouteRegexp(window.Service); err != nil {
+		return fmt.Errorf("invalid service pattern: %s", err)
+	}
+	if window.Start == "" || window.End == "" {
+		return fmt.Errorf("start and end must be set")
+	}
+	if window.schedule, err = compileSchedule(window.Days, window.Start, window.End, window.Timezone); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Returns whether the window applies to alerts on a service
+func (window *MaintenanceConfig) matches(service string, tags []string) bool {
+	if window.serviceRegexp != nil && !window.serviceRegexp.MatchString(service) {
+		return false
+	}
+	for _, tag := range window.Tags {
+		if !contains(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the first maintenance window active for a service at the given time, or nil
+func (c *Config) activeMaintenance(service string, tags []string, now time.Time) *MaintenanceConfig {
+	for i := range c.Maintenance {
+		window := &c.Maintenance[i]
+		if window.matches(service, tags) && window.schedule.active(now) {
+			return window
+		}
+	}
+	return nil
+}
+
+// Returns the handlers alerts are sent to during a maintenance window: none if they're
+// suppressed, or every stdout handler
+func (c *Config) maintenanceHandlerNames(window *MaintenanceConfig) []string {
+	names := make([]string, 0)
+	if window.Action != MaintenanceStdout {
+		return names
+	}
+
+	for name := range c.Handlers {
+		if strings.HasPrefix(name, "stdout.") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
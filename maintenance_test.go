@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMaintenance_active(t *testing.T) {
+	config, err := ParseConfig(`
+	maintenance "nightly" {
+		tags = ["batch"]
+		days = ["mon", "tue", "wed", "thu", "fri"]
+		start = "23:00"
+		end = "02:00"
+		timezone = "America/New_York"
+	}
+
+	maintenance "reports" {
+		service = "reports(-.*)?"
+		start = "09:00"
+		end = "10:30"
+		action = "stdout"
+	}
+
+	handler "stdout" "log" {}
+	handler "slack" "ops" {
+		api_token = "token"
+		channel_name = "ops"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newYork, _ := time.LoadLocation("America/New_York")
+	cases := []struct {
+		service  string
+		tags     []string
+		now      time.Time
+		expected string
+	}{
+		// Friday night and its early Saturday morning
+		{"jobs", []string{"batch"}, time.Date(2026, 10, 16, 23, 30, 0, 0, newYork), "nightly"},
+		{"jobs", []string{"batch"}, time.Date(2026, 10, 17, 1, 59, 0, 0, newYork), "nightly"},
+		{"jobs", []string{"batch"}, time.Date(2026, 10, 17, 2, 0, 0, 0, newYork), ""},
+		// Saturday night isn't a maintenance day
+		{"jobs", []string{"batch"}, time.Date(2026, 10, 17, 23, 30, 0, 0, newYork), ""},
+		// Monday morning belongs to Sunday's window, which isn't set
+		{"jobs", []string{"batch"}, time.Date(2026, 10, 19, 1, 0, 0, 0, newYork), ""},
+		{"jobs", []string{"web"}, time.Date(2026, 10, 16, 23, 30, 0, 0, newYork), ""},
+		// The timezone defaults to UTC
+		{"reports-daily", nil, time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC), "reports"},
+		{"reports-daily", nil, time.Date(2026, 10, 18, 10, 0, 0, 0, newYork), ""},
+		{"reporting", nil, time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC), ""},
+	}
+	for _, c := range cases {
+		name := ""
+		if window := config.activeMaintenance(c.service, c.tags, c.now); window != nil {
+			name = window.Name
+		}
+		if name != c.expected {
+			t.Errorf("expected window %q for %s %v at %s, got %q", c.expected, c.service, c.tags, c.now, name)
+		}
+	}
+
+	if names := config.maintenanceHandlerNames(&config.Maintenance[0]); len(names) != 0 {
+		t.Errorf("expected suppressed alerts to go to no handlers, got %v", names)
+	}
+	if names := config.maintenanceHandlerNames(&config.Maintenance[1]); !reflect.DeepEqual(names, []string{"stdout.log"}) {
+		t.Errorf("expected only the stdout handlers, got %v", names)
+	}
+}
+
+func TestMaintenance_invalid(t *testing.T) {
+	for _, raw := range []string{
+		`maintenance "a" {
+			start = "2am"
+			end = "04:00"
+		}`,
+		`maintenance "a" {
+			start = "02:00"
+			end = "02:00"
+		}`,
+		`maintenance "a" {
+			start = "02:00"
+			end = "04:00"
+			days = ["someday"]
+		}`,
+		`maintenance "a" {
+			start = "02:00"
+			end = "04:00"
+			timezone = "Nowhere/City"
+		}`,
+		`maintenance "a" {
+			start = "02:00"
+			end = "04:00"
+			action = "page"
+		}`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Errorf("expected error for %q, but nothing was returned", raw)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Tracks the alerts that are currently open on this instance, with their datacenters, for
+// exporting as metrics
+type alertRegistry struct {
+	sync.Mutex
+	alerts map[string]alertPayload
+}
+
+var activeAlerts = &alertRegistry{
+	alerts: make(map[string]alertPayload),
+}
+
+// Records the latest state of an alert, removing it once it has recovered
+func (r *alertRegistry) update(datacenter string, alert *AlertState) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := strings.Join([]string{datacenter, alert.scopedService(), alert.Tag, alert.scopedNode(), alert.Check}, "/")
+	if alert.Status == api.HealthPassing {
+		delete(r.alerts, key)
+	} else {
+		copied := *alert
+		r.alerts[key] = alertPayload{datacenter, &copied}
+	}
+	exportAlertState(datacenter, alert)
+}
+
+// Returns the open alerts, sorted by datacenter, service, node and check
+func (r *alertRegistry) list() []AlertState {
+	payloads := r.payloads()
+	alerts := make([]AlertState, 0, len(payloads))
+	for _, payload := range payloads {
+		alerts = append(alerts, *payload.AlertState)
+	}
+	return alerts
+}
+
+// Returns the open alerts with their datacenters, sorted like list
+func (r *alertRegistry) payloads() []alertPayload {
+	r.Lock()
+	defer r.Unlock()
+
+	keys := make([]string, 0, len(r.alerts))
+	for key := range r.alerts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	payloads := make([]alertPayload, 0, len(keys))
+	for _, key := range keys {
+		alert := *r.alerts[key].AlertState
+		payloads = append(payloads, alertPayload{r.alerts[key].Datacenter, &alert})
+	}
+	return payloads
+}
+
+// Writes a gauge for each open alert in the Prometheus text exposition format
+func (r *alertRegistry) writeMetrics(w io.Writer) {
+	r.Lock()
+	keys := make([]string, 0, len(r.alerts))
+	for key := range r.alerts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(w, "# HELP consul_alerting_alert Alerts currently open, by service, node and check.")
+	fmt.Fprintln(w, "# TYPE consul_alerting_alert gauge")
+	for _, key := range keys {
+		alert, datacenter := r.alerts[key].AlertState, r.alerts[key].Datacenter
+		labels := []string{"service", alert.Service}
+		// The same service or node can be registered in several partitions and namespaces
+		if alert.Partition != "" {
+			labels = append(labels, "partition", alert.Partition)
+		}
+		if alert.Namespace != "" {
+			labels = append(labels, "namespace", alert.Namespace)
+		}
+		labels = append(labels,
+			"tag", alert.Tag,
+			"node", alert.Node,
+			"datacenter", datacenter,
+			"status", alert.Status,
+			"check", alert.Check,
+		)
+		fmt.Fprintf(w, "consul_alerting_alert{%s} 1\n", formatLabels(labels...))
+	}
+	r.Unlock()
+}
+
+// A metric exported on the metrics endpoint, such as a counter, gauge or histogram
+type metric struct {
+	name     string
+	help     string
+	kind     string
+	registry *metricRegistry
+
+	// The values of the metric, keyed by their formatted labels
+	values map[string]float64
+
+	// The upper bounds of a histogram's buckets, and its observations by formatted labels
+	buckets    []float64
+	histograms map[string]*histogram
+}
+
+// The observations of a histogram for one set of labels: the count in each bucket (not
+// cumulative), plus their count and sum
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// The buckets (in seconds) of the latency histograms
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Holds the internal metrics of the daemon
+type metricRegistry struct {
+	sync.Mutex
+	metrics map[string]*metric
+
+	// If set, where updates to the metrics are also sent
+	sink *statsdSink
+}
+
+var metrics = &metricRegistry{
+	metrics: make(map[string]*metric),
+}
+
+// The metrics on the health of the daemon itself
+var (
+	activeWatches = metrics.register("consul_alerting_watches",
+		"Watches running on Consul health checks, by type.", "gauge")
+	consulQueryErrors = metrics.register("consul_alerting_consul_query_errors_total",
+		"Blocking queries to Consul that failed, by what they were watching.", "counter")
+	alertsFired = metrics.register("consul_alerting_alerts_fired_total",
+		"Alerts raised for failing checks, by service and status.", "counter")
+	alertsResolved = metrics.register("consul_alerting_alerts_resolved_total",
+		"Alerts resolved by their checks passing again, by service.", "counter")
+)
+
+// Counts an alert sent to the handlers as fired or resolved
+func countAlert(alert *AlertState) {
+	if alert.Status == api.HealthPassing {
+		alertsResolved.add(1, "service", alert.Service)
+	} else {
+		alertsFired.add(1, "service", alert.Service, "status", alert.Status)
+	}
+}
+
+// Registers a metric with the given name, help text and kind (counter or gauge)
+func (r *metricRegistry) register(name string, help string, kind string) *metric {
+	r.Lock()
+	defer r.Unlock()
+
+	m := &metric{
+		name:     name,
+		help:     help,
+		kind:     kind,
+		registry: r,
+		values:   make(map[string]float64),
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// Registers a histogram with the given upper bounds for its buckets
+func (r *metricRegistry) registerHistogram(name string, help string, buckets []float64) *metric {
+	m := r.register(name, help, "histogram")
+	m.buckets = buckets
+	m.histograms = make(map[string]*histogram)
+	return m
+}
+
+// Adds delta to the metric's value for the given label name/value pairs
+func (m *metric) add(delta float64, labels ...string) {
+	m.registry.Lock()
+	defer m.registry.Unlock()
+
+	key := formatLabels(labels...)
+	m.values[key] += delta
+
+	if sink := m.registry.sink; sink != nil {
+		if m.kind == "gauge" {
+			sink.emit(m, m.values[key], labels)
+		} else {
+			sink.emit(m, delta, labels)
+		}
+	}
+}
+
+// Sets the metric's value for the given label name/value pairs
+func (m *metric) set(value float64, labels ...string) {
+	m.registry.Lock()
+	defer m.registry.Unlock()
+
+	m.values[formatLabels(labels...)] = value
+
+	if sink := m.registry.sink; sink != nil {
+		sink.emit(m, value, labels)
+	}
+}
+
+// Returns the metric's value for the given label name/value pairs
+func (m *metric) value(labels ...string) float64 {
+	m.registry.Lock()
+	defer m.registry.Unlock()
+
+	return m.values[formatLabels(labels...)]
+}
+
+// Records an observation of a histogram for the given label name/value pairs
+func (m *metric) observe(value float64, labels ...string) {
+	m.registry.Lock()
+	defer m.registry.Unlock()
+
+	key := formatLabels(labels...)
+	h, ok := m.histograms[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.histograms[key] = h
+	}
+	for i, bound := range m.buckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+
+	if sink := m.registry.sink; sink != nil {
+		sink.emit(m, value, labels)
+	}
+}
+
+// Writes the buckets, sum and count of a histogram for each set of labels
+func (m *metric) writeHistograms(w io.Writer) {
+	labels := make([]string, 0, len(m.histograms))
+	for label := range m.histograms {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		h := m.histograms[label]
+		prefix := ""
+		if label != "" {
+			prefix = label + ","
+		}
+
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%v\"} %d\n", m.name, prefix, bound, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", m.name, prefix, h.count)
+		if label == "" {
+			fmt.Fprintf(w, "%s_sum %v\n%s_count %d\n", m.name, h.sum, m.name, h.count)
+		} else {
+			fmt.Fprintf(w, "%s_sum{%s} %v\n%s_count{%s} %d\n", m.name, label, h.sum, m.name, label, h.count)
+		}
+	}
+}
+
+// Writes every registered metric in the Prometheus text exposition format
+func (r *metricRegistry) writeMetrics(w io.Writer) {
+	r.Lock()
+	defer r.Unlock()
+
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := r.metrics[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+		if m.kind == "histogram" {
+			m.writeHistograms(w)
+			continue
+		}
+
+		labels := make([]string, 0, len(m.values))
+		for label := range m.values {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			if label == "" {
+				fmt.Fprintf(w, "%s %v\n", m.name, m.values[label])
+			} else {
+				fmt.Fprintf(w, "%s{%s} %v\n", m.name, label, m.values[label])
+			}
+		}
+	}
+}
+
+// Formats pairs of label names and values for a metric, escaping the values
+func formatLabels(pairs ...string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+
+	return strings.Join(labels, ",")
+}
+
+// Serves the metrics endpoint
+func metricsHandler(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		activeAlerts.writeMetrics(w)
+		metrics.writeMetrics(w)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Make sure open alerts are exported as gauges and removed on recovery
+func TestMetrics_activeAlerts(t *testing.T) {
+	registry := &alertRegistry{alerts: make(map[string]alertPayload)}
+
+	registry.update("dc1", &AlertState{Service: "redis", Node: "node1", Status: api.HealthCritical})
+	registry.update("dc2", &AlertState{Service: "nginx", Status: api.HealthWarning})
+	registry.update("dc2", &AlertState{Service: "nginx", Namespace: "team-a", Status: api.HealthWarning})
+
+	var buf bytes.Buffer
+	registry.writeMetrics(&buf)
+
+	expected := []string{
+		`consul_alerting_alert{service="nginx",tag="",node="",datacenter="dc2",status="warning",check=""} 1`,
+		`consul_alerting_alert{service="nginx",namespace="team-a",tag="",node="",datacenter="dc2",status="warning",check=""} 1`,
+		`consul_alerting_alert{service="redis",tag="",node="node1",datacenter="dc1",status="critical",check=""} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected output to contain %q, got:\n%s", line, buf.String())
+		}
+	}
+
+	registry.update("dc1", &AlertState{Service: "redis", Node: "node1", Status: api.HealthPassing})
+
+	buf.Reset()
+	registry.writeMetrics(&buf)
+	if strings.Contains(buf.String(), `service="redis"`) {
+		t.Errorf("expected recovered alert to be removed, got:\n%s", buf.String())
+	}
+}
+
+func TestMetrics_formatLabels(t *testing.T) {
+	labels := formatLabels("service", `a"b\c`, "node", "line\nbreak")
+	expected := `service="a\"b\\c",node="line\nbreak"`
+	if labels != expected {
+		t.Errorf("expected %s, got %s", expected, labels)
+	}
+}
+
+func TestMetrics_histogram(t *testing.T) {
+	registry := &metricRegistry{metrics: make(map[string]*metric)}
+	latency := registry.registerHistogram("test_duration_seconds", "Test latency.", []float64{0.1, 1})
+
+	latency.observe(0.05, "handler", "slack")
+	latency.observe(0.5, "handler", "slack")
+	latency.observe(5, "handler", "slack")
+
+	var buf bytes.Buffer
+	registry.writeMetrics(&buf)
+
+	expected := []string{
+		`# TYPE test_duration_seconds histogram`,
+		`test_duration_seconds_bucket{handler="slack",le="0.1"} 1`,
+		`test_duration_seconds_bucket{handler="slack",le="1"} 2`,
+		`test_duration_seconds_bucket{handler="slack",le="+Inf"} 3`,
+		`test_duration_seconds_sum{handler="slack"} 5.55`,
+		`test_duration_seconds_count{handler="slack"} 3`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected output to contain %q, got:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestMetrics_handlerSends(t *testing.T) {
+	down := int32(1)
+	handler := namedHandler{name: "metrics-test", handler: flakyHandler{&down, make(chan *AlertState, 1)}}
+	handler.call("dc1", &AlertState{Service: "redis", Status: "critical"})
+
+	var buf bytes.Buffer
+	metrics.writeMetrics(&buf)
+	for _, line := range []string{
+		`consul_alerting_handler_sends_total{handler="metrics-test",result="failure"} 1`,
+		`consul_alerting_handler_send_duration_seconds_count{handler="metrics-test"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected output to contain %q", line)
+		}
+	}
+}
+
+// Services with the same name in different partitions and namespaces get series of their own
+func TestMetrics_scopedServices(t *testing.T) {
+	registry := &alertRegistry{alerts: make(map[string]alertPayload)}
+
+	registry.update("dc1", &AlertState{Service: "web", Partition: "team-a", Namespace: "default", Status: api.HealthCritical})
+	registry.update("dc1", &AlertState{Service: "web", Partition: "team-b", Namespace: "default", Status: api.HealthCritical})
+	registry.update("dc1", &AlertState{Service: "web", Partition: "team-b", Namespace: "billing", Status: api.HealthCritical})
+
+	var buf bytes.Buffer
+	registry.writeMetrics(&buf)
+
+	series := make(map[string]bool)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.HasPrefix(line, "consul_alerting_alert{") {
+			continue
+		}
+		if series[line] {
+			t.Errorf("duplicate series %s", line)
+		}
+		series[line] = true
+	}
+	if len(series) != 3 {
+		t.Errorf("expected 3 series, got:\n%s", buf.String())
+	}
+	expected := `consul_alerting_alert{service="web",partition="team-b",namespace="billing",tag="",node="",datacenter="dc1",status="critical",check=""} 1`
+	if !series[expected] {
+		t.Errorf("expected output to contain %q, got:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A minimal MQTT 3.1.1 client, implementing just enough of the protocol to publish
+// messages at QoS 0 or 1.
+
+// The MQTT control packet types used by the client
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttDisconnect = 14
+)
+
+// The timeout for connecting to a broker and for each acknowledgement
+const mqttTimeout = 10 * time.Second
+
+// The reasons a broker can refuse a connection, by CONNACK return code
+var mqttConnectErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// The settings for connecting to an MQTT broker
+type mqttConfig struct {
+	brokers   []string
+	clientID  string
+	username  string
+	password  string
+	qos       int
+	retain    bool
+	tlsConfig *tls.Config
+}
+
+// A publishing connection to an MQTT broker, used as a busConn
+type mqttConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	qos      int
+	retain   bool
+	packetID uint16
+}
+
+// Parses a broker address, which may be a tcp://, ssl:// or tls:// URL or a plain host:port
+func mqttBrokerAddress(broker string) (string, bool, error) {
+	if !strings.Contains(broker, "://") {
+		return broker, false, nil
+	}
+
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", false, err
+	}
+
+	useTLS := u.Scheme == "ssl" || u.Scheme == "tls"
+	if u.Port() == "" {
+		port := "1883"
+		if useTLS {
+			port = "8883"
+		}
+		return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+	}
+	return u.Host, useTLS, nil
+}
+
+// Connects to the first reachable broker
+func dialMQTT(config *mqttConfig) (*mqttConn, error) {
+	var lastErr error
+	for _, broker := range config.brokers {
+		conn, err := dialMQTTBroker(broker, config)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = fmt.Errorf("%s: %s", broker, err)
+	}
+
+	return nil, fmt.Errorf("no brokers reachable, last error: %s", lastErr)
+}
+
+func dialMQTTBroker(broker string, config *mqttConfig) (*mqttConn, error) {
+	address, useTLS, err := mqttBrokerAddress(broker)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+	var conn net.Conn
+	tlsConfig := config.tlsConfig
+	if tlsConfig == nil && useTLS {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mqttConn{conn: conn, reader: bufio.NewReader(conn), qos: config.qos, retain: config.retain}
+	if err := c.connect(config); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Appends a length-prefixed string
+func mqttString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+// Writes a packet with the given fixed header byte and body
+func (c *mqttConn) writePacket(header byte, body []byte) error {
+	var packet bytes.Buffer
+	packet.WriteByte(header)
+
+	// The remaining length is encoded 7 bits at a time
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet.WriteByte(digit)
+		if length == 0 {
+			break
+		}
+	}
+	packet.Write(body)
+
+	_, err := c.conn.Write(packet.Bytes())
+	return err
+}
+
+// Reads a packet, returning its fixed header byte (the packet type and flags) and body
+func (c *mqttConn) readPacket() (byte, []byte, error) {
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, fmt.Errorf("invalid remaining length")
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header, body, nil
+}
+
+func (c *mqttConn) connect(config *mqttConfig) error {
+	c.conn.SetDeadline(time.Now().Add(mqttTimeout))
+
+	// Clean session, since nothing is ever subscribed to
+	flags := byte(0x02)
+	if config.username != "" {
+		flags |= 0x80
+		if config.password != "" {
+			flags |= 0x40
+		}
+	}
+
+	var body bytes.Buffer
+	mqttString(&body, "MQTT")
+	body.WriteByte(4) // protocol level 3.1.1
+	body.WriteByte(flags)
+	binary.Write(&body, binary.BigEndian, uint16(0)) // no keep alive
+	mqttString(&body, config.clientID)
+	if config.username != "" {
+		mqttString(&body, config.username)
+		if config.password != "" {
+			mqttString(&body, config.password)
+		}
+	}
+
+	if err := c.writePacket(mqttConnect<<4, body.Bytes()); err != nil {
+		return err
+	}
+
+	header, ack, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if header>>4 != mqttConnack || len(ack) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", header>>4)
+	}
+	if code := ack[1]; code != 0 {
+		if reason, ok := mqttConnectErrors[code]; ok {
+			return fmt.Errorf("connection refused: %s", reason)
+		}
+		return fmt.Errorf("connection refused with code %d", code)
+	}
+
+	return nil
+}
+
+func (c *mqttConn) Publish(topic string, key string, payload []byte) error {
+	c.conn.SetDeadline(time.Now().Add(mqttTimeout))
+
+	header := byte(mqttPublish<<4) | byte(c.qos<<1)
+	if c.retain {
+		header |= 0x01
+	}
+
+	var body bytes.Buffer
+	mqttString(&body, topic)
+	if c.qos > 0 {
+		c.packetID++
+		if c.packetID == 0 {
+			c.packetID = 1
+		}
+		binary.Write(&body, binary.BigEndian, c.packetID)
+	}
+	body.Write(payload)
+
+	if err := c.writePacket(header, body.Bytes()); err != nil {
+		return err
+	}
+	if c.qos == 0 {
+		return nil
+	}
+
+	for {
+		header, ack, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if header>>4 == mqttPuback && len(ack) >= 2 && binary.BigEndian.Uint16(ack) == c.packetID {
+			return nil
+		}
+	}
+}
+
+func (c *mqttConn) Close() error {
+	c.writePacket(mqttDisconnect<<4, nil)
+	return c.conn.Close()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// A message published to the fake broker
+type testMQTTMessage struct {
+	topic   string
+	payload string
+	qos     int
+	retain  bool
+}
+
+// A fake MQTT broker that accepts any credentials except the user "denied"
+type testMQTTBroker struct {
+	listener  net.Listener
+	published chan testMQTTMessage
+}
+
+func newTestMQTTBroker(t *testing.T) *testMQTTBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := &testMQTTBroker{listener: listener, published: make(chan testMQTTMessage, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+
+	return broker
+}
+
+func (b *testMQTTBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &mqttConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	for {
+		header, body, err := c.readPacket()
+		if err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case mqttConnect:
+			// Skip the protocol name, level and keep alive to get to the flags and payload
+			flags := body[7]
+			d := body[10:]
+			next := func() string {
+				n := int(binary.BigEndian.Uint16(d))
+				s := string(d[2 : 2+n])
+				d = d[2+n:]
+				return s
+			}
+			next() // client id
+
+			code := byte(0)
+			if flags&0x80 != 0 && next() == "denied" {
+				code = 5
+			}
+			c.writePacket(mqttConnack<<4, []byte{0, code})
+		case mqttPublish:
+			n := int(binary.BigEndian.Uint16(body))
+			message := testMQTTMessage{topic: string(body[2 : 2+n]), qos: int(header>>1) & 0x03, retain: header&0x01 != 0}
+			rest := body[2+n:]
+			if message.qos > 0 {
+				c.writePacket(mqttPuback<<4, rest[:2])
+				rest = rest[2:]
+			}
+			message.payload = string(rest)
+			b.published <- message
+		}
+	}
+}
+
+func TestMQTT_publish(t *testing.T) {
+	broker := newTestMQTTBroker(t)
+	defer broker.listener.Close()
+
+	for _, qos := range []int{0, 1} {
+		conn, err := dialMQTT(&mqttConfig{brokers: []string{"tcp://" + broker.listener.Addr().String()}, clientID: "test", qos: qos})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := conn.Publish("consul/alerts", "", []byte(`{"status":"critical"}`)); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case message := <-broker.published:
+			if message.topic != "consul/alerts" || message.payload != `{"status":"critical"}` || message.qos != qos {
+				t.Errorf("unexpected message for qos %d: %+v", qos, message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the message")
+		}
+		conn.Close()
+	}
+}
+
+func TestMQTT_connectRefused(t *testing.T) {
+	broker := newTestMQTTBroker(t)
+	defer broker.listener.Close()
+
+	_, err := dialMQTT(&mqttConfig{brokers: []string{broker.listener.Addr().String()}, username: "denied"})
+	if err == nil {
+		t.Fatal("expected the connection to be refused")
+	}
+}
+
+func TestMQTT_brokerAddress(t *testing.T) {
+	cases := map[string]string{
+		"10.0.0.1:1883":        "10.0.0.1:1883",
+		"tcp://10.0.0.1":       "10.0.0.1:1883",
+		"ssl://mqtt.local":     "mqtt.local:8883",
+		"tls://mqtt.local:443": "mqtt.local:443",
+	}
+
+	for broker, expected := range cases {
+		address, _, err := mqttBrokerAddress(broker)
+		if err != nil || address != expected {
+			t.Errorf("expected %s for %s, got %s (%v)", expected, broker, address, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Watches every namespace in Consul Enterprise when given as the namespaces
+const AllNamespaces = "*"
+
+// The namespace that services are registered in when none is given
+const defaultNamespace = "default"
+
+// How often the namespaces are listed again when watching "*"
+const namespaceDiscoveryInterval = time.Minute
+
+// Returns a copy of the config for the watches of the given namespace, whose alerts are
+// tagged with it
+func (config *Config) forNamespace(namespace string) *Config {
+	copied := *config
+	copied.namespace = namespace
+	return &copied
+}
+
+// Returns the namespaces to watch, listing them from Consul if they're "*"
+func (config *Config) watchedNamespaces(client *api.Client) ([]string, error) {
+	if !contains(config.Namespaces, AllNamespaces) {
+		return config.Namespaces, nil
+	}
+
+	var namespaces []struct {
+		Name string
+	}
+	if _, err := client.Raw().Query("/v1/namespaces", &namespaces, config.queryOptions()); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		names = append(names, namespace.Name)
+	}
+	return names, nil
+}
+
+// Returns the service of an alert, prefixed with its namespace outside of the default one,
+// for telling apart the alerts of services with the same name in different namespaces.
+// Outside of the default partition, the partition and namespace are both prefixed.
+func (alert *AlertState) scopedService() string {
+	if alert.Service == "" {
+		return alert.Service
+	}
+	namespace := alert.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	if alert.Partition != "" && alert.Partition != defaultPartition {
+		return alert.Partition + "/" + namespace + "/" + alert.Service
+	}
+	if namespace == defaultNamespace {
+		return alert.Service
+	}
+	return namespace + "/" + alert.Service
+}
+
+// Adds the partition and namespace to the catalog and health requests made by a client,
+// when they're set, and the partition to the listing of its namespaces. The KV store and
+// sessions holding the alert state and watch locks stay in the agent's partition and the
+// default namespace.
+type scopeTransport struct {
+	partition string
+	namespace string
+	base      http.RoundTripper
+}
+
+func (t *scopeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	scoped := strings.HasPrefix(req.URL.Path, "/v1/catalog/") || strings.HasPrefix(req.URL.Path, "/v1/health/")
+	if scoped || req.URL.Path == "/v1/namespaces" {
+		req = req.Clone(req.Context())
+		query := req.URL.Query()
+		if t.partition != "" {
+			query.Set("partition", t.partition)
+		}
+		if scoped && t.namespace != "" {
+			query.Set("ns", t.namespace)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Builds a client querying the catalog and health of the given partition and namespace,
+// either of which can be empty
+func scopedClient(clientConfig *api.Config, partition string, namespace string) (*api.Client, error) {
+	scopedConfig := *clientConfig
+	base := http.DefaultTransport
+	if clientConfig.HttpClient != nil && clientConfig.HttpClient.Transport != nil {
+		base = clientConfig.HttpClient.Transport
+	}
+	scopedConfig.HttpClient = &http.Client{Transport: &scopeTransport{partition: partition, namespace: namespace, base: base}}
+	return api.NewClient(&scopedConfig)
+}
+
+// Runs the service discovery of each of the namespaces, through clients passing the
+// namespace on catalog and health queries. When watching "*", namespaces being created or
+// deleted are picked up every minute.
+func discoverNamespaces(nodeName string, config *Config, shutdownCh chan struct{}, client *api.Client, clientConfig *api.Config) {
+	watches := make(map[string]*datacenterWatch)
+
+	for {
+		wait := namespaceDiscoveryInterval
+		namespaces, err := config.watchedNamespaces(client)
+		if err != nil {
+			wait = consulErrorWait()
+			log.Errorf("Error trying to list namespaces: %s, retrying in %s...", err, wait.Truncate(time.Millisecond))
+			consulQueryErrors.add(1, "watch", "namespaces")
+		} else {
+			current := make(map[string]bool)
+			for _, namespace := range namespaces {
+				current[namespace] = true
+				if _, ok := watches[namespace]; ok {
+					continue
+				}
+
+				namespacedClient, err := scopedClient(clientConfig, config.partition, namespace)
+				if err != nil {
+					log.Errorf("Error watching namespace %s: %s", namespace, err)
+					continue
+				}
+				log.Infof("Watching namespace %s", namespace)
+				watch := &datacenterWatch{stopCh: make(chan struct{}), goroutines: 1}
+				go discoverServices(nodeName, config.forNamespace(namespace), watch.stopCh, namespacedClient)
+				watches[namespace] = watch
+			}
+
+			for namespace, watch := range watches {
+				if !current[namespace] {
+					log.Infof("Namespace %s was removed, removing its watches", namespace)
+					delete(watches, namespace)
+					go watch.stop()
+				}
+			}
+		}
+
+		select {
+		case <-shutdownCh:
+			log.Infof("Shutting down namespace watches (count: %d)...", len(watches))
+
+			var wg sync.WaitGroup
+			for _, watch := range watches {
+				wg.Add(1)
+				go func(watch *datacenterWatch) {
+					defer wg.Done()
+					watch.stop()
+				}(watch)
+			}
+			wg.Wait()
+			log.Info("Finished shutting down namespace watches")
+			<-shutdownCh
+			return
+		case <-time.After(wait):
+		}
+	}
+}
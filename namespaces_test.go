@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestNamespaces_keys(t *testing.T) {
+	config := &Config{ConsulDatacenter: "dc1"}
+	if root := config.forNamespace("team-a").stateKVRoot(); root != alertingKVRoot+"/namespace/team-a" {
+		t.Errorf("expected team-a to keep its state under its own path, got %s", root)
+	}
+	if root := config.forNamespace(defaultNamespace).stateKVRoot(); root != alertingKVRoot {
+		t.Errorf("expected the default namespace to keep the usual path, got %s", root)
+	}
+
+	// Services with the same name in different namespaces get different keys
+	alert := &AlertState{Service: "redis", Namespace: "team-a", Node: "node1"}
+	if key := alertIncidentKey("dc1", alert); key != "dc1-team-a/redis--node1" {
+		t.Errorf("expected the namespace in the incident key, got %s", key)
+	}
+	if path := ackPath("dc1", alert); path != ackKVPath+"dc1/team-a/redis/_/node1/_" {
+		t.Errorf("expected the namespace in the ack path, got %s", path)
+	}
+	alert.Namespace = defaultNamespace
+	if key := alertIncidentKey("dc1", alert); key != "dc1-redis--node1" {
+		t.Errorf("expected the default namespace to keep the usual incident key, got %s", key)
+	}
+}
+
+func TestNamespaces_parse(t *testing.T) {
+	config, err := ParseConfig(`namespaces = ["default", "team-a"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Namespaces, []string{"default", "team-a"}) {
+		t.Errorf("expected the namespaces, got %v", config.Namespaces)
+	}
+
+	if _, err := ParseConfig(`namespaces = ["*", "team-a"]`); err == nil || !strings.Contains(err.Error(), `along with "*"`) {
+		t.Errorf("expected an error for * with other namespaces, got %v", err)
+	}
+}
+
+func TestNamespaces_watch(t *testing.T) {
+	var lock sync.Mutex
+	queried := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		queried[r.URL.Path] = r.URL.Query().Get("ns")
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/v1/namespaces":
+			w.Write([]byte(`[{"Name": "default"}, {"Name": "team-a"}]`))
+		case "/v1/catalog/services":
+			w.Header().Set("X-Consul-Index", "1")
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = strings.TrimPrefix(server.URL, "http://")
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{Namespaces: []string{AllNamespaces}, ServiceWatch: GlobalMode}
+	namespaces, err := config.watchedNamespaces(client)
+	if err != nil || !reflect.DeepEqual(namespaces, []string{"default", "team-a"}) {
+		t.Fatalf("expected the namespaces from Consul, got %v, %v", namespaces, err)
+	}
+
+	// Only catalog and health queries are made in the namespace
+	namespaced, err := scopedClient(clientConfig, "", "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaced.KV().Get(alertingKVRoot+"/test", nil)
+	if _, _, err := namespaced.Catalog().Services(nil); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	if queried["/v1/catalog/services"] != "team-a" || queried["/v1/kv/"+alertingKVRoot+"/test"] != "" {
+		t.Errorf("expected only the catalog query to be in the namespace, got %v", queried)
+	}
+	delete(queried, "/v1/catalog/services")
+	lock.Unlock()
+
+	config = &Config{Namespaces: []string{"team-b"}, ServiceWatch: GlobalMode}
+	stopCh := make(chan struct{})
+	go discoverNamespaces("node1", config, stopCh, client, clientConfig)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		namespace := queried["/v1/catalog/services"]
+		lock.Unlock()
+		if namespace != "" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopCh <- struct{}{}
+	stopCh <- struct{}{}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if queried["/v1/catalog/services"] != "team-b" {
+		t.Errorf("expected the services of team-b to be queried with its ns parameter, got %v", queried)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal NATS client, implementing just enough of the protocol to publish messages
+// and wait for them to be acknowledged by the server or by JetStream.
+
+// The timeout for connecting to a server and for each acknowledgement
+const natsTimeout = 10 * time.Second
+
+// The characters of the random tokens NATS clients use in inbox subjects
+const natsNUIDAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// The settings for connecting to a NATS server
+type natsConfig struct {
+	servers   []string
+	name      string
+	username  string
+	password  string
+	token     string
+	jetstream bool
+	tlsConfig *tls.Config
+}
+
+// The subset of the server's INFO message the client needs
+type natsInfo struct {
+	TLSRequired  bool `json:"tls_required"`
+	AuthRequired bool `json:"auth_required"`
+}
+
+// The CONNECT message sent after receiving the server's INFO
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name,omitempty"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+	TLS      bool   `json:"tls_required"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+}
+
+// A JetStream publish acknowledgement
+type natsPubAck struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// A publishing connection to a NATS server, used as a busConn
+type natsConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	jetstream bool
+
+	// The inbox JetStream publish acknowledgements are sent to, and the number of
+	// messages published so far, which tells each acknowledgement's subject apart
+	inbox     string
+	published uint64
+}
+
+// Returns an inbox of its own for a connection, _INBOX.<random token>, so instances
+// publishing to the same server don't receive each other's acknowledgements
+func newNATSInbox() (string, error) {
+	token := make([]byte, 22)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	for i, b := range token {
+		token[i] = natsNUIDAlphabet[int(b)%len(natsNUIDAlphabet)]
+	}
+	return "_INBOX." + string(token), nil
+}
+
+// Connects to the first reachable server
+func dialNATS(config *natsConfig) (*natsConn, error) {
+	var lastErr error
+	for _, server := range config.servers {
+		conn, err := dialNATSServer(server, config)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = fmt.Errorf("%s: %s", server, err)
+	}
+
+	return nil, fmt.Errorf("no servers reachable, last error: %s", lastErr)
+}
+
+// Parses a server address, which may be a nats:// or tls:// URL or a plain host:port
+func natsServerAddress(server string) (string, bool, error) {
+	if !strings.Contains(server, "://") {
+		return server, false, nil
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", false, err
+	}
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), "4222"), u.Scheme == "tls", nil
+	}
+	return u.Host, u.Scheme == "tls", nil
+}
+
+func dialNATSServer(server string, config *natsConfig) (*natsConn, error) {
+	address, useTLS, err := natsServerAddress(server)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", address, natsTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &natsConn{conn: conn, reader: bufio.NewReader(conn), jetstream: config.jetstream}
+	if err := c.handshake(address, useTLS, config); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reads the server's INFO, upgrades to TLS if needed and authenticates
+func (c *natsConn) handshake(address string, useTLS bool, config *natsConfig) error {
+	c.conn.SetDeadline(time.Now().Add(natsTimeout))
+
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("expected INFO from server, got: %s", line)
+	}
+
+	var info natsInfo
+	if err := json.Unmarshal([]byte(line[5:]), &info); err != nil {
+		return fmt.Errorf("error decoding server info: %s", err)
+	}
+
+	tlsConfig := config.tlsConfig
+	if tlsConfig == nil && (useTLS || info.TLSRequired) {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig != nil {
+		if tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		tlsConn := tls.Client(c.conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("tls handshake failed: %s", err)
+		}
+		c.conn = tlsConn
+		c.reader = bufio.NewReader(tlsConn)
+	}
+
+	connect, err := json.Marshal(natsConnect{
+		Name:     config.name,
+		User:     config.username,
+		Pass:     config.password,
+		Token:    config.token,
+		TLS:      tlsConfig != nil,
+		Lang:     "go",
+		Version:  "consul-alerting",
+		Protocol: 1,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(c.conn, "CONNECT %s\r\n", connect); err != nil {
+		return err
+	}
+	if c.jetstream {
+		if c.inbox, err = newNATSInbox(); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(c.conn, "SUB %s.* 1\r\n", c.inbox); err != nil {
+			return err
+		}
+	}
+
+	return c.flush()
+}
+
+// Reads a line from the server without the trailing CRLF
+func (c *natsConn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Sends a PING and waits for the PONG, returning any error the server reports first
+func (c *natsConn) flush() error {
+	if _, err := io.WriteString(c.conn, "PING\r\n"); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := c.next()
+		if err != nil {
+			return err
+		}
+		if msg == "PONG" {
+			return nil
+		}
+	}
+}
+
+// Reads the next protocol message, answering PINGs and skipping ones the client doesn't
+// need. Server errors are returned as an error; MSGs are returned as "MSG <subject> <payload>".
+func (c *natsConn) next() (string, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case line == "PING":
+			if _, err := io.WriteString(c.conn, "PONG\r\n"); err != nil {
+				return "", err
+			}
+		case line == "PONG":
+			return line, nil
+		case strings.HasPrefix(line, "-ERR"):
+			return "", fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case strings.HasPrefix(line, "MSG "):
+			fields := strings.Fields(line)
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return "", fmt.Errorf("invalid MSG from server: %s", line)
+			}
+
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(c.reader, payload); err != nil {
+				return "", err
+			}
+			return "MSG " + fields[1] + " " + string(payload[:size]), nil
+		}
+	}
+}
+
+func (c *natsConn) Publish(subject string, key string, payload []byte) error {
+	c.conn.SetDeadline(time.Now().Add(natsTimeout))
+
+	if !c.jetstream {
+		if _, err := fmt.Fprintf(c.conn, "PUB %s %d\r\n%s\r\n", subject, len(payload), payload); err != nil {
+			return err
+		}
+		return c.flush()
+	}
+
+	c.published++
+	reply := c.inbox + "." + strconv.FormatUint(c.published, 10)
+	if _, err := fmt.Fprintf(c.conn, "PUB %s %s %d\r\n%s\r\n", subject, reply, len(payload), payload); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := c.next()
+		if err != nil {
+			return err
+		}
+
+		// Acknowledgements of earlier messages may still arrive, after their publish
+		// timed out
+		parts := strings.SplitN(msg, " ", 3)
+		if len(parts) != 3 || parts[0] != "MSG" || parts[1] != reply {
+			continue
+		}
+
+		var ack natsPubAck
+		if err := json.Unmarshal([]byte(parts[2]), &ack); err != nil {
+			return fmt.Errorf("invalid JetStream ack: %s", err)
+		}
+		if ack.Error != nil {
+			return fmt.Errorf("JetStream error %d: %s", ack.Error.Code, ack.Error.Description)
+		}
+		if ack.Stream == "" {
+			return fmt.Errorf("no JetStream stream matches subject %s", subject)
+		}
+		return nil
+	}
+}
+
+func (c *natsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A published message, as seen by the fake server
+type testNATSMessage struct {
+	subject string
+	reply   string
+	payload string
+}
+
+// A fake NATS server that acknowledges JetStream publishes to ackStream if set
+type testNATSServer struct {
+	listener  net.Listener
+	ackStream string
+	connects  chan string
+	published chan testNATSMessage
+}
+
+func newTestNATSServer(t *testing.T, ackStream string) *testNATSServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testNATSServer{
+		listener:  listener,
+		ackStream: ackStream,
+		connects:  make(chan string, 10),
+		published: make(chan testNATSMessage, 10),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (s *testNATSServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	io.WriteString(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "CONNECT":
+			s.connects <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			io.ReadFull(reader, payload)
+
+			message := testNATSMessage{subject: fields[1], payload: string(payload[:size])}
+			if len(fields) == 4 {
+				message.reply = fields[2]
+				ack := fmt.Sprintf(`{"stream":"%s","seq":1}`, s.ackStream)
+				if s.ackStream == "" {
+					ack = `{"error":{"code":503,"description":"no responders"}}`
+				}
+				fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", message.reply, len(ack), ack)
+			}
+			s.published <- message
+		}
+	}
+}
+
+func TestNATS_publish(t *testing.T) {
+	server := newTestNATSServer(t, "")
+	defer server.listener.Close()
+
+	conn, err := dialNATS(&natsConfig{servers: []string{"nats://" + server.listener.Addr().String()}, token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if connect := <-server.connects; !strings.Contains(connect, `"auth_token":"secret"`) {
+		t.Errorf("expected the token in the CONNECT message, got %s", connect)
+	}
+
+	if err := conn.Publish("alerts", "", []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-server.published:
+		if message.subject != "alerts" || message.payload != "payload" || message.reply != "" {
+			t.Errorf("unexpected message: %+v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+}
+
+func TestNATS_jetstream(t *testing.T) {
+	server := newTestNATSServer(t, "ALERTS")
+	defer server.listener.Close()
+
+	conn, err := dialNATS(&natsConfig{servers: []string{server.listener.Addr().String()}, jetstream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Publish("alerts", "", []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if message := <-server.published; message.reply != conn.inbox+".1" {
+		t.Errorf("expected reply subject %s.1, got %s", conn.inbox, message.reply)
+	}
+
+	// Each connection gets an inbox of its own
+	other, err := dialNATS(&natsConfig{servers: []string{server.listener.Addr().String()}, jetstream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if !strings.HasPrefix(conn.inbox, "_INBOX.") || len(conn.inbox) != len("_INBOX.")+22 || other.inbox == conn.inbox {
+		t.Errorf("expected random inboxes, got %s and %s", conn.inbox, other.inbox)
+	}
+}
+
+func TestNATS_jetstreamError(t *testing.T) {
+	server := newTestNATSServer(t, "")
+	defer server.listener.Close()
+
+	conn, err := dialNATS(&natsConfig{servers: []string{server.listener.Addr().String()}, jetstream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Publish("alerts", "", []byte("payload")); err == nil {
+		t.Error("expected an error for a JetStream publish error")
+	}
+}
+
+func TestNATS_serverAddress(t *testing.T) {
+	cases := map[string]string{
+		"10.0.0.1:4222":         "10.0.0.1:4222",
+		"nats://10.0.0.1":       "10.0.0.1:4222",
+		"tls://nats.local:4443": "nats.local:4443",
+	}
+
+	for server, expected := range cases {
+		address, _, err := natsServerAddress(server)
+		if err != nil || address != expected {
+			t.Errorf("expected %s for %s, got %s (%v)", expected, server, address, err)
+		}
+	}
+}
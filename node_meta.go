@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The catalog and health endpoints that take a node-meta filter, which node_meta is
+// added to
+var nodeMetaFilteredPaths = []string{
+	"/v1/catalog/nodes", "/v1/catalog/services", "/v1/catalog/service/",
+	"/v1/health/checks/", "/v1/health/service/", "/v1/health/state/",
+}
+
+// Returns whether a node's metadata has every one of the wanted values
+func matchesNodeMeta(wanted map[string]string, meta map[string]string) bool {
+	for key, value := range wanted {
+		if meta[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// An HTTP transport adding the node_meta filter to the queries listing nodes and the
+// health of services, so only nodes with the metadata get watched and alerted on
+type nodeMetaTransport struct {
+	nodeMeta map[string]string
+	base     http.RoundTripper
+}
+
+func (t *nodeMetaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, path := range nodeMetaFilteredPaths {
+		if req.URL.Path != path && !(strings.HasSuffix(path, "/") && strings.HasPrefix(req.URL.Path, path)) {
+			continue
+		}
+
+		req = req.Clone(req.Context())
+		keys := make([]string, 0, len(t.nodeMeta))
+		for key := range t.nodeMeta {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		query := req.URL.Query()
+		for _, key := range keys {
+			query.Add("node-meta", key+":"+t.nodeMeta[key])
+		}
+		req.URL.RawQuery = query.Encode()
+		break
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Returns whether the local node has the node_meta, which its node watch in local mode
+// needs since the node's health isn't filtered by it
+func localNodeMatches(client *api.Client, nodeName string, config *Config) (bool, error) {
+	if len(config.NodeMeta) == 0 {
+		return true, nil
+	}
+
+	var catalogNode struct {
+		Node catalogNodeMeta
+	}
+	if _, err := client.Raw().Query("/v1/catalog/node/"+nodeName, &catalogNode, config.queryOptions()); err != nil {
+		return false, fmt.Errorf("Error fetching metadata for node %s: %s", nodeName, err)
+	}
+	return matchesNodeMeta(config.NodeMeta, catalogNode.Node.Meta), nil
+}
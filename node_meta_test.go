@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestNodeMeta_transport(t *testing.T) {
+	parsed, err := ParseConfig(`node_meta = { env = "prod" }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.NodeMeta, map[string]string{"env": "prod"}) {
+		t.Errorf("expected the node_meta, got %v", parsed.NodeMeta)
+	}
+
+	var lock sync.Mutex
+	queried := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		queried[r.URL.Path] = r.URL.Query()["node-meta"]
+		lock.Unlock()
+		w.Header().Set("X-Consul-Index", "1")
+		switch r.URL.Path {
+		case "/v1/catalog/services":
+			w.Write([]byte(`{}`))
+		case "/v1/kv/test":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	config := &Config{ConsulAddress: strings.TrimPrefix(server.URL, "http://"), NodeMeta: map[string]string{"env": "prod", "team": "payments"}}
+	clientConfig, err := config.consulClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Catalog().Services(nil)
+	client.Health().Checks("redis", nil)
+	client.KV().Get("test", nil)
+
+	lock.Lock()
+	defer lock.Unlock()
+	expected := []string{"env:prod", "team:payments"}
+	for _, path := range []string{"/v1/catalog/services", "/v1/health/checks/redis"} {
+		if !reflect.DeepEqual(queried[path], expected) {
+			t.Errorf("expected the node-meta filter on %s, got %v", path, queried[path])
+		}
+	}
+	if meta := queried["/v1/kv/test"]; len(meta) != 0 {
+		t.Errorf("expected no node-meta filter on KV requests, got %v", meta)
+	}
+}
+
+func TestNodeMeta_routes(t *testing.T) {
+	config, err := ParseConfig(`
+default_handlers = ["stdout.default"]
+
+handler "stdout" "default" {}
+handler "stdout" "payments" {}
+
+route "payments" {
+  node_meta = {
+    team = "payments"
+  }
+  handlers = ["stdout.payments"]
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		nodeMeta map[string]string
+		expected []string
+	}{
+		{map[string]string{"team": "payments", "env": "prod"}, []string{"stdout.payments"}},
+		{map[string]string{"team": "search"}, []string{"stdout.default"}},
+		{nil, []string{"stdout.default"}},
+	}
+	for _, c := range cases {
+		names := config.severityHandlerNames("dc1", "redis", "node1", c.nodeMeta, nil, "critical", "passing")
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("node meta %v: expected handlers %v, got %v", c.nodeMeta, c.expected, names)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The name of the pseudo-service used for alerting on nodes registering in and leaving
+// the catalog. A service block with this name can be used to configure its handlers.
+const nodeRegistrationService = "consul-catalog"
+
+// The KV path the registered nodes are kept under between restarts, along with the lock
+// electing the instance that watches them
+const nodeRegistrationsKVPath = alertingKVRoot + "/node-registrations"
+
+// The nodes last seen in the catalog, and the nodes that left it and are still alerting
+// until they register again
+type nodeRegistrationState struct {
+	Nodes        []string `json:"nodes"`
+	Deregistered []string `json:"deregistered"`
+}
+
+// A node registering in or leaving the catalog, and the status to alert it with
+type nodeRegistrationChange struct {
+	node        string
+	status      string
+	lastAlerted string
+}
+
+// Compiles the patterns of an ignore list setting, which have to match whole names
+func compileIgnorePatterns(setting string, patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := compileRouteRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q in %s: %s", pattern, setting, err)
+		}
+		if re != nil {
+			compiled = append(compiled, re)
+		}
+	}
+	return compiled, nil
+}
+
+// Returns whether a name matches one of the given patterns
+func matchesAny(patterns []*regexp.Regexp, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the changes between the stored nodes and the nodes currently in the catalog,
+// along with the state to store next. Nodes matching one of the ignored patterns, such as
+// the nodes of autoscaled pools, are left out.
+func diffNodeRegistrations(state *nodeRegistrationState, nodes []string, ignored []*regexp.Regexp) ([]nodeRegistrationChange, *nodeRegistrationState) {
+	isIgnored := func(node string) bool {
+		return matchesAny(ignored, node)
+	}
+
+	current := make(map[string]bool)
+	next := &nodeRegistrationState{Nodes: make([]string, 0), Deregistered: make([]string, 0)}
+	for _, node := range nodes {
+		if !isIgnored(node) && !current[node] {
+			current[node] = true
+			next.Nodes = append(next.Nodes, node)
+		}
+	}
+	sort.Strings(next.Nodes)
+
+	changes := make([]nodeRegistrationChange, 0)
+	for _, node := range next.Nodes {
+		if contains(state.Deregistered, node) {
+			changes = append(changes, nodeRegistrationChange{node, api.HealthPassing, api.HealthCritical})
+		} else if !contains(state.Nodes, node) {
+			changes = append(changes, nodeRegistrationChange{node, api.HealthPassing, api.HealthPassing})
+		}
+	}
+	for _, node := range state.Nodes {
+		if !current[node] && !isIgnored(node) {
+			changes = append(changes, nodeRegistrationChange{node, api.HealthCritical, api.HealthPassing})
+			next.Deregistered = append(next.Deregistered, node)
+		}
+	}
+	for _, node := range state.Deregistered {
+		if !current[node] && !isIgnored(node) && !contains(next.Deregistered, node) {
+			next.Deregistered = append(next.Deregistered, node)
+		}
+	}
+	sort.Strings(next.Deregistered)
+
+	return changes, next
+}
+
+// Loads the registrations stored under the given KV path into state, returning false if
+// there are none yet
+func getRegistrationState(client *api.Client, kvPath string, state interface{}) (bool, error) {
+	value, _, err := stateKV(client).get(kvPath + "/state")
+	if err != nil {
+		return false, fmt.Errorf("Error loading registrations: %s", err)
+	}
+	if len(value) == 0 {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(value, state); err != nil {
+		return false, fmt.Errorf("Error parsing registrations: %s", err)
+	}
+	return true, nil
+}
+
+// Stores the registrations under the given KV path
+func setRegistrationState(client *api.Client, kvPath string, state interface{}) error {
+	serialized, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("Error forming registrations: %s", err)
+	}
+	if err := stateKV(client).put(kvPath+"/state", serialized); err != nil {
+		return fmt.Errorf("Error storing registrations: %s", err)
+	}
+	return nil
+}
+
+// Sends the alert for a node registering in or leaving the catalog. Deregistered nodes
+// stay alerting until they register again.
+func notifyNodeRegistration(config *Config, change nodeRegistrationChange) {
+	alert := &AlertState{
+		Status:      change.status,
+		Node:        change.node,
+		Service:     nodeRegistrationService,
+		Check:       "registration",
+		LastAlerted: change.lastAlerted,
+	}
+	switch {
+	case change.status == api.HealthCritical:
+		alert.Message = fmt.Sprintf("[%s] node %s was deregistered from the catalog", config.ConsulDatacenter, change.node)
+	case change.lastAlerted == api.HealthCritical:
+		alert.Message = fmt.Sprintf("[%s] node %s registered in the catalog again", config.ConsulDatacenter, change.node)
+	default:
+		alert.Message = fmt.Sprintf("[%s] node %s registered in the catalog", config.ConsulDatacenter, change.node)
+	}
+
+	alert.Severity = config.alertSeverity(nodeRegistrationService, alert.Status)
+	config.applyMessageTemplates(nodeRegistrationService, alert)
+	notifyHandlers(config, nodeRegistrationService, nil, alert, change.lastAlerted)
+	activeAlerts.update(config.ConsulDatacenter, alert)
+	countAlert(alert)
+	history.record(config.ConsulDatacenter, alert, time.Now())
+}
+
+// Watches the nodes in the catalog and alerts when they register or leave it. The nodes
+// found on the first run are only stored, without alerting.
+func watchNodeRegistrations(config *Config, client *api.Client, stopCh chan struct{}) {
+	watchRegistrations("node registrations", nodeRegistrationsKVPath, config, client, stopCh,
+		func(config *Config, queryOpts *api.QueryOptions) (*api.QueryMeta, error) {
+			nodes, queryMeta, err := client.Catalog().Nodes(queryOpts)
+			if err != nil {
+				return nil, err
+			}
+			return queryMeta, updateNodeRegistrations(config, client, nodes)
+		})
+}
+
+// Runs the blocking queries of a registrations watch while holding its lock, shared with
+// the other instances, passing update the latest config on each run
+func watchRegistrations(target string, kvPath string, config *Config, client *api.Client, stopCh chan struct{}, update func(*Config, *api.QueryOptions) (*api.QueryMeta, error)) {
+	apiLock, err := client.LockKey(kvPath + "/leader")
+	if err != nil {
+		log.Fatalf("Error initializing lock for %s: %s", target, err)
+	}
+
+	lock := LockHelper{
+		target:   target,
+		client:   client,
+		lock:     apiLock,
+		stopCh:   make(chan struct{}, 1),
+		lockCh:   make(chan struct{}, 1),
+		callback: func() {},
+	}
+	go lock.start()
+
+	queryOpts := config.queryOptions()
+	for {
+		select {
+		case <-stopCh:
+			lock.stop()
+			<-stopCh
+			return
+		default:
+		}
+
+		if !lock.acquired {
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		queryMeta, err := update(latestConfig(config), queryOpts)
+		if queryMeta != nil {
+			queryOpts.WaitIndex = queryMeta.LastIndex
+		}
+		if err != nil {
+			wait := consulErrorWait()
+			log.Errorf("Error trying to watch %s: %s, retrying in %s...", target, err, wait.Truncate(time.Millisecond))
+			consulQueryErrors.add(1, "watch", strings.Replace(target, " ", "-", -1))
+			time.Sleep(wait)
+		}
+	}
+}
+
+// Compares the nodes in the catalog to the stored ones, storing them and alerting on the
+// nodes that registered or left since
+func updateNodeRegistrations(config *Config, client *api.Client, nodes []*api.Node) error {
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Node)
+	}
+
+	state := &nodeRegistrationState{}
+	found, err := getRegistrationState(client, nodeRegistrationsKVPath, state)
+	if err != nil {
+		return err
+	}
+	baseline := !found
+
+	changes, next := diffNodeRegistrations(state, names, config.nodeRegistrationsIgnore)
+	if len(changes) == 0 && !baseline {
+		return nil
+	}
+	if err := setRegistrationState(client, nodeRegistrationsKVPath, next); err != nil {
+		return err
+	}
+
+	if baseline {
+		log.Infof("Stored the %d nodes in the catalog for alerting on node registrations", len(next.Nodes))
+		return nil
+	}
+	for _, change := range changes {
+		log.Debugf("Node %s changed registration (%s)", change.node, change.status)
+		notifyNodeRegistration(config, change)
+	}
+	return nil
+}
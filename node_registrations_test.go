@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestNodeRegistrations_diff(t *testing.T) {
+	ignored, _ := compileRouteRegexp("asg-.*")
+	state := &nodeRegistrationState{Nodes: []string{"node1", "node2", "asg-1"}, Deregistered: []string{"node3"}}
+
+	changes, next := diffNodeRegistrations(state, []string{"node1", "node3", "node4", "asg-2"}, []*regexp.Regexp{ignored})
+	expected := []nodeRegistrationChange{
+		{"node3", api.HealthPassing, api.HealthCritical},
+		{"node4", api.HealthPassing, api.HealthPassing},
+		{"node2", api.HealthCritical, api.HealthPassing},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+	if !reflect.DeepEqual(next.Nodes, []string{"node1", "node3", "node4"}) || !reflect.DeepEqual(next.Deregistered, []string{"node2"}) {
+		t.Errorf("expected the next state to leave out the ignored nodes, got %+v", next)
+	}
+
+	// Deregistered nodes stay alerting until they register again
+	changes, next = diffNodeRegistrations(next, []string{"node1", "node3"}, nil)
+	if len(changes) != 1 || changes[0].node != "node4" || changes[0].status != api.HealthCritical {
+		t.Errorf("expected node4 to be deregistered, got %v", changes)
+	}
+	if !reflect.DeepEqual(next.Deregistered, []string{"node2", "node4"}) {
+		t.Errorf("expected node2 and node4 to stay deregistered, got %v", next.Deregistered)
+	}
+}
+
+func TestNodeRegistrations_update(t *testing.T) {
+	var lock sync.Mutex
+	var stored []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.Method {
+		case "PUT":
+			stored, _ = ioutil.ReadAll(r.Body)
+			w.Write([]byte(`true`))
+		default:
+			if stored == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`[{"Key": "state", "Value": "` + base64.StdEncoding.EncodeToString(stored) + `"}]`))
+		}
+	}))
+	defer server.Close()
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = strings.TrimPrefix(server.URL, "http://")
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, alertCh := testAlertConfig()
+	config.ConsulDatacenter = "dc1"
+	config.DefaultHandlers = []string{"test"}
+
+	// The first run only stores the nodes
+	if err := updateNodeRegistrations(config, client, []*api.Node{{Node: "node1"}, {Node: "node2"}}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case alert := <-alertCh:
+		t.Fatalf("expected no alert for the first run, got %v", alert)
+	default:
+	}
+
+	if err := updateNodeRegistrations(config, client, []*api.Node{{Node: "node1"}}); err != nil {
+		t.Fatal(err)
+	}
+	var alert *AlertState
+	select {
+	case alert = <-alertCh:
+	case <-time.After(1 * time.Second):
+		t.Fatal("didn't get an alert for node2 leaving the catalog")
+	}
+	if alert.Status != api.HealthCritical || alert.Node != "node2" || alert.Service != nodeRegistrationService {
+		t.Errorf("expected a critical alert for node2, got %+v", alert)
+	}
+	if alert.Message != "[dc1] node node2 was deregistered from the catalog" {
+		t.Errorf("unexpected message: %s", alert.Message)
+	}
+}
+
+func TestNodeRegistrations_config(t *testing.T) {
+	config, err := ParseConfig(`
+node_registrations = true
+node_registrations_ignore = ["asg-.*", "spot-[0-9]+"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.NodeRegistrations || len(config.nodeRegistrationsIgnore) != 2 {
+		t.Errorf("expected node registrations with two ignored patterns, got %+v", config)
+	}
+	if !config.nodeRegistrationsIgnore[0].MatchString("asg-web") || config.nodeRegistrationsIgnore[1].MatchString("spot-a") {
+		t.Error("expected the patterns to match whole node names")
+	}
+
+	if _, err := ParseConfig(`node_registrations_ignore = ["("]`); err == nil || !strings.Contains(err.Error(), "node_registrations_ignore") {
+		t.Errorf("expected an error for an invalid pattern, got %v", err)
+	}
+}
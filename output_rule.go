@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// A rule matching the output of health checks, named after the signature it gives the
+// alerts of matching checks. The rule can also override the status of matching checks,
+// e.g. to escalate a warning whose output says "connection refused".
+type OutputRuleConfig struct {
+	Name    string `json:"name"`
+	Pattern string `mapstructure:"pattern" json:"pattern"`
+	Service string `mapstructure:"service" json:"service,omitempty"`
+	Status  string `mapstructure:"status" json:"status,omitempty"`
+
+	patternRegexp *regexp.Regexp
+	serviceRegexp *regexp.Regexp
+}
+
+// Parse the raw output_rule objects into the config, keeping the order they were declared in
+func parseOutputRules(list *ast.ObjectList, config *Config) error {
+	config.OutputRules = make([]OutputRuleConfig, 0, len(list.Items))
+
+	for _, r := range list.Items {
+		if len(r.Keys) != 1 {
+			return fmt.Errorf("output_rule must be in the form 'output_rule \"name\" {}'")
+		}
+		name := r.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var rule OutputRuleConfig
+		if err := hcl.DecodeObject(&m, r.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &rule); err != nil {
+			return err
+		}
+		rule.Name = name
+
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("Error loading output_rule %s: %s", name, err)
+		}
+		config.OutputRules = append(config.OutputRules, rule)
+	}
+
+	return nil
+}
+
+func (rule *OutputRuleConfig) validate() error {
+	if rule.Pattern == "" {
+		return fmt.Errorf("no pattern set")
+	}
+
+	var err error
+	if rule.patternRegexp, err = regexp.Compile(rule.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %s", err)
+	}
+	if rule.serviceRegexp, err = compileRouteRegexp(rule.Service); err != nil {
+		return fmt.Errorf("invalid service pattern: %s", err)
+	}
+
+	if rule.Status != "" && !contains([]string{api.HealthPassing, api.HealthWarning, api.HealthCritical}, rule.Status) {
+		return fmt.Errorf("invalid status %s", rule.Status)
+	}
+	return nil
+}
+
+// Returns whether the rule applies to a check, by its service and output
+func (rule *OutputRuleConfig) matches(check *api.HealthCheck) bool {
+	if rule.serviceRegexp != nil && !rule.serviceRegexp.MatchString(check.ServiceName) {
+		return false
+	}
+	return rule.patternRegexp.MatchString(check.Output)
+}
+
+// Returns the checks with their statuses overridden by the first matching output rule
+// that sets a status. Checks are copied rather than changed.
+func (c *Config) applyOutputRules(checks []*api.HealthCheck) []*api.HealthCheck {
+	if len(c.OutputRules) == 0 {
+		return checks
+	}
+
+	applied := make([]*api.HealthCheck, 0, len(checks))
+	for _, check := range checks {
+		for i := range c.OutputRules {
+			rule := &c.OutputRules[i]
+			if rule.Status != "" && rule.matches(check) {
+				overridden := *check
+				overridden.Status = rule.Status
+				check = &overridden
+				break
+			}
+		}
+		applied = append(applied, check)
+	}
+	return applied
+}
+
+// Returns the names of the output rules matched by the failing checks of an alert
+func (c *Config) outputSignatures(checks []*api.HealthCheck) []string {
+	var signatures []string
+	for i := range c.OutputRules {
+		rule := &c.OutputRules[i]
+		for _, check := range checks {
+			if check.Status != api.HealthPassing && rule.matches(check) {
+				signatures = append(signatures, rule.Name)
+				break
+			}
+		}
+	}
+	return signatures
+}
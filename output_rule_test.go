@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestOutputRule_apply(t *testing.T) {
+	config, err := ParseConfig(`
+output_rule "connection-refused" {
+  pattern = "connection refused"
+  status = "critical"
+}
+
+output_rule "oom" {
+  pattern = "(?i)out of memory"
+  service = "redis"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []*api.HealthCheck{
+		{Node: "node1", CheckID: "http", ServiceName: "web", Status: api.HealthWarning, Output: "dial tcp: connection refused"},
+		{Node: "node1", CheckID: "mem", ServiceName: "redis", Status: api.HealthWarning, Output: "Out of memory"},
+		{Node: "node1", CheckID: "mem", ServiceName: "web", Status: api.HealthWarning, Output: "Out of memory"},
+	}
+	applied := config.applyOutputRules(checks)
+	if applied[0].Status != api.HealthCritical || checks[0].Status != api.HealthWarning {
+		t.Errorf("expected a critical copy of the refused check, got %s and %s", applied[0].Status, checks[0].Status)
+	}
+	if applied[1].Status != api.HealthWarning {
+		t.Errorf("expected a rule without a status to leave the check as it is, got %s", applied[1].Status)
+	}
+
+	if signatures := config.outputSignatures(applied); !reflect.DeepEqual(signatures, []string{"connection-refused", "oom"}) {
+		t.Errorf("unexpected signatures: %v", signatures)
+	}
+	if signatures := config.outputSignatures(applied[2:]); len(signatures) != 0 {
+		t.Errorf("expected the oom rule not to match other services, got %v", signatures)
+	}
+
+	if _, err := ParseConfig(`output_rule "bad" { pattern = "(" }`); err == nil || !strings.Contains(err.Error(), "Error loading output_rule bad") {
+		t.Errorf("expected an error for an invalid pattern, got %v", err)
+	}
+}
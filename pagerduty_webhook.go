@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The alert a PagerDuty incident was opened for
+type pagerdutyIncident struct {
+	Datacenter string
+	Alert      AlertState
+}
+
+// The incidents opened by PagerDuty handlers that haven't been resolved yet, keyed by
+// incident key, so webhooks about them can be matched to their alert
+type pagerdutyIncidentRegistry struct {
+	sync.Mutex
+	incidents map[string]pagerdutyIncident
+}
+
+var pagerdutyIncidents = &pagerdutyIncidentRegistry{
+	incidents: make(map[string]pagerdutyIncident),
+}
+
+// Records the incident of a failing alert by its incident key, or forgets it once the
+// alert recovers
+func (r *pagerdutyIncidentRegistry) track(key string, datacenter string, alert *AlertState) {
+	r.Lock()
+	defer r.Unlock()
+
+	if alert.Status == api.HealthPassing {
+		delete(r.incidents, key)
+		return
+	}
+	r.incidents[key] = pagerdutyIncident{
+		Datacenter: datacenter,
+		Alert:      AlertState{Service: alert.Service, Namespace: alert.Namespace, Partition: alert.Partition, Tag: alert.Tag, Node: alert.Node, Check: alert.Check},
+	}
+}
+
+func (r *pagerdutyIncidentRegistry) get(key string) (pagerdutyIncident, bool) {
+	r.Lock()
+	defer r.Unlock()
+	incident, ok := r.incidents[key]
+	return incident, ok
+}
+
+// Checks the signature PagerDuty adds to its webhooks (v1=HMAC-SHA256 of the body). The
+// header holds several comma separated signatures while a secret is being rotated.
+func verifyPagerdutySignature(secret string, body []byte, header string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "v1=" + hex.EncodeToString(mac.Sum(nil))
+
+	for _, signature := range strings.Split(header, ",") {
+		if hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature))) {
+			return true
+		}
+	}
+	return false
+}
+
+// The parts of a PagerDuty v3 webhook used to sync acknowledgements
+type pagerdutyWebhook struct {
+	Event struct {
+		EventType string `json:"event_type"`
+		Agent     struct {
+			Summary string `json:"summary"`
+		} `json:"agent"`
+		Data struct {
+			IncidentKey string `json:"incident_key"`
+		} `json:"data"`
+	} `json:"event"`
+}
+
+// Handles PagerDuty webhooks, acknowledging the alert of an incident when it's acknowledged
+// or resolved in PagerDuty, and removing the acknowledgement when it's unacknowledged.
+// Webhooks must be signed with the webhook secret of one of the PagerDuty handlers.
+func pagerdutyWebhookHandler(config *Config, client *api.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// A reload may have rotated the webhook secrets
+		verified := false
+		for _, h := range latestConfig(config).Handlers {
+			if handler, ok := h.(PagerdutyHandler); ok && handler.WebhookSecret != "" &&
+				verifyPagerdutySignature(handler.WebhookSecret, body, r.Header.Get("X-PagerDuty-Signature")) {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var webhook pagerdutyWebhook
+		if err := json.Unmarshal(body, &webhook); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		event := webhook.Event
+
+		// Incidents that weren't opened by consul-alerting, or whose alert already
+		// recovered, are ignored
+		incident, ok := pagerdutyIncidents.get(event.Data.IncidentKey)
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		by := event.Agent.Summary
+		if by == "" {
+			by = "PagerDuty"
+		}
+
+		switch event.EventType {
+		case "incident.acknowledged", "incident.resolved":
+			err = acknowledge(client, incident.Datacenter, &incident.Alert, alertAck{By: by, Comment: "via PagerDuty"})
+		case "incident.unacknowledged":
+			_, err = client.KV().Delete(ackPath(incident.Datacenter, &incident.Alert), nil)
+		}
+
+		if err != nil {
+			log.Errorf("Error syncing PagerDuty %s for incident %s: %s", event.EventType, event.Data.IncidentKey, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func testPagerdutyWebhook(secret string, eventType string, incidentKey string) *http.Request {
+	body, _ := json.Marshal(map[string]interface{}{
+		"event": map[string]interface{}{
+			"event_type": eventType,
+			"agent":      map[string]string{"summary": "Alice"},
+			"data":       map[string]string{"type": "incident", "incident_key": incidentKey},
+		},
+	})
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	req := httptest.NewRequest("POST", "/v1/pagerduty/webhook", strings.NewReader(string(body)))
+	req.Header.Set("X-PagerDuty-Signature", "v1=0000,v1="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestPagerdutyWebhook_syncsAcks(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	config := &Config{
+		Handlers: map[string]AlertHandler{
+			"pagerduty.ops": PagerdutyHandler{RoutingKey: "key", WebhookSecret: "secret"},
+		},
+	}
+	handler := pagerdutyWebhookHandler(config, client)
+
+	alert := &AlertState{Service: "redis", Node: "node1", Check: "service:redis", Status: api.HealthCritical}
+	pagerdutyIncidents.track("dc1-redis--node1", "dc1", alert)
+	defer pagerdutyIncidents.track("dc1-redis--node1", "dc1", &AlertState{Service: "redis", Node: "node1", Status: api.HealthPassing})
+
+	path := "service/consul-alerting/acks/dc1/redis/_/node1/service:redis"
+	w := httptest.NewRecorder()
+	handler(w, testPagerdutyWebhook("secret", "incident.acknowledged", "dc1-redis--node1"))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	var ack alertAck
+	if err := json.Unmarshal(values[path], &ack); err != nil || ack.By != "Alice" || ack.Comment != "via PagerDuty" {
+		t.Errorf("expected an acknowledgement by Alice, got %+v (%v)", ack, err)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, testPagerdutyWebhook("secret", "incident.unacknowledged", "dc1-redis--node1"))
+	if _, ok := values[path]; ok || w.Code != http.StatusNoContent {
+		t.Errorf("expected the acknowledgement to be removed, got status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, testPagerdutyWebhook("secret", "incident.acknowledged", "dc1-unknown--"))
+	if len(values) != 0 || w.Code != http.StatusNoContent {
+		t.Errorf("expected unknown incidents to be ignored, got status %d and %v", w.Code, values)
+	}
+}
+
+func TestPagerdutyWebhook_invalidSignature(t *testing.T) {
+	config := &Config{
+		Handlers: map[string]AlertHandler{
+			"pagerduty.ops": PagerdutyHandler{RoutingKey: "key", WebhookSecret: "secret"},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	pagerdutyWebhookHandler(config, nil)(w, testPagerdutyWebhook("wrong", "incident.acknowledged", "dc1-redis--node1"))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+// A webhook secret rotated by a reload replaces the one the server was started with
+func TestPagerdutyWebhook_reloadedSecret(t *testing.T) {
+	config := &Config{
+		Handlers: map[string]AlertHandler{
+			"pagerduty.ops": PagerdutyHandler{RoutingKey: "key", WebhookSecret: "old"},
+		},
+	}
+	handler := pagerdutyWebhookHandler(config, nil)
+	setReloadedConfig(&Config{
+		Handlers: map[string]AlertHandler{
+			"pagerduty.ops": PagerdutyHandler{RoutingKey: "key", WebhookSecret: "new"},
+		},
+	})
+	defer setReloadedConfig(nil)
+
+	w := httptest.NewRecorder()
+	handler(w, testPagerdutyWebhook("old", "incident.acknowledged", "dc1-unknown--"))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the rotated secret to be refused, got status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler(w, testPagerdutyWebhook("new", "incident.acknowledged", "dc1-unknown--"))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected the reloaded secret to be accepted, got status %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Watches every admin partition in Consul Enterprise when given as the partitions
+const AllPartitions = "*"
+
+// The partition of agents that aren't given one, and of every agent outside of Enterprise
+const defaultPartition = "default"
+
+// How often the partitions are listed again when watching "*"
+const partitionDiscoveryInterval = time.Minute
+
+// Returns a copy of the config for the watches of the given partition, whose alerts are
+// tagged with it. Services in partitions other than the agent's are always watched
+// globally, since the local node isn't in them.
+func (config *Config) forPartition(partition string, local string) *Config {
+	copied := *config
+	copied.partition = partition
+	copied.localPartition = local
+	if partition != local {
+		copied.ServiceWatch = GlobalMode
+	}
+	return &copied
+}
+
+// Returns the partitions to watch, listing them from Consul if they're "*"
+func (config *Config) watchedPartitions(client *api.Client) ([]string, error) {
+	if !contains(config.Partitions, AllPartitions) {
+		return config.Partitions, nil
+	}
+
+	var partitions []struct {
+		Name string
+	}
+	if _, err := client.Raw().Query("/v1/partitions", &partitions, config.queryOptions()); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		names = append(names, partition.Name)
+	}
+	return names, nil
+}
+
+// Looks up the partition of the Consul agent, retrying until it succeeds. Agents outside
+// of Enterprise are in the default partition.
+func agentPartition(client *api.Client) string {
+	for {
+		agentInfo, err := client.Agent().Self()
+		if err == nil {
+			if partition, ok := agentInfo["Config"]["Partition"].(string); ok && partition != "" {
+				return partition
+			}
+			return defaultPartition
+		}
+		log.Error("Error fetching partition from Consul: ", err)
+		wait := consulErrorWait()
+		log.Errorf("Retrying in %s...", wait.Truncate(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
+
+// Returns the node of an alert, prefixed with its partition outside of the default one
+func (alert *AlertState) scopedNode() string {
+	if alert.Node == "" || alert.Partition == "" || alert.Partition == defaultPartition {
+		return alert.Node
+	}
+	return alert.Partition + "/" + alert.Node
+}
+
+// Starts the discovery of services, and of nodes if node_watch is global or of external
+// nodes with external_services, split up by partition and namespace when they're set.
+// Returns the number of goroutines started, which each need two sends on the stop
+// channel to stop.
+func startDiscovery(nodeName string, config *Config, stopCh chan struct{}, client *api.Client, clientConfig *api.Config) int {
+	if len(config.Partitions) > 0 && config.partition == "" {
+		go discoverPartitions(nodeName, config, stopCh, client, clientConfig)
+		return 1
+	}
+
+	if len(config.Namespaces) > 0 {
+		go discoverNamespaces(nodeName, config, stopCh, client, clientConfig)
+	} else {
+		go discoverServices(nodeName, config, stopCh, client)
+	}
+	if config.NodeWatch == GlobalMode {
+		log.Info("Discovering nodes from catalog")
+		go discoverNodes(config, stopCh, client)
+		return 2
+	}
+	if config.ExternalServices {
+		log.Info("Discovering external nodes from catalog")
+		go discoverNodes(config, stopCh, client)
+		return 2
+	}
+	return 1
+}
+
+// Runs the discovery of each of the partitions, through clients passing the partition on
+// catalog and health queries. When watching "*", partitions being created or deleted are
+// picked up every minute.
+func discoverPartitions(nodeName string, config *Config, shutdownCh chan struct{}, client *api.Client, clientConfig *api.Config) {
+	local := agentPartition(client)
+	watches := make(map[string]*datacenterWatch)
+
+	for {
+		wait := partitionDiscoveryInterval
+		partitions, err := config.watchedPartitions(client)
+		if err != nil {
+			wait = consulErrorWait()
+			log.Errorf("Error trying to list partitions: %s, retrying in %s...", err, wait.Truncate(time.Millisecond))
+			consulQueryErrors.add(1, "watch", "partitions")
+		} else {
+			current := make(map[string]bool)
+			for _, partition := range partitions {
+				current[partition] = true
+				if _, ok := watches[partition]; ok {
+					continue
+				}
+
+				partitionClient, err := scopedClient(clientConfig, partition, "")
+				if err != nil {
+					log.Errorf("Error watching partition %s: %s", partition, err)
+					continue
+				}
+				log.Infof("Watching partition %s", partition)
+				watch := &datacenterWatch{stopCh: make(chan struct{})}
+				watch.goroutines = startDiscovery(nodeName, config.forPartition(partition, local), watch.stopCh, partitionClient, clientConfig)
+				watches[partition] = watch
+			}
+
+			for partition, watch := range watches {
+				if !current[partition] {
+					log.Infof("Partition %s was removed, removing its watches", partition)
+					delete(watches, partition)
+					go watch.stop()
+				}
+			}
+		}
+
+		select {
+		case <-shutdownCh:
+			log.Infof("Shutting down partition watches (count: %d)...", len(watches))
+
+			var wg sync.WaitGroup
+			for _, watch := range watches {
+				wg.Add(1)
+				go func(watch *datacenterWatch) {
+					defer wg.Done()
+					watch.stop()
+				}(watch)
+			}
+			wg.Wait()
+			log.Info("Finished shutting down partition watches")
+			<-shutdownCh
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestPartitions_forPartition(t *testing.T) {
+	config := &Config{ConsulDatacenter: "dc1", ServiceWatch: LocalMode}
+
+	local := config.forPartition("default", "default")
+	remote := config.forPartition("team-a", "default")
+	if local.ServiceWatch != LocalMode || local.stateKVRoot() != alertingKVRoot {
+		t.Errorf("expected the agent's partition to keep the service watch mode and KV root, got %+v", local)
+	}
+	if remote.ServiceWatch != GlobalMode || remote.stateKVRoot() != alertingKVRoot+"/partition/team-a" {
+		t.Errorf("expected other partitions to watch services globally under their own KV root, got %+v", remote)
+	}
+	if root := remote.forNamespace("web").stateKVRoot(); root != alertingKVRoot+"/partition/team-a/namespace/web" {
+		t.Errorf("expected the namespace's state under its partition, got %s", root)
+	}
+
+	// After a reload, the watches of each partition get a copy of the new config
+	setReloadedConfig(&Config{ConsulDatacenter: "dc1", ServiceWatch: LocalMode, ChangeThreshold: 30})
+	defer setReloadedConfig(nil)
+	latest := latestConfig(remote.forNamespace("web"))
+	if latest.partition != "team-a" || latest.namespace != "web" || latest.ServiceWatch != GlobalMode || latest.ChangeThreshold != 30 {
+		t.Errorf("expected a team-a/web copy of the reloaded config, got %+v", latest)
+	}
+}
+
+func TestPartitions_keys(t *testing.T) {
+	alert := &AlertState{Service: "redis", Partition: "team-a", Node: "node1"}
+	if key := alertIncidentKey("dc1", alert); key != "dc1-team-a/default/redis--team-a/node1" {
+		t.Errorf("expected the partition in the incident key, got %s", key)
+	}
+
+	alert = &AlertState{Node: "node1", Partition: "team-a"}
+	if path := ackPath("dc1", alert); path != ackKVPath+"dc1/_/_/team-a/node1/_" {
+		t.Errorf("expected the partition in the node's ack path, got %s", path)
+	}
+	alert.Partition = defaultPartition
+	if key := alertIncidentKey("dc1", alert); key != "dc1---node1" {
+		t.Errorf("expected the default partition to keep the usual incident key, got %s", key)
+	}
+}
+
+func TestPartitions_routes(t *testing.T) {
+	config, err := ParseConfig(`
+partitions = ["default", "team-a"]
+
+handler "stdout" "team-a" {}
+
+route "team-a" {
+  partition = "team-a"
+  handlers = ["stdout.team-a"]
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Partitions, []string{"default", "team-a"}) {
+		t.Errorf("expected the partitions, got %v", config.Partitions)
+	}
+
+	if _, matched := config.forPartition("team-a", "default").routeHandlerNames("dc1", "web", "", nil, nil, "critical", []string{"passing"}, time.Now()); !matched {
+		t.Error("expected the route to match alerts in team-a")
+	}
+	if _, matched := config.routeHandlerNames("dc1", "web", "", nil, nil, "critical", []string{"passing"}, time.Now()); matched {
+		t.Error("expected the route not to match alerts in the default partition")
+	}
+
+	if _, err := ParseConfig(`partitions = ["*", "team-a"]`); err == nil || !strings.Contains(err.Error(), `along with "*"`) {
+		t.Errorf("expected an error for * with other partitions, got %v", err)
+	}
+}
+
+func TestPartitions_watch(t *testing.T) {
+	var lock sync.Mutex
+	queried := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		queried[r.URL.Path+"?partition="+r.URL.Query().Get("partition")+"&ns="+r.URL.Query().Get("ns")] = true
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/v1/agent/self":
+			w.Write([]byte(`{"Config": {"Datacenter": "dc1", "Partition": "default"}}`))
+		case "/v1/partitions":
+			w.Write([]byte(`[{"Name": "default"}, {"Name": "team-a"}]`))
+		case "/v1/namespaces":
+			w.Write([]byte(`[{"Name": "web"}]`))
+		case "/v1/catalog/services":
+			w.Header().Set("X-Consul-Index", "1")
+			w.Write([]byte(`{}`))
+		case "/v1/catalog/nodes":
+			w.Header().Set("X-Consul-Index", "1")
+			w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = strings.TrimPrefix(server.URL, "http://")
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The nodes of each partition are watched, and the services of its namespaces
+	config := &Config{Partitions: []string{AllPartitions}, Namespaces: []string{AllNamespaces}, ServiceWatch: GlobalMode, NodeWatch: GlobalMode}
+	stopCh := make(chan struct{})
+	if goroutines := startDiscovery("node1", config, stopCh, client, clientConfig); goroutines != 1 {
+		t.Errorf("expected a single goroutine discovering the partitions, got %d", goroutines)
+	}
+
+	expected := []string{
+		"/v1/catalog/nodes?partition=team-a&ns=",
+		"/v1/namespaces?partition=team-a&ns=",
+		"/v1/catalog/services?partition=team-a&ns=web",
+		"/v1/catalog/services?partition=default&ns=web",
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		done := true
+		for _, request := range expected {
+			if !queried[request] {
+				done = false
+			}
+		}
+		lock.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopCh <- struct{}{}
+	stopCh <- struct{}{}
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, request := range expected {
+		if !queried[request] {
+			t.Errorf("expected a request for %s, got %v", request, queried)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+)
+
+// An external handler, declared with a plugin block and used by plugin handlers. Its
+// command is run for each alert like the exec handler's, with the alert and the options
+// of the handler that sent it as JSON on stdin.
+type PluginConfig struct {
+	Name          string   `json:"name"`
+	Command       []string `mapstructure:"command" json:"command"`
+	Timeout       int      `mapstructure:"timeout" json:"timeout"`
+	MaxConcurrent int      `mapstructure:"max_concurrent" json:"max_concurrent"`
+	MaxRetries    int      `mapstructure:"max_retries" json:"max_retries"`
+
+	// Runs the command, shared by the plugin's handlers so max_concurrent covers all of them
+	exec *ExecHandler
+}
+
+// Parse the raw plugin objects into the config
+func parsePlugins(list *ast.ObjectList, config *Config) error {
+	config.Plugins = make(map[string]PluginConfig)
+
+	for _, p := range list.Items {
+		if len(p.Keys) != 1 {
+			return fmt.Errorf("plugin must be in the form 'plugin \"name\" {}'")
+		}
+		name := p.Keys[0].Token.Value().(string)
+
+		m := map[string]interface{}{
+			"timeout":        30,
+			"max_concurrent": 4,
+		}
+		var plugin PluginConfig
+		if err := hcl.DecodeObject(&m, p.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &plugin); err != nil {
+			return err
+		}
+		plugin.Name = name
+
+		plugin.exec = &ExecHandler{
+			Command:       plugin.Command,
+			Timeout:       plugin.Timeout,
+			MaxConcurrent: plugin.MaxConcurrent,
+			MaxRetries:    plugin.MaxRetries,
+		}
+		if err := plugin.exec.validate(); err != nil {
+			return fmt.Errorf("Error loading plugin %s: %s", name, err)
+		}
+		config.Plugins[name] = plugin
+	}
+
+	return nil
+}
+
+// PluginHandler sends alerts through a plugin, passing its other options along to it
+type PluginHandler struct {
+	Plugin  string                 `mapstructure:"plugin"`
+	Options map[string]interface{} `mapstructure:"-"`
+
+	id   string
+	exec *ExecHandler
+}
+
+// The JSON written to a plugin's stdin for each alert
+type pluginRequest struct {
+	Handler    string                 `json:"handler"`
+	Options    map[string]interface{} `json:"options"`
+	Datacenter string                 `json:"datacenter"`
+	Alert      *AlertState            `json:"alert"`
+}
+
+// Creates a plugin handler from the options of its handler block, which are passed to the
+// plugin except for the plugin name and the options every handler has
+func newPluginHandler(id string, m map[string]interface{}, config *Config) (PluginHandler, error) {
+	var handler PluginHandler
+	if err := mapstructure.WeakDecode(m, &handler); err != nil {
+		return handler, err
+	}
+	if handler.Plugin == "" {
+		return handler, fmt.Errorf("Error loading handler %s: plugin must be set", id)
+	}
+	plugin, ok := config.Plugins[handler.Plugin]
+	if !ok {
+		return handler, fmt.Errorf("Error loading handler %s: unknown plugin %s", id, handler.Plugin)
+	}
+
+	handler.id = id
+	handler.exec = plugin.exec
+	handler.Options = make(map[string]interface{})
+	for key, value := range m {
+		if key != "plugin" && !isHandlerOption(key) {
+			handler.Options[key] = value
+		}
+	}
+	return handler, nil
+}
+
+// Returns whether a key is one of the HandlerOptions every handler block accepts
+func isHandlerOption(key string) bool {
+	options := reflect.TypeOf(HandlerOptions{})
+	for i := 0; i < options.NumField(); i++ {
+		if options.Field(i).Tag.Get("mapstructure") == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (handler PluginHandler) Alert(datacenter string, alert *AlertState) error {
+	payload, err := json.Marshal(pluginRequest{handler.id, handler.Options, datacenter, alert})
+	if err != nil {
+		log.Errorf("Error encoding alert for plugin %s: %s", handler.Plugin, err)
+		return err
+	}
+
+	return handler.exec.runAlert(alert, "plugin "+handler.Plugin, execEnvironment(datacenter, alert), payload)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPlugin_handler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "output")
+
+	config, err := ParseConfig(`
+	plugin "notify" {
+		command = ["sh", "-c", "cat > ` + output + `"]
+		max_concurrent = 1
+	}
+	handler "plugin" "ops" {
+		plugin = "notify"
+		team = "ops"
+		urgent = true
+		repeat_interval = 600
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.HandlerOptions["plugin.ops"].RepeatInterval != 600 {
+		t.Errorf("expected the handler options to apply to plugin handlers")
+	}
+
+	config.Handlers["plugin.ops"].Alert("dc1", &AlertState{Service: "redis", Status: "critical"})
+
+	stdin, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request pluginRequest
+	if err := json.Unmarshal(stdin, &request); err != nil {
+		t.Fatalf("expected the request as JSON on stdin, got %s (%v)", stdin, err)
+	}
+	if request.Handler != "plugin.ops" || request.Datacenter != "dc1" || request.Alert.Service != "redis" {
+		t.Errorf("unexpected request: %+v", request)
+	}
+	expected := map[string]interface{}{"team": "ops", "urgent": true}
+	if !reflect.DeepEqual(request.Options, expected) {
+		t.Errorf("expected options %v, got %v", expected, request.Options)
+	}
+}
+
+func TestPlugin_unknown(t *testing.T) {
+	if _, err := ParseConfig(`handler "plugin" "ops" { plugin = "missing" }`); err == nil {
+		t.Error("expected an error for an unknown plugin")
+	}
+	if _, err := ParseConfig(`plugin "notify" {}`); err == nil {
+		t.Error("expected an error for a plugin without a command")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The name of the pseudo-service used for alerting on the results of prepared queries.
+// A service block with this name can be used to configure its handlers and thresholds.
+const preparedQueryService = "consul-prepared-queries"
+
+// The result of executing a prepared query, or the error if it doesn't exist
+type preparedQueryResult struct {
+	query    string
+	response *api.PreparedQueryExecuteResponse
+	err      error
+}
+
+// Executes each of the prepared_queries. A query that doesn't exist is reported in its
+// result rather than failing the others, any other error is returned.
+func executePreparedQueries(config *Config, client *api.Client) ([]preparedQueryResult, error) {
+	results := make([]preparedQueryResult, 0, len(config.PreparedQueries))
+	for _, query := range config.PreparedQueries {
+		response, _, err := client.PreparedQuery().Execute(query, config.queryOptions())
+		if err != nil && !strings.Contains(err.Error(), "404") {
+			return nil, fmt.Errorf("Error executing prepared query %s: %s", query, err)
+		}
+		results = append(results, preparedQueryResult{query: query, response: response, err: err})
+	}
+	return results, nil
+}
+
+// Converts the prepared query results into health checks, so they can be watched the same
+// way as a regular service. Each query gets a "query" check on a node named after it,
+// which is critical when the query returns no healthy instances or doesn't exist, and has
+// the prepared_query_failover_status when the instances came from another datacenter.
+func preparedQueryChecks(config *Config, results []preparedQueryResult) []*api.HealthCheck {
+	checks := make([]*api.HealthCheck, 0, len(results))
+	for _, result := range results {
+		status := api.HealthPassing
+		var output string
+		switch {
+		case result.err != nil || result.response == nil:
+			status = api.HealthCritical
+			output = fmt.Sprintf("Prepared query %s was not found", result.query)
+		case len(result.response.Nodes) == 0:
+			status = api.HealthCritical
+			output = fmt.Sprintf("Prepared query %s returned no healthy instances of %s", result.query, result.response.Service)
+		case result.response.Failovers > 0:
+			status = config.PreparedQueryFailoverStatus
+			output = fmt.Sprintf("Prepared query %s failed over to %s, returning %d healthy instances of %s", result.query, result.response.Datacenter, len(result.response.Nodes), result.response.Service)
+		default:
+			output = fmt.Sprintf("Prepared query %s returned %d healthy instances of %s in %s", result.query, len(result.response.Nodes), result.response.Service, result.response.Datacenter)
+		}
+
+		checks = append(checks, &api.HealthCheck{
+			Node:        result.query,
+			CheckID:     "query",
+			Name:        "query",
+			Status:      status,
+			Output:      output,
+			ServiceID:   preparedQueryService,
+			ServiceName: preparedQueryService,
+		})
+	}
+	return checks
+}
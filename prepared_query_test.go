@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestPreparedQuery_checks(t *testing.T) {
+	config, err := ParseConfig(`prepared_queries = ["redis", "web", "db", "missing"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := []preparedQueryResult{
+		{query: "redis", response: &api.PreparedQueryExecuteResponse{Service: "redis", Datacenter: "dc1", Nodes: make([]api.ServiceEntry, 2)}},
+		{query: "web", response: &api.PreparedQueryExecuteResponse{Service: "web", Datacenter: "dc2", Nodes: make([]api.ServiceEntry, 1), Failovers: 1}},
+		{query: "db", response: &api.PreparedQueryExecuteResponse{Service: "db", Datacenter: "dc1"}},
+		{query: "missing", err: errors.New("Unexpected response code: 404 (Query not found)")},
+	}
+
+	expected := map[string]string{
+		"redis":   api.HealthPassing,
+		"web":     api.HealthWarning,
+		"db":      api.HealthCritical,
+		"missing": api.HealthCritical,
+	}
+	checks := preparedQueryChecks(config, results)
+	if len(checks) != len(expected) {
+		t.Fatalf("expected %d checks, got %d", len(expected), len(checks))
+	}
+	for _, check := range checks {
+		if check.ServiceName != preparedQueryService || check.CheckID != "query" {
+			t.Errorf("expected a query check of %s, got %+v", preparedQueryService, check)
+		}
+		if check.Status != expected[check.Node] {
+			t.Errorf("expected %s to be %s, got %s", check.Node, expected[check.Node], check.Status)
+		}
+		if check.Node == "web" && check.Output != "Prepared query web failed over to dc2, returning 1 healthy instances of web" {
+			t.Errorf("unexpected output: %s", check.Output)
+		}
+	}
+
+	if _, err := ParseConfig(`prepared_query_failover_status = "down"`); err == nil || !strings.Contains(err.Error(), "prepared_query_failover_status") {
+		t.Errorf("expected an error for an invalid prepared_query_failover_status, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// A minimal encoder and decoder for the protobuf wire format, covering the scalar, message
+// and well-known timestamp and duration fields used by the gRPC API in alerting.proto.
+
+// The protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+var errInvalidProto = errors.New("invalid protobuf message")
+
+// Encodes a message field by field. Like proto3, fields with their zero value are omitted.
+type protoEncoder struct {
+	buf []byte
+}
+
+func (e *protoEncoder) tag(field int, wireType int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field<<3|wireType))
+}
+
+func (e *protoEncoder) string(field int, value string) {
+	if value == "" {
+		return
+	}
+	e.tag(field, protoBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(value)))
+	e.buf = append(e.buf, value...)
+}
+
+func (e *protoEncoder) uint64(field int, value uint64) {
+	if value == 0 {
+		return
+	}
+	e.tag(field, protoVarint)
+	e.buf = binary.AppendUvarint(e.buf, value)
+}
+
+func (e *protoEncoder) int64(field int, value int64) {
+	e.uint64(field, uint64(value))
+}
+
+func (e *protoEncoder) bool(field int, value bool) {
+	if value {
+		e.uint64(field, 1)
+	}
+}
+
+func (e *protoEncoder) double(field int, value float64) {
+	if value == 0 {
+		return
+	}
+	e.tag(field, protoFixed64)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(value))
+}
+
+// Encodes an embedded message, which unlike scalars is written even when it's empty
+func (e *protoEncoder) message(field int, message *protoEncoder) {
+	e.tag(field, protoBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(message.buf)))
+	e.buf = append(e.buf, message.buf...)
+}
+
+// Encodes a google.protobuf.Timestamp, omitting it for the zero time
+func (e *protoEncoder) timestamp(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	var ts protoEncoder
+	ts.int64(1, t.Unix())
+	ts.int64(2, int64(t.Nanosecond()))
+	e.message(field, &ts)
+}
+
+// A decoded field. Varint and fixed width values are kept in value, length-delimited ones
+// (strings and messages) in bytes.
+type protoField struct {
+	number   int
+	wireType int
+	value    uint64
+	bytes    []byte
+}
+
+// Decodes the fields of a message, in the order they appear
+func decodeProto(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errInvalidProto
+		}
+		b = b[n:]
+
+		field := protoField{number: int(key >> 3), wireType: int(key & 7)}
+		switch field.wireType {
+		case protoVarint:
+			field.value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errInvalidProto
+			}
+			b = b[n:]
+		case protoFixed64:
+			if len(b) < 8 {
+				return nil, errInvalidProto
+			}
+			field.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case protoFixed32:
+			if len(b) < 4 {
+				return nil, errInvalidProto
+			}
+			field.value = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case protoBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return nil, errInvalidProto
+			}
+			field.bytes = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			return nil, errInvalidProto
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Returns the last value of a string field, as proto3 does for repeated scalars, or ""
+func protoString(fields []protoField, number int) string {
+	value := ""
+	for _, field := range fields {
+		if field.number == number && field.wireType == protoBytes {
+			value = string(field.bytes)
+		}
+	}
+	return value
+}
+
+// Returns the value of a google.protobuf.Duration field, or 0 if it isn't set
+func protoDuration(fields []protoField, number int) (time.Duration, error) {
+	var d time.Duration
+	for _, field := range fields {
+		if field.number != number || field.wireType != protoBytes {
+			continue
+		}
+		parts, err := decodeProto(field.bytes)
+		if err != nil {
+			return 0, err
+		}
+		d = 0
+		for _, part := range parts {
+			switch {
+			case part.number == 1 && part.wireType == protoVarint:
+				d += time.Duration(int64(part.value)) * time.Second
+			case part.number == 2 && part.wireType == protoVarint:
+				d += time.Duration(int32(part.value))
+			}
+		}
+	}
+	return d, nil
+}
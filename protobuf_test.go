@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProtobuf_roundTrip(t *testing.T) {
+	var duration protoEncoder
+	duration.int64(1, 90)
+	duration.int64(2, 500)
+
+	var m protoEncoder
+	m.string(1, "redis")
+	m.message(2, &duration)
+	m.bool(3, true)
+	m.double(4, 1.5)
+	m.string(5, "")
+
+	fields, err := decodeProto(m.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 4 {
+		t.Fatalf("expected 4 fields without the empty string, got %d", len(fields))
+	}
+	if s := protoString(fields, 1); s != "redis" {
+		t.Errorf("expected redis, got %q", s)
+	}
+	d, err := protoDuration(fields, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 90*time.Second+500 {
+		t.Errorf("expected 90.0000005s, got %s", d)
+	}
+	if fields[2].value != 1 {
+		t.Errorf("expected true, got %d", fields[2].value)
+	}
+
+	if _, err := decodeProto([]byte{0x0a, 0x05, 'a'}); err == nil {
+		t.Error("expected an error for a truncated field")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var queuedAlerts = metrics.register("consul_alerting_queued_alerts",
+	"Alerts waiting in the persistent queue for their handler to recover.", "gauge")
+
+// An alert kept in the queue directory until its handler delivers it
+type queuedAlert struct {
+	Handler    string     `json:"handler"`
+	Datacenter string     `json:"datacenter"`
+	Alert      AlertState `json:"alert"`
+	Queued     time.Time  `json:"queued"`
+
+	file string
+}
+
+// Identifies the incident and check an alert is about, or "" for alerts that aren't about
+// one (like batch digests), which never replace each other in the queue
+func (q *queuedAlert) key() string {
+	if q.Alert.Service == "" && q.Alert.Node == "" {
+		return ""
+	}
+	return q.Handler + "/" + alertIncidentKey(q.Datacenter, &q.Alert) + "-" + q.Alert.Check
+}
+
+// A persistent queue of outbound alerts. Every alert is written to the queue before it's
+// sent and removed once its handler delivers it, so alerts that failed (or were in flight
+// when consul-alerting stopped) are redelivered later. A newer alert about the same
+// incident replaces the queued one, since only its latest status matters.
+type outboundQueue struct {
+	sync.Mutex
+	dir     string
+	pending map[string]*queuedAlert
+
+	// The handlers that have had alerts queued, so their gauge drops back to 0
+	handlers map[string]bool
+}
+
+var alertQueue = struct {
+	sync.Mutex
+	queue *outboundQueue
+}{}
+
+var queueFileSeq uint64
+
+// Opens the queue in a directory, loading the alerts left in it
+func openQueue(dir string) (*outboundQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Error creating queue directory: %s", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	q := &outboundQueue{dir: dir, pending: make(map[string]*queuedAlert), handlers: make(map[string]bool)}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading queued alert: %s", err)
+		}
+		entry := &queuedAlert{}
+		if err := json.Unmarshal(contents, entry); err != nil {
+			log.Errorf("Removing invalid queued alert %s: %s", file, err)
+			os.Remove(file)
+			continue
+		}
+		entry.file = filepath.Base(file)
+		q.pending[entry.file] = entry
+	}
+	q.updateMetrics()
+	return q, nil
+}
+
+// Starts the persistent queue in the configured directory, redelivering its alerts every
+// queue_retry_interval to the handlers of the latest config
+func startQueue(config *Config) error {
+	q, err := openQueue(config.QueueDir)
+	if err != nil {
+		return err
+	}
+	if len(q.pending) > 0 {
+		log.Infof("Loaded %d undelivered alerts from the queue", len(q.pending))
+	}
+
+	alertQueue.Lock()
+	alertQueue.queue = q
+	alertQueue.Unlock()
+
+	go func() {
+		for {
+			q.redeliver(latestConfig(config))
+			time.Sleep(time.Duration(config.QueueRetryInterval) * time.Second)
+		}
+	}()
+	return nil
+}
+
+func currentQueue() *outboundQueue {
+	alertQueue.Lock()
+	defer alertQueue.Unlock()
+	return alertQueue.queue
+}
+
+// Writes an alert to the queue, replacing any queued alert about the same incident for the
+// handler. Returns the queued entry, which is still returned (but only kept in memory) if
+// writing it failed.
+func (q *outboundQueue) add(handler string, datacenter string, alert *AlertState) *queuedAlert {
+	entry := &queuedAlert{Handler: handler, Datacenter: datacenter, Alert: *alert, Queued: time.Now().UTC()}
+	entry.file = fmt.Sprintf("%020d-%06d.json", entry.Queued.UnixNano(), atomic.AddUint64(&queueFileSeq, 1)%1000000)
+
+	if err := q.write(entry); err != nil {
+		log.Errorf("Error writing alert '%s' to the queue: %s", alert.Message, err)
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	if key := entry.key(); key != "" {
+		for file, queued := range q.pending {
+			if queued.key() == key {
+				delete(q.pending, file)
+				os.Remove(filepath.Join(q.dir, file))
+			}
+		}
+	}
+	q.pending[entry.file] = entry
+	q.updateMetrics()
+	return entry
+}
+
+// Writes an entry to its file, through a temporary file so a crash can't leave half of it
+func (q *outboundQueue) write(entry *queuedAlert) error {
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(q.dir, entry.file)
+	if err := ioutil.WriteFile(path+".tmp", contents, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Removes a delivered alert from the queue, unless a newer alert already replaced it
+func (q *outboundQueue) remove(entry *queuedAlert) {
+	q.Lock()
+	defer q.Unlock()
+	if q.pending[entry.file] != entry {
+		return
+	}
+	delete(q.pending, entry.file)
+	if err := os.Remove(filepath.Join(q.dir, entry.file)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error removing delivered alert from the queue: %s", err)
+	}
+	q.updateMetrics()
+}
+
+// Returns the queued alerts, oldest first
+func (q *outboundQueue) entries() []*queuedAlert {
+	q.Lock()
+	defer q.Unlock()
+	entries := make([]*queuedAlert, 0, len(q.pending))
+	for _, entry := range q.pending {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].file < entries[j].file
+	})
+	return entries
+}
+
+// Tries to deliver the queued alerts in order. Once an alert to a handler fails the rest
+// of its alerts wait for the next attempt, so a handler that's still down isn't retried
+// for each of them. Alerts for handlers that are no longer configured are dropped.
+func (q *outboundQueue) redeliver(config *Config) {
+	failed := make(map[string]bool)
+	for _, entry := range q.entries() {
+		if failed[entry.Handler] {
+			continue
+		}
+		if _, ok := config.Handlers[entry.Handler]; !ok {
+			log.Warnf("Dropping queued alert '%s' for handler %s, which is no longer configured", entry.Alert.Message, entry.Handler)
+			q.remove(entry)
+			continue
+		}
+
+		q.Lock()
+		current := q.pending[entry.file] == entry
+		q.Unlock()
+		if !current {
+			continue
+		}
+
+		alert := entry.Alert
+		if err := config.namedHandler(entry.Handler).call(entry.Datacenter, &alert); err != nil {
+			failed[entry.Handler] = true
+			continue
+		}
+		log.Infof("Delivered queued alert '%s' to handler %s (queued at %s)", alert.Message, entry.Handler, entry.Queued.Format(time.RFC3339))
+		q.remove(entry)
+	}
+}
+
+// Sets the number of queued alerts per handler. Called with the lock held.
+func (q *outboundQueue) updateMetrics() {
+	counts := make(map[string]int)
+	for _, entry := range q.pending {
+		counts[entry.Handler]++
+	}
+	for handler := range q.handlers {
+		if _, ok := counts[handler]; !ok {
+			queuedAlerts.set(0, "handler", handler)
+		}
+	}
+	for handler, count := range counts {
+		queuedAlerts.set(float64(count), "handler", handler)
+		q.handlers[handler] = true
+	}
+}
+
+// Returns the number of alerts queued for a handler
+func (q *outboundQueue) count(handler string) int {
+	q.Lock()
+	defer q.Unlock()
+
+	n := 0
+	for _, entry := range q.pending {
+		if entry.Handler == handler {
+			n++
+		}
+	}
+	return n
+}
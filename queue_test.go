@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A handler that fails while down is set, and otherwise sends alerts to a channel
+type flakyHandler struct {
+	down   *int32
+	alerts chan *AlertState
+}
+
+func (h flakyHandler) Alert(datacenter string, alert *AlertState) error {
+	if atomic.LoadInt32(h.down) == 1 {
+		return errors.New("endpoint unreachable")
+	}
+	h.alerts <- alert
+	return nil
+}
+
+func testQueue(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "consul-alerting-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() {
+		alertQueue.Lock()
+		alertQueue.queue = nil
+		alertQueue.Unlock()
+		os.RemoveAll(dir)
+	}
+}
+
+func queueFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestQueue_redeliverAfterRestart(t *testing.T) {
+	dir, cleanup := testQueue(t)
+	defer cleanup()
+
+	q, err := openQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alertQueue.queue = q
+
+	down := int32(1)
+	alertCh := make(chan *AlertState, 10)
+	config := &Config{Handlers: map[string]AlertHandler{"test": flakyHandler{&down, alertCh}}}
+
+	alert := &AlertState{Service: "redis", Status: "critical", Message: "redis is critical"}
+	if err := config.namedHandler("test").deliver("dc1", alert); err == nil {
+		t.Fatal("expected the delivery to fail")
+	}
+	if files := queueFiles(t, dir); len(files) != 1 {
+		t.Fatalf("expected the failed alert to be queued, got %v", files)
+	}
+
+	// Reopening the queue picks up the alert, and delivers it once the handler is back
+	q, err = openQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.redeliver(config)
+	if len(alertCh) != 0 {
+		t.Fatal("expected no delivery while the handler is down")
+	}
+
+	atomic.StoreInt32(&down, 0)
+	q.redeliver(config)
+	if len(alertCh) != 1 {
+		t.Fatalf("expected the queued alert to be delivered, got %d", len(alertCh))
+	}
+	if received := <-alertCh; received.Message != "redis is critical" {
+		t.Errorf("unexpected alert: %+v", received)
+	}
+	if files := queueFiles(t, dir); len(files) != 0 {
+		t.Errorf("expected the delivered alert to be removed, got %v", files)
+	}
+}
+
+func TestQueue_replacesIncident(t *testing.T) {
+	dir, cleanup := testQueue(t)
+	defer cleanup()
+
+	q, err := openQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alertQueue.queue = q
+
+	down := int32(1)
+	alertCh := make(chan *AlertState, 10)
+	config := &Config{Handlers: map[string]AlertHandler{"test": flakyHandler{&down, alertCh}}}
+	handler := config.namedHandler("test")
+
+	handler.deliver("dc1", &AlertState{Service: "redis", Status: "critical", Message: "redis is critical"})
+	handler.deliver("dc1", &AlertState{Service: "redis", Status: "passing", Message: "redis is passing"})
+	handler.deliver("dc1", &AlertState{Message: "digest 1"})
+	handler.deliver("dc1", &AlertState{Message: "digest 2"})
+
+	atomic.StoreInt32(&down, 0)
+	q.redeliver(config)
+
+	var messages []string
+	for len(alertCh) > 0 {
+		messages = append(messages, (<-alertCh).Message)
+	}
+	expected := []string{"redis is passing", "digest 1", "digest 2"}
+	if len(messages) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, messages)
+	}
+	for i := range expected {
+		if messages[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, messages)
+		}
+	}
+}
+
+func TestQueue_removedHandler(t *testing.T) {
+	dir, cleanup := testQueue(t)
+	defer cleanup()
+
+	q, err := openQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.add("old", "dc1", &AlertState{Service: "redis", Status: "critical"})
+
+	q.redeliver(&Config{Handlers: map[string]AlertHandler{}})
+	if files := queueFiles(t, dir); len(files) != 0 {
+		t.Errorf("expected alerts for a removed handler to be dropped, got %v", files)
+	}
+}
+
+func TestQueue_redeliverAfterReload(t *testing.T) {
+	dir, cleanup := testQueue(t)
+	defer cleanup()
+
+	q, err := openQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.add("added", "dc1", &AlertState{Service: "redis", Status: "critical", Message: "redis is critical"})
+
+	// The handler was only added by a reload, after the queue was started
+	down := int32(0)
+	alertCh := make(chan *AlertState, 1)
+	setReloadedConfig(&Config{Handlers: map[string]AlertHandler{"added": flakyHandler{&down, alertCh}}})
+	defer setReloadedConfig(nil)
+
+	startup := &Config{QueueDir: dir, QueueRetryInterval: 3600, Handlers: map[string]AlertHandler{}}
+	if err := startQueue(startup); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case alert := <-alertCh:
+		if alert.Message != "redis is critical" {
+			t.Errorf("unexpected alert: %+v", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the queued alert to be redelivered to the reloaded handler")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// What happens to alerts sent to a handler over its rate limit: dropped, with a summary of
+// them sent once the handler is allowed to send again, or queued and sent in order then
+const RateLimitDrop = "drop"
+const RateLimitQueue = "queue"
+
+// The window rate limits are counted over
+const rateLimitWindow = time.Minute
+
+// The most dropped alerts listed in a summary
+const rateLimitSummaryLines = 20
+
+type rateLimitedAlert struct {
+	datacenter string
+	alert      *AlertState
+}
+
+// The alerts recently sent to a handler, and the ones held back by its rate limit
+type rateLimiter struct {
+	handler namedHandler
+	sent    []time.Time
+	held    []rateLimitedAlert
+	timer   *time.Timer
+}
+
+// Tracks the handlers with a rate_limit, keyed by handler name
+type rateLimits struct {
+	sync.Mutex
+	limiters map[string]*rateLimiter
+}
+
+var handlerRateLimits = &rateLimits{
+	limiters: make(map[string]*rateLimiter),
+}
+
+// Returns whether an alert can be sent to the handler now. Otherwise it's held back to be
+// dropped or queued, and a flush is scheduled for when the handler can send again.
+func (r *rateLimits) allow(handler namedHandler, datacenter string, alert *AlertState, now time.Time) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	limiter, ok := r.limiters[handler.name]
+	if !ok {
+		limiter = &rateLimiter{}
+		r.limiters[handler.name] = limiter
+	}
+	limiter.handler = handler
+	limiter.expire(now)
+
+	if len(limiter.held) == 0 && len(limiter.sent) < handler.options.RateLimit {
+		limiter.sent = append(limiter.sent, now)
+		return true
+	}
+
+	held := *alert
+	limiter.held = append(limiter.held, rateLimitedAlert{datacenter, &held})
+	if len(limiter.held) == 1 {
+		log.Warnf("Handler %s reached its rate limit of %d alerts per minute", handler.name, handler.options.RateLimit)
+	}
+	if limiter.timer == nil {
+		limiter.timer = time.AfterFunc(limiter.nextSlot(now), func() {
+			r.flush(handler.name)
+		})
+	}
+	return false
+}
+
+// Returns how long until the handler can send again
+func (l *rateLimiter) nextSlot(now time.Time) time.Duration {
+	if len(l.sent) == 0 {
+		return 0
+	}
+	return l.sent[0].Add(rateLimitWindow).Sub(now)
+}
+
+// Forgets the sends that have left the window
+func (l *rateLimiter) expire(now time.Time) {
+	for len(l.sent) > 0 && now.Sub(l.sent[0]) >= rateLimitWindow {
+		l.sent = l.sent[1:]
+	}
+}
+
+// Sends what a handler held back as far as its rate limit allows: the queued alerts in
+// order, or a summary of the dropped ones. Schedules another flush if alerts are left.
+func (r *rateLimits) flush(name string) {
+	r.Lock()
+	limiter, ok := r.limiters[name]
+	if !ok {
+		r.Unlock()
+		return
+	}
+	limiter.timer = nil
+	now := time.Now()
+	limiter.expire(now)
+
+	var send []rateLimitedAlert
+	if limiter.handler.options.RateLimitOverflow == RateLimitQueue {
+		n := limiter.handler.options.RateLimit - len(limiter.sent)
+		if n > len(limiter.held) {
+			n = len(limiter.held)
+		}
+		send, limiter.held = limiter.held[:n], limiter.held[n:]
+	} else if len(limiter.held) > 0 {
+		send = []rateLimitedAlert{summarizeDropped(limiter.handler.options.RateLimit, limiter.held)}
+		limiter.held = nil
+	}
+
+	for range send {
+		limiter.sent = append(limiter.sent, now)
+	}
+	if len(limiter.held) > 0 {
+		limiter.timer = time.AfterFunc(limiter.nextSlot(now), func() {
+			r.flush(name)
+		})
+	}
+	handler := limiter.handler
+	r.Unlock()
+
+	for _, held := range send {
+		handler.deliver(held.datacenter, held.alert)
+	}
+}
+
+// Summarizes the alerts dropped by a rate limit as a single alert with their worst status
+func summarizeDropped(limit int, dropped []rateLimitedAlert) rateLimitedAlert {
+	statuses := make(map[string]string)
+	lines := make([]string, 0, rateLimitSummaryLines)
+	for i, held := range dropped {
+		statuses[fmt.Sprint(i)] = held.alert.Status
+		if i < rateLimitSummaryLines {
+			lines = append(lines, "=> "+held.alert.Message)
+		}
+	}
+	if len(dropped) > rateLimitSummaryLines {
+		lines = append(lines, fmt.Sprintf("(and %d more)", len(dropped)-rateLimitSummaryLines))
+	}
+
+	summary := &AlertState{
+		Status:  computeHealth(statuses),
+		Message: fmt.Sprintf("Rate limit of %d alerts per minute reached, dropped %d alerts", limit, len(dropped)),
+		Details: "Dropped alerts:\n" + strings.Join(lines, "\n"),
+	}
+	return rateLimitedAlert{dropped[0].datacenter, summary}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Lets the handler's rate limit window pass and flushes what it held back
+func testRateLimitFlush(limits *rateLimits, name string) {
+	limits.Lock()
+	limiter := limits.limiters[name]
+	limiter.timer.Stop()
+	for i := range limiter.sent {
+		limiter.sent[i] = limiter.sent[i].Add(-rateLimitWindow)
+	}
+	limits.Unlock()
+
+	limits.flush(name)
+}
+
+func TestRateLimit_queue(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	limits := &rateLimits{limiters: make(map[string]*rateLimiter)}
+	handler := namedHandler{name: "test", handler: testHandler{alertCh}, options: HandlerOptions{RateLimit: 2, RateLimitOverflow: RateLimitQueue}}
+
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if limits.allow(handler, "dc1", &AlertState{Message: fmt.Sprintf("alert %d", i)}, time.Now()) {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Fatalf("expected 2 alerts to be allowed, got %d", allowed)
+	}
+
+	testRateLimitFlush(limits, "test")
+	if len(alertCh) != 2 || (<-alertCh).Message != "alert 2" || (<-alertCh).Message != "alert 3" {
+		t.Errorf("expected the next 2 queued alerts in order")
+	}
+	if limits.limiters["test"].timer == nil {
+		t.Error("expected another flush to be scheduled for the last queued alert")
+	}
+
+	testRateLimitFlush(limits, "test")
+	if len(alertCh) != 1 || (<-alertCh).Message != "alert 4" {
+		t.Error("expected the last queued alert")
+	}
+}
+
+func TestRateLimit_drop(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	limits := &rateLimits{limiters: make(map[string]*rateLimiter)}
+	handler := namedHandler{name: "test", handler: testHandler{alertCh}, options: HandlerOptions{RateLimit: 1}}
+
+	limits.allow(handler, "dc1", &AlertState{Status: "critical", Message: "redis is critical"}, time.Now())
+	limits.allow(handler, "dc1", &AlertState{Status: "warning", Message: "web is warning"}, time.Now())
+	limits.allow(handler, "dc1", &AlertState{Status: "critical", Message: "api is critical"}, time.Now())
+
+	testRateLimitFlush(limits, "test")
+	if len(alertCh) != 1 {
+		t.Fatalf("expected a single summary, got %d alerts", len(alertCh))
+	}
+	summary := <-alertCh
+	if summary.Status != "critical" || summary.Message != "Rate limit of 1 alerts per minute reached, dropped 2 alerts" {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if summary.Details != "Dropped alerts:\n=> web is warning\n=> api is critical" {
+		t.Errorf("unexpected summary details: %s", summary.Details)
+	}
+	if limits.limiters["test"].timer != nil {
+		t.Error("expected no further flush after the summary")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Tracks whether the daemon is ready: it's connected to Consul and has set up its watches.
+// Each component is pending until it first reports in, then ready unless it reported an
+// error.
+type readiness struct {
+	sync.Mutex
+	components map[string]error
+	reported   map[string]bool
+}
+
+var daemonReadiness = &readiness{
+	components: make(map[string]error),
+	reported:   make(map[string]bool),
+}
+
+// Adds components that must report in before the daemon is ready
+func (r *readiness) expect(components ...string) {
+	r.Lock()
+	defer r.Unlock()
+	for _, component := range components {
+		if _, ok := r.components[component]; !ok {
+			r.components[component] = nil
+		}
+	}
+}
+
+// Records the state of a component, nil meaning it's ready
+func (r *readiness) update(component string, err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.components[component] = err
+	r.reported[component] = true
+}
+
+// Returns whether every component is ready, and the state of each
+func (r *readiness) status() (bool, map[string]string) {
+	r.Lock()
+	defer r.Unlock()
+
+	ready := true
+	states := make(map[string]string)
+	for component, err := range r.components {
+		switch {
+		case !r.reported[component]:
+			states[component] = "pending"
+			ready = false
+		case err != nil:
+			states[component] = err.Error()
+			ready = false
+		default:
+			states[component] = "ok"
+		}
+	}
+	return ready, states
+}
+
+// Serves the liveness endpoint, which only shows the daemon is running
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "ok")
+}
+
+// Serves the readiness endpoint, returning 503 until every component is ready
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ready, states := daemonReadiness.status()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	response := map[string]interface{}{
+		"ready":      ready,
+		"components": states,
+	}
+	if role := activeInstance.role(); role != "" {
+		response["role"] = role
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadiness_components(t *testing.T) {
+	r := &readiness{components: make(map[string]error), reported: make(map[string]bool)}
+	r.expect("consul", "services")
+	r.update("consul", nil)
+
+	if ready, states := r.status(); ready || states["services"] != "pending" {
+		t.Errorf("expected to wait for services, got %v %v", ready, states)
+	}
+
+	r.update("services", nil)
+	if ready, _ := r.status(); !ready {
+		t.Error("expected to be ready")
+	}
+
+	r.update("consul", errors.New("connection refused"))
+	if ready, states := r.status(); ready || states["consul"] != "connection refused" {
+		t.Errorf("expected a Consul error to make it unready, got %v %v", ready, states)
+	}
+}
+
+func TestReadiness_endpoints(t *testing.T) {
+	w := httptest.NewRecorder()
+	healthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+
+	daemonReadiness.expect("readiness-test")
+	w = httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	var body struct {
+		Ready      bool              `json:"ready"`
+		Components map[string]string `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable || body.Ready || body.Components["readiness-test"] != "pending" {
+		t.Errorf("expected a pending component to be unready, got %d %+v", w.Code, body)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The KV prefix the state of alerts posted to the receiver is kept under
+const receivedKVPath = alertingKVRoot + "/received/"
+
+// An alert posted to /v1/receive by an external system
+type receivedAlert struct {
+	Source  string   `json:"source"`
+	Service string   `json:"service"`
+	Node    string   `json:"node"`
+	Check   string   `json:"check"`
+	Tags    []string `json:"tags"`
+	Status  string   `json:"status"`
+	Message string   `json:"message"`
+	Details string   `json:"details"`
+}
+
+func (received *receivedAlert) validate() error {
+	if received.Service == "" && received.Node == "" {
+		return fmt.Errorf("service or node must be set")
+	}
+	switch received.Status {
+	case api.HealthPassing, api.HealthWarning, api.HealthCritical:
+	default:
+		return fmt.Errorf("status must be passing, warning or critical, not %q", received.Status)
+	}
+	return nil
+}
+
+// The parts of an Alertmanager webhook that are used
+type alertmanagerWebhook struct {
+	Alerts []struct {
+		Status       string            `json:"status"`
+		Labels       map[string]string `json:"labels"`
+		Annotations  map[string]string `json:"annotations"`
+		GeneratorURL string            `json:"generatorURL"`
+	} `json:"alerts"`
+}
+
+// Converts the alerts of an Alertmanager webhook. The service is the service or job label,
+// the node the node or instance label and the check the alert name; firing alerts are
+// warnings if their severity label says so and critical otherwise.
+func (webhook *alertmanagerWebhook) receivedAlerts() []*receivedAlert {
+	alerts := make([]*receivedAlert, 0, len(webhook.Alerts))
+	for _, a := range webhook.Alerts {
+		received := &receivedAlert{
+			Source:  "alertmanager",
+			Service: firstLabel(a.Labels, "service", "job"),
+			Node:    firstLabel(a.Labels, "node", "instance"),
+			Check:   a.Labels["alertname"],
+			Status:  api.HealthCritical,
+			Message: firstLabel(a.Annotations, "summary", "message"),
+		}
+		if a.Status == "resolved" {
+			received.Status = api.HealthPassing
+		} else if severity := strings.ToLower(a.Labels["severity"]); severity == "warning" || severity == "info" {
+			received.Status = api.HealthWarning
+		}
+		if received.Service == "" && received.Node == "" {
+			received.Service = "alertmanager"
+		}
+
+		var details []string
+		if description := a.Annotations["description"]; description != "" {
+			details = append(details, description)
+		}
+		names := make([]string, 0, len(a.Labels))
+		for name := range a.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			details = append(details, fmt.Sprintf("=> %s: %s", name, a.Labels[name]))
+		}
+		if a.GeneratorURL != "" {
+			details = append(details, "Source: "+a.GeneratorURL)
+		}
+		received.Details = strings.Join(details, "\n")
+		alerts = append(alerts, received)
+	}
+	return alerts
+}
+
+// Returns the first of the given labels that is set
+func firstLabel(labels map[string]string, names ...string) string {
+	for _, name := range names {
+		if value := labels[name]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Serializes updates to the state of received alerts
+var receivedAlertsLock sync.Mutex
+
+// Returns the KV path of a received alert's state, with "_" for the parts it doesn't have
+func receivedPath(datacenter string, alert *AlertState) string {
+	parts := []string{datacenter, alert.Service, alert.Node, alert.Check}
+	for i, part := range parts {
+		if part == "" {
+			parts[i] = "_"
+		}
+	}
+	return receivedKVPath + strings.Join(parts, "/")
+}
+
+// Sends a received alert through the same pipeline as the alerts of the watches: its
+// status is tracked so only changes are sent, and it's routed, deduplicated, checked for
+// flapping, silences, maintenance and inhibit rules, and recorded in the history. Returns
+// whether it was a change of status.
+func receiveAlert(config *Config, client *api.Client, received *receivedAlert) (bool, error) {
+	datacenter := config.ConsulDatacenter
+	receivedAlertsLock.Lock()
+	defer receivedAlertsLock.Unlock()
+
+	alert := &AlertState{Service: received.Service, Node: received.Node, Check: received.Check}
+	path := receivedPath(datacenter, alert)
+	if stored, err := getAlertState(path, client); err != nil {
+		return false, err
+	} else if stored != nil {
+		alert = stored
+	} else {
+		alert.LastAlerted = api.HealthPassing
+	}
+	if received.Status == alert.LastAlerted {
+		return false, nil
+	}
+
+	alert.Status = received.Status
+	alert.Message = received.Message
+	if alert.Message == "" {
+		target := "service " + received.Service
+		if received.Service == "" {
+			target = "node " + received.Node
+		} else if received.Node != "" {
+			target += fmt.Sprintf(" (node: %s)", received.Node)
+		}
+		if received.Check != "" {
+			target += fmt.Sprintf(" (check: %s)", received.Check)
+		}
+		alert.Message = fmt.Sprintf("[%s] %s is now %s", datacenter, target, received.Status)
+	}
+	alert.Details = received.Details
+	if received.Source != "" {
+		alert.Details = strings.TrimSpace(alert.Details + "\nReceived from " + received.Source)
+	}
+	secrets.redactAlert(alert)
+
+	now := time.Now()
+	if alert.Status == api.HealthPassing {
+		alert.FailingSince = 0
+	} else if alert.LastAlerted == api.HealthPassing || alert.FailingSince == 0 {
+		alert.FailingSince = now.Unix()
+	}
+	alert.LastNotified = now.Unix()
+	alert.Severity = config.alertSeverity(alert.Service, alert.Status)
+	config.applyMessageTemplates(alert.Service, alert)
+
+	flapRates.record(config, alert.Service, received.Tags, alert)
+	if !suppressDuplicate(client, config.serviceDedupeCooldown(alert.Service), datacenter, alert) {
+		flapDetection.notify(config, alert.Service, received.Tags, alert)
+	}
+	if alert.Status == api.HealthPassing {
+		clearAck(client, datacenter, alert)
+	}
+	activeAlerts.update(datacenter, alert)
+	countAlert(alert)
+	history.record(datacenter, alert, now)
+
+	alert.LastAlerted = alert.Status
+	recordAlerted(alert, alert.Status)
+	return true, setAlertState(path, alert, client)
+}
+
+// The response of the receiver endpoints
+type receiveResponse struct {
+	Received int `json:"received"`
+	Changed  int `json:"changed"`
+}
+
+// Serves /v1/receive, which takes an alert or a list of alerts in the receivedAlert
+// format, and /v1/receive/alertmanager, which takes Alertmanager webhooks
+func receiveHandler(config *Config, client *api.Client, alertmanager bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error reading request: %s", err), http.StatusBadRequest)
+			return
+		}
+		current := latestConfig(config)
+		if current.ReceiveSigningSecret != "" {
+			tolerance := time.Duration(current.ReceiveSignatureTolerance) * time.Second
+			if err := verifyWebhookSignature(current.ReceiveSigningSecret, body, r.Header, time.Now(), tolerance); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		var alerts []*receivedAlert
+		if alertmanager {
+			var webhook alertmanagerWebhook
+			err = json.Unmarshal(body, &webhook)
+			alerts = webhook.receivedAlerts()
+		} else if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+			err = json.Unmarshal(body, &alerts)
+		} else {
+			var received receivedAlert
+			err = json.Unmarshal(body, &received)
+			alerts = []*receivedAlert{&received}
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+			return
+		}
+		for i, received := range alerts {
+			if received == nil {
+				http.Error(w, fmt.Sprintf("invalid alert %d: alert can't be null", i), http.StatusBadRequest)
+				return
+			}
+			if err := received.validate(); err != nil {
+				http.Error(w, fmt.Sprintf("invalid alert %d: %s", i, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		response := receiveResponse{Received: len(alerts)}
+		for _, received := range alerts {
+			changed, err := receiveAlert(current, client, received)
+			if err != nil {
+				log.Errorf("Error handling received alert: %s", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if changed {
+				response.Changed++
+			}
+		}
+		writeJSON(w, response)
+	}
+}
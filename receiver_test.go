@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Posts a request to a receiver endpoint, returning its response
+func testReceive(t *testing.T, handler http.HandlerFunc, body string) receiveResponse {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/v1/receive", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response receiveResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestReceiver_alerts(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	config, alertCh := testAlertConfig()
+	config.ConsulDatacenter = "dc1"
+	handler := receiveHandler(config, client, false)
+
+	body := `{"source": "nagios", "service": "billing", "check": "http", "status": "critical", "details": "HTTP 503"}`
+	if response := testReceive(t, handler, body); response.Received != 1 || response.Changed != 1 {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	select {
+	case alert := <-alertCh:
+		if alert.Message != "[dc1] service billing (check: http) is now critical" || alert.Details != "HTTP 503\nReceived from nagios" {
+			t.Errorf("unexpected alert: %+v", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the alert")
+	}
+	if _, ok := values["service/consul-alerting/received/dc1/billing/_/http"]; !ok {
+		t.Errorf("expected the alert state to be stored, got %v", values)
+	}
+
+	// Posting the same status again doesn't alert again
+	if response := testReceive(t, handler, "["+body+"]"); response.Changed != 0 {
+		t.Errorf("expected no change, got %+v", response)
+	}
+
+	body = `{"service": "billing", "check": "http", "status": "passing", "message": "billing recovered"}`
+	if response := testReceive(t, handler, body); response.Changed != 1 {
+		t.Errorf("expected the recovery to be a change, got %+v", response)
+	}
+	if alert := <-alertCh; alert.Status != "passing" || alert.Message != "billing recovered" {
+		t.Errorf("unexpected recovery: %+v", alert)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/v1/receive", strings.NewReader(`{"service": "billing", "status": "down"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid status, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/v1/receive", strings.NewReader(`[null]`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a null alert, got %d", w.Code)
+	}
+}
+
+func TestReceiver_signed(t *testing.T) {
+	client, _, stop := testFakeKV(t)
+	defer stop()
+
+	config, _ := testAlertConfig()
+	config.ReceiveSigningSecret = "shared"
+	config.ReceiveSignatureTolerance = 300
+	handler := receiveHandler(config, client, false)
+
+	body := `{"service": "billing", "status": "critical"}`
+	post := func(signedAt time.Time, secret string) int {
+		r := httptest.NewRequest("POST", "/v1/receive", strings.NewReader(body))
+		if secret != "" {
+			setWebhookSignature(r, defaultSignatureHeader, secret, []byte(body), signedAt)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	if code := post(time.Now(), "shared"); code != http.StatusOK {
+		t.Errorf("expected status 200 for a signed alert, got %d", code)
+	}
+	if code := post(time.Now(), ""); code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without a signature, got %d", code)
+	}
+	if code := post(time.Now(), "other"); code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 with the wrong secret, got %d", code)
+	}
+	if code := post(time.Now().Add(-10*time.Minute), "shared"); code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for a replayed alert, got %d", code)
+	}
+}
+
+func TestReceiver_alertmanager(t *testing.T) {
+	webhook := alertmanagerWebhook{}
+	json.Unmarshal([]byte(`{
+  "version": "4",
+  "status": "firing",
+  "alerts": [
+    {
+      "status": "firing",
+      "labels": {"alertname": "HighLatency", "job": "api", "instance": "10.0.0.1:9100", "severity": "warning"},
+      "annotations": {"summary": "API latency is high", "description": "p99 is 2s"},
+      "generatorURL": "http://prometheus/graph"
+    },
+    {
+      "status": "resolved",
+      "labels": {"alertname": "DiskFull"}
+    }
+  ]
+}`), &webhook)
+
+	alerts := webhook.receivedAlerts()
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %d", len(alerts))
+	}
+	first := alerts[0]
+	if first.Service != "api" || first.Node != "10.0.0.1:9100" || first.Check != "HighLatency" || first.Status != "warning" || first.Message != "API latency is high" {
+		t.Errorf("unexpected alert: %+v", first)
+	}
+	if !strings.HasPrefix(first.Details, "p99 is 2s\n=> alertname: HighLatency\n") || !strings.HasSuffix(first.Details, "Source: http://prometheus/graph") {
+		t.Errorf("unexpected details: %q", first.Details)
+	}
+	if alerts[1].Service != "alertmanager" || alerts[1].Status != "passing" {
+		t.Errorf("unexpected resolved alert: %+v", alerts[1])
+	}
+}
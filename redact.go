@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The text secrets are replaced with
+const redactedText = "[REDACTED]"
+
+// The patterns of common credentials redacted with redact_credentials, keeping the text
+// around the secret in their first and last groups
+var credentialPatterns = []*regexp.Regexp{
+	// Passwords in URLs, like connection strings
+	regexp.MustCompile(`(?i)(\b[a-z][a-z0-9+.-]*://[^:/@\s]+:)[^@\s]+(@)`),
+	// Assignments like password=..., "token": "..." or api_key: ...
+	regexp.MustCompile(`(?i)((?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|secret[_-]?key)"?\s*[=:]\s*"?)[^\s"',;&]+()`),
+	// Authorization headers
+	regexp.MustCompile(`(?i)(\bauthorization:\s*(?:bearer|basic|token)\s+)\S+()`),
+	// AWS access key IDs
+	regexp.MustCompile(`()\b(?:AKIA|ASIA)[0-9A-Z]{16}\b()`),
+}
+
+// Masks secrets in log lines and check output: the configured values, those read from
+// Vault, matches of the configured patterns, and with redact_credentials common
+// credential patterns
+type secretRedactor struct {
+	sync.RWMutex
+	values      []string
+	patterns    []*regexp.Regexp
+	credentials bool
+}
+
+var secrets = &secretRedactor{}
+
+// Compiles the redact_patterns of the config
+func compileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern in redact_patterns: %s", err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// Replaces what is redacted with the settings of the given config
+func (r *secretRedactor) update(config *Config) {
+	values := make([]string, 0, len(config.RedactValues)+len(config.vaultSecrets))
+	for _, value := range config.RedactValues {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	for _, value := range config.vaultSecrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	// Longer values first, so a secret containing another is masked whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	r.Lock()
+	defer r.Unlock()
+	r.values = values
+	r.patterns = config.redactPatterns
+	r.credentials = config.RedactCredentials
+}
+
+func (r *secretRedactor) redact(text string) string {
+	if text == "" {
+		return text
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+	for _, value := range r.values {
+		text = strings.Replace(text, value, redactedText, -1)
+	}
+	for _, pattern := range r.patterns {
+		text = pattern.ReplaceAllString(text, redactedText)
+	}
+	if r.credentials {
+		for _, pattern := range credentialPatterns {
+			text = pattern.ReplaceAllString(text, "${1}"+redactedText+"${2}")
+		}
+	}
+	return text
+}
+
+// Masks the secrets in the message, details and check output of an alert
+func (r *secretRedactor) redactAlert(alert *AlertState) {
+	alert.Message = r.redact(alert.Message)
+	alert.Details = r.redact(alert.Details)
+	for i := range alert.Checks {
+		alert.Checks[i].Output = r.redact(alert.Checks[i].Output)
+	}
+}
+
+// A log hook masking secrets in the message and fields of every log entry
+type redactHook struct {
+	redactor *secretRedactor
+}
+
+func (h redactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h redactHook) Fire(entry *log.Entry) error {
+	entry.Message = h.redactor.redact(entry.Message)
+	if len(entry.Data) == 0 {
+		return nil
+	}
+
+	data := make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			data[key] = h.redactor.redact(v)
+		case error:
+			data[key] = h.redactor.redact(v.Error())
+		default:
+			data[key] = value
+		}
+	}
+	entry.Data = data
+	return nil
+}
+
+var installRedactHook sync.Once
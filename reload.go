@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The settings that only take effect on startup, which a reload leaves as they were
+var restartSettings = []string{
+	"ConsulAddress", "ConsulToken", "ConsulCAFile", "ConsulCertFile", "ConsulKeyFile",
+	"ConsulTLSServerName", "ConsulTLSSkipVerify", "ConsulWaitTime", "ConsulRetryInitialInterval", "ConsulRetryMaxInterval", "ConsulRateLimit", "AllowStale", "MaxStale", "DevMode", "NodeWatch", "ServiceWatch", "ServerHealth", "WANHealth", "AgentHealth", "PreparedQueries", "HighAvailability", "HASessionTTL", "Sharding", "ExternalServices", "NodeMeta", "NodeRegistrations", "ServiceRegistrations", "KVWatches", "LockWatches", "Heartbeats", "SLOs",
+	"Datacenters", "DatacenterTokens", "Namespaces", "Partitions", "HTTPAddress", "APIToken", "ReceiveAlerts", "DryRun", "GRPCAddress", "DebugAddress", "DebugUsername", "DebugPassword",
+	"SilenceKVPrefix", "ConfigKVPrefix", "DispatchWorkers", "DispatchQueueSize", "QueueDir", "QueueRetryInterval", "StateStore", "StateDir",
+	"HistorySize", "HistoryFile", "AuditLogFile", "AuditLogMaxSize", "AuditLogMaxBackups",
+	"StatsdAddress", "StatsdPrefix", "StatsdDogStatsD",
+	"TracingEndpoint", "TracingHeaders", "TracingServiceName", "TracingSampleRate",
+	"StateExportKVPrefix", "StateExportCheck",
+}
+
+// The config loaded by the last reload, used by the watches in place of the one they
+// were started with, and its copies for the watches of each datacenter, partition and
+// namespace
+var reloadedConfig = struct {
+	sync.Mutex
+	config *Config
+	copies map[string]*Config
+}{}
+
+// Returns the config from the last reload, or the given one if there hasn't been one
+func latestConfig(config *Config) *Config {
+	reloadedConfig.Lock()
+	defer reloadedConfig.Unlock()
+	if reloadedConfig.config == nil {
+		return config
+	}
+	if config.localDatacenter == "" && config.partition == "" && config.namespace == "" {
+		return reloadedConfig.config
+	}
+
+	key := config.ConsulDatacenter + "/" + config.partition + "/" + config.namespace
+	copied, ok := reloadedConfig.copies[key]
+	if !ok {
+		copied = reloadedConfig.config
+		if config.localDatacenter != "" {
+			copied = copied.forDatacenter(config.ConsulDatacenter, config.localDatacenter)
+		}
+		if config.partition != "" {
+			copied = copied.forPartition(config.partition, config.localPartition)
+		}
+		if config.namespace != "" {
+			copied = copied.forNamespace(config.namespace)
+		}
+		reloadedConfig.copies[key] = copied
+	}
+	return copied
+}
+
+func setReloadedConfig(config *Config) {
+	reloadedConfig.Lock()
+	defer reloadedConfig.Unlock()
+	reloadedConfig.config = config
+	reloadedConfig.copies = make(map[string]*Config)
+}
+
+// Reloads requested by the daemon itself, like when Vault secrets were rotated
+var reloadRequests = make(chan struct{}, 1)
+
+// Asks the main loop to reload the config file, unless a reload is already pending
+func requestReload() {
+	select {
+	case reloadRequests <- struct{}{}:
+	default:
+	}
+}
+
+// Reloads the config file, along with the config under config_kv_prefix. Running watches pick up the new handlers, routing rules and
+// service overrides as they next alert, without losing their alert state, and service
+// discovery starts or stops watches to match. Handlers whose settings didn't change keep
+// running as they were, with their open incidents and threads.
+func reloadConfig(path string, running *Config, client *api.Client) (*Config, error) {
+	config, err := loadConfig(path, client, running.ConfigKVPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// The datacenter is looked up from the agent when it isn't configured
+	if config.ConsulDatacenter == "" {
+		config.ConsulDatacenter = running.ConsulDatacenter
+	}
+	keepRestartSettings(running, config)
+
+	before, after := snapshotConfig(running), snapshotConfig(config)
+	kept := make(map[string]bool)
+	for name := range config.Handlers {
+		if _, ok := running.Handlers[name]; ok && before.Handlers[name] == after.Handlers[name] {
+			config.Handlers[name] = running.Handlers[name]
+			kept[name] = true
+		}
+	}
+
+	if err := setupLogging(config); err != nil {
+		return nil, err
+	}
+	setRetryPolicy(config.retryPolicy())
+	setDeadLetters(config, client)
+	recordConfigChange(client, &before, config, "reload")
+
+	setReloadedConfig(config)
+
+	// The handlers replaced or removed would otherwise keep their background work running,
+	// like re-sending alerts to an Alertmanager that's no longer configured
+	for name, handler := range running.Handlers {
+		if stoppable, ok := handler.(stoppableHandler); ok && !kept[name] {
+			stoppable.stop()
+		}
+	}
+	log.Infof("Reloaded configuration from %s (%d handlers)", path, len(config.Handlers))
+	return config, nil
+}
+
+// Copies the restart settings of the running config to the new one, warning about any
+// that changed
+func keepRestartSettings(running *Config, config *Config) {
+	old, updated := reflect.ValueOf(running).Elem(), reflect.ValueOf(config).Elem()
+	for _, name := range restartSettings {
+		oldValue, value := old.FieldByName(name), updated.FieldByName(name)
+		if reflect.DeepEqual(oldValue.Interface(), value.Interface()) {
+			continue
+		}
+
+		field, _ := old.Type().FieldByName(name)
+		log.Warnf("Ignoring the change to %s on reload, it needs a restart to take effect", field.Tag.Get("mapstructure"))
+		value.Set(oldValue)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReload_config(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.hcl")
+	write := func(config string) {
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+log_level = "debug"
+http_address = ":9586"
+handler "alertmanager" "kept" {
+	urls = ["http://am1:9093"]
+}
+handler "alertmanager" "changed" {
+	urls = ["http://am2:9093"]
+}
+`)
+	running, err := ParseConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running.ConsulDatacenter = "dc1"
+
+	write(`
+log_level = "debug"
+http_address = ":9999"
+handler "alertmanager" "kept" {
+	urls = ["http://am1:9093"]
+}
+handler "alertmanager" "changed" {
+	urls = ["http://am3:9093"]
+}
+handler "stdout" "added" {}
+service "redis" {
+	change_threshold = 5
+}
+`)
+	config, err := reloadConfig(path, running, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setReloadedConfig(nil)
+	defer setupLogging(&Config{LogLevel: "debug", LogFormat: "text"})
+
+	if latestConfig(running) != config {
+		t.Error("expected the reloaded config to be used by the watches")
+	}
+	if config.ConsulDatacenter != "dc1" {
+		t.Errorf("expected the running datacenter to be kept, got %q", config.ConsulDatacenter)
+	}
+	if config.HTTPAddress != ":9586" {
+		t.Errorf("expected http_address to need a restart, got %q", config.HTTPAddress)
+	}
+	if _, ok := config.Handlers["stdout.added"]; !ok {
+		t.Error("expected the added handler to be loaded")
+	}
+	if config.Services["redis"].ChangeThreshold != 5 {
+		t.Errorf("expected the service override to be loaded, got %+v", config.Services["redis"])
+	}
+
+	if config.Handlers["alertmanager.kept"].(AlertmanagerHandler).firing != running.Handlers["alertmanager.kept"].(AlertmanagerHandler).firing {
+		t.Error("expected the unchanged handler to be kept")
+	}
+	changed := config.Handlers["alertmanager.changed"].(AlertmanagerHandler)
+	if changed.firing == running.Handlers["alertmanager.changed"].(AlertmanagerHandler).firing || changed.URLs[0] != "http://am3:9093" {
+		t.Error("expected the changed handler to be replaced")
+	}
+
+	// The replaced handler stops re-sending its alerts, the kept one carries on
+	select {
+	case <-running.Handlers["alertmanager.changed"].(AlertmanagerHandler).firing.stopCh:
+	default:
+		t.Error("expected the replaced handler to be stopped")
+	}
+	select {
+	case <-config.Handlers["alertmanager.kept"].(AlertmanagerHandler).firing.stopCh:
+		t.Error("expected the kept handler to keep running")
+	default:
+	}
+
+	write(`handler "nope" "broken" {}`)
+	if _, err := reloadConfig(path, config, nil); err == nil {
+		t.Error("expected an error reloading an invalid config")
+	}
+	if latestConfig(running) != config {
+		t.Error("expected a failed reload to keep the running config")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// A failing alert that's re-sent to a handler until it recovers
+type alertRepeat struct {
+	handler    namedHandler
+	datacenter string
+	alert      *AlertState
+	since      time.Time
+	timer      *time.Timer
+}
+
+// Tracks the failing alerts of handlers with a repeat_interval, keyed by
+// handler/datacenter/service/tag/node/check
+type alertRepeats struct {
+	sync.Mutex
+	repeats map[string]*alertRepeat
+}
+
+var handlerRepeats = &alertRepeats{
+	repeats: make(map[string]*alertRepeat),
+}
+
+// Starts or updates the reminders for a failing alert sent to a handler, or stops them
+// once the alert has recovered
+func (r *alertRepeats) track(handler namedHandler, datacenter string, alert *AlertState, interval time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := repeatKey(handler, datacenter, alert)
+	repeat, ok := r.repeats[key]
+
+	if alert.Status == api.HealthPassing {
+		if ok {
+			repeat.timer.Stop()
+			delete(r.repeats, key)
+		}
+		return
+	}
+
+	latest := *alert
+	if ok {
+		repeat.handler = handler
+		repeat.alert = &latest
+		return
+	}
+
+	repeat = &alertRepeat{handler: handler, datacenter: datacenter, alert: &latest, since: time.Now()}
+	repeat.timer = time.AfterFunc(interval, func() {
+		r.remind(key, interval)
+	})
+	r.repeats[key] = repeat
+}
+
+// Resumes the reminders of an alert that was failing before a restart, keeping to the
+// interval counted from when the alert was last sent
+func (r *alertRepeats) resume(handler namedHandler, datacenter string, alert *AlertState, interval time.Duration, since time.Time, lastSent time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := repeatKey(handler, datacenter, alert)
+	if _, ok := r.repeats[key]; ok {
+		return
+	}
+
+	latest := *alert
+	repeat := &alertRepeat{handler: handler, datacenter: datacenter, alert: &latest, since: since}
+	repeat.timer = time.AfterFunc(interval-time.Since(lastSent)%interval, func() {
+		r.remind(key, interval)
+	})
+	r.repeats[key] = repeat
+}
+
+func repeatKey(handler namedHandler, datacenter string, alert *AlertState) string {
+	return handler.name + "/" + alertIncidentKey(datacenter, alert) + "-" + alert.Check
+}
+
+// Re-sends a still failing alert to its handler, unless it has been acknowledged, and
+// schedules the next reminder
+func (r *alertRepeats) remind(key string, interval time.Duration) {
+	r.Lock()
+	repeat, ok := r.repeats[key]
+	if !ok {
+		r.Unlock()
+		return
+	}
+	repeat.timer.Reset(interval)
+	if ack, ok := alertAcks.acked(repeat.datacenter, repeat.alert); ok {
+		log.Debugf("Not re-sending alert '%s', acknowledged by %s", repeat.alert.Message, ack.By)
+		r.Unlock()
+		return
+	}
+	reminder := reminderAlert(repeat.alert, time.Since(repeat.since))
+	handler, datacenter := repeat.handler, repeat.datacenter
+	r.Unlock()
+
+	log.Debugf("Re-sending unresolved alert '%s'", reminder.Message)
+	handler.send(datacenter, reminder)
+}
+
+// Returns a copy of an alert marked as a reminder of how long it has been failing
+func reminderAlert(alert *AlertState, failing time.Duration) *AlertState {
+	reminder := *alert
+	reminder.Message = fmt.Sprintf("Reminder: %s (for %s)", alert.Message, failing.Truncate(time.Second))
+	return &reminder
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepeat_untilRecovered(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	repeats := &alertRepeats{repeats: make(map[string]*alertRepeat)}
+	handler := namedHandler{name: "test", handler: testHandler{alertCh}}
+
+	repeats.track(handler, "dc1", &AlertState{Service: "web", Status: "critical", Message: "web is critical"}, 50*time.Millisecond)
+
+	select {
+	case alert := <-alertCh:
+		if !strings.HasPrefix(alert.Message, "Reminder: web is critical (for ") {
+			t.Errorf("unexpected reminder message: %s", alert.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a reminder for the failing alert")
+	}
+
+	// A later alert for the same service updates the reminder
+	repeats.track(handler, "dc1", &AlertState{Service: "web", Status: "warning", Message: "web is warning"}, 50*time.Millisecond)
+	select {
+	case alert := <-alertCh:
+		if alert.Status != "warning" {
+			t.Errorf("expected the latest status to be repeated, got %s", alert.Status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected another reminder for the failing alert")
+	}
+
+	repeats.track(handler, "dc1", &AlertState{Service: "web", Status: "passing", Message: "web is passing"}, 50*time.Millisecond)
+	if len(repeats.repeats) != 0 {
+		t.Fatalf("expected the reminders to stop, got %d", len(repeats.repeats))
+	}
+
+	// Drain a reminder that may have fired before the recovery
+	time.Sleep(100 * time.Millisecond)
+	for len(alertCh) > 0 {
+		<-alertCh
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(alertCh) != 0 {
+		t.Errorf("expected no reminders after recovering, got %d", len(alertCh))
+	}
+}
+
+func TestRepeat_passingNotTracked(t *testing.T) {
+	repeats := &alertRepeats{repeats: make(map[string]*alertRepeat)}
+	repeats.track(namedHandler{name: "test", handler: testHandler{make(chan *AlertState, 1)}}, "dc1", &AlertState{Service: "web", Status: "passing"}, time.Hour)
+
+	if len(repeats.repeats) != 0 {
+		t.Errorf("expected a passing alert not to be repeated, got %d", len(repeats.repeats))
+	}
+}
+
+func TestRepeat_resume(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	repeats := &alertRepeats{repeats: make(map[string]*alertRepeat)}
+	handler := namedHandler{name: "test", handler: testHandler{alertCh}}
+
+	// Sent an hour ago with a reminder due every hour and a minute, so the next one is a
+	// minute away
+	alert := &AlertState{Service: "web", Status: "critical", Message: "web is critical"}
+	since := time.Now().Add(-2 * time.Hour)
+	repeats.resume(handler, "dc1", alert, time.Hour+time.Minute, since, time.Now().Add(-time.Hour))
+
+	repeat := repeats.repeats["test/"+alertIncidentKey("dc1", alert)+"-"]
+	if repeat == nil || repeat.since != since {
+		t.Fatalf("expected the reminders to resume from when the alert started failing")
+	}
+	if !repeat.timer.Stop() {
+		t.Errorf("expected the next reminder to be scheduled")
+	}
+
+	// Resuming doesn't replace reminders that are already running
+	repeats.resume(handler, "dc1", alert, time.Hour, time.Now(), time.Now())
+	if repeats.repeats["test/"+alertIncidentKey("dc1", alert)+"-"] != repeat {
+		t.Errorf("expected the running reminders to be kept")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Returns the alert states stored under a watch's KV prefix, keyed by alert group, with
+// the watch's ungrouped alert under an empty group
+func getAlertStates(keyPath string, client *api.Client) (map[string]*AlertState, error) {
+	alerts := make(map[string]*AlertState)
+	values, err := stateKV(client).list(keyPath)
+	if err != nil {
+		return alerts, fmt.Errorf("Error loading alert states: %s", err)
+	}
+
+	for key, value := range values {
+		var group string
+		switch {
+		case key == keyPath+"alert":
+		case strings.HasPrefix(key, keyPath+alertGroupsPath):
+			group = strings.TrimPrefix(key, keyPath+alertGroupsPath)
+		default:
+			continue
+		}
+		if len(value) == 0 {
+			continue
+		}
+
+		alert := &AlertState{}
+		if err := json.Unmarshal(value, alert); err != nil {
+			return alerts, fmt.Errorf("Error parsing alert state at %s: %s", key, err)
+		}
+		alerts[group] = alert
+	}
+
+	return alerts, nil
+}
+
+// Picks up where a watch's alerts left off before a restart or a change of lock ownership.
+// The last alerted status of each group is loaded so alerts that are still failing aren't
+// sent again, and the alerts that were sent failing are active again, with their
+// reminders and escalations resuming from when they were sent.
+func restoreAlerts(opts *WatchOptions, alerts map[string]*AlertState, lastAlertStatus map[string]string) {
+	config := latestConfig(opts.config)
+	var tags []string
+	if opts.service != "" && !opts.pseudoService() {
+		tags = serviceTags(opts)
+		config = config.withCatalogSettings(opts.service, tags)
+	}
+
+	for group, alert := range alerts {
+		lastAlertStatus[group] = alert.LastAlerted
+		if alert.LastAlerted == api.HealthPassing {
+			continue
+		}
+		restoreAlert(opts.client, config.withCheckSettings(opts.service, groupCheckConfig(config, opts.service, group)), opts.service, tags, alert)
+	}
+}
+
+// Returns the check block of a group holding a single check, keyed by node/checkID
+func groupCheckConfig(config *Config, service string, group string) *CheckConfig {
+	parts := strings.SplitN(group, "/", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	return config.checkConfig(service, &api.HealthCheck{Node: parts[0], CheckID: parts[1]})
+}
+
+// Restores a failing alert that was sent before a restart, choosing its handlers the way
+// notifyHandlers does. The alerts of services using datacenter aggregation rejoin their
+// datacenter's incident instead, so their recoveries resolve it.
+func restoreAlert(client *api.Client, config *Config, service string, tags []string, alert *AlertState) {
+	activeAlerts.update(config.ConsulDatacenter, alert)
+	if config.serviceAggregation(service) == AggregateDatacenter {
+		datacenterIncidents.restore(client, config, alert)
+		return
+	}
+
+	now := time.Now()
+	if _, ok := alertSilences.silenced(service, alert.Node, now); ok {
+		return
+	}
+
+	// Alerts stored before the times were recorded resume as if they were just sent
+	lastNotified := now
+	if alert.LastNotified != 0 {
+		lastNotified = time.Unix(alert.LastNotified, 0)
+	}
+	since := lastNotified
+	if alert.FailingSince != 0 {
+		since = time.Unix(alert.FailingSince, 0)
+	}
+
+	names := config.severityHandlerNames(config.ConsulDatacenter, service, alert.Node, alert.NodeMeta, tags, alert.Status, alertedStatuses(alert, alert.LastAlerted)...)
+	if window := config.activeMaintenance(service, tags, now); window != nil {
+		names = config.maintenanceHandlerNames(window)
+	} else {
+		escalations.resume(config, service, alert, names, now.Sub(since))
+	}
+
+	for _, name := range names {
+		handler := config.namedHandler(name)
+		if interval := handler.options.RepeatInterval; interval > 0 {
+			handlerRepeats.resume(handler, config.ConsulDatacenter, alert, time.Duration(interval)*time.Second, since, lastNotified)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestore_getAlertStates(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	values["consul-alerting/service/redis/alert"] = []byte(`{"status":"critical","last_alerted":"critical","failing_since":100}`)
+	values["consul-alerting/service/redis/alerts/node1/redis-check"] = []byte(`{"status":"warning","last_alerted":"warning"}`)
+	values["consul-alerting/service/redis/node1/redis-check"] = []byte(`{"status":"critical"}`)
+	values["consul-alerting/service/redis/leader"] = []byte("")
+
+	alerts, err := getAlertStates("consul-alerting/service/redis/", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alert states, got %d", len(alerts))
+	}
+	if alert := alerts[""]; alert == nil || alert.LastAlerted != "critical" || alert.FailingSince != 100 {
+		t.Errorf("unexpected ungrouped alert: %+v", alert)
+	}
+	if alert := alerts["node1/redis-check"]; alert == nil || alert.Status != "warning" {
+		t.Errorf("unexpected grouped alert: %+v", alert)
+	}
+}
+
+func TestRestore_lastAlertStatus(t *testing.T) {
+	defer func(registry *alertRegistry) { activeAlerts = registry }(activeAlerts)
+	activeAlerts = &alertRegistry{alerts: make(map[string]alertPayload)}
+
+	config := &Config{ConsulDatacenter: "dc1", Handlers: map[string]AlertHandler{}}
+	opts := &WatchOptions{node: "node1", config: config}
+	lastAlertStatus := make(map[string]string)
+	restoreAlerts(opts, map[string]*AlertState{
+		"node1/serfHealth": {Node: "node1", Check: "node1/serfHealth", Status: "critical", LastAlerted: "critical"},
+		"node1/disk":       {Node: "node1", Check: "node1/disk", Status: "critical", LastAlerted: "passing"},
+	}, lastAlertStatus)
+
+	if lastAlertStatus["node1/serfHealth"] != "critical" || lastAlertStatus["node1/disk"] != "passing" {
+		t.Errorf("unexpected last alert statuses: %v", lastAlertStatus)
+	}
+
+	// Only the alert that was sent failing is active again
+	if len(activeAlerts.alerts) != 1 {
+		t.Errorf("expected 1 active alert, got %d", len(activeAlerts.alerts))
+	}
+}
+
+// Make sure a restored alert rejoins its datacenter's incident, so its recovery resolves it
+func TestRestore_datacenterIncident(t *testing.T) {
+	defer func(registry *alertRegistry) { activeAlerts = registry }(activeAlerts)
+	activeAlerts = &alertRegistry{alerts: make(map[string]alertPayload)}
+
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	config, alertCh := testAlertConfig()
+	config.ConsulDatacenter = "restore-incident"
+	config.Aggregation = AggregateDatacenter
+	opts := &WatchOptions{node: "node1", config: config, client: client}
+
+	alert := &AlertState{Node: "node1", Status: "critical", LastAlerted: "critical"}
+	restoreAlerts(opts, map[string]*AlertState{"": alert}, make(map[string]string))
+	select {
+	case alert := <-alertCh:
+		t.Fatalf("got unexpected incident alert: %v", alert)
+	default:
+	}
+	if _, ok := values[incidentsKVPath+"restore-incident"]; !ok {
+		t.Fatal("expected the incident to be stored")
+	}
+
+	datacenterIncidents.update(client, config, &AlertState{Node: "node1", Status: "passing"})
+	select {
+	case alert := <-alertCh:
+		if alert.Status != "passing" {
+			t.Fatalf("expected incident status passing, got %s", alert.Status)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("didn't get the incident recovery")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+var resyncCorrections = metrics.register("consul_alerting_resync_corrections_total",
+	"Alert groups whose last alerted status a resync found out of date and corrected.", "counter")
+
+// Returns whether a watch that last resynced at last is due for another resync
+func resyncDue(config *Config, last time.Time, now time.Time) bool {
+	return config.ResyncInterval > 0 && now.Sub(last) >= time.Duration(config.ResyncInterval)*time.Second
+}
+
+// Compares the status each group of a watch was last alerted with to the stored alert
+// states, resetting the groups whose alert was never stored as sent, so the watch alerts
+// on them again. Alerts still waiting out their delay are left alone. Returns the groups
+// that were reset.
+func reconcileAlertStates(stored map[string]*AlertState, lastAlertStatus map[string]string) []string {
+	corrected := make([]string, 0)
+	for group, status := range lastAlertStatus {
+		alert, ok := stored[group]
+		switch {
+		case !ok && status != api.HealthPassing:
+			delete(lastAlertStatus, group)
+		case ok && alert.Status == alert.LastAlerted && alert.LastAlerted != status:
+			lastAlertStatus[group] = alert.LastAlerted
+		default:
+			continue
+		}
+		corrected = append(corrected, group)
+	}
+	return corrected
+}
+
+// Reloads the stored alert states of a watch and reconciles them with the statuses it
+// believes it alerted with
+func resyncAlertStates(name string, keyPath string, opts *WatchOptions, lastAlertStatus map[string]string) {
+	opts.alertLock.Lock()
+	stored, err := getAlertStates(keyPath, opts.client)
+	opts.alertLock.Unlock()
+	if err != nil {
+		log.Errorf("Error resyncing %s: %s", name, err)
+		return
+	}
+
+	for _, group := range reconcileAlertStates(stored, lastAlertStatus) {
+		if group == "" {
+			log.Warnf("Resync of %s found its last alert was never sent, alerting again", name)
+		} else {
+			log.Warnf("Resync of %s found the last alert of %s was never sent, alerting again", name, group)
+		}
+		resyncCorrections.add(1)
+	}
+}
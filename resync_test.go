@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestResync_reconcileAlertStates(t *testing.T) {
+	stored := map[string]*AlertState{
+		// Sent and stored as sent
+		"node1": {Status: api.HealthCritical, LastAlerted: api.HealthCritical},
+		// Failed to store the new status, so it was never sent
+		"node2": {Status: api.HealthPassing, LastAlerted: api.HealthPassing},
+		// Still waiting out its delay
+		"node3": {Status: api.HealthCritical, LastAlerted: api.HealthPassing},
+	}
+	lastAlertStatus := map[string]string{
+		"node1": api.HealthCritical,
+		"node2": api.HealthCritical,
+		"node3": api.HealthCritical,
+		"node4": api.HealthWarning,
+		"node5": api.HealthPassing,
+	}
+
+	corrected := reconcileAlertStates(stored, lastAlertStatus)
+	sort.Strings(corrected)
+	if !reflect.DeepEqual(corrected, []string{"node2", "node4"}) {
+		t.Errorf("expected node2 and node4 to be corrected, got %v", corrected)
+	}
+
+	expected := map[string]string{
+		"node1": api.HealthCritical,
+		"node2": api.HealthPassing,
+		"node3": api.HealthCritical,
+		"node5": api.HealthPassing,
+	}
+	if !reflect.DeepEqual(lastAlertStatus, expected) {
+		t.Errorf("expected %v, got %v", expected, lastAlertStatus)
+	}
+
+	if corrected := reconcileAlertStates(stored, lastAlertStatus); len(corrected) != 0 {
+		t.Errorf("expected nothing left to correct, got %v", corrected)
+	}
+}
+
+func TestResync_due(t *testing.T) {
+	now := time.Now()
+	config := &Config{ResyncInterval: 60}
+	if resyncDue(config, now.Add(-30*time.Second), now) {
+		t.Error("expected no resync before the interval passed")
+	}
+	if !resyncDue(config, now.Add(-60*time.Second), now) {
+		t.Error("expected a resync once the interval passed")
+	}
+	if resyncDue(&Config{}, now.Add(-time.Hour), now) {
+		t.Error("expected no resync with resync_interval disabled")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How failed sends are retried: waiting initial before the first retry and doubling the
+// wait up to max after each further failure, with jitter so handlers that failed together
+// don't retry in lockstep. No retries are started once maxElapsed has passed, if set.
+type retryPolicy struct {
+	initial    time.Duration
+	max        time.Duration
+	maxElapsed time.Duration
+}
+
+func (c *Config) retryPolicy() retryPolicy {
+	return retryPolicy{
+		initial:    time.Duration(c.RetryInitialInterval) * time.Second,
+		max:        time.Duration(c.RetryMaxInterval) * time.Second,
+		maxElapsed: time.Duration(c.RetryMaxElapsed) * time.Second,
+	}
+}
+
+// The policy and context used by retryAlert. The context is cancelled on shutdown so
+// handlers stop retrying instead of holding up the watches they were called from.
+var handlerRetries = struct {
+	sync.Mutex
+	policy retryPolicy
+	ctx    context.Context
+	cancel context.CancelFunc
+}{
+	policy: retryPolicy{initial: 5 * time.Second, max: 60 * time.Second},
+	ctx:    context.Background(),
+	cancel: func() {},
+}
+
+// Sets the retry policy used by handlers
+func setRetryPolicy(policy retryPolicy) {
+	handlerRetries.Lock()
+	defer handlerRetries.Unlock()
+
+	handlerRetries.policy = policy
+	handlerRetries.ctx, handlerRetries.cancel = context.WithCancel(context.Background())
+}
+
+// Stops all handler retries in progress or started later
+func cancelRetries() {
+	handlerRetries.Lock()
+	defer handlerRetries.Unlock()
+	handlerRetries.cancel()
+}
+
+// Returns the wait before the given retry (starting at 1): the backoff for the attempt,
+// with its second half randomized
+func (p retryPolicy) wait(retry int) time.Duration {
+	backoff := p.initial
+	for i := 1; i < retry && backoff < p.max; i++ {
+		backoff *= 2
+	}
+	if backoff > p.max {
+		backoff = p.max
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff)/2+1))
+}
+
+// Calls fn until it succeeds, has been retried maxRetries times, runs out of time or ctx
+// is done, waiting between attempts as the policy says. Returns the last error if every
+// attempt failed.
+func retry(ctx context.Context, policy retryPolicy, maxRetries int, description string, fn func() error) error {
+	start := time.Now()
+	var err error
+	for tries := 0; tries <= maxRetries; tries++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		log.Errorf("Error sending alert to %s: %s", description, err)
+		if tries == maxRetries {
+			break
+		}
+		wait := policy.wait(tries + 1)
+		if policy.maxElapsed > 0 && time.Since(start)+wait > policy.maxElapsed {
+			log.Errorf("Giving up on alert to %s after %s", description, time.Since(start).Truncate(time.Second))
+			break
+		}
+
+		log.Errorf("Retrying alert to %s in %s...", description, wait.Truncate(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			log.Errorf("Not retrying alert to %s: %s", description, ctx.Err())
+			return err
+		}
+	}
+	return err
+}
+
+// Calls fn until it succeeds or has been retried maxRetries times, using the configured
+// retry policy, and records the attempt in the audit log of the alert being sent. Returns
+// the last error if every attempt failed.
+func retryAlert(alert *AlertState, maxRetries int, description string, fn func() error) error {
+	handlerRetries.Lock()
+	policy, ctx := handlerRetries.policy, handlerRetries.ctx
+	handlerRetries.Unlock()
+
+	attempts := 0
+	err := retry(ctx, policy, maxRetries, description, func() error {
+		attempts++
+		err := fn()
+		if err != nil && alert != nil {
+			alert.span.addEvent("attempt failed", "target", description, "attempt", strconv.Itoa(attempts), "error", err.Error())
+		}
+		return err
+	})
+	auditLog.attempt(alert, description, attempts-1, err)
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetry_backoff(t *testing.T) {
+	policy := retryPolicy{initial: 100 * time.Millisecond, max: 400 * time.Millisecond}
+
+	for retry, backoff := range map[int]time.Duration{1: 100, 2: 200, 3: 400, 10: 400} {
+		backoff *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if wait := policy.wait(retry); wait < backoff/2 || wait > backoff {
+				t.Errorf("expected the wait before retry %d to be within [%s, %s], got %s", retry, backoff/2, backoff, wait)
+			}
+		}
+	}
+}
+
+func TestRetry_stops(t *testing.T) {
+	policy := retryPolicy{initial: 20 * time.Millisecond, max: 20 * time.Millisecond}
+	failing := func(calls *int) func() error {
+		return func() error {
+			*calls++
+			return fmt.Errorf("unreachable")
+		}
+	}
+
+	calls := 0
+	if err := retry(context.Background(), policy, 3, "test", failing(&calls)); err == nil || calls != 4 {
+		t.Errorf("expected 4 attempts and an error, got %d (%v)", calls, err)
+	}
+
+	calls = 0
+	policy.maxElapsed = 50 * time.Millisecond
+	retry(context.Background(), policy, 100, "test", failing(&calls))
+	if calls < 2 || calls > 6 {
+		t.Errorf("expected retries to stop after 50ms, got %d attempts", calls)
+	}
+
+	calls = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	retry(ctx, retryPolicy{initial: time.Hour, max: time.Hour}, 3, "test", failing(&calls))
+	if calls != 1 {
+		t.Errorf("expected no retries once cancelled, got %d attempts", calls)
+	}
+
+	calls = 0
+	succeeding := func() error {
+		calls++
+		if calls < 2 {
+			return fmt.Errorf("unreachable")
+		}
+		return nil
+	}
+	if err := retry(context.Background(), policy, 3, "test", succeeding); err != nil || calls != 2 {
+		t.Errorf("expected success on the second attempt, got %d (%v)", calls, err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// A routing rule choosing the handlers that receive the alerts it matches. Every set
+// condition must match; conditions that aren't set match any alert.
+type RouteConfig struct {
+	Name       string   `json:"name"`
+	Service    string   `mapstructure:"service" json:"service,omitempty"`
+	Node       string   `mapstructure:"node" json:"node,omitempty"`
+	Datacenter string   `mapstructure:"datacenter" json:"datacenter,omitempty"`
+	Partition  string   `mapstructure:"partition" json:"partition,omitempty"`
+	Tags       []string `mapstructure:"tags" json:"tags,omitempty"`
+	Statuses   []string `mapstructure:"status" json:"status,omitempty"`
+	Handlers   []string `mapstructure:"handlers" json:"handlers"`
+
+	// The Consul metadata the alert's node must have, e.g. { team = "payments" }
+	NodeMeta map[string]string `mapstructure:"node_meta" json:"node_meta,omitempty"`
+
+	// When the route applies, e.g. outside business hours. A route without any of these
+	// set applies at all times.
+	Days     []string `mapstructure:"days" json:"days,omitempty"`
+	Start    string   `mapstructure:"start" json:"start,omitempty"`
+	End      string   `mapstructure:"end" json:"end,omitempty"`
+	Timezone string   `mapstructure:"timezone" json:"timezone,omitempty"`
+
+	// Whether to keep evaluating the following routes after this one matches, adding
+	// their handlers to this one's
+	Continue bool `mapstructure:"continue" json:"continue,omitempty"`
+
+	serviceRegexp *regexp.Regexp
+	nodeRegexp    *regexp.Regexp
+	schedule      *schedule
+}
+
+// Parse the raw route objects into the config, keeping the order they were declared in
+func parseRoutes(list *ast.ObjectList, config *Config) error {
+	config.Routes = make([]RouteConfig, 0, len(list.Items))
+
+	for _, r := range list.Items {
+		if len(r.Keys) != 1 {
+			return fmt.Errorf("route must be in the form 'route \"name\" {}'")
+		}
+		name := r.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var route RouteConfig
+		if err := hcl.DecodeObject(&m, r.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &route); err != nil {
+			return err
+		}
+		route.Name = name
+
+		if err := route.validate(config); err != nil {
+			return fmt.Errorf("Error loading route %s: %s", name, err)
+		}
+		config.Routes = append(config.Routes, route)
+	}
+
+	return nil
+}
+
+func (route *RouteConfig) validate(config *Config) error {
+	if len(route.Handlers) == 0 {
+		return fmt.Errorf("no handlers set")
+	}
+	for _, name := range route.Handlers {
+		if _, ok := config.Handlers[name]; !ok {
+			return fmt.Errorf("unknown handler %s", name)
+		}
+	}
+
+	for _, status := range route.Statuses {
+		if !contains([]string{api.HealthPassing, api.HealthWarning, api.HealthCritical}, status) {
+			return fmt.Errorf("invalid status %s", status)
+		}
+	}
+
+	var err error
+	if route.serviceRegexp, err = compileRouteRegexp(route.Service); err != nil {
+		return fmt.Errorf("invalid service pattern: %s", err)
+	}
+	if route.nodeRegexp, err = compileRouteRegexp(route.Node); err != nil {
+		return fmt.Errorf("invalid node pattern: %s", err)
+	}
+
+	if (route.Start == "") != (route.End == "") {
+		return fmt.Errorf("start and end must be set together")
+	}
+	if len(route.Days) > 0 || route.Start != "" || route.Timezone != "" {
+		if route.schedule, err = compileSchedule(route.Days, route.Start, route.End, route.Timezone); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Compiles a pattern that has to match a whole name, returning nil for an empty pattern
+func compileRouteRegexp(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// Returns whether the route matches an alert at the given time. A route with statuses set
+// also matches alerts with a previously alerted status it matched, so its handlers receive
+// the updates and recovery of the alerts they were sent.
+func (route *RouteConfig) matches(datacenter string, partition string, service string, node string, nodeMeta map[string]string, tags []string, status string, alerted []string, now time.Time) bool {
+	if route.schedule != nil && !route.schedule.active(now) {
+		return false
+	}
+	if route.Datacenter != "" && route.Datacenter != datacenter {
+		return false
+	}
+	if route.Partition != "" && route.Partition != partition {
+		return false
+	}
+	if route.serviceRegexp != nil && !route.serviceRegexp.MatchString(service) {
+		return false
+	}
+	if route.nodeRegexp != nil && !route.nodeRegexp.MatchString(node) {
+		return false
+	}
+	if !matchesNodeMeta(route.NodeMeta, nodeMeta) {
+		return false
+	}
+	for _, tag := range route.Tags {
+		if !contains(tags, tag) {
+			return false
+		}
+	}
+	if len(route.Statuses) == 0 || contains(route.Statuses, status) {
+		return true
+	}
+	for _, previous := range alerted {
+		if contains(route.Statuses, previous) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the names of the handlers chosen by the routes matching an alert at the given
+// time, and whether any route matched. Routes are evaluated in order, stopping at the
+// first match that doesn't set continue. Alerts are matched against the partition of the
+// config's watches.
+func (c *Config) routeHandlerNames(datacenter string, service string, node string, nodeMeta map[string]string, tags []string, status string, alerted []string, now time.Time) ([]string, bool) {
+	names := make([]string, 0)
+	matched := false
+	partition := c.partition
+	if partition == "" {
+		partition = defaultPartition
+	}
+
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		if !route.matches(datacenter, partition, service, node, nodeMeta, tags, status, alerted, now) {
+			continue
+		}
+
+		matched = true
+		for _, name := range route.Handlers {
+			if !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		if !route.Continue {
+			break
+		}
+	}
+
+	return names, matched
+}
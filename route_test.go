@@ -0,0 +1,171 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoute_handlerNames(t *testing.T) {
+	config, err := ParseConfig(`
+	default_handlers = ["stdout.log"]
+
+	route "payments" {
+		service = "payments(-.*)?"
+		handlers = ["pagerduty.payments", "slack.payments"]
+	}
+
+	route "batch" {
+		tags = ["batch"]
+		status = ["critical"]
+		handlers = ["slack.batch"]
+		continue = true
+	}
+
+	route "edge" {
+		datacenter = "dc2"
+		node = "edge-[0-9]+"
+		handlers = ["slack.edge"]
+	}
+
+	handler "stdout" "log" {}
+	handler "pagerduty" "payments" {
+		service_key = "asdf1234"
+	}
+	handler "slack" "payments" {
+		api_token = "token"
+		channel_name = "payments"
+	}
+	handler "slack" "batch" {
+		api_token = "token"
+		channel_name = "batch"
+	}
+	handler "slack" "edge" {
+		api_token = "token"
+		channel_name = "edge"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		datacenter string
+		service    string
+		node       string
+		tags       []string
+		status     string
+		lastStatus string
+		expected   []string
+	}{
+		// Service patterns have to match the whole name
+		{"dc1", "payments-api", "", nil, "critical", "passing", []string{"pagerduty.payments", "slack.payments"}},
+		{"dc1", "old-payments", "", nil, "critical", "passing", []string{"stdout.log"}},
+
+		// The first match wins, and severity classes still apply
+		{"dc1", "payments", "", []string{"batch"}, "warning", "passing", []string{"slack.payments"}},
+
+		// Status conditions also match the updates and recovery of alerts they matched
+		{"dc1", "reports", "", []string{"batch"}, "warning", "passing", []string{"stdout.log"}},
+		{"dc1", "reports", "", []string{"batch"}, "critical", "passing", []string{"slack.batch"}},
+		{"dc1", "reports", "", []string{"batch"}, "passing", "critical", []string{"slack.batch"}},
+
+		// Continue adds the handlers of later matching routes
+		{"dc2", "reports", "edge-1", []string{"batch"}, "critical", "passing", []string{"slack.batch", "slack.edge"}},
+		{"dc1", "", "edge-1", nil, "critical", "passing", []string{"stdout.log"}},
+	}
+
+	for _, c := range cases {
+		names := config.severityHandlerNames(c.datacenter, c.service, c.node, nil, c.tags, c.status, c.lastStatus)
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%s/%s/%s %v %s->%s: expected handlers %v, got %v", c.datacenter, c.service, c.node, c.tags,
+				c.lastStatus, c.status, c.expected, names)
+		}
+	}
+
+	// The recovery of a critical alert downgraded to warning still matches the critical route
+	names := config.severityHandlerNames("dc1", "reports", "", nil, []string{"batch"}, "passing", "warning", "critical")
+	if !reflect.DeepEqual(names, []string{"slack.batch"}) {
+		t.Errorf("critical->warning->passing: expected the critical route's handlers, got %v", names)
+	}
+}
+
+func TestRoute_schedule(t *testing.T) {
+	config, err := ParseConfig(`
+	route "business-hours" {
+		days = ["mon", "tue", "wed", "thu", "fri"]
+		start = "09:00"
+		end = "17:00"
+		timezone = "Europe/Berlin"
+		handlers = ["slack.ops"]
+	}
+
+	route "after-hours" {
+		handlers = ["slack.ops", "pagerduty.ops"]
+	}
+
+	handler "slack" "ops" {
+		api_token = "token"
+		channel_name = "ops"
+	}
+	handler "pagerduty" "ops" {
+		service_key = "asdf1234"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	cases := []struct {
+		now      time.Time
+		expected []string
+	}{
+		// Wednesday during and after business hours
+		{time.Date(2026, 10, 14, 9, 0, 0, 0, berlin), []string{"slack.ops"}},
+		{time.Date(2026, 10, 14, 17, 0, 0, 0, berlin), []string{"slack.ops", "pagerduty.ops"}},
+		// 12:30 in New York is 18:30 in Berlin
+		{time.Date(2026, 10, 14, 12, 30, 0, 0, time.FixedZone("EDT", -4*3600)), []string{"slack.ops", "pagerduty.ops"}},
+		// Saturday
+		{time.Date(2026, 10, 17, 12, 0, 0, 0, berlin), []string{"slack.ops", "pagerduty.ops"}},
+	}
+
+	for _, c := range cases {
+		names, _ := config.routeHandlerNames("dc1", "web", "", nil, nil, "critical", []string{"passing"}, c.now)
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("at %s: expected handlers %v, got %v", c.now, c.expected, names)
+		}
+	}
+}
+
+func TestRoute_invalid(t *testing.T) {
+	cases := []string{
+		`route "none" {}`,
+		`route "missing" {
+			handlers = ["stdout.missing"]
+		}`,
+		`route "pattern" {
+			service = "("
+			handlers = ["stdout.log"]
+		}`,
+		`route "status" {
+			status = ["failing"]
+			handlers = ["stdout.log"]
+		}`,
+		`route "start" {
+			start = "09:00"
+			handlers = ["stdout.log"]
+		}`,
+		`route "timezone" {
+			days = ["mon"]
+			timezone = "Nowhere/City"
+			handlers = ["stdout.log"]
+		}`,
+	}
+
+	for _, c := range cases {
+		if _, err := ParseConfig(c + "\nhandler \"stdout\" \"log\" {}"); err == nil {
+			t.Errorf("expected an error parsing %s", c)
+		}
+	}
+}
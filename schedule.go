@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var scheduleDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// A recurring weekly time range, running from start to end on each of its days and
+// ending on the next day if end is earlier than start
+type schedule struct {
+	location    *time.Location
+	weekdays    map[time.Weekday]bool
+	startMinute int
+	endMinute   int
+}
+
+// Compiles a schedule from days (every day if empty), start and end times in the form
+// HH:MM (the whole day if both are empty) and an IANA timezone (UTC if empty)
+func compileSchedule(days []string, start string, end string, timezone string) (*schedule, error) {
+	s := &schedule{weekdays: make(map[time.Weekday]bool), endMinute: 24 * 60}
+
+	var err error
+	if s.location, err = time.LoadLocation(timezone); err != nil {
+		return nil, fmt.Errorf("invalid timezone: %s", err)
+	}
+
+	if start != "" || end != "" {
+		if s.startMinute, err = parseScheduleTime(start); err != nil {
+			return nil, fmt.Errorf("invalid start: %s", err)
+		}
+		if s.endMinute, err = parseScheduleTime(end); err != nil {
+			return nil, fmt.Errorf("invalid end: %s", err)
+		}
+		if s.startMinute == s.endMinute {
+			return nil, fmt.Errorf("start and end must differ")
+		}
+	}
+
+	for _, day := range days {
+		weekday, ok := scheduleDays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %s", day)
+		}
+		s.weekdays[weekday] = true
+	}
+
+	return s, nil
+}
+
+// Parses a time of day in the form HH:MM into minutes after midnight
+func parseScheduleTime(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("expected a time in the form HH:MM, got %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Returns whether the schedule is active at the given time
+func (s *schedule) active(now time.Time) bool {
+	local := now.In(s.location)
+	minute := local.Hour()*60 + local.Minute()
+
+	if s.startMinute < s.endMinute {
+		return minute >= s.startMinute && minute < s.endMinute && s.onDay(local.Weekday())
+	}
+
+	// The range crosses midnight, so the early part belongs to the previous day's range
+	if minute >= s.startMinute {
+		return s.onDay(local.Weekday())
+	}
+	return minute < s.endMinute && s.onDay((local.Weekday()+6)%7)
+}
+
+func (s *schedule) onDay(weekday time.Weekday) bool {
+	return len(s.weekdays) == 0 || s.weekdays[weekday]
+}
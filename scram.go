@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Authenticates as a SCRAM client (RFC 5802), using exchange to send each client
+// message and receive the server's reply
+func scramAuthenticate(mechanism string, username string, password string, exchange func([]byte) ([]byte, error)) error {
+	var newHash func() hash.Hash
+	switch mechanism {
+	case "SCRAM-SHA-256":
+		newHash = sha256.New
+	case "SCRAM-SHA-512":
+		newHash = sha512.New
+	default:
+		return fmt.Errorf("unsupported scram mechanism %s", mechanism)
+	}
+
+	nonceBytes := make([]byte, 18)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return err
+	}
+	nonce := base64.StdEncoding.EncodeToString(nonceBytes)
+
+	client := &scramClient{newHash: newHash, username: username, password: password, nonce: nonce}
+	serverFirst, err := exchange([]byte(client.firstMessage()))
+	if err != nil {
+		return err
+	}
+
+	clientFinal, err := client.finalMessage(string(serverFirst))
+	if err != nil {
+		return err
+	}
+
+	serverFinal, err := exchange([]byte(clientFinal))
+	if err != nil {
+		return err
+	}
+
+	return client.verifyServer(string(serverFinal))
+}
+
+// The state of a SCRAM conversation
+type scramClient struct {
+	newHash  func() hash.Hash
+	username string
+	password string
+	nonce    string
+
+	authMessage    string
+	saltedPassword []byte
+}
+
+var scramNameEscaper = strings.NewReplacer("=", "=3D", ",", "=2C")
+
+func (c *scramClient) firstMessageBare() string {
+	return "n=" + scramNameEscaper.Replace(c.username) + ",r=" + c.nonce
+}
+
+func (c *scramClient) firstMessage() string {
+	return "n,," + c.firstMessageBare()
+}
+
+// Computes the client's proof from the server's first message
+func (c *scramClient) finalMessage(serverFirst string) (string, error) {
+	attrs := scramAttributes(serverFirst)
+
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, c.nonce) {
+		return "", fmt.Errorf("server nonce doesn't extend the client nonce")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return "", fmt.Errorf("invalid salt from server: %s", err)
+	}
+
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations < 1 {
+		return "", fmt.Errorf("invalid iteration count from server: %s", attrs["i"])
+	}
+
+	withoutProof := "c=biws,r=" + nonce
+	c.authMessage = c.firstMessageBare() + "," + serverFirst + "," + withoutProof
+	c.saltedPassword = pbkdf2(c.newHash, []byte(c.password), salt, iterations)
+
+	clientKey := c.hmac(c.saltedPassword, "Client Key")
+	storedKey := c.newHash()
+	storedKey.Write(clientKey)
+	signature := c.hmac(storedKey.Sum(nil), c.authMessage)
+
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// Checks the server's signature, proving it also knows the password
+func (c *scramClient) verifyServer(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("server rejected authentication: %s", e)
+	}
+
+	serverKey := c.hmac(c.saltedPassword, "Server Key")
+	expected := base64.StdEncoding.EncodeToString(c.hmac(serverKey, c.authMessage))
+	if !hmac.Equal([]byte(attrs["v"]), []byte(expected)) {
+		return fmt.Errorf("invalid server signature")
+	}
+
+	return nil
+}
+
+func (c *scramClient) hmac(key []byte, data string) []byte {
+	mac := hmac.New(c.newHash, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Parses the comma-separated key=value attributes of a SCRAM message
+func scramAttributes(message string) map[string]string {
+	attrs := make(map[string]string)
+	for _, part := range strings.Split(message, ",") {
+		if kv := strings.SplitN(part, "=", 2); len(kv) == 2 {
+			attrs[kv[0]] = kv[1]
+		}
+	}
+	return attrs
+}
+
+// Derives a key from a password using PBKDF2 (RFC 8018) with a key length equal to
+// the hash size, which is all SCRAM needs
+func pbkdf2(newHash func() hash.Hash, password []byte, salt []byte, iterations int) []byte {
+	mac := hmac.New(newHash, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	result := make([]byte, len(u))
+	copy(result, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(nil)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	handlerSends = metrics.register("consul_alerting_handler_sends_total",
+		"Alerts sent to each handler, by whether they were delivered.", "counter")
+	handlerSendDuration = metrics.registerHistogram("consul_alerting_handler_send_duration_seconds",
+		"How long handlers took to send an alert, including their retries.", latencyBuckets)
+)
+
+// A configured handler, with the name and options it was declared with
+type namedHandler struct {
+	name    string
+	handler AlertHandler
+	options HandlerOptions
+}
+
+func (c *Config) namedHandler(name string) namedHandler {
+	return namedHandler{name: name, handler: c.Handlers[name], options: c.HandlerOptions[name]}
+}
+
+// Sends an alert to the handler, unless its rate limit holds it back
+func (h namedHandler) send(datacenter string, alert *AlertState) {
+	span := startSpan(alert.span, "alert.send", "handler", h.name)
+	if !activeInstance.isActive() {
+		log.Infof("Not sending alert '%s' to handler %s from a standby instance", alert.Message, h.name)
+		span.setAttributes("alert.outcome", "standby")
+		span.finish(nil)
+		return
+	}
+	if h.options.RateLimit > 0 && !handlerRateLimits.allow(h, datacenter, alert, time.Now()) {
+		span.setAttributes("alert.outcome", "rate limited")
+		span.finish(nil)
+		return
+	}
+	if span != nil {
+		traced := *alert
+		traced.span = span
+		alert = &traced
+	}
+	span.finish(h.deliver(datacenter, alert))
+}
+
+// Delivers an alert to the handler. With the persistent queue enabled the alert is queued
+// first and only removed once the handler has delivered it; otherwise alerts the handler
+// fails to deliver go to the dead-letter sinks.
+func (h namedHandler) deliver(datacenter string, alert *AlertState) error {
+	queue := currentQueue()
+	if queue == nil {
+		err := h.call(datacenter, alert)
+		if err != nil {
+			deadLetters.add(h.name, datacenter, alert, err)
+		}
+		return err
+	}
+
+	entry := queue.add(h.name, datacenter, alert)
+	if err := h.call(datacenter, alert); err != nil {
+		log.Warnf("Queued alert '%s' for handler %s to redeliver later", alert.Message, h.name)
+		return err
+	}
+	queue.remove(entry)
+	return nil
+}
+
+// Calls the handler with an alert, unless its circuit breaker is open or this is a dry run
+func (h namedHandler) call(datacenter string, alert *AlertState) error {
+	if isDryRun() {
+		logDryRun(h, datacenter, alert)
+		alert.span.setAttributes("alert.outcome", "dry run")
+		return nil
+	}
+	if h.options.CircuitBreakerThreshold <= 0 {
+		return h.alert(datacenter, alert)
+	}
+
+	if !handlerCircuitBreakers.allow(h, time.Now()) {
+		log.Debugf("Skipping alert '%s' to handler %s, its circuit breaker is open", alert.Message, h.name)
+		auditLog.skipped(h, datacenter, alert)
+		alert.span.setAttributes("alert.outcome", "circuit open")
+		return errCircuitOpen
+	}
+	err := h.alert(datacenter, alert)
+	handlerCircuitBreakers.record(h, err, time.Now())
+	return err
+}
+
+// Calls the handler's Alert method, recording the result and how long it took
+func (h namedHandler) alert(datacenter string, alert *AlertState) error {
+	span := startSpan(alert.span, "handler.alert", "handler", h.name, "handler.type", handlerType(h.handler))
+	if span != nil {
+		traced := *alert
+		traced.span = span
+		alert = &traced
+	}
+	alert, send := auditLog.start(alert)
+	start := time.Now()
+	err := h.alertWithTimeout(datacenter, alert)
+	span.finish(err)
+	handlerSendDuration.observe(time.Since(start).Seconds(), "handler", h.name)
+	auditLog.finish(h, datacenter, alert, send, err)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	handlerSends.add(1, "handler", h.name, "result", result)
+	return err
+}
+
+// Calls the handler's Alert method. With a timeout set, stops waiting for the handler once
+// it runs out so a hung connection can't hold up the alerts behind it; the handler is left
+// to finish in the background.
+func (h namedHandler) alertWithTimeout(datacenter string, alert *AlertState) error {
+	if h.options.SendTimeout <= 0 {
+		return h.handler.Alert(datacenter, alert)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.handler.Alert(datacenter, alert)
+	}()
+
+	timeout := time.Duration(h.options.SendTimeout) * time.Second
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		log.Errorf("Timed out sending alert '%s' to handler %s after %s", alert.Message, h.name, timeout)
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// A handler that blocks until released
+type blockingHandler struct {
+	release chan struct{}
+}
+
+func (h blockingHandler) Alert(datacenter string, alert *AlertState) error {
+	<-h.release
+	return nil
+}
+
+func TestSend_timeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	handler := namedHandler{name: "slow", handler: blockingHandler{release}, options: HandlerOptions{SendTimeout: 1}}
+
+	start := time.Now()
+	handler.send("dc1", &AlertState{Service: "redis", Status: "critical"})
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 2*time.Second {
+		t.Errorf("expected send to give up after the 1s timeout, took %s", elapsed)
+	}
+
+	alertCh := make(chan *AlertState, 1)
+	namedHandler{name: "fast", handler: testHandler{alertCh}, options: HandlerOptions{SendTimeout: 1}}.send("dc1", &AlertState{Service: "redis"})
+	if len(alertCh) != 1 {
+		t.Error("expected the alert to be sent")
+	}
+}
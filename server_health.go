@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The name of the pseudo-service used for alerting on the health of the Consul servers.
+// A service block with this name can be used to configure its handlers and thresholds.
+const serverHealthService = "consul-servers"
+
+// The interval to poll the autopilot health endpoint at, since it doesn't support blocking queries
+const serverHealthInterval = 10 * time.Second
+
+// The autopilot health endpoint returns this status code when the cluster is unhealthy
+const autopilotUnhealthyPrefix = "Unexpected response code: 429 ("
+
+// The response from the /v1/operator/autopilot/health endpoint
+type autopilotHealth struct {
+	Healthy          bool
+	FailureTolerance int
+	Servers          []autopilotServerHealth
+}
+
+// The health of a single server, as reported by autopilot
+type autopilotServerHealth struct {
+	ID          string
+	Name        string
+	Address     string
+	SerfStatus  string
+	Version     string
+	Leader      bool
+	LastContact string
+	Healthy     bool
+	Voter       bool
+}
+
+// Fetches the autopilot health of the servers, which requires operator:read
+func getAutopilotHealth(client *api.Client, queryOpts *api.QueryOptions) (*autopilotHealth, error) {
+	health := &autopilotHealth{}
+	_, err := client.Raw().Query("/v1/operator/autopilot/health", health, queryOpts)
+
+	// The health is still returned in the body when the cluster is unhealthy, so parse
+	// it out of the error
+	if err != nil && strings.HasPrefix(err.Error(), autopilotUnhealthyPrefix) {
+		body := strings.TrimSuffix(strings.TrimPrefix(err.Error(), autopilotUnhealthyPrefix), ")")
+		if jsonErr := json.Unmarshal([]byte(body), health); jsonErr == nil {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching autopilot health: %s", err)
+	}
+
+	return health, nil
+}
+
+// Returns a health check of the consul-servers pseudo-service
+func newServerHealthCheck(node, checkID, status, output string) *api.HealthCheck {
+	return &api.HealthCheck{
+		Node:        node,
+		CheckID:     checkID,
+		Name:        checkID,
+		Status:      status,
+		Output:      output,
+		ServiceID:   serverHealthService,
+		ServiceName: serverHealthService,
+	}
+}
+
+// Converts the autopilot health of the servers into health checks, so they can be
+// watched the same way as a regular service. Each server gets an "autopilot" check,
+// and a "cluster" node holds checks for the leader and quorum.
+func serverHealthChecks(health *autopilotHealth) []*api.HealthCheck {
+	checks := make([]*api.HealthCheck, 0, len(health.Servers)+2)
+	newCheck := newServerHealthCheck
+
+	hasLeader := false
+	voters, healthyVoters := 0, 0
+
+	for _, server := range health.Servers {
+		role := "non-voter"
+		if server.Voter {
+			role = "voter"
+			voters++
+			if server.Healthy {
+				healthyVoters++
+			}
+		}
+		if server.Leader {
+			hasLeader = true
+			role = role + ", leader"
+		}
+
+		status := api.HealthPassing
+		state := "healthy"
+		if !server.Healthy {
+			status = api.HealthCritical
+			state = "unhealthy"
+		}
+
+		output := fmt.Sprintf("Server %s (%s, %s) is %s: serf status %s, last contact %s",
+			server.Name, server.Address, role, state, server.SerfStatus, server.LastContact)
+		checks = append(checks, newCheck(server.Name, "autopilot", status, output))
+	}
+
+	if hasLeader {
+		checks = append(checks, newCheck("cluster", "leader", api.HealthPassing, "The cluster has a leader"))
+	} else {
+		checks = append(checks, newCheck("cluster", "leader", api.HealthCritical, "The cluster has no leader"))
+	}
+
+	quorum := voters/2 + 1
+	output := fmt.Sprintf("%d of %d voters are healthy (quorum: %d, failure tolerance: %d)",
+		healthyVoters, voters, quorum, health.FailureTolerance)
+	status := api.HealthPassing
+	if healthyVoters < quorum {
+		status = api.HealthCritical
+	} else if health.FailureTolerance == 0 && voters > 1 {
+		status = api.HealthWarning
+	}
+	checks = append(checks, newCheck("cluster", "quorum", status, output))
+
+	return checks
+}
+
+// Fetches the address of the raft leader and of the raft peers from the status endpoints
+func getRaftStatus(client *api.Client, queryOpts *api.QueryOptions) (string, []string, error) {
+	var leader string
+	if _, err := client.Raw().Query("/v1/status/leader", &leader, queryOpts); err != nil {
+		return "", nil, fmt.Errorf("Error fetching raft leader: %s", err)
+	}
+
+	var peers []string
+	if _, err := client.Raw().Query("/v1/status/peers", &peers, queryOpts); err != nil {
+		return "", nil, fmt.Errorf("Error fetching raft peers: %s", err)
+	}
+
+	return leader, peers, nil
+}
+
+// Tracks the raft leader and peers across polls of the server health, kept by the watch
+// of the consul-servers pseudo-service
+type raftTracker struct {
+	// The last seen leader and when it last changed
+	leader        string
+	leaderChanged time.Time
+
+	// Every peer seen since the watch started
+	peers []string
+}
+
+// Returns the "leader_change" check, which is warning for server_leader_stable_time
+// after the leader changes, and the "raft_peers" check, which is warning while there are
+// fewer raft peers than server_raft_peers, or than the most seen if it isn't set
+func (r *raftTracker) checks(config *Config, leader string, peers []string, now time.Time) []*api.HealthCheck {
+	checks := make([]*api.HealthCheck, 0, 2)
+
+	if leader != "" && r.leader != "" && leader != r.leader {
+		log.Infof("Raft leader changed from %s to %s", r.leader, leader)
+		r.leaderChanged = now
+	}
+	if leader != "" {
+		r.leader = leader
+	}
+
+	stableTime := time.Duration(config.ServerLeaderStableTime) * time.Second
+	if !r.leaderChanged.IsZero() && now.Sub(r.leaderChanged) < stableTime {
+		output := fmt.Sprintf("The leader changed to %s at %s", r.leader, r.leaderChanged.Format(time.RFC3339))
+		checks = append(checks, newServerHealthCheck("cluster", "leader_change", api.HealthWarning, output))
+	} else {
+		checks = append(checks, newServerHealthCheck("cluster", "leader_change", api.HealthPassing, "The leader is "+r.leader))
+	}
+
+	for _, peer := range peers {
+		if !contains(r.peers, peer) {
+			r.peers = append(r.peers, peer)
+		}
+	}
+	sort.Strings(r.peers)
+
+	expected := len(r.peers)
+	if config.ServerRaftPeers > 0 {
+		expected = config.ServerRaftPeers
+	}
+	missing := make([]string, 0)
+	for _, peer := range r.peers {
+		if !contains(peers, peer) {
+			missing = append(missing, peer)
+		}
+	}
+
+	status := api.HealthPassing
+	output := fmt.Sprintf("%d of %d raft peers are in the configuration", len(peers), expected)
+	if len(peers) < expected {
+		status = api.HealthWarning
+	}
+	if len(missing) > 0 {
+		output += ", missing: " + strings.Join(missing, ", ")
+	}
+	checks = append(checks, newServerHealthCheck("cluster", "raft_peers", status, output))
+
+	return checks
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestServerHealth_checks(t *testing.T) {
+	health := &autopilotHealth{
+		Healthy:          false,
+		FailureTolerance: 0,
+		Servers: []autopilotServerHealth{
+			{Name: "server1", Address: "10.0.0.1:8300", Voter: true, Leader: true, Healthy: true, SerfStatus: "alive"},
+			{Name: "server2", Address: "10.0.0.2:8300", Voter: true, Healthy: true, SerfStatus: "alive"},
+			{Name: "server3", Address: "10.0.0.3:8300", Voter: true, Healthy: false, SerfStatus: "failed"},
+		},
+	}
+
+	statuses := make(map[string]string)
+	for _, check := range serverHealthChecks(health) {
+		if check.ServiceName != serverHealthService {
+			t.Errorf("expected check %s to belong to %s, got %s", check.CheckID, serverHealthService, check.ServiceName)
+		}
+		statuses[check.Node+"/"+check.CheckID] = check.Status
+	}
+
+	expected := map[string]string{
+		"server1/autopilot": api.HealthPassing,
+		"server2/autopilot": api.HealthPassing,
+		"server3/autopilot": api.HealthCritical,
+		"cluster/leader":    api.HealthPassing,
+		"cluster/quorum":    api.HealthWarning,
+	}
+	for check, status := range expected {
+		if statuses[check] != status {
+			t.Errorf("expected %s to be %s, got %s", check, status, statuses[check])
+		}
+	}
+
+	// Losing another voter and the leader should lose quorum
+	health.Servers[0].Healthy = false
+	health.Servers[0].Leader = false
+	for _, check := range serverHealthChecks(health) {
+		if check.Node == "cluster" && check.Status != api.HealthCritical {
+			t.Errorf("expected %s to be critical, got %s", check.CheckID, check.Status)
+		}
+	}
+}
+
+func TestServerHealth_raftChecks(t *testing.T) {
+	config := &Config{ServerLeaderStableTime: 300}
+	raft := &raftTracker{}
+	now := time.Now()
+	peers := []string{"10.0.0.1:8300", "10.0.0.2:8300", "10.0.0.3:8300"}
+
+	statuses := func(checks []*api.HealthCheck) map[string]string {
+		result := make(map[string]string)
+		for _, check := range checks {
+			result[check.CheckID] = check.Status
+		}
+		return result
+	}
+
+	expect := func(checks []*api.HealthCheck, leaderChange string, raftPeers string) {
+		t.Helper()
+		got := statuses(checks)
+		if got["leader_change"] != leaderChange || got["raft_peers"] != raftPeers {
+			t.Errorf("expected leader_change %s and raft_peers %s, got %v", leaderChange, raftPeers, got)
+		}
+	}
+
+	expect(raft.checks(config, "10.0.0.1:8300", peers, now), api.HealthPassing, api.HealthPassing)
+
+	// A new leader is warning until it has been stable for server_leader_stable_time
+	expect(raft.checks(config, "10.0.0.2:8300", peers, now.Add(time.Minute)), api.HealthWarning, api.HealthPassing)
+	expect(raft.checks(config, "10.0.0.2:8300", peers, now.Add(5*time.Minute)), api.HealthWarning, api.HealthPassing)
+	expect(raft.checks(config, "10.0.0.2:8300", peers, now.Add(7*time.Minute)), api.HealthPassing, api.HealthPassing)
+
+	// Losing a peer is warning until it comes back
+	checks := raft.checks(config, "10.0.0.2:8300", peers[1:], now.Add(8*time.Minute))
+	expect(checks, api.HealthPassing, api.HealthWarning)
+	for _, check := range checks {
+		if check.CheckID == "raft_peers" && check.Output != "2 of 3 raft peers are in the configuration, missing: 10.0.0.1:8300" {
+			t.Errorf("unexpected output: %s", check.Output)
+		}
+	}
+	expect(raft.checks(config, "10.0.0.2:8300", peers, now.Add(9*time.Minute)), api.HealthPassing, api.HealthPassing)
+
+	// With server_raft_peers set, fewer peers than the most seen are expected after
+	// scaling down
+	config.ServerRaftPeers = 2
+	expect(raft.checks(config, "10.0.0.2:8300", peers[1:], now.Add(10*time.Minute)), api.HealthPassing, api.HealthPassing)
+}
+
+func TestServerHealth_handlers(t *testing.T) {
+	config, err := ParseConfig(`
+default_handlers = ["stdout.default"]
+server_health_handlers = ["stdout.servers"]
+
+handler "stdout" "default" {}
+handler "stdout" "servers" {}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := config.severityHandlerNames("dc1", serverHealthService, "cluster", nil, nil, "critical", "passing"); !reflect.DeepEqual(names, []string{"stdout.servers"}) {
+		t.Errorf("expected the server health handlers, got %v", names)
+	}
+	if names := config.severityHandlerNames("dc1", "redis", "", nil, nil, "critical", "passing"); !reflect.DeepEqual(names, []string{"stdout.default"}) {
+		t.Errorf("expected the default handlers for other services, got %v", names)
+	}
+
+	if _, err := ParseConfig(`server_health_handlers = ["stdout.missing"]`); err == nil || !strings.Contains(err.Error(), "server_health_handlers") {
+		t.Errorf("expected an error for an unknown handler, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// A filter choosing the services that get watches, in the datacenter it's named after or
+// in every datacenter if it's named "*". A service is watched if it matches every filter
+// that applies.
+type ServiceFilterConfig struct {
+	Datacenter  string   `json:"datacenter"`
+	Include     []string `mapstructure:"include" json:"include,omitempty"`
+	Exclude     []string `mapstructure:"exclude" json:"exclude,omitempty"`
+	IncludeTags []string `mapstructure:"include_tags" json:"include_tags,omitempty"`
+	ExcludeTags []string `mapstructure:"exclude_tags" json:"exclude_tags,omitempty"`
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// Parse the raw service_filter objects into the config
+func parseServiceFilters(list *ast.ObjectList, config *Config) error {
+	config.ServiceFilters = make([]ServiceFilterConfig, 0, len(list.Items))
+
+	for _, f := range list.Items {
+		if len(f.Keys) != 1 {
+			return fmt.Errorf("service_filter must be in the form 'service_filter \"datacenter\" {}'")
+		}
+
+		var m map[string]interface{}
+		var filter ServiceFilterConfig
+		if err := hcl.DecodeObject(&m, f.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &filter); err != nil {
+			return err
+		}
+		filter.Datacenter = f.Keys[0].Token.Value().(string)
+
+		var err error
+		if filter.include, err = compileIgnorePatterns("include", filter.Include); err != nil {
+			return fmt.Errorf("Error loading service_filter %s: %s", filter.Datacenter, err)
+		}
+		if filter.exclude, err = compileIgnorePatterns("exclude", filter.Exclude); err != nil {
+			return fmt.Errorf("Error loading service_filter %s: %s", filter.Datacenter, err)
+		}
+		config.ServiceFilters = append(config.ServiceFilters, filter)
+	}
+
+	return nil
+}
+
+// Returns whether a service with the given tags passes the filter. A service must match
+// one of the include patterns and have one of the include_tags if they're set, and mustn't
+// match an exclude pattern or have one of the exclude_tags.
+func (filter *ServiceFilterConfig) matches(service string, tags []string) bool {
+	if len(filter.include) > 0 && !matchesAny(filter.include, service) {
+		return false
+	}
+	if matchesAny(filter.exclude, service) {
+		return false
+	}
+
+	if len(filter.IncludeTags) > 0 {
+		included := false
+		for _, tag := range filter.IncludeTags {
+			if contains(tags, tag) {
+				included = true
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, tag := range filter.ExcludeTags {
+		if contains(tags, tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns whether a service with the given tags gets a watch, passing every service filter
+// that isn't for another datacenter and not having opted out with an "alerting.enabled=false" tag
+func (c *Config) watchesService(service string, tags []string) bool {
+	if contains(tags, enabledTag) {
+		return false
+	}
+	for i := range c.ServiceFilters {
+		filter := &c.ServiceFilters[i]
+		if filter.Datacenter != AllDatacenters && filter.Datacenter != c.ConsulDatacenter {
+			continue
+		}
+		if !filter.matches(service, tags) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServiceFilter_watchesService(t *testing.T) {
+	config, err := ParseConfig(`
+service_filter "*" {
+  exclude = ["ci-.*"]
+  exclude_tags = ["ephemeral"]
+}
+
+service_filter "dc2" {
+  include = ["web.*", "redis"]
+  include_tags = ["prod"]
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.ServiceFilters) != 2 || config.ServiceFilters[1].Datacenter != "dc2" {
+		t.Fatalf("expected a global and a dc2 filter, got %+v", config.ServiceFilters)
+	}
+
+	cases := []struct {
+		datacenter string
+		service    string
+		tags       []string
+		expected   bool
+	}{
+		{"dc1", "redis", nil, true},
+		{"dc1", "ci-1234", nil, false},
+		{"dc1", "redis", []string{"ephemeral"}, false},
+		{"dc2", "redis", []string{"prod"}, true},
+		{"dc2", "redis", []string{"staging"}, false},
+		{"dc2", "webapp", []string{"prod", "v2"}, true},
+		{"dc2", "nginx", []string{"prod"}, false},
+		{"dc2", "ci-web", []string{"prod"}, false},
+	}
+	for _, c := range cases {
+		config.ConsulDatacenter = c.datacenter
+		if watched := config.watchesService(c.service, c.tags); watched != c.expected {
+			t.Errorf("%s %s %v: expected watched to be %v, got %v", c.datacenter, c.service, c.tags, c.expected, watched)
+		}
+	}
+
+	if _, err := ParseConfig(`service_filter "*" { include = ["("] }`); err == nil || !strings.Contains(err.Error(), "service_filter") {
+		t.Errorf("expected an error for an invalid pattern, got %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The KV path the instances of the services are kept under between restarts, along with
+// the lock electing the instance that watches them
+const serviceRegistrationsKVPath = alertingKVRoot + "/service-registrations"
+
+// The instances (as node/service ID) of each service last seen in the catalog, and the
+// services that left it and are still alerting until they register again
+type serviceRegistrationState struct {
+	Services     map[string][]string `json:"services"`
+	Deregistered []string            `json:"deregistered"`
+}
+
+// A service gaining or losing instances, registering or leaving the catalog, and the
+// status to alert it with
+type serviceRegistrationChange struct {
+	service     string
+	status      string
+	lastAlerted string
+	instances   int
+	added       []string
+	removed     []string
+}
+
+// Returns the changes between the stored instances of the services and the ones currently
+// in the catalog, along with the state to store next. Services matching one of the ignored
+// patterns are left out.
+func diffServiceRegistrations(state *serviceRegistrationState, services map[string][]string, ignored []*regexp.Regexp) ([]serviceRegistrationChange, *serviceRegistrationState) {
+	next := &serviceRegistrationState{Services: make(map[string][]string), Deregistered: make([]string, 0)}
+	names := make([]string, 0, len(services))
+	for service, instances := range services {
+		if matchesAny(ignored, service) || len(instances) == 0 {
+			continue
+		}
+		sorted := append([]string{}, instances...)
+		sort.Strings(sorted)
+		next.Services[service] = sorted
+		names = append(names, service)
+	}
+	sort.Strings(names)
+
+	changes := make([]serviceRegistrationChange, 0)
+	for _, service := range names {
+		instances := next.Services[service]
+		previous, known := state.Services[service]
+		change := serviceRegistrationChange{
+			service:     service,
+			status:      api.HealthPassing,
+			lastAlerted: api.HealthPassing,
+			instances:   len(instances),
+		}
+
+		switch {
+		case contains(state.Deregistered, service):
+			change.lastAlerted = api.HealthCritical
+		case !known:
+		default:
+			for _, instance := range instances {
+				if !contains(previous, instance) {
+					change.added = append(change.added, instance)
+				}
+			}
+			for _, instance := range previous {
+				if !contains(instances, instance) {
+					change.removed = append(change.removed, instance)
+				}
+			}
+			if len(change.added) == 0 && len(change.removed) == 0 {
+				continue
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	previousNames := make([]string, 0, len(state.Services))
+	for service := range state.Services {
+		previousNames = append(previousNames, service)
+	}
+	sort.Strings(previousNames)
+	for _, service := range previousNames {
+		if _, ok := next.Services[service]; ok || matchesAny(ignored, service) {
+			continue
+		}
+		changes = append(changes, serviceRegistrationChange{
+			service:     service,
+			status:      api.HealthCritical,
+			lastAlerted: api.HealthPassing,
+			removed:     state.Services[service],
+		})
+		next.Deregistered = append(next.Deregistered, service)
+	}
+	for _, service := range state.Deregistered {
+		if _, ok := next.Services[service]; !ok && !matchesAny(ignored, service) && !contains(next.Deregistered, service) {
+			next.Deregistered = append(next.Deregistered, service)
+		}
+	}
+	sort.Strings(next.Deregistered)
+
+	return changes, next
+}
+
+// Sends the alert for a service gaining or losing instances, or registering in or leaving
+// the catalog. Services that left the catalog stay alerting until they register again.
+func notifyServiceRegistration(config *Config, change serviceRegistrationChange) {
+	datacenter := config.ConsulDatacenter
+	alert := &AlertState{
+		Status:      change.status,
+		Service:     change.service,
+		Check:       "registration",
+		LastAlerted: change.lastAlerted,
+	}
+	switch {
+	case change.status == api.HealthCritical:
+		alert.Message = fmt.Sprintf("[%s] service %s was deregistered from the catalog, no instances are left", datacenter, change.service)
+	case change.lastAlerted == api.HealthCritical:
+		alert.Message = fmt.Sprintf("[%s] service %s registered in the catalog again with %d instances", datacenter, change.service, change.instances)
+	case len(change.added) == 0 && len(change.removed) == 0:
+		alert.Message = fmt.Sprintf("[%s] service %s registered in the catalog with %d instances", datacenter, change.service, change.instances)
+	default:
+		alert.Message = fmt.Sprintf("[%s] service %s now has %d instances", datacenter, change.service, change.instances)
+	}
+
+	details := ""
+	if len(change.added) > 0 {
+		details += "Registered instances:\n=> " + strings.Join(change.added, "\n=> ") + "\n"
+	}
+	if len(change.removed) > 0 {
+		details += "Deregistered instances:\n=> " + strings.Join(change.removed, "\n=> ")
+	}
+	alert.Details = strings.TrimSpace(details)
+
+	alert.Severity = config.alertSeverity(change.service, alert.Status)
+	config.applyMessageTemplates(change.service, alert)
+	notifyHandlers(config, change.service, nil, alert, change.lastAlerted)
+	activeAlerts.update(datacenter, alert)
+	countAlert(alert)
+	history.record(datacenter, alert, time.Now())
+}
+
+// Watches the services in the catalog and alerts when they gain or lose instances, or
+// register or leave the catalog. The instances found on the first run are only stored,
+// without alerting.
+func watchServiceRegistrations(config *Config, client *api.Client, stopCh chan struct{}) {
+	watchRegistrations("service registrations", serviceRegistrationsKVPath, config, client, stopCh,
+		func(config *Config, queryOpts *api.QueryOptions) (*api.QueryMeta, error) {
+			services, queryMeta, err := client.Catalog().Services(queryOpts)
+			if err != nil {
+				return nil, err
+			}
+
+			// Look up the instances of each service, which the services endpoint doesn't list
+			instances := make(map[string][]string)
+			for service := range services {
+				if matchesAny(config.serviceRegistrationsIgnore, service) {
+					continue
+				}
+				catalogServices, _, err := client.Catalog().Service(service, "", config.queryOptions())
+				if err != nil {
+					return queryMeta, err
+				}
+				for _, instance := range catalogServices {
+					instances[service] = append(instances[service], instance.Node+"/"+instance.ServiceID)
+				}
+			}
+			return queryMeta, updateServiceRegistrations(config, client, instances)
+		})
+}
+
+// Compares the instances of the services in the catalog to the stored ones, storing them
+// and alerting on the changes since
+func updateServiceRegistrations(config *Config, client *api.Client, instances map[string][]string) error {
+	state := &serviceRegistrationState{}
+	found, err := getRegistrationState(client, serviceRegistrationsKVPath, state)
+	if err != nil {
+		return err
+	}
+
+	changes, next := diffServiceRegistrations(state, instances, config.serviceRegistrationsIgnore)
+	if len(changes) == 0 && found {
+		return nil
+	}
+	if err := setRegistrationState(client, serviceRegistrationsKVPath, next); err != nil {
+		return err
+	}
+
+	if !found {
+		log.Infof("Stored the instances of the %d services in the catalog for alerting on service registrations", len(next.Services))
+		return nil
+	}
+	for _, change := range changes {
+		log.Debugf("Service %s changed registration (%s, %d instances)", change.service, change.status, change.instances)
+		notifyServiceRegistration(config, change)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestServiceRegistrations_diff(t *testing.T) {
+	ignored, _ := compileRouteRegexp("ci-.*")
+	state := &serviceRegistrationState{
+		Services: map[string][]string{
+			"redis": {"node1/redis", "node2/redis"},
+			"nginx": {"node1/nginx"},
+			"ci-42": {"node3/ci-42"},
+		},
+		Deregistered: []string{"consul-ui"},
+	}
+
+	changes, next := diffServiceRegistrations(state, map[string][]string{
+		"redis":     {"node2/redis", "node3/redis"},
+		"consul-ui": {"node1/consul-ui"},
+		"web":       {"node1/web"},
+		"ci-43":     {"node3/ci-43"},
+	}, []*regexp.Regexp{ignored})
+
+	expected := []serviceRegistrationChange{
+		{service: "consul-ui", status: api.HealthPassing, lastAlerted: api.HealthCritical, instances: 1},
+		{service: "redis", status: api.HealthPassing, lastAlerted: api.HealthPassing, instances: 2, added: []string{"node3/redis"}, removed: []string{"node1/redis"}},
+		{service: "web", status: api.HealthPassing, lastAlerted: api.HealthPassing, instances: 1},
+		{service: "nginx", status: api.HealthCritical, lastAlerted: api.HealthPassing, removed: []string{"node1/nginx"}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes:\n%+v\ngot:\n%+v", expected, changes)
+	}
+	if _, ok := next.Services["ci-43"]; ok || len(next.Services) != 3 {
+		t.Errorf("expected the ignored services to be left out, got %v", next.Services)
+	}
+	if !reflect.DeepEqual(next.Deregistered, []string{"nginx"}) {
+		t.Errorf("expected nginx to stay deregistered, got %v", next.Deregistered)
+	}
+
+	// Nothing changes while the instances stay the same
+	if changes, _ := diffServiceRegistrations(next, map[string][]string{
+		"redis":     {"node3/redis", "node2/redis"},
+		"consul-ui": {"node1/consul-ui"},
+		"web":       {"node1/web"},
+	}, nil); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+func TestServiceRegistrations_notify(t *testing.T) {
+	config, alertCh := testAlertConfig()
+	config.ConsulDatacenter = "dc1"
+	config.DefaultHandlers = []string{"test"}
+
+	notifyServiceRegistration(config, serviceRegistrationChange{
+		service:     "redis",
+		status:      api.HealthPassing,
+		lastAlerted: api.HealthPassing,
+		instances:   2,
+		added:       []string{"node3/redis"},
+		removed:     []string{"node1/redis"},
+	})
+	alert := <-alertCh
+	if alert.Message != "[dc1] service redis now has 2 instances" {
+		t.Errorf("unexpected message: %s", alert.Message)
+	}
+	if alert.Details != "Registered instances:\n=> node3/redis\nDeregistered instances:\n=> node1/redis" {
+		t.Errorf("unexpected details: %s", alert.Details)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Sends an email through the SES query api's SendRawEmail action. The recipients are
+// passed explicitly since the raw message has its Bcc header stripped.
+func (handler EmailHandler) sendSES(from string, to []string, msg io.WriterTo) error {
+	creds, err := handler.credentials.get()
+	if err != nil {
+		return err
+	}
+
+	var raw bytes.Buffer
+	if _, err := msg.WriteTo(&raw); err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("Action", "SendRawEmail")
+	form.Set("Version", "2010-12-01")
+	form.Set("RawMessage.Data", base64.StdEncoding.EncodeToString(raw.Bytes()))
+	for i, destination := range to {
+		form.Set(fmt.Sprintf("Destinations.member.%d", i+1), destination)
+	}
+	body := []byte(form.Encode())
+
+	req, err := http.NewRequest("POST", handler.SESEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	awsSignRequest(req, body, creds, handler.Region, "ses", time.Now())
+
+	_, err = handler.doRequest(req)
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_emailSES(t *testing.T) {
+	var forms []map[string][]string
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	handler := EmailHandler{
+		Recipients:      []string{"admin@example.com", "ops@example.org"},
+		SendMode:        EmailSendBcc,
+		Transport:       EmailTransportSES,
+		FromAddress:     "alerts@example.com",
+		Region:          "eu-west-1",
+		SESEndpoint:     server.URL,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Status: "critical", Message: "redis is critical", Details: "check failed"})
+
+	if len(forms) != 1 {
+		t.Fatalf("expected one email sent for bcc mode, got %d", len(forms))
+	}
+	form := forms[0]
+	if form["Action"][0] != "SendRawEmail" {
+		t.Errorf("unexpected action: %v", form["Action"])
+	}
+
+	destinations := []string{form["Destinations.member.1"][0], form["Destinations.member.2"][0], form["Destinations.member.3"][0]}
+	if destinations[0] != "alerts@example.com" || destinations[1] != "admin@example.com" || destinations[2] != "ops@example.org" {
+		t.Errorf("unexpected destinations: %v", destinations)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(form["RawMessage.Data"][0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "alerts@example.com") || !strings.Contains(string(raw), "Subject: redis is critical") {
+		t.Errorf("unexpected raw message: %s", raw)
+	}
+	if strings.Contains(string(raw), "Bcc") {
+		t.Errorf("expected the bcc header to be stripped from the raw message: %s", raw)
+	}
+
+	if !strings.Contains(auth, "/eu-west-1/ses/") {
+		t.Errorf("unexpected authorization header: %s", auth)
+	}
+}
+
+func TestHandler_emailSESInvalid(t *testing.T) {
+	cases := []EmailHandler{
+		{SendMode: EmailSendIndividual, Transport: "sendmail"},
+		{SendMode: EmailSendIndividual, Transport: EmailTransportSES},
+		{SendMode: EmailSendIndividual, Transport: EmailTransportSES, Region: "us-east-1", AccessKeyID: "key"},
+		{SendMode: EmailSendIndividual, Transport: EmailTransportSESSMTP, Region: "us-east-1"},
+	}
+
+	for _, handler := range cases {
+		if err := handler.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", handler)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Severity levels, in increasing order of urgency
+const SeverityInfo = "info"
+const SeverityWarning = "warning"
+const SeverityCritical = "critical"
+
+var severityLevels = []string{SeverityInfo, SeverityWarning, SeverityCritical}
+
+// The severity of alerts of each status, unless overridden by the service
+var defaultSeverities = map[string]string{
+	api.HealthPassing:  SeverityInfo,
+	api.HealthWarning:  SeverityWarning,
+	api.HealthCritical: SeverityCritical,
+}
+
+// Returns the position of a severity level in severityLevels, or -1 if it isn't one
+func severityRank(severity string) int {
+	for i, level := range severityLevels {
+		if level == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the severity of an alert with the given status on a service, using the
+// service's severities block if it overrides the status
+func (c *Config) alertSeverity(service string, status string) string {
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		if severity, ok := serviceConfig.Severities[status]; ok {
+			return severity
+		}
+	}
+
+	if severity, ok := defaultSeverities[status]; ok {
+		return severity
+	}
+	return SeverityCritical
+}
+
+// Returns whether a handler's min_severity allows it to receive an alert. Like severity
+// classes, a handler that was sent one of the previously alerted statuses keeps receiving
+// updates, and receives the recovery, even after the alert was downgraded below it.
+func (c *Config) meetsMinSeverity(name string, service string, status string, alerted []string) bool {
+	min := severityRank(c.HandlerOptions[name].MinSeverity)
+	if min <= 0 {
+		return true
+	}
+
+	for _, previous := range alerted {
+		if previous != "" && previous != api.HealthPassing && severityRank(c.alertSeverity(service, previous)) >= min {
+			return true
+		}
+	}
+	return status != api.HealthPassing && severityRank(c.alertSeverity(service, status)) >= min
+}
+
+// Makes sure a severity mapping only maps alerting statuses to valid severity levels
+func validateSeverities(severities map[string]string) error {
+	for status, severity := range severities {
+		if _, ok := defaultSeverityClasses[status]; !ok {
+			return fmt.Errorf("Invalid status in severities: %s", status)
+		}
+		if severityRank(severity) < 0 {
+			return fmt.Errorf("Invalid severity for %s: %s", status, severity)
+		}
+	}
+	return nil
+}
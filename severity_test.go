@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeverity_minSeverity(t *testing.T) {
+	config, err := ParseConfig(`
+	service "payments" {
+		severities {
+			warning = "critical"
+		}
+	}
+
+	handler "stdout" "log" {}
+
+	handler "slack" "alerts" {
+		api_token = "token"
+		channel_name = "alerts"
+		min_severity = "warning"
+	}
+
+	handler "pagerduty" "page_ops" {
+		service_key = "asdf1234"
+		class = "notify"
+		min_severity = "critical"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service    string
+		status     string
+		lastStatus string
+		expected   []string
+	}{
+		{"redis", "warning", "passing", []string{"slack.alerts", "stdout.log"}},
+		{"redis", "critical", "passing", []string{"pagerduty.page_ops", "slack.alerts", "stdout.log"}},
+
+		// Recoveries only go to handlers that received the alert
+		{"redis", "passing", "warning", []string{"slack.alerts", "stdout.log"}},
+		{"redis", "passing", "critical", []string{"pagerduty.page_ops", "slack.alerts", "stdout.log"}},
+
+		// A paged handler keeps receiving updates after a downgrade
+		{"redis", "warning", "critical", []string{"pagerduty.page_ops", "slack.alerts", "stdout.log"}},
+
+		// The service raises the severity of its warnings
+		{"payments", "warning", "passing", []string{"pagerduty.page_ops", "slack.alerts", "stdout.log"}},
+	}
+
+	for _, c := range cases {
+		names := config.severityHandlerNames("dc1", c.service, "", nil, nil, c.status, c.lastStatus)
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%s %s->%s: expected handlers %v, got %v", c.service, c.lastStatus, c.status, c.expected, names)
+		}
+	}
+
+	// The paged handler also gets the recovery of an alert downgraded below its min_severity
+	alert := &AlertState{}
+	recordAlerted(alert, "critical")
+	recordAlerted(alert, "warning")
+	names := config.severityHandlerNames("dc1", "redis", "", nil, nil, "passing", alertedStatuses(alert, "warning")...)
+	if !reflect.DeepEqual(names, []string{"pagerduty.page_ops", "slack.alerts", "stdout.log"}) {
+		t.Errorf("critical->warning->passing: expected handlers %v, got %v", []string{"pagerduty.page_ops", "slack.alerts", "stdout.log"}, names)
+	}
+
+	if severity := config.alertSeverity("payments", "warning"); severity != SeverityCritical {
+		t.Errorf("expected severity %s, got %s", SeverityCritical, severity)
+	}
+	if severity := config.alertSeverity("redis", "passing"); severity != SeverityInfo {
+		t.Errorf("expected severity %s, got %s", SeverityInfo, severity)
+	}
+}
+
+func TestSeverity_invalid(t *testing.T) {
+	cases := []string{
+		`handler "stdout" "log" {
+			min_severity = "urgent"
+		}`,
+		`service "redis" {
+			severities {
+				warning = "urgent"
+			}
+		}`,
+		`service "redis" {
+			severities {
+				passing = "warning"
+			}
+		}`,
+	}
+
+	for _, c := range cases {
+		if _, err := ParseConfig(c); err == nil {
+			t.Errorf("expected an error parsing %s", c)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The KV prefix the instances register under with sharding enabled, each holding its key
+// with a session, and the TTL of the sessions
+const (
+	shardsKVPath    = alertingKVRoot + "/shards/"
+	shardSessionTTL = "10s"
+)
+
+// The instances sharing the watches with sharding enabled. Each service and node is
+// watched by a single instance, chosen by rendezvous hashing of its name over the
+// instances, so only the share of an instance that joins or leaves moves to the others.
+type shardMembership struct {
+	sync.Mutex
+
+	enabled bool
+	self    string
+	members []string
+}
+
+var shards = &shardMembership{}
+
+// Starts sharding the watches, with this instance known by the node it runs on. Nothing
+// is owned until the instance sees itself among the members.
+func (s *shardMembership) enable(self string) {
+	s.Lock()
+	defer s.Unlock()
+	s.enabled = true
+	s.self = self
+}
+
+// Replaces the members, returning whether they changed
+func (s *shardMembership) setMembers(members []string) bool {
+	s.Lock()
+	defer s.Unlock()
+	sort.Strings(members)
+	if strings.Join(members, ",") == strings.Join(s.members, ",") {
+		return false
+	}
+	s.members = members
+	return true
+}
+
+// Returns whether this instance watches the service or node with the given name, which
+// is always the case without sharding
+func (s *shardMembership) owns(name string) bool {
+	s.Lock()
+	defer s.Unlock()
+	if !s.enabled {
+		return true
+	}
+	return shardOwner(s.members, name) == s.self
+}
+
+// Returns the member with the highest hash of the name, or an empty string if there are
+// no members
+func shardOwner(members []string, name string) string {
+	var owner string
+	var highest uint64
+	for _, member := range members {
+		h := fnv.New64a()
+		h.Write([]byte(member + "\x00" + name))
+		if sum := mixHash(h.Sum64()); owner == "" || sum > highest {
+			owner, highest = member, sum
+		}
+	}
+	return owner
+}
+
+// Spreads the bits of an FNV hash, whose high bits barely change between names that only
+// differ in their last characters
+func mixHash(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// Registers this instance under the shards prefix with a session, and keeps the members
+// up to date with the instances registered there. An instance that fails loses its key
+// along with its session, and its share moves to the others.
+func watchShardMembers(nodeName string, config *Config, client *api.Client, stopCh chan struct{}) {
+	var session string
+	var doneCh chan struct{}
+	expiredCh := make(chan struct{}, 1)
+
+	queryOpts := config.queryOptions()
+	queryOpts.WaitTime = watchWaitTime
+
+	for {
+		select {
+		case <-stopCh:
+			if doneCh != nil {
+				close(doneCh)
+			}
+			<-stopCh
+			return
+		case <-expiredCh:
+			log.Warn("The shard membership session expired, registering again")
+			session = ""
+		default:
+		}
+
+		if session == "" {
+			id, _, err := client.Session().Create(&api.SessionEntry{
+				Name:     "consul-alerting shard",
+				TTL:      shardSessionTTL,
+				Behavior: api.SessionBehaviorDelete,
+			}, nil)
+			if err != nil {
+				wait := consulErrorWait()
+				log.Errorf("Error creating the shard membership session: %s, retrying in %s...", err, wait.Truncate(time.Millisecond))
+				consulQueryErrors.add(1, "watch", "shards")
+				time.Sleep(wait)
+				continue
+			}
+			session = id
+			doneCh = make(chan struct{})
+			go func(id string, doneCh chan struct{}) {
+				client.Session().RenewPeriodic(shardSessionTTL, id, nil, doneCh)
+				select {
+				case expiredCh <- struct{}{}:
+				default:
+				}
+			}(id, doneCh)
+		}
+
+		// Acquiring the key again is a no-op while the session holds it, and registers the
+		// instance again if the key was removed
+		if _, _, err := client.KV().Acquire(&api.KVPair{Key: shardsKVPath + nodeName, Value: []byte(nodeName), Session: session}, nil); err != nil {
+			wait := consulErrorWait()
+			log.Errorf("Error registering as a shard member: %s, retrying in %s...", err, wait.Truncate(time.Millisecond))
+			consulQueryErrors.add(1, "watch", "shards")
+			time.Sleep(wait)
+			continue
+		}
+
+		pairs, queryMeta, err := client.KV().List(shardsKVPath, queryOpts)
+		if err != nil {
+			wait := consulErrorWait()
+			log.Errorf("Error listing the shard members: %s, retrying in %s...", err, wait.Truncate(time.Millisecond))
+			consulQueryErrors.add(1, "watch", "shards")
+			time.Sleep(wait)
+			continue
+		}
+		queryOpts.WaitIndex = queryMeta.LastIndex
+
+		members := make([]string, 0, len(pairs))
+		for _, pair := range pairs {
+			if pair.Session != "" {
+				members = append(members, strings.TrimPrefix(pair.Key, shardsKVPath))
+			}
+		}
+		if shards.setMembers(members) {
+			log.Infof("Sharding watches across instances %v", members)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShard_owner(t *testing.T) {
+	members := []string{"node1", "node2", "node3"}
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		name := fmt.Sprintf("service-%d", i)
+		owner := shardOwner(members, name)
+		if owner != shardOwner([]string{"node3", "node1", "node2"}, name) {
+			t.Fatalf("expected the owner of %s not to depend on the order of the members", name)
+		}
+		counts[owner]++
+
+		// Only the services of a member that leaves should move
+		remaining := shardOwner([]string{"node1", "node3"}, name)
+		if owner != "node2" && remaining != owner {
+			t.Fatalf("expected %s to stay on %s, moved to %s", name, owner, remaining)
+		}
+	}
+	for _, member := range members {
+		if counts[member] < 800 {
+			t.Errorf("expected the services to be spread evenly, got %v", counts)
+		}
+	}
+
+	if owner := shardOwner(nil, "redis"); owner != "" {
+		t.Errorf("expected no owner without members, got %s", owner)
+	}
+}
+
+func TestShard_owns(t *testing.T) {
+	s := &shardMembership{}
+	if !s.owns("redis") {
+		t.Fatalf("expected every service to be owned without sharding")
+	}
+
+	s.enable("node1")
+	if s.owns("redis") {
+		t.Errorf("expected nothing to be owned before joining the members")
+	}
+
+	if !s.setMembers([]string{"node1"}) || !s.owns("redis") {
+		t.Errorf("expected a single member to own every service")
+	}
+	if s.setMembers([]string{"node1"}) {
+		t.Errorf("expected the members to be unchanged")
+	}
+
+	s.setMembers([]string{"node2", "node1"})
+	if s.owns("redis") != (shardOwner([]string{"node1", "node2"}, "redis") == "node1") {
+		t.Errorf("expected redis to be owned by its shard owner")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// A silence written to the KV store by an operator, suppressing alerts until it expires.
+// A silence suppresses the alerts of the service its key is named after, unless it sets
+// a node or a pattern of services to match instead.
+type silence struct {
+	Expires time.Time `json:"expires"`
+	Reason  string    `json:"reason"`
+	Node    string    `json:"node,omitempty"`
+	Match   string    `json:"match,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// The silences currently stored in the KV store, keyed by the name of their key, which is
+// the service they silence for service silences
+type silenceRegistry struct {
+	sync.Mutex
+	silences map[string]silence
+}
+
+var alertSilences = &silenceRegistry{
+	silences: make(map[string]silence),
+}
+
+// Watches the silence prefix in the KV store, replacing the known silences whenever
+// it changes
+func watchSilences(client *api.Client, prefix string) {
+	log.Infof("Watching for silences under %s", prefix)
+	watchKVPrefix(client, prefix, func(pairs api.KVPairs) {
+		alertSilences.replace(parseSilences(prefix, pairs))
+	})
+}
+
+// Runs blocking queries for the keys under a KV prefix, calling update with the keys
+// each time they change
+func watchKVPrefix(client *api.Client, prefix string, update func(api.KVPairs)) {
+	queryOpts := &api.QueryOptions{
+		AllowStale: true,
+		WaitTime:   watchWaitTime,
+	}
+
+	for {
+		pairs, queryMeta, err := client.KV().List(prefix, queryOpts)
+		if err != nil {
+			wait := consulErrorWait()
+			log.Errorf("Error trying to watch %s: %s, retrying in %s...", prefix, err, wait.Truncate(time.Millisecond))
+			time.Sleep(wait)
+			continue
+		}
+
+		queryOpts.WaitIndex = queryMeta.LastIndex
+		update(pairs)
+	}
+}
+
+// Decodes the silences stored under a prefix, skipping the ones that can't be decoded
+func parseSilences(prefix string, pairs api.KVPairs) map[string]silence {
+	silences := make(map[string]silence)
+	for _, pair := range pairs {
+		service := strings.TrimPrefix(pair.Key, prefix)
+		if service == "" {
+			continue
+		}
+
+		var s silence
+		if err := json.Unmarshal(pair.Value, &s); err != nil || s.Expires.IsZero() {
+			log.Errorf("Invalid silence at %s, expected {\"expires\": \"<RFC 3339 time>\"}", pair.Key)
+			continue
+		}
+		if err := s.compile(); err != nil {
+			log.Errorf("Invalid silence at %s: %s", pair.Key, err)
+			continue
+		}
+		silences[service] = s
+	}
+	return silences
+}
+
+// Compiles the silence's pattern, which has to match the whole service name
+func (s *silence) compile() error {
+	pattern, err := compileRouteRegexp(s.Match)
+	if err != nil {
+		return fmt.Errorf("invalid match %q: %s", s.Match, err)
+	}
+	s.pattern = pattern
+	return nil
+}
+
+// Returns whether the silence stored under name applies to the alerts of a service on a
+// node
+func (s silence) matches(name string, service string, node string) bool {
+	if s.Node == "" && s.pattern == nil {
+		return name == service
+	}
+	if s.Node != "" && s.Node != node {
+		return false
+	}
+	return s.pattern == nil || s.pattern.MatchString(service)
+}
+
+// Describes what the silence stored under name applies to
+func (s silence) target(name string) string {
+	switch {
+	case s.Node != "" && s.Match != "":
+		return fmt.Sprintf("services matching %s on node %s", s.Match, s.Node)
+	case s.Node != "":
+		return "node " + s.Node
+	case s.Match != "":
+		return "services matching " + s.Match
+	}
+	return name
+}
+
+// Returns the name of the key a silence is stored under: the service for a service
+// silence, or a name derived from the node or pattern it matches
+func silenceName(service string, node string, match string) string {
+	if node == "" && match == "" {
+		return service
+	}
+
+	name := ""
+	if node != "" {
+		name = "node/" + node
+	}
+	if match != "" {
+		sum := sha256.Sum256([]byte(match))
+		name = strings.TrimPrefix(name+"/match/"+hex.EncodeToString(sum[:6]), "/")
+	}
+	return name
+}
+
+// Stores a silence under a name in the KV store
+func writeSilence(client *api.Client, prefix string, service string, s silence) error {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.KV().Put(&api.KVPair{Key: prefix + service, Value: value}, nil)
+	return err
+}
+
+func (r *silenceRegistry) replace(silences map[string]silence) {
+	r.Lock()
+	defer r.Unlock()
+	r.silences = silences
+}
+
+// Returns an unexpired silence of a service's alerts on a node, if there is one
+func (r *silenceRegistry) silenced(service string, node string, now time.Time) (silence, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	if s, ok := r.silences[service]; ok && now.Before(s.Expires) && s.matches(service, service, node) {
+		return s, true
+	}
+	for name, s := range r.silences {
+		if now.Before(s.Expires) && s.matches(name, service, node) {
+			return s, true
+		}
+	}
+	return silence{}, false
+}
+
+// Returns the unexpired silences, keyed by name
+func (r *silenceRegistry) active(now time.Time) map[string]silence {
+	r.Lock()
+	defer r.Unlock()
+
+	active := make(map[string]silence)
+	for service, s := range r.silences {
+		if now.Before(s.Expires) {
+			active[service] = s
+		}
+	}
+	return active
+}
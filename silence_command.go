@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"text/tabwriter"
+	"time"
+)
+
+const silenceUsage = `Usage: consul-alerting silence create [options] [<config>]
+       consul-alerting silence list [options] [<config>]
+       consul-alerting silence delete [options] [<config>]
+
+Manages the silences of a running daemon, which stop the alerts of a service, of
+every service on a node or of the services matching a regular expression from being
+sent until they expire. The daemon is reached over its HTTP API, at the http_address
+and with the api_token of the config file if one is given.
+
+Options:
+
+    -address=<host:port>    The address of the daemon's HTTP API, overriding the config.
+    -token=<token>          The API token, overriding the config.
+
+Create and delete options:
+
+    -service=<name>         Silences the alerts of a service.
+    -node=<name>            Silences the alerts on a node.
+    -match=<regexp>         Silences the alerts of the services fully matching a
+                            regular expression. Can be combined with -node.
+    -id=<id>                Deletes the silence with this ID, as listed.
+    -duration=<duration>    How long the silence lasts, e.g. 30m or 2h. Defaults to 1h.
+    -reason=<text>          Why the alerts are silenced.
+
+List options:
+
+    -json                   Prints the silences as JSON instead.
+`
+
+// Runs the silence subcommand, returning the exit code
+func runSilence(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, silenceUsage)
+		return 2
+	}
+	action := args[0]
+
+	flags := flag.NewFlagSet("silence "+action, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, silenceUsage) }
+	var daemon daemonClient
+	daemon.register(flags)
+	var req silenceRequest
+	var id string
+	var duration time.Duration
+	var asJSON bool
+	switch action {
+	case "create", "delete":
+		flags.StringVar(&req.Service, "service", "", "")
+		flags.StringVar(&req.Node, "node", "", "")
+		flags.StringVar(&req.Match, "match", "", "")
+		if action == "create" {
+			flags.DurationVar(&duration, "duration", time.Hour, "")
+			flags.StringVar(&req.Reason, "reason", "", "")
+		} else {
+			flags.StringVar(&id, "id", "", "")
+		}
+	case "list":
+		flags.BoolVar(&asJSON, "json", false, "")
+	default:
+		fmt.Fprintf(stderr, "Unknown silence command: %s\n\n", action)
+		flags.Usage()
+		return 2
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+	if code := daemon.setup(flags.Arg(0), stderr); code != 0 {
+		return code
+	}
+
+	switch action {
+	case "create":
+		if req.Service == "" && req.Node == "" && req.Match == "" {
+			fmt.Fprintln(stderr, "One of -service, -node or -match must be given")
+			return 2
+		}
+		if duration <= 0 {
+			fmt.Fprintln(stderr, "-duration must be positive")
+			return 2
+		}
+		req.Duration = duration.String()
+
+		body, err := sendJSON("PUT", daemon.url("/v1/silences"), daemon.headers(), req)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error creating the silence: %s\n", err)
+			return 1
+		}
+		var created silenceListing
+		if err := json.Unmarshal(body, &created); err != nil {
+			fmt.Fprintf(stderr, "Invalid response from the daemon: %s\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "Silenced %s until %s (ID: %s)\n", created.target(), created.Expires.Local().Format(time.RFC3339), created.ID)
+
+	case "list":
+		var silences []silenceListing
+		if err := getJSON(daemon.url("/v1/silences"), daemon.headers(), &silences); err != nil {
+			fmt.Fprintf(stderr, "Error listing the silences: %s\n", err)
+			return 1
+		}
+		if asJSON {
+			encoder := json.NewEncoder(stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(silences)
+			return 0
+		}
+		printSilences(stdout, silences, time.Now())
+
+	case "delete":
+		query := url.Values{}
+		for name, value := range map[string]string{"id": id, "service": req.Service, "node": req.Node, "match": req.Match} {
+			if value != "" {
+				query.Set(name, value)
+			}
+		}
+		if len(query) == 0 {
+			fmt.Fprintln(stderr, "One of -id, -service, -node or -match must be given")
+			return 2
+		}
+
+		httpReq, err := http.NewRequest("DELETE", daemon.url("/v1/silences")+"?"+query.Encode(), nil)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		for name, value := range daemon.headers() {
+			httpReq.Header.Set(name, value)
+		}
+		if _, err := doRequest(httpReq); err != nil {
+			fmt.Fprintf(stderr, "Error deleting the silence: %s\n", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, "Deleted the silence")
+	}
+	return 0
+}
+
+func printSilences(w io.Writer, silences []silenceListing, now time.Time) {
+	if len(silences) == 0 {
+		fmt.Fprintln(w, "No active silences")
+		return
+	}
+
+	out := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer out.Flush()
+	fmt.Fprintln(out, "ID\tSILENCES\tEXPIRES\tREASON")
+	for _, s := range silences {
+		expires := fmt.Sprintf("%s (in %s)", s.Expires.Local().Format(time.RFC3339), s.Expires.Sub(now).Truncate(time.Second))
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", s.ID, s.target(), expires, s.Reason)
+	}
+}
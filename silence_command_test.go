@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestSilence_command(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+	defer alertSilences.replace(make(map[string]silence))
+
+	prefix := "service/consul-alerting/silences/"
+	config := &Config{SilenceKVPrefix: prefix}
+	server := httptest.NewServer(silencesHandler(config, client))
+	defer server.Close()
+
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runSilence(append(args, "-address", server.URL), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, stdout, stderr := run("create", "-node", "web1", "-duration", "30m", "-reason", "disk replacement")
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "Silenced node web1 until ") || !strings.Contains(stdout, "(ID: node/web1)") {
+		t.Errorf("unexpected output: %s", stdout)
+	}
+	if _, ok := values[prefix+"node/web1"]; !ok {
+		t.Fatalf("expected the silence in the KV, got %v", values)
+	}
+
+	// The daemon picks up the silence from the KV store
+	var pairs api.KVPairs
+	for key, value := range values {
+		pairs = append(pairs, &api.KVPair{Key: key, Value: value})
+	}
+	alertSilences.replace(parseSilences(prefix, pairs))
+
+	code, stdout, _ = run("list")
+	if code != 0 || !strings.Contains(stdout, "node/web1") || !strings.Contains(stdout, "disk replacement") {
+		t.Errorf("unexpected listing (exit code %d): %s", code, stdout)
+	}
+
+	if code, _, stderr := run("delete", "-id", "node/web1"); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if _, ok := values[prefix+"node/web1"]; ok {
+		t.Error("expected the silence to be deleted")
+	}
+
+	if code, _, _ := run("create", "-duration", "1h"); code != 2 {
+		t.Errorf("expected exit code 2 without a service, node or pattern, got %d", code)
+	}
+	if code, _, stderr := run("create", "-match", "db-("); code != 1 || !strings.Contains(stderr, "invalid match") {
+		t.Errorf("expected the invalid pattern to be refused, got %d: %s", code, stderr)
+	}
+	if code, _, _ := run("mute"); code != 2 {
+		t.Errorf("expected exit code 2 for an unknown command, got %d", code)
+	}
+}
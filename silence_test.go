@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestSilence_parse(t *testing.T) {
+	prefix := "service/consul-alerting/silences/"
+	silences := parseSilences(prefix, api.KVPairs{
+		{Key: prefix, Value: nil},
+		{Key: prefix + "redis", Value: []byte(`{"expires": "2026-10-14T12:00:00Z", "reason": "failover"}`)},
+		{Key: prefix + "web", Value: []byte(`2 hours`)},
+		{Key: prefix + "api", Value: []byte(`{"reason": "no expiry"}`)},
+	})
+
+	if len(silences) != 1 {
+		t.Fatalf("expected only the valid silence, got %v", silences)
+	}
+	if silences["redis"].Reason != "failover" {
+		t.Errorf("unexpected silence: %+v", silences["redis"])
+	}
+
+	registry := &silenceRegistry{}
+	registry.replace(silences)
+
+	expires := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	if _, ok := registry.silenced("redis", "", expires.Add(-time.Minute)); !ok {
+		t.Error("expected redis to be silenced before the expiry")
+	}
+	if _, ok := registry.silenced("redis", "", expires); ok {
+		t.Error("expected the silence to have expired")
+	}
+	if _, ok := registry.silenced("web", "", expires.Add(-time.Minute)); ok {
+		t.Error("expected web not to be silenced")
+	}
+}
+
+func TestSilence_matchers(t *testing.T) {
+	prefix := "service/consul-alerting/silences/"
+	expires := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	silences := parseSilences(prefix, api.KVPairs{
+		{Key: prefix + silenceName("", "web1", ""), Value: []byte(`{"expires": "2026-10-14T12:00:00Z", "node": "web1"}`)},
+		{Key: prefix + silenceName("", "", "db-.*"), Value: []byte(`{"expires": "2026-10-14T12:00:00Z", "match": "db-.*"}`)},
+		{Key: prefix + silenceName("", "web2", "api"), Value: []byte(`{"expires": "2026-10-14T12:00:00Z", "node": "web2", "match": "api"}`)},
+		{Key: prefix + "match/invalid", Value: []byte(`{"expires": "2026-10-14T12:00:00Z", "match": "db-("}`)},
+	})
+	if len(silences) != 3 {
+		t.Fatalf("expected the invalid pattern to be skipped, got %v", silences)
+	}
+
+	registry := &silenceRegistry{}
+	registry.replace(silences)
+
+	cases := []struct {
+		service  string
+		node     string
+		silenced bool
+	}{
+		{"redis", "web1", true},
+		{"redis", "web2", false},
+		{"db-main", "web3", true},
+		{"my-db-main", "web3", false},
+		{"api", "web2", true},
+		{"api", "web3", false},
+		{"web1", "", false},
+	}
+	for _, c := range cases {
+		if _, ok := registry.silenced(c.service, c.node, expires.Add(-time.Minute)); ok != c.silenced {
+			t.Errorf("expected %s on %s to be silenced: %v", c.service, c.node, c.silenced)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The values of the buttons added to interactive Slack alerts
+const (
+	slackActionAck     = "ack"
+	slackActionSilence = "silence"
+)
+
+// How old a signed Slack request can be before it's rejected as a possible replay
+const slackRequestMaxAge = 5 * time.Minute
+
+// Identifies the alert an interactive Slack message is about, sent back by Slack as the
+// block ID of the clicked button
+type slackAlertRef struct {
+	Datacenter string `json:"dc"`
+	Service    string `json:"service,omitempty"`
+	Namespace  string `json:"ns,omitempty"`
+	Partition  string `json:"ap,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+	Node       string `json:"node,omitempty"`
+	Check      string `json:"check,omitempty"`
+}
+
+func slackCallbackID(datacenter string, alert *AlertState) string {
+	id, _ := json.Marshal(slackAlertRef{datacenter, alert.Service, alert.Namespace, alert.Partition, alert.Tag, alert.Node, alert.Check})
+	return string(id)
+}
+
+// Returns the block of buttons for a failing alert. Only alerts on a service can be silenced.
+func slackAlertActions(datacenter string, alert *AlertState, silenceDuration int) slackBlock {
+	buttons := []interface{}{
+		slackButton{
+			Type:     "button",
+			Text:     slackText{Type: "plain_text", Text: "Acknowledge"},
+			ActionID: slackActionAck,
+			Value:    slackActionAck,
+			Style:    "primary",
+		},
+	}
+	if alert.Service != "" {
+		buttons = append(buttons, slackButton{
+			Type:     "button",
+			Text:     slackText{Type: "plain_text", Text: "Silence " + shortDuration(time.Duration(silenceDuration)*time.Second)},
+			ActionID: slackActionSilence,
+			Value:    slackActionSilence,
+		})
+	}
+	return slackBlock{Type: "actions", BlockID: slackCallbackID(datacenter, alert), Elements: buttons}
+}
+
+// The parts of an interaction payload Slack sends when a button is clicked. Buttons in
+// blocks carry the alert in their block ID, legacy attachment buttons in the callback ID.
+type slackActionPayload struct {
+	CallbackID string `json:"callback_id"`
+	User       struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Actions []struct {
+		Value   string `json:"value"`
+		BlockID string `json:"block_id"`
+	} `json:"actions"`
+}
+
+// Formats a duration for a button label, e.g. "1h" rather than "1h0m0s"
+func shortDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return d.String()
+}
+
+// Checks the signature Slack adds to its requests (v0=HMAC-SHA256 of "v0:<timestamp>:<body>")
+func verifySlackSignature(secret string, timestamp string, body []byte, signature string, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > slackRequestMaxAge || age < -slackRequestMaxAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// Handles the button clicks of interactive Slack alerts, acknowledging or silencing the
+// alert the message was about. Requests must be signed with the signing secret of one of
+// the interactive Slack handlers.
+func slackActionsHandler(config *Config, client *api.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// A reload may have rotated the signing secrets or added interactive handlers
+		current := latestConfig(config)
+		var handler *SlackHandler
+		for _, h := range current.Handlers {
+			if slackHandler, ok := h.(SlackHandler); ok && slackHandler.Interactive &&
+				verifySlackSignature(slackHandler.SigningSecret, r.Header.Get("X-Slack-Request-Timestamp"), body, r.Header.Get("X-Slack-Signature"), time.Now()) {
+				handler = &slackHandler
+				break
+			}
+		}
+		if handler == nil {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var payload slackActionPayload
+		if err := json.Unmarshal([]byte(form.Get("payload")), &payload); err != nil || len(payload.Actions) == 0 {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		action := payload.Actions[0]
+
+		refID := action.BlockID
+		if payload.CallbackID != "" {
+			refID = payload.CallbackID
+		}
+		var ref slackAlertRef
+		if err := json.Unmarshal([]byte(refID), &ref); err != nil {
+			http.Error(w, "invalid alert reference", http.StatusBadRequest)
+			return
+		}
+
+		user := payload.User.Username
+		if user == "" {
+			user = payload.User.Name
+		}
+
+		text, err := handler.runAction(current, client, action.Value, ref, user)
+		if err != nil {
+			log.Errorf("Error handling Slack action %s: %s", action.Value, err)
+			text = fmt.Sprintf("Error: %s", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"response_type":    "in_channel",
+			"replace_original": false,
+			"text":             text,
+		})
+	}
+}
+
+// Acknowledges or silences an alert for a user, returning the reply to post in the channel
+func (handler SlackHandler) runAction(config *Config, client *api.Client, action string, ref slackAlertRef, user string) (string, error) {
+	alert := &AlertState{Service: ref.Service, Namespace: ref.Namespace, Partition: ref.Partition, Tag: ref.Tag, Node: ref.Node, Check: ref.Check}
+
+	switch action {
+	case slackActionAck:
+		if err := acknowledge(client, ref.Datacenter, alert, alertAck{By: user, Comment: "via Slack"}); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s acknowledged this alert", user), nil
+	case slackActionSilence:
+		if config.SilenceKVPrefix == "" {
+			return "", fmt.Errorf("silences are disabled")
+		}
+		if ref.Service == "" {
+			return "", fmt.Errorf("only alerts on a service can be silenced")
+		}
+		expires := time.Now().Add(time.Duration(handler.SilenceDuration) * time.Second).UTC()
+		s := silence{Expires: expires, Reason: "silenced by " + user + " via Slack"}
+		if err := writeSilence(client, config.SilenceKVPrefix, ref.Service, s); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s silenced %s until %s", user, ref.Service, expires.Format(time.RFC3339)), nil
+	}
+
+	return "", fmt.Errorf("unknown action %s", action)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSlackActionRequest(secret string, timestamp time.Time, callbackID string, action string) *http.Request {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":    "block_actions",
+		"actions": []map[string]string{{"action_id": action, "block_id": callbackID, "value": action}},
+		"user":    map[string]string{"id": "U1", "username": "alice", "name": "Alice"},
+	})
+	body := url.Values{"payload": {string(payload)}}.Encode()
+
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, body)
+
+	req := httptest.NewRequest("POST", "/v1/slack/actions", strings.NewReader(body))
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestSlackActions_ackAndSilence(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	config := &Config{
+		ConsulDatacenter: "dc1",
+		SilenceKVPrefix:  "service/consul-alerting/silences/",
+		Handlers: map[string]AlertHandler{
+			"slack.ops": SlackHandler{Interactive: true, SigningSecret: "secret", SilenceDuration: 3600},
+		},
+	}
+	handler := slackActionsHandler(config, client)
+	callbackID := slackCallbackID("dc1", &AlertState{Service: "redis", Status: "critical"})
+
+	w := httptest.NewRecorder()
+	handler(w, testSlackActionRequest("secret", time.Now(), callbackID, slackActionAck))
+	if !strings.Contains(w.Body.String(), "alice acknowledged this alert") {
+		t.Errorf("unexpected reply: %d %s", w.Code, w.Body.String())
+	}
+	var ack alertAck
+	if err := json.Unmarshal(values["service/consul-alerting/acks/dc1/redis/_/_/_"], &ack); err != nil || ack.By != "alice" {
+		t.Errorf("expected an acknowledgement by alice, got %+v (%v)", ack, err)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, testSlackActionRequest("secret", time.Now(), callbackID, slackActionSilence))
+	if !strings.Contains(w.Body.String(), "alice silenced redis until") {
+		t.Errorf("unexpected reply: %d %s", w.Code, w.Body.String())
+	}
+	var s silence
+	if err := json.Unmarshal(values["service/consul-alerting/silences/redis"], &s); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := time.Until(s.Expires); remaining < 59*time.Minute || remaining > time.Hour {
+		t.Errorf("expected a silence of an hour, expires %s", s.Expires)
+	}
+}
+
+func TestSlackActions_invalidSignature(t *testing.T) {
+	config := &Config{
+		Handlers: map[string]AlertHandler{
+			"slack.ops": SlackHandler{Interactive: true, SigningSecret: "secret"},
+		},
+	}
+	handler := slackActionsHandler(config, nil)
+
+	for _, req := range []*http.Request{
+		testSlackActionRequest("wrong", time.Now(), "{}", slackActionAck),
+		testSlackActionRequest("secret", time.Now().Add(-10*time.Minute), "{}", slackActionAck),
+	} {
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", w.Code)
+		}
+	}
+}
+
+func TestSlackActions_buttons(t *testing.T) {
+	block := slackAlertActions("dc1", &AlertState{Service: "redis"}, 3600)
+	if len(block.Elements) != 2 || block.Elements[1].(slackButton).Text.Text != "Silence 1h" {
+		t.Errorf("unexpected buttons: %+v", block.Elements)
+	}
+	if block.BlockID != `{"dc":"dc1","service":"redis"}` {
+		t.Errorf("expected the alert in the block ID, got %s", block.BlockID)
+	}
+
+	if block := slackAlertActions("dc1", &AlertState{Node: "node1"}, 3600); len(block.Elements) != 1 {
+		t.Errorf("expected node alerts not to be silenceable, got %+v", block.Elements)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The subset of Slack's Block Kit used to lay out alerts
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackButton struct {
+	Type     string    `json:"type"`
+	Text     slackText `json:"text"`
+	ActionID string    `json:"action_id"`
+	Value    string    `json:"value"`
+	Style    string    `json:"style,omitempty"`
+}
+
+type slackBlock struct {
+	Type     string        `json:"type"`
+	BlockID  string        `json:"block_id,omitempty"`
+	Text     *slackText    `json:"text,omitempty"`
+	Fields   []slackText   `json:"fields,omitempty"`
+	Elements []interface{} `json:"elements,omitempty"`
+}
+
+// An attachment holding blocks, used so the alert gets a colored bar by status
+type slackBlockAttachment struct {
+	Color    string       `json:"color"`
+	Fallback string       `json:"fallback"`
+	Blocks   []slackBlock `json:"blocks"`
+}
+
+// The body posted to the incoming webhook. Channel overrides the webhook's channel, which
+// only legacy webhooks allow.
+type slackBlockMessage struct {
+	Channel     string                 `json:"channel,omitempty"`
+	Attachments []slackBlockAttachment `json:"attachments"`
+}
+
+// Slack allows at most 10 fields in a section and 3000 characters in a text object
+const (
+	slackMaxFields = 10
+	slackMaxText   = 3000
+)
+
+func slackMarkdown(text string) *slackText {
+	return &slackText{Type: "mrkdwn", Text: text}
+}
+
+// Builds the attachment for an alert: its message (or the rendered text_template), fields
+// for the service, node, datacenter, status and labels followed by any configured fields,
+// and the check output, with a bar colored by status
+func (handler SlackHandler) attachment(datacenter string, alert *AlertState, details string) slackBlockAttachment {
+	blocks := []slackBlock{}
+
+	if handler.textTemplate != nil {
+		text := renderAlertTemplateOr(handler.textTemplate, datacenter, alert, fmt.Sprintf(slackMessageFormat, alert.Message, details))
+		blocks = append(blocks, slackBlock{Type: "section", Text: slackMarkdown(truncateDetails(text, slackMaxText))})
+	} else {
+		blocks = append(blocks, slackBlock{Type: "section", Text: slackMarkdown("*" + alert.Message + "*")})
+	}
+
+	fields := make([]slackText, 0)
+	if alert.Service != "" {
+		service := alert.Service
+		if alert.Tag != "" {
+			service += " (" + alert.Tag + ")"
+		}
+		fields = append(fields, *slackMarkdown("*Service*\n" + service))
+	}
+	if alert.Node != "" {
+		fields = append(fields, *slackMarkdown("*Node*\n" + alert.Node))
+	}
+	if datacenter != "" {
+		fields = append(fields, *slackMarkdown("*Datacenter*\n" + datacenter))
+	}
+	if alert.Status != "" {
+		fields = append(fields, *slackMarkdown("*Status*\n" + alert.Status))
+	}
+	for _, name := range sortedLabelNames(alert.Labels) {
+		fields = append(fields, *slackMarkdown("*" + name + "*\n" + alert.Labels[name]))
+	}
+	for _, field := range handler.renderFields(datacenter, alert) {
+		fields = append(fields, *slackMarkdown("*" + field.Title + "*\n" + field.Value))
+	}
+	for len(fields) > 0 {
+		n := len(fields)
+		if n > slackMaxFields {
+			n = slackMaxFields
+		}
+		blocks = append(blocks, slackBlock{Type: "section", Fields: fields[:n]})
+		fields = fields[n:]
+	}
+
+	if handler.textTemplate == nil && strings.TrimSpace(details) != "" {
+		output := truncateDetails(details, slackMaxText-len("```\n\n```"))
+		blocks = append(blocks, slackBlock{Type: "section", Text: slackMarkdown("```\n" + output + "\n```")})
+	}
+
+	if handler.Interactive && alert.Status != api.HealthPassing {
+		blocks = append(blocks, slackAlertActions(datacenter, alert, handler.SilenceDuration))
+	}
+
+	context := fmt.Sprintf("consul-alerting | <!date^%d^{date_short_pretty} {time}|%s>",
+		time.Now().Unix(), time.Now().UTC().Format(time.RFC1123))
+	if alert.ConsulURL != "" {
+		context += " | <" + alert.ConsulURL + "|View in Consul>"
+	}
+	blocks = append(blocks, slackBlock{Type: "context", Elements: []interface{}{slackMarkdown(context)}})
+
+	return slackBlockAttachment{
+		Color:    fmt.Sprintf("#%06X", alertStatusColor(alert.Status)),
+		Fallback: alert.Message,
+		Blocks:   blocks,
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlackBlocks_attachment(t *testing.T) {
+	handler := SlackHandler{Fields: []SlackField{{Title: "Owner", Value: "{{.Service}}-team"}}}
+	if err := handler.compileFields(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Service: "redis", Tag: "primary", Node: "node1", Status: "critical", Message: "redis is critical"}
+	attachment := handler.attachment("dc1", alert, "connection refused")
+
+	if attachment.Color != "#A30200" {
+		t.Errorf("expected a red bar for a critical alert, got %s", attachment.Color)
+	}
+	if attachment.Fallback != "redis is critical" {
+		t.Errorf("unexpected fallback: %s", attachment.Fallback)
+	}
+
+	blocks := attachment.Blocks
+	if len(blocks) != 4 {
+		t.Fatalf("expected message, fields, output and context blocks, got %+v", blocks)
+	}
+	if blocks[0].Text.Text != "*redis is critical*" {
+		t.Errorf("unexpected message block: %s", blocks[0].Text.Text)
+	}
+
+	fields := make([]string, 0)
+	for _, field := range blocks[1].Fields {
+		fields = append(fields, field.Text)
+	}
+	expected := "*Service*\nredis (primary)|*Node*\nnode1|*Datacenter*\ndc1|*Status*\ncritical|*Owner*\nredis-team"
+	if actual := strings.Join(fields, "|"); actual != expected {
+		t.Errorf("expected fields %s, got %s", expected, actual)
+	}
+	if blocks[2].Text.Text != "```\nconnection refused\n```" {
+		t.Errorf("unexpected output block: %s", blocks[2].Text.Text)
+	}
+	if blocks[3].Type != "context" {
+		t.Errorf("expected a context block last, got %s", blocks[3].Type)
+	}
+
+	passing := handler.attachment("dc1", &AlertState{Status: "passing", Message: "redis is passing"}, "")
+	if passing.Color != "#2EB886" {
+		t.Errorf("expected a green bar for a passing alert, got %s", passing.Color)
+	}
+}
+
+func TestSlackBlocks_interactive(t *testing.T) {
+	handler := SlackHandler{Interactive: true, SilenceDuration: 1800}
+
+	blocks := handler.attachment("dc1", &AlertState{Service: "redis", Status: "warning"}, "").Blocks
+	if blocks[len(blocks)-2].Type != "actions" {
+		t.Errorf("expected buttons on a failing alert, got %+v", blocks)
+	}
+
+	blocks = handler.attachment("dc1", &AlertState{Service: "redis", Status: "passing"}, "").Blocks
+	for _, block := range blocks {
+		if block.Type == "actions" {
+			t.Error("expected no buttons on a recovery")
+		}
+	}
+}
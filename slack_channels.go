@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// A rule sending the alerts it matches to another channel than channel_name. Every set
+// condition must match; the first matching rule picks the channel.
+type SlackChannelRoute struct {
+	Service    string   `mapstructure:"service"`
+	Tags       []string `mapstructure:"tags"`
+	Severities []string `mapstructure:"severity"`
+	Channel    string   `mapstructure:"channel"`
+
+	serviceRegexp *regexp.Regexp
+}
+
+func (route *SlackChannelRoute) validate() error {
+	if route.Channel == "" {
+		return fmt.Errorf("channel must be set")
+	}
+	for _, severity := range route.Severities {
+		if severityRank(severity) < 0 {
+			return fmt.Errorf("invalid severity %s", severity)
+		}
+	}
+
+	var err error
+	if route.serviceRegexp, err = compileRouteRegexp(route.Service); err != nil {
+		return fmt.Errorf("invalid service pattern: %s", err)
+	}
+	return nil
+}
+
+func (route *SlackChannelRoute) matches(alert *AlertState) bool {
+	if route.serviceRegexp != nil && !route.serviceRegexp.MatchString(alert.Service) {
+		return false
+	}
+	if len(route.Tags) > 0 && !contains(route.Tags, alert.Tag) {
+		return false
+	}
+	if len(route.Severities) > 0 && !contains(route.Severities, alert.Severity) {
+		return false
+	}
+	return true
+}
+
+// Returns the channel of the first channel route matching an alert, or "" if none match
+func (handler SlackHandler) routeChannel(alert *AlertState) string {
+	for i := range handler.Channels {
+		if handler.Channels[i].matches(alert) {
+			return handler.Channels[i].Channel
+		}
+	}
+	return ""
+}
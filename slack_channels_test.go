@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSlackChannels_routeChannel(t *testing.T) {
+	handler := SlackHandler{
+		Token:       "https://hooks.slack.com/services/x",
+		ChannelName: "#platform-alerts",
+		Channels: []SlackChannelRoute{
+			{Service: "payments-.*", Channel: "#team-payments-alerts"},
+			{Tags: []string{"primary"}, Severities: []string{SeverityCritical}, Channel: "#db-pages"},
+		},
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		alert    AlertState
+		expected string
+	}{
+		{AlertState{Service: "payments-api", Severity: SeverityWarning}, "#team-payments-alerts"},
+		{AlertState{Service: "redis", Tag: "primary", Severity: SeverityCritical}, "#db-pages"},
+		{AlertState{Service: "redis", Tag: "primary", Severity: SeverityWarning}, ""},
+		{AlertState{Service: "redis", Severity: SeverityCritical}, ""},
+	}
+	for _, c := range cases {
+		if actual := handler.routeChannel(&c.alert); actual != c.expected {
+			t.Errorf("expected channel %q for %+v, got %q", c.expected, c.alert, actual)
+		}
+	}
+
+	invalid := SlackHandler{Token: "https://hooks.slack.com/services/x", Channels: []SlackChannelRoute{{Service: "redis"}}}
+	if err := invalid.validate(); err == nil {
+		t.Error("expected an error for a channel route without a channel")
+	}
+}
+
+func TestSlackChannels_recoveryFollowsAlert(t *testing.T) {
+	var channels []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg slackBlockMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error(err)
+		}
+		channels = append(channels, msg.Channel)
+	}))
+	defer server.Close()
+
+	handler := SlackHandler{
+		Token:    server.URL,
+		Channels: []SlackChannelRoute{{Severities: []string{SeverityCritical}, Channel: "#pages"}},
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Service: "redis", Status: "critical", Severity: SeverityCritical})
+	handler.Alert("dc1", &AlertState{Service: "redis", Status: "passing", Severity: SeverityInfo})
+	handler.Alert("dc1", &AlertState{Service: "redis", Status: "passing", Severity: SeverityInfo})
+
+	if len(channels) != 3 || channels[0] != "#pages" || channels[1] != "#pages" || channels[2] != "" {
+		t.Errorf("expected the recovery in the alert's channel, got %q", channels)
+	}
+}
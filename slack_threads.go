@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const slackAPIURL = "https://slack.com/api"
+
+// Where the message for a failing alert was posted, so its updates and recovery go to the
+// same channel (even if they no longer match its channel route) and into its thread
+type slackThread struct {
+	Channel string
+	TS      string
+}
+
+// The messages of the alerts that are failing, keyed by incident key and check. A nil
+// registry tracks nothing.
+type slackThreadRegistry struct {
+	sync.Mutex
+	threads map[string]slackThread
+}
+
+func (r *slackThreadRegistry) get(key string) (slackThread, bool) {
+	if r == nil {
+		return slackThread{}, false
+	}
+	r.Lock()
+	defer r.Unlock()
+	thread, ok := r.threads[key]
+	return thread, ok
+}
+
+func (r *slackThreadRegistry) set(key string, thread slackThread) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.threads[key] = thread
+}
+
+func (r *slackThreadRegistry) remove(key string) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	delete(r.threads, key)
+}
+
+// The body of a chat.postMessage request
+type slackPostMessage struct {
+	Channel     string                 `json:"channel"`
+	Text        string                 `json:"text"`
+	ThreadTS    string                 `json:"thread_ts,omitempty"`
+	Attachments []slackBlockAttachment `json:"attachments"`
+}
+
+// The parts of a Slack Web API response used by the handler
+type slackAPIResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+	TS    string `json:"ts"`
+}
+
+// Posts a message using the Web API, returning its timestamp
+func (handler SlackHandler) postMessage(msg slackPostMessage) (string, error) {
+	headers := map[string]string{"Authorization": "Bearer " + handler.BotToken}
+	body, err := handler.sendJSON("POST", strings.TrimSuffix(handler.APIURL, "/")+"/chat.postMessage", headers, msg)
+	if err != nil {
+		return "", err
+	}
+
+	var resp slackAPIResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("error decoding response: %s", err)
+	}
+	if !resp.OK {
+		return "", fmt.Errorf("slack API error: %s", resp.Error)
+	}
+	return resp.TS, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSlackThreads_recoveryReplies(t *testing.T) {
+	var posted []slackPostMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat.postMessage" || r.Header.Get("Authorization") != "Bearer xoxb-token" {
+			t.Errorf("unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+		var msg slackPostMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error(err)
+		}
+		posted = append(posted, msg)
+		w.Write([]byte(`{"ok": true, "ts": "1500000000.000100"}`))
+	}))
+	defer server.Close()
+
+	handler := SlackHandler{ChannelName: "alerts", BotToken: "xoxb-token", ThreadUpdates: true, APIURL: server.URL}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Service: "redis", Status: "critical", Message: "redis is critical"})
+	handler.Alert("dc1", &AlertState{Service: "redis", Status: "passing", Message: "redis is passing"})
+	handler.Alert("dc1", &AlertState{Service: "redis", Status: "critical", Message: "redis is critical"})
+
+	if len(posted) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(posted))
+	}
+	if posted[0].ThreadTS != "" || posted[0].Channel != "alerts" {
+		t.Errorf("expected the alert to open a new message, got %+v", posted[0])
+	}
+	if posted[1].ThreadTS != "1500000000.000100" {
+		t.Errorf("expected the recovery in the alert's thread, got thread_ts %q", posted[1].ThreadTS)
+	}
+	if posted[2].ThreadTS != "" {
+		t.Errorf("expected a new failure to open a new message, got thread_ts %q", posted[2].ThreadTS)
+	}
+}
+
+func TestSlackThreads_validate(t *testing.T) {
+	handler := SlackHandler{Token: "https://hooks.slack.com/services/x", ChannelName: "alerts", ThreadUpdates: true}
+	if err := handler.validate(); err == nil {
+		t.Error("expected an error without bot_token")
+	}
+}
+
+func TestSlackThreads_botToken(t *testing.T) {
+	var posted []slackPostMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg slackPostMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error(err)
+		}
+		posted = append(posted, msg)
+		w.Write([]byte(`{"ok": true, "ts": "1500000000.000100"}`))
+	}))
+	defer server.Close()
+
+	handler := SlackHandler{
+		BotToken:    "xoxb-token",
+		ChannelName: "#platform-alerts",
+		APIURL:      server.URL,
+		Channels:    []SlackChannelRoute{{Service: "payments-.*", Channel: "#team-payments-alerts"}},
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.Alert("dc1", &AlertState{Service: "payments-api", Status: "critical", Message: "payments-api is critical"})
+	handler.Alert("dc1", &AlertState{Service: "redis", Status: "critical", Message: "redis is critical"})
+
+	if len(posted) != 2 || posted[0].Channel != "#team-payments-alerts" || posted[1].Channel != "#platform-alerts" {
+		t.Fatalf("expected messages to both channels, got %+v", posted)
+	}
+	if posted[0].ThreadTS != "" || posted[0].Text != "payments-api is critical" {
+		t.Errorf("unexpected message: %+v", posted[0])
+	}
+
+	if err := (&SlackHandler{BotToken: "xoxb-token"}).validate(); err == nil {
+		t.Error("expected an error without channel_name")
+	}
+}
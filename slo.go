@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// The KV path the availability samples of each SLO are stored under, along with its lock
+const slosKVPath = alertingKVRoot + "/slos/"
+
+// How often the health of the services with an SLO is sampled. A minute is counted as
+// unavailable when any of its samples found the service critical.
+const sloSampleInterval = 10 * time.Second
+
+// An availability objective for a service, measured in minutes over a rolling window from
+// the observed health of its checks. The SLO alerts once the unavailable minutes in the
+// window use up its error budget, or when the budget burns faster than one of the
+// burn_rates over the shorter windows they're given for.
+type SLOConfig struct {
+	Name      string             `json:"name"`
+	Service   string             `mapstructure:"service" json:"service"`
+	Objective float64            `mapstructure:"objective" json:"objective"`
+	Window    string             `mapstructure:"window" json:"window"`
+	BurnRates map[string]float64 `mapstructure:"burn_rates" json:"burn_rates,omitempty"`
+	Status    string             `mapstructure:"status" json:"status"`
+	Handlers  []string           `mapstructure:"handlers" json:"handlers,omitempty"`
+
+	// The window and the burn rate windows in minutes
+	windowMinutes int
+	burnWindows   []sloBurnWindow
+}
+
+type sloBurnWindow struct {
+	name    string
+	minutes int
+	rate    float64
+}
+
+// Parse the raw slo objects into the config
+func parseSLOs(list *ast.ObjectList, config *Config) error {
+	config.SLOs = make([]SLOConfig, 0, len(list.Items))
+
+	for _, s := range list.Items {
+		if len(s.Keys) != 1 {
+			return fmt.Errorf("slo must be in the form 'slo \"name\" {}'")
+		}
+		name := s.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var slo SLOConfig
+		if err := hcl.DecodeObject(&m, s.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &slo); err != nil {
+			return err
+		}
+		slo.Name = name
+
+		if err := slo.validate(config); err != nil {
+			return fmt.Errorf("Error loading slo %s: %s", name, err)
+		}
+		config.SLOs = append(config.SLOs, slo)
+	}
+
+	return nil
+}
+
+func (slo *SLOConfig) validate(config *Config) error {
+	if slo.Service == "" {
+		return fmt.Errorf("no service set")
+	}
+	if slo.Objective <= 0 || slo.Objective >= 100 {
+		return fmt.Errorf("objective must be between 0 and 100 percent")
+	}
+
+	if slo.Window == "" {
+		slo.Window = "720h"
+	}
+	window, err := time.ParseDuration(slo.Window)
+	if err != nil {
+		return fmt.Errorf("invalid window: %s", err)
+	}
+	if slo.windowMinutes = int(window / time.Minute); slo.windowMinutes < 1 {
+		return fmt.Errorf("window must be at least a minute")
+	}
+
+	slo.burnWindows = make([]sloBurnWindow, 0, len(slo.BurnRates))
+	for name, rate := range slo.BurnRates {
+		burnWindow, err := time.ParseDuration(name)
+		if err != nil {
+			return fmt.Errorf("invalid burn rate window %q: %s", name, err)
+		}
+		minutes := int(burnWindow / time.Minute)
+		if minutes < 1 || minutes > slo.windowMinutes {
+			return fmt.Errorf("burn rate window %s must be between a minute and the window", name)
+		}
+		if rate <= 0 {
+			return fmt.Errorf("burn rate for %s must be positive", name)
+		}
+		slo.burnWindows = append(slo.burnWindows, sloBurnWindow{name, minutes, rate})
+	}
+	sort.Slice(slo.burnWindows, func(i, j int) bool {
+		return slo.burnWindows[i].minutes < slo.burnWindows[j].minutes
+	})
+
+	if slo.Status == "" {
+		slo.Status = api.HealthCritical
+	}
+	if slo.Status != api.HealthWarning && slo.Status != api.HealthCritical {
+		return fmt.Errorf("invalid status %s, must be warning or critical", slo.Status)
+	}
+
+	for _, name := range slo.Handlers {
+		if _, ok := config.Handlers[name]; !ok {
+			return fmt.Errorf("unknown handler %s", name)
+		}
+	}
+	return nil
+}
+
+// The availability samples of an SLO, one bit per minute in rings as long as its window,
+// and the status last alerted for it
+type sloState struct {
+	Status string `json:"status"`
+
+	// The last minute sampled, in minutes since the unix epoch
+	Last int64 `json:"last"`
+
+	// The minutes that were sampled, and those that were unavailable
+	Seen []byte `json:"seen"`
+	Bad  []byte `json:"bad"`
+}
+
+func newSLOState(minutes int) *sloState {
+	size := (minutes + 7) / 8
+	return &sloState{Status: api.HealthPassing, Seen: make([]byte, size), Bad: make([]byte, size)}
+}
+
+func (s *sloState) size() int {
+	return len(s.Seen) * 8
+}
+
+func (s *sloState) set(bits []byte, minute int64, value bool) {
+	slot := int(minute % int64(s.size()))
+	if value {
+		bits[slot/8] |= 1 << uint(slot%8)
+	} else {
+		bits[slot/8] &^= 1 << uint(slot%8)
+	}
+}
+
+func (s *sloState) get(bits []byte, minute int64) bool {
+	slot := int(minute % int64(s.size()))
+	return bits[slot/8]&(1<<uint(slot%8)) != 0
+}
+
+// Records a sample for a minute. Minutes skipped since the last sample, like while
+// consul-alerting was down, are left unsampled.
+func (s *sloState) record(minute int64, bad bool) {
+	if minute < s.Last {
+		return
+	}
+	if minute > s.Last {
+		start := s.Last + 1
+		if minute-start >= int64(s.size()) {
+			start = minute - int64(s.size()) + 1
+		}
+		for m := start; m <= minute; m++ {
+			s.set(s.Seen, m, false)
+			s.set(s.Bad, m, false)
+		}
+		s.Last = minute
+	}
+	s.set(s.Seen, minute, true)
+	if bad {
+		s.set(s.Bad, minute, true)
+	}
+}
+
+// Returns the unavailable and sampled minutes of the given number of minutes up to and
+// including now
+func (s *sloState) count(now int64, minutes int) (int, int) {
+	bad, seen := 0, 0
+	for m := now - int64(minutes) + 1; m <= now; m++ {
+		if m < 0 || m > s.Last || m <= s.Last-int64(s.size()) {
+			continue
+		}
+		if s.get(s.Seen, m) {
+			seen++
+			if s.get(s.Bad, m) {
+				bad++
+			}
+		}
+	}
+	return bad, seen
+}
+
+// Evaluates an SLO as of a minute, returning its status and a description of its
+// availability and any violations. Minutes that weren't sampled count as available, so a
+// new SLO doesn't alert on its first unavailable minutes.
+func (slo *SLOConfig) evaluate(state *sloState, now int64) (string, string) {
+	budget := (100 - slo.Objective) / 100
+	bad, seen := state.count(now, slo.windowMinutes)
+
+	availability := 100.0
+	if seen > 0 {
+		availability = 100 * float64(seen-bad) / float64(seen)
+	}
+	lines := []string{fmt.Sprintf("Availability over %s: %.3f%% (%d of %d sampled minutes unavailable), objective %g%%", slo.Window, availability, bad, seen, slo.Objective)}
+
+	status := api.HealthPassing
+	if allowed := budget * float64(slo.windowMinutes); float64(bad) > allowed {
+		status = slo.Status
+		lines = append(lines, fmt.Sprintf("Error budget exhausted: %d unavailable minutes, %.1f allowed", bad, allowed))
+	}
+	for _, burn := range slo.burnWindows {
+		burnBad, _ := state.count(now, burn.minutes)
+		rate := float64(burnBad) / (budget * float64(burn.minutes))
+		if rate > burn.rate {
+			status = slo.Status
+			lines = append(lines, fmt.Sprintf("Burning the error budget at %.1fx over %s (limit %gx)", rate, burn.name, burn.rate))
+		}
+	}
+	return status, strings.Join(lines, "\n")
+}
+
+// Returns whether a service's checks make it unavailable for its SLO. Services with
+// instance thresholds are unavailable when the thresholds make them critical.
+func sloUnavailable(config *Config, service string, checks []*api.HealthCheck) bool {
+	if thresholds := config.serviceInstanceThresholds(service); thresholds.set() {
+		return thresholds.health(countInstances(checks)) == api.HealthCritical
+	}
+	statuses := make(map[string]string, len(checks))
+	for _, check := range checks {
+		statuses[check.Node+"/"+check.CheckID] = check.Status
+	}
+	return computeHealth(statuses) == api.HealthCritical
+}
+
+// Samples the health of an SLO's service and alerts when the SLO is violated, evaluating
+// it once a minute
+func watchSLO(slo SLOConfig, config *Config, client *api.Client, stopCh chan struct{}) {
+	kvPath := slosKVPath + slo.Name
+	var state *sloState
+	var lastSample time.Time
+	watchRegistrations("slo "+slo.Name, kvPath, config, client, stopCh,
+		func(config *Config, queryOpts *api.QueryOptions) (*api.QueryMeta, error) {
+			time.Sleep(sloSampleInterval)
+
+			// Another instance may have held the lock since the last sample
+			now := time.Now()
+			if state == nil || now.Sub(lastSample) > 3*sloSampleInterval {
+				empty := newSLOState(slo.windowMinutes)
+				loaded := &sloState{}
+				found, err := getRegistrationState(client, kvPath, loaded)
+				if err != nil {
+					return nil, err
+				}
+
+				// Samples stored for a different window are started over
+				if !found || len(loaded.Seen) != len(empty.Seen) || len(loaded.Bad) != len(empty.Bad) {
+					loaded = empty
+				}
+				state = loaded
+			}
+			lastSample = now
+
+			checks, _, err := client.Health().Checks(slo.Service, config.queryOptions())
+			if err != nil {
+				return nil, err
+			}
+			checks, _ = config.filterIgnoredChecks(slo.Service, config.applyOutputRules(checks))
+
+			minute := now.Unix() / 60
+			previous := state.Last
+			state.record(minute, sloUnavailable(config, slo.Service, checks))
+			if previous == minute {
+				return nil, nil
+			}
+
+			status, details := slo.evaluate(state, minute)
+			lastAlerted := state.Status
+			state.Status = status
+			if err := setRegistrationState(client, kvPath, state); err != nil {
+				return nil, err
+			}
+			if status != lastAlerted {
+				notifySLO(config, &slo, status, lastAlerted, details)
+			}
+			return nil, nil
+		})
+}
+
+// Sends the alert for an SLO being violated or met again
+func notifySLO(config *Config, slo *SLOConfig, status string, lastAlerted string, details string) {
+	datacenter := config.ConsulDatacenter
+	if len(slo.Handlers) > 0 {
+		config = config.withCheckSettings(slo.Service, &CheckConfig{Handlers: slo.Handlers})
+	}
+
+	alert := &AlertState{
+		Status:      status,
+		Service:     slo.Service,
+		Check:       "slo:" + slo.Name,
+		LastAlerted: lastAlerted,
+		Details:     details,
+	}
+	if status == api.HealthPassing {
+		alert.Message = fmt.Sprintf("[%s] service %s meets its SLO %s of %g%% again", datacenter, slo.Service, slo.Name, slo.Objective)
+	} else {
+		alert.Message = fmt.Sprintf("[%s] service %s is violating its SLO %s of %g%%", datacenter, slo.Service, slo.Name, slo.Objective)
+	}
+
+	alert.Severity = config.alertSeverity(slo.Service, alert.Status)
+	config.applyMessageTemplates(slo.Service, alert)
+	notifyHandlers(config, slo.Service, nil, alert, lastAlerted)
+	activeAlerts.update(datacenter, alert)
+	countAlert(alert)
+	history.record(datacenter, alert, time.Now())
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestSLO_parse(t *testing.T) {
+	config, err := ParseConfig(`
+slo "api-availability" {
+  service = "api"
+  objective = 99.9
+  window = "24h"
+  burn_rates = {
+    "1h" = 14.4
+    "6h" = 6
+  }
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.SLOs) != 1 {
+		t.Fatalf("expected one slo, got %d", len(config.SLOs))
+	}
+	slo := config.SLOs[0]
+	if slo.Service != "api" || slo.Objective != 99.9 || slo.windowMinutes != 1440 || slo.Status != api.HealthCritical {
+		t.Errorf("unexpected slo: %+v", slo)
+	}
+	if len(slo.burnWindows) != 2 || slo.burnWindows[0] != (sloBurnWindow{"1h", 60, 14.4}) || slo.burnWindows[1] != (sloBurnWindow{"6h", 360, 6}) {
+		t.Errorf("unexpected burn windows: %+v", slo.burnWindows)
+	}
+
+	config, err = ParseConfig(`slo "default" { service = "api" objective = 99 }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SLOs[0].windowMinutes != 30*24*60 {
+		t.Errorf("expected a 30 day window by default, got %d minutes", config.SLOs[0].windowMinutes)
+	}
+
+	for _, raw := range []string{
+		`slo "noservice" { objective = 99 }`,
+		`slo "badobjective" { service = "api" objective = 100 }`,
+		`slo "badwindow" { service = "api" objective = 99 window = "30d" }`,
+		`slo "longburn" { service = "api" objective = 99 window = "1h" burn_rates = { "2h" = 2 } }`,
+		`slo "badstatus" { service = "api" objective = 99 status = "passing" }`,
+	} {
+		if _, err := ParseConfig(raw); err == nil || !strings.Contains(err.Error(), "Error loading slo") {
+			t.Errorf("expected an error for %s, got %v", raw, err)
+		}
+	}
+}
+
+func TestSLO_samples(t *testing.T) {
+	state := newSLOState(60)
+	start := int64(1000000)
+
+	state.record(start, false)
+	state.record(start, true)
+	state.record(start+1, false)
+	// Minutes skipped are left unsampled
+	state.record(start+5, true)
+
+	if bad, seen := state.count(start+5, 60); bad != 2 || seen != 3 {
+		t.Errorf("expected 2 of 3 minutes unavailable, got %d of %d", bad, seen)
+	}
+	if bad, seen := state.count(start+5, 2); bad != 1 || seen != 1 {
+		t.Errorf("expected 1 of 1 recent minutes unavailable, got %d of %d", bad, seen)
+	}
+
+	// Samples older than the window are forgotten as the ring wraps around
+	state.record(start+64, false)
+	if bad, seen := state.count(start+64, 60); bad != 1 || seen != 2 {
+		t.Errorf("expected only the samples within the window, got %d of %d", bad, seen)
+	}
+
+	// Samples for minutes already passed are ignored
+	state.record(start+10, true)
+	if bad, _ := state.count(start+64, 60); bad != 1 {
+		t.Errorf("expected an old sample to be ignored, got %d unavailable minutes", bad)
+	}
+}
+
+func TestSLO_evaluate(t *testing.T) {
+	config, err := ParseConfig(`
+slo "api" {
+  service = "api"
+  objective = 99
+  window = "1000m"
+  burn_rates = { "1h" = 10 }
+  status = "warning"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slo := &config.SLOs[0]
+	now := int64(5000000)
+	samples := func(unavailable func(minute int64) bool) *sloState {
+		state := newSLOState(slo.windowMinutes)
+		for m := now - 999; m <= now; m++ {
+			state.record(m, unavailable(m))
+		}
+		return state
+	}
+
+	// 5 unavailable minutes in the last hour burn the budget of 10 minutes at 8.3x
+	state := samples(func(m int64) bool { return m > now-5 })
+	if status, details := slo.evaluate(state, now); status != api.HealthPassing || !strings.Contains(details, "99.500%") {
+		t.Errorf("expected the slo to be met, got %s: %s", status, details)
+	}
+
+	// 7 unavailable minutes burn it at 11.7x
+	state = samples(func(m int64) bool { return m > now-7 })
+	status, details := slo.evaluate(state, now)
+	if status != api.HealthWarning || !strings.Contains(details, "Burning the error budget at 11.7x over 1h") {
+		t.Errorf("expected a fast burn, got %s: %s", status, details)
+	}
+	if strings.Contains(details, "exhausted") {
+		t.Errorf("expected the budget not to be exhausted yet: %s", details)
+	}
+
+	// Once more than 10 minutes are unavailable the budget is exhausted
+	state = samples(func(m int64) bool { return m > now-7 || (m >= now-500 && m < now-496) })
+	if _, details := slo.evaluate(state, now); !strings.Contains(details, "Error budget exhausted: 11 unavailable minutes, 10.0 allowed") {
+		t.Errorf("expected the budget to be exhausted: %s", details)
+	}
+}
+
+func TestSLO_unavailable(t *testing.T) {
+	config, err := ParseConfig(`
+service "api" {
+  critical_below_count = 2
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []*api.HealthCheck{
+		{Node: "node1", ServiceID: "api", CheckID: "http", Status: api.HealthCritical},
+		{Node: "node2", ServiceID: "api", CheckID: "http", Status: api.HealthPassing},
+		{Node: "node3", ServiceID: "api", CheckID: "http", Status: api.HealthPassing},
+	}
+	if sloUnavailable(config, "api", checks) {
+		t.Error("expected the instance thresholds to keep the service available")
+	}
+	if !sloUnavailable(config, "web", checks) {
+		t.Error("expected a critical check to make a service without thresholds unavailable")
+	}
+}
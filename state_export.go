@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The service and TTL check registered on the local agent with state_export_check, how
+// long the check stays passing without an update, and how often it's updated while
+// nothing changes. The check belongs to a service of its own, as a failing node check
+// would fail every other service on the node.
+const (
+	stateExportServiceID    = "consul-alerting-alerts"
+	stateExportServiceName  = "consul-alerting"
+	stateExportCheckID      = "consul-alerting:alerts"
+	stateExportCheckTTL     = "90s"
+	stateExportRefreshEvery = 30 * time.Second
+)
+
+// Writes this instance's view of the open alerts back into Consul, for other automation
+// and dashboards to read: each open alert as a key under the KV prefix, and/or a TTL
+// check on the local agent with the worst status of the open alerts. Changes are written
+// from a goroutine of its own, so a slow Consul doesn't hold up the alerts.
+type stateExporter struct {
+	client   *api.Client
+	kvPrefix string
+	check    bool
+
+	sync.Mutex
+	// The alerts changed since the last write, by KV key. Recovered alerts are kept
+	// until their key is deleted.
+	pending    map[string]alertPayload
+	registered bool
+
+	notify chan struct{}
+	stopCh chan chan struct{}
+}
+
+var stateExport = struct {
+	sync.Mutex
+	exporter *stateExporter
+}{}
+
+// Starts exporting the open alerts to Consul, if state_export_kv_prefix or
+// state_export_check is set
+func startStateExport(config *Config, client *api.Client) {
+	if config.StateExportKVPrefix == "" && !config.StateExportCheck {
+		return
+	}
+
+	exporter := &stateExporter{
+		client:   client,
+		kvPrefix: config.StateExportKVPrefix,
+		check:    config.StateExportCheck,
+		pending:  make(map[string]alertPayload),
+		notify:   make(chan struct{}, 1),
+		stopCh:   make(chan chan struct{}),
+	}
+	go exporter.run()
+
+	stateExport.Lock()
+	defer stateExport.Unlock()
+	stateExport.exporter = exporter
+}
+
+// Queues an alert's new state to be written to Consul
+func exportAlertState(datacenter string, alert *AlertState) {
+	stateExport.Lock()
+	exporter := stateExport.exporter
+	stateExport.Unlock()
+	if exporter == nil {
+		return
+	}
+
+	copied := *alert
+	exporter.Lock()
+	exporter.pending[stateExportKey(datacenter, alert)] = alertPayload{datacenter, &copied}
+	exporter.Unlock()
+	select {
+	case exporter.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Writes the last changes and removes the exported service and check on shutdown, so a
+// stopped instance doesn't leave them to fail once the TTL runs out
+func stopStateExport(timeout time.Duration) {
+	stateExport.Lock()
+	exporter := stateExport.exporter
+	stateExport.exporter = nil
+	stateExport.Unlock()
+	if exporter == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	select {
+	case exporter.stopCh <- done:
+	case <-time.After(timeout):
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// Returns the key of an alert under the KV prefix: <datacenter>/<service>/<node>/<check>,
+// with "_" for the parts it doesn't have. Alerts on a tag of a service use
+// <service>:<tag>.
+func stateExportKey(datacenter string, alert *AlertState) string {
+	service := alert.scopedService()
+	if alert.Tag != "" {
+		service += ":" + alert.Tag
+	}
+	parts := []string{datacenter, service, alert.scopedNode(), alert.Check}
+	for i, part := range parts {
+		if part == "" {
+			parts[i] = "_"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (e *stateExporter) run() {
+	ticker := time.NewTicker(stateExportRefreshEvery)
+	defer ticker.Stop()
+
+	e.write()
+	for {
+		select {
+		case <-e.notify:
+		case <-ticker.C:
+		case done := <-e.stopCh:
+			e.write()
+			if e.check {
+				if err := e.client.Agent().ServiceDeregister(stateExportServiceID); err != nil {
+					log.Errorf("Error deregistering the %s service: %s", stateExportServiceID, err)
+				}
+			}
+			close(done)
+			return
+		}
+		e.write()
+	}
+}
+
+// Writes the changed alerts to the KV store and updates the check. Keys that couldn't be
+// written are tried again on the next write, unless the alert changed again since.
+func (e *stateExporter) write() {
+	e.Lock()
+	pending := e.pending
+	e.pending = make(map[string]alertPayload)
+	e.Unlock()
+
+	if e.kvPrefix != "" {
+		for key, payload := range pending {
+			if err := e.writeKey(key, payload); err != nil {
+				log.Errorf("Error exporting the state of alert '%s': %s", payload.Message, err)
+				e.Lock()
+				if _, ok := e.pending[key]; !ok {
+					e.pending[key] = payload
+				}
+				e.Unlock()
+			}
+		}
+	}
+	if e.check {
+		if err := e.updateCheck(activeAlerts.list()); err != nil {
+			log.Errorf("Error updating the %s check: %s", stateExportCheckID, err)
+		}
+	}
+}
+
+// Writes an open alert's state as JSON in the format of the webhook handler, or deletes
+// it once the alert has recovered
+func (e *stateExporter) writeKey(key string, payload alertPayload) error {
+	if payload.Status == api.HealthPassing {
+		_, err := e.client.KV().Delete(e.kvPrefix+key, nil)
+		return err
+	}
+
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = e.client.KV().Put(&api.KVPair{Key: e.kvPrefix + key, Value: value}, nil)
+	return err
+}
+
+// Sets the check to the worst status of the open alerts, listing them in its output.
+// The service and check are registered first if they aren't yet, like after the agent
+// restarted.
+func (e *stateExporter) updateCheck(alerts []AlertState) error {
+	status, output := stateExportSummary(alerts)
+
+	e.Lock()
+	registered := e.registered
+	e.Unlock()
+	if !registered {
+		err := e.client.Agent().ServiceRegister(&api.AgentServiceRegistration{
+			ID:   stateExportServiceID,
+			Name: stateExportServiceName,
+			Tags: []string{"alerts"},
+		})
+		if err != nil {
+			return err
+		}
+		err = e.client.Agent().CheckRegister(&api.AgentCheckRegistration{
+			ID:        stateExportCheckID,
+			Name:      "Consul alerting open alerts",
+			Notes:     "The alerts open on this consul-alerting instance",
+			ServiceID: stateExportServiceID,
+			AgentServiceCheck: api.AgentServiceCheck{
+				TTL: stateExportCheckTTL,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := e.client.Agent().UpdateTTL(stateExportCheckID, output, status)
+	e.Lock()
+	e.registered = err == nil
+	e.Unlock()
+	return err
+}
+
+// Returns the worst status of the open alerts and a summary of them
+func stateExportSummary(alerts []AlertState) (string, string) {
+	if len(alerts) == 0 {
+		return api.HealthPassing, "No open alerts"
+	}
+
+	status := api.HealthWarning
+	lines := []string{fmt.Sprintf("%d open alerts:", len(alerts))}
+	if len(alerts) == 1 {
+		lines[0] = "1 open alert:"
+	}
+	for _, alert := range alerts {
+		if alert.Status == api.HealthCritical {
+			status = api.HealthCritical
+		}
+		lines = append(lines, alert.Message)
+	}
+	return status, strings.Join(lines, "\n")
+}
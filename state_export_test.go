@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestStateExport_kv(t *testing.T) {
+	client, values, stop := testFakeKV(t)
+	defer stop()
+
+	config := DefaultConfig()
+	config.StateExportKVPrefix = "consul-alerting/state/"
+	startStateExport(config, client)
+
+	exportAlertState("dc1", &AlertState{Status: api.HealthCritical, Service: "redis", Node: "web1", Message: "redis is critical"})
+	exportAlertState("dc1", &AlertState{Status: api.HealthWarning, Node: "web2", Check: "memory", Message: "web2 is warning"})
+	exportAlertState("dc1", &AlertState{Status: api.HealthPassing, Service: "nginx", Tag: "edge", Node: "web1"})
+	stopStateExport(5 * time.Second)
+
+	var exported alertPayload
+	if err := json.Unmarshal(values["consul-alerting/state/dc1/redis/web1/_"], &exported); err != nil {
+		t.Fatalf("expected the redis alert to be exported, got %v: %s", values, err)
+	}
+	if exported.Datacenter != "dc1" || exported.Status != api.HealthCritical || exported.Message != "redis is critical" {
+		t.Errorf("unexpected exported alert: %+v", exported)
+	}
+	if _, ok := values["consul-alerting/state/dc1/_/web2/memory"]; !ok {
+		t.Errorf("expected the node alert to be exported, got %v", values)
+	}
+
+	// Recovering alerts are removed
+	if len(values) != 2 {
+		t.Errorf("expected 2 keys, got %v", values)
+	}
+	values["consul-alerting/state/dc1/nginx:edge/web1/_"] = []byte("{}")
+	startStateExport(config, client)
+	exportAlertState("dc1", &AlertState{Status: api.HealthPassing, Service: "nginx", Tag: "edge", Node: "web1"})
+	stopStateExport(5 * time.Second)
+	if _, ok := values["consul-alerting/state/dc1/nginx:edge/web1/_"]; ok {
+		t.Errorf("expected the recovered alert to be deleted, got %v", values)
+	}
+}
+
+func TestStateExport_check(t *testing.T) {
+	var lock sync.Mutex
+	var requests []string
+	var service api.AgentServiceRegistration
+	var check api.AgentCheckRegistration
+	var update struct {
+		Status string
+		Output string
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/v1/agent/service/register":
+			json.Unmarshal(body, &service)
+		case r.URL.Path == "/v1/agent/check/register":
+			json.Unmarshal(body, &check)
+		case strings.HasPrefix(r.URL.Path, "/v1/agent/check/update/"):
+			json.Unmarshal(body, &update)
+		}
+	}))
+	defer server.Close()
+	config := api.DefaultConfig()
+	config.Address = strings.TrimPrefix(server.URL, "http://")
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exporter := &stateExporter{client: client, check: true, pending: make(map[string]alertPayload)}
+	alerts := []AlertState{
+		{Status: api.HealthWarning, Message: "web2 is warning"},
+		{Status: api.HealthCritical, Message: "redis is critical"},
+	}
+	if err := exporter.updateCheck(alerts); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.updateCheck(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	expected := []string{
+		"PUT /v1/agent/service/register",
+		"PUT /v1/agent/check/register",
+		"PUT /v1/agent/check/update/" + stateExportCheckID,
+		"PUT /v1/agent/check/update/" + stateExportCheckID,
+	}
+	if strings.Join(requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected the check to be registered once and updated, got %v", requests)
+	}
+
+	// The check belongs to a service of its own, since a failing node check would fail
+	// every service on the node
+	if service.ID != stateExportServiceID || service.Name != "consul-alerting" {
+		t.Errorf("unexpected service registration: %+v", service)
+	}
+	if check.ID != stateExportCheckID || check.ServiceID != stateExportServiceID || check.TTL == "" {
+		t.Errorf("expected the check to be registered on the service, got %+v", check)
+	}
+	if update.Status != api.HealthPassing || update.Output != "No open alerts" {
+		t.Errorf("unexpected check update: %+v", update)
+	}
+}
+
+func TestStateExport_summary(t *testing.T) {
+	status, output := stateExportSummary([]AlertState{
+		{Status: api.HealthWarning, Message: "web2 is warning"},
+		{Status: api.HealthCritical, Message: "redis is critical"},
+	})
+	if status != api.HealthCritical || output != "2 open alerts:\nweb2 is warning\nredis is critical" {
+		t.Errorf("unexpected summary: %s, %q", status, output)
+	}
+
+	status, output = stateExportSummary([]AlertState{{Status: api.HealthWarning, Message: "web2 is warning"}})
+	if status != api.HealthWarning || output != "1 open alert:\nweb2 is warning" {
+		t.Errorf("unexpected summary: %s, %q", status, output)
+	}
+
+	// The exported check doesn't alert on itself
+	if !DefaultConfig().ignoresCheck("", &api.HealthCheck{CheckID: stateExportCheckID}) {
+		t.Error("expected the exported check to be ignored")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Where the alert and check states and the dedupe records are kept
+const (
+	StateStoreConsul = "consul"
+	StateStoreLocal  = "local"
+)
+
+// A key/value store for the alert and check states, keyed by their Consul KV paths
+type stateStore interface {
+	// Returns the value of a key and whether it's set
+	get(key string) ([]byte, bool, error)
+	put(key string, value []byte) error
+
+	// Sets a key to a value only if it still holds the old one, or isn't set if old is nil,
+	// returning whether it was set
+	cas(key string, old, value []byte) (bool, error)
+
+	// Returns the keys and values under a prefix
+	list(prefix string) (map[string][]byte, error)
+}
+
+// Keeps the state in the Consul KV store, shared by every instance
+type consulStore struct {
+	client *api.Client
+}
+
+func (s consulStore) get(key string) ([]byte, bool, error) {
+	kvPair, _, err := s.client.KV().Get(key, nil)
+	if err != nil || kvPair == nil {
+		return nil, false, err
+	}
+	return kvPair.Value, true, nil
+}
+
+func (s consulStore) put(key string, value []byte) error {
+	_, err := s.client.KV().Put(&api.KVPair{Key: key, Value: value}, nil)
+	return err
+}
+
+func (s consulStore) cas(key string, old, value []byte) (bool, error) {
+	kvPair, _, err := s.client.KV().Get(key, nil)
+	if err != nil {
+		return false, err
+	}
+
+	var index uint64
+	if kvPair != nil {
+		if old == nil || !bytes.Equal(kvPair.Value, old) {
+			return false, nil
+		}
+		index = kvPair.ModifyIndex
+	} else if old != nil {
+		return false, nil
+	}
+
+	stored, _, err := s.client.KV().CAS(&api.KVPair{Key: key, Value: value, ModifyIndex: index}, nil)
+	return stored, err
+}
+
+func (s consulStore) list(prefix string) (map[string][]byte, error) {
+	pairs, _, err := s.client.KV().List(prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(pairs))
+	for _, pair := range pairs {
+		values[pair.Key] = pair.Value
+	}
+	return values, nil
+}
+
+// Keeps the state in files in a local directory, one file per key named after its hash,
+// for deployments that don't want consul-alerting writing to the KV store. The values are
+// also held in memory, so only writes touch the disk.
+type localStore struct {
+	sync.Mutex
+	dir    string
+	values map[string][]byte
+}
+
+// The contents of a local state file
+type localStateEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+var localState = struct {
+	sync.Mutex
+	store *localStore
+}{}
+
+// Opens the local store in a directory, loading the state left in it
+func openLocalStore(dir string) (*localStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Error creating state directory: %s", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.state"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &localStore{dir: dir, values: make(map[string][]byte)}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading state file: %s", err)
+		}
+		var entry localStateEntry
+		if err := json.Unmarshal(contents, &entry); err != nil {
+			log.Errorf("Removing invalid state file %s: %s", file, err)
+			os.Remove(file)
+			continue
+		}
+		s.values[entry.Key] = entry.Value
+	}
+	return s, nil
+}
+
+// Opens the local store in state_dir when state_store is local
+func startStateStore(config *Config) error {
+	if config.StateStore != StateStoreLocal {
+		return nil
+	}
+	s, err := openLocalStore(config.StateDir)
+	if err != nil {
+		return err
+	}
+	log.Infof("Keeping the alert state in %s (%d keys)", config.StateDir, len(s.values))
+
+	localState.Lock()
+	localState.store = s
+	localState.Unlock()
+	return nil
+}
+
+// Returns the store the state is kept in: the local store when it's open, or else the
+// Consul KV store of the client
+func stateKV(client *api.Client) stateStore {
+	localState.Lock()
+	defer localState.Unlock()
+	if localState.store != nil {
+		return localState.store
+	}
+	return consulStore{client}
+}
+
+func (s *localStore) get(key string) ([]byte, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	value, ok := s.values[key]
+	return value, ok, nil
+}
+
+// Writes a value through a temporary file so a crash can't leave half of it. A store
+// without a directory, as used by dry runs, only keeps the value in memory.
+func (s *localStore) put(key string, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.write(key, value)
+}
+
+// Writes a value with the store's lock held
+func (s *localStore) write(key string, value []byte) error {
+	if s.dir == "" {
+		s.values[key] = append([]byte{}, value...)
+		return nil
+	}
+	contents, err := json.Marshal(localStateEntry{Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(key))
+	path := filepath.Join(s.dir, hex.EncodeToString(hash[:])+".state")
+	if err := ioutil.WriteFile(path+".tmp", contents, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	s.values[key] = append([]byte{}, value...)
+	return nil
+}
+
+// The local store is only used by a single instance, so the check and the write only need
+// to hold the store's lock against the other watches
+func (s *localStore) cas(key string, old, value []byte) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	current, ok := s.values[key]
+	if ok != (old != nil) || !bytes.Equal(current, old) {
+		return false, nil
+	}
+	return true, s.write(key, value)
+}
+
+func (s *localStore) list(prefix string) (map[string][]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	values := make(map[string][]byte)
+	for key, value := range s.values {
+		if strings.HasPrefix(key, prefix) {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateStore_local(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := openLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := store.get("service/consul-alerting/service/redis/alert"); ok {
+		t.Fatal("expected no value in an empty store")
+	}
+	if err := store.put("service/consul-alerting/service/redis/alert", []byte(`{"status":"critical"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.put("service/consul-alerting/service/redis/node1/check", []byte(`{"status":"critical"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.put("service/consul-alerting/node/node1/serfHealth", []byte(`{"status":"passing"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The state is loaded again from the directory
+	store, err = openLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, ok, err := store.get("service/consul-alerting/service/redis/alert")
+	if err != nil || !ok || string(value) != `{"status":"critical"}` {
+		t.Errorf("unexpected value: %s, %v, %v", value, ok, err)
+	}
+	values, _ := store.list("service/consul-alerting/service/redis/")
+	if len(values) != 2 {
+		t.Errorf("expected 2 values under the service, got %d", len(values))
+	}
+
+	// A check-and-set only writes over the value it was given
+	if set, err := store.cas("service/consul-alerting/incidents/dc1", nil, []byte("1")); err != nil || !set {
+		t.Fatalf("expected an unset key to be set, got %v, %v", set, err)
+	}
+	if set, _ := store.cas("service/consul-alerting/incidents/dc1", nil, []byte("2")); set {
+		t.Error("expected a set key not to be overwritten")
+	}
+	if set, _ := store.cas("service/consul-alerting/incidents/dc1", []byte("2"), []byte("3")); set {
+		t.Error("expected a changed key not to be overwritten")
+	}
+	if set, _ := store.cas("service/consul-alerting/incidents/dc1", []byte("1"), []byte("3")); !set {
+		t.Error("expected the key to be overwritten")
+	}
+	if value, _, _ := store.get("service/consul-alerting/incidents/dc1"); string(value) != "3" {
+		t.Errorf("unexpected value: %s", value)
+	}
+}
+
+func TestStateStore_stateKV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, ok := stateKV(nil).(consulStore); !ok {
+		t.Fatal("expected the state to be kept in Consul by default")
+	}
+
+	config, err := ParseConfig(`
+	state_store = "local"
+	state_dir = "` + dir + `"
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.QueueDir != filepath.Join(dir, "queue") {
+		t.Errorf("expected the queue to be kept in the state directory, got %s", config.QueueDir)
+	}
+
+	defer func() { localState.store = nil }()
+	if err := startStateStore(config); err != nil {
+		t.Fatal(err)
+	}
+	alert := &AlertState{Service: "redis", Status: "critical", LastAlerted: "critical"}
+	if err := setAlertState("service/consul-alerting/service/redis/alert", alert, nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := getAlertState("service/consul-alerting/service/redis/alert", nil)
+	if err != nil || stored == nil || stored.LastAlerted != "critical" {
+		t.Errorf("unexpected alert state: %+v, %v", stored, err)
+	}
+
+	if _, err := ParseConfig(`state_store = "local"`); err == nil {
+		t.Error("expected an error without state_dir")
+	}
+	if _, err := ParseConfig(`state_store = "bolt"`); err == nil {
+		t.Error("expected an error for an unknown state_store")
+	}
+}
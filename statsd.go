@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Sends metric updates to a StatsD server over UDP. DogStatsD servers get the labels as
+// tags; plain StatsD has no tags, so the label values are appended to the metric name.
+type statsdSink struct {
+	conn      net.Conn
+	prefix    string
+	dogstatsd bool
+}
+
+func newStatsdSink(address string, prefix string, dogstatsd bool) (*statsdSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to StatsD at %s: %s", address, err)
+	}
+	return &statsdSink{conn: conn, prefix: prefix, dogstatsd: dogstatsd}, nil
+}
+
+// Starts sending the internal metrics to the configured StatsD server
+func startStatsd(config *Config) error {
+	sink, err := newStatsdSink(config.StatsdAddress, config.StatsdPrefix, config.StatsdDogStatsD)
+	if err != nil {
+		return err
+	}
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.sink = sink
+	return nil
+}
+
+// The StatsD type of each kind of metric. Histograms are all durations in seconds here,
+// so they're sent as timers in milliseconds.
+var statsdTypes = map[string]string{
+	"counter":   "c",
+	"gauge":     "g",
+	"histogram": "ms",
+}
+
+// Sends a metric update: the delta of a counter, the new value of a gauge or an
+// observation of a histogram. Errors are ignored, as StatsD is best effort.
+func (s *statsdSink) emit(m *metric, value float64, labels []string) {
+	if m.kind == "histogram" {
+		value *= 1000
+	}
+	s.conn.Write([]byte(s.format(m, value, labels)))
+}
+
+func (s *statsdSink) format(m *metric, value float64, labels []string) string {
+	name := s.prefix + strings.TrimSuffix(strings.TrimPrefix(m.name, "consul_alerting_"), "_seconds")
+	stat := strconv.FormatFloat(value, 'f', -1, 64) + "|" + statsdTypes[m.kind]
+
+	if s.dogstatsd {
+		tags := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			tags = append(tags, labels[i]+":"+statsdSanitize(labels[i+1]))
+		}
+		if len(tags) > 0 {
+			stat += "|#" + strings.Join(tags, ",")
+		}
+		return name + ":" + stat
+	}
+
+	for i := 1; i < len(labels); i += 2 {
+		if labels[i] != "" {
+			name += "." + strings.Replace(statsdSanitize(labels[i]), ".", "_", -1)
+		}
+	}
+	return name + ":" + stat
+}
+
+// Replaces the characters that have a meaning in the StatsD protocol
+func statsdSanitize(s string) string {
+	return strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", " ", "_").Replace(s)
+}
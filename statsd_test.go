@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatsd_format(t *testing.T) {
+	counter := &metric{name: "consul_alerting_handler_sends_total", kind: "counter"}
+	latency := &metric{name: "consul_alerting_handler_send_duration_seconds", kind: "histogram"}
+
+	statsd := &statsdSink{prefix: "consul_alerting."}
+	if line := statsd.format(counter, 1, []string{"handler", "slack.ops", "result", "failure"}); line != "consul_alerting.handler_sends_total.slack_ops.failure:1|c" {
+		t.Errorf("unexpected StatsD line: %s", line)
+	}
+
+	dogstatsd := &statsdSink{prefix: "alerting.", dogstatsd: true}
+	if line := dogstatsd.format(counter, 1, []string{"handler", "slack.ops", "result", "failure"}); line != "alerting.handler_sends_total:1|c|#handler:slack.ops,result:failure" {
+		t.Errorf("unexpected DogStatsD line: %s", line)
+	}
+	if line := dogstatsd.format(latency, 250, []string{"handler", "slack.ops"}); line != "alerting.handler_send_duration:250|ms|#handler:slack.ops" {
+		t.Errorf("unexpected DogStatsD line: %s", line)
+	}
+}
+
+func TestStatsd_emit(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	sink, err := newStatsdSink(server.LocalAddr().String(), "consul_alerting.", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := &metricRegistry{metrics: make(map[string]*metric), sink: sink}
+	queueLength := registry.register("consul_alerting_dispatch_queue_length", "Test gauge.", "gauge")
+
+	queueLength.add(3, "worker", "0")
+	queueLength.add(-1, "worker", "0")
+
+	buf := make([]byte, 512)
+	for _, expected := range []string{
+		"consul_alerting.dispatch_queue_length:3|g|#worker:0",
+		"consul_alerting.dispatch_queue_length:2|g|#worker:0",
+	} {
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != expected {
+			t.Errorf("expected %s, got %s", expected, buf[:n])
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const statusUsage = `Usage: consul-alerting status [options] [<config>]
+
+Shows what a running daemon is doing: its watches per datacenter, open alerts, active
+silences and the health of its handlers. The daemon is reached over its HTTP API, at
+the http_address and with the api_token of the config file if one is given.
+Exits with 1 if the daemon can't be reached.
+
+Options:
+
+    -address=<host:port>    The address of the daemon's HTTP API, overriding the config.
+    -token=<token>          The API token, overriding the config.
+    -json                   Prints the status as JSON instead.
+`
+
+// The state of the daemon, as reported by the status endpoint
+type daemonStatus struct {
+	Datacenter string                   `json:"datacenter"`
+	Role       string                   `json:"role,omitempty"`
+	DryRun     bool                     `json:"dry_run,omitempty"`
+	Ready      bool                     `json:"ready"`
+	Components map[string]string        `json:"components"`
+	Watches    []watchStatus            `json:"watches"`
+	Alerts     []alertPayload           `json:"alerts"`
+	Silences   []silenceListing         `json:"silences"`
+	Handlers   []dashboardHandlerHealth `json:"handlers"`
+}
+
+// Gathers the daemon's state for the status endpoint
+func currentStatus(config *Config, now time.Time) daemonStatus {
+	dashboard := dashboardState(config, now)
+	ready, components := daemonReadiness.status()
+	return daemonStatus{
+		Datacenter: dashboard.Datacenter,
+		Role:       activeInstance.role(),
+		DryRun:     isDryRun(),
+		Ready:      ready,
+		Components: components,
+		Watches:    runningWatches.list(),
+		Alerts:     activeAlerts.payloads(),
+		Silences:   silenceListings(now),
+		Handlers:   dashboard.Handlers,
+	}
+}
+
+// Serves the state of the daemon in one response, for the status subcommand
+func statusHandler(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, currentStatus(latestConfig(config), time.Now()))
+	}
+}
+
+// Runs the status subcommand, returning the exit code
+func runStatus(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, statusUsage) }
+	var daemon daemonClient
+	daemon.register(flags)
+	asJSON := flags.Bool("json", false, "")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+	if code := daemon.setup(flags.Arg(0), stderr); code != 0 {
+		return code
+	}
+
+	var status daemonStatus
+	if err := getJSON(daemon.url("/v1/status"), daemon.headers(), &status); err != nil {
+		fmt.Fprintf(stderr, "Error getting the status of the daemon at %s: %s\n", daemon.address, err)
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(status)
+		return 0
+	}
+	printStatus(stdout, status, time.Now())
+	return 0
+}
+
+// The address and API token of a running daemon, for the subcommands using its API
+type daemonClient struct {
+	address string
+	token   string
+}
+
+func (c *daemonClient) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.address, "address", "", "")
+	flags.StringVar(&c.token, "token", "", "")
+}
+
+// Fills in the address and token the flags didn't set from the http_address and
+// api_token of a config file, if one is given, returning the exit code on error
+func (c *daemonClient) setup(path string, stderr io.Writer) int {
+	if path != "" {
+		config, err := ParseConfigFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", path, err)
+			return 1
+		}
+		if c.address == "" {
+			c.address = config.HTTPAddress
+		}
+		if c.token == "" {
+			c.token = config.APIToken
+		}
+	}
+	if c.address == "" {
+		fmt.Fprintln(stderr, "No address to reach the daemon at, pass -address or a config with http_address set")
+		return 2
+	}
+	return 0
+}
+
+// Returns the URL of an endpoint of the daemon, whose address may be an http_address
+// binding every interface
+func (c *daemonClient) url(path string) string {
+	address := c.address
+	if strings.Contains(address, "://") {
+		return strings.TrimSuffix(address, "/") + path
+	}
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			address = net.JoinHostPort("127.0.0.1", port)
+		}
+	}
+	return "http://" + address + path
+}
+
+func (c *daemonClient) headers() map[string]string {
+	headers := map[string]string{}
+	if c.token != "" {
+		headers["Authorization"] = "Bearer " + c.token
+	}
+	return headers
+}
+
+func printStatus(w io.Writer, status daemonStatus, now time.Time) {
+	out := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer out.Flush()
+
+	ready := "yes"
+	if !status.Ready {
+		ready = "no"
+	}
+	fmt.Fprintf(out, "Datacenter:\t%s\n", status.Datacenter)
+	if status.Role != "" {
+		fmt.Fprintf(out, "Role:\t%s\n", status.Role)
+	}
+	if status.DryRun {
+		fmt.Fprintf(out, "Dry run:\tyes\n")
+	}
+	fmt.Fprintf(out, "Ready:\t%s\n", ready)
+	components := make([]string, 0, len(status.Components))
+	for component := range status.Components {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		fmt.Fprintf(out, "    %s\t%s\n", component, status.Components[component])
+	}
+
+	// Watches of the local datacenter are only labeled with it when watching several
+	fmt.Fprintf(out, "\nWatches (%d):\n", len(status.Watches))
+	byDatacenter := make(map[string][]watchStatus)
+	var datacenters []string
+	for _, watch := range status.Watches {
+		dc := watch.Datacenter
+		if dc == "" {
+			dc = status.Datacenter
+		}
+		if _, ok := byDatacenter[dc]; !ok {
+			datacenters = append(datacenters, dc)
+		}
+		byDatacenter[dc] = append(byDatacenter[dc], watch)
+	}
+	sort.Strings(datacenters)
+	for _, dc := range datacenters {
+		fmt.Fprintf(out, "  %s\n", dc)
+		for _, watch := range byDatacenter[dc] {
+			state := "standby"
+			if watch.Leader {
+				state = "leader"
+			}
+			lastQuery := "never queried"
+			if watch.LastQuery != nil {
+				lastQuery = "queried " + statusDuration(now, *watch.LastQuery) + " ago"
+			}
+			if watch.LastError != "" {
+				lastQuery += ", error: " + watch.LastError
+			}
+			fmt.Fprintf(out, "    %s\t%s\t%s\t%s\n", watch.Name, watch.Type, state, lastQuery)
+		}
+	}
+
+	fmt.Fprintf(out, "\nAlerts (%d):\n", len(status.Alerts))
+	for _, alert := range status.Alerts {
+		since := ""
+		if alert.FailingSince != 0 {
+			since = "for " + statusDuration(now, time.Unix(alert.FailingSince, 0))
+		}
+		message := alert.Message
+		if alert.AckedBy != "" {
+			message += " (acknowledged by " + alert.AckedBy + ")"
+		}
+		fmt.Fprintf(out, "    %s\t%s\t%s\t%s\n", alert.Datacenter, alert.Status, since, message)
+	}
+
+	fmt.Fprintf(out, "\nSilences (%d):\n", len(status.Silences))
+	for _, s := range status.Silences {
+		fmt.Fprintf(out, "    %s\tuntil %s\t%s\n", s.target(), s.Expires.Local().Format(time.RFC3339), s.Reason)
+	}
+
+	fmt.Fprintf(out, "\nHandlers (%d):\n", len(status.Handlers))
+	for _, h := range status.Handlers {
+		health := "ok"
+		if h.CircuitOpen {
+			health = "circuit open"
+		}
+		fmt.Fprintf(out, "    %s\t%s\t%s\t%.0f delivered, %.0f failed, %d queued\n", h.Name, h.Type, health, h.Delivered, h.Failed, h.Queued)
+	}
+}
+
+// Formats how long it's been since a time, to the second
+func statusDuration(now time.Time, since time.Time) string {
+	d := now.Sub(since).Truncate(time.Second)
+	if d < 0 {
+		d = 0
+	}
+	return d.String()
+}
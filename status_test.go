@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestStatus_command(t *testing.T) {
+	runningWatches.start("service redis", "service", "dc2")
+	defer runningWatches.stop("service redis")
+	runningWatches.queried("service redis", nil, time.Now())
+
+	alert := &AlertState{Status: api.HealthCritical, Service: "redis", Node: "web1", Message: "redis is critical", AckedBy: "alice"}
+	activeAlerts.update("dc2", alert)
+	defer activeAlerts.update("dc2", &AlertState{Status: api.HealthPassing, Service: "redis", Node: "web1"})
+
+	config := DefaultConfig()
+	config.APIToken = "s3cret"
+	server := httptest.NewServer(requireAPIToken(config.APIToken, statusHandler(config)))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := runStatus([]string{"-address", server.URL, "-token", "s3cret"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	output := stdout.String()
+	for _, expected := range []string{"Watches (1):\n  dc2\n", "service redis", "leader", "redis is critical (acknowledged by alice)", "Handlers (1):", "stdout.default"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected the output to contain %q, got:\n%s", expected, output)
+		}
+	}
+
+	// Requests without the token are refused
+	stdout.Reset()
+	stderr.Reset()
+	if code := runStatus([]string{"-address", server.URL}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected exit code 1 without the token, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "401") {
+		t.Errorf("expected an unauthorized error, got %q", stderr.String())
+	}
+}
+
+func TestStatus_endpoint(t *testing.T) {
+	w := httptest.NewRecorder()
+	statusHandler(DefaultConfig())(w, httptest.NewRequest("POST", "/v1/status", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
+
+func TestStatus_url(t *testing.T) {
+	cases := map[string]string{
+		":9586":                   "http://127.0.0.1:9586/v1/status",
+		"0.0.0.0:9586":            "http://127.0.0.1:9586/v1/status",
+		"10.0.0.5:9586":           "http://10.0.0.5:9586/v1/status",
+		"https://alerting.local/": "https://alerting.local/v1/status",
+	}
+
+	for address, expected := range cases {
+		daemon := daemonClient{address: address}
+		if actual := daemon.url("/v1/status"); actual != expected {
+			t.Errorf("expected %s for %s, got %s", expected, address, actual)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The pseudo-service alert storms are reported on
+const stormService = "alert-storm"
+
+// An alert that went critical or was held back during a storm, with the service and tags
+// it's routed by
+type stormAlert struct {
+	time    time.Time
+	service string
+	tags    []string
+	alert   *AlertState
+}
+
+// The alert storm state of a datacenter
+type alertStorm struct {
+	// The alerts that went critical within the storm window, keyed by service/tag/node
+	critical map[string]stormAlert
+
+	// While a storm lasts, the latest alert of each service/tag/node held back from the
+	// handlers in the order they arrived, when an alert last went critical, and the timer
+	// checking whether the storm is over once it has been quiet for a whole window
+	active       bool
+	config       *Config
+	keys         []string
+	held         map[string]stormAlert
+	lastCritical time.Time
+	quiet        *time.Timer
+}
+
+// Tracks alert storms, keyed by datacenter
+type stormTracker struct {
+	sync.Mutex
+	storms map[string]*alertStorm
+}
+
+var alertStorms = &stormTracker{
+	storms: make(map[string]*alertStorm),
+}
+
+// Returns whether an alert is held back by an alert storm. Once more than storm_threshold
+// distinct services and nodes go critical within storm_window, a single alert storm alert
+// listing them is sent to the storm_handlers and the failing alerts that follow are held
+// back. Recoveries of alerts sent before the storm still go out.
+func (s *stormTracker) hold(config *Config, service string, tags []string, alert *AlertState) bool {
+	if config.StormThreshold <= 0 || service == stormService {
+		return false
+	}
+	datacenter := config.ConsulDatacenter
+	window := time.Duration(config.StormWindow) * time.Second
+	key := alertIncidentKey(datacenter, alert)
+	now := time.Now()
+	held := *alert
+
+	s.Lock()
+	storm, ok := s.storms[datacenter]
+	if !ok {
+		storm = &alertStorm{critical: make(map[string]stormAlert)}
+		s.storms[datacenter] = storm
+	}
+
+	if storm.active {
+		storm.config = config
+		if alert.Status == api.HealthPassing {
+			if _, ok := storm.held[key]; !ok {
+				s.Unlock()
+				return false
+			}
+		} else if alert.Status == api.HealthCritical {
+			storm.lastCritical = now
+			storm.quiet.Reset(window)
+		}
+		if _, ok := storm.held[key]; !ok {
+			storm.keys = append(storm.keys, key)
+		}
+		storm.held[key] = stormAlert{now, service, tags, &held}
+		s.Unlock()
+
+		log.Debugf("Holding back alert '%s' during an alert storm", alert.Message)
+		if alert.Status == api.HealthPassing {
+			s.end(datacenter)
+		}
+		return true
+	}
+
+	if alert.Status != api.HealthCritical {
+		if alert.Status == api.HealthPassing {
+			delete(storm.critical, key)
+		}
+		s.Unlock()
+		return false
+	}
+	for k, critical := range storm.critical {
+		if now.Sub(critical.time) >= window {
+			delete(storm.critical, k)
+		}
+	}
+	storm.critical[key] = stormAlert{now, service, tags, &held}
+	if len(storm.critical) <= config.StormThreshold {
+		s.Unlock()
+		return false
+	}
+
+	// Too many alerts went critical at once, so report them as a storm and hold back the rest
+	storm.active = true
+	storm.config = config
+	storm.keys = []string{key}
+	storm.held = map[string]stormAlert{key: storm.critical[key]}
+	storm.lastCritical = now
+	storm.quiet = time.AfterFunc(window, func() {
+		s.end(datacenter)
+	})
+	keys := make([]string, 0, len(storm.critical))
+	for k := range storm.critical {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	affected := make([]*AlertState, 0, len(keys))
+	for _, k := range keys {
+		affected = append(affected, storm.critical[k].alert)
+	}
+	storm.critical = make(map[string]stormAlert)
+	s.Unlock()
+
+	log.Warnf("Alert storm in datacenter %s: %d services and nodes went critical within %s, holding back their alerts",
+		datacenter, len(affected), window)
+	started := digestAlert(datacenter, affected)
+	started.Status = api.HealthCritical
+	started.LastAlerted = api.HealthPassing
+	started.Message = fmt.Sprintf("[%s] Alert storm: %d services and nodes went critical within %s, holding back their alerts until it's over",
+		datacenter, len(affected), window)
+	notifyStorm(config, started)
+	return true
+}
+
+// Ends the storm of a datacenter once it has been quiet for a whole window and no more
+// than storm_threshold of the alerts it held back are still failing. The alert storm
+// alert recovers, and the alerts still failing are sent to their handlers.
+func (s *stormTracker) end(datacenter string) {
+	s.Lock()
+	storm, ok := s.storms[datacenter]
+	if !ok || !storm.active {
+		s.Unlock()
+		return
+	}
+	config := storm.config
+	window := time.Duration(config.StormWindow) * time.Second
+	if time.Since(storm.lastCritical) < window {
+		s.Unlock()
+		return
+	}
+
+	failing := make([]stormAlert, 0)
+	for _, key := range storm.keys {
+		if held := storm.held[key]; held.alert.Status != api.HealthPassing {
+			failing = append(failing, held)
+		}
+	}
+	if len(failing) > config.StormThreshold {
+		// Wait for more of the alerts to recover
+		s.Unlock()
+		return
+	}
+	total := len(storm.keys)
+	storm.active = false
+	storm.quiet.Stop()
+	storm.keys = nil
+	storm.held = nil
+	s.Unlock()
+
+	log.Infof("Alert storm in datacenter %s is over, %d of the %d alerts held back are still failing", datacenter, len(failing), total)
+	over := &AlertState{
+		Service:     stormService,
+		Status:      api.HealthPassing,
+		LastAlerted: api.HealthCritical,
+		Message:     fmt.Sprintf("[%s] Alert storm is over: %d of the %d alerts held back are still failing", datacenter, len(failing), total),
+	}
+	lines := make([]string, 0, len(failing))
+	for _, held := range failing {
+		lines = append(lines, "=> "+held.alert.Message)
+	}
+	over.Details = strings.Join(lines, "\n")
+	notifyStorm(config, over)
+
+	// The handlers of the alerts still failing never got their failing status
+	for _, held := range failing {
+		alert := *held.alert
+		alert.Message += " (held back during an alert storm)"
+		notifyHandlers(config, held.service, held.tags, &alert, api.HealthPassing)
+	}
+}
+
+// Sends an alert storm alert to the storm_handlers, or to the handlers the alert-storm
+// service's alerts go to when none are set
+func notifyStorm(config *Config, alert *AlertState) {
+	alert.Service = stormService
+	alert.Node = ""
+	alert.Tag = ""
+	alert.Check = "alert storm"
+	alert.Severity = config.alertSeverity(stormService, alert.Status)
+	alert.Labels = config.alertLabels(stormService)
+
+	names := config.StormHandlers
+	if len(names) == 0 {
+		names = config.severityHandlerNames(config.ConsulDatacenter, stormService, "", nil, nil, alert.Status, alert.LastAlerted)
+	}
+	dispatchAlerts(config, names, config.ConsulDatacenter, alert)
+	history.record(config.ConsulDatacenter, alert, time.Now())
+}
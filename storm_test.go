@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorm_holdsAlerts(t *testing.T) {
+	alertCh := make(chan *AlertState, 10)
+	config := &Config{
+		ConsulDatacenter: "storm-dc",
+		StormThreshold:   2,
+		StormWindow:      1,
+		Handlers:         map[string]AlertHandler{"test": testHandler{alertCh}},
+		HandlerOptions:   map[string]HandlerOptions{},
+	}
+
+	for _, service := range []string{"api", "db", "redis", "web"} {
+		notifyHandlers(config, service, nil, &AlertState{Service: service, Status: "critical", LastAlerted: "passing", Message: service + " is critical"}, "passing")
+	}
+	notifyHandlers(config, "api", nil, &AlertState{Service: "api", Status: "passing", LastAlerted: "critical", Message: "api is passing"}, "critical")
+
+	// The alerts up to the threshold are sent, then the storm, and recoveries of the alerts
+	// sent before it still go out
+	for _, message := range []string{"api is critical", "db is critical"} {
+		if alert := <-alertCh; alert.Message != message {
+			t.Errorf("expected alert %q, got %q", message, alert.Message)
+		}
+	}
+	storm := <-alertCh
+	if storm.Service != stormService || storm.Status != "critical" || !strings.Contains(storm.Message, "3 services and nodes went critical") {
+		t.Errorf("unexpected storm alert: %+v", storm)
+	}
+	if !strings.Contains(storm.Details, "Affected services: api, db, redis") {
+		t.Errorf("expected the affected services to be listed, got %q", storm.Details)
+	}
+	if alert := <-alertCh; alert.Message != "api is passing" {
+		t.Errorf("expected the recovery to be sent, got %q", alert.Message)
+	}
+	if len(alertCh) != 0 {
+		t.Fatalf("expected the alerts during the storm to be held back, got %d alerts", len(alertCh))
+	}
+
+	// Once quiet for a window with few enough alerts failing, the storm recovers and the
+	// alerts still failing are sent
+	select {
+	case alert := <-alertCh:
+		if alert.Service != stormService || alert.Status != "passing" || !strings.Contains(alert.Message, "2 of the 2 alerts held back are still failing") {
+			t.Errorf("unexpected storm recovery: %+v", alert)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the storm to end")
+	}
+	for _, message := range []string{"redis is critical (held back during an alert storm)", "web is critical (held back during an alert storm)"} {
+		if alert := <-alertCh; alert.Message != message {
+			t.Errorf("expected alert %q, got %q", message, alert.Message)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// The data made available to user-supplied alert templates
+type alertTemplateData struct {
+	Datacenter string
+	*AlertState
+}
+
+// The functions available to alert templates, for compact text like email subjects
+var alertTemplateFuncs = template.FuncMap{
+	"upper":       strings.ToUpper,
+	"lower":       strings.ToLower,
+	"shortStatus": shortStatus,
+}
+
+// Abbreviates a status for subject lines and other short text, e.g. CRIT for critical
+func shortStatus(status string) string {
+	switch status {
+	case api.HealthCritical:
+		return "CRIT"
+	case api.HealthWarning:
+		return "WARN"
+	case api.HealthPassing:
+		return "OK"
+	}
+	return strings.ToUpper(status)
+}
+
+// Parses a template to be rendered against an alert, returning an error that includes
+// the template's name if it's malformed
+func parseAlertTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(alertTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing template %s: %s", name, err)
+	}
+
+	return tmpl, nil
+}
+
+// Parses a template and renders it against an empty alert, so that references to
+// unknown fields are caught when loading the config rather than when alerting
+func compileAlertTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := parseAlertTemplate(name, text)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := renderAlertTemplate(tmpl, "", &AlertState{}); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// Renders a parsed template against the given alert
+func renderAlertTemplate(tmpl *template.Template, datacenter string, alert *AlertState) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, alertTemplateData{datacenter, alert}); err != nil {
+		return "", fmt.Errorf("Error rendering template %s: %s", tmpl.Name(), err)
+	}
+
+	return buf.String(), nil
+}
+
+// The compiled message and details templates of the global config or a service block,
+// both nil if not set
+type alertMessageTemplates struct {
+	message *template.Template
+	details *template.Template
+}
+
+func compileMessageTemplates(message string, details string) (alertMessageTemplates, error) {
+	var templates alertMessageTemplates
+	var err error
+
+	if message != "" {
+		if templates.message, err = compileAlertTemplate("message_template", message); err != nil {
+			return templates, err
+		}
+	}
+	if details != "" {
+		if templates.details, err = compileAlertTemplate("details_template", details); err != nil {
+			return templates, err
+		}
+	}
+
+	return templates, nil
+}
+
+// Replaces the message and details of an alert using the configured templates, with the
+// service's templates taking precedence over the global ones. Both templates are rendered
+// against the original alert.
+func (c *Config) applyMessageTemplates(service string, alert *AlertState) {
+	templates := c.messageTemplates
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		if serviceConfig.messageTemplates.message != nil {
+			templates.message = serviceConfig.messageTemplates.message
+		}
+		if serviceConfig.messageTemplates.details != nil {
+			templates.details = serviceConfig.messageTemplates.details
+		}
+	}
+
+	details := renderAlertTemplateOr(templates.details, c.ConsulDatacenter, alert, alert.Details)
+	message := renderAlertTemplateOr(templates.message, c.ConsulDatacenter, alert, alert.Message)
+	alert.Details, alert.Message = details, message
+}
+
+// Renders a handler's template for an alert, returning the fallback if the template
+// isn't set or fails to render
+func renderAlertTemplateOr(tmpl *template.Template, datacenter string, alert *AlertState, fallback string) string {
+	if tmpl == nil {
+		return fallback
+	}
+
+	rendered, err := renderAlertTemplate(tmpl, datacenter, alert)
+	if err != nil {
+		log.Error(err)
+		return fallback
+	}
+	return rendered
+}
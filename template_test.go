@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplate_messageTemplates(t *testing.T) {
+	config, err := ParseConfig(`
+	datacenter = "dc1"
+	message_template = "[{{.Datacenter}}] {{.Service}} is {{.Status}}"
+
+	service "payments" {
+		message_template = "PAY-{{index .ServiceMeta \"team\"}}: {{.Service}} on {{.Node}} is {{.Status}}"
+		details_template = "{{.Message}}\n{{.Details}}"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: "critical", Service: "redis", Message: "redis is critical", Details: "output"}
+	config.applyMessageTemplates("redis", alert)
+	if alert.Message != "[dc1] redis is critical" || alert.Details != "output" {
+		t.Errorf("unexpected message/details: %q, %q", alert.Message, alert.Details)
+	}
+
+	alert = &AlertState{
+		Status:      "warning",
+		Service:     "payments",
+		Node:        "node1",
+		Message:     "payments is warning",
+		Details:     "output",
+		ServiceMeta: map[string]string{"team": "billing"},
+	}
+	config.applyMessageTemplates("payments", alert)
+	if alert.Message != "PAY-billing: payments on node1 is warning" {
+		t.Errorf("unexpected message: %q", alert.Message)
+	}
+	if alert.Details != "payments is warning\noutput" {
+		t.Errorf("expected the details template to see the original message, got %q", alert.Details)
+	}
+}
+
+func TestTemplate_invalidMessageTemplate(t *testing.T) {
+	cases := []string{
+		`message_template = "{{.Missing}}"`,
+		`service "redis" {
+			details_template = "{{.Details"
+		}`,
+		`handler "pagerduty" "ops" {
+			description_template = "{{.Unknown}}"
+		}`,
+		`handler "email" "admin" {
+			subject_template = "{{"
+		}`,
+	}
+
+	for _, c := range cases {
+		if _, err := ParseConfig(c); err == nil {
+			t.Errorf("expected an error parsing %s", c)
+		}
+	}
+}
+
+func TestTemplate_emailTemplates(t *testing.T) {
+	handler := EmailHandler{
+		SendMode:        EmailSendIndividual,
+		Transport:       EmailTransportMX,
+		SubjectTemplate: "{{.Status | printf \"%.4s\"}}: {{.Service}}",
+		BodyTemplate:    "Node: {{.Node}}\n\n{{.Details}}",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := handler.newMessage("dc1", &AlertState{Status: "critical", Service: "redis", Node: "node1", Details: "output"})
+	if subject := m.GetHeader("Subject"); len(subject) != 1 || subject[0] != "crit: redis" {
+		t.Errorf("unexpected subject: %v", subject)
+	}
+
+	var raw strings.Builder
+	m.WriteTo(&raw)
+	if !strings.Contains(raw.String(), "Node: node1") {
+		t.Errorf("expected the rendered body in the email:\n%s", raw.String())
+	}
+}
+
+func TestTemplate_emailSubject(t *testing.T) {
+	handler := EmailHandler{
+		SendMode:        EmailSendIndividual,
+		Transport:       EmailTransportMX,
+		SubjectTemplate: "[{{shortStatus .Status}}][{{.Datacenter}}] {{.Service}}{{if .Node}} on {{.Node}}{{end}}\n",
+	}
+	if err := handler.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]*AlertState{
+		"[CRIT][dc1] api on node-7": {Status: "critical", Service: "api", Node: "node-7"},
+		"[WARN][dc1] api":           {Status: "warning", Service: "api"},
+		"[OK][dc1] api on node-7":   {Status: "passing", Service: "api", Node: "node-7"},
+	}
+	for expected, alert := range cases {
+		m := handler.newMessage("dc1", alert)
+		if subject := m.GetHeader("Subject"); len(subject) != 1 || subject[0] != expected {
+			t.Errorf("expected subject %q, got %q", expected, subject)
+		}
+	}
+
+	upper, err := compileAlertTemplate("test", "{{upper .Service}}/{{lower .Status}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, _ := renderAlertTemplate(upper, "dc1", &AlertState{Service: "api", Status: "CRITICAL"}); text != "API/critical" {
+		t.Errorf("unexpected text: %q", text)
+	}
+}
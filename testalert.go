@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const testAlertUsage = `Usage: consul-alerting test-alert [options] <config>
+
+Sends a test alert, followed by its recovery, to the handlers of a configuration file.
+Exits with 1 if any handler failed to send them.
+
+Options:
+
+    -handler=<type.name>    Only sends to this handler. Can be given more than once.
+    -service=<name>         The service of the test alert. Defaults to consul-alerting-test.
+    -no-recovery            Only sends the critical alert, not its recovery.
+`
+
+// The service a test alert is sent for if -service isn't given
+const testAlertService = "consul-alerting-test"
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Runs the test-alert subcommand, returning the exit code
+func runTestAlert(args []string, stdout io.Writer, stderr io.Writer) int {
+	var handlers stringList
+	flags := flag.NewFlagSet("test-alert", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, testAlertUsage) }
+	flags.Var(&handlers, "handler", "")
+	service := flags.String("service", testAlertService, "")
+	noRecovery := flags.Bool("no-recovery", false, "")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(level)
+
+	config, err := ParseConfigFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", flags.Arg(0), err)
+		return 1
+	}
+	setRetryPolicy(config.retryPolicy())
+
+	if len(handlers) == 0 {
+		for name := range config.Handlers {
+			handlers = append(handlers, name)
+		}
+		sort.Strings(handlers)
+	}
+	for _, name := range handlers {
+		if _, ok := config.Handlers[name]; !ok {
+			fmt.Fprintf(stderr, "Unknown handler: %s\n", name)
+			return 2
+		}
+	}
+
+	datacenter := config.ConsulDatacenter
+	if datacenter == "" {
+		datacenter = "dc1"
+	}
+
+	failed := false
+	for _, name := range handlers {
+		for _, alert := range testAlerts(datacenter, *service, !*noRecovery) {
+			start := time.Now()
+			if err := config.namedHandler(name).alert(datacenter, alert); err != nil {
+				fmt.Fprintf(stdout, "%s: failed to send the %s test alert: %s\n", name, alert.Status, err)
+				failed = true
+				break
+			}
+			fmt.Fprintf(stdout, "%s: sent the %s test alert in %s\n", name, alert.Status, time.Since(start).Truncate(time.Millisecond))
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// Returns the test alert and, if recovery is set, the alert of it recovering
+func testAlerts(datacenter string, service string, recovery bool) []*AlertState {
+	alerts := []*AlertState{{
+		Service:     service,
+		Status:      api.HealthCritical,
+		LastAlerted: api.HealthPassing,
+		Severity:    SeverityCritical,
+		Message:     fmt.Sprintf("[%s] service %s is now critical", datacenter, service),
+		Details:     "This is a test alert sent by consul-alerting test-alert.",
+	}}
+	if recovery {
+		alerts = append(alerts, &AlertState{
+			Service:     service,
+			Status:      api.HealthPassing,
+			LastAlerted: api.HealthCritical,
+			Message:     fmt.Sprintf("[%s] service %s is now passing", datacenter, service),
+			Details:     "This is a test recovery sent by consul-alerting test-alert.",
+		})
+	}
+	return alerts
+}
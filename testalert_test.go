@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTestAlert_command(t *testing.T) {
+	var statuses []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload alertPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		statuses = append(statuses, payload.Service+" "+payload.Status)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "config.hcl")
+	config := fmt.Sprintf(`
+handler "webhook" "ok" {
+	urls = ["%s"]
+}
+handler "webhook" "down" {
+	urls = ["http://127.0.0.1:1"]
+	max_retries = 0
+}
+`, server.URL)
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runTestAlert([]string{"-handler", "webhook.ok", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	if strings.Join(statuses, ",") != "consul-alerting-test critical,consul-alerting-test passing" {
+		t.Errorf("expected a test alert and its recovery, got %v", statuses)
+	}
+
+	stdout.Reset()
+	if code := runTestAlert([]string{path}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected exit code 1 with a failing handler, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "webhook.down: failed to send the critical test alert") {
+		t.Errorf("expected the failing handler to be reported, got %q", stdout.String())
+	}
+
+	if code := runTestAlert([]string{"-handler", "slack.missing", path}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for an unknown handler, got %d", code)
+	}
+}
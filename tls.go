@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLS options shared by handlers that hold their own connections
+type TLSOptions struct {
+	TLS           bool   `mapstructure:"tls"`
+	TLSCAFile     string `mapstructure:"tls_ca_file"`
+	TLSCertFile   string `mapstructure:"tls_cert_file"`
+	TLSKeyFile    string `mapstructure:"tls_key_file"`
+	TLSSkipVerify bool   `mapstructure:"tls_skip_verify"`
+}
+
+// Builds the TLS config for the options, returning nil if TLS is disabled
+func (options TLSOptions) tlsConfig() (*tls.Config, error) {
+	if !options.TLS {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: options.TLSSkipVerify}
+
+	if options.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(options.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tls_ca_file: %s", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls_ca_file %s", options.TLSCAFile)
+		}
+	}
+
+	if (options.TLSCertFile == "") != (options.TLSKeyFile == "") {
+		return nil, fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+	if options.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.TLSCertFile, options.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How many spans are sent to the collector in one request, and how often they're sent
+const (
+	traceBatchSize     = 512
+	traceFlushInterval = 5 * time.Second
+)
+
+// How many finished spans can wait to be exported before new ones are dropped
+const traceQueueSize = 4096
+
+// The OTLP status code of a span that failed
+const otlpStatusError = 2
+
+// A timed operation in the pipeline from a health check changing status to its alert
+// being delivered, exported to an OpenTelemetry collector. The spans of one alert share
+// a trace, from the watch seeing the change down to each handler sending it. Spans of
+// traces that weren't sampled are kept so their children know not to be exported either.
+// All methods are safe to call on a nil span, which is what is returned while tracing is
+// disabled.
+type span struct {
+	sync.Mutex
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	sampled  bool
+
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	events     []spanEvent
+	err        string
+}
+
+type spanEvent struct {
+	name       string
+	time       time.Time
+	attributes map[string]string
+}
+
+// The exporter spans are sent to, and the share of traces that are sampled
+var alertTracer = struct {
+	sync.Mutex
+	exporter   *otlpExporter
+	sampleRate float64
+}{}
+
+// Starts exporting the spans of the alert pipeline, if a tracing endpoint is configured
+func startTracing(config *Config) {
+	if config.TracingEndpoint == "" {
+		return
+	}
+
+	exporter := newOTLPExporter(otlpTracesURL(config.TracingEndpoint), config.TracingHeaders, config.TracingServiceName)
+	go exporter.run()
+	log.Infof("Exporting traces to %s", exporter.url)
+
+	alertTracer.Lock()
+	defer alertTracer.Unlock()
+	alertTracer.exporter = exporter
+	alertTracer.sampleRate = config.TracingSampleRate
+}
+
+// Starts a span, in the trace of its parent if it has one or in a new trace otherwise.
+// Attributes are given as key/value pairs.
+func startSpan(parent *span, name string, attributes ...string) *span {
+	alertTracer.Lock()
+	enabled, sampleRate := alertTracer.exporter != nil, alertTracer.sampleRate
+	alertTracer.Unlock()
+	if !enabled {
+		return nil
+	}
+
+	s := &span{name: name, start: time.Now(), attributes: make(map[string]string)}
+	rand.Read(s.spanID[:])
+	if parent != nil {
+		s.traceID, s.parentID, s.sampled = parent.traceID, parent.spanID, parent.sampled
+	} else {
+		rand.Read(s.traceID[:])
+		s.sampled = mathrand.Float64() < sampleRate
+	}
+	s.setAttributes(attributes...)
+	return s
+}
+
+// Sets attributes on the span, given as key/value pairs
+func (s *span) setAttributes(attributes ...string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	for i := 0; i+1 < len(attributes); i += 2 {
+		if attributes[i+1] != "" {
+			s.attributes[attributes[i]] = attributes[i+1]
+		}
+	}
+}
+
+// Records something that happened during the span, like a retry
+func (s *span) addEvent(name string, attributes ...string) {
+	if s == nil {
+		return
+	}
+	event := spanEvent{name: name, time: time.Now(), attributes: make(map[string]string)}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		event.attributes[attributes[i]] = attributes[i+1]
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, event)
+}
+
+// Ends the span, marking it as failed if err is set, and queues it for export if its trace
+// is sampled. Spans can only be ended once.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if !s.end.IsZero() {
+		s.Unlock()
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.Unlock()
+
+	if !s.sampled {
+		return
+	}
+	alertTracer.Lock()
+	exporter := alertTracer.exporter
+	alertTracer.Unlock()
+	if exporter != nil {
+		exporter.add(s)
+	}
+}
+
+// Returns the URL spans are posted to for an OTLP/HTTP endpoint, which may be the
+// collector's base URL or its full traces URL
+func otlpTracesURL(endpoint string) string {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if strings.HasSuffix(endpoint, "/v1/traces") {
+		return endpoint
+	}
+	return endpoint + "/v1/traces"
+}
+
+func validateTracingEndpoint(endpoint string) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("tracing_endpoint must be an http or https URL")
+	}
+	return nil
+}
+
+// Sends finished spans to an OpenTelemetry collector in batches, over OTLP/HTTP with
+// its JSON encoding
+type otlpExporter struct {
+	url         string
+	headers     map[string]string
+	serviceName string
+	spans       chan *span
+	flushCh     chan chan struct{}
+}
+
+func newOTLPExporter(url string, headers map[string]string, serviceName string) *otlpExporter {
+	return &otlpExporter{
+		url:         url,
+		headers:     headers,
+		serviceName: serviceName,
+		spans:       make(chan *span, traceQueueSize),
+		flushCh:     make(chan chan struct{}),
+	}
+}
+
+// Queues a span for export, dropping it if the queue is full so a slow collector can't
+// hold up alerts
+func (e *otlpExporter) add(s *span) {
+	select {
+	case e.spans <- s:
+	default:
+		log.Debugf("Trace queue is full, dropping span %s", s.name)
+	}
+}
+
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) >= traceBatchSize {
+				e.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.export(batch)
+			batch = nil
+		case done := <-e.flushCh:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			e.export(batch)
+			batch = nil
+			close(done)
+		}
+	}
+}
+
+// Exports the spans queued so far, waiting up to timeout for them to be sent
+func (e *otlpExporter) flush(timeout time.Duration) {
+	done := make(chan struct{})
+	select {
+	case e.flushCh <- done:
+	case <-time.After(timeout):
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// Exports the queued spans on shutdown, so the traces of the last alerts aren't lost
+func flushTraces(timeout time.Duration) {
+	alertTracer.Lock()
+	exporter := alertTracer.exporter
+	alertTracer.Unlock()
+	if exporter != nil {
+		exporter.flush(timeout)
+	}
+}
+
+func (e *otlpExporter) export(batch []*span) {
+	if len(batch) == 0 {
+		return
+	}
+	if _, err := sendJSON("POST", e.url, e.headers, e.request(batch)); err != nil {
+		log.Warnf("Error exporting %d spans to %s: %s", len(batch), e.url, err)
+	}
+}
+
+// The OTLP JSON encoding of an export request. IDs are hex encoded and times are
+// nanoseconds since the Unix epoch, as strings.
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Name         string          `json:"name"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func (e *otlpExporter) request(batch []*span) otlpExportRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, s.otlp())
+	}
+
+	return otlpExportRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes(map[string]string{"service.name": e.serviceName})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "consul-alerting"},
+			Spans: spans,
+		}},
+	}}}
+}
+
+func (s *span) otlp() otlpSpan {
+	s.Lock()
+	defer s.Unlock()
+
+	encoded := otlpSpan{
+		TraceID: hex.EncodeToString(s.traceID[:]),
+		SpanID:  hex.EncodeToString(s.spanID[:]),
+		Name:    s.name,
+		// Internal, as no span covers a request made to or received from another service
+		Kind:              1,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        otlpAttributes(s.attributes),
+	}
+	if s.parentID != [8]byte{} {
+		encoded.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	for _, event := range s.events {
+		encoded.Events = append(encoded.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(event.time.UnixNano(), 10),
+			Name:         event.name,
+			Attributes:   otlpAttributes(event.attributes),
+		})
+	}
+	if s.err != "" {
+		encoded.Status = &otlpStatus{Code: otlpStatusError, Message: s.err}
+	}
+	return encoded
+}
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	encoded := make([]otlpAttribute, 0, len(keys))
+	for _, key := range keys {
+		encoded = append(encoded, otlpAttribute{key, otlpAnyValue{attributes[key]}})
+	}
+	return encoded
+}
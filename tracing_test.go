@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Starts tracing to a fake collector, returning the spans it received so far
+func testTracing(t *testing.T, sampleRate float64) func() []otlpSpan {
+	var lock sync.Mutex
+	var spans []otlpSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected request path: %s", r.URL.Path)
+		}
+		var request otlpExportRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for _, resource := range request.ResourceSpans {
+			if len(resource.Resource.Attributes) != 1 || resource.Resource.Attributes[0].Value.StringValue != "alerting-test" {
+				t.Errorf("unexpected resource: %+v", resource.Resource)
+			}
+			for _, scope := range resource.ScopeSpans {
+				spans = append(spans, scope.Spans...)
+			}
+		}
+	}))
+
+	config := DefaultConfig()
+	config.TracingEndpoint = collector.URL
+	config.TracingServiceName = "alerting-test"
+	config.TracingSampleRate = sampleRate
+	startTracing(config)
+	t.Cleanup(func() {
+		alertTracer.Lock()
+		alertTracer.exporter = nil
+		alertTracer.Unlock()
+		collector.Close()
+	})
+
+	return func() []otlpSpan {
+		flushTraces(time.Second)
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]otlpSpan(nil), spans...)
+	}
+}
+
+func TestTracing_export(t *testing.T) {
+	exported := testTracing(t, 1)
+
+	root := startSpan(nil, "alert", "consul.service", "redis", "consul.check", "")
+	child := startSpan(root, "handler.alert", "handler", "slack.ops")
+	child.addEvent("attempt failed", "attempt", "1")
+	child.finish(errors.New("connection refused"))
+	root.finish(nil)
+	root.finish(errors.New("finished twice"))
+
+	spans := exported()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d: %+v", len(spans), spans)
+	}
+	handler, alert := spans[0], spans[1]
+	if alert.Name != "alert" || alert.ParentSpanID != "" || alert.Status != nil {
+		t.Errorf("unexpected root span: %+v", alert)
+	}
+	if len(alert.Attributes) != 1 || alert.Attributes[0].Key != "consul.service" || alert.Attributes[0].Value.StringValue != "redis" {
+		t.Errorf("expected empty attributes to be left out, got %+v", alert.Attributes)
+	}
+	if handler.TraceID != alert.TraceID || handler.ParentSpanID != alert.SpanID || len(handler.TraceID) != 32 || len(handler.SpanID) != 16 {
+		t.Errorf("expected the handler span to be a child of the alert span: %+v", handler)
+	}
+	if handler.Status == nil || handler.Status.Code != otlpStatusError || handler.Status.Message != "connection refused" {
+		t.Errorf("expected the handler span to have failed, got %+v", handler.Status)
+	}
+	if len(handler.Events) != 1 || handler.Events[0].Name != "attempt failed" {
+		t.Errorf("unexpected events: %+v", handler.Events)
+	}
+}
+
+func TestTracing_send(t *testing.T) {
+	exported := testTracing(t, 1)
+
+	down := int32(1)
+	config := &Config{Handlers: map[string]AlertHandler{"test": flakyHandler{&down, make(chan *AlertState, 1)}}}
+	root := startSpan(nil, "alert")
+	alert := &AlertState{Service: "redis", Status: "critical", Message: "redis is critical", span: root}
+	config.namedHandler("test").send("dc1", alert)
+	root.finish(nil)
+
+	spans := exported()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d: %+v", len(spans), spans)
+	}
+	handler, send := spans[0], spans[1]
+	if handler.Name != "handler.alert" || handler.ParentSpanID != send.SpanID || handler.Status == nil {
+		t.Errorf("unexpected handler span: %+v", handler)
+	}
+	if send.Name != "alert.send" || send.ParentSpanID != spans[2].SpanID || send.Status == nil {
+		t.Errorf("unexpected send span: %+v", send)
+	}
+	if alert.span != root {
+		t.Error("expected the alert's own span to be left as it was")
+	}
+}
+
+func TestTracing_sampling(t *testing.T) {
+	exported := testTracing(t, 0)
+
+	root := startSpan(nil, "alert")
+	startSpan(root, "alert.route").finish(nil)
+	root.finish(nil)
+
+	if spans := exported(); len(spans) != 0 {
+		t.Errorf("expected no spans from an unsampled trace, got %+v", spans)
+	}
+}
+
+func TestTracing_disabled(t *testing.T) {
+	if span := startSpan(nil, "alert"); span != nil {
+		t.Fatalf("expected no span with tracing disabled, got %+v", span)
+	}
+
+	// Spans are nil while tracing is disabled, which every method accepts
+	var span *span
+	span.setAttributes("handler", "slack.ops")
+	span.addEvent("attempt failed")
+	span.finish(nil)
+}
+
+func TestTracing_url(t *testing.T) {
+	cases := map[string]string{
+		"http://otel:4318":            "http://otel:4318/v1/traces",
+		"http://otel:4318/":           "http://otel:4318/v1/traces",
+		"https://otel:4318/v1/traces": "https://otel:4318/v1/traces",
+	}
+
+	for endpoint, expected := range cases {
+		if actual := otlpTracesURL(endpoint); actual != expected {
+			t.Errorf("expected %s for %s, got %s", expected, endpoint, actual)
+		}
+	}
+	for _, endpoint := range []string{"otel:4318", "udp://otel:4318", "http://"} {
+		if err := validateTracingEndpoint(endpoint); err == nil {
+			t.Errorf("expected an error validating %s", endpoint)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const validateUsage = `Usage: consul-alerting validate [-connect] <config>
+
+Checks a configuration file: its syntax, settings and handlers, and the Consul address.
+Exits with 1 if the configuration is invalid.
+
+Options:
+
+    -connect    Also checks that the Consul agent can be reached with consul_address and
+                consul_token.
+`
+
+// Runs the validate subcommand, returning the exit code
+func runValidate(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, validateUsage) }
+	connect := flags.Bool("connect", false, "")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	path := flags.Arg(0)
+
+	// Only let warnings through, not the handlers being loaded
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(level)
+
+	config, err := ParseConfigFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	clientConfig, err := config.consulClientConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", path, err)
+		return 1
+	}
+	if _, _, err := net.SplitHostPort(clientConfig.Address); err != nil {
+		fmt.Fprintf(stderr, "%s: warning: consul_address %q has no port, the %s default port will be used\n",
+			path, config.ConsulAddress, clientConfig.Scheme)
+	}
+
+	if *connect {
+		client, err := api.NewClient(clientConfig)
+		if err == nil {
+			_, err = client.Agent().NodeName()
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: Error connecting to Consul agent at %s: %s\n", path, clientConfig.Address, err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(stdout, "%s: configuration is valid (%d handlers, %d services)\n", path, len(config.Handlers), len(config.Services))
+	return 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate_command(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, config string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cases := []struct {
+		config   string
+		code     int
+		expected string
+	}{
+		{`handler "stdout" "log" {}`, 0, "configuration is valid (1 handlers, 0 services)"},
+		{"\n\nhandler \"pagerduty\" \"oncall\" {\n  max_retries = 1\n}\n", 1, "Error loading handler pagerduty.oncall: service_key or routing_key must be set (line 3)"},
+		{"handler \"email\" \"admin\" {\n  recipients = [\"admin@example.com\", \"not an address\"]\n}\n", 1, `Invalid address in recipients[1] "not an address"`},
+		{`consul_address = "ftp://localhost:8500"`, 1, "Invalid scheme in consul_address: ftp"},
+		{"log_level = \"info\"\nfoo = = 1\n", 1, "error parsing: At 2:"},
+	}
+	for i, c := range cases {
+		path := write("config.hcl", c.config)
+		var stdout, stderr bytes.Buffer
+		code := runValidate([]string{path}, &stdout, &stderr)
+		if code != c.code {
+			t.Errorf("case %d: expected exit code %d, got %d: %s", i, c.code, code, stderr.String())
+		}
+		if output := stdout.String() + stderr.String(); !strings.Contains(output, c.expected) {
+			t.Errorf("case %d: expected output to contain %q, got %q", i, c.expected, output)
+		}
+	}
+
+	var stderr bytes.Buffer
+	if code := runValidate(nil, &stderr, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 without a config, got %d", code)
+	}
+}
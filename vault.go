@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/hcl/ast"
+	log "github.com/sirupsen/logrus"
+)
+
+// The prefix of a reference to a Vault secret in a config value, as in
+// ${vault:secret/data/slack#api_token}
+const vaultReferencePrefix = "vault:"
+
+// How long to wait before trying to renew the Vault token again after an error
+const vaultRetryInterval = 30 * time.Second
+
+// A minimal client for the Vault HTTP API, used to read the secrets referenced by the
+// config and to renew its token
+type vaultClient struct {
+	address   string
+	token     string
+	namespace string
+}
+
+// Returns a client for the given Vault address, token and namespace, falling back to the
+// VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE environment variables
+func newVaultClient(address string, token string, namespace string) (*vaultClient, error) {
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if namespace == "" {
+		namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+
+	if address == "" {
+		return nil, fmt.Errorf("vault_address or VAULT_ADDR must be set to use Vault secrets")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("vault_token or VAULT_TOKEN must be set to use Vault secrets")
+	}
+	return &vaultClient{address: strings.TrimSuffix(address, "/"), token: token, namespace: namespace}, nil
+}
+
+func (config *Config) vaultClient() (*vaultClient, error) {
+	return newVaultClient(config.VaultAddress, config.VaultToken, config.VaultNamespace)
+}
+
+func (v *vaultClient) headers() map[string]string {
+	headers := map[string]string{"X-Vault-Token": v.token}
+	if v.namespace != "" {
+		headers["X-Vault-Namespace"] = v.namespace
+	}
+	return headers
+}
+
+// Reads the secret at a path, unwrapping the data of KV version 2 secrets
+func (v *vaultClient) read(path string) (map[string]interface{}, error) {
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := getJSON(v.address+"/v1/"+strings.TrimPrefix(path, "/"), v.headers(), &secret); err != nil {
+		return nil, fmt.Errorf("Error reading Vault secret %s: %s", path, err)
+	}
+
+	if data, ok := secret.Data["data"].(map[string]interface{}); ok {
+		if _, ok := secret.Data["metadata"]; ok {
+			return data, nil
+		}
+	}
+	return secret.Data, nil
+}
+
+// Renews the token if it's renewable, returning how long to wait before renewing it
+// again, or 0 if it can't be renewed
+func (v *vaultClient) renewToken() (time.Duration, error) {
+	var lookup struct {
+		Data struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		} `json:"data"`
+	}
+	if err := getJSON(v.address+"/v1/auth/token/lookup-self", v.headers(), &lookup); err != nil {
+		return 0, fmt.Errorf("Error looking up the Vault token: %s", err)
+	}
+	if !lookup.Data.Renewable || lookup.Data.TTL == 0 {
+		return 0, nil
+	}
+
+	body, err := sendJSON("POST", v.address+"/v1/auth/token/renew-self", v.headers(), struct{}{})
+	if err != nil {
+		return 0, fmt.Errorf("Error renewing the Vault token: %s", err)
+	}
+	var renewal struct {
+		Auth struct {
+			LeaseDuration int `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := json.Unmarshal(body, &renewal); err != nil {
+		return 0, fmt.Errorf("Error decoding the Vault token renewal: %s", err)
+	}
+
+	log.Debugf("Renewed the Vault token for %ds", renewal.Auth.LeaseDuration)
+	return time.Duration(renewal.Auth.LeaseDuration) * time.Second / 2, nil
+}
+
+// Resolves the Vault references of a config, reading each secret once. The client is
+// created from the config's Vault settings when the first reference is found.
+type vaultResolver struct {
+	root    *ast.File
+	client  *vaultClient
+	data    map[string]map[string]interface{}
+	secrets map[string]string
+}
+
+// Returns the value of a <path>#<key> reference
+func (r *vaultResolver) secret(reference string) (string, error) {
+	i := strings.LastIndex(reference, "#")
+	if i <= 0 || i == len(reference)-1 {
+		return "", fmt.Errorf("Invalid Vault reference %s, must be in the form vault:<path>#<key>", reference)
+	}
+	path, key := reference[:i], reference[i+1:]
+
+	if r.client == nil {
+		var settings [3]string
+		for i, name := range []string{"vault_address", "vault_token", "vault_namespace"} {
+			var err error
+			if settings[i], err = topLevelString(r.root, name); err != nil {
+				return "", err
+			}
+		}
+		client, err := newVaultClient(settings[0], settings[1], settings[2])
+		if err != nil {
+			return "", err
+		}
+		r.client = client
+	}
+	if r.data == nil {
+		r.data = make(map[string]map[string]interface{})
+		r.secrets = make(map[string]string)
+	}
+
+	data, ok := r.data[path]
+	if !ok {
+		var err error
+		if data, err = r.client.read(path); err != nil {
+			return "", err
+		}
+		r.data[path] = data
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no key %s", path, key)
+	}
+	secret := fmt.Sprint(value)
+	r.secrets[reference] = secret
+	return secret, nil
+}
+
+// Returns whether any of the Vault secrets the config was loaded with were rotated
+func vaultSecretsChanged(config *Config) (bool, error) {
+	client, err := config.vaultClient()
+	if err != nil {
+		return false, err
+	}
+
+	resolver := &vaultResolver{client: client}
+	for reference, value := range config.vaultSecrets {
+		current, err := resolver.secret(reference)
+		if err != nil {
+			return false, err
+		}
+		if current != value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Reads the Vault secrets referenced by the config again every vault_refresh_interval,
+// requesting a reload when any were rotated so the handlers pick up the new values
+func watchVaultSecrets(config *Config) {
+	for {
+		time.Sleep(time.Duration(latestConfig(config).VaultRefreshInterval) * time.Second)
+
+		current := latestConfig(config)
+		if len(current.vaultSecrets) == 0 {
+			continue
+		}
+		changed, err := vaultSecretsChanged(current)
+		if err != nil {
+			log.Errorf("Error checking Vault secrets for rotation: %s", err)
+			continue
+		}
+		if changed {
+			log.Info("Vault secrets were rotated, reloading configuration")
+			requestReload()
+		}
+	}
+}
+
+// Keeps the Vault token used for the config's secrets renewed, if it's renewable
+func renewVaultTokens(config *Config) {
+	for {
+		current := latestConfig(config)
+		wait := time.Duration(current.VaultRefreshInterval) * time.Second
+		if len(current.vaultSecrets) > 0 {
+			client, err := current.vaultClient()
+			if err == nil {
+				var renewal time.Duration
+				if renewal, err = client.renewToken(); renewal > 0 {
+					wait = renewal
+				}
+			}
+			if err != nil {
+				log.Error(err)
+				wait = vaultRetryInterval
+			}
+		}
+		time.Sleep(wait)
+	}
+}
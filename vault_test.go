@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake Vault server with a KV version 2 secret at secret/data/slack and a version 1
+// secret at kv/pagerduty, accepting the token "root"
+type testVault struct {
+	sync.Mutex
+	slackToken string
+	renewals   int
+}
+
+func newTestVault(t *testing.T) (*testVault, *httptest.Server) {
+	vault := &testVault{slackToken: "xoxb-first"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		vault.Lock()
+		defer vault.Unlock()
+		var response interface{}
+		switch r.URL.Path {
+		case "/v1/secret/data/slack":
+			response = map[string]interface{}{"data": map[string]interface{}{
+				"data":     map[string]interface{}{"api_token": vault.slackToken},
+				"metadata": map[string]interface{}{"version": 1},
+			}}
+		case "/v1/kv/pagerduty":
+			response = map[string]interface{}{"data": map[string]interface{}{"service_key": "pd-key"}}
+		case "/v1/auth/token/lookup-self":
+			response = map[string]interface{}{"data": map[string]interface{}{"ttl": 3600, "renewable": true}}
+		case "/v1/auth/token/renew-self":
+			vault.renewals++
+			response = map[string]interface{}{"auth": map[string]interface{}{"lease_duration": 3600}}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return vault, server
+}
+
+func TestVault_config(t *testing.T) {
+	vault, server := newTestVault(t)
+	t.Setenv("ALERTING_VAULT_TOKEN", "root")
+
+	raw := `
+vault_address = "` + server.URL + `"
+vault_token = "${ALERTING_VAULT_TOKEN}"
+
+handler "slack" "ops" {
+  api_token = "${vault:secret/data/slack#api_token}"
+  channel_name = "ops"
+}
+
+handler "pagerduty" "oncall" {
+  service_key = "${vault:kv/pagerduty#service_key}"
+}
+`
+	config, err := ParseConfig(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token := config.Handlers["slack.ops"].(SlackHandler).Token; token != "xoxb-first" {
+		t.Errorf("expected the KV v2 secret, got %q", token)
+	}
+	if key := config.Handlers["pagerduty.oncall"].(PagerdutyHandler).ServiceKey; key != "pd-key" {
+		t.Errorf("expected the KV v1 secret, got %q", key)
+	}
+	if len(config.vaultSecrets) != 2 {
+		t.Errorf("expected the 2 references to be recorded, got %v", config.vaultSecrets)
+	}
+
+	changed, err := vaultSecretsChanged(config)
+	if err != nil || changed {
+		t.Fatalf("expected the secrets to be unchanged, got %v, %v", changed, err)
+	}
+	vault.Lock()
+	vault.slackToken = "xoxb-second"
+	vault.Unlock()
+	changed, err = vaultSecretsChanged(config)
+	if err != nil || !changed {
+		t.Fatalf("expected the rotated secret to be found, got %v, %v", changed, err)
+	}
+}
+
+func TestVault_errors(t *testing.T) {
+	_, server := newTestVault(t)
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+
+	cases := []struct {
+		config   string
+		expected string
+	}{
+		{`consul_token = "${vault:secret/data/slack#api_token}"`, "vault_address or VAULT_ADDR must be set to use Vault secrets (line 1)"},
+		{`vault_address = "` + server.URL + `"
+consul_token = "${vault:secret/data/slack#api_token}"`, "vault_token or VAULT_TOKEN must be set"},
+		{`vault_address = "` + server.URL + `"
+vault_token = "wrong"
+consul_token = "${vault:secret/data/slack#api_token}"`, "Error reading Vault secret secret/data/slack: unexpected response code 403"},
+		{`vault_address = "` + server.URL + `"
+vault_token = "root"
+consul_token = "${vault:secret/data/slack#missing}"`, "Vault secret secret/data/slack has no key missing"},
+		{`vault_address = "` + server.URL + `"
+vault_token = "root"
+consul_token = "${vault:secret/data/slack}"`, "Invalid Vault reference secret/data/slack"},
+	}
+	for _, c := range cases {
+		_, err := ParseConfig(c.config)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("expected error %q, got %v", c.expected, err)
+		}
+	}
+}
+
+func TestVault_environment(t *testing.T) {
+	_, server := newTestVault(t)
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+
+	config, err := ParseConfig(`consul_token = "${vault:kv/pagerduty#service_key}"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ConsulToken != "pd-key" {
+		t.Errorf("expected the secret to be read with VAULT_ADDR and VAULT_TOKEN, got %q", config.ConsulToken)
+	}
+}
+
+func TestVault_renewToken(t *testing.T) {
+	vault, server := newTestVault(t)
+	client, err := newVaultClient(server.URL, "root", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait, err := client.renewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 30*time.Minute {
+		t.Errorf("expected to renew again after half the lease, got %s", wait)
+	}
+	if vault.renewals != 1 {
+		t.Errorf("expected 1 renewal, got %d", vault.renewals)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The name of the pseudo-service used for alerting on the WAN federation of the
+// datacenters. A service block with this name can be used to configure its handlers and
+// thresholds.
+const wanHealthService = "consul-wan"
+
+// The serf status of a member that is alive, as returned by /v1/agent/members
+const serfAlive = 1
+
+// The names of the other serf statuses, used in check outputs
+var serfStatuses = map[int]string{0: "none", 1: "alive", 2: "leaving", 3: "left", 4: "failed"}
+
+// Fetches the members of the WAN pool, the servers of every federated datacenter. Only
+// server agents are in the WAN pool, so a client agent returns no members.
+func getWANMembers(client *api.Client) ([]*api.AgentMember, error) {
+	members, err := client.Agent().Members(true)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching WAN members: %s", err)
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("The agent has no WAN members; watching the WAN federation needs consul_address to be a server agent")
+	}
+	return members, nil
+}
+
+// Converts the WAN pool members into health checks, so they can be watched the same way
+// as a regular service. Each datacenter gets a "wan" check on a node named after it, which
+// is critical when none of its servers are alive in the pool and warning when some aren't.
+func wanHealthChecks(members []*api.AgentMember) []*api.HealthCheck {
+	servers := make(map[string][]*api.AgentMember)
+	for _, member := range members {
+		if member.Tags["role"] != "consul" {
+			continue
+		}
+		datacenter := member.Tags["dc"]
+		servers[datacenter] = append(servers[datacenter], member)
+	}
+
+	datacenters := make([]string, 0, len(servers))
+	for datacenter := range servers {
+		datacenters = append(datacenters, datacenter)
+	}
+	sort.Strings(datacenters)
+
+	checks := make([]*api.HealthCheck, 0, len(datacenters))
+	for _, datacenter := range datacenters {
+		alive := 0
+		down := make([]string, 0)
+		for _, server := range servers[datacenter] {
+			if server.Status == serfAlive {
+				alive++
+			} else {
+				down = append(down, fmt.Sprintf("%s (%s, %s)", server.Name, server.Addr, serfStatuses[server.Status]))
+			}
+		}
+		sort.Strings(down)
+
+		status := api.HealthPassing
+		output := fmt.Sprintf("%d of %d servers in %s are alive in the WAN pool", alive, len(servers[datacenter]), datacenter)
+		switch {
+		case alive == 0:
+			status = api.HealthCritical
+		case len(down) > 0:
+			status = api.HealthWarning
+		}
+		if len(down) > 0 {
+			output = output + "; not alive: " + strings.Join(down, ", ")
+		}
+
+		checks = append(checks, &api.HealthCheck{
+			Node:        datacenter,
+			CheckID:     "wan",
+			Name:        "wan",
+			Status:      status,
+			Output:      output,
+			ServiceID:   wanHealthService,
+			ServiceName: wanHealthService,
+		})
+	}
+	return checks
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestWANHealth_checks(t *testing.T) {
+	server := func(name, datacenter string, status int) *api.AgentMember {
+		return &api.AgentMember{
+			Name:   name + "." + datacenter,
+			Addr:   "10.0.0.1",
+			Status: status,
+			Tags:   map[string]string{"role": "consul", "dc": datacenter},
+		}
+	}
+	members := []*api.AgentMember{
+		server("server1", "dc1", 1),
+		server("server2", "dc1", 1),
+		server("server1", "dc2", 1),
+		server("server2", "dc2", 4),
+		server("server1", "dc3", 4),
+		server("server2", "dc3", 3),
+		{Name: "client1", Status: 1, Tags: map[string]string{"role": "node", "dc": "dc4"}},
+	}
+
+	checks := wanHealthChecks(members)
+	statuses := make(map[string]string)
+	for _, check := range checks {
+		if check.ServiceName != wanHealthService || check.CheckID != "wan" {
+			t.Errorf("expected a wan check of %s, got %s of %s", wanHealthService, check.CheckID, check.ServiceName)
+		}
+		statuses[check.Node] = check.Status
+	}
+
+	expected := map[string]string{
+		"dc1": api.HealthPassing,
+		"dc2": api.HealthWarning,
+		"dc3": api.HealthCritical,
+	}
+	if len(statuses) != len(expected) {
+		t.Errorf("expected checks for %v, got %v", expected, statuses)
+	}
+	for datacenter, status := range expected {
+		if statuses[datacenter] != status {
+			t.Errorf("expected %s to be %s, got %s", datacenter, status, statuses[datacenter])
+		}
+	}
+
+	output := checks[2].Output
+	if !strings.Contains(output, "0 of 2 servers in dc3") || !strings.Contains(output, "server1.dc3 (10.0.0.1, failed)") || !strings.Contains(output, "server2.dc3 (10.0.0.1, left)") {
+		t.Errorf("expected the output to list the servers that aren't alive, got %q", output)
+	}
+}
+
+func TestWANHealth_clientAgent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/agent/members" || r.URL.Query().Get("wan") != "1" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = strings.TrimPrefix(server.URL, "http://")
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := getWANMembers(client); err == nil || !strings.Contains(err.Error(), "needs consul_address to be a server agent") {
+		t.Errorf("expected an error for an agent without WAN members, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/consul/api"
@@ -9,12 +10,6 @@ import (
 	"sync"
 )
 
-// Maximum time to wait for a blocking (watch) query to Consul
-const watchWaitTime = 10 * time.Second
-
-// Time to wait before retrying after getting an api error from Consul
-const errorWaitTime = 10 * time.Second
-
 // The settings to use when performing a watch on a service or node
 type WatchOptions struct {
 	// The node name in Consul to use. Only used when watching a node.
@@ -38,11 +33,33 @@ type WatchOptions struct {
 
 	// A channel to use in order to stop the watch and release its lock.
 	stopCh chan struct{}
+
+	// Whether to watch the autopilot health of the Consul servers instead of a service's
+	// health checks. The service should be set to serverHealthService.
+	serverHealth bool
+
+	// Whether to watch the WAN pool membership of the servers of each datacenter instead.
+	// The service should be set to wanHealthService.
+	wanHealth bool
+
+	// Whether to watch the LAN pool membership of the agents in the datacenter instead.
+	// The service should be set to agentHealthService.
+	agentHealth bool
+
+	// Whether to watch the results of the prepared_queries instead.
+	// The service should be set to preparedQueryService.
+	preparedQueries bool
 }
 
 const ServiceWatch = "service"
 const NodeWatch = "node"
 
+// Returns whether the watch is for one of the pseudo-services generated from the state of
+// the cluster, rather than the health checks of a service or node
+func (opts *WatchOptions) pseudoService() bool {
+	return opts.serverHealth || opts.wanHealth || opts.agentHealth || opts.preparedQueries
+}
+
 /*  Watches a service or node for changes in health, updating the given handlers when an alert fires.
 
 Each watch is responsible for alerting on its own node/service, by watching the health check
@@ -66,10 +83,8 @@ that the check/alert state is persisted across restarts/lock acquisitions.
 func watch(opts *WatchOptions) {
 	// Set wait time to make the consul query block until an update happens
 	client := opts.client
-	queryOpts := &api.QueryOptions{
-		AllowStale: true,
-		WaitTime:   watchWaitTime,
-	}
+	queryOpts := opts.config.queryOptions()
+	queryOpts.WaitTime = watchWaitTime
 
 	// Initialize the mutex used for locking alert state
 	opts.alertLock = &sync.Mutex{}
@@ -85,7 +100,8 @@ func watch(opts *WatchOptions) {
 	name := mode + " " + opts.node
 
 	// The base path in the consul KV store to keep the state for this watch
-	keyPath := alertingKVRoot + "/node/" + opts.node + "/"
+	kvRoot := opts.config.stateKVRoot()
+	keyPath := kvRoot + "/node/" + opts.node + "/"
 	if mode == ServiceWatch {
 		name = mode + " " + opts.service
 		tagPath := ""
@@ -93,14 +109,43 @@ func watch(opts *WatchOptions) {
 			tagPath = opts.tag + "/"
 			name = name + fmt.Sprintf(" (tag: %s)", opts.tag)
 		}
-		keyPath = alertingKVRoot + "/service/" + opts.service + "/" + tagPath
+		keyPath = kvRoot + "/service/" + opts.service + "/" + tagPath
+	}
+	if opts.serverHealth {
+		name = "consul servers"
+	}
+	if opts.wanHealth {
+		name = "consul wan federation"
+	}
+	if opts.agentHealth {
+		name = "consul agents"
+	}
+	if opts.preparedQueries {
+		name = "prepared queries"
+	}
+	// Watches of each datacenter are told apart by it when watching several
+	if opts.config.localDatacenter != "" {
+		name = name + fmt.Sprintf(" (datacenter: %s)", opts.config.ConsulDatacenter)
+	}
+	if opts.config.partition != "" {
+		name = name + fmt.Sprintf(" (partition: %s)", opts.config.partition)
+	}
+	if opts.config.namespace != "" {
+		name = name + fmt.Sprintf(" (namespace: %s)", opts.config.namespace)
 	}
 	lockPath := keyPath + "leader"
-	alertPath := keyPath + "alert"
 
 	// Load previously stored check states for this watch from consul
 	lastCheckStatus := make(map[string]string)
-	lastAlertStatus := api.HealthPassing
+
+	// The last alert status for each group of checks, keyed by aggregation group
+	lastAlertStatus := make(map[string]string)
+
+	// The new health of groups that haven't been observed enough times to alert yet
+	pendingObservations := make(map[string]observationCount)
+
+	// The last instance counts of each group, with the instances aggregation
+	lastInstanceCounts := make(map[string]instanceCounts)
 
 	// Set up a callback to be run when we acquire the lock/gain leadership so we can
 	// load the last check/alert states
@@ -115,6 +160,12 @@ func watch(opts *WatchOptions) {
 			log.Debugf("Loaded check %s for %s, state: %s", checkName, name, checkState.Status)
 			lastCheckStatus[checkName] = checkState.Status
 		}
+
+		storedAlerts, err := getAlertStates(keyPath, client)
+		if err != nil {
+			log.Error("Error loading previous alert states from consul: ", err)
+		}
+		restoreAlerts(opts, storedAlerts, lastAlertStatus)
 	}
 
 	// Set up the lock this thread will use to determine leader status
@@ -135,6 +186,17 @@ func watch(opts *WatchOptions) {
 	go lock.start()
 
 	log.Debugf("Initialized watch for %s", name)
+	activeWatches.add(1, "type", mode)
+	defer activeWatches.add(-1, "type", mode)
+	runningWatches.start(name, mode, opts.config.ConsulDatacenter)
+	defer runningWatches.stop(name)
+	defer watchedChecks.remove(name)
+
+	// Whether the autopilot health has been polled yet, when watching server health
+	polled := false
+	raft := &raftTracker{}
+	maintenance := &maintenanceTracker{}
+	lastResync := time.Now()
 
 	// The main loop for the watch; do blocking queries to monitor the state of this service/node
 	// and read changes in the health status for potential alerts
@@ -150,10 +212,20 @@ func watch(opts *WatchOptions) {
 
 		// Sleep and continue until we hold the lock
 		if !lock.acquired {
+			runningWatches.waiting(name)
 			time.Sleep(1 * time.Second)
 			continue
 		}
 
+		// Every resync_interval, read the checks again in full instead of waiting on the
+		// blocking query, and correct the alerts that were never sent, so transitions missed
+		// by dropped queries or failed state writes are caught up on
+		if now := time.Now(); resyncDue(latestConfig(opts.config), lastResync, now) {
+			lastResync = now
+			queryOpts.WaitIndex = 0
+			resyncAlertStates(name, keyPath, opts, lastAlertStatus)
+		}
+
 		var checks []*api.HealthCheck
 		var queryMeta *api.QueryMeta
 		var err error
@@ -161,63 +233,217 @@ func watch(opts *WatchOptions) {
 		// Do a blocking query (a consul watch) for the health checks
 		if mode == NodeWatch {
 			checks, queryMeta, err = client.Health().Node(opts.node, queryOpts)
+		} else if opts.pseudoService() {
+			// The autopilot health, agent members and prepared query endpoints don't support
+			// blocking queries, so poll them instead
+			if polled {
+				time.Sleep(serverHealthInterval)
+			}
+			polled = true
+
+			if opts.wanHealth {
+				var members []*api.AgentMember
+				members, err = getWANMembers(client)
+				if err == nil {
+					checks = wanHealthChecks(members)
+				}
+			} else if opts.agentHealth {
+				var members []*api.AgentMember
+				members, err = getLANMembers(client)
+				if err == nil {
+					checks = agentHealthChecks(latestConfig(opts.config), members)
+				}
+			} else if opts.preparedQueries {
+				var results []preparedQueryResult
+				results, err = executePreparedQueries(latestConfig(opts.config), client)
+				if err == nil {
+					checks = preparedQueryChecks(latestConfig(opts.config), results)
+				}
+			} else {
+				var health *autopilotHealth
+				health, err = getAutopilotHealth(client, opts.config.queryOptions())
+				if err == nil {
+					checks = serverHealthChecks(health)
+
+					var leader string
+					var peers []string
+					leader, peers, err = getRaftStatus(client, opts.config.queryOptions())
+					if err == nil {
+						checks = append(checks, raft.checks(latestConfig(opts.config), leader, peers, time.Now())...)
+					}
+				}
+			}
 		} else {
 			checks, queryMeta, err = client.Health().Checks(opts.service, queryOpts)
 		}
 
+		runningWatches.queried(name, err, time.Now())
+
 		// Try again in 10s if we got an error during the blocking request
 		if err != nil {
-			log.Errorf("Error trying to watch %s: %s, retrying in 10s...", mode, err)
-			time.Sleep(errorWaitTime)
+			wait := consulErrorWait()
+			log.Errorf("Error trying to watch %s: %s, retrying in %s...", mode, err, wait.Truncate(time.Millisecond))
+			consulQueryErrors.add(1, "watch", mode)
+			time.Sleep(wait)
 			continue
 		}
 
 		// Update our WaitIndex for the next query
-		queryOpts.WaitIndex = queryMeta.LastIndex
+		if queryMeta != nil {
+			queryOpts.WaitIndex = queryMeta.LastIndex
+		}
+
+		// Apply the status overrides of the output rules, leave out the checks of nodes and
+		// instances in maintenance mode, then the ignored checks, forgetting them if they
+		// were watched before a reload
+		checks = latestConfig(opts.config).applyOutputRules(checks)
+		if !opts.pseudoService() {
+			if checks, err = maintenance.update(latestConfig(opts.config), opts, checks); err != nil {
+				wait := consulErrorWait()
+				log.Errorf("Error trying to watch %s: %s, retrying in %s...", mode, err, wait.Truncate(time.Millisecond))
+				consulQueryErrors.add(1, "watch", mode)
+				time.Sleep(wait)
+				continue
+			}
+		}
+		checks, ignored := latestConfig(opts.config).filterIgnoredChecks(opts.service, checks)
+		for _, checkHash := range ignored {
+			delete(lastCheckStatus, checkHash)
+		}
+
+		// Record the checks for the inhibit rules, sending the alerts they no longer hold back
+		watchedChecks.set(name, opts.config.ConsulDatacenter, checks)
+		inhibitions.release()
 
 		// Filter out health checks whose statuses haven't changed
 		updates := diffCheckFunc(checks, lastCheckStatus, opts)
 
-		// If there's any health check status changes, try to update the remote/local check caches and
-		// see if the alert status changed. If it has, we start a quiescence timer that will alert if
-		// it lives past the changeThreshold
+		// If there's any health check status changes, try to update the remote/local check caches
 		if len(updates) > 0 {
 			success := true
 
 			// Try to write the health updates to consul
 			for _, update := range updates {
 				log.Debugf("Got health check update for '%s' (%s) for %s", update.HealthCheck.Name, update.Status, name)
-				if !updateCheckState(update, client) {
+				if !updateCheckState(kvRoot, update, client) {
 					success = false
 				}
 			}
 
-			// Update the alert details to include info about any failing checks
-			alert := AlertState{}
-			if mode == NodeWatch {
-				alert.Details = nodeDetails(checks)
+			if !success {
+				continue
+			}
+
+			for checkHash, update := range updates {
+				lastCheckStatus[checkHash] = update.Status
+			}
+		}
+
+		// Group the check statuses according to the aggregation level and see if the health of
+		// any group changed. Once a group has had its new health for enough consecutive
+		// observations, we start a quiescence timer that will alert if it lives past the changeThreshold
+		config := latestConfig(opts.config)
+		if mode == ServiceWatch && !opts.pseudoService() {
+			config = config.withCatalogSettings(opts.service, serviceTags(opts))
+		}
+		aggregation := config.serviceAggregation(opts.service)
+		separate := config.separateChecks(opts.service, checks)
+		for group, statuses := range groupCheckStatuses(mode, aggregation, lastCheckStatus, separate) {
+			newStatus := computeHealth(statuses)
+			oldStatus, ok := lastAlertStatus[group]
+			if !ok {
+				oldStatus = api.HealthPassing
+			}
+
+			// Count the instances of the service for the instances aggregation and the
+			// instance thresholds, which replace the worst status of its checks. With the
+			// instances aggregation, a failing service alerts again whenever the number of its
+			// failing instances changes.
+			check := separate[group]
+			thresholds := config.serviceInstanceThresholds(opts.service)
+			countInstanceChanges := mode == ServiceWatch && !opts.pseudoService() && check == nil && group == "" &&
+				(aggregation == AggregateInstances || thresholds.set())
+			counts, counted := lastInstanceCounts[group]
+			if countInstanceChanges {
+				lastInstanceCounts[group] = countInstances(filterGroupChecks(mode, aggregation, group, checks, separate))
+				if thresholds.set() {
+					newStatus = thresholds.health(lastInstanceCounts[group])
+				}
+			}
+			instancesChanged := aggregation == AggregateInstances && countInstanceChanges && counted && counts != lastInstanceCounts[group]
+
+			if oldStatus == newStatus {
+				delete(pendingObservations, group)
+				if !instancesChanged || newStatus == api.HealthPassing {
+					continue
+				}
 			} else {
-				alert.Details = serviceDetails(checks)
+				// Count the consecutive observations of the new health, starting over if it changed
+				pending := pendingObservations[group]
+				if pending.status != newStatus {
+					pending = observationCount{status: newStatus}
+				}
+				pending.count++
+				pendingObservations[group] = pending
+
+				required := config.withCheckSettings(opts.service, check).serviceObservationsRequired(opts.service, newStatus)
+				if pending.count < required {
+					log.Debugf("Health of %s is %s for %d/%d observations", name, newStatus, pending.count, required)
+					continue
+				}
+				delete(pendingObservations, group)
 			}
 
-			if success {
-				for checkHash, update := range updates {
-					lastCheckStatus[checkHash] = update.Status
+			lastAlertStatus[group] = newStatus
+
+			// Update the alert details to include info about any failing checks in the group
+			groupChecks := filterGroupChecks(mode, aggregation, group, checks, separate)
+			alert := AlertState{Status: newStatus}
+			if mode == NodeWatch {
+				alert.Details = nodeDetails(groupChecks)
+			} else {
+				alert.Details = serviceDetails(groupChecks)
+			}
+			alert.Checks = failingChecks(mode, groupChecks)
+			if alert.Signatures = config.outputSignatures(groupChecks); len(alert.Signatures) > 0 {
+				alert.Details = strings.TrimSpace(alert.Details + "\nMatched signatures: " + strings.Join(alert.Signatures, ", "))
+			}
+			secrets.redactAlert(&alert)
+
+			target := name
+			switch {
+			case check != nil || aggregation == AggregateNone:
+				alert.Check = group
+				target = target + fmt.Sprintf(" (check: %s)", group)
+			case aggregation == AggregateNode:
+				if mode == ServiceWatch {
+					alert.Node = group
+					target = target + fmt.Sprintf(" (node: %s)", group)
 				}
+			}
 
-				// If the alert status changed, try to trigger an alert
-				newStatus := computeHealth(lastCheckStatus)
-				if lastAlertStatus != newStatus {
-					lastAlertStatus = newStatus
-					alert.Status = newStatus
-					alert.Message = fmt.Sprintf("[%s] %s is now %s", opts.config.ConsulDatacenter, name, newStatus)
-					go tryAlert(alertPath, alert, opts)
+			alert.Message = fmt.Sprintf("[%s] %s is now %s", config.ConsulDatacenter, target, newStatus)
+			if countInstanceChanges {
+				counts := lastInstanceCounts[group]
+				alert.Instances, alert.FailingInstances = counts.total, counts.failing()
+				if oldStatus == newStatus {
+					alert.Message = fmt.Sprintf("[%s] %s is still %s: %s", config.ConsulDatacenter, target, newStatus, counts.summary())
+					alert.instancesChanged = true
+				} else {
+					alert.Message = fmt.Sprintf("[%s] %s is now %s: %s", config.ConsulDatacenter, target, newStatus, counts.summary())
 				}
 			}
+			go tryAlert(groupAlertPath(keyPath, group), check, alert, opts)
 		}
 	}
 }
 
+// A health status and the number of consecutive times it has been observed
+type observationCount struct {
+	status string
+	count  int
+}
+
 // Returns a map of checks whose status differs from their entry in lastStatus
 func diffServiceChecks(checks []*api.HealthCheck, lastStatus map[string]string, opts *WatchOptions) map[string]CheckUpdate {
 	updates := make(map[string]CheckUpdate)
@@ -228,7 +454,7 @@ func diffServiceChecks(checks []*api.HealthCheck, lastStatus map[string]string,
 		if oldStatus, ok := lastStatus[checkHash]; ok && oldStatus != check.Status {
 			// If it did, make sure it's for our tag (if specified)
 			if opts.tag != "" {
-				node, _, err := opts.client.Catalog().Node(check.Node, &api.QueryOptions{})
+				node, _, err := opts.client.Catalog().Node(check.Node, opts.config.queryOptions())
 
 				if err != nil {
 					log.Errorf("Error trying to get service info for node '%s': %s", check.Node, err)
@@ -17,8 +17,9 @@ type testHandler struct {
 	alerts chan *AlertState
 }
 
-func (t testHandler) Alert(datacenter string, alert *AlertState) {
+func (t testHandler) Alert(datacenter string, alert *AlertState) error {
 	t.alerts <- alert
+	return nil
 }
 
 // Create a test Consul server and a client for making calls to it
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The headers of a signed webhook holding its signature and the Unix time it was signed at
+const (
+	defaultSignatureHeader   = "X-Consul-Alerting-Signature"
+	signatureTimestampHeader = "X-Consul-Alerting-Timestamp"
+)
+
+// Signs a webhook body, as v1=HMAC-SHA256 of "<timestamp>.<body>" with the shared secret.
+// Covering the timestamp means it can't be changed to replay an old request.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sets the signature and timestamp headers of a webhook request
+func setWebhookSignature(req *http.Request, header string, secret string, body []byte, now time.Time) {
+	timestamp := now.Unix()
+	req.Header.Set(signatureTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(header, signWebhook(secret, timestamp, body))
+}
+
+// Checks the signature of a webhook signed by setWebhookSignature, refusing ones signed
+// more than tolerance away from now. The header can hold several comma separated
+// signatures while a secret is being rotated.
+func verifyWebhookSignature(secret string, body []byte, headers http.Header, now time.Time, tolerance time.Duration) error {
+	signatures := headers.Get(defaultSignatureHeader)
+	if signatures == "" {
+		return fmt.Errorf("missing %s header", defaultSignatureHeader)
+	}
+	timestamp, err := strconv.ParseInt(headers.Get(signatureTimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid %s header", signatureTimestampHeader)
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("signature timestamp is too far from the current time")
+	}
+
+	expected := signWebhook(secret, timestamp, body)
+	for _, signature := range strings.Split(signatures, ",") {
+		if hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid signature")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature_verify(t *testing.T) {
+	body := []byte(`{"service":"redis","status":"critical"}`)
+	now := time.Unix(1504662182, 0)
+	signed := func(timestamp string, signatures string) http.Header {
+		headers := http.Header{}
+		headers.Set(signatureTimestampHeader, timestamp)
+		headers.Set(defaultSignatureHeader, signatures)
+		return headers
+	}
+	valid := signWebhook("shared", now.Unix(), body)
+
+	if err := verifyWebhookSignature("shared", body, signed("1504662182", valid), now, time.Minute); err != nil {
+		t.Errorf("expected the signature to verify, got %s", err)
+	}
+	// Either of the signatures sent while a secret is rotated is accepted
+	rotating := signWebhook("old", now.Unix(), body) + ", " + valid
+	if err := verifyWebhookSignature("shared", body, signed("1504662182", rotating), now, time.Minute); err != nil {
+		t.Errorf("expected one of the signatures to verify, got %s", err)
+	}
+
+	cases := map[string]http.Header{
+		"missing signature": signed("1504662182", ""),
+		"missing timestamp": signed("", valid),
+		"changed timestamp": signed("1504662183", valid),
+		"wrong secret":      signed("1504662182", signWebhook("other", now.Unix(), body)),
+	}
+	for name, headers := range cases {
+		if err := verifyWebhookSignature("shared", body, headers, now, time.Minute); err == nil {
+			t.Errorf("expected an error for the %s", name)
+		}
+	}
+	if err := verifyWebhookSignature("shared", []byte(`{}`), signed("1504662182", valid), now, time.Minute); err == nil {
+		t.Error("expected an error for a changed body")
+	}
+	if err := verifyWebhookSignature("shared", body, signed("1504662182", valid), now.Add(2*time.Minute), time.Minute); err == nil {
+		t.Error("expected an error for an old signature")
+	}
+}
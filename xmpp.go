@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A minimal XMPP client (RFC 6120), implementing just enough of the protocol to log in
+// with STARTTLS and SASL PLAIN, join MUC rooms (XEP-0045) and send messages.
+
+// The timeout for connecting and logging in to the server, and for each write
+const xmppTimeout = 30 * time.Second
+
+// How often a whitespace keepalive is sent, so idle connections aren't dropped
+const xmppKeepalive = time.Minute
+
+// The settings for connecting to an XMPP server
+type xmppConfig struct {
+	server         string
+	jid            xmppJID
+	password       string
+	recipients     []string
+	rooms          []string
+	roomNick       string
+	directTLS      bool
+	allowPlaintext bool
+	tlsConfig      *tls.Config
+}
+
+// The parts of a JID, local@domain/resource
+type xmppJID struct {
+	local    string
+	domain   string
+	resource string
+}
+
+func parseXMPPJID(jid string) (xmppJID, error) {
+	var parsed xmppJID
+	if i := strings.Index(jid, "/"); i >= 0 {
+		jid, parsed.resource = jid[:i], jid[i+1:]
+	}
+	parts := strings.Split(jid, "@")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ContainsAny(jid, " <>'\"&") {
+		return parsed, fmt.Errorf("%q isn't a JID like user@example.org", jid)
+	}
+	parsed.local, parsed.domain = parts[0], parts[1]
+	return parsed, nil
+}
+
+// The stream features advertised by the server
+type xmppFeatures struct {
+	StartTLS   *struct{} `xml:"starttls"`
+	Mechanisms []string  `xml:"mechanisms>mechanism"`
+	Bind       *struct{} `xml:"bind"`
+	Session    *struct {
+		Optional *struct{} `xml:"optional"`
+	} `xml:"session"`
+}
+
+// The parts of a stanza the client looks at
+type xmppStanza struct {
+	XMLName xml.Name
+	Type    string    `xml:"type,attr"`
+	ID      string    `xml:"id,attr"`
+	From    string    `xml:"from,attr"`
+	Ping    *struct{} `xml:"urn:xmpp:ping ping"`
+	Error   *struct {
+		Text      string `xml:"text"`
+		Condition struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"error"`
+}
+
+// The reason the server gave for an error stanza
+func (s *xmppStanza) errorText() string {
+	if s.Error == nil {
+		return ""
+	}
+	if s.Error.Text != "" {
+		return s.Error.Text
+	}
+	return s.Error.Condition.XMLName.Local
+}
+
+// A logged-in connection to an XMPP server, used as a busConn. A reader goroutine answers
+// the server's pings, and a whitespace keepalive is sent every minute.
+type xmppConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	decoder *xml.Decoder
+	config  *xmppConfig
+
+	writeLock sync.Mutex
+	nextID    int
+	done      chan struct{}
+	err       error
+}
+
+// Returns the address to connect to: the configured server, the xmpp-client SRV record of
+// the JID's domain, or the domain on the default port
+func xmppServerAddress(config *xmppConfig) string {
+	if config.server != "" {
+		return config.server
+	}
+	service := "xmpp-client"
+	if config.directTLS {
+		service = "xmpps-client"
+	}
+	if _, records, err := net.LookupSRV(service, "tcp", config.jid.domain); err == nil && len(records) > 0 {
+		return net.JoinHostPort(strings.TrimSuffix(records[0].Target, "."), strconv.Itoa(int(records[0].Port)))
+	}
+	if config.directTLS {
+		return net.JoinHostPort(config.jid.domain, "5223")
+	}
+	return net.JoinHostPort(config.jid.domain, "5222")
+}
+
+// Connects and logs in to the server, then joins the rooms
+func dialXMPP(config *xmppConfig) (*xmppConn, error) {
+	conn, err := net.DialTimeout("tcp", xmppServerAddress(config), xmppTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &xmppConn{conn: conn, config: config, done: make(chan struct{})}
+	if config.directTLS {
+		if err := c.startTLS(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	c.reader = bufio.NewReader(c.conn)
+
+	if err := c.login(); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+
+	go c.read()
+	go c.keepalive()
+	return c, nil
+}
+
+// Upgrades the connection to TLS, verifying the certificate against the JID's domain
+func (c *xmppConn) startTLS() error {
+	tlsConfig := c.config.tlsConfig
+	if tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = c.config.jid.domain
+	}
+	tlsConn := tls.Client(c.conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(xmppTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("tls handshake failed: %s", err)
+	}
+	c.conn = tlsConn
+	return nil
+}
+
+// Negotiates TLS and authentication, binds a resource, sends presence and joins the rooms
+func (c *xmppConn) login() error {
+	c.conn.SetDeadline(time.Now().Add(xmppTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	features, err := c.openStream()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.conn.(*tls.Conn); !ok {
+		if features.StartTLS != nil {
+			if err := c.send("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+				return err
+			}
+			if reply, err := c.nextElement(); err != nil {
+				return err
+			} else if reply.Name.Local != "proceed" {
+				return fmt.Errorf("server refused STARTTLS")
+			}
+			if err := c.startTLS(); err != nil {
+				return err
+			}
+			c.conn.SetDeadline(time.Now().Add(xmppTimeout))
+			c.reader = bufio.NewReader(c.conn)
+			if features, err = c.openStream(); err != nil {
+				return err
+			}
+		} else if !c.config.allowPlaintext {
+			return fmt.Errorf("server doesn't support STARTTLS")
+		}
+	}
+
+	if !contains(features.Mechanisms, "PLAIN") {
+		return fmt.Errorf("server doesn't support SASL PLAIN, only %s", strings.Join(features.Mechanisms, ", "))
+	}
+	credentials := "\x00" + c.config.jid.local + "\x00" + c.config.password
+	if err := c.send("<auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='PLAIN'>" +
+		base64.StdEncoding.EncodeToString([]byte(credentials)) + "</auth>"); err != nil {
+		return err
+	}
+	if reply, err := c.nextElement(); err != nil {
+		return err
+	} else if reply.Name.Local != "success" {
+		return fmt.Errorf("authentication failed")
+	}
+	if features, err = c.openStream(); err != nil {
+		return err
+	}
+
+	if features.Bind == nil {
+		return fmt.Errorf("server doesn't support resource binding")
+	}
+	resource := c.config.jid.resource
+	if resource == "" {
+		resource = "consul-alerting"
+	}
+	if err := c.iq("<bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'><resource>" + xmppEscape(resource) + "</resource></bind>"); err != nil {
+		return fmt.Errorf("error binding resource: %s", err)
+	}
+	if features.Session != nil && features.Session.Optional == nil {
+		if err := c.iq("<session xmlns='urn:ietf:params:xml:ns:xmpp-session'/>"); err != nil {
+			return fmt.Errorf("error establishing session: %s", err)
+		}
+	}
+
+	if err := c.send("<presence/>"); err != nil {
+		return err
+	}
+	for _, room := range c.config.rooms {
+		fields := strings.Fields(room)
+		join := "<x xmlns='http://jabber.org/protocol/muc'><history maxstanzas='0'/>"
+		if len(fields) > 1 {
+			join += "<password>" + xmppEscape(fields[1]) + "</password>"
+		}
+		join += "</x>"
+		if err := c.send(fmt.Sprintf("<presence to='%s/%s'>%s</presence>", xmppEscape(fields[0]), xmppEscape(c.config.roomNick), join)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Opens a new stream, as is done after negotiating TLS and authenticating, and returns
+// the features the server advertises on it
+func (c *xmppConn) openStream() (*xmppFeatures, error) {
+	c.decoder = xml.NewDecoder(c.reader)
+	header := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' "+
+		"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", xmppEscape(c.config.jid.domain))
+	if err := c.send(header); err != nil {
+		return nil, err
+	}
+
+	start, err := c.nextElement()
+	if err != nil {
+		return nil, err
+	}
+	if start.Name.Local != "stream" {
+		return nil, fmt.Errorf("expected a stream from the server, got <%s>", start.Name.Local)
+	}
+	start, err = c.nextElement()
+	if err != nil {
+		return nil, err
+	}
+	if start.Name.Local != "features" {
+		return nil, fmt.Errorf("expected stream features from the server, got <%s>", start.Name.Local)
+	}
+
+	var features xmppFeatures
+	if err := c.decoder.DecodeElement(&features, &start); err != nil {
+		return nil, err
+	}
+	return &features, nil
+}
+
+// Reads up to the start of the next element, failing if the server closed the stream
+func (c *xmppConn) nextElement() (xml.StartElement, error) {
+	for {
+		token, err := c.decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if token.Name.Local == "error" && token.Name.Space == "http://etherx.jabber.org/streams" {
+				var stanza xmppStanza
+				c.decoder.DecodeElement(&stanza.Error, &token)
+				return token, fmt.Errorf("stream error: %s", stanza.errorText())
+			}
+			return token, nil
+		case xml.EndElement:
+			if token.Name.Local == "stream" {
+				return xml.StartElement{}, io.EOF
+			}
+		}
+	}
+}
+
+// Sends an iq set and waits for its result
+func (c *xmppConn) iq(payload string) error {
+	c.nextID++
+	id := "consul-alerting-" + strconv.Itoa(c.nextID)
+	if err := c.send(fmt.Sprintf("<iq type='set' id='%s'>%s</iq>", id, payload)); err != nil {
+		return err
+	}
+
+	for {
+		start, err := c.nextElement()
+		if err != nil {
+			return err
+		}
+		var stanza xmppStanza
+		if err := c.decoder.DecodeElement(&stanza, &start); err != nil {
+			return err
+		}
+		if stanza.XMLName.Local != "iq" || stanza.ID != id {
+			c.handle(&stanza)
+			continue
+		}
+		if stanza.Type == "error" {
+			return fmt.Errorf("%s", stanza.errorText())
+		}
+		return nil
+	}
+}
+
+// Reads the server's stanzas until the connection fails, answering pings (XEP-0199) and
+// logging errors joining rooms or delivering messages
+func (c *xmppConn) read() {
+	for {
+		start, err := c.nextElement()
+		if err == nil {
+			var stanza xmppStanza
+			if err = c.decoder.DecodeElement(&stanza, &start); err == nil {
+				c.handle(&stanza)
+				continue
+			}
+		}
+
+		c.err = err
+		close(c.done)
+		return
+	}
+}
+
+func (c *xmppConn) handle(stanza *xmppStanza) {
+	switch {
+	case stanza.XMLName.Local == "iq" && stanza.Type == "get" && stanza.Ping != nil:
+		c.send(fmt.Sprintf("<iq type='result' id='%s' to='%s'/>", xmppEscape(stanza.ID), xmppEscape(stanza.From)))
+	case stanza.XMLName.Local == "iq" && (stanza.Type == "get" || stanza.Type == "set"):
+		c.send(fmt.Sprintf("<iq type='error' id='%s' to='%s'><error type='cancel'>"+
+			"<service-unavailable xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/></error></iq>",
+			xmppEscape(stanza.ID), xmppEscape(stanza.From)))
+	case stanza.Type == "error" && stanza.XMLName.Local == "presence":
+		log.Errorf("Error joining XMPP room %s: %s", strings.Split(stanza.From, "/")[0], stanza.errorText())
+	case stanza.Type == "error" && stanza.XMLName.Local == "message":
+		log.Errorf("Error sending XMPP message to %s: %s", stanza.From, stanza.errorText())
+	}
+}
+
+// Sends a whitespace keepalive every minute until the connection fails
+func (c *xmppConn) keepalive() {
+	ticker := time.NewTicker(xmppKeepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.send(" ")
+		}
+	}
+}
+
+// Writes raw XML to the server
+func (c *xmppConn) send(data string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(xmppTimeout))
+	_, err := io.WriteString(c.conn, data)
+	return err
+}
+
+// Escapes text for use in XML content or attributes
+func xmppEscape(text string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+// Sends a message to every recipient and room
+func (c *xmppConn) Publish(topic string, key string, payload []byte) error {
+	select {
+	case <-c.done:
+		return fmt.Errorf("connection closed: %s", c.err)
+	default:
+	}
+
+	body := xmppEscape(string(payload))
+	for _, recipient := range c.config.recipients {
+		if err := c.send(fmt.Sprintf("<message to='%s' type='chat'><body>%s</body></message>", xmppEscape(recipient), body)); err != nil {
+			return err
+		}
+	}
+	for _, room := range c.config.rooms {
+		// Only the room JID is the target; the rest is its password
+		target := strings.Fields(room)[0]
+		if err := c.send(fmt.Sprintf("<message to='%s' type='groupchat'><body>%s</body></message>", xmppEscape(target), body)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *xmppConn) Close() error {
+	c.send("</stream:stream>")
+	return c.conn.Close()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A stanza sent to the fake server
+type testXMPPStanza struct {
+	XMLName xml.Name
+	To      string `xml:"to,attr"`
+	Type    string `xml:"type,attr"`
+	ID      string `xml:"id,attr"`
+	Body    string `xml:"body"`
+	Inner   string `xml:",innerxml"`
+}
+
+// A fake XMPP server without STARTTLS, accepting SASL PLAIN for user/secret
+type testXMPPServer struct {
+	listener net.Listener
+	stanzas  chan testXMPPStanza
+}
+
+func newTestXMPPServer(t *testing.T) *testXMPPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testXMPPServer{listener: listener, stanzas: make(chan testXMPPStanza, 20)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (s *testXMPPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	decoder := xml.NewDecoder(conn)
+	authenticated := false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "stream":
+			io.WriteString(conn, "<?xml version='1.0'?><stream:stream from='example.org' id='1' xmlns='jabber:client' "+
+				"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>")
+			if authenticated {
+				io.WriteString(conn, "<stream:features><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'/>"+
+					"<session xmlns='urn:ietf:params:xml:ns:xmpp-session'><optional/></session></stream:features>")
+			} else {
+				io.WriteString(conn, "<stream:features><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>"+
+					"<mechanism>SCRAM-SHA-1</mechanism><mechanism>PLAIN</mechanism></mechanisms></stream:features>")
+			}
+			continue
+		}
+
+		var stanza testXMPPStanza
+		if err := decoder.DecodeElement(&stanza, &start); err != nil {
+			return
+		}
+		switch stanza.XMLName.Local {
+		case "auth":
+			if credentials, _ := base64.StdEncoding.DecodeString(stanza.Inner); string(credentials) == "\x00user\x00secret" {
+				authenticated = true
+				io.WriteString(conn, "<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>")
+			} else {
+				io.WriteString(conn, "<failure xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><not-authorized/></failure>")
+			}
+		case "iq":
+			// Test answering pings by pinging the client before the bind result
+			io.WriteString(conn, "<iq type='get' id='ping1' from='example.org'><ping xmlns='urn:xmpp:ping'/></iq>")
+			fmt.Fprintf(conn, "<iq type='result' id='%s'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'>"+
+				"<jid>user@example.org/consul-alerting</jid></bind></iq>", stanza.ID)
+			s.stanzas <- stanza
+		default:
+			s.stanzas <- stanza
+		}
+	}
+}
+
+// Returns the next stanza sent to the fake server
+func (s *testXMPPServer) next(t *testing.T) testXMPPStanza {
+	select {
+	case stanza := <-s.stanzas:
+		return stanza
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the client")
+		return testXMPPStanza{}
+	}
+}
+
+func TestXMPP_publish(t *testing.T) {
+	server := newTestXMPPServer(t)
+	defer server.listener.Close()
+
+	jid, _ := parseXMPPJID("user@example.org")
+	conn, err := dialXMPP(&xmppConfig{
+		server:         server.listener.Addr().String(),
+		jid:            jid,
+		password:       "secret",
+		recipients:     []string{"oncall@example.org"},
+		rooms:          []string{"ops@conference.example.org roompass"},
+		roomNick:       "alerts",
+		allowPlaintext: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if bind := server.next(t); bind.Type != "set" || !strings.Contains(bind.Inner, "<resource>consul-alerting</resource>") {
+		t.Errorf("expected a bind request, got %+v", bind)
+	}
+	if pong := server.next(t); pong.XMLName.Local != "iq" || pong.ID != "ping1" || pong.Type != "result" {
+		t.Errorf("expected a ping result, got %+v", pong)
+	}
+	if presence := server.next(t); presence.XMLName.Local != "presence" || presence.To != "" {
+		t.Errorf("expected the initial presence, got %+v", presence)
+	}
+	if join := server.next(t); join.To != "ops@conference.example.org/alerts" || !strings.Contains(join.Inner, "<password>roompass</password>") {
+		t.Errorf("expected a room join, got %+v", join)
+	}
+
+	if err := conn.Publish("", "", []byte("[dc1] CRIT: redis <is> critical")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []testXMPPStanza{
+		{To: "oncall@example.org", Type: "chat"},
+		{To: "ops@conference.example.org", Type: "groupchat"},
+	}
+	for _, e := range expected {
+		message := server.next(t)
+		if message.To != e.To || message.Type != e.Type || message.Body != "[dc1] CRIT: redis <is> critical" {
+			t.Errorf("unexpected message: %+v", message)
+		}
+	}
+}
+
+func TestXMPP_authFailure(t *testing.T) {
+	server := newTestXMPPServer(t)
+	defer server.listener.Close()
+
+	jid, _ := parseXMPPJID("user@example.org")
+	config := &xmppConfig{server: server.listener.Addr().String(), jid: jid, password: "wrong", allowPlaintext: true}
+	if _, err := dialXMPP(config); err == nil || err.Error() != "authentication failed" {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+
+	// Logging in without TLS must be allowed explicitly
+	config.password = "secret"
+	config.allowPlaintext = false
+	if _, err := dialXMPP(config); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("expected an error for a server without STARTTLS, got %v", err)
+	}
+}
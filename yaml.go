@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A parser for the subset of YAML used to write config files, converting them to the JSON
+// form of the config: block mappings and sequences, flow sequences and mappings on a line,
+// plain and quoted scalars, literal (|) and folded (>) block scalars and comments. Anchors,
+// aliases, tags and multiple documents aren't supported.
+
+// A mapping, keeping its keys in order so blocks like routes stay in the order written
+type yamlMapping struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *yamlMapping) set(key string, value interface{}, line int) error {
+	if _, ok := m.values[key]; ok {
+		return fmt.Errorf("line %d: duplicate key %q", line, key)
+	}
+	m.keys = append(m.keys, key)
+	m.values[key] = value
+	return nil
+}
+
+// Encodes the mapping as a JSON object, leaving out null values
+func (m *yamlMapping) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	first := true
+	for _, key := range m.keys {
+		value := m.values[key]
+		if value == nil {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type yamlLine struct {
+	number  int
+	indent  int
+	content string
+	raw     string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// Converts a YAML document to JSON
+func yamlToJSON(data []byte) ([]byte, error) {
+	value, err := parseYAML(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := value.(*yamlMapping); !ok {
+		return nil, fmt.Errorf("the document should be a mapping")
+	}
+	return json.Marshal(value)
+}
+
+func parseYAML(document string) (interface{}, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(strings.Replace(document, "\r\n", "\n", -1), "\n") {
+		if strings.HasPrefix(raw, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", i+1)
+		}
+		content := strings.TrimLeft(raw, " ")
+		p.lines = append(p.lines, yamlLine{
+			number:  i + 1,
+			indent:  len(raw) - len(content),
+			content: strings.TrimSpace(stripYAMLComment(content)),
+			raw:     raw,
+		})
+	}
+
+	p.skipBlank()
+	if p.done() {
+		return &yamlMapping{values: make(map[string]interface{})}, nil
+	}
+	value, err := p.parseNode(p.current().indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if !p.done() {
+		return nil, fmt.Errorf("line %d: unexpected content", p.current().number)
+	}
+	return value, nil
+}
+
+func (p *yamlParser) done() bool {
+	return p.pos >= len(p.lines)
+}
+
+func (p *yamlParser) current() *yamlLine {
+	return &p.lines[p.pos]
+}
+
+// Skips blank and comment lines, and document markers
+func (p *yamlParser) skipBlank() {
+	for !p.done() {
+		content := p.current().content
+		if content != "" && !(p.current().indent == 0 && (content == "---" || content == "...")) {
+			return
+		}
+		p.pos++
+	}
+}
+
+// Parses the block node starting at the current line, which is indented by indent
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	line := p.current()
+	switch {
+	case isYAMLSequenceItem(line.content):
+		return p.parseSequence(indent)
+	case yamlKeyEnd(line.content) >= 0:
+		return p.parseMapping(indent)
+	default:
+		p.pos++
+		return parseYAMLInline(line.content, line.number)
+	}
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	mapping := &yamlMapping{values: make(map[string]interface{})}
+	for p.skipBlank(); !p.done(); p.skipBlank() {
+		line := p.current()
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+
+		end := yamlKeyEnd(line.content)
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: expected a key", line.number)
+		}
+		key, err := parseYAMLKey(line.content[:end], line.number)
+		if err != nil {
+			return nil, err
+		}
+		rest := strings.TrimSpace(line.content[end+1:])
+		p.pos++
+
+		value, err := p.parseValue(indent, rest, line.number, true)
+		if err != nil {
+			return nil, err
+		}
+		if err := mapping.set(key, value, line.number); err != nil {
+			return nil, err
+		}
+	}
+	return mapping, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	sequence := make([]interface{}, 0)
+	for p.skipBlank(); !p.done(); p.skipBlank() {
+		line := p.current()
+		if line.indent < indent || !isYAMLSequenceItem(line.content) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+
+		rest := strings.TrimSpace(line.content[1:])
+		if rest != "" && (isYAMLSequenceItem(rest) || yamlKeyEnd(rest) >= 0) && !strings.HasPrefix(rest, "[") && !strings.HasPrefix(rest, "{") {
+			// A mapping or sequence starting on the item's line continues at the column it starts at
+			offset := strings.Index(line.raw, rest)
+			line.indent, line.content = offset, rest
+			item, err := p.parseNode(offset)
+			if err != nil {
+				return nil, err
+			}
+			sequence = append(sequence, item)
+			continue
+		}
+
+		p.pos++
+		item, err := p.parseValue(indent, rest, line.number, false)
+		if err != nil {
+			return nil, err
+		}
+		sequence = append(sequence, item)
+	}
+	return sequence, nil
+}
+
+// Parses the value after a key or sequence item marker: inline, a block scalar, or a
+// nested block on the following lines
+func (p *yamlParser) parseValue(indent int, rest string, number int, inMapping bool) (interface{}, error) {
+	if strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">") {
+		return p.parseBlockScalar(indent, rest, number)
+	}
+	if rest != "" {
+		return parseYAMLInline(rest, number)
+	}
+
+	p.skipBlank()
+	if p.done() {
+		return nil, nil
+	}
+	next := p.current()
+	// A sequence can be a mapping value at the same indentation as its key
+	if next.indent > indent || (inMapping && next.indent == indent && isYAMLSequenceItem(next.content)) {
+		return p.parseNode(next.indent)
+	}
+	return nil, nil
+}
+
+// Parses a literal (|) or folded (>) block scalar, with its chomping indicator
+func (p *yamlParser) parseBlockScalar(indent int, header string, number int) (interface{}, error) {
+	style, chomp := header[0], byte(0)
+	if len(header) > 1 {
+		chomp = header[1]
+	}
+	if len(header) > 2 || (chomp != 0 && chomp != '-' && chomp != '+') {
+		return nil, fmt.Errorf("line %d: unsupported block scalar header %q", number, header)
+	}
+
+	var lines []string
+	blockIndent := -1
+	for !p.done() {
+		line := p.current()
+		if strings.TrimSpace(line.raw) == "" {
+			lines = append(lines, "")
+			p.pos++
+			continue
+		}
+		if line.indent <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = line.indent
+		}
+		if line.indent < blockIndent {
+			return nil, fmt.Errorf("line %d: block scalar lines must be indented at least as much as the first", line.number)
+		}
+		lines = append(lines, line.raw[blockIndent:])
+		p.pos++
+	}
+
+	// Trailing blank lines are only kept with the + indicator
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var text string
+	if style == '|' {
+		text = strings.Join(lines, "\n")
+	} else {
+		for i, line := range lines {
+			switch {
+			case i == 0, lines[i-1] == "":
+			case line == "":
+				text += "\n"
+			default:
+				text += " "
+			}
+			text += line
+		}
+	}
+
+	switch {
+	case len(lines) == 0:
+	case chomp == '-':
+	case chomp == '+':
+		text += "\n" + strings.Repeat("\n", trailing)
+	default:
+		text += "\n"
+	}
+	return text, nil
+}
+
+func isYAMLSequenceItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+// Returns the index of the colon ending a mapping key, or -1 if the content isn't a key
+func yamlKeyEnd(content string) int {
+	quote := byte(0)
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote != 0:
+			if c == quote && !(quote == '"' && i > 0 && content[i-1] == '\\') {
+				quote = 0
+			}
+		case i == 0 && (c == '"' || c == '\''):
+			quote = c
+		case i == 0 && (c == '[' || c == '{'):
+			return -1
+		case c == ':' && (i == len(content)-1 || content[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+func parseYAMLKey(key string, number int) (string, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("line %d: empty key", number)
+	}
+	if key[0] == '"' || key[0] == '\'' {
+		value, err := parseYAMLScalar(key, number)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(value), nil
+	}
+	return key, nil
+}
+
+// Removes a comment from a line, which starts with a # at the start or after a space
+// outside of quotes
+func stripYAMLComment(content string) string {
+	quote := byte(0)
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote != 0:
+			if c == quote && !(quote == '"' && content[i-1] == '\\') {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" [{,:", rune(content[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || content[i-1] == ' '):
+			return content[:i]
+		}
+	}
+	return content
+}
+
+// Parses a value written on one line: a flow sequence or mapping, or a scalar
+func parseYAMLInline(value string, number int) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	switch value[0] {
+	case '[':
+		if value[len(value)-1] != ']' {
+			return nil, fmt.Errorf("line %d: unterminated flow sequence", number)
+		}
+		items, err := splitYAMLFlow(value[1:len(value)-1], number)
+		if err != nil {
+			return nil, err
+		}
+		sequence := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			parsed, err := parseYAMLInline(item, number)
+			if err != nil {
+				return nil, err
+			}
+			sequence = append(sequence, parsed)
+		}
+		return sequence, nil
+
+	case '{':
+		if value[len(value)-1] != '}' {
+			return nil, fmt.Errorf("line %d: unterminated flow mapping", number)
+		}
+		items, err := splitYAMLFlow(value[1:len(value)-1], number)
+		if err != nil {
+			return nil, err
+		}
+		mapping := &yamlMapping{values: make(map[string]interface{})}
+		for _, item := range items {
+			end := yamlKeyEnd(item)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: expected a key in flow mapping: %q", number, item)
+			}
+			key, err := parseYAMLKey(item[:end], number)
+			if err != nil {
+				return nil, err
+			}
+			parsed, err := parseYAMLInline(item[end+1:], number)
+			if err != nil {
+				return nil, err
+			}
+			if err := mapping.set(key, parsed, number); err != nil {
+				return nil, err
+			}
+		}
+		return mapping, nil
+	}
+
+	return parseYAMLScalar(value, number)
+}
+
+// Splits the inside of a flow collection on its top-level commas
+func splitYAMLFlow(value string, number int) ([]string, error) {
+	var items []string
+	depth, quote, start := 0, byte(0), 0
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case quote != 0:
+			if c == quote && !(quote == '"' && value[i-1] == '\\') {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, value[start:i])
+			start = i + 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, fmt.Errorf("line %d: unbalanced flow collection", number)
+	}
+	if last := strings.TrimSpace(value[start:]); last != "" || len(items) > 0 {
+		items = append(items, value[start:])
+	}
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items, nil
+}
+
+func parseYAMLScalar(value string, number int) (interface{}, error) {
+	switch value[0] {
+	case '"':
+		if len(value) < 2 || value[len(value)-1] != '"' {
+			return nil, fmt.Errorf("line %d: unterminated string", number)
+		}
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid string %s: %s", number, value, err)
+		}
+		return s, nil
+	case '\'':
+		if len(value) < 2 || value[len(value)-1] != '\'' {
+			return nil, fmt.Errorf("line %d: unterminated string", number)
+		}
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1), nil
+	case '&', '*', '!':
+		return nil, fmt.Errorf("line %d: anchors, aliases and tags aren't supported", number)
+	}
+
+	switch value {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil && strings.ContainsAny(value, "0123456789") {
+		return f, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAML_parse(t *testing.T) {
+	document := `
+# A comment
+---
+name: "consul # alerting"
+count: 3
+ratio: 0.5
+enabled: true
+missing: ~
+quoted: 'it''s'
+list:
+  - one
+  - 2
+flow: [a, "b, c", {x: 1}]
+empty: {}
+nested:
+  key: value # trailing comment
+items:
+- name: first
+  values: [1, 2]
+- name: second
+literal: |
+  line one
+  line two
+
+folded: >-
+  one
+  two
+
+  three
+last: end
+`
+	value, err := parseYAML(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"name":"consul # alerting","count":3,"ratio":0.5,"enabled":true,"quoted":"it's",` +
+		`"list":["one",2],"flow":["a","b, c",{"x":1}],"empty":{},"nested":{"key":"value"},` +
+		`"items":[{"name":"first","values":[1,2]},{"name":"second"}],` +
+		`"literal":"line one\nline two\n","folded":"one two\nthree","last":"end"}`
+	if string(encoded) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, encoded)
+	}
+}
+
+func TestYAML_errors(t *testing.T) {
+	cases := []struct {
+		document string
+		expected string
+	}{
+		{"a: 1\na: 2\n", `line 2: duplicate key "a"`},
+		{"a:\n\tb: 1\n", "line 2: tabs can't be used for indentation"},
+		{"a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"a: [1, 2\n", "line 1: unterminated flow sequence"},
+		{"a: \"open\n", "line 1: unterminated string"},
+		{"a: &anchor 1\n", "line 1: anchors, aliases and tags aren't supported"},
+		{"- a\n- b\n", "the document should be a mapping"},
+	}
+	for _, c := range cases {
+		_, err := yamlToJSON([]byte(c.document))
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%q: expected error %q, got %v", c.document, c.expected, err)
+		}
+	}
+}
+
+func TestYAML_configFile(t *testing.T) {
+	hclConfig := `
+change_threshold = 30
+default_handlers = ["stdout.log"]
+
+service "redis" {
+  distinct_tags = true
+  ignored_tags = ["master"]
+}
+
+handler "stdout" "log" {
+  log_level = "warn"
+}
+
+handler "email" "admin" {
+  recipients = ["admin@example.com"]
+}
+
+route "payments" {
+  service = "payments(-.*)?"
+  handlers = ["email.admin"]
+}
+
+route "batch" {
+  tags = ["batch"]
+  handlers = ["stdout.log"]
+}
+`
+	yamlConfig := `
+change_threshold: 30
+default_handlers: [stdout.log]
+
+service:
+  redis:
+    distinct_tags: true
+    ignored_tags:
+      - master
+
+handler:
+  stdout:
+    log:
+      log_level: warn
+  email:
+    admin:
+      recipients: ["admin@example.com"]
+
+route:
+  payments:
+    service: "payments(-.*)?"
+    handlers: [email.admin]
+  batch:
+    tags: [batch]
+    handlers: [stdout.log]
+`
+	jsonConfig := `{
+  "change_threshold": 30,
+  "default_handlers": ["stdout.log"],
+  "service": {"redis": {"distinct_tags": true, "ignored_tags": ["master"]}},
+  "handler": {
+    "stdout": {"log": {"log_level": "warn"}},
+    "email": {"admin": {"recipients": ["admin@example.com"]}}
+  },
+  "route": {
+    "payments": {"service": "payments(-.*)?", "handlers": ["email.admin"]},
+    "batch": {"tags": ["batch"], "handlers": ["stdout.log"]}
+  }
+}`
+
+	dir := t.TempDir()
+	load := func(name string, config string) *Config {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseConfigFile(path)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		return parsed
+	}
+
+	expected := load("config.hcl", hclConfig)
+	for name, config := range map[string]string{"config.yaml": yamlConfig, "config.yml": yamlConfig, "config.json": jsonConfig} {
+		parsed := load(name, config)
+		if !reflect.DeepEqual(snapshotConfig(parsed), snapshotConfig(expected)) {
+			t.Errorf("%s: expected %+v, got %+v", name, snapshotConfig(expected), snapshotConfig(parsed))
+		}
+		if len(parsed.Routes) != 2 || parsed.Routes[0].Name != "payments" || parsed.Routes[1].Name != "batch" {
+			t.Errorf("%s: expected the routes in order, got %+v", name, parsed.Routes)
+		}
+	}
+}